@@ -19,9 +19,11 @@ import (
 	"context"
 	"log"
 	"runtime"
+	"sync"
 
 	lua "github.com/hootrhino/gopher-lua"
 	"github.com/hootrhino/rhilex/typex"
+	"github.com/robfig/cron/v3"
 )
 
 // lua 虚拟机的参数
@@ -30,23 +32,67 @@ const p_VM_Registry_MaxSize int = 1024 * 1024 // 默认最大堆栈
 const p_VM_Registry_GrowStep int = 32         // 默认CPU消耗
 type AppState int
 
+const (
+	APP_STOPPED AppState = 0
+	APP_RUNNING AppState = 1
+	APP_WAITING AppState = 2 // 等下一次定时执行, 或者等重启
+)
+
+// 重启策略
+const (
+	RESTART_NEVER      = "never"
+	RESTART_ON_FAILURE = "on-failure"
+	RESTART_ALWAYS     = "always"
+)
+
+/*
+*
+* 资源配额, 0 表示不限制或者用默认值
+*
+ */
+type AppQuota struct {
+	MaxRegistrySize int   `json:"maxRegistrySize"` // Lua 栈的最大槽位数, 不限制表和字符串占用的内存
+	MaxInstructions int64 `json:"maxInstructions"` // 每次运行最多执行的指令数
+	MaxRunTime      int   `json:"maxRunTime"`      // 每次运行最长时间(秒)
+	MailboxSize     int   `json:"mailboxSize"`     // 邮箱容量, 默认 64
+}
+
+// 运行状态
+type AppStatus struct {
+	Runs      int    `json:"runs"`      // 执行了几次 Main
+	Restarts  int    `json:"restarts"`  // 连续重启的次数, 稳定运行一段时间以后清零
+	LastStart int64  `json:"lastStart"` // 毫秒
+	LastExit  int64  `json:"lastExit"`  // 毫秒
+	LastError string `json:"lastError"`
+	NextRun   int64  `json:"nextRun"` // 下一次定时执行或者重启的时间
+	KilledBy  string `json:"killedBy"`
+}
+
 /*
 *
 * 轻量级应用
 *
  */
 type Application struct {
-	UUID        string             `json:"uuid"`        // 名称
-	Name        string             `json:"name"`        // 名称
-	Version     string             `json:"version"`     // 版本号
-	Description string             `json:"description"` // 版本号
-	AutoStart   bool               `json:"autoStart"`   // 自动启动
-	AppState    AppState           `json:"appState"`    // 状态: 1 运行中, 0 停止
-	KilledBy    string             `json:"-"`           // 被谁杀死的: RHILEX|EXCEPT|NORMAL|""
-	luaMainFunc *lua.LFunction     `json:"-"`           // Main
-	vm          *lua.LState        `json:"-"`           // lua 环境
-	ctx         context.Context    `json:"-"`           // context
-	cancel      context.CancelFunc `json:"-"`           // Cancel
+	UUID          string             `json:"uuid"`          // 名称
+	Name          string             `json:"name"`          // 名称
+	Version       string             `json:"version"`       // 版本号
+	Description   string             `json:"description"`   // 版本号
+	AutoStart     bool               `json:"autoStart"`     // 自动启动
+	AppState      AppState           `json:"appState"`      // 状态: 1 运行中, 0 停止, 2 等待
+	KilledBy      string             `json:"-"`             // 被谁杀死的: RHILEX|EXCEPT|QUOTA|NORMAL|""
+	RestartPolicy string             `json:"restartPolicy"` // never|on-failure|always, 为空的时候自启动的应用出错重启
+	Schedule      string             `json:"schedule"`      // 定时执行的 cron 表达式, 为空启动以后一直运行
+	Quota         AppQuota           `json:"quota"`         // 资源配额
+	Config        map[string]any     `json:"config"`        // 持久化的配置, Lua 里是 AppConfig 表
+	Files         map[string]string  `json:"-"`             // 应用包里入口以外的 Lua 文件, 模块名 -> 源码
+	status        AppStatus          `json:"-"`             // 运行状态
+	statusLock    sync.Mutex         `json:"-"`             //
+	cronEntry     cron.EntryID       `json:"-"`             // 定时任务
+	luaMainFunc   *lua.LFunction     `json:"-"`             // Main
+	vm            *lua.LState        `json:"-"`             // lua 环境
+	ctx           context.Context    `json:"-"`             // context
+	cancel        context.CancelFunc `json:"-"`             // Cancel
 }
 
 func NewApplication(uuid, Name, Version string) *Application {
@@ -55,12 +101,46 @@ func NewApplication(uuid, Name, Version string) *Application {
 	app.UUID = uuid
 	app.Version = Version
 	app.KilledBy = "NORMAL"
-	app.vm = lua.NewState(lua.Options{
-		RegistrySize:     p_VM_Registry_Size,
-		RegistryMaxSize:  p_VM_Registry_MaxSize,
+	app.Config = map[string]any{}
+	app.vm = newAppVM(AppQuota{})
+	return app
+}
+
+func newAppVM(quota AppQuota) *lua.LState {
+	registryMaxSize := p_VM_Registry_MaxSize
+	if quota.MaxRegistrySize > 0 {
+		registryMaxSize = quota.MaxRegistrySize
+	}
+	return lua.NewState(lua.Options{
+		RegistrySize:     min(p_VM_Registry_Size, registryMaxSize),
+		RegistryMaxSize:  registryMaxSize,
 		RegistryGrowStep: p_VM_Registry_GrowStep,
 	})
-	return app
+}
+
+// 实际生效的重启策略: 没有配置的时候和以前一样, 自启动的应用出错以后重启
+func (app *Application) restartPolicy() string {
+	if app.RestartPolicy != "" {
+		return app.RestartPolicy
+	}
+	if app.AutoStart {
+		return RESTART_ON_FAILURE
+	}
+	return RESTART_NEVER
+}
+
+func (app *Application) Status() AppStatus {
+	app.statusLock.Lock()
+	defer app.statusLock.Unlock()
+	status := app.status
+	status.KilledBy = app.KilledBy
+	return status
+}
+
+func (app *Application) updateStatus(f func(status *AppStatus)) {
+	app.statusLock.Lock()
+	defer app.statusLock.Unlock()
+	f(&app.status)
 }
 
 func (app *Application) SetCnC(ctx context.Context, cancel context.CancelFunc) {
@@ -91,7 +171,8 @@ func (app *Application) Stop() {
 			log.Println("[gopher-lua] app Stop:", app.UUID, ", with recover error: ", err)
 		}
 	}()
-	app.AppState = 0
+	app.AppState = APP_STOPPED
+	app.KilledBy = "RHILEX"
 	if app.cancel != nil {
 		app.cancel()
 	}
@@ -118,7 +199,7 @@ type XApplet interface {
 	LoadApp(app *Application) error
 	GetApp(uuid string) *Application
 	RemoveApp(uuid string) error
-	UpdateApp(app Application) error
+	UpdateApp(app *Application) error
 	StartApp(uuid string) error
 	StopApp(uuid string) error
 	Stop()
@@ -16,31 +16,75 @@
 package applet
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/luamodule"
 	"github.com/hootrhino/rhilex/component/luaruntime"
+	"github.com/hootrhino/rhilex/component/mailbox"
+	"github.com/hootrhino/rhilex/component/streamwindow"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
+	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 )
 
 var __DefaultAppletRuntime *AppletRuntime
 
+// 定时表达式支持可选的秒字段和 @every 之类的描述符
+var __ScheduleParser = cron.NewParser(cron.SecondOptional | cron.Minute |
+	cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// 重启退避: 从 1 秒开始翻倍, 最长 1 分钟; 稳定运行 1 分钟以后重新计数
+var (
+	__RestartBackoffMin = 1 * time.Second
+	__RestartBackoffMax = 60 * time.Second
+	__RestartStableTime = 60 * time.Second
+)
+
 func InitAppletRuntime(re typex.Rhilex) *AppletRuntime {
 	__DefaultAppletRuntime = &AppletRuntime{
 		RuleEngine:   re,
 		locker:       sync.Mutex{},
 		Applications: make(map[string]*Application),
+		cron:         cron.New(cron.WithParser(__ScheduleParser)),
 	}
+	__DefaultAppletRuntime.cron.Start()
 	return __DefaultAppletRuntime
 }
 func AppRuntime() *AppletRuntime {
 	return __DefaultAppletRuntime
 }
 
+/*
+*
+* 检查重启策略, 定时表达式和配额
+*
+ */
+func ValidateLifecycle(restartPolicy, schedule string, quota AppQuota) error {
+	switch restartPolicy {
+	case "", RESTART_NEVER, RESTART_ON_FAILURE, RESTART_ALWAYS:
+	default:
+		return fmt.Errorf("invalid restart policy: %s", restartPolicy)
+	}
+	if schedule != "" {
+		if _, err := __ScheduleParser.Parse(schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %s", err)
+		}
+	}
+	if quota.MaxRegistrySize < 0 || quota.MaxInstructions < 0 ||
+		quota.MaxRunTime < 0 || quota.MailboxSize < 0 {
+		return fmt.Errorf("quota must not be negative")
+	}
+	return nil
+}
+
 /*
 *
 * 加载本地文件到lua虚拟机, 但是并不执行
@@ -49,7 +93,16 @@ func AppRuntime() *AppletRuntime {
 func LoadApp(app *Application, luaSource string) error {
 	__DefaultAppletRuntime.locker.Lock()
 	defer __DefaultAppletRuntime.locker.Unlock()
-	// 重新读
+	if app.Quota.MaxRegistrySize > 0 {
+		app.vm.Close()
+		app.vm = newAppVM(app.Quota)
+	}
+	// 先建邮箱, 脚本顶层就可以收发
+	mailbox.Register(app.UUID, app.Quota.MailboxSize)
+	app.setAppConfig()
+	// 重新读, 脚本里可能 require 用户模块
+	luamodule.Install(app.VM(), luamodule.SCOPE_APPLET, app.UUID)
+	installBundleLoader(app.VM(), app.Files)
 	app.VM().DoString(string(luaSource))
 	// 检查函数入口
 	AppMainVM := app.VM().GetGlobal("Main")
@@ -70,9 +123,54 @@ func LoadApp(app *Application, luaSource string) error {
 	return nil
 }
 
+/*
+*
+* 应用包里的 Lua 文件, 排在用户模块前面; 同名的时候用包里自带的
+*
+ */
+func installBundleLoader(L *lua.LState, files map[string]string) {
+	if len(files) == 0 {
+		return
+	}
+	registry := L.Get(lua.RegistryIndex)
+	if L.GetField(registry, "_RHILEX_BUNDLE") != lua.LNil {
+		return
+	}
+	loaders, ok := L.GetField(registry, "_LOADERS").(*lua.LTable)
+	if !ok {
+		return
+	}
+	L.SetField(registry, "_RHILEX_BUNDLE", lua.LTrue)
+	loaders.Insert(2, L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		source, ok := files[name]
+		if !ok {
+			L.Push(lua.LString(fmt.Sprintf("no bundle file '%s'", name)))
+			return 1
+		}
+		fn, err := L.Load(strings.NewReader(source), "bundle:"+name)
+		if err != nil {
+			L.Push(lua.LString(fmt.Sprintf("load bundle file '%s' failed: %s", name, err)))
+			return 1
+		}
+		L.Push(fn)
+		return 1
+	}))
+}
+
+// 把配置放进全局的 AppConfig 表
+func (app *Application) setAppConfig() {
+	config := map[string]any{}
+	for k, v := range app.Config {
+		config[k] = v
+	}
+	app.vm.SetGlobal("AppConfig", typex.ToLValue(app.vm, config))
+}
+
 /*
 * 此时才是真正的启动入口:
 * 启动 function Main(args) --do-some-thing-- return 0 end
+* 有定时表达式的应用只是挂到定时器上, 到点才执行
 *
  */
 func StartApp(uuid string) error {
@@ -86,93 +184,230 @@ func StartAppWithArgs(uuid string, args ...lua.LValue) error {
 	if !ok {
 		return fmt.Errorf("Application not exists:%s", uuid)
 	}
-	if app.AppState == 1 {
+	if app.AppState != APP_STOPPED {
 		return fmt.Errorf("Application already started:%s", uuid)
 	}
 	ctx, cancel := typex.NewCCTX()
 	app.SetCnC(ctx, cancel)
-	go func(app *Application) {
-		defer func() {
-			glogger.GLogger.Debug("Application exit:", app.UUID)
-			app.VM().Pop(1) // 防止registry溢出
-			app.AppState = 0
-		}()
-		glogger.GLogger.Debugf("Ready to run Application:%s", app.UUID)
-		app.AppState = 1
-		err := app.VM().CallByParam(lua.P{
-			Fn:      app.GetMainFunc(),
-			NRet:    1,
-			Protect: true,
-			Handler: &lua.LFunction{
-				GFunction: func(*lua.LState) int {
-					glogger.GLogger.Debug("Protect Mode Call")
-					return 0
-				},
-			},
-		}, args...)
-		if err == nil {
-			if app.KilledBy == "RHILEX" {
-				glogger.GLogger.Infof("Application %s Killed By RHILEX", app.UUID)
-			}
-			if app.KilledBy == "NORMAL" || app.KilledBy == "" {
-				glogger.GLogger.Infof("Application %s NORMAL Exited", app.UUID)
-			}
+	app.KilledBy = ""
+	app.updateStatus(func(status *AppStatus) { status.Restarts = 0 })
+	if app.Schedule != "" {
+		entryId, err := __DefaultAppletRuntime.cron.AddFunc(app.Schedule, scheduledRun(ctx, app, args))
+		if err != nil {
+			cancel()
+			return fmt.Errorf("invalid schedule: %s", err)
+		}
+		app.cronEntry = entryId
+		app.AppState = APP_WAITING
+		app.updateNextRun()
+		glogger.GLogger.Info("App scheduled:", app.UUID, ", ", app.Schedule)
+		return nil
+	}
+	app.AppState = APP_RUNNING
+	go supervise(ctx, app, false, args)
+	glogger.GLogger.Info("App started:", app.UUID)
+	return nil
+}
+
+// 定时执行一次, 上一次还没跑完就跳过这一次
+func scheduledRun(ctx context.Context, app *Application, args []lua.LValue) func() {
+	var running atomic.Bool
+	return func() {
+		if !running.CompareAndSwap(false, true) {
+			glogger.GLogger.Warnf("App %s still running, skip scheduled run", app.UUID)
 			return
 		}
-		Debugger, Ok := app.vm.GetStack(1)
-		if Ok {
-			LValue, _ := app.vm.GetInfo("f", Debugger, lua.LNil)
-			app.vm.GetInfo("l", Debugger, lua.LNil)
-			app.vm.GetInfo("S", Debugger, lua.LNil)
-			app.vm.GetInfo("u", Debugger, lua.LNil)
-			app.vm.GetInfo("n", Debugger, lua.LNil)
-			LFunction := LValue.(*lua.LFunction)
-			LastCall := lua.DbgCall{
-				Name: "_main",
-			}
-			if len(LFunction.Proto.DbgCalls) > 0 {
-				LastCall = LFunction.Proto.DbgCalls[0]
-			}
-			glogger.GLogger.WithFields(logrus.Fields{
-				"topic": "app/console/" + uuid,
-			}).Warnf("Function Name: [%s],"+
-				"What: [%s], Source Line: [%d],"+
-				" Last Call: [%s], Error message: %s",
-				Debugger.Name, Debugger.What, Debugger.CurrentLine,
-				LastCall.Name, err.Error(),
-			)
+		defer running.Store(false)
+		supervise(ctx, app, true, args)
+	}
+}
+
+// 定时执行的应用: 下一次执行时间
+func (app *Application) updateNextRun() {
+	next := __DefaultAppletRuntime.cron.Entry(app.cronEntry).Next
+	app.updateStatus(func(status *AppStatus) {
+		status.NextRun = 0
+		if !next.IsZero() {
+			status.NextRun = next.UnixMilli()
 		}
-		//
-		// 检查是自己死的还是被RHILEX杀死
-		// 1 正常结束
-		// 2 被rhilex删除
-		// 3 跑飞了
+	})
+}
+
+// 从定时器上摘下来
+func unschedule(app *Application) {
+	if app.cronEntry != 0 {
+		__DefaultAppletRuntime.cron.Remove(app.cronEntry)
+		app.cronEntry = 0
+	}
+}
 
-		// 中间出现异常挂了，此时要根据: auto start 来判断是否抢救
-		time.Sleep(5 * time.Second)
-		if app.KilledBy == "RHILEX" {
-			glogger.GLogger.Infof("App %s Killed By RHILEX, No need to rescue", app.UUID)
+/*
+*
+* 守护: 执行 Main, 然后根据重启策略决定要不要重启;
+* 定时执行的应用 always 不会重启, 等下一次定时
+*
+ */
+func supervise(ctx context.Context, app *Application, scheduled bool, args []lua.LValue) {
+	for {
+		begin := time.Now()
+		failed := runMain(ctx, app, args)
+		if ctx.Err() != nil {
+			glogger.GLogger.Infof("Application %s Killed By RHILEX", app.UUID)
 			return
 		}
-		if app.KilledBy == "NORMAL" {
-			glogger.GLogger.Infof("App %s NORMAL Exited, No need to rescue", app.UUID)
+		policy := app.restartPolicy()
+		restart := (failed && policy != RESTART_NEVER) ||
+			(!scheduled && policy == RESTART_ALWAYS)
+		if !restart {
+			break
+		}
+		delay := app.nextBackoff(time.Since(begin))
+		app.AppState = APP_WAITING
+		app.updateStatus(func(status *AppStatus) {
+			status.NextRun = time.Now().Add(delay).UnixMilli()
+		})
+		glogger.GLogger.Warnf("App %s Try to restart after %v", app.UUID, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
 			return
 		}
+	}
+	if scheduled {
+		app.AppState = APP_WAITING
+		app.updateNextRun()
+		return
+	}
+	app.AppState = APP_STOPPED
+	app.updateStatus(func(status *AppStatus) { status.NextRun = 0 })
+	glogger.GLogger.Infof("App %s not need to restart", app.UUID)
+}
 
-		glogger.GLogger.Warnf("App %s Exited With error: %s, Maybe accident, Try to survive",
-			app.UUID, err.Error())
-		// TODO 到底要不要设置一个尝试重启的阈值？
-		// if tryTimes >= Max -> return
-		if app.AutoStart {
-			glogger.GLogger.Warnf("App %s Try to restart", app.UUID)
-			go StartApp(uuid)
-			return
+// 退避时间, 稳定运行过一段时间的话从头算
+func (app *Application) nextBackoff(ranFor time.Duration) time.Duration {
+	var restarts int
+	app.updateStatus(func(status *AppStatus) {
+		if ranFor >= __RestartStableTime {
+			status.Restarts = 0
 		}
-		glogger.GLogger.Infof("App %s not need to restart", app.UUID)
+		status.Restarts++
+		restarts = status.Restarts
+	})
+	delay := __RestartBackoffMax
+	if restarts <= 6 {
+		delay = min(__RestartBackoffMin<<(restarts-1), __RestartBackoffMax)
+	}
+	return delay
+}
 
-	}(app)
-	glogger.GLogger.Info("App started:", app.UUID)
-	return nil
+/*
+*
+* 执行一次 Main, 返回是否失败: 出错, 超过配额, 或者 Main 返回 false/非 0
+*
+ */
+func runMain(ctx context.Context, app *Application, args []lua.LValue) (failed bool) {
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if app.Quota.MaxRunTime > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, time.Duration(app.Quota.MaxRunTime)*time.Second)
+	}
+	defer cancel()
+	exceeded := func() bool { return false }
+	if app.Quota.MaxInstructions > 0 {
+		runCtx, exceeded = luaexecutor.WithInstructionLimit(runCtx, app.Quota.MaxInstructions)
+	}
+	glogger.GLogger.Debugf("Ready to run Application:%s", app.UUID)
+	app.AppState = APP_RUNNING
+	app.updateStatus(func(status *AppStatus) {
+		status.Runs++
+		status.LastStart = time.Now().UnixMilli()
+		status.NextRun = 0
+	})
+	app.vm.SetContext(runCtx)
+	app.setAppConfig()
+	top := app.vm.GetTop()
+	err := app.vm.CallByParam(lua.P{
+		Fn:      app.GetMainFunc(),
+		NRet:    1,
+		Protect: true,
+		Handler: &lua.LFunction{
+			GFunction: func(*lua.LState) int {
+				glogger.GLogger.Debug("Protect Mode Call")
+				return 0
+			},
+		},
+	}, args...)
+	var ret lua.LValue = lua.LNil
+	if app.vm.GetTop() > top {
+		ret = app.vm.Get(-1)
+	}
+	app.vm.SetTop(top) // 防止registry溢出
+	// 检查是自己死的还是被RHILEX杀死
+	switch {
+	case ctx.Err() != nil:
+		app.KilledBy = "RHILEX"
+	case exceeded():
+		app.KilledBy = "QUOTA"
+		err = fmt.Errorf("instruction quota exceeded: %d", app.Quota.MaxInstructions)
+	case runCtx.Err() != nil:
+		app.KilledBy = "QUOTA"
+		err = fmt.Errorf("run time quota exceeded: %ds", app.Quota.MaxRunTime)
+	case err != nil:
+		app.KilledBy = "EXCEPT"
+		logAppError(app, err)
+	case ret == lua.LFalse:
+		app.KilledBy = "EXCEPT"
+		err = fmt.Errorf("Main returned false")
+	case ret.Type() == lua.LTNumber && ret.(lua.LNumber) != 0:
+		app.KilledBy = "EXCEPT"
+		err = fmt.Errorf("Main returned %s", ret.String())
+	default:
+		app.KilledBy = "NORMAL"
+	}
+	app.updateStatus(func(status *AppStatus) {
+		status.LastExit = time.Now().UnixMilli()
+		status.LastError = ""
+		if err != nil {
+			status.LastError = err.Error()
+		}
+	})
+	if app.KilledBy == "NORMAL" {
+		glogger.GLogger.Infof("Application %s NORMAL Exited", app.UUID)
+	}
+	if app.KilledBy == "EXCEPT" || app.KilledBy == "QUOTA" {
+		glogger.GLogger.WithFields(logrus.Fields{
+			"topic": "app/console/" + app.UUID,
+		}).Warnf("App %s Exited With error: %s", app.UUID, err)
+		return true
+	}
+	return false
+}
+
+// 打印出错的位置
+func logAppError(app *Application, err error) {
+	Debugger, Ok := app.vm.GetStack(1)
+	if !Ok {
+		return
+	}
+	LValue, _ := app.vm.GetInfo("f", Debugger, lua.LNil)
+	app.vm.GetInfo("l", Debugger, lua.LNil)
+	app.vm.GetInfo("S", Debugger, lua.LNil)
+	app.vm.GetInfo("u", Debugger, lua.LNil)
+	app.vm.GetInfo("n", Debugger, lua.LNil)
+	LastCall := lua.DbgCall{
+		Name: "_main",
+	}
+	if LFunction, ok := LValue.(*lua.LFunction); ok && LFunction.Proto != nil &&
+		len(LFunction.Proto.DbgCalls) > 0 {
+		LastCall = LFunction.Proto.DbgCalls[0]
+	}
+	glogger.GLogger.WithFields(logrus.Fields{
+		"topic": "app/console/" + app.UUID,
+	}).Warnf("Function Name: [%s],"+
+		"What: [%s], Source Line: [%d],"+
+		" Last Call: [%s], Error message: %s",
+		Debugger.Name, Debugger.What, Debugger.CurrentLine,
+		LastCall.Name, err.Error(),
+	)
 }
 
 /*
@@ -184,9 +419,13 @@ func RemoveApp(uuid string) error {
 	__DefaultAppletRuntime.locker.Lock()
 	defer __DefaultAppletRuntime.locker.Unlock()
 	if app, ok := __DefaultAppletRuntime.Applications[uuid]; ok {
+		unschedule(app)
 		app.Remove()
 		delete(__DefaultAppletRuntime.Applications, uuid)
 	}
+	mailbox.Unregister(uuid)
+	luamodule.Forget(luamodule.SCOPE_APPLET, uuid)
+	streamwindow.Release(uuid)
 	glogger.GLogger.Info("App removed:", uuid)
 	return nil
 }
@@ -200,9 +439,11 @@ func StopApp(uuid string) error {
 	__DefaultAppletRuntime.locker.Lock()
 	defer __DefaultAppletRuntime.locker.Unlock()
 	if app, ok := __DefaultAppletRuntime.Applications[uuid]; ok {
+		unschedule(app)
 		app.Stop()
+		app.updateStatus(func(status *AppStatus) { status.NextRun = 0 })
 	}
-	glogger.GLogger.Info("App removed:", uuid)
+	glogger.GLogger.Info("App stopped:", uuid)
 	return nil
 }
 
@@ -211,7 +452,7 @@ func StopApp(uuid string) error {
 * 更新应用信息
 *
  */
-func UpdateApp(app Application) error {
+func UpdateApp(app *Application) error {
 	__DefaultAppletRuntime.locker.Lock()
 	defer __DefaultAppletRuntime.locker.Unlock()
 	if oldApp, ok := __DefaultAppletRuntime.Applications[app.UUID]; ok {
@@ -255,9 +496,11 @@ func Stop() {
 	defer __DefaultAppletRuntime.locker.Unlock()
 	for _, app := range __DefaultAppletRuntime.Applications {
 		glogger.GLogger.Info("Stop App:", app.UUID)
+		unschedule(app)
 		app.Stop()
 		glogger.GLogger.Info("Stop App:", app.UUID, " Successfully")
 	}
+	__DefaultAppletRuntime.cron.Stop()
 	glogger.GLogger.Info("applet stopped")
 
 }
@@ -0,0 +1,191 @@
+// Copyright (C) 2023 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package applet
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/sirupsen/logrus"
+)
+
+// 不走 LoadApp, 只加载脚本和 Main, 重启等待缩短到毫秒
+func newTestApp(t *testing.T, source string) *Application {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	if __DefaultAppletRuntime == nil { // 定时执行的应用要用定时器
+		InitAppletRuntime(nil)
+	}
+	min, max, stable := __RestartBackoffMin, __RestartBackoffMax, __RestartStableTime
+	__RestartBackoffMin, __RestartBackoffMax, __RestartStableTime = time.Millisecond, 4*time.Millisecond, time.Hour
+	t.Cleanup(func() {
+		__RestartBackoffMin, __RestartBackoffMax, __RestartStableTime = min, max, stable
+	})
+	app := NewApplication("APP_TEST", "test", "1.0.0")
+	t.Cleanup(func() { app.vm.Close() })
+	if err := app.vm.DoString(source); err != nil {
+		t.Fatal(err)
+	}
+	app.SetMainFunc(app.vm.GetGlobal("Main").(*lua.LFunction))
+	return app
+}
+
+// 前两次 Main 返回 false, 以后返回 0
+const failTwiceSource = `
+runs = 0
+function Main(arg)
+	runs = runs + 1
+	if runs <= 2 then return false end
+	return 0
+end`
+
+// go test -timeout 30s -run ^Test_Applet_RestartPolicy github.com/hootrhino/rhilex/applet -v -count=1
+func Test_Applet_RestartPolicy(t *testing.T) {
+	for _, c := range []struct {
+		policy string
+		runs   int
+	}{
+		{RESTART_NEVER, 1},
+		{RESTART_ON_FAILURE, 3}, // 失败两次重启两次, 第三次正常退出不再重启
+	} {
+		app := newTestApp(t, failTwiceSource)
+		app.RestartPolicy = c.policy
+		supervise(context.Background(), app, false, nil)
+		status := app.Status()
+		if status.Runs != c.runs || app.AppState != APP_STOPPED {
+			t.Fatal(c.policy, "unexpected runs:", status.Runs, app.AppState)
+		}
+	}
+	// 没有配置的时候自启动的应用按 on-failure
+	app := newTestApp(t, failTwiceSource)
+	app.AutoStart = true
+	supervise(context.Background(), app, false, nil)
+	if status := app.Status(); status.Runs != 3 || status.KilledBy != "NORMAL" {
+		t.Fatal("unexpected status:", status)
+	}
+	// always 正常退出也重启, 一直到被停止
+	app = newTestApp(t, `function Main(arg) return 0 end`)
+	app.RestartPolicy = RESTART_ALWAYS
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		supervise(ctx, app, false, nil)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for app.Status().Runs < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if app.Status().Runs < 5 {
+		t.Fatal("always should keep restarting:", app.Status().Runs)
+	}
+	// 定时执行的应用 always 不重启, 等下一次定时
+	app = newTestApp(t, `function Main(arg) return 0 end`)
+	app.RestartPolicy = RESTART_ALWAYS
+	supervise(context.Background(), app, true, nil)
+	if app.Status().Runs != 1 || app.AppState != APP_WAITING {
+		t.Fatal("scheduled app should wait next run:", app.Status().Runs, app.AppState)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Applet_Backoff github.com/hootrhino/rhilex/applet -v -count=1
+func Test_Applet_Backoff(t *testing.T) {
+	app := NewApplication("APP_TEST", "test", "1.0.0")
+	defer app.vm.Close()
+	expect := []time.Duration{1, 2, 4, 8, 16, 32, 60, 60}
+	for i, seconds := range expect {
+		if delay := app.nextBackoff(time.Second); delay != seconds*time.Second {
+			t.Fatal("unexpected backoff", i, delay)
+		}
+	}
+	// 稳定运行过以后从头算
+	if delay := app.nextBackoff(__RestartStableTime); delay != __RestartBackoffMin {
+		t.Fatal("backoff should reset:", delay)
+	}
+	if delay := app.nextBackoff(time.Second); delay != 2*time.Second {
+		t.Fatal("unexpected backoff", delay)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Applet_ScheduleOverlap github.com/hootrhino/rhilex/applet -v -count=1
+func Test_Applet_ScheduleOverlap(t *testing.T) {
+	app := newTestApp(t, `function Main(arg) block() return 0 end`)
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	app.vm.SetGlobal("block", app.vm.NewFunction(func(*lua.LState) int {
+		started <- struct{}{}
+		<-release
+		return 0
+	}))
+	run := scheduledRun(context.Background(), app, nil)
+	done := make(chan struct{})
+	go func() {
+		run()
+		close(done)
+	}()
+	<-started
+	// 上一次还没跑完, 这一次直接跳过, 不会并发用同一个虚拟机
+	run()
+	if runs := app.Status().Runs; runs != 1 {
+		t.Fatal("overlapped run should be skipped:", runs)
+	}
+	close(release)
+	<-done
+	run()
+	if runs := app.Status().Runs; runs != 2 || app.AppState != APP_WAITING {
+		t.Fatal("next run should execute:", runs, app.AppState)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Applet_Quota github.com/hootrhino/rhilex/applet -v -count=1
+func Test_Applet_Quota(t *testing.T) {
+	for _, c := range []struct {
+		quota AppQuota
+		err   string
+	}{
+		{AppQuota{MaxInstructions: 10000}, "instruction quota exceeded"},
+		{AppQuota{MaxRunTime: 1}, "run time quota exceeded"},
+	} {
+		app := newTestApp(t, `function Main(arg) while true do end return 0 end`)
+		app.Quota = c.quota
+		start := time.Now()
+		if failed := runMain(context.Background(), app, nil); !failed {
+			t.Fatal("quota should kill the app")
+		}
+		status := app.Status()
+		if status.KilledBy != "QUOTA" || !strings.Contains(status.LastError, c.err) {
+			t.Fatal("unexpected status:", status)
+		}
+		if time.Since(start) > 3*time.Second {
+			t.Fatal("quota kill took too long")
+		}
+	}
+	// 被 RHILEX 停止的不算失败
+	app := newTestApp(t, `function Main(arg) while true do end return 0 end`)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if failed := runMain(ctx, app, nil); failed || app.KilledBy != "RHILEX" {
+		t.Fatal("stopped app should not fail:", app.KilledBy)
+	}
+	if err := ValidateLifecycle(RESTART_ALWAYS, "@every 10s", AppQuota{MaxRunTime: -1}); err == nil {
+		t.Fatal("negative quota should fail")
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"sync"
 
 	"github.com/hootrhino/rhilex/typex"
+	"github.com/robfig/cron/v3"
 )
 
 /*
@@ -30,4 +31,5 @@ type AppletRuntime struct {
 	locker       sync.Mutex
 	RuleEngine   typex.Rhilex
 	Applications map[string]*Application
+	cron         *cron.Cron // 定时执行的应用共用一个定时器
 }
@@ -17,7 +17,9 @@ package cecolla
 import (
 	"fmt"
 
+	"github.com/hootrhino/rhilex/cecolla/iothub"
 	"github.com/hootrhino/rhilex/cecolla/ithings"
+	"github.com/hootrhino/rhilex/cecolla/thingsboard"
 	"github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/xmanager"
 	"github.com/hootrhino/rhilex/glogger"
@@ -44,6 +46,8 @@ func InitCecollaRuntime(Rhilex typex.Rhilex) {
 	}
 
 	__DefaultCecollaResourceManager.CecollaResourceManager.RegisterType("ITHINGS_IOTHUB", ithings.NewIthingsResource)
+	__DefaultCecollaResourceManager.CecollaResourceManager.RegisterType("THINGSBOARD_GATEWAY", thingsboard.NewThingsBoardResource)
+	__DefaultCecollaResourceManager.CecollaResourceManager.RegisterType("GENERIC_IOTHUB", iothub.NewIotHubResource)
 	__DefaultCecollaResourceManager.CecollaResourceManager.StartMonitoring()
 
 	intercache.RegisterSlot("__CecollaBinding")
@@ -0,0 +1,126 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// 云边协同资源测试共用的假设备, 假引擎和本地 Broker, 只给 _test.go 用
+package cecollatest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/typex"
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// 收到的指令按 "cmd:args" 写进 Ctrl; 指令是 fail 的时候返回错误
+type FakeDevice struct {
+	typex.XDevice
+	Ctrl chan string
+}
+
+func NewFakeDevice() *FakeDevice {
+	return &FakeDevice{Ctrl: make(chan string, 4)}
+}
+
+func (d *FakeDevice) Status() typex.SourceState {
+	return typex.SOURCE_UP
+}
+
+func (d *FakeDevice) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	d.Ctrl <- string(cmd) + ":" + string(args)
+	if string(cmd) == "fail" {
+		return nil, fmt.Errorf("device error")
+	}
+	return []byte(`{"value":1}`), nil
+}
+
+type FakeRhilex struct {
+	typex.Rhilex
+	Devices []*typex.Device
+}
+
+func (e *FakeRhilex) AllDevices() []*typex.Device {
+	return e.Devices
+}
+
+func (e *FakeRhilex) GetDevice(uuid string) *typex.Device {
+	for _, d := range e.Devices {
+		if d.UUID == uuid {
+			return d
+		}
+	}
+	return nil
+}
+
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+/*
+*
+* 本地 Broker, tlsConfig 为空的时候不加密; filter 下面网关发上来的消息写进返回的 chan,
+* 测试结束自动关掉
+*
+ */
+func StartBroker(t *testing.T, tlsConfig *tls.Config, filter string) (*mqttserver.Server, int, chan Message) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	server := mqttserver.New(&mqttserver.Options{InlineClient: true,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	server.AddHook(new(auth.AllowHook), nil)
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "test",
+		Address: fmt.Sprintf("127.0.0.1:%d", port), TLSConfig: tlsConfig})); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	received := make(chan Message, 16)
+	server.Subscribe(filter, 1, func(cl *mqttserver.Client, sub packets.Subscription, pk packets.Packet) {
+		if pk.Origin != mqttserver.InlineClientId {
+			received <- Message{Topic: pk.TopicName, Payload: pk.Payload}
+		}
+	})
+	return server, port, received
+}
+
+// 等某个主题的消息, 其他主题的丢掉
+func WaitTopic(t *testing.T, ch chan Message, topic string) []byte {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case msg := <-ch:
+			if msg.Topic == topic {
+				return msg.Payload
+			}
+		case <-timeout:
+			t.Fatal("wait message timeout:", topic)
+		}
+	}
+}
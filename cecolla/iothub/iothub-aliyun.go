@@ -0,0 +1,107 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package iothub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+*
+* 阿里云物联网平台: 一机一密签名登录, Alink 物模型协议
+*
+ */
+type aliyunDialect struct{}
+
+func (aliyunDialect) Connect(config IotHubResourceConfig) (ConnectInfo, error) {
+	if config.ProductId == "" || config.DeviceSecret == "" {
+		return ConnectInfo{}, fmt.Errorf("Aliyun IoT requires productKey and deviceSecret")
+	}
+	clientId := config.ProductId + "." + config.DeviceName
+	timestamp := fmt.Sprint(time.Now().UnixMilli())
+	// securemode=2 是 TLS 直连, 3 是 TCP 直连
+	securemode := 3
+	if config.Tls.Enable {
+		securemode = 2
+	}
+	content := "clientId" + clientId + "deviceName" + config.DeviceName +
+		"productKey" + config.ProductId + "timestamp" + timestamp
+	return ConnectInfo{
+		ClientId: fmt.Sprintf("%s|securemode=%d,signmethod=hmacsha256,timestamp=%s|",
+			clientId, securemode, timestamp),
+		Username: config.DeviceName + "&" + config.ProductId,
+		Password: hmacSha256Hex([]byte(config.DeviceSecret), content),
+	}, nil
+}
+
+func aliyunTopic(config IotHubResourceConfig, suffix string) string {
+	return fmt.Sprintf("/sys/%s/%s/thing/%s", config.ProductId, config.DeviceName, suffix)
+}
+
+func (aliyunDialect) Subscriptions(config IotHubResourceConfig) []string {
+	return []string{aliyunTopic(config, "service/#")}
+}
+
+type aliyunMessage struct {
+	Id      string         `json:"id"`
+	Version string         `json:"version,omitempty"`
+	Method  string         `json:"method,omitempty"`
+	Params  map[string]any `json:"params,omitempty"`
+	Code    int            `json:"code,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Data    map[string]any `json:"data,omitempty"`
+}
+
+func (aliyunDialect) Report(config IotHubResourceConfig, values map[string]any) []Publish {
+	payload, _ := json.Marshal(aliyunMessage{Id: nextToken(), Version: "1.0",
+		Method: "thing.event.property.post", Params: values})
+	return []Publish{{Topic: aliyunTopic(config, "event/property/post"), Payload: payload}}
+}
+
+/*
+*
+* 属性设置 service/property/set, 服务调用 service/{identifier}, 回复都是 Topic 加 _reply
+*
+ */
+func (aliyunDialect) Decode(config IotHubResourceConfig, topic string, payload []byte) (*Command, error) {
+	prefix := aliyunTopic(config, "service/")
+	if !strings.HasPrefix(topic, prefix) || strings.HasSuffix(topic, "_reply") {
+		return nil, nil
+	}
+	msg := aliyunMessage{}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, err
+	}
+	reply := func(result map[string]any, err error) []Publish {
+		r := aliyunMessage{Id: msg.Id, Code: 200, Data: result}
+		if err != nil {
+			r.Code, r.Message, r.Data = 500, err.Error(), nil
+		}
+		if r.Data == nil && err == nil {
+			r.Data = map[string]any{}
+		}
+		bytes, _ := json.Marshal(r)
+		return []Publish{{Topic: topic + "_reply", Payload: bytes}}
+	}
+	identifier := strings.TrimPrefix(topic, prefix)
+	if identifier == "property/set" {
+		return &Command{Kind: COMMAND_PROPERTY, Params: msg.Params, Reply: reply}, nil
+	}
+	return &Command{Kind: COMMAND_METHOD, Name: identifier, Params: msg.Params, Reply: reply}, nil
+}
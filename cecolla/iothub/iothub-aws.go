@@ -0,0 +1,117 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package iothub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/*
+*
+* AWS IoT Core: X.509 证书登录, 属性走设备影子, 指令走 AWS IoT Commands
+*
+ */
+type awsDialect struct{}
+
+func (awsDialect) Connect(config IotHubResourceConfig) (ConnectInfo, error) {
+	if !config.Tls.Enable || config.Tls.ClientCert == "" {
+		return ConnectInfo{}, fmt.Errorf("AWS IoT requires X.509 client certificate")
+	}
+	return ConnectInfo{ClientId: config.DeviceName}, nil
+}
+
+func awsShadowTopic(thing, suffix string) string {
+	return fmt.Sprintf("$aws/things/%s/shadow/%s", thing, suffix)
+}
+
+func awsCommandTopic(thing string) string {
+	return fmt.Sprintf("$aws/commands/things/%s/executions/+/request/json", thing)
+}
+
+func (awsDialect) Subscriptions(config IotHubResourceConfig) []string {
+	return []string{
+		awsShadowTopic(config.DeviceName, "update/delta"),
+		awsCommandTopic(config.DeviceName),
+	}
+}
+
+type awsShadowState struct {
+	State struct {
+		Reported map[string]any `json:"reported,omitempty"`
+	} `json:"state"`
+}
+
+func awsReported(thing string, values map[string]any) []Publish {
+	shadow := awsShadowState{}
+	shadow.State.Reported = values
+	payload, _ := json.Marshal(shadow)
+	return []Publish{{Topic: awsShadowTopic(thing, "update"), Payload: payload}}
+}
+
+// 上报: {"state":{"reported":{...}}}
+func (awsDialect) Report(config IotHubResourceConfig, values map[string]any) []Publish {
+	return awsReported(config.DeviceName, values)
+}
+
+/*
+*
+* 影子 delta: {"version":1,"state":{"switch":true}}, 写成功以后上报 reported 消掉 delta;
+* 指令: $aws/commands/things/{thing}/executions/{id}/request/json, 内容 {"method":"","params":{}}
+*
+ */
+func (awsDialect) Decode(config IotHubResourceConfig, topic string, payload []byte) (*Command, error) {
+	thing := config.DeviceName
+	if topic == awsShadowTopic(thing, "update/delta") {
+		delta := struct {
+			State map[string]any `json:"state"`
+		}{}
+		if err := json.Unmarshal(payload, &delta); err != nil {
+			return nil, err
+		}
+		return &Command{Kind: COMMAND_PROPERTY, Params: delta.State,
+			Reply: func(result map[string]any, err error) []Publish {
+				if err != nil {
+					return nil
+				}
+				return awsReported(thing, delta.State)
+			}}, nil
+	}
+	parts := strings.Split(topic, "/")
+	if len(parts) == 8 && parts[1] == "commands" && parts[6] == "request" {
+		executionId := parts[5]
+		request := struct {
+			Method string         `json:"method"`
+			Params map[string]any `json:"params"`
+		}{}
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return nil, err
+		}
+		responseTopic := fmt.Sprintf("$aws/commands/things/%s/executions/%s/response/json", thing, executionId)
+		return &Command{Kind: COMMAND_METHOD, Name: request.Method, Params: request.Params,
+			Reply: func(result map[string]any, err error) []Publish {
+				response := map[string]any{"status": "SUCCEEDED", "result": result}
+				if err != nil {
+					response = map[string]any{"status": "FAILED", "statusReason": map[string]any{
+						"reasonCode": "DEVICE_ERROR", "reasonDescription": err.Error()}}
+				}
+				bytes, _ := json.Marshal(response)
+				return []Publish{{Topic: responseTopic, Payload: bytes}}
+			}}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package iothub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+*
+* Azure IoT Hub: SAS 令牌或者 X.509 登录, 遥测走 events,
+* 期望属性走设备孪生, 方法走直接方法
+*
+ */
+type azureDialect struct{}
+
+// SAS 令牌: sr=URL编码的资源, sig=HMAC-SHA256(资源\n过期时间)
+func AzureSasToken(host, deviceId, key string, expiry int64) (string, error) {
+	secret, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid shared access key: %s", err)
+	}
+	resource := url.QueryEscape(host + "/devices/" + deviceId)
+	sig := base64.StdEncoding.EncodeToString(hmacSha256(secret, fmt.Sprintf("%s\n%d", resource, expiry)))
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%d", resource, url.QueryEscape(sig), expiry), nil
+}
+
+func (azureDialect) Connect(config IotHubResourceConfig) (ConnectInfo, error) {
+	info := ConnectInfo{
+		ClientId: config.DeviceName,
+		Username: fmt.Sprintf("%s/%s/?api-version=2021-04-12", config.Host, config.DeviceName),
+	}
+	if config.DeviceSecret == "" {
+		if config.Tls.ClientCert == "" {
+			return info, fmt.Errorf("Azure IoT Hub requires shared access key or X.509 certificate")
+		}
+		return info, nil
+	}
+	ttl := config.TokenTtl
+	if ttl <= 0 {
+		ttl = 3600
+	}
+	token, err := AzureSasToken(config.Host, config.DeviceName, config.DeviceSecret,
+		time.Now().Add(time.Duration(ttl)*time.Second).Unix())
+	if err != nil {
+		return info, err
+	}
+	info.Password = token
+	return info, nil
+}
+
+func (azureDialect) Subscriptions(config IotHubResourceConfig) []string {
+	return []string{
+		"$iothub/twin/PATCH/properties/desired/#",
+		"$iothub/methods/POST/#",
+		"$iothub/twin/res/#",
+	}
+}
+
+// 遥测: devices/{deviceId}/messages/events/
+func (azureDialect) Report(config IotHubResourceConfig, values map[string]any) []Publish {
+	payload, _ := json.Marshal(values)
+	return []Publish{{Topic: fmt.Sprintf("devices/%s/messages/events/", config.DeviceName), Payload: payload}}
+}
+
+// 从 ...?$rid=1 里取请求编号
+func azureRid(topic string) string {
+	if i := strings.Index(topic, "$rid="); i >= 0 {
+		rid := topic[i+5:]
+		if j := strings.Index(rid, "&"); j >= 0 {
+			rid = rid[:j]
+		}
+		return rid
+	}
+	return ""
+}
+
+/*
+*
+* 期望属性: {"switch":true,"$version":3}, 写成功以后上报 reported;
+* 直接方法: $iothub/methods/POST/{method}/?$rid={rid}, 回复 $iothub/methods/res/{status}/?$rid={rid}
+*
+ */
+func (azureDialect) Decode(config IotHubResourceConfig, topic string, payload []byte) (*Command, error) {
+	switch {
+	case strings.HasPrefix(topic, "$iothub/twin/PATCH/properties/desired/"):
+		desired := map[string]any{}
+		if err := json.Unmarshal(payload, &desired); err != nil {
+			return nil, err
+		}
+		for k := range desired {
+			if strings.HasPrefix(k, "$") {
+				delete(desired, k)
+			}
+		}
+		return &Command{Kind: COMMAND_PROPERTY, Params: desired,
+			Reply: func(result map[string]any, err error) []Publish {
+				if err != nil {
+					return nil
+				}
+				bytes, _ := json.Marshal(desired)
+				return []Publish{{Topic: "$iothub/twin/PATCH/properties/reported/?$rid=" + nextToken(), Payload: bytes}}
+			}}, nil
+	case strings.HasPrefix(topic, "$iothub/methods/POST/"):
+		name := strings.TrimPrefix(topic, "$iothub/methods/POST/")
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i]
+		}
+		rid := azureRid(topic)
+		params := map[string]any{}
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, &params); err != nil {
+				params = map[string]any{"payload": string(payload)}
+			}
+		}
+		return &Command{Kind: COMMAND_METHOD, Name: name, Params: params,
+			Reply: func(result map[string]any, err error) []Publish {
+				status, body := 200, any(result)
+				if err != nil {
+					status, body = 500, map[string]any{"error": err.Error()}
+				}
+				bytes, _ := json.Marshal(body)
+				return []Publish{{Topic: fmt.Sprintf("$iothub/methods/res/%d/?$rid=%s", status, rid), Payload: bytes}}
+			}}, nil
+	}
+	return nil, nil
+}
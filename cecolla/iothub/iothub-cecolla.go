@@ -0,0 +1,395 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package iothub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/dataschema"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/xmanager"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+type IotHubTlsConfig struct {
+	Enable             bool   `json:"enable"`
+	CaCert             string `json:"caCert"`     // PEM 内容或者文件路径
+	ClientCert         string `json:"clientCert"` // X.509 登录的设备证书
+	ClientKey          string `json:"clientKey"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+/*
+*
+* 云平台设备配置; 一个资源对应云端一个设备, 绑定本地一个设备
+*
+ */
+type IotHubResourceConfig struct {
+	Dialect        string          `json:"dialect" validate:"required"` // AWS|AZURE|TENCENT|ALIYUN
+	Host           string          `json:"host" validate:"required"`
+	Port           int             `json:"port" validate:"required"`
+	ProductId      string          `json:"productId"`                      // 腾讯 productId, 阿里 productKey
+	DeviceName     string          `json:"deviceName" validate:"required"` // AWS thingName, Azure deviceId
+	DeviceSecret   string          `json:"deviceSecret"`                   // Azure 共享访问密钥, 腾讯阿里设备密钥
+	TokenTtl       int             `json:"tokenTtl"`                       // Azure SAS 令牌有效期, 秒
+	DeviceUuid     string          `json:"deviceUuid" validate:"required"`
+	ReportInterval int             `json:"reportInterval"` // 属性上报周期, 毫秒
+	CtrlCommand    string          `json:"ctrlCommand"`    // 属性设置写点位的指令, 默认 WriteToSheetRegisterWithTag
+	SchemaId       string          `json:"schemaId"`       // 数据模型, 云端方法优先按模型里的服务调用
+	Tls            IotHubTlsConfig `json:"tls"`
+}
+
+type IotHubResource struct {
+	manager *xmanager.GatewayResourceManager
+	state   xmanager.GatewayResourceState
+	uuid    string
+	config  IotHubResourceConfig
+	dialect Dialect
+	client  mqtt.Client
+	locker  sync.Mutex
+	last    map[string]any // 上次上报的值
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func NewIotHubResource(manager *xmanager.GatewayResourceManager) (xmanager.GatewayResource, error) {
+	return &IotHubResource{
+		state:   xmanager.MEDIA_PENDING,
+		config:  IotHubResourceConfig{},
+		manager: manager,
+		last:    map[string]any{},
+	}, nil
+}
+
+func (r *IotHubResource) Init(uuid string, configMap map[string]any) error {
+	r.uuid = uuid
+	if err := xmanager.MapToConfig(configMap, &r.config); err != nil {
+		return err
+	}
+	dialect, err := GetDialect(r.config.Dialect)
+	if err != nil {
+		return err
+	}
+	r.dialect = dialect
+	if r.config.ReportInterval <= 0 {
+		r.config.ReportInterval = 5000
+	}
+	if r.config.CtrlCommand == "" {
+		r.config.CtrlCommand = "WriteToSheetRegisterWithTag"
+	}
+	r.state = xmanager.MEDIA_PENDING
+	glogger.GLogger.Infof("IotHub resource %s initialized, dialect: %s, device: %s",
+		uuid, r.config.Dialect, r.config.DeviceName)
+	return nil
+}
+
+/*
+*
+* 启动: 按方言登录并订阅下行; 连不上的时候置为 DOWN, 让监控器去重连
+*
+ */
+func (r *IotHubResource) Start(ctx context.Context) error {
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	if err := r.connect(); err != nil {
+		glogger.GLogger.Error("IotHub resource start failed:", err)
+		r.setErrMsg(err)
+		r.setState(xmanager.MEDIA_DOWN)
+		return nil
+	}
+	intercache.DeleteValue("__DefaultRuleEngine", r.uuid)
+	r.setState(xmanager.MEDIA_UP)
+	go r.reportLoop()
+	glogger.GLogger.Infof("IotHub resource %s started", r.uuid)
+	return nil
+}
+
+func (r *IotHubResource) connect() error {
+	info, err := r.dialect.Connect(r.config)
+	if err != nil {
+		return err
+	}
+	opts := mqtt.NewClientOptions()
+	scheme := "tcp"
+	if r.config.Tls.Enable {
+		scheme = "ssl"
+		tlsConfig, err := r.config.Tls.TLSConfig()
+		if err != nil {
+			return err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.AddBroker(fmt.Sprintf("%s://%s:%v", scheme, r.config.Host, r.config.Port))
+	opts.SetClientID(info.ClientId)
+	opts.SetUsername(info.Username)
+	opts.SetPassword(info.Password)
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false)
+	opts.SetConnectTimeout(5 * time.Second)
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		glogger.GLogger.Warn("IotHub connect lost:", err)
+	})
+	r.client = mqtt.NewClient(opts)
+	if token := r.client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	for _, topic := range r.dialect.Subscriptions(r.config) {
+		if token := r.client.Subscribe(topic, 1, r.onMessage); token.Wait() && token.Error() != nil {
+			r.client.Disconnect(100)
+			return token.Error()
+		}
+	}
+	return nil
+}
+
+func (r *IotHubResource) setState(state xmanager.GatewayResourceState) {
+	r.locker.Lock()
+	r.state = state
+	r.locker.Unlock()
+}
+
+func (r *IotHubResource) setErrMsg(err error) {
+	intercache.SetValue("__DefaultRuleEngine", r.uuid, intercache.CacheValue{
+		UUID:          r.uuid,
+		Status:        1,
+		ErrMsg:        err.Error(),
+		LastFetchTime: uint64(time.Now().UnixMilli()),
+		Value:         "",
+	})
+}
+
+func (r *IotHubResource) publish(messages []Publish) error {
+	for _, msg := range messages {
+		token := r.client.Publish(msg.Topic, 1, false, msg.Payload)
+		if !token.WaitTimeout(5 * time.Second) {
+			return fmt.Errorf("publish timeout: %s", msg.Topic)
+		}
+		if err := token.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *IotHubResource) reportLoop() {
+	ticker := time.NewTicker(time.Duration(r.config.ReportInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.client.IsConnectionOpen() {
+			r.setErrMsg(fmt.Errorf("IotHub connection lost"))
+			r.setState(xmanager.MEDIA_DOWN)
+			return
+		}
+		values := r.changedValues()
+		if len(values) == 0 {
+			continue
+		}
+		if err := r.publish(r.dialect.Report(r.config, values)); err != nil {
+			glogger.GLogger.Error("IotHub report error:", err)
+		}
+	}
+}
+
+// 点位表的 tag 作为属性名, 只报变化的值
+func (r *IotHubResource) changedValues() map[string]any {
+	values := map[string]any{}
+	points, err := service.DevicePointTags(r.config.DeviceUuid)
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return values
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for _, point := range points {
+		if point.Tag == "" {
+			continue
+		}
+		cache := intercache.GetValue(r.config.DeviceUuid, point.UUID)
+		if cache.Status != 1 || cache.Value == nil {
+			continue
+		}
+		value := utils.ParsePointValue(cache.Value)
+		if last, ok := r.last[point.Tag]; ok && reflect.DeepEqual(last, value) {
+			continue
+		}
+		r.last[point.Tag] = value
+		values[point.Tag] = value
+	}
+	return values
+}
+
+func (r *IotHubResource) onMessage(client mqtt.Client, m mqtt.Message) {
+	command, err := r.dialect.Decode(r.config, m.Topic(), m.Payload())
+	if err != nil {
+		glogger.GLogger.Error("IotHub invalid message:", m.Topic(), err)
+		return
+	}
+	if command == nil {
+		return
+	}
+	// 设备调用可能比较慢, 不能阻塞 MQTT 的收包
+	go func() {
+		result, err := r.execute(command)
+		if err != nil {
+			glogger.GLogger.Errorf("IotHub %s %s error: %s", command.Kind, command.Name, err)
+		}
+		if command.Reply != nil {
+			if err := r.publish(command.Reply(result, err)); err != nil {
+				glogger.GLogger.Error("IotHub reply error:", err)
+			}
+		}
+	}()
+}
+
+/*
+*
+* 属性设置: 模型里绑定了点位的属性按绑定写, 其余属性按 tag 写点位; 方法调用: 配置了数据模型并且模型里有同名服务的按服务调用,
+* 否则方法名作为设备指令, 参数原样传下去
+*
+ */
+func (r *IotHubResource) execute(command *Command) (map[string]any, error) {
+	rhilexDevice := r.manager.Rhilex().GetDevice(r.config.DeviceUuid)
+	if rhilexDevice == nil || rhilexDevice.Device == nil {
+		return nil, fmt.Errorf("device not exists: %s", r.config.DeviceUuid)
+	}
+	if rhilexDevice.Device.Status() != typex.SOURCE_UP {
+		return nil, fmt.Errorf("device is not running: %s", r.config.DeviceUuid)
+	}
+	if command.Kind == COMMAND_PROPERTY {
+		for tag, v := range command.Params {
+			// 模型里绑定了点位的属性按绑定写
+			if r.config.SchemaId != "" && dataschema.HasIoTBinding(r.config.SchemaId, tag) {
+				if err := dataschema.WriteProperty(r.manager.Rhilex(), r.config.SchemaId, tag, v); err != nil {
+					return nil, fmt.Errorf("%s: %s", tag, err)
+				}
+				continue
+			}
+			value := fmt.Sprintf("%v", v)
+			if b, ok := v.(bool); ok {
+				value = "0"
+				if b {
+					value = "1"
+				}
+			}
+			args, _ := json.Marshal(map[string]string{"tag": tag, "value": value})
+			if _, err := rhilexDevice.Device.OnCtrl([]byte(r.config.CtrlCommand), args); err != nil {
+				return nil, fmt.Errorf("%s: %s", tag, err)
+			}
+		}
+		return map[string]any{}, nil
+	}
+	if command.Name == "" {
+		return nil, fmt.Errorf("empty method")
+	}
+	if r.config.SchemaId != "" {
+		result, err := dataschema.InvokeService(r.manager.Rhilex(), r.config.SchemaId,
+			r.config.DeviceUuid, command.Name, command.Params)
+		if !errors.Is(err, dataschema.ErrIoTServiceNotExists) {
+			return result, err
+		}
+	}
+	args, _ := json.Marshal(command.Params)
+	result, err := rhilexDevice.Device.OnCtrl([]byte(command.Name), args)
+	if err != nil {
+		return nil, err
+	}
+	response := map[string]any{}
+	if len(result) > 0 {
+		if err := json.Unmarshal(result, &response); err != nil {
+			response = map[string]any{"result": string(result)}
+		}
+	}
+	return response, nil
+}
+
+func (r *IotHubResource) Status() xmanager.GatewayResourceState {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.state
+}
+
+func (r *IotHubResource) Services() []xmanager.ResourceService {
+	return []xmanager.ResourceService{
+		{
+			Name:        "iothub",
+			Method:      "Report",
+			Args:        []xmanager.ResourceServiceArg{{UUID: r.uuid, Args: []any{map[string]any{}}}},
+			Description: "按平台格式上报属性",
+		},
+	}
+}
+
+func (r *IotHubResource) OnService(request xmanager.ResourceServiceRequest) (xmanager.ResourceServiceResponse, error) {
+	glogger.GLogger.Debugf("IotHub resource %s received service request: %+v", r.uuid, request)
+	response := xmanager.ResourceServiceResponse{Type: "string", Result: "ok"}
+	var err error
+	switch request.Method {
+	case "Report":
+		if len(request.Args) == 0 || len(request.Args[0].Args) == 0 {
+			err = fmt.Errorf("Report need args: values")
+			break
+		}
+		if r.client == nil || !r.client.IsConnectionOpen() {
+			err = fmt.Errorf("IotHub is not connected")
+			break
+		}
+		values := map[string]any{}
+		switch T := request.Args[0].Args[0].(type) {
+		case map[string]any:
+			values = T
+		case string:
+			json.Unmarshal([]byte(T), &values)
+		}
+		err = r.publish(r.dialect.Report(r.config, values))
+	default:
+		err = fmt.Errorf("unsupported service: %s", request.Method)
+	}
+	response.Error = err
+	return response, err
+}
+
+func (r *IotHubResource) Details() *xmanager.GatewayResourceWorker {
+	if r.manager == nil {
+		return nil
+	}
+	worker, _ := r.manager.GetResource(r.uuid)
+	return worker
+}
+
+func (r *IotHubResource) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	if r.client != nil && r.client.IsConnectionOpen() {
+		r.client.Disconnect(100)
+	}
+	r.setState(xmanager.MEDIA_STOP)
+	glogger.GLogger.Infof("IotHub resource %s stopped", r.uuid)
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package iothub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 登录信息, 每家平台算法不一样
+type ConnectInfo struct {
+	ClientId string
+	Username string
+	Password string
+}
+
+// 要发出去的消息
+type Publish struct {
+	Topic   string
+	Payload []byte
+}
+
+const (
+	COMMAND_PROPERTY = "property" // 设置属性, 按 tag 写点位
+	COMMAND_METHOD   = "method"   // 调用方法, 方法名作为设备指令
+)
+
+/*
+*
+* 云端下发的指令; Reply 根据执行结果生成回复, 不需要回复的平台为 nil
+*
+ */
+type Command struct {
+	Kind   string
+	Name   string
+	Params map[string]any
+	Reply  func(result map[string]any, err error) []Publish
+}
+
+/*
+*
+* 平台方言: 登录方式, 订阅哪些 Topic, 上报格式和下行解析
+*
+ */
+type Dialect interface {
+	Connect(config IotHubResourceConfig) (ConnectInfo, error)
+	Subscriptions(config IotHubResourceConfig) []string
+	Report(config IotHubResourceConfig, values map[string]any) []Publish
+	Decode(config IotHubResourceConfig, topic string, payload []byte) (*Command, error)
+}
+
+var (
+	dialects      = map[string]Dialect{}
+	dialectLocker sync.RWMutex
+)
+
+// 注册方言, 新平台在这里扩展
+func RegisterDialect(name string, dialect Dialect) {
+	dialectLocker.Lock()
+	defer dialectLocker.Unlock()
+	dialects[strings.ToUpper(name)] = dialect
+}
+
+func GetDialect(name string) (Dialect, error) {
+	dialectLocker.RLock()
+	defer dialectLocker.RUnlock()
+	if dialect, ok := dialects[strings.ToUpper(name)]; ok {
+		return dialect, nil
+	}
+	return nil, fmt.Errorf("unsupported dialect: %s", name)
+}
+
+func init() {
+	RegisterDialect("AWS", awsDialect{})
+	RegisterDialect("AZURE", azureDialect{})
+	RegisterDialect("TENCENT", tencentDialect{})
+	RegisterDialect("ALIYUN", aliyunDialect{})
+}
+
+/*
+*
+* TLS: 证书可以直接填 PEM 内容, 也可以填文件路径
+*
+ */
+func (c IotHubTlsConfig) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CaCert != "" {
+		ca, err := readPem(c.CaCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid CA certificate")
+		}
+		config.RootCAs = pool
+	}
+	if c.ClientCert != "" || c.ClientKey != "" {
+		cert, err := readPem(c.ClientCert)
+		if err != nil {
+			return nil, err
+		}
+		key, err := readPem(c.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+func readPem(v string) ([]byte, error) {
+	if strings.Contains(v, "-----BEGIN") {
+		return []byte(v), nil
+	}
+	return os.ReadFile(v)
+}
+
+func hmacSha256(key []byte, content string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(content))
+	return mac.Sum(nil)
+}
+
+func hmacSha256Hex(key []byte, content string) string {
+	return hex.EncodeToString(hmacSha256(key, content))
+}
+
+var __token atomic.Int64
+
+// 请求编号, 平台用来匹配回复
+func nextToken() string {
+	return strconv.FormatInt(time.Now().Unix()*1000+__token.Add(1)%1000, 10)
+}
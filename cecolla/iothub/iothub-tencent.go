@@ -0,0 +1,112 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package iothub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+/*
+*
+* 腾讯云物联网开发平台: 设备密钥签名登录, 数据模板协议
+*
+ */
+type tencentDialect struct{}
+
+func (tencentDialect) Connect(config IotHubResourceConfig) (ConnectInfo, error) {
+	if config.ProductId == "" || config.DeviceSecret == "" {
+		return ConnectInfo{}, fmt.Errorf("Tencent IoT requires productId and deviceSecret")
+	}
+	secret, err := base64.StdEncoding.DecodeString(config.DeviceSecret)
+	if err != nil {
+		return ConnectInfo{}, fmt.Errorf("invalid device secret: %s", err)
+	}
+	clientId := config.ProductId + config.DeviceName
+	username := fmt.Sprintf("%s;12010126;%05d;%d", clientId, rand.Intn(100000),
+		time.Now().Add(24*time.Hour).Unix())
+	return ConnectInfo{
+		ClientId: clientId,
+		Username: username,
+		Password: hmacSha256Hex(secret, username) + ";hmacsha256",
+	}, nil
+}
+
+func tencentTopic(direction, kind string, config IotHubResourceConfig) string {
+	return fmt.Sprintf("$thing/%s/%s/%s/%s", direction, kind, config.ProductId, config.DeviceName)
+}
+
+func (tencentDialect) Subscriptions(config IotHubResourceConfig) []string {
+	return []string{
+		tencentTopic("down", "property", config),
+		tencentTopic("down", "action", config),
+	}
+}
+
+type tencentMessage struct {
+	Method      string         `json:"method"`
+	ClientToken string         `json:"clientToken"`
+	Timestamp   int64          `json:"timestamp,omitempty"`
+	Params      map[string]any `json:"params,omitempty"`
+	ActionId    string         `json:"actionId,omitempty"`
+	Code        int            `json:"code"`
+	Status      string         `json:"status,omitempty"`
+	Response    map[string]any `json:"response,omitempty"`
+}
+
+func (tencentDialect) Report(config IotHubResourceConfig, values map[string]any) []Publish {
+	payload, _ := json.Marshal(tencentMessage{Method: "report", ClientToken: nextToken(),
+		Timestamp: time.Now().UnixMilli(), Params: values})
+	return []Publish{{Topic: tencentTopic("up", "property", config), Payload: payload}}
+}
+
+/*
+*
+* 属性下发 control 回复 control_reply; 行为调用 action 回复 action_reply;
+* 其他的回复消息(report_reply 等)忽略
+*
+ */
+func (tencentDialect) Decode(config IotHubResourceConfig, topic string, payload []byte) (*Command, error) {
+	msg := tencentMessage{}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, err
+	}
+	reply := func(kind, method string) func(result map[string]any, err error) []Publish {
+		return func(result map[string]any, err error) []Publish {
+			r := tencentMessage{Method: method, ClientToken: msg.ClientToken, Status: "success"}
+			if err != nil {
+				r.Code, r.Status = 1, err.Error()
+			}
+			if method == "action_reply" {
+				r.Response = result
+			}
+			bytes, _ := json.Marshal(r)
+			return []Publish{{Topic: tencentTopic("up", kind, config), Payload: bytes}}
+		}
+	}
+	switch {
+	case topic == tencentTopic("down", "property", config) && msg.Method == "control":
+		return &Command{Kind: COMMAND_PROPERTY, Params: msg.Params,
+			Reply: reply("property", "control_reply")}, nil
+	case topic == tencentTopic("down", "action", config) && msg.Method == "action":
+		return &Command{Kind: COMMAND_METHOD, Name: msg.ActionId, Params: msg.Params,
+			Reply: reply("action", "action_reply")}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,214 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package iothub
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/cecolla/cecollatest"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/xmanager"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+type testPki struct {
+	caPem, serverCert, serverKey, clientCert, clientKey string
+}
+
+func issueCert(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (string, string, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})), cert, key
+}
+
+// 自签 CA, 再签发服务端和设备证书
+func newTestPki(t *testing.T) testPki {
+	pki := testPki{}
+	notAfter := time.Now().Add(time.Hour)
+	caPem, _, ca, caKey := issueCert(t, &x509.Certificate{SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "rhilex-ca"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: notAfter,
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+	pki.caPem = caPem
+	pki.serverCert, pki.serverKey, _, _ = issueCert(t, &x509.Certificate{SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{CommonName: "127.0.0.1"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: notAfter,
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage: x509.KeyUsageDigitalSignature}, ca, caKey)
+	pki.clientCert, pki.clientKey, _, _ = issueCert(t, &x509.Certificate{SerialNumber: big.NewInt(3),
+		Subject: pkix.Name{CommonName: "thing1"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: notAfter,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, KeyUsage: x509.KeyUsageDigitalSignature}, ca, caKey)
+	return pki
+}
+
+// go test -timeout 30s -run ^Test_IotHub_Aws_Tls github.com/hootrhino/rhilex/cecolla/iothub -v -count=1
+func Test_IotHub_Aws_Tls(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	intercache.InitGlobalValueRegistry(nil)
+	intercache.RegisterSlot("__DefaultRuleEngine")
+	pki := newTestPki(t)
+	serverPair, err := tls.X509KeyPair([]byte(pki.serverCert), []byte(pki.serverKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(pki.caPem))
+	// 本地 TLS Broker, 和 AWS 一样要求设备证书
+	server, port, received := cecollatest.StartBroker(t, &tls.Config{
+		Certificates: []tls.Certificate{serverPair}, ClientCAs: pool,
+		ClientAuth: tls.RequireAndVerifyClientCert}, "$aws/#")
+	device := cecollatest.NewFakeDevice()
+	manager := xmanager.NewGatewayResourceManager(&cecollatest.FakeRhilex{
+		Devices: []*typex.Device{{UUID: "DEVICE1", Device: device}}})
+	config := map[string]any{
+		"dialect": "aws", "host": "127.0.0.1", "port": port, "deviceName": "thing1",
+		"deviceUuid": "DEVICE1", "reportInterval": 60000,
+		"tls": map[string]any{"enable": true, "caCert": pki.caPem},
+	}
+	// 没有设备证书登录不了
+	resource, _ := NewIotHubResource(manager)
+	if err := resource.Init("HUB1", config); err != nil {
+		t.Fatal(err)
+	}
+	resource.Start(context.Background())
+	if resource.Status() != xmanager.MEDIA_DOWN {
+		t.Fatal("connect without certificate should fail")
+	}
+	config["tls"] = map[string]any{"enable": true, "caCert": pki.caPem,
+		"clientCert": pki.clientCert, "clientKey": pki.clientKey}
+	resource, _ = NewIotHubResource(manager)
+	if err := resource.Init("HUB1", config); err != nil {
+		t.Fatal(err)
+	}
+	resource.Start(context.Background())
+	defer resource.Stop()
+	if resource.Status() != xmanager.MEDIA_UP {
+		t.Fatal("resource should be up")
+	}
+	if _, err := resource.OnService(xmanager.ResourceServiceRequest{Method: "Report",
+		Args: []xmanager.ResourceServiceArg{{Args: []any{map[string]any{"temp": 21.5}}}}}); err != nil {
+		t.Fatal(err)
+	}
+	shadow := map[string]map[string]map[string]any{}
+	json.Unmarshal(cecollatest.WaitTopic(t, received, "$aws/things/thing1/shadow/update"), &shadow)
+	if shadow["state"]["reported"]["temp"] != 21.5 {
+		t.Fatal("unexpected shadow", shadow)
+	}
+	// 影子 delta 写点位, 然后上报 reported
+	server.Publish("$aws/things/thing1/shadow/update/delta", []byte(`{"version":2,"state":{"switch":true}}`), false, 1)
+	if ctrl := <-device.Ctrl; ctrl != `WriteToSheetRegisterWithTag:{"tag":"switch","value":"1"}` {
+		t.Fatal("unexpected ctrl", ctrl)
+	}
+	json.Unmarshal(cecollatest.WaitTopic(t, received, "$aws/things/thing1/shadow/update"), &shadow)
+	if shadow["state"]["reported"]["switch"] != true {
+		t.Fatal("delta should be reported back", shadow)
+	}
+	// 指令
+	server.Publish("$aws/commands/things/thing1/executions/E1/request/json",
+		[]byte(`{"method":"reboot","params":{"delay":3}}`), false, 1)
+	if ctrl := <-device.Ctrl; ctrl != `reboot:{"delay":3}` {
+		t.Fatal("unexpected ctrl", ctrl)
+	}
+	response := map[string]any{}
+	json.Unmarshal(cecollatest.WaitTopic(t, received, "$aws/commands/things/thing1/executions/E1/response/json"), &response)
+	if response["status"] != "SUCCEEDED" {
+		t.Fatal("unexpected command response", response)
+	}
+}
+
+// go test -timeout 30s -run ^Test_IotHub_Dialects github.com/hootrhino/rhilex/cecolla/iothub -v -count=1
+func Test_IotHub_Dialects(t *testing.T) {
+	config := IotHubResourceConfig{Host: "hub1.azure-devices.net", DeviceName: "dev1",
+		ProductId: "PK1", DeviceSecret: "c2VjcmV0"}
+	// Azure
+	azure, _ := GetDialect("azure")
+	info, err := azure.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Username != "hub1.azure-devices.net/dev1/?api-version=2021-04-12" ||
+		!strings.HasPrefix(info.Password, "SharedAccessSignature sr=hub1.azure-devices.net%2Fdevices%2Fdev1&sig=") {
+		t.Fatal("unexpected azure connect info", info)
+	}
+	cmd, _ := azure.Decode(config, "$iothub/twin/PATCH/properties/desired/?$version=3", []byte(`{"switch":true,"$version":3}`))
+	if cmd.Kind != COMMAND_PROPERTY || len(cmd.Params) != 1 {
+		t.Fatal("unexpected desired", cmd)
+	}
+	cmd, _ = azure.Decode(config, "$iothub/methods/POST/reboot/?$rid=7", []byte(`{"delay":3}`))
+	if cmd.Kind != COMMAND_METHOD || cmd.Name != "reboot" {
+		t.Fatal("unexpected method", cmd)
+	}
+	if reply := cmd.Reply(map[string]any{}, fmt.Errorf("busy")); reply[0].Topic != "$iothub/methods/res/500/?$rid=7" {
+		t.Fatal("unexpected method reply", reply)
+	}
+	// 腾讯
+	tencent, _ := GetDialect("TENCENT")
+	if info, _ := tencent.Connect(config); info.ClientId != "PK1dev1" || !strings.HasSuffix(info.Password, ";hmacsha256") {
+		t.Fatal("unexpected tencent connect info", info)
+	}
+	cmd, _ = tencent.Decode(config, "$thing/down/property/PK1/dev1",
+		[]byte(`{"method":"control","clientToken":"t1","params":{"power":1}}`))
+	reply := map[string]any{}
+	json.Unmarshal(cmd.Reply(map[string]any{}, nil)[0].Payload, &reply)
+	if cmd.Kind != COMMAND_PROPERTY || reply["method"] != "control_reply" || reply["clientToken"] != "t1" {
+		t.Fatal("unexpected tencent control", cmd, reply)
+	}
+	if cmd, _ := tencent.Decode(config, "$thing/down/property/PK1/dev1", []byte(`{"method":"report_reply"}`)); cmd != nil {
+		t.Fatal("reply should be ignored")
+	}
+	// 阿里云
+	aliyun, _ := GetDialect("ALIYUN")
+	if info, _ := aliyun.Connect(config); info.Username != "dev1&PK1" || !strings.Contains(info.ClientId, "securemode=3") {
+		t.Fatal("unexpected aliyun connect info", info)
+	}
+	cmd, _ = aliyun.Decode(config, "/sys/PK1/dev1/thing/service/property/set",
+		[]byte(`{"id":"9","params":{"power":1}}`))
+	if cmd.Kind != COMMAND_PROPERTY || cmd.Reply(nil, nil)[0].Topic != "/sys/PK1/dev1/thing/service/property/set_reply" {
+		t.Fatal("unexpected aliyun set", cmd)
+	}
+	cmd, _ = aliyun.Decode(config, "/sys/PK1/dev1/thing/service/reboot", []byte(`{"id":"10","params":{}}`))
+	if cmd.Kind != COMMAND_METHOD || cmd.Name != "reboot" {
+		t.Fatal("unexpected aliyun service", cmd)
+	}
+	if _, err := GetDialect("unknown"); err == nil {
+		t.Fatal("unknown dialect should fail")
+	}
+}
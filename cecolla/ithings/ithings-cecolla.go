@@ -17,13 +17,46 @@ package ithings
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/dataschema"
+	"github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/xmanager"
+	ithingsclient "github.com/hootrhino/rhilex/device/ithings"
 	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
 )
 
+// IthingsSubDeviceConfig 子设备: 云端的三元组绑定到本地设备
+type IthingsSubDeviceConfig struct {
+	ProductId    string `json:"productId" validate:"required"`
+	DeviceName   string `json:"deviceName" validate:"required"`
+	DeviceSecret string `json:"deviceSecret"`
+	DeviceUuid   string `json:"deviceUuid"`  // 本地设备, 点位表的 tag 就是物模型的标识符
+	CtrlCommand  string `json:"ctrlCommand"` // 属性下发时调用的设备指令, 默认 WriteToSheetRegisterWithTag
+}
+
 // IthingsResourceConfig Ithings资源配置结构体
 type IthingsResourceConfig struct {
+	Host           string                   `json:"host" validate:"required"`
+	Port           int                      `json:"port" validate:"required"`
+	ProductId      string                   `json:"productId" validate:"required"`
+	DeviceName     string                   `json:"deviceName" validate:"required"`
+	DeviceSecret   string                   `json:"deviceSecret" validate:"required"`
+	HmacType       string                   `json:"hmacType"`       // hmacsha256|hmacsha1
+	ReportInterval int                      `json:"reportInterval"` // 属性上报周期, 毫秒
+	SyncSchema     bool                     `json:"syncSchema"`     // 启动时把云端物模型同步到本地
+	SubDevices     []IthingsSubDeviceConfig `json:"subDevices"`
 }
 
 // IthingsResource Ithings资源实现
@@ -32,6 +65,12 @@ type IthingsResource struct {
 	state   xmanager.GatewayResourceState
 	uuid    string
 	config  IthingsResourceConfig
+	client  *ithingsclient.Client
+	locker  sync.Mutex
+	models  map[string]ithingsclient.ThingModel // productId -> 物模型
+	last    map[string]map[string]any           // 设备 -> 上次上报的值
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
 // NewIthingsResource 创建新的Ithings资源
@@ -40,6 +79,8 @@ func NewIthingsResource(manager *xmanager.GatewayResourceManager) (xmanager.Gate
 		state:   xmanager.MEDIA_PENDING,
 		config:  IthingsResourceConfig{},
 		manager: manager,
+		models:  map[string]ithingsclient.ThingModel{},
+		last:    map[string]map[string]any{},
 	}, nil
 }
 
@@ -50,38 +91,595 @@ func (r *IthingsResource) Init(uuid string, configMap map[string]any) error {
 	if err != nil {
 		return err
 	}
+	if r.config.ReportInterval <= 0 {
+		r.config.ReportInterval = 5000
+	}
 	r.state = xmanager.MEDIA_PENDING
-	glogger.GLogger.Infof("Ithings resource %s initialized with config: %+v", uuid, r.config)
+	glogger.GLogger.Infof("Ithings resource %s initialized, gateway: %s/%s",
+		uuid, r.config.ProductId, r.config.DeviceName)
 	return nil
 }
 
-// Start 启动Ithings资源
+/*
+*
+* 启动: 网关登录, 注册子设备拓扑, 按需同步物模型, 然后开始周期上报;
+* 云端连不上的时候不报错, 置为 DOWN 让监控器去重连
+*
+ */
 func (r *IthingsResource) Start(ctx context.Context) error {
-	r.state = xmanager.MEDIA_UP
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	r.client = ithingsclient.NewClient(ithingsclient.ClientConfig{
+		Host:         r.config.Host,
+		Port:         r.config.Port,
+		ProductId:    r.config.ProductId,
+		DeviceName:   r.config.DeviceName,
+		DeviceSecret: r.config.DeviceSecret,
+		HmacType:     r.config.HmacType,
+	}, ithingsclient.Handler{
+		OnControl:   r.onControl,
+		OnGetReport: r.onGetReport,
+		OnAction:    r.onAction,
+	})
+	if err := r.connect(); err != nil {
+		glogger.GLogger.Error("Ithings resource start failed:", err)
+		r.setErrMsg(err)
+		r.client.Disconnect()
+		r.setState(xmanager.MEDIA_DOWN)
+		return nil
+	}
+	intercache.DeleteValue("__DefaultRuleEngine", r.uuid)
+	if r.config.SyncSchema {
+		if err := r.syncSchema(); err != nil {
+			glogger.GLogger.Error("Ithings sync schema failed:", err)
+			r.setErrMsg(err)
+		}
+	}
+	r.setState(xmanager.MEDIA_UP)
+	go r.reportLoop()
 	glogger.GLogger.Infof("Ithings resource %s started", r.uuid)
 	return nil
 }
 
+func (r *IthingsResource) connect() error {
+	if err := r.client.Connect(); err != nil {
+		return err
+	}
+	if devices := r.subDevices(); len(devices) > 0 {
+		if err := r.client.AddTopology(devices); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 网关自己也可以绑定本地设备, 所以拓扑里要去掉网关
+func (r *IthingsResource) subDevices() []ithingsclient.SubDevice {
+	devices := []ithingsclient.SubDevice{}
+	for _, d := range r.config.SubDevices {
+		if d.ProductId == r.config.ProductId && d.DeviceName == r.config.DeviceName {
+			continue
+		}
+		devices = append(devices, ithingsclient.SubDevice{
+			ProductId:    d.ProductId,
+			DeviceName:   d.DeviceName,
+			DeviceSecret: d.DeviceSecret,
+		})
+	}
+	return devices
+}
+
+func (r *IthingsResource) findSubDevice(productId, deviceName string) (IthingsSubDeviceConfig, bool) {
+	for _, d := range r.config.SubDevices {
+		if d.ProductId == productId && d.DeviceName == deviceName {
+			return d, true
+		}
+	}
+	return IthingsSubDeviceConfig{}, false
+}
+
+func (r *IthingsResource) setState(state xmanager.GatewayResourceState) {
+	r.locker.Lock()
+	r.state = state
+	r.locker.Unlock()
+}
+
+func (r *IthingsResource) setErrMsg(err error) {
+	intercache.SetValue("__DefaultRuleEngine", r.uuid, intercache.CacheValue{
+		UUID:          r.uuid,
+		Status:        1,
+		ErrMsg:        err.Error(),
+		LastFetchTime: uint64(time.Now().UnixMilli()),
+		Value:         "",
+	})
+}
+
+/*
+*
+* 从云端拉物模型, 属性写进本地数据模型; 每个产品对应一个数据模型
+*
+ */
+func (r *IthingsResource) syncSchema() error {
+	products := map[string]ithingsclient.SubDevice{}
+	products[r.config.ProductId] = ithingsclient.SubDevice{
+		ProductId: r.config.ProductId, DeviceName: r.config.DeviceName}
+	for _, d := range r.config.SubDevices {
+		if _, ok := products[d.ProductId]; !ok {
+			products[d.ProductId] = ithingsclient.SubDevice{
+				ProductId: d.ProductId, DeviceName: d.DeviceName}
+		}
+	}
+	for productId, device := range products {
+		thingModel, err := r.client.GetSchema(device)
+		if err != nil {
+			return fmt.Errorf("product %s: %s", productId, err)
+		}
+		r.locker.Lock()
+		r.models[productId] = thingModel
+		r.locker.Unlock()
+		if err := dataschema.SyncIoTSchema(SchemaUuid(productId),
+			"iThings-"+productId, "Synced from iThings product "+productId,
+			ToIoTProperties(thingModel)); err != nil {
+			return fmt.Errorf("product %s: %s", productId, err)
+		}
+		if err := dataschema.SyncIoTFunctions(SchemaUuid(productId),
+			ToIoTEvents(thingModel), ToIoTServices(thingModel)); err != nil {
+			return fmt.Errorf("product %s: %s", productId, err)
+		}
+	}
+	return nil
+}
+
+// 同步下来的数据模型 UUID 固定, 重复同步覆盖同一个
+func SchemaUuid(productId string) string {
+	b := strings.Builder{}
+	for _, c := range productId {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return "SCHEMA_ITHINGS_" + b.String()
+}
+
+/*
+*
+* 物模型属性转本地数据模型属性; 本地表达不了的(没有字段的结构体等)跳过
+*
+ */
+func ToIoTProperties(thingModel ithingsclient.ThingModel) []dataschema.IoTProperty {
+	properties := []dataschema.IoTProperty{}
+	for _, p := range thingModel.Properties {
+		param, ok := ToIoTParam(p.Identifier, p.Name, p.Define)
+		if !ok {
+			continue
+		}
+		property := dataschema.IoTProperty{
+			Label:       p.Name,
+			Name:        p.Identifier,
+			Description: p.Desc,
+			Type:        param.Type,
+			Unit:        param.Unit,
+			Rule:        param.Rule,
+			Rw:          "R",
+		}
+		if p.Mode == "rw" {
+			property.Rw = "RW"
+		}
+		properties = append(properties, property)
+	}
+	return properties
+}
+
+// 物模型事件转本地事件, 有参数表达不了的整个跳过
+func ToIoTEvents(thingModel ithingsclient.ThingModel) []dataschema.IoTEvent {
+	events := []dataschema.IoTEvent{}
+	for _, e := range thingModel.Events {
+		event := dataschema.IoTEvent{
+			Name:        e.Identifier,
+			Label:       e.Name,
+			Severity:    dataschema.IoTEventSeverityInfo,
+			Description: e.Desc,
+		}
+		switch e.Type {
+		case "alert":
+			event.Severity = dataschema.IoTEventSeverityAlert
+		case "fault":
+			event.Severity = dataschema.IoTEventSeverityFault
+		}
+		outputs, ok := toIoTParams(e.Params)
+		if !ok {
+			continue
+		}
+		event.Outputs = outputs
+		events = append(events, event)
+	}
+	return events
+}
+
+// 物模型行为转本地服务, 行为标识符就是设备指令
+func ToIoTServices(thingModel ithingsclient.ThingModel) []dataschema.IoTService {
+	services := []dataschema.IoTService{}
+	for _, a := range thingModel.Actions {
+		inputs, ok1 := toIoTParams(a.Input)
+		outputs, ok2 := toIoTParams(a.Output)
+		if !ok1 || !ok2 {
+			continue
+		}
+		services = append(services, dataschema.IoTService{
+			Name:        a.Identifier,
+			Label:       a.Name,
+			Inputs:      inputs,
+			Outputs:     outputs,
+			Description: a.Desc,
+		})
+	}
+	return services
+}
+
+func toIoTParams(params []ithingsclient.ThingParam) ([]dataschema.IoTParam, bool) {
+	result := []dataschema.IoTParam{}
+	for _, p := range params {
+		param, ok := ToIoTParam(p.Identifier, p.Name, p.Define)
+		if !ok {
+			return nil, false
+		}
+		result = append(result, param)
+	}
+	return result, true
+}
+
+/*
+*
+* 数据定义转本地参数; 云端没给范围的数值不限制范围, 没给长度的字符串按 2048
+*
+ */
+func ToIoTParam(identifier, name string, define ithingsclient.ThingDefine) (dataschema.IoTParam, bool) {
+	param := dataschema.IoTParam{Name: identifier, Label: name, Unit: define.Unit}
+	switch define.Type {
+	case "bool":
+		param.Type = dataschema.IoTPropertyTypeBool
+		param.Rule.TrueLabel = define.Mapping["1"]
+		param.Rule.FalseLabel = define.Mapping["0"]
+	case "int", "float":
+		param.Type = dataschema.IoTPropertyTypeInteger
+		if define.Type == "float" {
+			param.Type = dataschema.IoTPropertyTypeFloat
+			param.Rule.Round = 2
+		}
+		param.Rule.Min = int(define.MinValue())
+		param.Rule.Max = int(define.MaxValue())
+		if param.Rule.Max <= param.Rule.Min {
+			param.Rule.Min, param.Rule.Max = math.MinInt32, math.MaxInt32
+		}
+	case "string":
+		param.Type = dataschema.IoTPropertyTypeString
+		param.Rule.Max = int(define.MaxValue())
+		if param.Rule.Max <= 0 {
+			param.Rule.Max = 2048
+		}
+	case "enum":
+		param.Type = dataschema.IoTPropertyTypeEnum
+		for k, v := range define.Mapping {
+			if value, err := strconv.Atoi(k); err == nil {
+				param.Rule.Enums = append(param.Rule.Enums, dataschema.IoTEnumItem{Value: value, Label: v})
+			}
+		}
+		if len(param.Rule.Enums) == 0 {
+			return param, false
+		}
+		sort.Slice(param.Rule.Enums, func(i, j int) bool {
+			return param.Rule.Enums[i].Value < param.Rule.Enums[j].Value
+		})
+	case "timestamp":
+		param.Type = dataschema.IoTPropertyTypeTimestamp
+	case "struct":
+		param.Type = dataschema.IoTPropertyTypeStruct
+		for _, spec := range define.Specs {
+			field, ok := ToIoTParam(spec.Identifier, spec.Name, spec.DataType)
+			if !ok {
+				return param, false
+			}
+			param.Rule.Fields = append(param.Rule.Fields, field)
+		}
+		if len(param.Rule.Fields) == 0 {
+			return param, false
+		}
+	case "array":
+		if define.ArrayInfo == nil {
+			return param, false
+		}
+		element, ok := ToIoTParam("element", "", *define.ArrayInfo)
+		if !ok || element.Type == dataschema.IoTPropertyTypeArray {
+			return param, false
+		}
+		param.Type = dataschema.IoTPropertyTypeArray
+		param.Rule = element.Rule
+		param.Rule.ElementType = element.Type
+		param.Rule.Size = int(define.MaxValue())
+	default:
+		return param, false
+	}
+	return param, true
+}
+
+// 周期上报绑定设备的点位, 只报变化的值
+func (r *IthingsResource) reportLoop() {
+	ticker := time.NewTicker(time.Duration(r.config.ReportInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.client.IsConnected() {
+			r.setErrMsg(fmt.Errorf("iThings connection lost"))
+			r.setState(xmanager.MEDIA_DOWN)
+			return
+		}
+		for _, d := range r.config.SubDevices {
+			if d.DeviceUuid == "" {
+				continue
+			}
+			params := r.changedValues(d)
+			if len(params) == 0 {
+				continue
+			}
+			device := ithingsclient.SubDevice{ProductId: d.ProductId, DeviceName: d.DeviceName}
+			if err := r.client.ReportProperty(device, params); err != nil {
+				glogger.GLogger.Errorf("Ithings report %s/%s error: %s", d.ProductId, d.DeviceName, err)
+			}
+		}
+	}
+}
+
+func (r *IthingsResource) changedValues(d IthingsSubDeviceConfig) map[string]any {
+	values := r.pointValues(d)
+	key := d.ProductId + "/" + d.DeviceName
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	last, ok := r.last[key]
+	if !ok {
+		last = map[string]any{}
+		r.last[key] = last
+	}
+	params := map[string]any{}
+	for tag, value := range values {
+		if old, ok := last[tag]; ok && reflect.DeepEqual(old, value) {
+			continue
+		}
+		last[tag] = value
+		params[tag] = value
+	}
+	return params
+}
+
+// 点位表当前的值, 按物模型的类型转换
+func (r *IthingsResource) pointValues(d IthingsSubDeviceConfig) map[string]any {
+	values := map[string]any{}
+	points, err := service.DevicePointTags(d.DeviceUuid)
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return values
+	}
+	r.locker.Lock()
+	thingModel := r.models[d.ProductId]
+	r.locker.Unlock()
+	for _, point := range points {
+		if point.Tag == "" {
+			continue
+		}
+		cache := intercache.GetValue(d.DeviceUuid, point.UUID)
+		if cache.Status != 1 || cache.Value == nil {
+			continue
+		}
+		var define *ithingsclient.ThingDefine
+		if p, ok := thingModel.Property(point.Tag); ok {
+			define = &p.Define
+		}
+		values[point.Tag] = ithingsclient.ConvertValue(define, cache.Value)
+	}
+	return values
+}
+
+func (r *IthingsResource) bindDevice(device ithingsclient.SubDevice) (IthingsSubDeviceConfig, *typex.Device, error) {
+	d, ok := r.findSubDevice(device.ProductId, device.DeviceName)
+	if !ok || d.DeviceUuid == "" {
+		return d, nil, fmt.Errorf("device not bound: %s/%s", device.ProductId, device.DeviceName)
+	}
+	rhilexDevice := r.manager.Rhilex().GetDevice(d.DeviceUuid)
+	if rhilexDevice == nil || rhilexDevice.Device == nil {
+		return d, nil, fmt.Errorf("device not exists: %s", d.DeviceUuid)
+	}
+	if rhilexDevice.Device.Status() != typex.SOURCE_UP {
+		return d, nil, fmt.Errorf("device is not running: %s", d.DeviceUuid)
+	}
+	return d, rhilexDevice, nil
+}
+
+/*
+*
+* 属性下发: 绑定了点位的属性按绑定写, 其余属性按 tag 写点位
+*
+ */
+func (r *IthingsResource) onControl(device ithingsclient.SubDevice, params map[string]any) error {
+	d, rhilexDevice, err := r.bindDevice(device)
+	if err != nil {
+		return err
+	}
+	cmd := d.CtrlCommand
+	if cmd == "" {
+		cmd = "WriteToSheetRegisterWithTag"
+	}
+	schemaUuid := SchemaUuid(device.ProductId)
+	for tag, v := range params {
+		// 同步了物模型并且属性绑定了点位的按绑定写
+		if r.config.SyncSchema && dataschema.HasIoTBinding(schemaUuid, tag) {
+			if err := dataschema.WriteProperty(r.manager.Rhilex(), schemaUuid, tag, v); err != nil {
+				return fmt.Errorf("%s: %s", tag, err)
+			}
+			continue
+		}
+		value := fmt.Sprintf("%v", v)
+		if b, ok := v.(bool); ok {
+			value = "0"
+			if b {
+				value = "1"
+			}
+		}
+		args, _ := json.Marshal(map[string]string{"tag": tag, "value": value})
+		if _, err := rhilexDevice.Device.OnCtrl([]byte(cmd), args); err != nil {
+			return fmt.Errorf("%s: %s", tag, err)
+		}
+	}
+	return nil
+}
+
+// 云端主动获取: 返回点位表的当前值
+func (r *IthingsResource) onGetReport(device ithingsclient.SubDevice) map[string]any {
+	d, ok := r.findSubDevice(device.ProductId, device.DeviceName)
+	if !ok || d.DeviceUuid == "" {
+		return map[string]any{}
+	}
+	return r.pointValues(d)
+}
+
+/*
+*
+* 行为调用: 同步了物模型的按本地服务调用, 会检查输入输出参数;
+* 否则行为标识符作为设备指令, 参数原样传下去; 设备返回 JSON 对象就作为输出参数
+*
+ */
+func (r *IthingsResource) onAction(device ithingsclient.SubDevice, actionId string,
+	params map[string]any) (map[string]any, error) {
+	d, rhilexDevice, err := r.bindDevice(device)
+	if err != nil {
+		return nil, err
+	}
+	if r.config.SyncSchema {
+		result, err := dataschema.InvokeService(r.manager.Rhilex(), SchemaUuid(device.ProductId),
+			d.DeviceUuid, actionId, params)
+		if !errors.Is(err, dataschema.ErrIoTServiceNotExists) {
+			return result, err
+		}
+	}
+	args, _ := json.Marshal(params)
+	result, err := rhilexDevice.Device.OnCtrl([]byte(actionId), args)
+	if err != nil {
+		return nil, err
+	}
+	response := map[string]any{}
+	if len(result) > 0 {
+		if err := json.Unmarshal(result, &response); err != nil {
+			response = map[string]any{"result": string(result)}
+		}
+	}
+	return response, nil
+}
+
 // Status 获取Ithings资源状态
 func (r *IthingsResource) Status() xmanager.GatewayResourceState {
-	glogger.GLogger.Infof("Ithings resource %s status: %s", r.uuid, r.state)
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	glogger.GLogger.Debugf("Ithings resource %s status: %s", r.uuid, r.state)
 	return r.state
 }
 
 // Services 获取Ithings资源服务
 func (r *IthingsResource) Services() []xmanager.ResourceService {
-	services := []xmanager.ResourceService{}
+	services := []xmanager.ResourceService{
+		{
+			Name:        "ithings",
+			Method:      "ReportProperty",
+			Args:        []xmanager.ResourceServiceArg{{UUID: r.uuid, Args: []any{"productId", "deviceName", map[string]any{}}}},
+			Description: "上报属性",
+		},
+		{
+			Name:        "ithings",
+			Method:      "PostEvent",
+			Args:        []xmanager.ResourceServiceArg{{UUID: r.uuid, Args: []any{"productId", "deviceName", "eventId", "info", map[string]any{}}}},
+			Description: "上报事件",
+		},
+		{
+			Name:        "ithings",
+			Method:      "SyncSchema",
+			Description: "从云端同步物模型",
+		},
+		{
+			Name:        "ithings",
+			Method:      "GetSchema",
+			Description: "获取已同步的物模型",
+		},
+	}
 	return services
 }
 
 // OnService 处理Ithings资源服务请求
 func (r *IthingsResource) OnService(request xmanager.ResourceServiceRequest) (xmanager.ResourceServiceResponse, error) {
 	glogger.GLogger.Debugf("Ithings resource %s received service request: %+v", r.uuid, request)
-	return xmanager.ResourceServiceResponse{
-		Type:   "string",
-		Result: "ok",
-		Error:  nil,
-	}, nil
+	response := xmanager.ResourceServiceResponse{Type: "string", Result: "ok"}
+	args := []any{}
+	if len(request.Args) > 0 {
+		args = request.Args[0].Args
+	}
+	var err error
+	switch request.Method {
+	case "ReportProperty":
+		if len(args) < 3 {
+			err = fmt.Errorf("ReportProperty need args: productId, deviceName, params")
+			break
+		}
+		device, params := serviceDevice(args), serviceParams(args[2])
+		err = r.withClient(func(c *ithingsclient.Client) error { return c.ReportProperty(device, params) })
+	case "PostEvent":
+		if len(args) < 5 {
+			err = fmt.Errorf("PostEvent need args: productId, deviceName, eventId, type, params")
+			break
+		}
+		device, params := serviceDevice(args), serviceParams(args[4])
+		err = r.withClient(func(c *ithingsclient.Client) error {
+			return c.PostEvent(device, fmt.Sprint(args[2]), fmt.Sprint(args[3]), params)
+		})
+	case "SyncSchema":
+		err = r.withClient(func(c *ithingsclient.Client) error { return r.syncSchema() })
+	case "GetSchema":
+		r.locker.Lock()
+		models := map[string]ithingsclient.ThingModel{}
+		for k, v := range r.models {
+			models[k] = v
+		}
+		r.locker.Unlock()
+		response.Type, response.Result = "object", models
+	default:
+		err = fmt.Errorf("unsupported service: %s", request.Method)
+	}
+	response.Error = err
+	return response, err
+}
+
+func (r *IthingsResource) withClient(f func(c *ithingsclient.Client) error) error {
+	if r.client == nil || !r.client.IsConnected() {
+		return fmt.Errorf("iThings is not connected")
+	}
+	return f(r.client)
+}
+
+func serviceDevice(args []any) ithingsclient.SubDevice {
+	return ithingsclient.SubDevice{ProductId: fmt.Sprint(args[0]), DeviceName: fmt.Sprint(args[1])}
+}
+
+// 参数可以是对象也可以是 JSON 字符串
+func serviceParams(v any) map[string]any {
+	switch T := v.(type) {
+	case map[string]any:
+		return T
+	case string:
+		params := map[string]any{}
+		json.Unmarshal([]byte(T), &params)
+		return params
+	}
+	return map[string]any{}
 }
 
 // Details 获取Ithings资源详情
@@ -96,6 +694,15 @@ func (r *IthingsResource) Details() *xmanager.GatewayResourceWorker {
 
 // Stop 停止Ithings资源
 func (r *IthingsResource) Stop() {
-	r.state = xmanager.MEDIA_DOWN
+	if r.cancel != nil {
+		r.cancel()
+	}
+	if r.client != nil && r.client.IsConnected() {
+		if devices := r.subDevices(); len(devices) > 0 {
+			r.client.SetOnline(devices, false)
+		}
+		r.client.Disconnect()
+	}
+	r.setState(xmanager.MEDIA_STOP)
 	glogger.GLogger.Infof("Ithings resource %s stopped", r.uuid)
 }
@@ -0,0 +1,105 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ithings
+
+import (
+	"testing"
+
+	"github.com/hootrhino/rhilex/component/dataschema"
+	ithingsclient "github.com/hootrhino/rhilex/device/ithings"
+)
+
+// go test -timeout 30s -run ^Test_Ithings_To_IoTProperties github.com/hootrhino/rhilex/cecolla/ithings -v -count=1
+func Test_Ithings_To_IoTProperties(t *testing.T) {
+	thingModel, err := ithingsclient.ParseThingModel(`{"properties":[
+		{"identifier":"temp","name":"温度","mode":"r","define":{"type":"float","unit":"℃","min":"-40","max":"120"}},
+		{"identifier":"switch","name":"开关","mode":"rw","define":{"type":"bool","mapping":{"0":"关","1":"开"}}},
+		{"identifier":"mode","name":"模式","mode":"rw","define":{"type":"enum","mapping":{"0":"自动","1":"手动"}}},
+		{"identifier":"pos","name":"位置","mode":"r","define":{"type":"struct"}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := ToIoTProperties(thingModel)
+	if len(properties) != 3 {
+		t.Fatal("struct should be skipped", properties)
+	}
+	if properties[0].Type != "FLOAT" || properties[0].Rw != "R" ||
+		properties[0].Rule.Min != -40 || properties[0].Rule.Max != 120 {
+		t.Fatal("unexpected float property", properties[0])
+	}
+	if properties[1].Type != "BOOL" || properties[1].Rw != "RW" || properties[1].Rule.TrueLabel != "开" {
+		t.Fatal("unexpected bool property", properties[1])
+	}
+	if properties[2].Type != "ENUM" || len(properties[2].Rule.Enums) != 2 ||
+		properties[2].Rule.Enums[1].Label != "手动" {
+		t.Fatal("unexpected enum property", properties[2])
+	}
+	for _, p := range properties {
+		if err := p.HoldValidator(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if uuid := SchemaUuid("a-b.c1"); uuid != "SCHEMA_ITHINGS_a_b_c1" {
+		t.Fatal("unexpected schema uuid", uuid)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Ithings_To_IoTFunctions github.com/hootrhino/rhilex/cecolla/ithings -v -count=1
+func Test_Ithings_To_IoTFunctions(t *testing.T) {
+	thingModel, err := ithingsclient.ParseThingModel(`{
+		"properties":[
+			{"identifier":"pos","name":"位置","mode":"r","define":{"type":"struct","specs":[
+				{"identifier":"lat","name":"纬度","dataType":{"type":"float","min":"-90","max":"90"}},
+				{"identifier":"lng","name":"经度","dataType":{"type":"float","min":"-180","max":"180"}}]}},
+			{"identifier":"history","name":"历史","mode":"r","define":{"type":"array","max":"5",
+				"arrayInfo":{"type":"int","min":"0","max":"100"}}}],
+		"events":[
+			{"identifier":"overheat","name":"过热","type":"alert","params":[
+				{"identifier":"temp","name":"温度","define":{"type":"float"}}]},
+			{"identifier":"weird","name":"未知","type":"info","params":[
+				{"identifier":"raw","name":"原始","define":{"type":"struct"}}]}],
+		"actions":[
+			{"identifier":"reboot","name":"重启","input":[
+				{"identifier":"delay","name":"延时","define":{"type":"int","min":"0","max":"60"}}],
+				"output":[{"identifier":"at","name":"时间","define":{"type":"timestamp"}}]}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := ToIoTProperties(thingModel)
+	if len(properties) != 2 || properties[0].Type != "STRUCT" || len(properties[0].Rule.Fields) != 2 ||
+		properties[1].Type != "ARRAY" || properties[1].Rule.ElementType != "INTEGER" || properties[1].Rule.Size != 5 {
+		t.Fatal("unexpected properties", properties)
+	}
+	for _, p := range properties {
+		if err := p.HoldValidator(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := ToIoTEvents(thingModel)
+	if len(events) != 1 || events[0].Severity != dataschema.IoTEventSeverityAlert {
+		t.Fatal("unexpected events", events)
+	}
+	services := ToIoTServices(thingModel)
+	if len(services) != 1 || services[0].Name != "reboot" || services[0].Outputs[0].Type != "TIMESTAMP" {
+		t.Fatal("unexpected services", services)
+	}
+	if err := events[0].HoldValidator(); err != nil {
+		t.Fatal(err)
+	}
+	if err := services[0].HoldValidator(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,548 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package thingsboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/dataschema"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/xmanager"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+// ThingsBoardResourceConfig ThingsBoard 网关配置
+type ThingsBoardResourceConfig struct {
+	Host           string   `json:"host" validate:"required"`
+	Port           int      `json:"port" validate:"required"`
+	AccessToken    string   `json:"accessToken" validate:"required"` // 网关设备的令牌
+	ClientId       string   `json:"clientId"`
+	DeviceType     string   `json:"deviceType"`     // 子设备在 ThingsBoard 上的设备配置, 默认 rhilex
+	ReportInterval int      `json:"reportInterval"` // 遥测周期, 毫秒
+	Devices        []string `json:"devices"`        // 只接入这些设备, 空的时候接入全部
+	CtrlCommand    string   `json:"ctrlCommand"`    // 共享属性写点位的指令, 默认 WriteToSheetRegisterWithTag
+	SchemaId       string   `json:"schemaId"`       // 数据模型, RPC 优先按模型里的服务调用
+}
+
+// 已经接入 ThingsBoard 的子设备
+type tbDevice struct {
+	UUID  string
+	Name  string
+	State typex.SourceState
+	last  map[string]any // 上次上报的遥测
+}
+
+// ThingsBoardResource ThingsBoard 网关
+type ThingsBoardResource struct {
+	manager *xmanager.GatewayResourceManager
+	state   xmanager.GatewayResourceState
+	uuid    string
+	config  ThingsBoardResourceConfig
+	client  mqtt.Client
+	locker  sync.Mutex
+	devices map[string]*tbDevice // ThingsBoard 设备名 -> 子设备
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewThingsBoardResource 创建 ThingsBoard 网关
+func NewThingsBoardResource(manager *xmanager.GatewayResourceManager) (xmanager.GatewayResource, error) {
+	return &ThingsBoardResource{
+		state:   xmanager.MEDIA_PENDING,
+		config:  ThingsBoardResourceConfig{},
+		manager: manager,
+		devices: map[string]*tbDevice{},
+	}, nil
+}
+
+// Init 初始化
+func (r *ThingsBoardResource) Init(uuid string, configMap map[string]any) error {
+	r.uuid = uuid
+	if err := xmanager.MapToConfig(configMap, &r.config); err != nil {
+		return err
+	}
+	if r.config.ClientId == "" {
+		r.config.ClientId = "rhilex-" + uuid
+	}
+	if r.config.DeviceType == "" {
+		r.config.DeviceType = "rhilex"
+	}
+	if r.config.ReportInterval <= 0 {
+		r.config.ReportInterval = 5000
+	}
+	if r.config.CtrlCommand == "" {
+		r.config.CtrlCommand = "WriteToSheetRegisterWithTag"
+	}
+	r.state = xmanager.MEDIA_PENDING
+	glogger.GLogger.Infof("ThingsBoard resource %s initialized, server: %s:%d",
+		uuid, r.config.Host, r.config.Port)
+	return nil
+}
+
+/*
+*
+* 启动: 用网关令牌登录, 订阅 RPC 和共享属性, 然后把设备都接入进去;
+* 连不上的时候置为 DOWN, 让监控器去重连
+*
+ */
+func (r *ThingsBoardResource) Start(ctx context.Context) error {
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("tcp://%s:%v", r.config.Host, r.config.Port))
+	opts.SetClientID(r.config.ClientId)
+	opts.SetUsername(r.config.AccessToken)
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false)
+	opts.SetConnectTimeout(5 * time.Second)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		glogger.GLogger.Infof("ThingsBoard resource %s connected", r.uuid)
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		glogger.GLogger.Warn("ThingsBoard connect lost:", err)
+	})
+	r.client = mqtt.NewClient(opts)
+	if err := r.connect(); err != nil {
+		glogger.GLogger.Error("ThingsBoard resource start failed:", err)
+		r.setErrMsg(err)
+		r.setState(xmanager.MEDIA_DOWN)
+		return nil
+	}
+	intercache.DeleteValue("__DefaultRuleEngine", r.uuid)
+	r.setState(xmanager.MEDIA_UP)
+	go r.reportLoop()
+	glogger.GLogger.Infof("ThingsBoard resource %s started", r.uuid)
+	return nil
+}
+
+func (r *ThingsBoardResource) connect() error {
+	if token := r.client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	for _, topic := range []string{TOPIC_RPC, TOPIC_ATTRIBUTES} {
+		if token := r.client.Subscribe(topic, 1, r.onMessage); token.Wait() && token.Error() != nil {
+			r.client.Disconnect(100)
+			return token.Error()
+		}
+	}
+	return r.announce()
+}
+
+func (r *ThingsBoardResource) setState(state xmanager.GatewayResourceState) {
+	r.locker.Lock()
+	r.state = state
+	r.locker.Unlock()
+}
+
+func (r *ThingsBoardResource) setErrMsg(err error) {
+	intercache.SetValue("__DefaultRuleEngine", r.uuid, intercache.CacheValue{
+		UUID:          r.uuid,
+		Status:        1,
+		ErrMsg:        err.Error(),
+		LastFetchTime: uint64(time.Now().UnixMilli()),
+		Value:         "",
+	})
+}
+
+func (r *ThingsBoardResource) publish(topic string, v any) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	token := r.client.Publish(topic, 1, false, bytes)
+	if !token.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("publish timeout: %s", topic)
+	}
+	return token.Error()
+}
+
+// 需要接入的设备
+func (r *ThingsBoardResource) rhilexDevices() []*typex.Device {
+	devices := []*typex.Device{}
+	for _, device := range r.manager.Rhilex().AllDevices() {
+		if len(r.config.Devices) == 0 || utils.SContains(r.config.Devices, device.UUID) {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+/*
+*
+* 设备接入: 新设备 connect 并上报一次客户端属性, 已经删除的设备 disconnect;
+* 状态变了也更新属性
+*
+ */
+func (r *ThingsBoardResource) announce() error {
+	current := map[string]*typex.Device{}
+	for _, device := range r.rhilexDevices() {
+		current[device.Name] = device
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for name := range r.devices {
+		if _, ok := current[name]; !ok {
+			if err := r.publish(TOPIC_DISCONNECT, ConnectMessage{Device: name}); err != nil {
+				return err
+			}
+			delete(r.devices, name)
+		}
+	}
+	attributes := AttributesMessage{}
+	for name, device := range current {
+		state := device.State
+		if device.Device != nil {
+			state = device.Device.Status()
+		}
+		old, ok := r.devices[name]
+		if !ok || old.UUID != device.UUID {
+			if err := r.publish(TOPIC_CONNECT, ConnectMessage{Device: name, Type: r.config.DeviceType}); err != nil {
+				return err
+			}
+			r.devices[name] = &tbDevice{UUID: device.UUID, Name: name, State: state, last: map[string]any{}}
+		} else if old.State == state {
+			continue
+		}
+		r.devices[name].State = state
+		attributes[name] = map[string]any{
+			"rhilexUuid": device.UUID,
+			"rhilexType": string(device.Type),
+			"online":     state == typex.SOURCE_UP,
+		}
+	}
+	if len(attributes) > 0 {
+		return r.publish(TOPIC_ATTRIBUTES, attributes)
+	}
+	return nil
+}
+
+func (r *ThingsBoardResource) reportLoop() {
+	ticker := time.NewTicker(time.Duration(r.config.ReportInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.client.IsConnectionOpen() {
+			r.setErrMsg(fmt.Errorf("ThingsBoard connection lost"))
+			r.setState(xmanager.MEDIA_DOWN)
+			return
+		}
+		if err := r.announce(); err != nil {
+			glogger.GLogger.Error("ThingsBoard announce error:", err)
+			continue
+		}
+		if err := r.reportTelemetry(); err != nil {
+			glogger.GLogger.Error("ThingsBoard telemetry error:", err)
+		}
+	}
+}
+
+// 遥测: 点位表的 tag 作为 key, 只报变化的值
+func (r *ThingsBoardResource) reportTelemetry() error {
+	r.locker.Lock()
+	devices := []*tbDevice{}
+	for _, device := range r.devices {
+		devices = append(devices, device)
+	}
+	r.locker.Unlock()
+	telemetry := TelemetryMessage{}
+	ts := time.Now().UnixMilli()
+	for _, device := range devices {
+		values := r.changedValues(device)
+		if len(values) > 0 {
+			telemetry[device.Name] = []TelemetryValues{{Ts: ts, Values: values}}
+		}
+	}
+	if len(telemetry) == 0 {
+		return nil
+	}
+	return r.publish(TOPIC_TELEMETRY, telemetry)
+}
+
+func (r *ThingsBoardResource) changedValues(device *tbDevice) map[string]any {
+	values := map[string]any{}
+	points, err := service.DevicePointTags(device.UUID)
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return values
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for _, point := range points {
+		if point.Tag == "" {
+			continue
+		}
+		cache := intercache.GetValue(device.UUID, point.UUID)
+		if cache.Status != 1 || cache.Value == nil {
+			continue
+		}
+		value := utils.ParsePointValue(cache.Value)
+		if last, ok := device.last[point.Tag]; ok && reflect.DeepEqual(last, value) {
+			continue
+		}
+		device.last[point.Tag] = value
+		values[point.Tag] = value
+	}
+	return values
+}
+
+/*
+*
+* 服务端下发: RPC 交给设备 OnCtrl, 方法名就是指令;
+* 共享属性更新按 tag 写点位
+*
+ */
+func (r *ThingsBoardResource) onMessage(client mqtt.Client, m mqtt.Message) {
+	msg := DownlinkMessage{}
+	if err := json.Unmarshal(m.Payload(), &msg); err != nil {
+		glogger.GLogger.Error("ThingsBoard invalid message:", m.Topic(), err)
+		return
+	}
+	// 自己上报的属性和 RPC 回复也在这两个 Topic 上, 没有 device 的不是下发
+	if msg.Device == "" {
+		return
+	}
+	// 设备调用可能比较慢, 不能阻塞 MQTT 的收包
+	switch m.Topic() {
+	case TOPIC_RPC:
+		request := RpcRequest{}
+		if err := json.Unmarshal(msg.Data, &request); err != nil {
+			glogger.GLogger.Error("ThingsBoard invalid RPC:", err)
+			return
+		}
+		if request.Method == "" {
+			return
+		}
+		go r.onRpc(msg.Device, request)
+	case TOPIC_ATTRIBUTES:
+		attributes := map[string]any{}
+		if err := json.Unmarshal(msg.Data, &attributes); err != nil {
+			glogger.GLogger.Error("ThingsBoard invalid attributes:", err)
+			return
+		}
+		go func() {
+			if err := r.onAttributes(msg.Device, attributes); err != nil {
+				glogger.GLogger.Errorf("ThingsBoard attributes %s error: %s", msg.Device, err)
+			}
+		}()
+	}
+}
+
+func (r *ThingsBoardResource) onRpc(name string, request RpcRequest) {
+	response := RpcResponse{Device: name, Id: request.Id}
+	result, err := r.invokeService(name, request)
+	if errors.Is(err, dataschema.ErrIoTServiceNotExists) {
+		var bytes []byte
+		bytes, err = r.ctrlDevice(name, []byte(request.Method), rpcArgs(request.Params))
+		result = rpcResult(bytes)
+	}
+	if err != nil {
+		response.Data = map[string]any{"success": false, "error": err.Error()}
+	} else {
+		response.Data = map[string]any{"success": true, "result": result}
+	}
+	if err := r.publish(TOPIC_RPC, response); err != nil {
+		glogger.GLogger.Error("ThingsBoard RPC reply error:", err)
+	}
+}
+
+// 配置了数据模型的时候 RPC 参数必须是对象, 按模型里的同名服务调用
+func (r *ThingsBoardResource) invokeService(name string, request RpcRequest) (any, error) {
+	if r.config.SchemaId == "" {
+		return nil, dataschema.ErrIoTServiceNotExists
+	}
+	if _, ok := dataschema.GetIoTServiceCache(r.config.SchemaId, request.Method); !ok {
+		return nil, dataschema.ErrIoTServiceNotExists
+	}
+	r.locker.Lock()
+	device, ok := r.devices[name]
+	r.locker.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("device not connected: %s", name)
+	}
+	params := map[string]any{}
+	if len(request.Params) > 0 && string(request.Params) != "null" {
+		if err := json.Unmarshal(request.Params, &params); err != nil {
+			return nil, fmt.Errorf("service params must be object: %s", err)
+		}
+	}
+	return dataschema.InvokeService(r.manager.Rhilex(), r.config.SchemaId, device.UUID, request.Method, params)
+}
+
+func (r *ThingsBoardResource) onAttributes(name string, attributes map[string]any) error {
+	for tag, v := range attributes {
+		// 模型里绑定了点位的属性按绑定写
+		if r.config.SchemaId != "" && dataschema.HasIoTBinding(r.config.SchemaId, tag) {
+			if err := dataschema.WriteProperty(r.manager.Rhilex(), r.config.SchemaId, tag, v); err != nil {
+				return fmt.Errorf("%s: %s", tag, err)
+			}
+			continue
+		}
+		value := fmt.Sprintf("%v", v)
+		if b, ok := v.(bool); ok {
+			value = "0"
+			if b {
+				value = "1"
+			}
+		}
+		args, _ := json.Marshal(map[string]string{"tag": tag, "value": value})
+		if _, err := r.ctrlDevice(name, []byte(r.config.CtrlCommand), args); err != nil {
+			return fmt.Errorf("%s: %s", tag, err)
+		}
+	}
+	return nil
+}
+
+func (r *ThingsBoardResource) ctrlDevice(name string, cmd []byte, args []byte) ([]byte, error) {
+	r.locker.Lock()
+	device, ok := r.devices[name]
+	r.locker.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("device not connected: %s", name)
+	}
+	rhilexDevice := r.manager.Rhilex().GetDevice(device.UUID)
+	if rhilexDevice == nil || rhilexDevice.Device == nil {
+		return nil, fmt.Errorf("device not exists: %s", device.UUID)
+	}
+	if rhilexDevice.Device.Status() != typex.SOURCE_UP {
+		return nil, fmt.Errorf("device is not running: %s", name)
+	}
+	return rhilexDevice.Device.OnCtrl(cmd, args)
+}
+
+// Status 状态
+func (r *ThingsBoardResource) Status() xmanager.GatewayResourceState {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.state
+}
+
+// Services 服务
+func (r *ThingsBoardResource) Services() []xmanager.ResourceService {
+	return []xmanager.ResourceService{
+		{
+			Name:        "thingsboard",
+			Method:      "Telemetry",
+			Args:        []xmanager.ResourceServiceArg{{UUID: r.uuid, Args: []any{"deviceName", map[string]any{}}}},
+			Description: "上报遥测",
+		},
+		{
+			Name:        "thingsboard",
+			Method:      "Attributes",
+			Args:        []xmanager.ResourceServiceArg{{UUID: r.uuid, Args: []any{"deviceName", map[string]any{}}}},
+			Description: "上报客户端属性",
+		},
+		{
+			Name:        "thingsboard",
+			Method:      "Devices",
+			Description: "已经接入的设备",
+		},
+	}
+}
+
+// OnService 处理服务请求
+func (r *ThingsBoardResource) OnService(request xmanager.ResourceServiceRequest) (xmanager.ResourceServiceResponse, error) {
+	glogger.GLogger.Debugf("ThingsBoard resource %s received service request: %+v", r.uuid, request)
+	response := xmanager.ResourceServiceResponse{Type: "string", Result: "ok"}
+	args := []any{}
+	if len(request.Args) > 0 {
+		args = request.Args[0].Args
+	}
+	var err error
+	switch request.Method {
+	case "Telemetry", "Attributes":
+		if len(args) < 2 {
+			err = fmt.Errorf("%s need args: deviceName, values", request.Method)
+			break
+		}
+		if r.client == nil || !r.client.IsConnectionOpen() {
+			err = fmt.Errorf("ThingsBoard is not connected")
+			break
+		}
+		name, values := fmt.Sprint(args[0]), serviceValues(args[1])
+		if request.Method == "Telemetry" {
+			err = r.publish(TOPIC_TELEMETRY, TelemetryMessage{name: {{Ts: time.Now().UnixMilli(), Values: values}}})
+		} else {
+			err = r.publish(TOPIC_ATTRIBUTES, AttributesMessage{name: values})
+		}
+	case "Devices":
+		r.locker.Lock()
+		names := []string{}
+		for name := range r.devices {
+			names = append(names, name)
+		}
+		r.locker.Unlock()
+		sort.Strings(names)
+		response.Type, response.Result = "array", names
+	default:
+		err = fmt.Errorf("unsupported service: %s", request.Method)
+	}
+	response.Error = err
+	return response, err
+}
+
+// 参数可以是对象也可以是 JSON 字符串
+func serviceValues(v any) map[string]any {
+	switch T := v.(type) {
+	case map[string]any:
+		return T
+	case string:
+		values := map[string]any{}
+		json.Unmarshal([]byte(T), &values)
+		return values
+	}
+	return map[string]any{}
+}
+
+// Details 详情
+func (r *ThingsBoardResource) Details() *xmanager.GatewayResourceWorker {
+	if r.manager == nil {
+		return nil
+	}
+	worker, _ := r.manager.GetResource(r.uuid)
+	return worker
+}
+
+// Stop 停止: 子设备下线以后断开
+func (r *ThingsBoardResource) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	if r.client != nil && r.client.IsConnectionOpen() {
+		r.locker.Lock()
+		for name := range r.devices {
+			r.publish(TOPIC_DISCONNECT, ConnectMessage{Device: name})
+		}
+		r.devices = map[string]*tbDevice{}
+		r.locker.Unlock()
+		r.client.Disconnect(100)
+	}
+	r.setState(xmanager.MEDIA_STOP)
+	glogger.GLogger.Infof("ThingsBoard resource %s stopped", r.uuid)
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package thingsboard
+
+import (
+	"encoding/json"
+)
+
+// ThingsBoard Gateway MQTT API
+const (
+	TOPIC_CONNECT             = "v1/gateway/connect"
+	TOPIC_DISCONNECT          = "v1/gateway/disconnect"
+	TOPIC_TELEMETRY           = "v1/gateway/telemetry"
+	TOPIC_ATTRIBUTES          = "v1/gateway/attributes"
+	TOPIC_ATTRIBUTES_REQUEST  = "v1/gateway/attributes/request"
+	TOPIC_ATTRIBUTES_RESPONSE = "v1/gateway/attributes/response"
+	TOPIC_RPC                 = "v1/gateway/rpc"
+)
+
+// 子设备上线: {"device":"Device A","type":"rhilex"}
+type ConnectMessage struct {
+	Device string `json:"device"`
+	Type   string `json:"type,omitempty"`
+}
+
+// 遥测: {"Device A":[{"ts":1483228800000,"values":{"temperature":42}}]}
+type TelemetryMessage map[string][]TelemetryValues
+
+type TelemetryValues struct {
+	Ts     int64          `json:"ts"`
+	Values map[string]any `json:"values"`
+}
+
+// 客户端属性: {"Device A":{"attribute1":"value1"}}
+type AttributesMessage map[string]map[string]any
+
+/*
+*
+* 服务端下发: 共享属性更新 {"device":"Device A","data":{"attribute1":"value1"}},
+* RPC {"device":"Device A","data":{"id":1,"method":"toggle","params":{}}}
+*
+ */
+type DownlinkMessage struct {
+	Device string          `json:"device"`
+	Data   json.RawMessage `json:"data"`
+}
+
+type RpcRequest struct {
+	Id     int64           `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// RPC 回复: {"device":"Device A","id":1,"data":{"success":true}}
+type RpcResponse struct {
+	Device string         `json:"device"`
+	Id     int64          `json:"id"`
+	Data   map[string]any `json:"data"`
+}
+
+// RPC 参数原样交给设备; 字符串参数去掉 JSON 的引号
+func rpcArgs(params json.RawMessage) []byte {
+	var s string
+	if err := json.Unmarshal(params, &s); err == nil {
+		return []byte(s)
+	}
+	if len(params) == 0 || string(params) == "null" {
+		return []byte{}
+	}
+	return params
+}
+
+// 设备返回 JSON 就原样回复, 否则作为字符串
+func rpcResult(result []byte) any {
+	var v any
+	if err := json.Unmarshal(result, &v); err == nil {
+		return v
+	}
+	return string(result)
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package thingsboard
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hootrhino/rhilex/cecolla/cecollatest"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/xmanager"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+// go test -timeout 30s -run ^Test_ThingsBoard_Gateway github.com/hootrhino/rhilex/cecolla/thingsboard -v -count=1
+func Test_ThingsBoard_Gateway(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	intercache.InitGlobalValueRegistry(nil)
+	intercache.RegisterSlot("__DefaultRuleEngine")
+	server, port, received := cecollatest.StartBroker(t, nil, "v1/gateway/#")
+	device := cecollatest.NewFakeDevice()
+	engine := &cecollatest.FakeRhilex{Devices: []*typex.Device{
+		{UUID: "DEVICE1", Name: "Boiler", Type: "GENERIC_MODBUS_MASTER", Device: device},
+	}}
+	resource, _ := NewThingsBoardResource(xmanager.NewGatewayResourceManager(engine))
+	if err := resource.Init("TB1", map[string]any{
+		"host": "127.0.0.1", "port": port, "accessToken": "token", "reportInterval": 60000,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := resource.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if resource.Status() != xmanager.MEDIA_UP {
+		t.Fatal("resource should be up")
+	}
+	connect := ConnectMessage{}
+	json.Unmarshal(cecollatest.WaitTopic(t, received, TOPIC_CONNECT), &connect)
+	if connect.Device != "Boiler" || connect.Type != "rhilex" {
+		t.Fatal("unexpected connect", connect)
+	}
+	attributes := AttributesMessage{}
+	json.Unmarshal(cecollatest.WaitTopic(t, received, TOPIC_ATTRIBUTES), &attributes)
+	if attributes["Boiler"]["rhilexUuid"] != "DEVICE1" || attributes["Boiler"]["online"] != true {
+		t.Fatal("unexpected attributes", attributes)
+	}
+	// 服务端 RPC
+	server.Publish(TOPIC_RPC, []byte(`{"device":"Boiler","data":{"id":7,"method":"setMode","params":{"mode":2}}}`), false, 1)
+	if ctrl := <-device.Ctrl; ctrl != `setMode:{"mode":2}` {
+		t.Fatal("unexpected ctrl", ctrl)
+	}
+	response := RpcResponse{}
+	json.Unmarshal(cecollatest.WaitTopic(t, received, TOPIC_RPC), &response)
+	if response.Device != "Boiler" || response.Id != 7 || response.Data["success"] != true {
+		t.Fatal("unexpected rpc response", response)
+	}
+	server.Publish(TOPIC_RPC, []byte(`{"device":"Boiler","data":{"id":8,"method":"fail"}}`), false, 1)
+	<-device.Ctrl
+	response = RpcResponse{}
+	json.Unmarshal(cecollatest.WaitTopic(t, received, TOPIC_RPC), &response)
+	if response.Id != 8 || response.Data["success"] != false {
+		t.Fatal("failed rpc should reply error", response)
+	}
+	// 共享属性写点位
+	server.Publish(TOPIC_ATTRIBUTES, []byte(`{"device":"Boiler","data":{"setpoint":true}}`), false, 1)
+	if ctrl := <-device.Ctrl; ctrl != `WriteToSheetRegisterWithTag:{"tag":"setpoint","value":"1"}` {
+		t.Fatal("unexpected ctrl", ctrl)
+	}
+	// 设备删除以后下线
+	engine.Devices = nil
+	if err := resource.(*ThingsBoardResource).announce(); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(cecollatest.WaitTopic(t, received, TOPIC_DISCONNECT), &connect)
+	if connect.Device != "Boiler" {
+		t.Fatal("unexpected disconnect", connect)
+	}
+	resource.Stop()
+	if utils.ParsePointValue("21.5") != 21.5 || utils.ParsePointValue("true") != true || utils.ParsePointValue("on") != "on" {
+		t.Fatal("unexpected telemetry value")
+	}
+	// NaN 和 Inf 不能转成 JSON 数字
+	for _, s := range []string{"NaN", "nan", "Inf", "-Infinity", "+inf"} {
+		if utils.ParsePointValue(s) != s {
+			t.Fatal("should keep as string:", s)
+		}
+	}
+	if _, err := json.Marshal(TelemetryValues{Values: map[string]any{"v": utils.ParsePointValue("NaN")}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,13 +1,17 @@
 package apis
 
 import (
+	"encoding/json"
 	"fmt"
 
 	common "github.com/hootrhino/rhilex/component/apiserver/common"
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/server"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/appletbundle"
 	"github.com/hootrhino/rhilex/component/luaruntime"
+	"github.com/hootrhino/rhilex/component/mailbox"
+	"github.com/hootrhino/rhilex/component/streamwindow"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hootrhino/rhilex/applet"
@@ -26,6 +30,13 @@ func InitAppletRoute() {
 		appApi.PUT(("/start"), server.AddRoute(StartApp))
 		appApi.PUT(("/stop"), server.AddRoute(StopApp))
 		appApi.GET(("/detail"), server.AddRoute(AppDetail))
+		appApi.GET(("/config"), server.AddRoute(GetAppConfig))
+		appApi.PUT(("/config"), server.AddRoute(UpdateAppConfig))
+		appApi.POST(("/mailbox"), server.AddRoute(SendAppMail))
+		appApi.POST(("/bundle/install"), server.AddRoute(InstallAppBundle))
+		appApi.PUT(("/bundle/upgrade"), server.AddRoute(UpgradeAppBundle))
+		appApi.GET(("/bundle/export"), server.AddRoute(ExportAppBundle))
+		appApi.GET(("/bundle/trustedKeys"), server.AddRoute(AppBundleTrustedKeys))
 	}
 }
 
@@ -35,14 +46,58 @@ func InitAppletRoute() {
 *
  */
 type AppletDto struct {
-	UUID        string `json:"uuid,omitempty"` // 名称
-	Name        string `json:"name"`           // 名称
-	Version     string `json:"version"`        // 版本号
-	AutoStart   *bool  `json:"autoStart"`      // 自动启动
-	AppState    int    `json:"appState"`       // 状态: 1 运行中, 0 停止
-	Type        string `json:"type"`           // 默认就是lua, 留个扩展以后可能支持别的
-	LuaSource   string `json:"luaSource"`      // Lua源码
-	Description string `json:"description"`
+	UUID          string            `json:"uuid,omitempty"` // 名称
+	Name          string            `json:"name"`           // 名称
+	Version       string            `json:"version"`        // 版本号
+	AutoStart     *bool             `json:"autoStart"`      // 自动启动
+	AppState      int               `json:"appState"`       // 状态: 1 运行中, 0 停止, 2 等待定时或者重启
+	Type          string            `json:"type"`           // 默认就是lua, 留个扩展以后可能支持别的
+	LuaSource     string            `json:"luaSource"`      // Lua源码
+	Description   string            `json:"description"`
+	RestartPolicy string            `json:"restartPolicy"` // never|on-failure|always
+	Schedule      string            `json:"schedule"`      // 定时执行的 cron 表达式
+	Quota         applet.AppQuota   `json:"quota"`         // 资源配额
+	Config        map[string]any    `json:"config"`        // 应用配置, 只在创建的时候生效, 修改用 /app/config
+	Status        *applet.AppStatus `json:"status,omitempty"`
+	Mailbox       *mailbox.Stats    `json:"mailbox,omitempty"`
+}
+
+// 数据库记录转成 VO, 带上内存里的运行状态
+func appletDto(mApp *model.MApplet) AppletDto {
+	dto := AppletDto{
+		UUID:          mApp.UUID,
+		Name:          mApp.Name,
+		Version:       mApp.Version,
+		AutoStart:     mApp.AutoStart,
+		Type:          "lua",
+		Description:   mApp.Description,
+		RestartPolicy: mApp.RestartPolicy,
+		Schedule:      mApp.Schedule,
+	}
+	if mApp.Quota != "" {
+		json.Unmarshal([]byte(mApp.Quota), &dto.Quota)
+	}
+	if a := applet.GetApp(mApp.UUID); a != nil {
+		dto.AppState = int(a.AppState)
+		status := a.Status()
+		dto.Status = &status
+	}
+	if stats, ok := mailbox.GetStats(mApp.UUID); ok {
+		dto.Mailbox = &stats
+	}
+	return dto
+}
+
+// 生命周期配置存库的格式
+func appletLifecycle(form AppletDto) (string, error) {
+	if err := applet.ValidateLifecycle(form.RestartPolicy, form.Schedule, form.Quota); err != nil {
+		return "", err
+	}
+	quota, err := json.Marshal(form.Quota)
+	if err != nil {
+		return "", err
+	}
+	return string(quota), nil
 }
 
 /*
@@ -58,20 +113,10 @@ func AppDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400EmptyObj(err1))
 		return
 	}
-	web_data := AppletDto{
-		UUID:      appInfo.UUID,
-		Name:      appInfo.Name,
-		Version:   appInfo.Version,
-		AutoStart: appInfo.AutoStart,
-		Type:      "lua",
-		AppState: func() int {
-			if a := applet.GetApp(appInfo.UUID); a != nil {
-				return int(a.AppState)
-			}
-			return 0
-		}(),
-		Description: appInfo.Description,
-		LuaSource:   appInfo.LuaSource,
+	web_data := appletDto(appInfo)
+	web_data.LuaSource = appInfo.LuaSource
+	if appInfo.Config != "" {
+		json.Unmarshal([]byte(appInfo.Config), &web_data.Config)
 	}
 	c.JSON(common.HTTP_OK, common.OkWithData(web_data))
 }
@@ -80,21 +125,7 @@ func AppDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 func Apps(c *gin.Context, ruleEngine typex.Rhilex) {
 	result := []AppletDto{}
 	for _, mApp := range service.AllApp() {
-		web_data := AppletDto{
-			UUID:      mApp.UUID,
-			Name:      mApp.Name,
-			Version:   mApp.Version,
-			AutoStart: mApp.AutoStart,
-			Type:      "lua",
-			AppState: func() int {
-				if a := applet.GetApp(mApp.UUID); a != nil {
-					return int(a.AppState)
-				}
-				return 0
-			}(),
-			Description: mApp.Description,
-		}
-		result = append(result, web_data)
+		result = append(result, appletDto(&mApp))
 	}
 	c.JSON(common.HTTP_OK, common.OkWithData(result))
 }
@@ -129,6 +160,19 @@ func CreateApp(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error(r))
 		return
 	}
+	quota, err := appletLifecycle(form)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if form.Config == nil {
+		form.Config = map[string]any{}
+	}
+	config, err := json.Marshal(form.Config)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	newUUID := utils.AppUuid()
 	mAPP := &model.MApplet{
 		UUID:    newUUID,
@@ -136,18 +180,19 @@ func CreateApp(c *gin.Context, ruleEngine typex.Rhilex) {
 		Version: form.Version,
 		LuaSource: fmt.Sprintf(luaTemplate,
 			newUUID, form.Name, form.Version, form.Description, defaultLuaMain),
-		AutoStart:   form.AutoStart,
-		Description: form.Description,
+		AutoStart:     form.AutoStart,
+		Description:   form.Description,
+		RestartPolicy: form.RestartPolicy,
+		Schedule:      form.Schedule,
+		Quota:         quota,
+		Config:        string(config),
 	}
 	if err := service.InsertApp(mAPP); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
 	// 立即加载但是不运行，主要是要加入内存
-	newAPP := applet.NewApplication(newUUID, form.Name, form.Version)
-	newAPP.AutoStart = *form.AutoStart
-	newAPP.Description = form.Description
-	if err := applet.LoadApp(newAPP, mAPP.LuaSource); err != nil {
+	if _, err := server.LoadApplet(mAPP); err != nil {
 		glogger.GLogger.Error("app Load failed:", err)
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -182,6 +227,11 @@ func UpdateApp(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err1))
 		return
 	}
+	quota, err := appletLifecycle(form)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	mApp := model.MApplet{
 		UUID:        form.UUID,
 		Name:        form.Name,
@@ -194,20 +244,27 @@ func UpdateApp(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	if err := service.UpdateAppLifecycle(form.UUID, form.RestartPolicy,
+		form.Schedule, quota); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	// 如果内存里面有, 先把内存里的清理了
 	if app := applet.GetApp(form.UUID); app != nil {
 		glogger.GLogger.Debug("Already loaded, will try to stop:", form.UUID)
 		// 已经启动了就不能再启动
-		if app.AppState == 1 {
+		if app.AppState != applet.APP_STOPPED {
 			applet.StopApp(form.UUID)
 		}
 		applet.RemoveApp(app.UUID)
 	}
-	// 必须先load后start
-	newAPP := applet.NewApplication(mApp.UUID, mApp.Name, mApp.Version)
-	newAPP.AutoStart = *mApp.AutoStart
-	newAPP.Description = mApp.Description
-	if err := applet.LoadApp(newAPP, mApp.LuaSource); err != nil {
+	// 必须先load后start, 重新读一下库里完整的记录
+	newMApp, err := service.GetMAppWithUUID(form.UUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if _, err := server.LoadApplet(newMApp); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
@@ -243,10 +300,10 @@ func StartApp(c *gin.Context, ruleEngine typex.Rhilex) {
 	if app := applet.GetApp(uuid); app != nil {
 		glogger.GLogger.Debug("Already loaded, will try to start:", uuid)
 		// 已经启动了就不能再启动
-		if app.AppState == 1 {
+		if app.AppState != applet.APP_STOPPED {
 			c.JSON(common.HTTP_OK, common.Error400(fmt.Errorf("app is running now:%s", uuid)))
 		}
-		if app.AppState == 0 {
+		if app.AppState == applet.APP_STOPPED {
 			if err := applet.StartApp(uuid); err != nil {
 				c.JSON(common.HTTP_OK, common.Error400(err))
 			} else {
@@ -257,8 +314,7 @@ func StartApp(c *gin.Context, ruleEngine typex.Rhilex) {
 	}
 	// 如果内存里面没有，尝试从配置加载
 	glogger.GLogger.Debug("No loaded, will try to load:", uuid)
-	if err := applet.LoadApp(applet.NewApplication(
-		mApp.UUID, mApp.Name, mApp.Version), mApp.LuaSource); err != nil {
+	if _, err := server.LoadApplet(mApp); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
@@ -274,11 +330,11 @@ func StartApp(c *gin.Context, ruleEngine typex.Rhilex) {
 func StopApp(c *gin.Context, ruleEngine typex.Rhilex) {
 	uuid, _ := c.GetQuery("uuid")
 	if app := applet.GetApp(uuid); app != nil {
-		if app.AppState == 0 {
+		if app.AppState == applet.APP_STOPPED {
 			c.JSON(common.HTTP_OK, common.Error400(fmt.Errorf("app is stopping now:%s", uuid)))
 			return
 		}
-		if app.AppState == 1 {
+		if app.AppState != applet.APP_STOPPED {
 			if err := applet.StopApp(uuid); err != nil {
 				c.JSON(common.HTTP_OK, common.Error400(err))
 				return
@@ -307,5 +363,85 @@ func RemoveApp(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	streamwindow.Purge(uuid)
+	appletbundle.RemoveFiles(uuid)
 	c.JSON(common.HTTP_OK, common.OkWithData(fmt.Sprintf("remove app successfully:%s", uuid)))
 }
+
+/*
+*
+* 应用配置: Lua 里是全局的 AppConfig 表, 修改以后下一次执行 Main 生效
+*
+ */
+func GetAppConfig(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	mApp, err := service.GetMAppWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	config := map[string]any{}
+	if mApp.Config != "" {
+		if err := json.Unmarshal([]byte(mApp.Config), &config); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(config))
+}
+
+type AppConfigVo struct {
+	UUID   string         `json:"uuid" binding:"required"`
+	Config map[string]any `json:"config"`
+}
+
+func UpdateAppConfig(c *gin.Context, ruleEngine typex.Rhilex) {
+	form := AppConfigVo{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if _, err := service.GetMAppWithUUID(form.UUID); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if form.Config == nil {
+		form.Config = map[string]any{}
+	}
+	config, err := json.Marshal(form.Config)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := service.UpdateAppConfig(form.UUID, string(config)); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if app := applet.GetApp(form.UUID); app != nil {
+		app.Config = form.Config
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 从接口给应用发信, 发件人是 RHILEX
+*
+ */
+type AppMailVo struct {
+	UUID string `json:"uuid" binding:"required"`
+	Data any    `json:"data"`
+}
+
+func SendAppMail(c *gin.Context, ruleEngine typex.Rhilex) {
+	form := AppMailVo{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := mailbox.Send(form.UUID, "RHILEX", form.Data); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
@@ -0,0 +1,366 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hootrhino/rhilex/applet"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/appletbundle"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+var bundleVersionRegexp = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+/*
+*
+* 应用包的基本信息, 安装和升级以后返回
+*
+ */
+type AppletBundleVo struct {
+	UUID     string                `json:"uuid"`
+	Manifest appletbundle.Manifest `json:"manifest"`
+	Signer   string                `json:"signer"` // 签名的公钥 ID, 没有签名为空
+}
+
+// 读取上传的应用包, 表单字段 file
+func readBundleForm(c *gin.Context, ruleEngine typex.Rhilex) (*appletbundle.Bundle, error) {
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, appletbundle.MaxBundleSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > appletbundle.MaxBundleSize {
+		return nil, fmt.Errorf("bundle too large, max %d bytes", appletbundle.MaxBundleSize)
+	}
+	bundle, err := appletbundle.ReadTrusted(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := bundle.CheckSyntax(); err != nil {
+		return nil, err
+	}
+	if err := bundle.Manifest.CheckRequirements(ruleEngine.CheckDeviceType); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// 表单里的 config 字段, JSON, 覆盖清单里的默认配置
+func bundleFormConfig(c *gin.Context, base map[string]any) (map[string]any, error) {
+	config := map[string]any{}
+	for k, v := range base {
+		config[k] = v
+	}
+	if s := c.Request.FormValue("config"); s != "" {
+		override := map[string]any{}
+		if err := json.Unmarshal([]byte(s), &override); err != nil {
+			return nil, fmt.Errorf("invalid config: %s", err)
+		}
+		for k, v := range override {
+			config[k] = v
+		}
+	}
+	return config, nil
+}
+
+/*
+*
+* 安装应用包: 新建一个应用, 入口脚本存库, 其他文件存到应用包目录
+*
+ */
+func InstallAppBundle(c *gin.Context, ruleEngine typex.Rhilex) {
+	bundle, err := readBundleForm(c, ruleEngine)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	config, err := bundleFormConfig(c, bundle.Manifest.DefaultConfig)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := bundle.Manifest.ValidateConfig(config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	configBytes, _ := json.Marshal(config)
+	manifestBytes, _ := json.Marshal(bundle.Manifest)
+	quotaBytes, _ := json.Marshal(bundle.Manifest.Quota)
+	autoStart := bundle.Manifest.AutoStart
+	newUUID := utils.AppUuid()
+	mApp := &model.MApplet{
+		UUID:          newUUID,
+		Name:          bundle.Manifest.Name,
+		Version:       bundle.Manifest.Version,
+		LuaSource:     bundle.MainSource(),
+		AutoStart:     &autoStart,
+		Description:   bundle.Manifest.Description,
+		RestartPolicy: bundle.Manifest.RestartPolicy,
+		Schedule:      bundle.Manifest.Schedule,
+		Quota:         string(quotaBytes),
+		Config:        string(configBytes),
+		Manifest:      string(manifestBytes),
+		Signer:        bundle.Signer,
+	}
+	if err := appletbundle.SaveFiles(newUUID, bundle.Files); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := service.InsertApp(mApp); err != nil {
+		appletbundle.RemoveFiles(newUUID)
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if _, err := server.LoadApplet(mApp); err != nil {
+		glogger.GLogger.Error("app Load failed:", err)
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if autoStart {
+		if err := applet.StartApp(newUUID); err != nil {
+			glogger.GLogger.Error("App autoStart failed:", err)
+		}
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(AppletBundleVo{
+		UUID:     newUUID,
+		Manifest: bundle.Manifest,
+		Signer:   bundle.Signer,
+	}))
+}
+
+/*
+*
+* 升级应用包: 名称要一样, 版本要更高(force 可以降级或者重装);
+* 已有的配置保留, 新版本多出来的配置项用默认值; 重启策略这些用户改过的不动
+*
+ */
+func UpgradeAppBundle(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid := c.Request.FormValue("uuid")
+	force := c.Request.FormValue("force") == "true"
+	mApp, err := service.GetMAppWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	bundle, err := readBundleForm(c, ruleEngine)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	// 不是从包安装的应用按记录里的名称比, 不然随便一个包都能把它换掉
+	installedName := mApp.Name
+	if mApp.Manifest != "" {
+		current := appletbundle.Manifest{}
+		if err := json.Unmarshal([]byte(mApp.Manifest), &current); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		installedName = current.Name
+		// 装的时候签过名的, 升级包也要有签名
+		if mApp.Signer != "" && bundle.Signer == "" {
+			c.JSON(common.HTTP_OK, common.Error400(fmt.Errorf("installed bundle is signed, upgrade must be signed too")))
+			return
+		}
+	}
+	if installedName != bundle.Manifest.Name {
+		c.JSON(common.HTTP_OK, common.Error400(fmt.Errorf("bundle name mismatch: %s, installed: %s",
+			bundle.Manifest.Name, installedName)))
+		return
+	}
+	if !force && appletbundle.CompareVersion(bundle.Manifest.Version, mApp.Version) <= 0 {
+		c.JSON(common.HTTP_OK, common.Error400(fmt.Errorf("bundle version %s is not newer than %s",
+			bundle.Manifest.Version, mApp.Version)))
+		return
+	}
+	currentConfig := map[string]any{}
+	if mApp.Config != "" {
+		json.Unmarshal([]byte(mApp.Config), &currentConfig)
+	}
+	config, err := bundleFormConfig(c, bundle.Manifest.MergeConfig(currentConfig))
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := bundle.Manifest.ValidateConfig(config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	manifestBytes, _ := json.Marshal(bundle.Manifest)
+	configBytes, _ := json.Marshal(config)
+	newMApp := *mApp
+	newMApp.Name = bundle.Manifest.Name
+	newMApp.Version = bundle.Manifest.Version
+	newMApp.LuaSource = bundle.MainSource()
+	newMApp.Description = bundle.Manifest.Description
+	newMApp.Manifest = string(manifestBytes)
+	newMApp.Signer = bundle.Signer
+	newMApp.Config = string(configBytes)
+	// 文件先写到临时目录, 库里的记录一个事务改完, 都成功了才换下正在跑的应用
+	if err := appletbundle.StageFiles(uuid, bundle.Files); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := service.UpgradeAppBundle(&newMApp); err != nil {
+		appletbundle.DiscardStaged(uuid)
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	running := false
+	if app := applet.GetApp(uuid); app != nil {
+		running = app.AppState != applet.APP_STOPPED
+		if running {
+			applet.StopApp(uuid)
+		}
+		applet.RemoveApp(uuid)
+	}
+	if err := appletbundle.SwapFiles(uuid); err != nil {
+		appletbundle.DiscardStaged(uuid)
+		rollbackAppUpgrade(mApp, running, false)
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := loadApplet(&newMApp, newMApp.AutoStart != nil && *newMApp.AutoStart); err != nil {
+		glogger.GLogger.Error("App upgrade failed, restore:", uuid, ", ", err)
+		rollbackAppUpgrade(mApp, running, true)
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	appletbundle.DropBackup(uuid)
+	c.JSON(common.HTTP_OK, common.OkWithData(AppletBundleVo{
+		UUID:     uuid,
+		Manifest: bundle.Manifest,
+		Signer:   bundle.Signer,
+	}))
+}
+
+// 加载应用, start 为真的时候加载完启动
+func loadApplet(mApp *model.MApplet, start bool) error {
+	if _, err := server.LoadApplet(mApp); err != nil {
+		return err
+	}
+	if start {
+		return applet.StartApp(mApp.UUID)
+	}
+	return nil
+}
+
+/*
+*
+* 升级失败, 换回旧的记录和文件, 原来在跑的重新跑起来
+*
+ */
+func rollbackAppUpgrade(mApp *model.MApplet, running, restoreFiles bool) {
+	if applet.GetApp(mApp.UUID) != nil {
+		applet.StopApp(mApp.UUID)
+		applet.RemoveApp(mApp.UUID)
+	}
+	if err := service.UpgradeAppBundle(mApp); err != nil {
+		glogger.GLogger.Error("App upgrade rollback failed:", mApp.UUID, ", ", err)
+	}
+	if restoreFiles {
+		if err := appletbundle.RestoreFiles(mApp.UUID); err != nil {
+			glogger.GLogger.Error("App upgrade rollback failed:", mApp.UUID, ", ", err)
+		}
+	}
+	if err := loadApplet(mApp, running); err != nil {
+		glogger.GLogger.Error("App upgrade rollback failed:", mApp.UUID, ", ", err)
+	}
+}
+
+/*
+*
+* 导出应用包, 不签名; 不是从包安装的应用按库里的记录生成清单,
+* 当前配置作为默认配置
+*
+ */
+func ExportAppBundle(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	mApp, err := service.GetMAppWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	config := map[string]any{}
+	if mApp.Config != "" {
+		json.Unmarshal([]byte(mApp.Config), &config)
+	}
+	manifest := appletbundle.Manifest{}
+	if mApp.Manifest != "" {
+		if err := json.Unmarshal([]byte(mApp.Manifest), &manifest); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+	} else {
+		manifest.Main = "main.lua"
+		manifest.DefaultConfig = config
+	}
+	manifest.Name = mApp.Name
+	manifest.Version = mApp.Version
+	if !bundleVersionRegexp.MatchString(manifest.Version) {
+		manifest.Version = "1.0.0"
+	}
+	manifest.Description = mApp.Description
+	manifest.RestartPolicy = mApp.RestartPolicy
+	manifest.Schedule = mApp.Schedule
+	if mApp.AutoStart != nil {
+		manifest.AutoStart = *mApp.AutoStart
+	}
+	if mApp.Quota != "" {
+		json.Unmarshal([]byte(mApp.Quota), &manifest.Quota)
+	}
+	files, err := appletbundle.LoadFiles(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	files[manifest.Main] = []byte(mApp.LuaSource)
+	buffer := bytes.Buffer{}
+	if err := appletbundle.Write(&buffer, &appletbundle.Bundle{
+		Manifest: manifest,
+		Files:    files,
+	}, nil); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%s-%s.zip",
+		manifest.Name, manifest.Version))
+	c.Data(common.HTTP_OK, "application/zip", buffer.Bytes())
+}
+
+// 受信任的公钥和是否强制签名
+func AppBundleTrustedKeys(c *gin.Context, ruleEngine typex.Rhilex) {
+	config := ruleEngine.GetConfig()
+	c.JSON(common.HTTP_OK, common.OkWithData(map[string]any{
+		"keys":             appletbundle.TrustedKeyIds(),
+		"requireSignature": config != nil && config.AppletRequireSignature,
+	}))
+}
@@ -3,6 +3,7 @@ package apis
 import (
 	"fmt"
 
+	"github.com/hootrhino/rhilex/cecolla"
 	common "github.com/hootrhino/rhilex/component/apiserver/common"
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/server"
@@ -10,6 +11,7 @@ import (
 	"github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/interdb"
 	"github.com/hootrhino/rhilex/component/luaruntime"
+	"github.com/hootrhino/rhilex/component/xmanager"
 	"gorm.io/gorm"
 
 	"github.com/hootrhino/rhilex/typex"
@@ -34,6 +36,8 @@ func InitCecollaRoute() {
 		cecollaApi.PUT("/restart", server.AddRoute(RestartCecolla))
 		cecollaApi.GET("/cecollaErrMsg", server.AddRoute(GetCecollaErrorMsg))
 		cecollaApi.GET("/cecollaSchema", server.AddRoute(GetCecollaSchema))
+		cecollaApi.GET("/services", server.AddRoute(GetCecollaServices))
+		cecollaApi.POST("/service", server.AddRoute(CallCecollaService))
 	}
 }
 
@@ -92,7 +96,7 @@ func ListCecolla(c *gin.Context, ruleEngine typex.Rhilex) {
 		CecollaVo.Action = mCecolla.Action
 		CecollaVo.Description = mCecolla.Description
 		CecollaVo.Config = mCecolla.GetConfig()
-		CecollaVo.State = int(typex.SOURCE_STOP)
+		CecollaVo.State = int(cecollaState(mCecolla.UUID))
 		Group := service.GetResourceGroup(mCecolla.UUID)
 		CecollaVo.Gid = Group.UUID
 
@@ -125,7 +129,7 @@ func ListCecollaByGroup(c *gin.Context, ruleEngine typex.Rhilex) {
 		CecollaVo.Action = mCecolla.Action
 		CecollaVo.Description = mCecolla.Description
 		CecollaVo.Config = mCecolla.GetConfig()
-		CecollaVo.State = int(typex.SOURCE_STOP)
+		CecollaVo.State = int(cecollaState(mCecolla.UUID))
 		Group := service.GetResourceGroup(mCecolla.UUID)
 		CecollaVo.Gid = Group.UUID
 
@@ -136,8 +140,36 @@ func ListCecollaByGroup(c *gin.Context, ruleEngine typex.Rhilex) {
 	c.JSON(common.HTTP_OK, common.OkWithData(Result))
 }
 
+// 运行时的状态; 没加载的就是停止
+func cecollaState(uuid string) typex.SourceState {
+	state, err := cecolla.GetCecollaResourceStatus(uuid)
+	if err != nil {
+		return typex.SOURCE_STOP
+	}
+	if state == xmanager.MEDIA_UP {
+		return typex.SOURCE_UP
+	}
+	return typex.SOURCE_DOWN
+}
+
+// 从数据库重新加载
+func loadCecolla(uuid string) error {
+	mCecolla, err := service.GetMCecollaWithUUID(uuid)
+	if err != nil {
+		return err
+	}
+	cecolla.StopCecollaResource(uuid)
+	return cecolla.LoadCecollaResource(mCecolla.UUID, mCecolla.Name,
+		mCecolla.Type, mCecolla.GetConfig(), mCecolla.Description)
+}
+
 // 重启
 func RestartCecolla(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if err := loadCecolla(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	c.JSON(common.HTTP_OK, common.Ok())
 }
 
@@ -168,6 +200,8 @@ func DeleteCecolla(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(txErr))
 		return
 	}
+	cecolla.StopCecollaResource(uuid)
+	intercache.DeleteValue("__DefaultRuleEngine", uuid)
 	c.JSON(common.HTTP_OK, common.Ok())
 }
 
@@ -224,7 +258,10 @@ end
 		c.JSON(common.HTTP_OK, common.Errorf("Group not found:%s", form.Gid))
 		return
 	}
-
+	if err := loadCecolla(MCecolla.UUID); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	c.JSON(common.HTTP_OK, common.Ok())
 
 }
@@ -298,7 +335,10 @@ func UpdateCecolla(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(txErr))
 		return
 	}
-
+	if err := loadCecolla(form.UUID); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	c.JSON(common.HTTP_OK, common.Ok())
 }
 
@@ -325,5 +365,59 @@ func GetCecollaErrorMsg(c *gin.Context, ruleEngine typex.Rhilex) {
  *
  */
 func GetCecollaSchema(c *gin.Context, ruleEngine typex.Rhilex) {
-	c.JSON(common.HTTP_OK, common.Ok())
+	uuid, _ := c.GetQuery("uuid")
+	response, err := callCecollaService(uuid, xmanager.ResourceServiceRequest{Method: "GetSchema"})
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(response.Result))
+}
+
+func callCecollaService(uuid string, request xmanager.ResourceServiceRequest) (xmanager.ResourceServiceResponse, error) {
+	worker, err := cecolla.GetCecollaResourceDetails(uuid)
+	if err != nil {
+		return xmanager.ResourceServiceResponse{}, err
+	}
+	return worker.Worker.OnService(request)
+}
+
+/**
+ * 资源支持的服务
+ *
+ */
+func GetCecollaServices(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	worker, err := cecolla.GetCecollaResourceDetails(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(worker.Worker.Services()))
+}
+
+/**
+ * 调用资源服务, 比如手动上报属性, 同步物模型
+ *
+ */
+func CallCecollaService(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		UUID   string `json:"uuid" binding:"required"`
+		Method string `json:"method" binding:"required"`
+		Args   []any  `json:"args"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	response, err := callCecollaService(form.UUID, xmanager.ResourceServiceRequest{
+		Method: form.Method,
+		Args:   []xmanager.ResourceServiceArg{{UUID: form.UUID, Args: form.Args}},
+	})
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(response.Result))
 }
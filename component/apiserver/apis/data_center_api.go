@@ -18,6 +18,7 @@ package apis
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -30,7 +31,6 @@ import (
 	"github.com/hootrhino/rhilex/datacenter"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
-	"github.com/xuri/excelize/v2"
 	"gorm.io/gorm"
 )
 
@@ -61,6 +61,15 @@ func InitDataCenterApi() {
 	datacenterApi.GET("/exportData", server.AddRoute(ExportData))
 	datacenterApi.GET("/schemaDDLDefine", server.AddRoute(GetSchemaDDLDefine))
 	datacenterApi.DELETE("/clearSchemaData", server.AddRoute(ClearSchemaData))
+	datacenterApi.GET("/queryAggregate", server.AddRoute(QueryDDLAggregate))
+	datacenterApi.GET("/query", server.AddRoute(QueryDDLData))
+	datacenterApi.GET("/retention", server.AddRoute(GetRetentionPolicy))
+	datacenterApi.PUT("/retention", server.AddRoute(SetRetentionPolicy))
+	datacenterApi.POST("/sync/create", server.AddRoute(CreateDataCenterSync))
+	datacenterApi.PUT("/sync/update", server.AddRoute(UpdateDataCenterSync))
+	datacenterApi.DELETE("/sync/del", server.AddRoute(DeleteDataCenterSync))
+	datacenterApi.GET("/sync/list", server.AddRoute(ListDataCenterSync))
+	datacenterApi.PUT("/sync/reset", server.AddRoute(ResetDataCenterSync))
 }
 
 /*
@@ -135,83 +144,52 @@ func SchemaDDLDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 
 /*
 *
-* 导出, ids[]
+* 查询参数: select, where, start, end, groupBy, func, order
+*
+ */
+func readDataQuery(c *gin.Context) (datacenter.DataQuery, error) {
+	selectFields, _ := c.GetQueryArray("select")
+	return datacenter.DataQueryOptions{
+		Select:  selectFields,
+		Where:   c.Query("where"),
+		Start:   c.Query("start"),
+		End:     c.Query("end"),
+		GroupBy: c.Query("groupBy"),
+		Func:    c.Query("func"),
+		Order:   c.Query("order"),
+	}.Parse()
+}
+
+/*
+*
+* 导出, 可以带查询参数只导出一部分; format: xlsx(默认), csv, parquet
 *
  */
 func ExportData(c *gin.Context, ruleEngine typex.Rhilex) {
 	uuid, _ := c.GetQuery("uuid")
-	TableSchemas, err := service.GetTableSchema(uuid) // PRAGMA table_info
+	format := c.DefaultQuery("format", "xlsx")
+	switch format {
+	case "xlsx", "csv", "parquet":
+	default:
+		c.JSON(common.HTTP_OK, common.Error("Unsupported export format:"+format))
+		return
+	}
+	Query, err := readDataQuery(c)
 	if err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
-	Headers := []string{}
-	OneRowNCol := make([]any, len(TableSchemas))
-	for i, TableSchema := range TableSchemas {
-		Headers = append(Headers, TableSchema.Name)
-		switch TableSchema.Type {
-		case "INTEGER":
-			OneRowNCol[i] = new(int)
-		case "BOOLEAN":
-			OneRowNCol[i] = new(bool)
-		case "DATETIME":
-			OneRowNCol[i] = new(string)
-		case "TIMESTAMP":
-			OneRowNCol[i] = new(string)
-		case "TEXT":
-			OneRowNCol[i] = new(string)
-		case "REAL":
-			OneRowNCol[i] = new(float32)
-		default:
-			OneRowNCol[i] = new(string) // 不知道啥类型就String
-		}
-	}
-
-	xlsx := excelize.NewFile()
-	defer func() {
-		if err := xlsx.Close(); err != nil {
-			glogger.GLogger.Errorf("close excel file, err=%v", err)
-		}
-	}()
-	cell, _ := excelize.CoordinatesToCellName(1, 1)
-	xlsx.SetSheetRow("Sheet1", cell, &Headers)
-	tableName := fmt.Sprintf("data_center_%s", uuid)
-	rows, Error := datacenter.DataCenterDb().Table(tableName).Rows()
-	if Error != nil {
-		c.JSON(common.HTTP_OK, common.Error400(Error))
+	Result, err := datacenter.QueryData(uuid, Query)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
-	idx := 0
-	for rows.Next() {
-		if err := rows.Scan(OneRowNCol...); err != nil {
-			c.JSON(common.HTTP_OK, common.Error400(err))
-			return
-		}
-		cell, _ = excelize.CoordinatesToCellName(1, idx+2)
-		SheetRow := []any{}
-		for _, Column := range OneRowNCol {
-			switch T := Column.(type) {
-			case *bool:
-				SheetRow = append(SheetRow, *T)
-			case *int:
-				SheetRow = append(SheetRow, *T)
-			case *float32:
-				SheetRow = append(SheetRow, *T)
-			case *string:
-				SheetRow = append(SheetRow, *T)
-			default:
-				SheetRow = append(SheetRow, "NULL") // 不支持的类型
-			}
-		}
-		xlsx.SetSheetRow("Sheet1", cell, &SheetRow)
-		idx++
-	}
-
 	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%v.xlsx",
-		time.Now().UnixMilli()))
-	xlsx.WriteTo(c.Writer)
-	c.JSON(common.HTTP_OK, common.Ok())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%v.%s",
+		time.Now().UnixMilli(), format))
+	if err := datacenter.ExportResult(c.Writer, format, Result); err != nil {
+		glogger.GLogger.Errorf("export data, err=%v", err)
+	}
 }
 
 /*
@@ -235,6 +213,13 @@ func ClearSchemaData(c *gin.Context, ruleEngine typex.Rhilex) {
 				return err
 			}
 		}
+		// 汇总表一起清空
+		for _, tier := range datacenter.RollupTiers {
+			err := tx.Exec(fmt.Sprintf(`DELETE FROM "%s";`, tier.Table(uuid))).Error
+			if err != nil && !strings.Contains(err.Error(), "no such table") {
+				return err
+			}
+		}
 		return nil
 	})
 	if TxDbError != nil {
@@ -322,6 +307,56 @@ func QueryDDLDataList(c *gin.Context, ruleEngine typex.Rhilex) {
 	c.JSON(common.HTTP_OK, common.OkWithData(Result))
 }
 
+/*
+*
+* 条件查询: 过滤表达式, 时间范围, 选列, 按时间分组, 分页
+*
+ */
+func QueryDDLData(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	secret, _ := c.GetQuery("secret")
+	if !datacenter.CheckSecrets(secret) {
+		c.JSON(common.HTTP_OK, common.Error("Expect api secret"))
+		return
+	}
+	pager, err := service.ReadPageRequest(c)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if pager.Size < 1 || pager.Size > 100 {
+		c.JSON(common.HTTP_OK, common.Error("Query size must between 1 and 100"))
+		return
+	}
+	MSchema, err := service.GetDataSchemaWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if !*MSchema.Published {
+		c.JSON(common.HTTP_OK, common.Error("The schema must be published before it can be operated"))
+		return
+	}
+	Query, err := readDataQuery(c)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	Query.Limit = pager.Size
+	Query.Offset = (pager.Current - 1) * pager.Size
+	Result, err := datacenter.QueryData(uuid, Query)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	count, err := datacenter.CountData(uuid, Query)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(service.WrapPageResult(*pager, Result.Rows, count)))
+}
+
 /*
 *
 * 最新数据
@@ -360,6 +395,98 @@ func QueryDDLLastData(c *gin.Context, ruleEngine typex.Rhilex) {
 	c.JSON(common.HTTP_OK, common.OkWithData(record))
 }
 
+/*
+*
+* 聚合查询: start, end 是本地时间(2006-01-02 15:04:05), 默认最近一天;
+* interval 是时间段(1m, 1h, 1d ...), func 是 min|max|avg|count, 默认 avg
+*
+ */
+func QueryDDLAggregate(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	secret, _ := c.GetQuery("secret")
+	if !datacenter.CheckSecrets(secret) {
+		c.JSON(common.HTTP_OK, common.Error("Expect api secret"))
+		return
+	}
+	MSchema, err := service.GetDataSchemaWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if !*MSchema.Published {
+		c.JSON(common.HTTP_OK, common.Error("The schema must be published before it can be operated"))
+		return
+	}
+	selectFields, _ := c.GetQueryArray("select")
+	Query := datacenter.AggregateQuery{
+		Fields:   selectFields,
+		End:      time.Now(),
+		Interval: time.Hour,
+		Func:     c.DefaultQuery("func", "avg"),
+	}
+	if end, ok := c.GetQuery("end"); ok {
+		if Query.End, err = datacenter.ParseTime(end); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+	}
+	Query.Start = Query.End.Add(-24 * time.Hour)
+	if start, ok := c.GetQuery("start"); ok {
+		if Query.Start, err = datacenter.ParseTime(start); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+	}
+	if interval, ok := c.GetQuery("interval"); ok {
+		if Query.Interval, err = datacenter.ParseInterval(interval); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+	}
+	records, err := datacenter.QueryAggregate(uuid, Query)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(records))
+}
+
+/*
+*
+* 保留策略
+*
+ */
+type RetentionPolicyVo struct {
+	UUID string `json:"uuid"`
+	datacenter.RetentionPolicy
+}
+
+func GetRetentionPolicy(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	c.JSON(common.HTTP_OK, common.OkWithData(RetentionPolicyVo{
+		UUID:            uuid,
+		RetentionPolicy: datacenter.GetRetentionPolicy(uuid),
+	}))
+}
+
+func SetRetentionPolicy(c *gin.Context, ruleEngine typex.Rhilex) {
+	RetentionPolicyVo := RetentionPolicyVo{}
+	if err := c.ShouldBindJSON(&RetentionPolicyVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if _, err := service.GetDataSchemaWithUUID(RetentionPolicyVo.UUID); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := datacenter.SetRetentionPolicy(RetentionPolicyVo.UUID,
+		RetentionPolicyVo.RetentionPolicy); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
 /*
 *
 * 获取定义
@@ -0,0 +1,193 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/datacenter"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+type DataCenterSyncVo struct {
+	UUID        string  `json:"uuid"`
+	SchemaId    string  `json:"schemaId" validate:"required"`
+	OutEndId    string  `json:"outEndId" validate:"required"`
+	BatchSize   int     `json:"batchSize"`
+	Interval    int     `json:"interval"` // 秒
+	Enable      *bool   `json:"enable"`
+	FromStart   bool    `json:"fromStart"` // 新建时是否把表里已有的数据也推出去
+	Description string  `json:"description"`
+	LastId      int64   `json:"lastId"`
+	Synced      int64   `json:"synced"`
+	LastSyncAt  int64   `json:"lastSyncAt"`
+	LastError   string  `json:"lastError"`
+	Pending     int64   `json:"pending"`
+	Lag         float64 `json:"lag"` // 秒
+}
+
+func (O DataCenterSyncVo) model() datacenter.MDataCenterSync {
+	return datacenter.MDataCenterSync{
+		UUID:        O.UUID,
+		SchemaId:    O.SchemaId,
+		OutEndId:    O.OutEndId,
+		BatchSize:   O.BatchSize,
+		Interval:    O.Interval,
+		Enable:      O.Enable,
+		Description: O.Description,
+	}
+}
+
+func syncJobVo(job datacenter.MDataCenterSync) DataCenterSyncVo {
+	status := datacenter.GetSyncStatus(job)
+	return DataCenterSyncVo{
+		UUID:        job.UUID,
+		SchemaId:    job.SchemaId,
+		OutEndId:    job.OutEndId,
+		BatchSize:   job.BatchSize,
+		Interval:    job.Interval,
+		Enable:      job.Enable,
+		Description: job.Description,
+		LastId:      job.LastId,
+		Synced:      job.Synced,
+		LastSyncAt:  job.LastSyncAt,
+		LastError:   job.LastError,
+		Pending:     status.Pending,
+		Lag:         status.Lag,
+	}
+}
+
+func checkSyncTarget(ruleEngine typex.Rhilex, vo DataCenterSyncVo) error {
+	schema, err := service.GetDataSchemaWithUUID(vo.SchemaId)
+	if err != nil {
+		return err
+	}
+	if schema.Published == nil || !*schema.Published {
+		return fmt.Errorf("schema not published: %s", vo.SchemaId)
+	}
+	return datacenter.CheckSyncOutEnd(ruleEngine, vo.OutEndId)
+}
+
+/*
+*
+* 新建同步任务
+*
+ */
+func CreateDataCenterSync(c *gin.Context, ruleEngine typex.Rhilex) {
+	form := DataCenterSyncVo{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := checkSyncTarget(ruleEngine, form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	job, err := datacenter.CreateSyncJob(form.model(), form.FromStart)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(syncJobVo(job)))
+}
+
+/*
+*
+* 更新同步任务, 不动同步进度
+*
+ */
+func UpdateDataCenterSync(c *gin.Context, ruleEngine typex.Rhilex) {
+	form := DataCenterSyncVo{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	old, err := datacenter.GetSyncJob(form.UUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	form.SchemaId = old.SchemaId
+	if err := checkSyncTarget(ruleEngine, form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := datacenter.UpdateSyncJob(form.model()); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+func DeleteDataCenterSync(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := datacenter.GetSyncJob(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := datacenter.DeleteSyncJob(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 同步任务列表, 带积压行数和延迟; schemaId 为空列出全部
+*
+ */
+func ListDataCenterSync(c *gin.Context, ruleEngine typex.Rhilex) {
+	schemaId, _ := c.GetQuery("schemaId")
+	jobs, err := datacenter.AllSyncJobs(schemaId)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	vos := []DataCenterSyncVo{}
+	for _, job := range jobs {
+		vos = append(vos, syncJobVo(job))
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(vos))
+}
+
+/*
+*
+* 重设同步进度, lastId=0 从头重推
+*
+ */
+func ResetDataCenterSync(c *gin.Context, ruleEngine typex.Rhilex) {
+	form := struct {
+		UUID   string `json:"uuid"`
+		LastId int64  `json:"lastId"`
+	}{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if _, err := datacenter.GetSyncJob(form.UUID); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := datacenter.ResetSyncJob(form.UUID, form.LastId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
@@ -40,6 +40,25 @@ func InitDataSchemaApi() {
 		schemaApi.DELETE(("/properties/del"), server.AddRoute(DeleteIotSchemaProperty))
 		schemaApi.GET(("/properties/list"), server.AddRoute(IotSchemaPropertyPageList))
 		schemaApi.GET(("/properties/detail"), server.AddRoute(IotSchemaPropertyDetail))
+		schemaApi.GET(("/properties/values"), server.AddRoute(IotSchemaPropertyValues))
+		schemaApi.POST(("/properties/write"), server.AddRoute(WriteIotSchemaProperty))
+		// 点位绑定
+		schemaApi.POST(("/bindings/create"), server.AddRoute(CreateIotSchemaBinding))
+		schemaApi.PUT(("/bindings/update"), server.AddRoute(UpdateIotSchemaBinding))
+		schemaApi.DELETE(("/bindings/del"), server.AddRoute(DeleteIotSchemaBinding))
+		schemaApi.GET(("/bindings/list"), server.AddRoute(ListIotSchemaBinding))
+		// 事件
+		schemaApi.POST(("/events/create"), server.AddRoute(CreateIotSchemaEvent))
+		schemaApi.PUT(("/events/update"), server.AddRoute(UpdateIotSchemaEvent))
+		schemaApi.DELETE(("/events/del"), server.AddRoute(DeleteIotSchemaEvent))
+		schemaApi.GET(("/events/list"), server.AddRoute(ListIotSchemaEvent))
+		schemaApi.POST(("/events/emit"), server.AddRoute(EmitIotSchemaEvent))
+		// 服务
+		schemaApi.POST(("/services/create"), server.AddRoute(CreateIotSchemaService))
+		schemaApi.PUT(("/services/update"), server.AddRoute(UpdateIotSchemaService))
+		schemaApi.DELETE(("/services/del"), server.AddRoute(DeleteIotSchemaService))
+		schemaApi.GET(("/services/list"), server.AddRoute(ListIotSchemaService))
+		schemaApi.POST(("/services/invoke"), server.AddRoute(InvokeIotSchemaService))
 		// 模板
 		schemaApi.GET(("/getTemplates"), server.AddRoute(GetTemplates))
 		schemaApi.GET(("/getTemplateFields"), server.AddRoute(GetTemplateFields))
@@ -83,11 +102,28 @@ type IoTPropertyRuleVo struct {
 	TrueLabel    string `json:"trueLabel"`    // 真值label
 	FalseLabel   string `json:"falseLabel"`   // 假值label
 	Round        *int   `json:"round"`        // 小数点位
+	// 枚举, 结构体, 数组
+	Enums       []dataschema.IoTEnumItem `json:"enums,omitempty"`       // 枚举项
+	Fields      []dataschema.IoTParam    `json:"fields,omitempty"`      // 结构体字段
+	ElementType string                   `json:"elementType,omitempty"` // 数组元素类型
+	Size        int                      `json:"size,omitempty"`        // 数组最大长度
 }
 
 func (O IoTPropertyRuleVo) Check() error {
 	return nil
 }
+
+// 类型和规则要对得上, 比如枚举必须有枚举项, 结构体必须有字段
+func checkPropertyRule(IotPropertyVo IotPropertyVo) error {
+	IoTProperty := dataschema.IoTProperty{
+		Name: IotPropertyVo.Name,
+		Type: dataschema.IoTPropertyType(IotPropertyVo.Type),
+	}
+	if err := json.Unmarshal([]byte(IotPropertyVo.Rule.String()), &IoTProperty.Rule); err != nil {
+		return err
+	}
+	return IoTProperty.HoldValidator()
+}
 func (O IoTPropertyRuleVo) GetDefaultValue() string {
 	switch T := O.DefaultValue.(type) {
 	case string:
@@ -101,6 +137,9 @@ func (O IoTPropertyRuleVo) GetDefaultValue() string {
 			return "1"
 		}
 		return "0"
+	case map[string]any, []any:
+		bytes, _ := json.Marshal(T)
+		return string(bytes)
 	default:
 		return "0"
 	}
@@ -230,6 +269,8 @@ func DeleteDataSchema(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	dataschema.FlushIoTFunctionCache(uuid)
+	reloadBindings()
 	c.JSON(common.HTTP_OK, common.Ok())
 
 }
@@ -245,6 +286,7 @@ func FixSchema(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	reloadBindings()
 	c.JSON(common.HTTP_OK, common.Ok())
 
 }
@@ -326,26 +368,7 @@ func PublishSchema(c *gin.Context, ruleEngine typex.Rhilex) {
 			if errIdx2 := datacenter.DataCenterDb().Exec(fmt.Sprintf(idxSql2, tableName)).Error; errIdx2 != nil {
 				return errIdx2
 			}
-			// 防止数据爆炸撑死数据库，10000条数据大概吃10Mb硬盘.
-			// 通常来说这些数据完全足够代表最新数据了，历史数据反而是垃圾
-			trigger := `
-CREATE TRIGGER IF NOT EXISTS "%s"
-AFTER INSERT ON "%s"
-WHEN ((SELECT COUNT(*) FROM "%s") / 100) * 100 = (SELECT COUNT(*) FROM "%s")
-AND (SELECT COUNT(*) FROM "%s") > 10000
-BEGIN
-    DELETE FROM "%s"
-    WHERE id IN (
-        SELECT id FROM "%s"
-        ORDER BY id ASC
-        LIMIT 100
-    );
-END;
-`
-			if errTrigger := datacenter.DataCenterDb().Exec(fmt.Sprintf(trigger, tableName, tableName,
-				tableName, tableName, tableName, tableName, tableName)).Error; errTrigger != nil {
-				return errTrigger
-			}
+			// 行数和保存时间由保留策略管, 见 datacenter/datacenter_retention.go
 			return nil
 		})
 
@@ -355,6 +378,7 @@ END;
 		c.JSON(common.HTTP_OK, common.Error400(txErr))
 		return
 	}
+	reloadBindings()
 	c.JSON(common.HTTP_OK, common.Ok())
 }
 
@@ -409,6 +433,10 @@ func CreateIotSchemaProperty(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	if err := checkPropertyRule(IotPropertyVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	if err := dataschema.ValidateRw(IotPropertyVo.Rw); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -464,6 +492,10 @@ func UpdateIotSchemaProperty(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	if err := checkPropertyRule(IotPropertyVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	Schema, err := service.GetDataSchemaWithUUID(IotPropertyVo.SchemaId)
 	if err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
@@ -488,6 +520,7 @@ func UpdateIotSchemaProperty(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err2))
 		return
 	}
+	reloadBindings()
 	c.JSON(common.HTTP_OK, common.Ok())
 
 }
@@ -510,6 +543,10 @@ func DeleteIotSchemaProperty(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error("Data Schema Already published"))
 		return
 	}
+	if service.CountIotSchemaBinding(Property.Name, Property.SchemaId) > 0 {
+		c.JSON(common.HTTP_OK, common.Error("Property Already Bound:"+Property.Name))
+		return
+	}
 	err1 := service.DeleteIotSchemaProperty(uuid)
 	if err1 != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err1))
@@ -0,0 +1,189 @@
+package apis
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/dataschema"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+/*
+*
+* 点位绑定 @ component/dataschema/data_schema_binding
+*
+ */
+type IotBindingVo struct {
+	UUID        string `json:"uuid"`
+	SchemaId    string `json:"schemaId"`
+	Property    string `json:"property"`
+	DeviceUuid  string `json:"deviceUuid"`
+	Tag         string `json:"tag"`
+	Expr        string `json:"expr"`
+	WriteExpr   string `json:"writeExpr"`
+	CtrlCommand string `json:"ctrlCommand"`
+	Description string `json:"description"`
+}
+
+func (O IotBindingVo) check() error {
+	if O.Property == "" || O.DeviceUuid == "" || O.Tag == "" {
+		return fmt.Errorf("property, deviceUuid and tag are required")
+	}
+	if _, err := service.FindIotSchemaPropertyByName(O.SchemaId, O.Property); err != nil {
+		return fmt.Errorf("property not exists: %s", O.Property)
+	}
+	if _, err := dataschema.CompileBindingExpr(O.Expr); err != nil {
+		return fmt.Errorf("expr: %s", err)
+	}
+	if _, err := dataschema.CompileBindingExpr(O.WriteExpr); err != nil {
+		return fmt.Errorf("writeExpr: %s", err)
+	}
+	return nil
+}
+
+func (O IotBindingVo) model() model.MIotBinding {
+	return model.MIotBinding{
+		UUID:        O.UUID,
+		SchemaId:    O.SchemaId,
+		Property:    O.Property,
+		DeviceUuid:  O.DeviceUuid,
+		Tag:         O.Tag,
+		Expr:        O.Expr,
+		WriteExpr:   O.WriteExpr,
+		CtrlCommand: O.CtrlCommand,
+		Description: O.Description,
+	}
+}
+
+// 绑定改了就重新加载, 加载失败只记日志, 不影响保存
+func reloadBindings() {
+	if err := dataschema.ReloadIoTBindings(); err != nil {
+		glogger.GLogger.Error("reload bindings failed:", err)
+	}
+}
+
+// 新建绑定
+func CreateIotSchemaBinding(c *gin.Context, ruleEngine typex.Rhilex) {
+	IotBindingVo := IotBindingVo{}
+	if err := c.ShouldBindJSON(&IotBindingVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := IotBindingVo.check(); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if service.CountIotSchemaBinding(IotBindingVo.Property, IotBindingVo.SchemaId) > 0 {
+		c.JSON(common.HTTP_OK, common.Error("Property Already Bound:"+IotBindingVo.Property))
+		return
+	}
+	IotBindingVo.UUID = utils.MakeUUID("BIND")
+	if err := service.InsertIotSchemaBinding(IotBindingVo.model()); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	reloadBindings()
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+// 更新绑定
+func UpdateIotSchemaBinding(c *gin.Context, ruleEngine typex.Rhilex) {
+	IotBindingVo := IotBindingVo{}
+	if err := c.ShouldBindJSON(&IotBindingVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	MIotBinding, err := service.FindIotSchemaBinding(IotBindingVo.UUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	IotBindingVo.SchemaId = MIotBinding.SchemaId
+	if err := IotBindingVo.check(); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if MIotBinding.Property != IotBindingVo.Property &&
+		service.CountIotSchemaBinding(IotBindingVo.Property, IotBindingVo.SchemaId) > 0 {
+		c.JSON(common.HTTP_OK, common.Error("Property Already Bound:"+IotBindingVo.Property))
+		return
+	}
+	if err := service.UpdateIotSchemaBinding(IotBindingVo.model()); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	reloadBindings()
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+// 删除绑定
+func DeleteIotSchemaBinding(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if err := service.DeleteIotSchemaBinding(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	reloadBindings()
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+// 绑定列表
+func ListIotSchemaBinding(c *gin.Context, ruleEngine typex.Rhilex) {
+	schemaUuid, _ := c.GetQuery("schema_uuid")
+	MIotBindings, err := service.AllIotSchemaBinding(schemaUuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	IotBindingVos := []IotBindingVo{}
+	for _, MIotBinding := range MIotBindings {
+		IotBindingVos = append(IotBindingVos, IotBindingVo{
+			UUID:        MIotBinding.UUID,
+			SchemaId:    MIotBinding.SchemaId,
+			Property:    MIotBinding.Property,
+			DeviceUuid:  MIotBinding.DeviceUuid,
+			Tag:         MIotBinding.Tag,
+			Expr:        MIotBinding.Expr,
+			WriteExpr:   MIotBinding.WriteExpr,
+			CtrlCommand: MIotBinding.CtrlCommand,
+			Description: MIotBinding.Description,
+		})
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(IotBindingVos))
+}
+
+// 绑定属性的当前值
+func IotSchemaPropertyValues(c *gin.Context, ruleEngine typex.Rhilex) {
+	schemaUuid, _ := c.GetQuery("schema_uuid")
+	c.JSON(common.HTTP_OK, common.OkWithData(dataschema.GetIoTPropertyValues(schemaUuid)))
+}
+
+/*
+*
+* 写属性: {"schemaId":"", "name":"", "value":1}, 写到绑定的点位
+*
+ */
+type IotPropertyWriteVo struct {
+	SchemaId string `json:"schemaId" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Value    any    `json:"value"`
+}
+
+func WriteIotSchemaProperty(c *gin.Context, ruleEngine typex.Rhilex) {
+	IotPropertyWriteVo := IotPropertyWriteVo{}
+	if err := c.ShouldBindJSON(&IotPropertyWriteVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := dataschema.WriteProperty(ruleEngine, IotPropertyWriteVo.SchemaId,
+		IotPropertyWriteVo.Name, IotPropertyWriteVo.Value); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
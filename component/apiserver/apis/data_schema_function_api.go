@@ -0,0 +1,371 @@
+package apis
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/dataschema"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+/*
+*
+* 事件 @ component/dataschema/data_schema_event
+* 事件和服务不影响数据中心的表, 模型发布以后也能改
+*
+ */
+type IotEventVo struct {
+	UUID        string                `json:"uuid"`
+	SchemaId    string                `json:"schemaId"`
+	Label       string                `json:"label"`
+	Name        string                `json:"name"`
+	Severity    string                `json:"severity"` // INFO|WARNING|ALERT|FAULT
+	Outputs     []dataschema.IoTParam `json:"outputs"`
+	Description string                `json:"description"`
+}
+
+func (O IotEventVo) check() error {
+	Event := dataschema.IoTEvent{Name: O.Name, Severity: O.Severity, Outputs: O.Outputs}
+	return Event.HoldValidator()
+}
+
+func (O IotEventVo) model() model.MIotEvent {
+	if O.Outputs == nil {
+		O.Outputs = []dataschema.IoTParam{}
+	}
+	Outputs, _ := json.Marshal(O.Outputs)
+	return model.MIotEvent{
+		SchemaId:    O.SchemaId,
+		UUID:        O.UUID,
+		Label:       O.Label,
+		Name:        O.Name,
+		Severity:    O.Severity,
+		Outputs:     string(Outputs),
+		Description: O.Description,
+	}
+}
+
+/*
+*
+* 服务 @ component/dataschema/data_schema_service
+*
+ */
+type IotServiceVo struct {
+	UUID        string                `json:"uuid"`
+	SchemaId    string                `json:"schemaId"`
+	Label       string                `json:"label"`
+	Name        string                `json:"name"`
+	Command     string                `json:"command"` // 设备指令, 空的时候就是服务名
+	Inputs      []dataschema.IoTParam `json:"inputs"`
+	Outputs     []dataschema.IoTParam `json:"outputs"`
+	Description string                `json:"description"`
+}
+
+func (O IotServiceVo) check() error {
+	Service := dataschema.IoTService{Name: O.Name, Inputs: O.Inputs, Outputs: O.Outputs}
+	return Service.HoldValidator()
+}
+
+func (O IotServiceVo) model() model.MIotService {
+	if O.Inputs == nil {
+		O.Inputs = []dataschema.IoTParam{}
+	}
+	if O.Outputs == nil {
+		O.Outputs = []dataschema.IoTParam{}
+	}
+	Inputs, _ := json.Marshal(O.Inputs)
+	Outputs, _ := json.Marshal(O.Outputs)
+	return model.MIotService{
+		SchemaId:    O.SchemaId,
+		UUID:        O.UUID,
+		Label:       O.Label,
+		Name:        O.Name,
+		Command:     O.Command,
+		Inputs:      string(Inputs),
+		Outputs:     string(Outputs),
+		Description: O.Description,
+	}
+}
+
+// 新建事件
+func CreateIotSchemaEvent(c *gin.Context, ruleEngine typex.Rhilex) {
+	IotEventVo := IotEventVo{}
+	if err := c.ShouldBindJSON(&IotEventVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := IotEventVo.check(); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if _, err := service.GetDataSchemaWithUUID(IotEventVo.SchemaId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if service.CountIotSchemaEvent(IotEventVo.Name, IotEventVo.SchemaId) > 0 {
+		c.JSON(common.HTTP_OK, common.Error("Already Exists Event:"+IotEventVo.Name))
+		return
+	}
+	IotEventVo.UUID = utils.MakeUUID("EVENT")
+	if err := service.InsertIotSchemaEvent(IotEventVo.model()); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := dataschema.LoadIoTFunctionCache(IotEventVo.SchemaId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+// 更新事件
+func UpdateIotSchemaEvent(c *gin.Context, ruleEngine typex.Rhilex) {
+	IotEventVo := IotEventVo{}
+	if err := c.ShouldBindJSON(&IotEventVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := IotEventVo.check(); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	MIotEvent, err := service.FindIotSchemaEvent(IotEventVo.UUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	IotEventVo.SchemaId = MIotEvent.SchemaId
+	if MIotEvent.Name != IotEventVo.Name &&
+		service.CountIotSchemaEvent(IotEventVo.Name, IotEventVo.SchemaId) > 0 {
+		c.JSON(common.HTTP_OK, common.Error("Already Exists Event:"+IotEventVo.Name))
+		return
+	}
+	if err := service.UpdateIotSchemaEvent(IotEventVo.model()); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := dataschema.LoadIoTFunctionCache(IotEventVo.SchemaId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+// 删除事件
+func DeleteIotSchemaEvent(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	MIotEvent, err := service.FindIotSchemaEvent(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := service.DeleteIotSchemaEvent(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := dataschema.LoadIoTFunctionCache(MIotEvent.SchemaId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+// 事件列表
+func ListIotSchemaEvent(c *gin.Context, ruleEngine typex.Rhilex) {
+	schemaUuid, _ := c.GetQuery("schema_uuid")
+	MIotEvents, err := service.AllIotSchemaEvent(schemaUuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	IotEventVos := []IotEventVo{}
+	for _, MIotEvent := range MIotEvents {
+		IotEventVo := IotEventVo{
+			UUID:        MIotEvent.UUID,
+			SchemaId:    MIotEvent.SchemaId,
+			Label:       MIotEvent.Label,
+			Name:        MIotEvent.Name,
+			Severity:    MIotEvent.Severity,
+			Outputs:     []dataschema.IoTParam{},
+			Description: MIotEvent.Description,
+		}
+		if err := json.Unmarshal([]byte(MIotEvent.Outputs), &IotEventVo.Outputs); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		IotEventVos = append(IotEventVos, IotEventVo)
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(IotEventVos))
+}
+
+/*
+*
+* 手动触发事件, 一般是调试用
+*
+ */
+type IotFunctionCallVo struct {
+	SchemaId   string         `json:"schemaId" binding:"required"`
+	DeviceUuid string         `json:"deviceUuid"`
+	Name       string         `json:"name" binding:"required"`
+	Params     map[string]any `json:"params"`
+}
+
+func EmitIotSchemaEvent(c *gin.Context, ruleEngine typex.Rhilex) {
+	IotFunctionCallVo := IotFunctionCallVo{}
+	if err := c.ShouldBindJSON(&IotFunctionCallVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	Message, err := dataschema.EmitEvent(IotFunctionCallVo.SchemaId,
+		IotFunctionCallVo.DeviceUuid, IotFunctionCallVo.Name, IotFunctionCallVo.Params)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(Message))
+}
+
+// 新建服务
+func CreateIotSchemaService(c *gin.Context, ruleEngine typex.Rhilex) {
+	IotServiceVo := IotServiceVo{}
+	if err := c.ShouldBindJSON(&IotServiceVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := IotServiceVo.check(); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if _, err := service.GetDataSchemaWithUUID(IotServiceVo.SchemaId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if service.CountIotSchemaService(IotServiceVo.Name, IotServiceVo.SchemaId) > 0 {
+		c.JSON(common.HTTP_OK, common.Error("Already Exists Service:"+IotServiceVo.Name))
+		return
+	}
+	IotServiceVo.UUID = utils.MakeUUID("SERVICE")
+	if err := service.InsertIotSchemaService(IotServiceVo.model()); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := dataschema.LoadIoTFunctionCache(IotServiceVo.SchemaId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+// 更新服务
+func UpdateIotSchemaService(c *gin.Context, ruleEngine typex.Rhilex) {
+	IotServiceVo := IotServiceVo{}
+	if err := c.ShouldBindJSON(&IotServiceVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := IotServiceVo.check(); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	MIotService, err := service.FindIotSchemaService(IotServiceVo.UUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	IotServiceVo.SchemaId = MIotService.SchemaId
+	if MIotService.Name != IotServiceVo.Name &&
+		service.CountIotSchemaService(IotServiceVo.Name, IotServiceVo.SchemaId) > 0 {
+		c.JSON(common.HTTP_OK, common.Error("Already Exists Service:"+IotServiceVo.Name))
+		return
+	}
+	if err := service.UpdateIotSchemaService(IotServiceVo.model()); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := dataschema.LoadIoTFunctionCache(IotServiceVo.SchemaId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+// 删除服务
+func DeleteIotSchemaService(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	MIotService, err := service.FindIotSchemaService(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := service.DeleteIotSchemaService(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := dataschema.LoadIoTFunctionCache(MIotService.SchemaId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+// 服务列表
+func ListIotSchemaService(c *gin.Context, ruleEngine typex.Rhilex) {
+	schemaUuid, _ := c.GetQuery("schema_uuid")
+	MIotServices, err := service.AllIotSchemaService(schemaUuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	IotServiceVos := []IotServiceVo{}
+	for _, MIotService := range MIotServices {
+		IotServiceVo := IotServiceVo{
+			UUID:        MIotService.UUID,
+			SchemaId:    MIotService.SchemaId,
+			Label:       MIotService.Label,
+			Name:        MIotService.Name,
+			Command:     MIotService.Command,
+			Inputs:      []dataschema.IoTParam{},
+			Outputs:     []dataschema.IoTParam{},
+			Description: MIotService.Description,
+		}
+		if err := json.Unmarshal([]byte(MIotService.Inputs), &IotServiceVo.Inputs); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		if err := json.Unmarshal([]byte(MIotService.Outputs), &IotServiceVo.Outputs); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		IotServiceVos = append(IotServiceVos, IotServiceVo)
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(IotServiceVos))
+}
+
+/*
+*
+* 调用服务: {"schemaId":"", "deviceUuid":"", "name":"", "params":{}}
+*
+ */
+func InvokeIotSchemaService(c *gin.Context, ruleEngine typex.Rhilex) {
+	IotFunctionCallVo := IotFunctionCallVo{}
+	if err := c.ShouldBindJSON(&IotFunctionCallVo); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if IotFunctionCallVo.DeviceUuid == "" {
+		c.JSON(common.HTTP_OK, common.Error400(fmt.Errorf("missing deviceUuid")))
+		return
+	}
+	Result, err := dataschema.InvokeService(ruleEngine, IotFunctionCallVo.SchemaId,
+		IotFunctionCallVo.DeviceUuid, IotFunctionCallVo.Name, IotFunctionCallVo.Params)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(Result))
+}
@@ -0,0 +1,283 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/applet"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/luamodule"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+func InitLuaModuleRoute() {
+	luaModuleApi := server.RouteGroup(server.ContextUrl("/luamodule"))
+	{
+		luaModuleApi.GET(("/list"), server.AddRoute(LuaModules))
+		luaModuleApi.GET(("/detail"), server.AddRoute(LuaModuleDetail))
+		luaModuleApi.POST(("/save"), server.AddRoute(SaveLuaModule))
+		luaModuleApi.DELETE(("/del"), server.AddRoute(DeleteLuaModule))
+		luaModuleApi.GET(("/dependents"), server.AddRoute(LuaModuleDependents))
+		luaModuleApi.PUT(("/reloadDependents"), server.AddRoute(ReloadLuaModuleDependents))
+	}
+}
+
+type luaModuleVo struct {
+	Name        string `json:"name"`
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Source      string `json:"source,omitempty"`
+}
+
+type luaModuleVersionVo struct {
+	Version   int    `json:"version"`
+	Source    string `json:"source"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type luaModuleReloadVo struct {
+	luamodule.Dependent
+	Error string `json:"error"`
+}
+
+/*
+*
+* 模块列表, 不带源码
+*
+ */
+func LuaModules(c *gin.Context, ruleEngine typex.Rhilex) {
+	MModules, err := service.AllMLuaModules()
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	vos := []luaModuleVo{}
+	for _, m := range MModules {
+		vos = append(vos, luaModuleVo{
+			Name:        m.Name,
+			Version:     m.Version,
+			Description: m.Description,
+		})
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(vos))
+}
+
+/*
+*
+* 模块详情, 带所有历史版本
+*
+ */
+func LuaModuleDetail(c *gin.Context, ruleEngine typex.Rhilex) {
+	name, _ := c.GetQuery("name")
+	MModule, err := service.GetMLuaModule(name)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	MVersions, err := service.GetMLuaModuleVersions(name)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	versions := []luaModuleVersionVo{}
+	for _, v := range MVersions {
+		versions = append(versions, luaModuleVersionVo{
+			Version:   v.Version,
+			Source:    v.Source,
+			CreatedAt: v.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(map[string]any{
+		"module": luaModuleVo{
+			Name:        MModule.Name,
+			Version:     MModule.Version,
+			Description: MModule.Description,
+			Source:      MModule.Source,
+		},
+		"versions":   versions,
+		"dependents": liveModuleDependents(ruleEngine, name),
+	}))
+}
+
+/*
+*
+* 新建或者更新模块, 每次保存版本号加一;
+* reload 为 true 的时候重新加载所有用到这个模块的规则和应用
+*
+ */
+func SaveLuaModule(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+		Source      string `json:"source" binding:"required"`
+		Reload      bool   `json:"reload"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := luamodule.ValidateName(form.Name); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	// 只编译不执行, 模块顶层的代码在 require 的时候才执行
+	tempVm := lua.NewState(lua.Options{SkipOpenLibs: true})
+	_, errSyntax := tempVm.LoadString(form.Source)
+	tempVm.Close()
+	if errSyntax != nil {
+		c.JSON(common.HTTP_OK, common.Error400(errSyntax))
+		return
+	}
+	version, err := service.SaveMLuaModule(form.Name, form.Description, form.Source)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	luamodule.Invalidate(form.Name)
+	dependents := liveModuleDependents(ruleEngine, form.Name)
+	reloaded := []luaModuleReloadVo{}
+	if form.Reload {
+		reloaded = reloadModuleDependents(ruleEngine, dependents)
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(map[string]any{
+		"name":       form.Name,
+		"version":    version,
+		"dependents": dependents,
+		"reloaded":   reloaded,
+	}))
+}
+
+/*
+*
+* 删除模块, 还有规则或者应用在用的时候不允许删除
+*
+ */
+func DeleteLuaModule(c *gin.Context, ruleEngine typex.Rhilex) {
+	name, _ := c.GetQuery("name")
+	if dependents := liveModuleDependents(ruleEngine, name); len(dependents) > 0 {
+		c.JSON(common.HTTP_OK, common.Error(fmt.Sprintf("module is used by %d scripts", len(dependents))))
+		return
+	}
+	if err := service.DeleteMLuaModule(name); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	luamodule.Invalidate(name)
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 用到模块的规则和应用
+*
+ */
+func LuaModuleDependents(c *gin.Context, ruleEngine typex.Rhilex) {
+	name, _ := c.GetQuery("name")
+	c.JSON(common.HTTP_OK, common.OkWithData(liveModuleDependents(ruleEngine, name)))
+}
+
+/*
+*
+* 重新加载用到模块的规则和应用
+*
+ */
+func ReloadLuaModuleDependents(c *gin.Context, ruleEngine typex.Rhilex) {
+	name, _ := c.GetQuery("name")
+	c.JSON(common.HTTP_OK, common.OkWithData(
+		reloadModuleDependents(ruleEngine, liveModuleDependents(ruleEngine, name))))
+}
+
+// 只返回还在运行的, 删掉的规则和语法校验用的临时规则不算
+func liveModuleDependents(ruleEngine typex.Rhilex, name string) []luamodule.Dependent {
+	dependents := []luamodule.Dependent{}
+	for _, dependent := range luamodule.Dependents(name) {
+		switch dependent.Type {
+		case luamodule.SCOPE_RULE:
+			if ruleEngine.GetRule(dependent.UUID) == nil {
+				continue
+			}
+		case luamodule.SCOPE_APPLET:
+			if applet.GetApp(dependent.UUID) == nil {
+				continue
+			}
+		}
+		dependents = append(dependents, dependent)
+	}
+	return dependents
+}
+
+func reloadModuleDependents(ruleEngine typex.Rhilex, dependents []luamodule.Dependent) []luaModuleReloadVo {
+	results := []luaModuleReloadVo{}
+	for _, dependent := range dependents {
+		var err error
+		switch dependent.Type {
+		case luamodule.SCOPE_RULE:
+			err = reloadRule(ruleEngine, dependent.UUID)
+		case luamodule.SCOPE_APPLET:
+			err = reloadApplet(dependent.UUID)
+		default:
+			err = fmt.Errorf("reload %s is not supported", dependent.Type)
+		}
+		result := luaModuleReloadVo{Dependent: dependent}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// 和更新规则一样: 重新创建规则, 加载的时候会重新绑定到资源
+func reloadRule(ruleEngine typex.Rhilex, uuid string) error {
+	MRule, err := service.GetMRuleWithUUID(uuid)
+	if err != nil {
+		return err
+	}
+	rule := typex.NewTypedRule(ruleEngine, MRule.Type, MRule.UUID, MRule.Name, MRule.Description,
+		MRule.SourceId, MRule.DeviceId, MRule.Success, MRule.Actions, MRule.Failed)
+	rule.FromStream = MRule.StreamId
+	ruleEngine.RemoveRule(rule.UUID)
+	return ruleEngine.LoadRule(rule)
+}
+
+// 和更新应用一样: 停止, 重新加载, 原来在运行的再启动
+func reloadApplet(uuid string) error {
+	mApp, err := service.GetMAppWithUUID(uuid)
+	if err != nil {
+		return err
+	}
+	running := false
+	if app := applet.GetApp(uuid); app != nil {
+		running = app.AppState != applet.APP_STOPPED
+		if running {
+			applet.StopApp(uuid)
+		}
+		applet.RemoveApp(uuid)
+	}
+	if _, err := server.LoadApplet(mApp); err != nil {
+		return err
+	}
+	if running {
+		return applet.StartApp(uuid)
+	}
+	return nil
+}
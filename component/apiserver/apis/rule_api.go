@@ -9,8 +9,11 @@ import (
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/server"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/exprrule"
 	"github.com/hootrhino/rhilex/component/interqueue"
 	"github.com/hootrhino/rhilex/component/luaruntime"
+	"github.com/hootrhino/rhilex/component/rulestream"
+	"github.com/hootrhino/rhilex/component/streamwindow"
 	"github.com/hootrhino/rhilex/glogger"
 	transceiver "github.com/hootrhino/rhilex/transceiver"
 
@@ -33,6 +36,16 @@ func InitRulesRoute() {
 		rulesApi.GET(("/byDevice"), server.AddRoute(ListByDevice))
 		rulesApi.GET(("/getCanUsedResources"), server.AddRoute(GetAllResources))
 		rulesApi.POST(("/formatLua"), server.AddRoute(FormatLua))
+		rulesApi.PUT(("/resume"), server.AddRoute(ResumeRule))
+		rulesApi.GET(("/streams"), server.AddRoute(RuleStreams))
+		rulesApi.GET(("/fixtures/list"), server.AddRoute(RuleFixtures))
+		rulesApi.POST(("/fixtures/create"), server.AddRoute(CreateRuleFixture))
+		rulesApi.PUT(("/fixtures/update"), server.AddRoute(UpdateRuleFixture))
+		rulesApi.DELETE(("/fixtures/del"), server.AddRoute(DeleteRuleFixture))
+		rulesApi.POST(("/fixtures/run"), server.AddRoute(RunRuleFixtures))
+		rulesApi.GET(("/fixtures/export"), server.AddRoute(ExportRuleFixtures))
+		rulesApi.GET(("/debug"), server.AddRoute(RuleDebugger))
+		rulesApi.POST(("/replay"), server.AddRoute(ReplayRule))
 
 	}
 }
@@ -41,6 +54,7 @@ type ruleVo struct {
 	UUID        string   `json:"uuid"`
 	FromSource  []string `json:"fromSource"`
 	FromDevice  []string `json:"fromDevice"`
+	FromStream  []string `json:"fromStream"`
 	Name        string   `json:"name"`
 	Type        string   `json:"type"`
 	Status      int      `json:"status"`
@@ -49,6 +63,74 @@ type ruleVo struct {
 	Actions     string   `json:"actions"`
 	Success     string   `json:"success"`
 	Failed      string   `json:"failed"`
+	// 规则被沙箱挂起的原因, 挂起以后 Status 为 0
+	SuspendReason string `json:"suspendReason"`
+}
+
+// 运行时状态: 被挂起的规则状态为 0
+func ruleRuntimeStatus(ruleEngine typex.Rhilex, uuid string) (int, string) {
+	rule := ruleEngine.GetRule(uuid)
+	if rule == nil || rule.Runtime == nil {
+		return 1, ""
+	}
+	if rule.Runtime.Suspended() {
+		return 0, rule.Runtime.SuspendReason()
+	}
+	return 1, ""
+}
+
+func streamIds(streamId string) []string {
+	if streamId == "" {
+		return []string{}
+	}
+	return []string{streamId}
+}
+
+func first(s []string) string {
+	if len(s) > 0 {
+		return s[0]
+	}
+	return ""
+}
+
+// 规则的输入只能是资源、设备、规则流中的一种
+func verifyRuleStream(fromSource, fromDevice, fromStream []string) error {
+	if len(fromStream) == 0 {
+		return nil
+	}
+	if len(fromSource) > 0 || len(fromDevice) > 0 {
+		return fmt.Errorf("rule subscribing a stream can not bind source or device")
+	}
+	return rulestream.ValidateName(fromStream[0])
+}
+
+// 规则会发布到哪些流
+func ruleEmits(ruleType, actions string) ([]string, error) {
+	if ruleType == typex.RULE_TYPE_EXPR {
+		pipeline, err := exprrule.Compile(nil, "", actions)
+		if err != nil {
+			return nil, err
+		}
+		return pipeline.Streams(), nil
+	}
+	return rulestream.ScanLua(actions), nil
+}
+
+/*
+*
+* 规则流的拓扑: 每个流有哪些规则发布、哪些规则订阅
+*
+ */
+func RuleStreams(c *gin.Context, ruleEngine typex.Rhilex) {
+	c.JSON(common.HTTP_OK, common.OkWithData(rulestream.Topology()))
+}
+
+// 老规则没有类型, 都是 Lua
+func ruleType(t string) string {
+	if t == "" {
+		return typex.RULE_TYPE_LUA
+	}
+	return t
 }
 
 func RuleDetail(c *gin.Context, ruleEngine typex.Rhilex) {
@@ -58,16 +140,20 @@ func RuleDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400EmptyObj(err))
 		return
 	}
+	status, suspendReason := ruleRuntimeStatus(ruleEngine, rule.UUID)
 	c.JSON(common.HTTP_OK, common.OkWithData(ruleVo{
-		UUID:        rule.UUID,
-		Name:        rule.Name,
-		Status:      1,
-		Description: rule.Description,
-		FromSource:  []string{rule.SourceId},
-		FromDevice:  []string{rule.DeviceId},
-		Success:     rule.Success,
-		Failed:      rule.Failed,
-		Actions:     rule.Actions,
+		UUID:          rule.UUID,
+		Name:          rule.Name,
+		Type:          ruleType(rule.Type),
+		Status:        status,
+		SuspendReason: suspendReason,
+		Description:   rule.Description,
+		FromSource:    []string{rule.SourceId},
+		FromDevice:    []string{rule.DeviceId},
+		FromStream:    streamIds(rule.StreamId),
+		Success:       rule.Success,
+		Failed:        rule.Failed,
+		Actions:       rule.Actions,
 	}))
 }
 
@@ -76,16 +162,20 @@ func Rules(c *gin.Context, ruleEngine typex.Rhilex) {
 	DataList := []ruleVo{}
 	allRules, _ := service.GetAllMRule()
 	for _, rule := range allRules {
+		status, suspendReason := ruleRuntimeStatus(ruleEngine, rule.UUID)
 		DataList = append(DataList, ruleVo{
-			UUID:        rule.UUID,
-			Name:        rule.Name,
-			Status:      1,
-			Description: rule.Description,
-			FromSource:  []string{rule.SourceId},
-			FromDevice:  []string{rule.DeviceId},
-			Success:     rule.Success,
-			Failed:      rule.Failed,
-			Actions:     rule.Actions,
+			UUID:          rule.UUID,
+			Name:          rule.Name,
+			Type:          ruleType(rule.Type),
+			Status:        status,
+			SuspendReason: suspendReason,
+			Description:   rule.Description,
+			FromSource:    []string{rule.SourceId},
+			FromDevice:    []string{rule.DeviceId},
+			FromStream:    streamIds(rule.StreamId),
+			Success:       rule.Success,
+			Failed:        rule.Failed,
+			Actions:       rule.Actions,
 		})
 	}
 	c.JSON(common.HTTP_OK, common.OkWithData(DataList))
@@ -94,11 +184,26 @@ func Rules(c *gin.Context, ruleEngine typex.Rhilex) {
 var __default_success = `function Success() end`
 var __default_failed = `function Failed(error) end`
 
+// 按规则类型校验 Lua 脚本或者声明式定义
+func verifyRule(ruleType, actions string) error {
+	switch ruleType {
+	case typex.RULE_TYPE_LUA:
+		// tmpRule 是一个一次性的临时rule，用来验证规则，这么做主要是为了防止真实Lua Vm 被污染
+		tmpRule := typex.NewRule(nil, "_", "_", "_", "_", "_",
+			__default_success, actions, __default_failed)
+		return luaruntime.VerifyLuaSyntax(tmpRule)
+	case typex.RULE_TYPE_EXPR:
+		return exprrule.Validate(actions)
+	}
+	return fmt.Errorf("rule type must be 'lua' or 'expr' but now is:%s", ruleType)
+}
+
 // Create rule
 func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 	type Form struct {
 		FromSource  []string `json:"fromSource" binding:"required"`
 		FromDevice  []string `json:"fromDevice" binding:"required"`
+		FromStream  []string `json:"fromStream"`
 		Name        string   `json:"name" binding:"required"`
 		Type        string   `json:"type"`
 		Description string   `json:"description"`
@@ -116,8 +221,8 @@ func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error(r))
 		return
 	}
-	if !utils.SContains([]string{"lua"}, form.Type) {
-		c.JSON(common.HTTP_OK, common.Error(`rule type must be 'lua' but now is:`+form.Type))
+	if !utils.SContains([]string{typex.RULE_TYPE_LUA, typex.RULE_TYPE_EXPR}, form.Type) {
+		c.JSON(common.HTTP_OK, common.Error(`rule type must be 'lua' or 'expr' but now is:`+form.Type))
 		return
 	}
 	for _, id := range form.FromSource {
@@ -136,16 +241,18 @@ func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		}
 	}
 
-	// tmpRule 是一个一次性的临时rule，用来验证规则，这么做主要是为了防止真实Lua Vm 被污染
-	tmpRule := typex.NewRule(nil, "_", "_", "_", "_", "_",
-		__default_success, form.Actions, __default_failed)
-	if err := luaruntime.VerifyLuaSyntax(tmpRule); err != nil {
+	if err := verifyRule(form.Type, form.Actions); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := verifyRuleStream(form.FromSource, form.FromDevice, form.FromStream); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
 
-	rule := typex.NewLuaRule(
+	rule := typex.NewTypedRule(
 		ruleEngine,
+		form.Type,
 		utils.RuleUuid(),
 		form.Name,
 		form.Description,
@@ -164,11 +271,31 @@ func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		__default_success,
 		form.Actions,
 		__default_failed)
+	rule.FromStream = first(form.FromStream)
 	ruleEngine.RemoveRule(rule.UUID)
 	if err := ruleEngine.LoadRule(rule); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	// 订阅规则流的规则不绑定资源, 直接保存
+	if rule.FromStream != "" {
+		if err := service.InsertMRule(&model.MRule{
+			Name:        form.Name,
+			UUID:        rule.UUID,
+			Type:        form.Type,
+			Description: form.Description,
+			StreamId:    rule.FromStream,
+			Success:     __default_success,
+			Failed:      __default_failed,
+			Actions:     form.Actions,
+		}); err != nil {
+			ruleEngine.RemoveRule(rule.UUID)
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		c.JSON(common.HTTP_OK, common.Ok())
+		return
+	}
 	go func() {
 		// 更新FromSource RULE到Device表中
 		for _, inId := range form.FromSource {
@@ -183,6 +310,7 @@ func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 			mRule := &model.MRule{
 				Name:        form.Name,
 				UUID:        rule.UUID,
+				Type:        form.Type,
 				Description: form.Description,
 				SourceId: func(s []string) string {
 					if len(s) > 0 {
@@ -248,6 +376,7 @@ func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 			mRule := &model.MRule{
 				Name:        form.Name,
 				UUID:        rule.UUID,
+				Type:        form.Type,
 				Description: form.Description,
 				SourceId: func(s []string) string {
 					if len(s) > 0 {
@@ -306,12 +435,13 @@ func UpdateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		UUID        string   `json:"uuid" binding:"required"` // 如果空串就是新建，非空就是更新
 		FromSource  []string `json:"fromSource" binding:"required"`
 		FromDevice  []string `json:"fromDevice" binding:"required"`
+		FromStream  []string `json:"fromStream"`
 		Name        string   `json:"name" binding:"required"`
 		Type        string   `json:"type"`
 		Description string   `json:"description"`
 		Actions     string   `json:"actions"`
 	}
-	form := Form{Type: "lua"}
+	form := Form{}
 	if err := c.ShouldBindJSON(&form); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -320,10 +450,28 @@ func UpdateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error(r))
 		return
 	}
-	// tmpRule 是一个一次性的临时rule，用来验证规则，这么做主要是为了防止真实Lua Vm 被污染
-	tmpRule := typex.NewRule(nil, "_", "_", "_", "_", "_",
-		__default_success, form.Actions, __default_failed)
-	if err := luaruntime.VerifyLuaSyntax(tmpRule); err != nil {
+	// 不传类型就保持原来的类型
+	if form.Type == "" {
+		form.Type = typex.RULE_TYPE_LUA
+		if OldRule, err := service.GetMRuleWithUUID(form.UUID); err == nil && OldRule.Type != "" {
+			form.Type = OldRule.Type
+		}
+	}
+	if err := verifyRule(form.Type, form.Actions); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := verifyRuleStream(form.FromSource, form.FromDevice, form.FromStream); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	// 保存之前检查规则流会不会成环
+	emits, err := ruleEmits(form.Type, form.Actions)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := rulestream.Check(form.UUID, first(form.FromStream), emits); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
@@ -347,8 +495,9 @@ func UpdateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 			c.JSON(common.HTTP_OK, common.Error400(err))
 			return
 		}
-		rule := typex.NewLuaRule(
+		rule := typex.NewTypedRule(
 			ruleEngine,
+			OldRule.Type,
 			OldRule.UUID,
 			OldRule.Name,
 			OldRule.Description,
@@ -357,6 +506,7 @@ func UpdateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 			OldRule.Success,
 			OldRule.Actions,
 			OldRule.Failed)
+		rule.FromStream = OldRule.StreamId
 		ruleEngine.RemoveRule(rule.UUID)
 		if err := ruleEngine.LoadRule(rule); err != nil {
 			c.JSON(common.HTTP_OK, common.Error400(err))
@@ -366,6 +516,7 @@ func UpdateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		//
 		if err := service.UpdateMRule(OldRule.UUID, &model.MRule{
 			Name:        form.Name,
+			Type:        form.Type,
 			Description: form.Description,
 			SourceId: func(s []string) string {
 				if len(s) > 0 {
@@ -386,6 +537,16 @@ func UpdateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 			c.JSON(common.HTTP_OK, common.Error400(err))
 			return
 		}
+		if err := service.UpdateMRuleStream(OldRule.UUID, first(form.FromStream)); err != nil {
+			glogger.GLogger.Error(err)
+			return
+		}
+		if len(form.FromStream) > 0 {
+			if err := server.LoadStreamRule(OldRule.UUID, ruleEngine); err != nil {
+				glogger.GLogger.Error(err)
+			}
+			return
+		}
 
 		// 耗时操作直接后台执行
 		if len(form.FromSource) > 0 {
@@ -525,7 +686,29 @@ func DeleteRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	if err := service.DeleteMRuleFixtures(mRule.UUID); err != nil {
+		glogger.GLogger.Error(err)
+	}
 	ruleEngine.RemoveRule(mRule.UUID)
+	streamwindow.Purge(mRule.UUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 恢复被挂起的规则
+*
+ */
+func ResumeRule(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	rule := ruleEngine.GetRule(uuid)
+	if rule == nil {
+		c.JSON(common.HTTP_OK, common.Error("rule not exists: "+uuid))
+		return
+	}
+	if rule.Runtime != nil {
+		rule.Runtime.Resume()
+	}
 	c.JSON(common.HTTP_OK, common.Ok())
 }
 
@@ -638,16 +821,20 @@ func ListByDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 	ruleVos := []ruleVo{}
 	for _, rule := range mRules {
 		if utils.SContains([]string{rule.DeviceId}, MDevice.UUID) {
+			status, suspendReason := ruleRuntimeStatus(ruleEngine, rule.UUID)
 			ruleVos = append(ruleVos, ruleVo{
-				UUID:        rule.UUID,
-				FromSource:  []string{rule.SourceId},
-				FromDevice:  []string{rule.DeviceId},
-				Name:        rule.Name,
-				Status:      1,
-				Description: rule.Description,
-				Actions:     rule.Actions,
-				Success:     rule.Success,
-				Failed:      rule.Failed,
+				UUID:          rule.UUID,
+				Type:          ruleType(rule.Type),
+				FromSource:    []string{rule.SourceId},
+				FromDevice:    []string{rule.DeviceId},
+				FromStream:    streamIds(rule.StreamId),
+				Name:          rule.Name,
+				Status:        status,
+				SuspendReason: suspendReason,
+				Description:   rule.Description,
+				Actions:       rule.Actions,
+				Success:       rule.Success,
+				Failed:        rule.Failed,
 			})
 		}
 	}
@@ -672,16 +859,20 @@ func ListByInend(c *gin.Context, ruleEngine typex.Rhilex) {
 	ruleVos := []ruleVo{}
 	for _, rule := range mRules {
 		if utils.SContains([]string{rule.SourceId}, MInend.UUID) {
+			status, suspendReason := ruleRuntimeStatus(ruleEngine, rule.UUID)
 			ruleVos = append(ruleVos, ruleVo{
-				UUID:        rule.UUID,
-				FromSource:  []string{rule.SourceId},
-				FromDevice:  []string{rule.DeviceId},
-				Name:        rule.Name,
-				Status:      1,
-				Description: rule.Description,
-				Actions:     rule.Actions,
-				Success:     rule.Success,
-				Failed:      rule.Failed,
+				UUID:          rule.UUID,
+				Type:          ruleType(rule.Type),
+				FromSource:    []string{rule.SourceId},
+				FromDevice:    []string{rule.DeviceId},
+				FromStream:    streamIds(rule.StreamId),
+				Name:          rule.Name,
+				Status:        status,
+				SuspendReason: suspendReason,
+				Description:   rule.Description,
+				Actions:       rule.Actions,
+				Success:       rule.Success,
+				Failed:        rule.Failed,
 			})
 		}
 	}
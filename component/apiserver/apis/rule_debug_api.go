@@ -0,0 +1,89 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/hootrhino/rhilex/component/luadebugger"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+var ruleDebugUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+/*
+*
+* 规则调试器: WebSocket 连上以后发 JSON 命令, 第一条一般是 start;
+* 规则在沙箱里执行, 外部调用全部模拟, 断开连接就停止调试
+*
+ */
+func RuleDebugger(c *gin.Context, ruleEngine typex.Rhilex) {
+	wsConn, err := ruleDebugUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer wsConn.Close()
+	locker := sync.Mutex{}
+	send := func(event luadebugger.Event) {
+		locker.Lock()
+		defer locker.Unlock()
+		wsConn.WriteJSON(event)
+	}
+	session, err := luadebugger.NewSession(ruleEngine, send)
+	if err != nil {
+		send(luadebugger.Event{Event: "error", Error: err.Error()})
+		return
+	}
+	defer session.Close()
+	glogger.GLogger.Info("Rule debugger connected:", wsConn.RemoteAddr().String())
+	for {
+		cmd := luadebugger.Command{}
+		if err := wsConn.ReadJSON(&cmd); err != nil {
+			glogger.GLogger.Info("Rule debugger disconnected:", wsConn.RemoteAddr().String())
+			return
+		}
+		if cmd.Cmd != "start" {
+			session.Handle(cmd)
+			continue
+		}
+		suite, err := loadRuleSuite(cmd.RuleId)
+		if err != nil {
+			send(luadebugger.Event{Event: "error", Error: err.Error()})
+			continue
+		}
+		// 和执行测试用例一样, 传了脚本就调试传进来的, 不用先保存
+		if cmd.Actions != "" {
+			suite.Rule.Actions = cmd.Actions
+		}
+		if cmd.Success != "" {
+			suite.Rule.Success = cmd.Success
+		}
+		if cmd.Failed != "" {
+			suite.Rule.Failed = cmd.Failed
+		}
+		if err := session.Start(suite.Rule, cmd); err != nil {
+			send(luadebugger.Event{Event: "error", Error: err.Error()})
+		}
+	}
+}
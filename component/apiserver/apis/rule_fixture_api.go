@@ -0,0 +1,276 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/ruletest"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+type ruleFixtureVo struct {
+	UUID   string `json:"uuid"`
+	RuleId string `json:"ruleId"`
+	ruletest.Fixture
+}
+
+func toRuleFixtureVo(m model.MRuleFixture) (ruleFixtureVo, error) {
+	vo := ruleFixtureVo{UUID: m.UUID, RuleId: m.RuleId}
+	if err := json.Unmarshal([]byte(m.Fixture), &vo.Fixture); err != nil {
+		return vo, fmt.Errorf("invalid fixture %s: %w", m.UUID, err)
+	}
+	return vo, nil
+}
+
+/*
+*
+* 规则的测试用例列表
+*
+ */
+func RuleFixtures(c *gin.Context, ruleEngine typex.Rhilex) {
+	ruleId, _ := c.GetQuery("ruleId")
+	MFixtures, err := service.GetMRuleFixtures(ruleId)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	vos := []ruleFixtureVo{}
+	for _, m := range MFixtures {
+		vo, err := toRuleFixtureVo(m)
+		if err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		vos = append(vos, vo)
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(vos))
+}
+
+/*
+*
+* 新建测试用例
+*
+ */
+func CreateRuleFixture(c *gin.Context, ruleEngine typex.Rhilex) {
+	form := ruleFixtureVo{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if form.Name == "" {
+		c.JSON(common.HTTP_OK, common.Error("fixture name can not be empty"))
+		return
+	}
+	if _, err := service.GetMRule(form.RuleId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	bytes, _ := json.Marshal(form.Fixture)
+	MFixture := model.MRuleFixture{
+		UUID:    utils.RuleFixtureUuid(),
+		RuleId:  form.RuleId,
+		Name:    form.Name,
+		Fixture: string(bytes),
+	}
+	if err := service.InsertMRuleFixture(&MFixture); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(map[string]string{
+		"uuid": MFixture.UUID,
+	}))
+}
+
+/*
+*
+* 更新测试用例
+*
+ */
+func UpdateRuleFixture(c *gin.Context, ruleEngine typex.Rhilex) {
+	form := ruleFixtureVo{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if form.Name == "" {
+		c.JSON(common.HTTP_OK, common.Error("fixture name can not be empty"))
+		return
+	}
+	if _, err := service.GetMRuleFixture(form.UUID); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	bytes, _ := json.Marshal(form.Fixture)
+	if err := service.UpdateMRuleFixture(form.UUID, &model.MRuleFixture{
+		Name:    form.Name,
+		Fixture: string(bytes),
+	}); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 删除测试用例
+*
+ */
+func DeleteRuleFixture(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if err := service.DeleteMRuleFixture(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 执行测试用例; 传了 actions 就用传进来的脚本, 用来在保存之前验证修改
+*
+ */
+func RunRuleFixtures(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		RuleId   string   `json:"ruleId" binding:"required"`
+		Actions  string   `json:"actions"`
+		Success  string   `json:"success"`
+		Failed   string   `json:"failed"`
+		Fixtures []string `json:"fixtures"` // 为空执行全部
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	suite, err := loadRuleSuite(form.RuleId)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if form.Actions != "" {
+		suite.Rule.Actions = form.Actions
+	}
+	if form.Success != "" {
+		suite.Rule.Success = form.Success
+	}
+	if form.Failed != "" {
+		suite.Rule.Failed = form.Failed
+	}
+	if len(form.Fixtures) > 0 {
+		fixtures := []ruletest.Fixture{}
+		MFixtures, _ := service.GetMRuleFixtures(form.RuleId)
+		for i, m := range MFixtures {
+			if utils.SContains(form.Fixtures, m.UUID) {
+				fixtures = append(fixtures, suite.Fixtures[i])
+			}
+		}
+		suite.Fixtures = fixtures
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(ruletest.RunSuite(ruleEngine, suite)))
+}
+
+/*
+*
+* 导出测试集, 给 rhilex test-rules 在 CI 里用; 不传 ruleId 导出全部
+*
+ */
+func ExportRuleFixtures(c *gin.Context, ruleEngine typex.Rhilex) {
+	ruleId, _ := c.GetQuery("ruleId")
+	ruleIds := []string{ruleId}
+	if ruleId == "" {
+		ruleIds = []string{}
+		for _, rule := range service.AllMRules() {
+			ruleIds = append(ruleIds, rule.UUID)
+		}
+	}
+	modules, err := exportLuaModules()
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	suites := []ruletest.Suite{}
+	for _, id := range ruleIds {
+		suite, err := loadRuleSuite(id)
+		if err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		if ruleId == "" && len(suite.Fixtures) == 0 {
+			continue
+		}
+		suite.Modules = modules
+		suites = append(suites, suite)
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(suites))
+}
+
+func loadRuleSuite(ruleId string) (ruletest.Suite, error) {
+	MRule, err := service.GetMRule(ruleId)
+	if err != nil {
+		return ruletest.Suite{}, err
+	}
+	suite := ruletest.Suite{
+		Rule: ruletest.RuleScript{
+			UUID:    MRule.UUID,
+			Name:    MRule.Name,
+			Type:    MRule.Type,
+			Actions: MRule.Actions,
+			Success: MRule.Success,
+			Failed:  MRule.Failed,
+		},
+		Fixtures: []ruletest.Fixture{},
+	}
+	MFixtures, err := service.GetMRuleFixtures(ruleId)
+	if err != nil {
+		return suite, err
+	}
+	for _, m := range MFixtures {
+		vo, err := toRuleFixtureVo(m)
+		if err != nil {
+			return suite, err
+		}
+		suite.Fixtures = append(suite.Fixtures, vo.Fixture)
+	}
+	return suite, nil
+}
+
+// 命令行里没有数据库, 用户模块跟着测试集一起导出
+func exportLuaModules() (map[string]string, error) {
+	modules := map[string]string{}
+	MModules, err := service.AllMLuaModules()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range MModules {
+		modules[m.Name] = m.Source
+		MVersions, err := service.GetMLuaModuleVersions(m.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range MVersions {
+			modules[fmt.Sprintf("%s@%d", v.Name, v.Version)] = v.Source
+		}
+	}
+	return modules, nil
+}
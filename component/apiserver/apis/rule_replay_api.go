@@ -0,0 +1,88 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/ruletest"
+	"github.com/hootrhino/rhilex/component/trafficcapture"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 回放抓包: 用资源的抓包或者上传的抓包内容执行规则, 外部调用全部模拟;
+* 传了 actions/success/failed 就和保存的版本对比, 只返回输出不一样的记录
+*
+ */
+func ReplayRule(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		RuleId     string                `json:"ruleId" binding:"required"`
+		ResourceId string                `json:"resourceId"` // 用这个资源的抓包
+		Capture    string                `json:"capture"`    // 或者直接传抓包文件的内容
+		Speed      float64               `json:"speed"`
+		Mocks      ruletest.FixtureMocks `json:"mocks"`
+		Actions    string                `json:"actions"`
+		Success    string                `json:"success"`
+		Failed     string                `json:"failed"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	var records []trafficcapture.Record
+	var err error
+	if form.Capture != "" {
+		records, err = trafficcapture.Parse(strings.NewReader(form.Capture))
+	} else {
+		records, err = trafficcapture.Load(form.ResourceId)
+	}
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	suite, err := loadRuleSuite(form.RuleId)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	var candidate *ruletest.RuleScript
+	if form.Actions != "" || form.Success != "" || form.Failed != "" {
+		script := suite.Rule
+		if form.Actions != "" {
+			script.Actions = form.Actions
+		}
+		if form.Success != "" {
+			script.Success = form.Success
+		}
+		if form.Failed != "" {
+			script.Failed = form.Failed
+		}
+		candidate = &script
+	}
+	// 按原速回放的时候请求断开就停止
+	report, err := ruletest.Replay(c.Request.Context(), ruleEngine, suite.Rule, candidate, records,
+		ruletest.ReplayOptions{Speed: form.Speed, Mocks: form.Mocks})
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(report))
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/trafficcapture"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+func InitTrafficCaptureRoute() {
+	captureApi := server.RouteGroup(server.ContextUrl("/capture"))
+	{
+		captureApi.POST(("/start"), server.AddRoute(StartTrafficCapture))
+		captureApi.PUT(("/stop"), server.AddRoute(StopTrafficCapture))
+		captureApi.GET(("/list"), server.AddRoute(TrafficCaptures))
+		captureApi.GET(("/download"), server.AddRoute(DownloadTrafficCapture))
+		captureApi.DELETE(("/del"), server.AddRoute(DeleteTrafficCapture))
+	}
+}
+
+/*
+*
+* 开始抓资源推给规则的数据; duration 秒, 为 0 一直抓到手动停止
+*
+ */
+func StartTrafficCapture(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		ResourceId string `json:"resourceId" binding:"required"`
+		Duration   int    `json:"duration"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if ruleEngine.GetInEnd(form.ResourceId) == nil && ruleEngine.GetDevice(form.ResourceId) == nil {
+		c.JSON(common.HTTP_OK, common.Error("resource not exists: "+form.ResourceId))
+		return
+	}
+	if err := trafficcapture.Start(form.ResourceId,
+		time.Duration(form.Duration)*time.Second); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+func StopTrafficCapture(c *gin.Context, ruleEngine typex.Rhilex) {
+	resourceId, _ := c.GetQuery("resourceId")
+	if err := trafficcapture.Stop(resourceId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+func TrafficCaptures(c *gin.Context, ruleEngine typex.Rhilex) {
+	c.JSON(common.HTTP_OK, common.OkWithData(trafficcapture.List()))
+}
+
+/*
+*
+* 下载抓包文件, 轮转的文件按时间顺序拼在一起, 一行一条记录
+*
+ */
+func DownloadTrafficCapture(c *gin.Context, ruleEngine typex.Rhilex) {
+	resourceId, _ := c.GetQuery("resourceId")
+	if !trafficcapture.Exists(resourceId) {
+		c.JSON(common.HTTP_OK, common.Error("capture not exists: "+resourceId))
+		return
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%s_%v.jsonl",
+		resourceId, time.Now().UnixMilli()))
+	trafficcapture.Export(c.Writer, resourceId)
+}
+
+func DeleteTrafficCapture(c *gin.Context, ruleEngine typex.Rhilex) {
+	resourceId, _ := c.GetQuery("resourceId")
+	if err := trafficcapture.Remove(resourceId); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
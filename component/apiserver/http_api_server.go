@@ -14,6 +14,7 @@ import (
 	"github.com/shirou/gopsutil/cpu"
 
 	"github.com/hootrhino/rhilex/applet"
+	"github.com/hootrhino/rhilex/cecolla"
 	"github.com/hootrhino/rhilex/component/apiserver/apis"
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/server"
@@ -93,16 +94,26 @@ func initRhilex(engine typex.Rhilex) {
 			glogger.GLogger.Error("Device load failed:", err)
 		}
 	}
+	// 云边协同资源要用到设备, 放在设备后面
+	for _, mCecolla := range service.AllCecollas() {
+		if err := cecolla.LoadCecollaResource(mCecolla.UUID, mCecolla.Name,
+			mCecolla.Type, mCecolla.GetConfig(), mCecolla.Description); err != nil {
+			glogger.GLogger.Error("Cecolla load failed:", err)
+		}
+	}
+	// 订阅规则流的规则
+	StreamRules, _ := service.GetStreamMRules()
+	for _, mRule := range StreamRules {
+		if err := server.LoadStreamRule(mRule.UUID, engine); err != nil {
+			glogger.GLogger.Error("Stream rule load failed:", err)
+		}
+	}
 	//
 	// APP stack
 	//
 	for _, mApp := range service.AllApp() {
-		app := applet.NewApplication(
-			mApp.UUID,
-			mApp.Name,
-			mApp.Version,
-		)
-		if err := applet.LoadApp(app, mApp.LuaSource); err != nil {
+		app, err := server.LoadApplet(&mApp)
+		if err != nil {
 			glogger.GLogger.Error(err)
 			continue
 		}
@@ -126,6 +137,9 @@ func (hs *ApiServerPlugin) Init(config *ini.Section) error {
 		&model.MInEnd{},
 		&model.MOutEnd{},
 		&model.MRule{},
+		&model.MRuleFixture{},
+		&model.MLuaModule{},
+		&model.MLuaModuleVersion{},
 		&model.MUser{},
 		&model.MDevice{},
 		&model.MCecolla{},
@@ -137,6 +151,9 @@ func (hs *ApiServerPlugin) Init(config *ini.Section) error {
 		&model.MNetworkConfig{},
 		&model.MIotSchema{},
 		&model.MIotProperty{},
+		&model.MIotEvent{},
+		&model.MIotService{},
+		&model.MIotBinding{},
 		&model.MIpRoute{},
 		&model.MUart{},
 		&model.MUserLuaTemplate{},
@@ -159,6 +176,8 @@ func (hs *ApiServerPlugin) Init(config *ini.Section) error {
 	server.DefaultApiServer.InitializeUnixData()
 	// InitDataSchemaCache
 	dataschema.InitDataSchemaCache(hs.ruleEngine)
+	// 点位绑定到模型属性
+	dataschema.StartIoTBinding(time.Second)
 	// Cron Reboot Executor
 	crontask.InitCronRebootExecutor(hs.ruleEngine)
 	initRhilex(hs.ruleEngine)
@@ -179,6 +198,10 @@ func (hs *ApiServerPlugin) LoadRoute() {
 	apis.InitInEndRoute()
 	// Rules
 	apis.InitRulesRoute()
+	// Lua 模块
+	apis.InitLuaModuleRoute()
+	// 流量抓包
+	apis.InitTrafficCaptureRoute()
 	// Out End
 	apis.InitOutEndRoute()
 	// System API
@@ -250,6 +273,7 @@ func (hs *ApiServerPlugin) Start(r typex.Rhilex) error {
 }
 
 func (hs *ApiServerPlugin) Stop() error {
+	dataschema.StopIoTBinding()
 	dataschema.FlushDataSchemaCache()
 	return nil
 }
@@ -22,10 +22,16 @@ package model
  */
 type MApplet struct {
 	RhilexModel
-	UUID        string `gorm:"uniqueIndex"` // 名称
-	Name        string `gorm:"not null"`    // 名称
-	Version     string `gorm:"not null"`    // 版本号
-	AutoStart   *bool  `gorm:"not null"`    // 允许启动
-	LuaSource   string `gorm:"not null"`    // LuaSource
-	Description string `gorm:"not null"`    // 文件路径, 是相对于main的apps目录
+	UUID          string `gorm:"uniqueIndex"` // 名称
+	Name          string `gorm:"not null"`    // 名称
+	Version       string `gorm:"not null"`    // 版本号
+	AutoStart     *bool  `gorm:"not null"`    // 允许启动
+	LuaSource     string `gorm:"not null"`    // LuaSource
+	Description   string `gorm:"not null"`    // 文件路径, 是相对于main的apps目录
+	RestartPolicy string // 重启策略: never|on-failure|always
+	Schedule      string // 定时执行的 cron 表达式
+	Quota         string // 资源配额, JSON
+	Config        string // 应用配置, JSON, Lua 里是 AppConfig 表
+	Manifest      string // 从应用包安装的: 清单, JSON
+	Signer        string // 从应用包安装的: 签名的公钥 ID
 }
@@ -45,3 +45,54 @@ type MIotProperty struct {
 	Rule        string `gorm:"not null"` // 规则,IoTPropertyRule
 	Description string // 额外信息
 }
+
+/*
+*
+* 事件, 输出参数是 JSON 数组, 见 dataschema.IoTParam
+*
+ */
+type MIotEvent struct {
+	RhilexModel
+	SchemaId    string `gorm:"not null"`
+	UUID        string `gorm:"not null"`
+	Label       string `gorm:"not null"`
+	Name        string `gorm:"not null"`
+	Severity    string `gorm:"not null"` // INFO|WARNING|ALERT|FAULT
+	Outputs     string `gorm:"not null"` // 输出参数
+	Description string
+}
+
+/*
+*
+* 服务, 调用的时候转成设备指令 Command
+*
+ */
+type MIotService struct {
+	RhilexModel
+	SchemaId    string `gorm:"not null"`
+	UUID        string `gorm:"not null"`
+	Label       string `gorm:"not null"`
+	Name        string `gorm:"not null"`
+	Command     string // 设备指令, 空的时候就是服务名
+	Inputs      string `gorm:"not null"` // 输入参数
+	Outputs     string `gorm:"not null"` // 输出参数
+	Description string
+}
+
+/*
+*
+* 点位绑定: 设备点位 -> 模型属性
+*
+ */
+type MIotBinding struct {
+	RhilexModel
+	UUID        string `gorm:"not null"`
+	SchemaId    string `gorm:"not null"`
+	Property    string `gorm:"not null"` // 属性名
+	DeviceUuid  string `gorm:"not null"`
+	Tag         string `gorm:"not null"` // 点位 tag
+	Expr        string // 点位值转属性值
+	WriteExpr   string // 属性值转点位值
+	CtrlCommand string // 写点位的设备指令
+	Description string
+}
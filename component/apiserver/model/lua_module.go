@@ -0,0 +1,42 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+/*
+*
+* 用户的 Lua 模块, 规则/应用里可以 require; 每次更新版本号加一
+*
+ */
+type MLuaModule struct {
+	RhilexModel
+	UUID        string `gorm:"not null"`
+	Name        string `gorm:"not null;uniqueIndex"`
+	Version     int    `gorm:"not null"` // 最新版本
+	Source      string `gorm:"not null"` // 最新版本的源码
+	Description string
+}
+
+/*
+*
+* 模块的历史版本, 用 require("name@version") 固定版本
+*
+ */
+type MLuaModuleVersion struct {
+	RhilexModel
+	Name    string `gorm:"not null;index"`
+	Version int    `gorm:"not null"`
+	Source  string `gorm:"not null"`
+}
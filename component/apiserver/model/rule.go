@@ -19,10 +19,25 @@ type MRule struct {
 	RhilexModel
 	UUID        string `gorm:"not null"`
 	Name        string `gorm:"not null"`
+	Type        string `gorm:"default:'lua'"` // lua, expr; 声明式规则的定义放在 Actions 里
 	SourceId    string `gorm:"not null"`
 	DeviceId    string `gorm:"not null"`
+	StreamId    string // 订阅的规则流
 	Actions     string `gorm:"not null"`
 	Success     string `gorm:"not null"`
 	Failed      string `gorm:"not null"`
 	Description string
 }
+
+/*
+*
+* 规则的测试用例, Fixture 是 ruletest.Fixture 的 JSON
+*
+ */
+type MRuleFixture struct {
+	RhilexModel
+	UUID    string `gorm:"not null"`
+	RuleId  string `gorm:"not null"`
+	Name    string `gorm:"not null"`
+	Fixture string `gorm:"not null"`
+}
@@ -6,7 +6,10 @@ import (
 
 	"encoding/json"
 
+	"github.com/hootrhino/rhilex/applet"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/appletbundle"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
 )
@@ -50,8 +53,9 @@ func LoadNewestInEnd(uuid string, ruleEngine typex.Rhilex) error {
 			return err1
 		}
 		glogger.GLogger.Debugf("Load rule:(%s,%s)", mRule.UUID, mRule.Name)
-		RuleInstance := typex.NewLuaRule(
+		RuleInstance := typex.NewTypedRule(
 			ruleEngine,
+			mRule.Type,
 			mRule.UUID,
 			mRule.Name,
 			mRule.Description,
@@ -142,8 +146,9 @@ func LoadNewestDevice(uuid string, ruleEngine typex.Rhilex) error {
 			return err1
 		}
 		glogger.GLogger.Debugf("Load rule:(%s,%s)", mRule.UUID, mRule.Name)
-		RuleInstance := typex.NewLuaRule(
+		RuleInstance := typex.NewTypedRule(
 			ruleEngine,
+			mRule.Type,
 			mRule.UUID,
 			mRule.Name,
 			mRule.Description,
@@ -169,3 +174,67 @@ func LoadNewestDevice(uuid string, ruleEngine typex.Rhilex) error {
 	return nil
 
 }
+
+/*
+*
+* 订阅规则流的规则不绑定资源, 直接从数据库加载
+*
+ */
+func LoadStreamRule(uuid string, ruleEngine typex.Rhilex) error {
+	mRule, err := service.GetMRuleWithUUID(uuid)
+	if err != nil {
+		return err
+	}
+	rule := typex.NewTypedRule(
+		ruleEngine,
+		mRule.Type,
+		mRule.UUID,
+		mRule.Name,
+		mRule.Description,
+		"",
+		"",
+		mRule.Success,
+		mRule.Actions,
+		mRule.Failed)
+	rule.FromStream = mRule.StreamId
+	ruleEngine.RemoveRule(rule.UUID)
+	return ruleEngine.LoadRule(rule)
+}
+
+/*
+*
+* 从数据库记录加载小程序到内存, 并不启动
+*
+ */
+func LoadApplet(mApp *model.MApplet) (*applet.Application, error) {
+	app := applet.NewApplication(mApp.UUID, mApp.Name, mApp.Version)
+	app.AutoStart = mApp.AutoStart != nil && *mApp.AutoStart
+	app.Description = mApp.Description
+	app.RestartPolicy = mApp.RestartPolicy
+	app.Schedule = mApp.Schedule
+	if mApp.Quota != "" {
+		if err := json.Unmarshal([]byte(mApp.Quota), &app.Quota); err != nil {
+			return nil, err
+		}
+	}
+	if mApp.Config != "" {
+		if err := json.Unmarshal([]byte(mApp.Config), &app.Config); err != nil {
+			return nil, err
+		}
+	}
+	if mApp.Manifest != "" {
+		manifest := appletbundle.Manifest{}
+		if err := json.Unmarshal([]byte(mApp.Manifest), &manifest); err != nil {
+			return nil, err
+		}
+		files, err := appletbundle.LoadFiles(mApp.UUID)
+		if err != nil {
+			return nil, err
+		}
+		app.Files = appletbundle.LuaModules(files, manifest.Main)
+	}
+	if err := applet.LoadApp(app, mApp.LuaSource); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
@@ -18,6 +18,7 @@ package service
 import (
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/interdb"
+	"gorm.io/gorm"
 )
 
 // -------------------------------------------------------------------------------------
@@ -60,3 +61,43 @@ func UpdateApp(app *model.MApplet) error {
 		return nil
 	}
 }
+
+// 更新生命周期配置, 用 map 更新, 空值也要写进去
+func UpdateAppLifecycle(uuid, restartPolicy, schedule, quota string) error {
+	return interdb.InterDb().Model(&model.MApplet{}).Where("uuid=?", uuid).
+		Updates(map[string]any{
+			"restart_policy": restartPolicy,
+			"schedule":       schedule,
+			"quota":          quota,
+		}).Error
+}
+
+// 更新应用配置
+func UpdateAppConfig(uuid, config string) error {
+	return interdb.InterDb().Model(&model.MApplet{}).Where("uuid=?", uuid).
+		Update("config", config).Error
+}
+
+/*
+*
+* 升级应用包: 脚本, 版本, 清单, 签名和配置放在一个事务里一起改, 不会只改一半;
+* 升级失败的时候拿旧的记录再调一次就回滚了
+*
+ */
+func UpgradeAppBundle(app *model.MApplet) error {
+	return interdb.InterDb().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("uuid=?", app.UUID).First(&model.MApplet{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.MApplet{}).Where("uuid=?", app.UUID).
+			Updates(map[string]any{
+				"name":        app.Name,
+				"version":     app.Version,
+				"lua_source":  app.LuaSource,
+				"description": app.Description,
+				"manifest":    app.Manifest,
+				"signer":      app.Signer,
+				"config":      app.Config,
+			}).Error
+	})
+}
@@ -52,7 +52,7 @@ func ResetSchema(schemaUuid string) error {
 			Update("published", new(bool)).Error; err != nil {
 			return err
 		}
-		return datacenter.DataCenterDb().Exec(fmt.Sprintf("DROP TABLE IF EXISTS data_center_%s;", schemaUuid)).Error
+		return datacenter.DropSchemaTables(schemaUuid)
 	})
 }
 
@@ -73,7 +73,7 @@ func DeleteDataSchemaAndProperty(schemaUuid string) error {
 		if CountIotSchemaProperty(MIotSchema.Name, MIotSchema.UUID) > 0 {
 			return fmt.Errorf("Schema Have Already Binding Properties")
 		}
-		return nil
+		return deleteIotSchemaFunctions(interdb.InterDb(), schemaUuid)
 	}
 	// 已经发布了，清空RHILEX数据库
 	return interdb.InterDb().Transaction(func(tx *gorm.DB) error {
@@ -87,8 +87,11 @@ func DeleteDataSchemaAndProperty(schemaUuid string) error {
 		if err1 != nil {
 			return err1
 		}
+		if err := deleteIotSchemaFunctions(tx, schemaUuid); err != nil {
+			return err
+		}
 		// 清空数据中心的表
-		err1Exec := datacenter.DataCenterDb().Exec(fmt.Sprintf("DROP TABLE IF EXISTS data_center_%s;", schemaUuid)).Error
+		err1Exec := datacenter.DropSchemaTables(schemaUuid)
 		if err1Exec != nil {
 			return err1Exec
 		}
@@ -145,3 +148,133 @@ func DeleteIotSchemaProperty(uuid string) error {
 	return interdb.InterDb().
 		Model(model.MIotProperty{}).Where("uuid=?", uuid).Delete(model.MIotProperty{}).Error
 }
+
+// 删除模型下的事件, 服务和点位绑定
+func deleteIotSchemaFunctions(tx *gorm.DB, schemaUuid string) error {
+	if err := tx.Model(model.MIotEvent{}).Where("schema_id=?", schemaUuid).
+		Delete(model.MIotEvent{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(model.MIotBinding{}).Where("schema_id=?", schemaUuid).
+		Delete(model.MIotBinding{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(model.MIotService{}).Where("schema_id=?", schemaUuid).
+		Delete(model.MIotService{}).Error
+}
+
+// 模型下的事件
+func AllIotSchemaEvent(schemaUuid string) ([]model.MIotEvent, error) {
+	m := []model.MIotEvent{}
+	return m, interdb.InterDb().Model(model.MIotEvent{}).
+		Where("schema_id=?", schemaUuid).Order("created_at DESC").Find(&m).Error
+}
+
+func FindIotSchemaEvent(uuid string) (model.MIotEvent, error) {
+	m := model.MIotEvent{}
+	return m, interdb.InterDb().Model(model.MIotEvent{}).Where("uuid=?", uuid).First(&m).Error
+}
+
+func CountIotSchemaEvent(name, schema_id string) int64 {
+	var count int64
+	interdb.InterDb().Model(model.MIotEvent{}).
+		Where("name=? and schema_id=?", name, schema_id).Count(&count)
+	return count
+}
+
+func InsertIotSchemaEvent(MIotEvent model.MIotEvent) error {
+	return interdb.InterDb().Model(model.MIotEvent{}).Create(&MIotEvent).Error
+}
+
+// 描述可以改成空, 所以整行保存
+func UpdateIotSchemaEvent(MIotEvent model.MIotEvent) error {
+	return interdb.InterDb().Model(MIotEvent).
+		Where("uuid=?", MIotEvent.UUID).
+		Select("label", "name", "severity", "outputs", "description").
+		Updates(&MIotEvent).Error
+}
+
+func DeleteIotSchemaEvent(uuid string) error {
+	return interdb.InterDb().Model(model.MIotEvent{}).
+		Where("uuid=?", uuid).Delete(model.MIotEvent{}).Error
+}
+
+// 模型下的服务
+func AllIotSchemaService(schemaUuid string) ([]model.MIotService, error) {
+	m := []model.MIotService{}
+	return m, interdb.InterDb().Model(model.MIotService{}).
+		Where("schema_id=?", schemaUuid).Order("created_at DESC").Find(&m).Error
+}
+
+func FindIotSchemaService(uuid string) (model.MIotService, error) {
+	m := model.MIotService{}
+	return m, interdb.InterDb().Model(model.MIotService{}).Where("uuid=?", uuid).First(&m).Error
+}
+
+func CountIotSchemaService(name, schema_id string) int64 {
+	var count int64
+	interdb.InterDb().Model(model.MIotService{}).
+		Where("name=? and schema_id=?", name, schema_id).Count(&count)
+	return count
+}
+
+func InsertIotSchemaService(MIotService model.MIotService) error {
+	return interdb.InterDb().Model(model.MIotService{}).Create(&MIotService).Error
+}
+
+// 指令和描述可以改成空, 所以整行保存
+func UpdateIotSchemaService(MIotService model.MIotService) error {
+	return interdb.InterDb().Model(MIotService).
+		Where("uuid=?", MIotService.UUID).
+		Select("label", "name", "command", "inputs", "outputs", "description").
+		Updates(&MIotService).Error
+}
+
+func DeleteIotSchemaService(uuid string) error {
+	return interdb.InterDb().Model(model.MIotService{}).
+		Where("uuid=?", uuid).Delete(model.MIotService{}).Error
+}
+
+// 模型下的点位绑定
+func AllIotSchemaBinding(schemaUuid string) ([]model.MIotBinding, error) {
+	m := []model.MIotBinding{}
+	return m, interdb.InterDb().Model(model.MIotBinding{}).
+		Where("schema_id=?", schemaUuid).Order("created_at DESC").Find(&m).Error
+}
+
+func FindIotSchemaBinding(uuid string) (model.MIotBinding, error) {
+	m := model.MIotBinding{}
+	return m, interdb.InterDb().Model(model.MIotBinding{}).Where("uuid=?", uuid).First(&m).Error
+}
+
+// 一个属性只能绑定一个点位
+func CountIotSchemaBinding(property, schema_id string) int64 {
+	var count int64
+	interdb.InterDb().Model(model.MIotBinding{}).
+		Where("property=? and schema_id=?", property, schema_id).Count(&count)
+	return count
+}
+
+func InsertIotSchemaBinding(MIotBinding model.MIotBinding) error {
+	return interdb.InterDb().Model(model.MIotBinding{}).Create(&MIotBinding).Error
+}
+
+// 表达式可以改成空, 所以整行保存
+func UpdateIotSchemaBinding(MIotBinding model.MIotBinding) error {
+	return interdb.InterDb().Model(MIotBinding).
+		Where("uuid=?", MIotBinding.UUID).
+		Select("property", "device_uuid", "tag", "expr", "write_expr", "ctrl_command", "description").
+		Updates(&MIotBinding).Error
+}
+
+func DeleteIotSchemaBinding(uuid string) error {
+	return interdb.InterDb().Model(model.MIotBinding{}).
+		Where("uuid=?", uuid).Delete(model.MIotBinding{}).Error
+}
+
+// 找模型里的属性
+func FindIotSchemaPropertyByName(schemaUuid, name string) (model.MIotProperty, error) {
+	m := model.MIotProperty{}
+	return m, interdb.InterDb().Model(model.MIotProperty{}).
+		Where("schema_id=? and name=?", schemaUuid, name).First(&m).Error
+}
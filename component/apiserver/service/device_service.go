@@ -132,3 +132,37 @@ gid = ?
 	interdb.InterDb().Raw(countSql, gid).Scan(&count)
 	return count, MDevices
 }
+
+// 点位表里的点位; 云边协同按 tag 上报
+type DevicePointTag struct {
+	UUID string
+	Tag  string
+}
+
+// 所有带点位表的设备
+var devicePointSheets = []any{
+	model.MModbusDataPoint{},
+	model.MSiemensDataPoint{},
+	model.MSnmpOid{},
+	model.MBacnetDataPoint{},
+	model.MBacnetRouterDataPoint{},
+	model.MDlt6452007DataPoint{},
+	model.MCjt1882004DataPoint{},
+	model.MSzy2062016DataPoint{},
+	model.MUserProtocolDataPoint{},
+	model.MMBusDataPoint{},
+}
+
+// 设备的所有点位, 不管是哪种点位表
+func DevicePointTags(deviceUuid string) ([]DevicePointTag, error) {
+	points := []DevicePointTag{}
+	for _, sheet := range devicePointSheets {
+		rows := []DevicePointTag{}
+		if err := interdb.InterDb().Model(sheet).Select("uuid, tag").
+			Where("device_uuid=?", deviceUuid).Order("id").Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		points = append(points, rows...)
+	}
+	return points, nil
+}
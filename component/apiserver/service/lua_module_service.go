@@ -0,0 +1,83 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"gorm.io/gorm"
+)
+
+func AllMLuaModules() ([]model.MLuaModule, error) {
+	m := []model.MLuaModule{}
+	return m, interdb.InterDb().Order("name").Find(&m).Error
+}
+
+func GetMLuaModule(name string) (*model.MLuaModule, error) {
+	m := new(model.MLuaModule)
+	return m, interdb.InterDb().Where("name=?", name).First(m).Error
+}
+
+func GetMLuaModuleVersions(name string) ([]model.MLuaModuleVersion, error) {
+	m := []model.MLuaModuleVersion{}
+	return m, interdb.InterDb().Where("name=?", name).Order("version desc").Find(&m).Error
+}
+
+/*
+*
+* 保存模块: 不存在就新建, 存在就版本号加一; 每个版本都留一份
+*
+ */
+func SaveMLuaModule(name, description, source string) (int, error) {
+	version := 1
+	err := interdb.InterDb().Transaction(func(tx *gorm.DB) error {
+		old := model.MLuaModule{}
+		if tx.Where("name=?", name).Limit(1).Find(&old); old.ID > 0 {
+			version = old.Version + 1
+			if err := tx.Model(&old).Updates(map[string]any{
+				"version":     version,
+				"source":      source,
+				"description": description,
+			}).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Create(&model.MLuaModule{
+				UUID:        name,
+				Name:        name,
+				Version:     version,
+				Source:      source,
+				Description: description,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&model.MLuaModuleVersion{
+			Name:    name,
+			Version: version,
+			Source:  source,
+		}).Error
+	})
+	return version, err
+}
+
+func DeleteMLuaModule(name string) error {
+	return interdb.InterDb().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("name=?", name).Delete(&model.MLuaModuleVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("name=?", name).Delete(&model.MLuaModule{}).Error
+	})
+}
@@ -34,6 +34,12 @@ func GetMRuleWithUUID(uuid string) (*model.MRule, error) {
 	return m, interdb.InterDb().Where("uuid=?", uuid).First(m).Error
 }
 
+// 订阅了规则流的规则, 不绑定资源, 启动的时候单独加载
+func GetStreamMRules() ([]model.MRule, error) {
+	m := []model.MRule{}
+	return m, interdb.InterDb().Where("stream_id != ''").Find(&m).Error
+}
+
 func InsertMRule(r *model.MRule) error {
 	return interdb.InterDb().Table("m_rules").Create(r).Error
 }
@@ -46,6 +52,39 @@ func UpdateMRule(uuid string, r *model.MRule) error {
 	return interdb.InterDb().Model(r).Where("uuid=?", uuid).Updates(*r).Error
 }
 
+// 订阅的流可以改成空, Updates 会忽略空字段所以单独更新
+func UpdateMRuleStream(uuid string, streamId string) error {
+	return interdb.InterDb().Model(&model.MRule{}).Where("uuid=?", uuid).Update("stream_id", streamId).Error
+}
+
+// -----------------------------------------------------------------------------------
+func GetMRuleFixtures(ruleId string) ([]model.MRuleFixture, error) {
+	m := []model.MRuleFixture{}
+	return m, interdb.InterDb().Where("rule_id=?", ruleId).Order("id").Find(&m).Error
+}
+
+func GetMRuleFixture(uuid string) (*model.MRuleFixture, error) {
+	m := new(model.MRuleFixture)
+	return m, interdb.InterDb().Where("uuid=?", uuid).First(m).Error
+}
+
+func InsertMRuleFixture(m *model.MRuleFixture) error {
+	return interdb.InterDb().Create(m).Error
+}
+
+func UpdateMRuleFixture(uuid string, m *model.MRuleFixture) error {
+	return interdb.InterDb().Model(m).Where("uuid=?", uuid).Updates(*m).Error
+}
+
+func DeleteMRuleFixture(uuid string) error {
+	return interdb.InterDb().Where("uuid=?", uuid).Delete(&model.MRuleFixture{}).Error
+}
+
+// 删除规则的时候一起删掉
+func DeleteMRuleFixtures(ruleId string) error {
+	return interdb.InterDb().Where("rule_id=?", ruleId).Delete(&model.MRuleFixture{}).Error
+}
+
 // -----------------------------------------------------------------------------------
 func AllMRules() []model.MRule {
 	rules := []model.MRule{}
@@ -0,0 +1,277 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package appletbundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	lua "github.com/hootrhino/gopher-lua"
+)
+
+const (
+	MANIFEST_FILE  = "manifest.json"
+	SIGNATURE_FILE = "manifest.sig"
+	MaxBundleSize  = 32 << 20 // 解压以后的总大小
+	MaxBundleFiles = 256
+)
+
+/*
+*
+* 应用包: 一个 zip, 里面有清单, 入口脚本, 其他 Lua 文件和静态资源;
+* 签名是 manifest.sig, 对其他所有文件的摘要做 ed25519 签名
+*
+ */
+type Bundle struct {
+	Manifest Manifest
+	Files    map[string][]byte // 清单和签名以外的文件, 路径用 /
+	Signer   string            // 验证通过的公钥 ID, 没有签名为空
+}
+
+// 入口脚本
+func (b *Bundle) MainSource() string {
+	return string(b.Files[b.Manifest.Main])
+}
+
+/*
+*
+* 只编译不执行包里所有的 Lua 文件; 入口要在加载的时候才知道有没有 Main
+*
+ */
+func (b *Bundle) CheckSyntax() error {
+	tempVm := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer tempVm.Close()
+	for _, name := range sortedNames(b.Files) {
+		if !strings.HasSuffix(name, ".lua") {
+			continue
+		}
+		if _, err := tempVm.LoadString(string(b.Files[name])); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+/*
+*
+* 读取应用包; 有签名的必须能用受信任的公钥验证通过,
+* requireSignature 为 true 的时候不接受没有签名的包
+*
+ */
+func Read(data []byte, trustedKeys []ed25519.PublicKey, requireSignature bool) (*Bundle, error) {
+	files, signature, err := readZip(data)
+	if err != nil {
+		return nil, err
+	}
+	manifestBytes, ok := files[MANIFEST_FILE]
+	if !ok {
+		return nil, fmt.Errorf("missing %s", MANIFEST_FILE)
+	}
+	bundle := &Bundle{Files: map[string][]byte{}}
+	if err := json.Unmarshal(manifestBytes, &bundle.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", MANIFEST_FILE, err)
+	}
+	if err := bundle.Manifest.Validate(); err != nil {
+		return nil, err
+	}
+	if signature != nil {
+		signer, err := verify(files, signature, trustedKeys)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Signer = signer
+	} else if requireSignature {
+		return nil, fmt.Errorf("bundle is not signed")
+	}
+	for name, content := range files {
+		if name != MANIFEST_FILE {
+			bundle.Files[name] = content
+		}
+	}
+	if _, ok := bundle.Files[bundle.Manifest.Main]; !ok {
+		return nil, fmt.Errorf("main file not exists: %s", bundle.Manifest.Main)
+	}
+	return bundle, nil
+}
+
+func readZip(data []byte) (map[string][]byte, []byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid bundle: %s", err)
+	}
+	files := map[string][]byte{}
+	var signature []byte
+	var total int64
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := cleanPath(f.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(files) >= MaxBundleFiles {
+			return nil, nil, fmt.Errorf("too many files in bundle, max %d", MaxBundleFiles)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		// 不信任 zip 里记录的大小, 按实际读到的算
+		content, err := io.ReadAll(io.LimitReader(rc, MaxBundleSize-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		total += int64(len(content))
+		if total > MaxBundleSize {
+			return nil, nil, fmt.Errorf("bundle too large, max %d bytes", MaxBundleSize)
+		}
+		if name == SIGNATURE_FILE {
+			signature = content
+			continue
+		}
+		files[name] = content
+	}
+	return files, signature, nil
+}
+
+// 包里的路径只能是相对路径, 不能跳出去
+func cleanPath(name string) (string, error) {
+	if strings.Contains(name, "\\") || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("invalid file path in bundle: %s", name)
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid file path in bundle: %s", name)
+	}
+	return cleaned, nil
+}
+
+/*
+*
+* 写应用包, key 不为空的时候签名
+*
+ */
+func Write(w io.Writer, bundle *Bundle, key ed25519.PrivateKey) error {
+	manifestBytes, err := json.MarshalIndent(bundle.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{MANIFEST_FILE: manifestBytes}
+	for name, content := range bundle.Files {
+		name, err := cleanPath(name)
+		if err != nil {
+			return err
+		}
+		if name == MANIFEST_FILE || name == SIGNATURE_FILE {
+			continue
+		}
+		files[name] = content
+	}
+	return writeZip(w, files, key)
+}
+
+func writeZip(w io.Writer, files map[string][]byte, key ed25519.PrivateKey) error {
+	writer := zip.NewWriter(w)
+	for _, name := range sortedNames(files) {
+		f, err := writer.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if key != nil {
+		f, err := writer.Create(SIGNATURE_FILE)
+		if err != nil {
+			return err
+		}
+		signature := ed25519.Sign(key, Digest(files))
+		if _, err := f.Write([]byte(base64.StdEncoding.EncodeToString(signature))); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+/*
+*
+* 给打好的包签名, 已经有的签名会被替换; 供应商发布应用的时候用
+*
+ */
+func Sign(data []byte, key ed25519.PrivateKey) ([]byte, error) {
+	files, _, err := readZip(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := files[MANIFEST_FILE]; !ok {
+		return nil, fmt.Errorf("missing %s", MANIFEST_FILE)
+	}
+	buffer := bytes.Buffer{}
+	if err := writeZip(&buffer, files, key); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+/*
+*
+* 摘要: 按路径排序, 每个文件一行 "sha256  路径", 再整体取 sha256
+*
+ */
+func Digest(files map[string][]byte) []byte {
+	hash := sha256.New()
+	for _, name := range sortedNames(files) {
+		sum := sha256.Sum256(files[name])
+		fmt.Fprintf(hash, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	return hash.Sum(nil)
+}
+
+func verify(files map[string][]byte, signature []byte, trustedKeys []ed25519.PublicKey) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return "", fmt.Errorf("invalid signature: %s", err)
+	}
+	digest := Digest(files)
+	for _, key := range trustedKeys {
+		if ed25519.Verify(key, digest, decoded) {
+			return KeyId(key), nil
+		}
+	}
+	return "", fmt.Errorf("signature verification failed: no trusted key matched")
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
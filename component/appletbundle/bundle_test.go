@@ -0,0 +1,197 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package appletbundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"testing"
+)
+
+func testBundle() *Bundle {
+	return &Bundle{
+		Manifest: Manifest{
+			Name:    "demo",
+			Version: "1.2.0",
+			ConfigSchema: map[string]ConfigField{
+				"interval": {Type: "integer", Required: true},
+				"mode":     {Type: "string", Enum: []any{"fast", "slow"}},
+			},
+			DefaultConfig: map[string]any{"interval": float64(5)},
+		},
+		Files: map[string][]byte{
+			"main.lua":      []byte(`local u = require("lib.util") function Main(arg) return u.f() end`),
+			"lib/util.lua":  []byte(`return { f = function() return 0 end }`),
+			"static/a.json": []byte(`{}`),
+		},
+	}
+}
+
+// go test -timeout 30s -run ^Test_Applet_Bundle_Sign github.com/hootrhino/rhilex/component/appletbundle -v -count=1
+func Test_Applet_Bundle_Sign(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	signed := bytes.Buffer{}
+	if err := Write(&signed, testBundle(), privateKey); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := Read(signed.Bytes(), []ed25519.PublicKey{otherKey, publicKey}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Signer != KeyId(publicKey) || bundle.Manifest.Main != "main.lua" {
+		t.Fatal("unexpected bundle", bundle.Signer, bundle.Manifest.Main)
+	}
+	if err := bundle.CheckSyntax(); err != nil {
+		t.Fatal(err)
+	}
+	modules := LuaModules(bundle.Files, bundle.Manifest.Main)
+	if len(modules) != 1 || modules["lib.util"] == "" {
+		t.Fatal("unexpected modules", modules)
+	}
+	if _, err := Read(signed.Bytes(), []ed25519.PublicKey{otherKey}, false); err == nil {
+		t.Fatal("untrusted signer should be rejected")
+	}
+	unsigned := bytes.Buffer{}
+	if err := Write(&unsigned, testBundle(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(unsigned.Bytes(), nil, true); err == nil {
+		t.Fatal("unsigned bundle should be rejected")
+	}
+	if _, err := Read(unsigned.Bytes(), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	// 签名以后改了文件
+	resigned, err := Sign(unsigned.Bytes(), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(resigned, []ed25519.PublicKey{publicKey}, true); err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Buffer{}
+	w := zip.NewWriter(&tampered)
+	r, _ := zip.NewReader(bytes.NewReader(resigned), int64(len(resigned)))
+	for _, f := range r.File {
+		rc, _ := f.Open()
+		content := bytes.Buffer{}
+		content.ReadFrom(rc)
+		rc.Close()
+		if f.Name == "lib/util.lua" {
+			content.WriteString("\n-- changed")
+		}
+		fw, _ := w.Create(f.Name)
+		fw.Write(content.Bytes())
+	}
+	w.Close()
+	if _, err := Read(tampered.Bytes(), []ed25519.PublicKey{publicKey}, false); err == nil {
+		t.Fatal("tampered bundle should be rejected")
+	}
+}
+
+// go test -timeout 30s -run ^Test_Applet_Bundle_Manifest github.com/hootrhino/rhilex/component/appletbundle -v -count=1
+func Test_Applet_Bundle_Manifest(t *testing.T) {
+	m := testBundle().Manifest
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ValidateConfig(map[string]any{}); err == nil {
+		t.Fatal("required config should be checked")
+	}
+	if err := m.ValidateConfig(map[string]any{"interval": 1.5}); err == nil {
+		t.Fatal("integer config should be checked")
+	}
+	if err := m.ValidateConfig(map[string]any{"interval": float64(1), "mode": "other"}); err == nil {
+		t.Fatal("enum config should be checked")
+	}
+	merged := m.MergeConfig(map[string]any{"interval": float64(9), "mode": "fast"})
+	if merged["interval"] != float64(9) || merged["mode"] != "fast" {
+		t.Fatal("unexpected merged config", merged)
+	}
+	m.Main = "../main.lua"
+	if err := m.Validate(); err == nil {
+		t.Fatal("main out of bundle should be rejected")
+	}
+	if CompareVersion("1.10.0", "1.9.9") <= 0 || CompareVersion("1.0.0", "1.0.0") != 0 {
+		t.Fatal("unexpected version compare")
+	}
+	if _, err := cleanPath("a/../../etc/passwd"); err == nil {
+		t.Fatal("path out of bundle should be rejected")
+	}
+}
+
+// go test -timeout 30s -run ^Test_Applet_Bundle_Store github.com/hootrhino/rhilex/component/appletbundle -v -count=1
+func Test_Applet_Bundle_Store(t *testing.T) {
+	__BundleDir = t.TempDir()
+	if err := SaveFiles("APP1", testBundle().Files); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveFiles("APP1", map[string][]byte{"main.lua": []byte("-- v2")}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := LoadFiles("APP1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 升级以后旧的文件不会留下来
+	if len(files) != 1 || string(files["main.lua"]) != "-- v2" {
+		t.Fatal("unexpected files", files)
+	}
+	// 换上去以后加载失败, 换回旧的
+	if err := StageFiles("APP1", map[string][]byte{"main.lua": []byte("-- v3")}); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := LoadFiles("APP1"); string(files["main.lua"]) != "-- v2" {
+		t.Fatal("staged files should not be visible", files)
+	}
+	if err := SwapFiles("APP1"); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := LoadFiles("APP1"); string(files["main.lua"]) != "-- v3" {
+		t.Fatal("unexpected files", files)
+	}
+	if err := RestoreFiles("APP1"); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := LoadFiles("APP1"); len(files) != 1 || string(files["main.lua"]) != "-- v2" {
+		t.Fatal("files should be restored", files)
+	}
+	// 原来没有文件的应用, 换回去就是没有
+	if err := StageFiles("APP2", map[string][]byte{"main.lua": []byte("-- v1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SwapFiles("APP2"); err != nil {
+		t.Fatal(err)
+	}
+	RestoreFiles("APP2")
+	if files, _ := LoadFiles("APP2"); len(files) != 0 {
+		t.Fatal("files should be removed", files)
+	}
+	if err := StageFiles("APP2", map[string][]byte{"../x.lua": nil}); err == nil {
+		t.Fatal("bad path should fail")
+	}
+	if entries, _ := os.ReadDir(__BundleDir); len(entries) != 1 {
+		t.Fatal("staged files should be cleaned", entries)
+	}
+	RemoveFiles("APP1")
+	if files, _ := LoadFiles("APP1"); len(files) != 0 {
+		t.Fatal("files should be removed")
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package appletbundle
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+var (
+	__trustedKeys      []ed25519.PublicKey
+	__requireSignature bool
+	__keysLock         sync.RWMutex
+)
+
+/*
+*
+* 加载受信任的公钥; 配置写错的公钥跳过, 不影响启动
+*
+ */
+func InitAppletBundle(config typex.RhilexConfig) {
+	keys := []ed25519.PublicKey{}
+	for _, key := range config.AppletTrustedKeys {
+		parsed, err := ParsePublicKeys([]string{key})
+		if err != nil {
+			glogger.GLogger.Error("Applet bundle trusted key ignored:", err)
+			continue
+		}
+		keys = append(keys, parsed...)
+	}
+	__keysLock.Lock()
+	__trustedKeys = keys
+	__requireSignature = config.AppletRequireSignature
+	__keysLock.Unlock()
+}
+
+// 按配置读取应用包
+func ReadTrusted(data []byte) (*Bundle, error) {
+	__keysLock.RLock()
+	keys, require := __trustedKeys, __requireSignature
+	__keysLock.RUnlock()
+	return Read(data, keys, require)
+}
+
+// 受信任的公钥 ID
+func TrustedKeyIds() []string {
+	__keysLock.RLock()
+	defer __keysLock.RUnlock()
+	ids := []string{}
+	for _, key := range __trustedKeys {
+		ids = append(ids, KeyId(key))
+	}
+	return ids
+}
+
+// 公钥 ID: 公钥 sha256 的前 8 个字节
+func KeyId(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// 受信任的公钥, base64 编码
+func ParsePublicKeys(keys []string) ([]ed25519.PublicKey, error) {
+	publicKeys := []ed25519.PublicKey{}
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(decoded) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key: %s", key)
+		}
+		publicKeys = append(publicKeys, ed25519.PublicKey(decoded))
+	}
+	return publicKeys, nil
+}
+
+// 签名用的私钥, base64 编码
+func ParsePrivateKey(key string) (ed25519.PrivateKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(decoded) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key")
+	}
+	return ed25519.PrivateKey(decoded), nil
+}
+
+// 生成一对密钥, 都是 base64 编码
+func GenerateKey() (string, string, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(publicKey),
+		base64.StdEncoding.EncodeToString(privateKey), nil
+}
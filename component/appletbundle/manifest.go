@@ -0,0 +1,234 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package appletbundle
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hootrhino/rhilex/applet"
+	"github.com/hootrhino/rhilex/component/luamodule"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+var versionRegexp = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)
+
+/*
+*
+* 清单 manifest.json
+*
+ */
+type Manifest struct {
+	Name          string                 `json:"name"`
+	Version       string                 `json:"version"` // x.y.z
+	Description   string                 `json:"description"`
+	Vendor        string                 `json:"vendor"`
+	Main          string                 `json:"main"`          // 入口脚本, 默认 main.lua
+	Modules       []string               `json:"modules"`       // 依赖的 Lua 模块, name 或者 name@version
+	DeviceTypes   []string               `json:"deviceTypes"`   // 依赖的设备类型
+	ConfigSchema  map[string]ConfigField `json:"configSchema"`  // 配置项说明
+	DefaultConfig map[string]any         `json:"defaultConfig"` // 默认配置
+	AutoStart     bool                   `json:"autoStart"`
+	RestartPolicy string                 `json:"restartPolicy"`
+	Schedule      string                 `json:"schedule"`
+	Quota         applet.AppQuota        `json:"quota"`
+}
+
+// 配置项
+type ConfigField struct {
+	Type        string `json:"type"` // string|number|integer|boolean|object|array
+	Required    bool   `json:"required"`
+	Description string `json:"description"`
+	Enum        []any  `json:"enum"`
+}
+
+func (m *Manifest) Validate() error {
+	if ok, r := utils.IsValidNameLength(m.Name); !ok {
+		return fmt.Errorf("invalid manifest name: %s", r)
+	}
+	if !versionRegexp.MatchString(m.Version) {
+		return fmt.Errorf("invalid manifest version, must be x.y.z: %s", m.Version)
+	}
+	if m.Main == "" {
+		m.Main = "main.lua"
+	}
+	main, err := cleanPath(m.Main)
+	if err != nil || !strings.HasSuffix(main, ".lua") {
+		return fmt.Errorf("invalid main file: %s", m.Main)
+	}
+	m.Main = main
+	for _, module := range m.Modules {
+		name, _, _ := strings.Cut(module, "@")
+		if err := luamodule.ValidateName(name); err != nil {
+			return err
+		}
+	}
+	for key, field := range m.ConfigSchema {
+		switch field.Type {
+		case "string", "number", "integer", "boolean", "object", "array":
+		default:
+			return fmt.Errorf("invalid type of config '%s': %s", key, field.Type)
+		}
+	}
+	if err := m.ValidateConfig(m.DefaultConfig); err != nil {
+		return fmt.Errorf("invalid default config: %s", err)
+	}
+	return applet.ValidateLifecycle(m.RestartPolicy, m.Schedule, m.Quota)
+}
+
+/*
+*
+* 按清单里的配置项检查配置, 没有说明的配置项不检查
+*
+ */
+func (m *Manifest) ValidateConfig(config map[string]any) error {
+	keys := make([]string, 0, len(m.ConfigSchema))
+	for key := range m.ConfigSchema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := m.ConfigSchema[key]
+		value, ok := config[key]
+		if !ok || value == nil {
+			if field.Required {
+				return fmt.Errorf("config '%s' is required", key)
+			}
+			continue
+		}
+		if !matchType(field.Type, value) {
+			return fmt.Errorf("config '%s' must be %s", key, field.Type)
+		}
+		if len(field.Enum) > 0 && !inEnum(field.Enum, value) {
+			return fmt.Errorf("config '%s' must be one of %v", key, field.Enum)
+		}
+	}
+	return nil
+}
+
+// 配置是 JSON 解出来的
+func matchType(t string, value any) bool {
+	switch t {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	}
+	return false
+}
+
+func inEnum(enum []any, value any) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*
+* 升级的时候合并配置: 新版本的默认值打底, 已有的配置覆盖上去
+*
+ */
+func (m *Manifest) MergeConfig(current map[string]any) map[string]any {
+	config := map[string]any{}
+	for k, v := range m.DefaultConfig {
+		config[k] = v
+	}
+	for k, v := range current {
+		config[k] = v
+	}
+	return config
+}
+
+/*
+*
+* 检查依赖: Lua 模块要存在, 设备类型要在这个网关上支持
+*
+ */
+func (m *Manifest) CheckRequirements(checkDeviceType func(typex.DeviceType) error) error {
+	for _, module := range m.Modules {
+		name, v, _ := strings.Cut(module, "@")
+		version := 0
+		if v != "" {
+			version, _ = strconv.Atoi(v)
+		}
+		if _, err := luamodule.GetSource(name, version); err != nil {
+			return fmt.Errorf("required lua module not exists: %s", module)
+		}
+	}
+	for _, t := range m.DeviceTypes {
+		if err := checkDeviceType(typex.DeviceType(t)); err != nil {
+			return fmt.Errorf("required device type not supported: %s", t)
+		}
+	}
+	return nil
+}
+
+// 比较 x.y.z 格式的版本号
+func CompareVersion(a, b string) int {
+	pa, pb := versionRegexp.FindStringSubmatch(a), versionRegexp.FindStringSubmatch(b)
+	if pa == nil || pb == nil {
+		return strings.Compare(a, b)
+	}
+	for i := 1; i <= 3; i++ {
+		na, _ := strconv.Atoi(pa[i])
+		nb, _ := strconv.Atoi(pb[i])
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+/*
+*
+* 入口以外的 Lua 文件, 按模块名索引: lib/util.lua 是 require("lib.util")
+*
+ */
+func LuaModules(files map[string][]byte, main string) map[string]string {
+	modules := map[string]string{}
+	for name, content := range files {
+		if name == main || !strings.HasSuffix(name, ".lua") {
+			continue
+		}
+		module := strings.ReplaceAll(strings.TrimSuffix(name, ".lua"), "/", ".")
+		modules[module] = string(content)
+	}
+	return modules
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package appletbundle
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/hootrhino/rhilex/ossupport"
+)
+
+// 每个应用一个目录, 测试的时候可以换掉
+var __BundleDir = ossupport.AppletBundleDir
+
+/*
+*
+* 保存应用包里的文件, 先写临时目录再替换, 升级失败不会留下一半
+*
+ */
+func SaveFiles(uuid string, files map[string][]byte) error {
+	if err := StageFiles(uuid, files); err != nil {
+		return err
+	}
+	if err := SwapFiles(uuid); err != nil {
+		return err
+	}
+	DropBackup(uuid)
+	return nil
+}
+
+/*
+*
+* 升级分三步: StageFiles 写到临时目录, 不影响正在跑的应用; SwapFiles 把临时目录换上去,
+* 旧的目录留作备份; 成功以后 DropBackup, 失败用 RestoreFiles 换回旧的
+*
+ */
+func StageFiles(uuid string, files map[string][]byte) error {
+	tmp := filepath.Join(__BundleDir, uuid) + ".tmp"
+	os.RemoveAll(tmp)
+	if err := writeFiles(tmp, files); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return nil
+}
+
+func writeFiles(dir string, files map[string][]byte) error {
+	for name, content := range files {
+		name, err := cleanPath(name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, 0644); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// 丢掉没有换上去的临时目录
+func DiscardStaged(uuid string) {
+	os.RemoveAll(filepath.Join(__BundleDir, uuid) + ".tmp")
+}
+
+// 临时目录换上去, 旧的目录改名成 .old; 原来没有目录的话也就没有备份
+func SwapFiles(uuid string) error {
+	dir := filepath.Join(__BundleDir, uuid)
+	os.RemoveAll(dir + ".old")
+	if _, err := os.Stat(dir); err == nil {
+		if err := os.Rename(dir, dir+".old"); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(dir+".tmp", dir); err != nil {
+		os.Rename(dir+".old", dir)
+		return err
+	}
+	return nil
+}
+
+// 换回 SwapFiles 之前的文件
+func RestoreFiles(uuid string) error {
+	dir := filepath.Join(__BundleDir, uuid)
+	os.RemoveAll(dir)
+	if _, err := os.Stat(dir + ".old"); os.IsNotExist(err) {
+		return nil
+	}
+	return os.Rename(dir+".old", dir)
+}
+
+func DropBackup(uuid string) {
+	os.RemoveAll(filepath.Join(__BundleDir, uuid) + ".old")
+}
+
+// 读取应用包里的文件, 没有的话返回空
+func LoadFiles(uuid string) (map[string][]byte, error) {
+	dir := filepath.Join(__BundleDir, uuid)
+	files := map[string][]byte{}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return files, nil
+	}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	return files, err
+}
+
+func RemoveFiles(uuid string) error {
+	return os.RemoveAll(filepath.Join(__BundleDir, uuid))
+}
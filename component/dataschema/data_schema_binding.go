@@ -0,0 +1,572 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataschema
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/datacenter"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+/*
+*
+* 点位绑定: (设备, 点位 tag) -> (数据模型, 属性);
+* 设备每采集一次, 属性值和数据中心跟着更新; 写属性的时候反过来写到点位
+*
+ */
+type IoTBinding struct {
+	UUID        string `json:"uuid"`
+	SchemaId    string `json:"schemaId"`
+	Property    string `json:"property"`
+	DeviceUuid  string `json:"deviceUuid"`
+	Tag         string `json:"tag"`
+	Expr        string `json:"expr"`        // 点位值转属性值, value 是点位值, 空的时候原样
+	WriteExpr   string `json:"writeExpr"`   // 属性值转点位值, value 是属性值, 空的时候原样
+	CtrlCommand string `json:"ctrlCommand"` // 写点位的设备指令, 默认 WriteToSheetRegisterWithTag
+}
+
+// 属性的当前值
+type IoTPropertyValue struct {
+	Name       string `json:"name"`
+	Value      any    `json:"value"`
+	Ts         uint64 `json:"ts"`
+	DeviceUuid string `json:"deviceUuid"`
+	Tag        string `json:"tag"`
+}
+
+type compiledBinding struct {
+	IoTBinding
+	property    IoTProperty
+	read, write *vm.Program
+	pointUuid   string
+	lastFetch   uint64
+	lastResolve time.Time
+}
+
+type boundSchema struct {
+	published bool
+	bindings  []*compiledBinding
+	values    map[string]IoTPropertyValue
+}
+
+type schemaBinder struct {
+	locker  sync.Mutex
+	schemas map[string]*boundSchema
+	cancel  context.CancelFunc
+}
+
+var __DefaultBinder = &schemaBinder{schemas: map[string]*boundSchema{}}
+
+// 点位表的 tag -> 点位 UUID, 数据中心写一行; 测试的时候替换
+var (
+	resolvePointTags = func(deviceUuid string) (map[string]string, error) {
+		tags, err := service.DevicePointTags(deviceUuid)
+		if err != nil {
+			return nil, err
+		}
+		points := map[string]string{}
+		for _, t := range tags {
+			points[t.Tag] = t.UUID
+		}
+		return points, nil
+	}
+	saveDataCenterRow = datacenter.InsertSchemaRow
+)
+
+// 表达式里能用 value, tag, device; value 类型运行时才知道
+type bindingEnv struct {
+	Value  any    `expr:"value"`
+	Tag    string `expr:"tag"`
+	Device string `expr:"device"`
+}
+
+func CompileBindingExpr(code string) (*vm.Program, error) {
+	if strings.TrimSpace(code) == "" {
+		return nil, nil
+	}
+	return expr.Compile(code, expr.Env(bindingEnv{}))
+}
+
+func runBindingExpr(program *vm.Program, b IoTBinding, value any) (any, error) {
+	if program == nil {
+		return value, nil
+	}
+	return expr.Run(program, bindingEnv{Value: value, Tag: b.Tag, Device: b.DeviceUuid})
+}
+
+func compileBinding(b IoTBinding, property IoTProperty) (*compiledBinding, error) {
+	if err := property.HoldValidator(); err != nil {
+		return nil, fmt.Errorf("property '%s': %s", property.Name, err)
+	}
+	read, err := CompileBindingExpr(b.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("binding '%s' expr: %s", b.Property, err)
+	}
+	write, err := CompileBindingExpr(b.WriteExpr)
+	if err != nil {
+		return nil, fmt.Errorf("binding '%s' writeExpr: %s", b.Property, err)
+	}
+	if b.CtrlCommand == "" {
+		b.CtrlCommand = "WriteToSheetRegisterWithTag"
+	}
+	return &compiledBinding{IoTBinding: b, property: property, read: read, write: write}, nil
+}
+
+/*
+*
+* 整体替换一个模型的绑定; 属性已经有的值保留
+*
+ */
+func SetIoTBindings(schemaUuid string, published bool, properties []IoTProperty, bindings []IoTBinding) error {
+	byName := map[string]IoTProperty{}
+	for _, p := range properties {
+		byName[p.Name] = p
+	}
+	compiled := []*compiledBinding{}
+	for _, b := range bindings {
+		property, ok := byName[b.Property]
+		if !ok {
+			return fmt.Errorf("property not exists: %s", b.Property)
+		}
+		cb, err := compileBinding(b, property)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, cb)
+	}
+	__DefaultBinder.locker.Lock()
+	defer __DefaultBinder.locker.Unlock()
+	if len(compiled) == 0 {
+		delete(__DefaultBinder.schemas, schemaUuid)
+		return nil
+	}
+	values := map[string]IoTPropertyValue{}
+	if old, ok := __DefaultBinder.schemas[schemaUuid]; ok {
+		for _, cb := range compiled {
+			if v, ok := old.values[cb.Property]; ok {
+				values[cb.Property] = v
+			}
+		}
+	}
+	__DefaultBinder.schemas[schemaUuid] = &boundSchema{
+		published: published,
+		bindings:  compiled,
+		values:    values,
+	}
+	return nil
+}
+
+/*
+*
+* 从数据库重新加载全部绑定; 绑定, 属性, 发布状态变了都要调一下
+*
+ */
+func ReloadIoTBindings() error {
+	MIotBindings := []model.MIotBinding{}
+	if err := interdb.InterDb().Model(model.MIotBinding{}).Find(&MIotBindings).Error; err != nil {
+		return err
+	}
+	bySchema := map[string][]IoTBinding{}
+	for _, MIotBinding := range MIotBindings {
+		bySchema[MIotBinding.SchemaId] = append(bySchema[MIotBinding.SchemaId], IoTBinding{
+			UUID:        MIotBinding.UUID,
+			SchemaId:    MIotBinding.SchemaId,
+			Property:    MIotBinding.Property,
+			DeviceUuid:  MIotBinding.DeviceUuid,
+			Tag:         MIotBinding.Tag,
+			Expr:        MIotBinding.Expr,
+			WriteExpr:   MIotBinding.WriteExpr,
+			CtrlCommand: MIotBinding.CtrlCommand,
+		})
+	}
+	__DefaultBinder.locker.Lock()
+	for schemaUuid := range __DefaultBinder.schemas {
+		if _, ok := bySchema[schemaUuid]; !ok {
+			delete(__DefaultBinder.schemas, schemaUuid)
+		}
+	}
+	__DefaultBinder.locker.Unlock()
+	for schemaUuid, bindings := range bySchema {
+		MIotSchema := model.MIotSchema{}
+		if err := interdb.InterDb().Where("uuid=?", schemaUuid).First(&MIotSchema).Error; err != nil {
+			glogger.GLogger.Error("load binding schema failed:", schemaUuid, err)
+			continue
+		}
+		MIotProperties := []model.MIotProperty{}
+		interdb.InterDb().Model(model.MIotProperty{}).
+			Where("schema_id=?", schemaUuid).Find(&MIotProperties)
+		properties := []IoTProperty{}
+		for _, MIotProperty := range MIotProperties {
+			property := IoTProperty{
+				UUID: MIotProperty.UUID,
+				Name: MIotProperty.Name,
+				Type: IoTPropertyType(MIotProperty.Type),
+				Rw:   MIotProperty.Rw,
+				Unit: MIotProperty.Unit,
+			}
+			json.Unmarshal([]byte(MIotProperty.Rule), &property.Rule)
+			properties = append(properties, property)
+		}
+		published := MIotSchema.Published != nil && *MIotSchema.Published
+		if err := SetIoTBindings(schemaUuid, published, properties, bindings); err != nil {
+			glogger.GLogger.Error("load binding failed:", schemaUuid, err)
+		}
+	}
+	return nil
+}
+
+/*
+*
+* 启动同步: 每个周期看绑定点位的采集时间, 变了就是新采集的值
+*
+ */
+func StartIoTBinding(interval time.Duration) {
+	if err := ReloadIoTBindings(); err != nil {
+		glogger.GLogger.Error("load bindings failed:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	__DefaultBinder.locker.Lock()
+	if __DefaultBinder.cancel != nil {
+		__DefaultBinder.cancel()
+	}
+	__DefaultBinder.cancel = cancel
+	__DefaultBinder.locker.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			SyncIoTBindings()
+		}
+	}()
+}
+
+func StopIoTBinding() {
+	__DefaultBinder.locker.Lock()
+	defer __DefaultBinder.locker.Unlock()
+	if __DefaultBinder.cancel != nil {
+		__DefaultBinder.cancel()
+		__DefaultBinder.cancel = nil
+	}
+}
+
+/*
+*
+* 同步一次: 有新值的模型更新属性缓存, 已发布的模型往数据中心写一行当前值
+*
+ */
+func SyncIoTBindings() {
+	__DefaultBinder.locker.Lock()
+	defer __DefaultBinder.locker.Unlock()
+	for schemaUuid, schema := range __DefaultBinder.schemas {
+		changed := false
+		for _, b := range schema.bindings {
+			value, ts, ok := b.poll()
+			if !ok {
+				continue
+			}
+			schema.values[b.Property] = IoTPropertyValue{
+				Name: b.Property, Value: value, Ts: ts, DeviceUuid: b.DeviceUuid, Tag: b.Tag,
+			}
+			updatePropertyCache(b.property, value, ts)
+			changed = true
+		}
+		if !changed || !schema.published {
+			continue
+		}
+		row := map[string]any{"create_at": time.Now().Format(datacenter.TimeLayout)}
+		for name, v := range schema.values {
+			row[name] = dataCenterValue(v.Value)
+		}
+		if err := saveDataCenterRow(schemaUuid, row); err != nil {
+			glogger.GLogger.Error("save binding values failed:", schemaUuid, err)
+		}
+	}
+}
+
+// 取新采集的点位值并转成属性值; 没有新值或者值不合法返回 false
+func (b *compiledBinding) poll() (any, uint64, bool) {
+	if b.pointUuid == "" {
+		if time.Since(b.lastResolve) < 10*time.Second {
+			return nil, 0, false
+		}
+		b.lastResolve = time.Now()
+		points, err := resolvePointTags(b.DeviceUuid)
+		if err != nil {
+			glogger.GLogger.Error("resolve point failed:", b.DeviceUuid, err)
+			return nil, 0, false
+		}
+		b.pointUuid = points[b.Tag]
+		if b.pointUuid == "" {
+			return nil, 0, false
+		}
+	}
+	v := intercache.GetValue(b.DeviceUuid, b.pointUuid)
+	if v.UUID == "" {
+		// 点位表改过了, 下次重新找
+		b.pointUuid = ""
+		return nil, 0, false
+	}
+	if v.LastFetchTime == b.lastFetch {
+		return nil, 0, false
+	}
+	b.lastFetch = v.LastFetchTime
+	if v.Status != 1 {
+		return nil, 0, false
+	}
+	value, err := runBindingExpr(b.read, b.IoTBinding, utils.ParsePointValue(v.Value))
+	if err != nil {
+		glogger.GLogger.Error("binding expr failed:", b.Property, err)
+		return nil, 0, false
+	}
+	value, err = CastPropertyValue(b.property, value)
+	if err != nil {
+		glogger.GLogger.Error("binding value invalid:", b.Property, err)
+		return nil, 0, false
+	}
+	return value, v.LastFetchTime, true
+}
+
+// 全局属性缓存里的值一起更新, rds:Save 的校验用的就是它
+func updatePropertyCache(property IoTProperty, value any, ts uint64) {
+	cached, ok := GetDataSchemaCache(property.Name)
+	if !ok || cached.UUID != property.UUID {
+		return
+	}
+	cached.Value = value
+	intercache.SetValue("__DataSchema", property.Name, intercache.CacheValue{
+		UUID:          property.UUID,
+		Status:        1,
+		LastFetchTime: ts,
+		Value:         &cached,
+	})
+}
+
+// 结构体和数组在数据中心里是 JSON 文本
+func dataCenterValue(value any) any {
+	switch value.(type) {
+	case map[string]any, []any:
+		bytes, _ := json.Marshal(value)
+		return string(bytes)
+	}
+	return value
+}
+
+/*
+*
+* 按属性类型转换并校验
+*
+ */
+func CastPropertyValue(property IoTProperty, value any) (any, error) {
+	if property.Rule.validator == nil {
+		if err := property.HoldValidator(); err != nil {
+			return nil, err
+		}
+	}
+	var result any
+	switch property.Type {
+	case IoTPropertyTypeInteger, IoTPropertyTypeEnum, IoTPropertyTypeTimestamp:
+		f, err := toFloat64(value)
+		if err != nil {
+			if property.Type == IoTPropertyTypeTimestamp {
+				result = value // RFC3339 字符串
+				break
+			}
+			return nil, err
+		}
+		result = int64(f)
+	case IoTPropertyTypeFloat:
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, err
+		}
+		if property.Rule.Round > 0 {
+			p := math.Pow10(property.Rule.Round)
+			f = math.Round(f*p) / p
+		}
+		result = f
+	case IoTPropertyTypeBool:
+		switch T := value.(type) {
+		case bool:
+			result = T
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(T))
+			if err != nil {
+				return nil, fmt.Errorf("invalid bool: %v", value)
+			}
+			result = b
+		default:
+			f, err := toFloat64(value)
+			if err != nil {
+				return nil, err
+			}
+			result = f != 0
+		}
+	case IoTPropertyTypeString, IoTPropertyTypeGeo:
+		if s, ok := value.(string); ok {
+			result = s
+		} else {
+			result = fmt.Sprintf("%v", value)
+		}
+	case IoTPropertyTypeStruct, IoTPropertyTypeArray:
+		var bytes []byte
+		if s, ok := value.(string); ok {
+			bytes = []byte(s)
+		} else {
+			bytes, _ = json.Marshal(value)
+		}
+		if err := json.Unmarshal(bytes, &result); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", property.Type, value)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported type:%v", property.Type)
+	}
+	if err := property.Rule.validator.Validate(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func toFloat64(value any) (float64, error) {
+	switch T := value.(type) {
+	case bool:
+		if T {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(T), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number: %v", value)
+		}
+		return f, nil
+	}
+	if ok, f := isNumber(value); ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("invalid number: %v", value)
+}
+
+// 模型的属性当前值
+func GetIoTPropertyValues(schemaUuid string) []IoTPropertyValue {
+	__DefaultBinder.locker.Lock()
+	defer __DefaultBinder.locker.Unlock()
+	values := []IoTPropertyValue{}
+	if schema, ok := __DefaultBinder.schemas[schemaUuid]; ok {
+		for _, v := range schema.values {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func GetIoTPropertyValue(schemaUuid, name string) (IoTPropertyValue, bool) {
+	__DefaultBinder.locker.Lock()
+	defer __DefaultBinder.locker.Unlock()
+	if schema, ok := __DefaultBinder.schemas[schemaUuid]; ok {
+		v, ok := schema.values[name]
+		return v, ok
+	}
+	return IoTPropertyValue{}, false
+}
+
+func findBinding(schemaUuid, name string) (compiledBinding, bool) {
+	__DefaultBinder.locker.Lock()
+	defer __DefaultBinder.locker.Unlock()
+	if schema, ok := __DefaultBinder.schemas[schemaUuid]; ok {
+		for _, b := range schema.bindings {
+			if b.Property == name {
+				return *b, true
+			}
+		}
+	}
+	return compiledBinding{}, false
+}
+
+// 属性有没有绑定点位, 云端连接器据此决定走属性写还是直接写 tag
+func HasIoTBinding(schemaUuid, name string) bool {
+	_, ok := findBinding(schemaUuid, name)
+	return ok
+}
+
+/*
+*
+* 写属性: 按类型转换校验 -> writeExpr -> 设备 OnCtrl(ctrlCommand, {"tag","value"})
+*
+ */
+func WriteProperty(rx typex.Rhilex, schemaUuid, name string, value any) error {
+	b, ok := findBinding(schemaUuid, name)
+	if !ok {
+		return fmt.Errorf("property not bound: %s.%s", schemaUuid, name)
+	}
+	if b.property.Rw == "R" {
+		return fmt.Errorf("property is read only: %s", name)
+	}
+	v, err := CastPropertyValue(b.property, value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	pv, err := runBindingExpr(b.write, b.IoTBinding, v)
+	if err != nil {
+		return fmt.Errorf("%s writeExpr: %s", name, err)
+	}
+	Device := rx.GetDevice(b.DeviceUuid)
+	if Device == nil || Device.Device == nil {
+		return fmt.Errorf("device not exists: %s", b.DeviceUuid)
+	}
+	if Device.Device.Status() != typex.SOURCE_UP {
+		return fmt.Errorf("device is not running: %s", b.DeviceUuid)
+	}
+	var s string
+	switch T := dataCenterValue(pv).(type) {
+	case bool:
+		s = "0"
+		if T {
+			s = "1"
+		}
+	case string:
+		s = T
+	case float64:
+		s = strconv.FormatFloat(T, 'f', -1, 64)
+	default:
+		s = fmt.Sprintf("%v", T)
+	}
+	args, _ := json.Marshal(map[string]string{"tag": b.Tag, "value": s})
+	if _, err := Device.Device.OnCtrl([]byte(b.CtrlCommand), args); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
@@ -70,8 +70,125 @@ func InitDataSchemaCache(e typex.Rhilex) {
 			})
 
 		}
+		if err := LoadIoTFunctionCache(MIotSchema.UUID); err != nil {
+			glogger.GLogger.Error(err)
+		}
+	}
+}
+
+/*
+*
+* 事件和服务按模型分槽缓存: __DataSchema_{schemaId}, key 是 event.{name} 和 service.{name}
+*
+ */
+func functionSlot(schemaUUID string) string {
+	return "__DataSchema_" + schemaUUID
+}
 
+// 从数据库重新加载一个模型的事件和服务
+func LoadIoTFunctionCache(schemaUUID string) error {
+	MIotEvents := []model.MIotEvent{}
+	if err := interdb.InterDb().Model(model.MIotEvent{}).
+		Where("schema_id=?", schemaUUID).Find(&MIotEvents).Error; err != nil {
+		return err
+	}
+	MIotServices := []model.MIotService{}
+	if err := interdb.InterDb().Model(model.MIotService{}).
+		Where("schema_id=?", schemaUUID).Find(&MIotServices).Error; err != nil {
+		return err
+	}
+	Events := []IoTEvent{}
+	for _, MIotEvent := range MIotEvents {
+		Event := IoTEvent{
+			UUID:        MIotEvent.UUID,
+			Name:        MIotEvent.Name,
+			Label:       MIotEvent.Label,
+			Severity:    MIotEvent.Severity,
+			Description: MIotEvent.Description,
+		}
+		if err := json.Unmarshal([]byte(MIotEvent.Outputs), &Event.Outputs); err != nil {
+			glogger.GLogger.Error(MIotEvent.Name, err)
+			continue
+		}
+		Events = append(Events, Event)
+	}
+	Services := []IoTService{}
+	for _, MIotService := range MIotServices {
+		Service := IoTService{
+			UUID:        MIotService.UUID,
+			Name:        MIotService.Name,
+			Label:       MIotService.Label,
+			Command:     MIotService.Command,
+			Description: MIotService.Description,
+		}
+		if err := json.Unmarshal([]byte(MIotService.Inputs), &Service.Inputs); err != nil {
+			glogger.GLogger.Error(MIotService.Name, err)
+			continue
+		}
+		if err := json.Unmarshal([]byte(MIotService.Outputs), &Service.Outputs); err != nil {
+			glogger.GLogger.Error(MIotService.Name, err)
+			continue
+		}
+		Services = append(Services, Service)
+	}
+	SetIoTFunctionCache(schemaUUID, Events, Services)
+	return nil
+}
+
+// 整体替换一个模型的事件和服务, 规则不合法的跳过
+func SetIoTFunctionCache(schemaUUID string, Events []IoTEvent, Services []IoTService) {
+	Slot := functionSlot(schemaUUID)
+	intercache.RegisterSlot(Slot)
+	for i := range Events {
+		if err := Events[i].HoldValidator(); err != nil {
+			glogger.GLogger.Error(Events[i].Name, err)
+			continue
+		}
+		intercache.SetValue(Slot, "event."+Events[i].Name, intercache.CacheValue{
+			UUID:          Events[i].UUID,
+			LastFetchTime: uint64(time.Now().UnixMilli()),
+			Value:         Events[i],
+		})
+	}
+	for i := range Services {
+		if err := Services[i].HoldValidator(); err != nil {
+			glogger.GLogger.Error(Services[i].Name, err)
+			continue
+		}
+		intercache.SetValue(Slot, "service."+Services[i].Name, intercache.CacheValue{
+			UUID:          Services[i].UUID,
+			LastFetchTime: uint64(time.Now().UnixMilli()),
+			Value:         Services[i],
+		})
+	}
+}
+
+// 删除模型的时候一起清掉
+func FlushIoTFunctionCache(schemaUUID string) {
+	intercache.UnRegisterSlot(functionSlot(schemaUUID))
+}
+
+func GetIoTEventCache(schemaUUID, name string) (IoTEvent, bool) {
+	V := intercache.GetValue(functionSlot(schemaUUID), "event."+name)
+	Event, ok := V.Value.(IoTEvent)
+	return Event, ok
+}
+
+func GetIoTServiceCache(schemaUUID, name string) (IoTService, bool) {
+	V := intercache.GetValue(functionSlot(schemaUUID), "service."+name)
+	Service, ok := V.Value.(IoTService)
+	return Service, ok
+}
+
+// 一个模型的全部服务, 给云端连接器生成能力列表用
+func ListIoTServiceCache(schemaUUID string) []IoTService {
+	Services := []IoTService{}
+	for _, V := range intercache.GetSlot(functionSlot(schemaUUID)) {
+		if Service, ok := V.Value.(IoTService); ok {
+			Services = append(Services, Service)
+		}
 	}
+	return Services
 }
 
 /*
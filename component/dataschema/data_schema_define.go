@@ -22,20 +22,24 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hootrhino/rhilex/utils"
 )
 
 type IoTPropertyType string
 
-// "INTEGER", "BOOL", "FLOAT", "STRING", "GEO"
+// "INTEGER", "BOOL", "FLOAT", "STRING", "GEO", "ENUM", "TIMESTAMP", "STRUCT", "ARRAY"
 const (
-	// 目前边缘侧暂时只支持常见类型
-	IoTPropertyTypeString  IoTPropertyType = "STRING"
-	IoTPropertyTypeInteger IoTPropertyType = "INTEGER"
-	IoTPropertyTypeFloat   IoTPropertyType = "FLOAT"
-	IoTPropertyTypeBool    IoTPropertyType = "BOOL"
-	IoTPropertyTypeGeo     IoTPropertyType = "GEO"
+	IoTPropertyTypeString    IoTPropertyType = "STRING"
+	IoTPropertyTypeInteger   IoTPropertyType = "INTEGER"
+	IoTPropertyTypeFloat     IoTPropertyType = "FLOAT"
+	IoTPropertyTypeBool      IoTPropertyType = "BOOL"
+	IoTPropertyTypeGeo       IoTPropertyType = "GEO"
+	IoTPropertyTypeEnum      IoTPropertyType = "ENUM"      // 整数枚举, 取值见 Rule.Enums
+	IoTPropertyTypeTimestamp IoTPropertyType = "TIMESTAMP" // Unix 毫秒时间戳
+	IoTPropertyTypeStruct    IoTPropertyType = "STRUCT"    // 结构体, 字段见 Rule.Fields
+	IoTPropertyTypeArray     IoTPropertyType = "ARRAY"     // 数组, 元素类型见 Rule.ElementType
 )
 
 // string
@@ -54,22 +58,121 @@ type IoTPropertyBool bool
 type IoTPropertyGeo string
 
 /*
-* 物模型,边缘端目前暂时只支持属性
+* 物模型: 属性, 事件, 服务
 *
  */
 type IoTSchema struct {
 	IoTProperties map[string]IoTProperty `json:"iotProperties"`
+	IoTEvents     map[string]IoTEvent    `json:"iotEvents"`
+	IoTServices   map[string]IoTService  `json:"iotServices"`
 }
 
 // 规则
 type IoTPropertyRule struct {
-	DefaultValue any       `json:"defaultValue"` // 默认值: 0 false ''
-	Max          int       `json:"max"`          // int|float|string: 最大值
-	Min          int       `json:"min"`          // int|float|string: 最小值
-	TrueLabel    string    `json:"trueLabel"`    // bool: 真值label
-	FalseLabel   string    `json:"falseLabel"`   // bool: 假值label
-	Round        int       `json:"round"`        // float: 小数点位
-	validator    Validator `json:"-"`
+	DefaultValue any             `json:"defaultValue"`          // 默认值: 0 false ''
+	Max          int             `json:"max"`                   // int|float|string: 最大值
+	Min          int             `json:"min"`                   // int|float|string: 最小值
+	TrueLabel    string          `json:"trueLabel"`             // bool: 真值label
+	FalseLabel   string          `json:"falseLabel"`            // bool: 假值label
+	Round        int             `json:"round"`                 // float: 小数点位
+	Enums        []IoTEnumItem   `json:"enums,omitempty"`       // enum: 可选值
+	Fields       []IoTParam      `json:"fields,omitempty"`      // struct: 字段; 元素是 struct 的 array 也用它
+	ElementType  IoTPropertyType `json:"elementType,omitempty"` // array: 元素类型, 不能再是 array
+	Size         int             `json:"size,omitempty"`        // array: 最大元素个数, 0 不限制
+	validator    Validator       `json:"-"`
+}
+
+// 枚举项
+type IoTEnumItem struct {
+	Value int    `json:"value"`
+	Label string `json:"label"`
+}
+
+/*
+*
+* 参数: 结构体字段, 事件输出参数, 服务输入输出参数都用它
+*
+ */
+type IoTParam struct {
+	Name        string          `json:"name"`
+	Label       string          `json:"label"`
+	Type        IoTPropertyType `json:"type"`
+	Unit        string          `json:"unit"`
+	Description string          `json:"description"`
+	Rule        IoTPropertyRule `json:"rule"`
+}
+
+// 参数规则和属性规则一样
+func (P *IoTParam) HoldValidator() error {
+	if P.Name == "" {
+		return fmt.Errorf("param name can not be empty")
+	}
+	property := IoTProperty{Name: P.Name, Type: P.Type, Rule: P.Rule}
+	if err := property.HoldValidator(); err != nil {
+		return fmt.Errorf("param '%s': %s", P.Name, err)
+	}
+	P.Rule = property.Rule
+	return nil
+}
+
+func (P IoTParam) Validate(Value any) error {
+	if P.Rule.validator == nil {
+		if err := P.HoldValidator(); err != nil {
+			return err
+		}
+	}
+	if err := P.Rule.validator.Validate(Value); err != nil {
+		return fmt.Errorf("param '%s': %s", P.Name, err)
+	}
+	return nil
+}
+
+/*
+*
+* 按参数表检查一组值: 不认识的参数报错, 缺的参数有默认值就补上, 没有默认值报错
+*
+ */
+func ValidateParams(Params []IoTParam, Values map[string]any, FillDefault bool) (map[string]any, error) {
+	Result := map[string]any{}
+	Declared := map[string]bool{}
+	for _, P := range Params {
+		Declared[P.Name] = true
+		V, ok := Values[P.Name]
+		if !ok {
+			if !FillDefault {
+				continue
+			}
+			if P.Rule.DefaultValue == nil {
+				return nil, fmt.Errorf("missing param '%s'", P.Name)
+			}
+			V = P.Rule.DefaultValue
+		}
+		if err := P.Validate(V); err != nil {
+			return nil, err
+		}
+		Result[P.Name] = V
+	}
+	for K := range Values {
+		if !Declared[K] {
+			return nil, fmt.Errorf("undeclared param '%s'", K)
+		}
+	}
+	return Result, nil
+}
+
+// 参数名不能重复, 每个参数的规则都要合法
+func holdParams(Params []IoTParam) error {
+	Names := map[string]bool{}
+	for i := range Params {
+		if err := Params[i].HoldValidator(); err != nil {
+			return err
+		}
+		if Names[Params[i].Name] {
+			return fmt.Errorf("duplicate param '%s'", Params[i].Name)
+		}
+		Names[Params[i].Name] = true
+	}
+	return nil
 }
 
 // 物模型属性
@@ -99,6 +202,10 @@ func (I *IoTProperty) CovertAndValidate() error {
 		I.StringValue()
 	case "GEO":
 		I.GeoValue()
+	case "ENUM", "TIMESTAMP", "STRUCT", "ARRAY":
+		if err := I.HoldValidator(); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("Unsupported type:%v", I.Type)
 	}
@@ -261,6 +368,47 @@ func (I *IoTProperty) HoldValidator() error {
 		Rule := GeoRule{DefaultValue: "0,0"}
 		I.Rule.validator = Rule
 		return nil
+
+	case "ENUM":
+		if len(I.Rule.Enums) == 0 {
+			return fmt.Errorf("Enum must contain at least one item")
+		}
+		Rule := EnumRule{Enums: I.Rule.Enums, DefaultValue: I.Rule.Enums[0].Value}
+		if ok, T := isNumber(I.Rule.DefaultValue); ok {
+			Rule.DefaultValue = int(T)
+		}
+		I.Rule.validator = Rule
+		return nil
+
+	case "TIMESTAMP":
+		I.Rule.validator = TimestampRule{}
+		return nil
+
+	case "STRUCT":
+		if len(I.Rule.Fields) == 0 {
+			return fmt.Errorf("Struct must contain at least one field")
+		}
+		if err := holdParams(I.Rule.Fields); err != nil {
+			return err
+		}
+		I.Rule.validator = StructRule{Fields: I.Rule.Fields}
+		return nil
+
+	case "ARRAY":
+		if I.Rule.ElementType == "" || I.Rule.ElementType == IoTPropertyTypeArray {
+			return fmt.Errorf("Invalid array element type:%v", I.Rule.ElementType)
+		}
+		if I.Rule.Size < 0 {
+			return fmt.Errorf("Invalid array size:%v", I.Rule.Size)
+		}
+		// 元素沿用数组本身的规则, 只是换了类型
+		Element := IoTParam{Name: "element", Type: I.Rule.ElementType, Rule: I.Rule}
+		Element.Rule.ElementType, Element.Rule.Size, Element.Rule.DefaultValue = "", 0, nil
+		if err := Element.HoldValidator(); err != nil {
+			return err
+		}
+		I.Rule.validator = ArrayRule{Size: I.Rule.Size, Element: Element}
+		return nil
 	}
 	return fmt.Errorf("Unsupported Validator type:%v", I.Type)
 }
@@ -471,10 +619,100 @@ func isValidGEO(coord string) bool {
 
 /*
 *
-  - Check Type:"INTEGER", "BOOL", "FLOAT", "STRING", "GEO"
+* 枚举规则: 只能是声明过的值
+*
+ */
+type EnumRule struct {
+	DefaultValue int           `json:"defaultValue"`
+	Enums        []IoTEnumItem `json:"enums"`
+}
+
+func (V EnumRule) String() string {
+	bytes, _ := json.Marshal(V)
+	return string(bytes)
+}
+
+func (V EnumRule) Validate(Value any) error {
+	if ok, T := isNumber(Value); ok && T == float64(int(T)) {
+		for _, E := range V.Enums {
+			if E.Value == int(T) {
+				return nil
+			}
+		}
+		return fmt.Errorf("Value (%v) not in enums:%s", Value, V.String())
+	}
+	return fmt.Errorf("Invalid Enum type:%v", Value)
+}
+
+/*
+*
+* 时间戳规则: Unix 毫秒, 也接受 RFC3339 字符串
+*
+ */
+type TimestampRule struct{}
+
+func (V TimestampRule) Validate(Value any) error {
+	if S, ok := Value.(string); ok {
+		if _, err := time.Parse(time.RFC3339, S); err == nil {
+			return nil
+		}
+	}
+	if ok, T := isNumber(Value); ok && T >= 0 && T == float64(int64(T)) {
+		return nil
+	}
+	return fmt.Errorf("Invalid Timestamp type:%v", Value)
+}
+
+/*
+*
+* 结构体规则: 不认识的字段报错, 缺的字段不管
+*
+ */
+type StructRule struct {
+	Fields []IoTParam `json:"fields"`
+}
+
+func (V StructRule) Validate(Value any) error {
+	Values, ok := Value.(map[string]any)
+	if !ok {
+		return fmt.Errorf("Invalid Struct type:%v", Value)
+	}
+	_, err := ValidateParams(V.Fields, Values, false)
+	return err
+}
+
+/*
+*
+* 数组规则: 每个元素按元素类型检查
+*
+ */
+type ArrayRule struct {
+	Size    int      `json:"size"`
+	Element IoTParam `json:"element"`
+}
+
+func (V ArrayRule) Validate(Value any) error {
+	Values, ok := Value.([]any)
+	if !ok {
+		return fmt.Errorf("Invalid Array type:%v", Value)
+	}
+	if V.Size > 0 && len(Values) > V.Size {
+		return fmt.Errorf("Array length %d exceed size:%d", len(Values), V.Size)
+	}
+	for i, E := range Values {
+		if err := V.Element.Rule.validator.Validate(E); err != nil {
+			return fmt.Errorf("element %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+/*
+*
+  - Check Type:"INTEGER", "BOOL", "FLOAT", "STRING", "GEO", "ENUM", "TIMESTAMP", "STRUCT", "ARRAY"
 */
 func CheckPropertyType(s string) error {
-	Types := []string{"INTEGER", "BOOL", "FLOAT", "STRING", "GEO"}
+	Types := []string{"INTEGER", "BOOL", "FLOAT", "STRING", "GEO", "ENUM", "TIMESTAMP", "STRUCT", "ARRAY"}
 	if !slices.Contains(Types, s) {
 		return fmt.Errorf("Invalid Property Type, Must one of:%v", Types)
 	}
@@ -0,0 +1,109 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataschema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/eventbus"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+// 事件级别
+const (
+	IoTEventSeverityInfo    = "INFO"
+	IoTEventSeverityWarning = "WARNING"
+	IoTEventSeverityAlert   = "ALERT"
+	IoTEventSeverityFault   = "FAULT"
+)
+
+/*
+*
+* 物模型事件: 设备主动上报的一次性消息, 带级别和输出参数
+*
+ */
+type IoTEvent struct {
+	UUID        string     `json:"uuid"`
+	Name        string     `json:"name"`
+	Label       string     `json:"label"`
+	Severity    string     `json:"severity"` // INFO|WARNING|ALERT|FAULT
+	Outputs     []IoTParam `json:"outputs"`
+	Description string     `json:"description"`
+}
+
+// 验证事件定义
+func (E *IoTEvent) HoldValidator() error {
+	if E.Name == "" {
+		return fmt.Errorf("event name can not be empty")
+	}
+	if err := ValidateSeverity(E.Severity); err != nil {
+		return err
+	}
+	return holdParams(E.Outputs)
+}
+
+func ValidateSeverity(s string) error {
+	if !utils.SContains([]string{IoTEventSeverityInfo, IoTEventSeverityWarning,
+		IoTEventSeverityAlert, IoTEventSeverityFault}, s) {
+		return fmt.Errorf("Severity Only Support 'INFO' or 'WARNING' or 'ALERT' or 'FAULT'")
+	}
+	return nil
+}
+
+// 事件总线上的事件内容
+type IoTEventMessage struct {
+	SchemaId   string         `json:"schemaId"`
+	DeviceUuid string         `json:"deviceUuid"`
+	Event      string         `json:"event"`
+	Severity   string         `json:"severity"`
+	Params     map[string]any `json:"params"`
+	Ts         uint64         `json:"ts"`
+}
+
+/*
+*
+* 触发事件: 按定义检查输出参数后发到事件总线 schema.event.{schemaId},
+* 内部事件源能收到, 规则里可以再转发到云端
+*
+ */
+func EmitEvent(schemaUuid, deviceUuid, name string, params map[string]any) (IoTEventMessage, error) {
+	Event, ok := GetIoTEventCache(schemaUuid, name)
+	if !ok {
+		return IoTEventMessage{}, fmt.Errorf("event not exists: %s.%s", schemaUuid, name)
+	}
+	Params, err := ValidateParams(Event.Outputs, params, false)
+	if err != nil {
+		return IoTEventMessage{}, err
+	}
+	Message := IoTEventMessage{
+		SchemaId:   schemaUuid,
+		DeviceUuid: deviceUuid,
+		Event:      name,
+		Severity:   Event.Severity,
+		Params:     Params,
+		Ts:         uint64(time.Now().UnixMilli()),
+	}
+	eventbus.Publish("schema.event."+schemaUuid, eventbus.EventMessage{
+		Topic:   "schema.event." + schemaUuid,
+		From:    deviceUuid,
+		Type:    "DEVICE",
+		Event:   "schema.event." + name,
+		Ts:      Message.Ts,
+		Payload: Message,
+	})
+	return Message, nil
+}
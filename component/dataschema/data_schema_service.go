@@ -0,0 +1,105 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hootrhino/rhilex/typex"
+)
+
+var ErrIoTServiceNotExists = errors.New("service not exists")
+
+/*
+*
+* 物模型服务: 可以被调用的设备能力, 调用的时候转成设备的 OnCtrl(Command, 输入参数JSON)
+*
+ */
+type IoTService struct {
+	UUID        string     `json:"uuid"`
+	Name        string     `json:"name"`
+	Label       string     `json:"label"`
+	Command     string     `json:"command"` // 设备指令, 空的时候就是服务名
+	Inputs      []IoTParam `json:"inputs"`
+	Outputs     []IoTParam `json:"outputs"`
+	Description string     `json:"description"`
+}
+
+// 验证服务定义
+func (S *IoTService) HoldValidator() error {
+	if S.Name == "" {
+		return fmt.Errorf("service name can not be empty")
+	}
+	if err := holdParams(S.Inputs); err != nil {
+		return fmt.Errorf("inputs: %s", err)
+	}
+	if err := holdParams(S.Outputs); err != nil {
+		return fmt.Errorf("outputs: %s", err)
+	}
+	return nil
+}
+
+func (S IoTService) DeviceCommand() string {
+	if S.Command != "" {
+		return S.Command
+	}
+	return S.Name
+}
+
+/*
+*
+* 调用服务: 检查输入参数 -> 设备 OnCtrl -> 设备返回 JSON 对象就按输出参数检查;
+* 没有声明输出参数的服务, 设备返回什么就给什么
+*
+ */
+func InvokeService(rx typex.Rhilex, schemaUuid, deviceUuid, name string,
+	params map[string]any) (map[string]any, error) {
+	Service, ok := GetIoTServiceCache(schemaUuid, name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s.%s", ErrIoTServiceNotExists, schemaUuid, name)
+	}
+	if params == nil {
+		params = map[string]any{}
+	}
+	Inputs, err := ValidateParams(Service.Inputs, params, true)
+	if err != nil {
+		return nil, err
+	}
+	Device := rx.GetDevice(deviceUuid)
+	if Device == nil || Device.Device == nil {
+		return nil, fmt.Errorf("device not exists: %s", deviceUuid)
+	}
+	if Device.Device.Status() != typex.SOURCE_UP {
+		return nil, fmt.Errorf("device is not running: %s", deviceUuid)
+	}
+	args, _ := json.Marshal(Inputs)
+	result, err := Device.Device.OnCtrl([]byte(Service.DeviceCommand()), args)
+	if err != nil {
+		return nil, err
+	}
+	Outputs := map[string]any{}
+	if len(result) > 0 {
+		if err := json.Unmarshal(result, &Outputs); err != nil {
+			Outputs = map[string]any{"result": string(result)}
+		}
+	}
+	if len(Service.Outputs) == 0 {
+		return Outputs, nil
+	}
+	return ValidateParams(Service.Outputs, Outputs, false)
+}
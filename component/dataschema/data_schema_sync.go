@@ -0,0 +1,183 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/utils"
+	"gorm.io/gorm"
+)
+
+/*
+*
+* 把云端同步下来的物模型写进本地: 没有就新建, 有就整体替换属性;
+* 已经发布的物模型数据中心建了表, 属性变了不能直接替换, 要先重置
+*
+ */
+func SyncIoTSchema(schemaUuid, name, description string, properties []IoTProperty) error {
+	for i := range properties {
+		if err := properties[i].HoldValidator(); err != nil {
+			return fmt.Errorf("property '%s': %s", properties[i].Name, err)
+		}
+	}
+	return interdb.InterDb().Transaction(func(tx *gorm.DB) error {
+		MIotSchema := model.MIotSchema{}
+		err := tx.Where("uuid=?", schemaUuid).First(&MIotSchema).Error
+		if err == gorm.ErrRecordNotFound {
+			MIotSchema = model.MIotSchema{
+				UUID:        schemaUuid,
+				Published:   new(bool),
+				Name:        name,
+				Description: description,
+			}
+			if err := tx.Create(&MIotSchema).Error; err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		MIotProperties := []model.MIotProperty{}
+		if err := tx.Where("schema_id=?", schemaUuid).Find(&MIotProperties).Error; err != nil {
+			return err
+		}
+		if sameProperties(MIotProperties, properties) {
+			return nil
+		}
+		if MIotSchema.Published != nil && *MIotSchema.Published {
+			return fmt.Errorf("schema '%s' is published, reset it before sync", schemaUuid)
+		}
+		if err := tx.Where("schema_id=?", schemaUuid).Delete(&model.MIotProperty{}).Error; err != nil {
+			return err
+		}
+		for _, p := range properties {
+			rule, _ := json.Marshal(p.Rule)
+			MIotProperty := model.MIotProperty{
+				SchemaId:    schemaUuid,
+				UUID:        utils.MakeUUID("PROPER"),
+				Label:       p.Label,
+				Name:        p.Name,
+				Type:        string(p.Type),
+				Rw:          p.Rw,
+				Unit:        p.Unit,
+				Rule:        string(rule),
+				Description: p.Description,
+			}
+			if err := tx.Create(&MIotProperty).Error; err != nil {
+				return err
+			}
+			p.UUID = MIotProperty.UUID
+			intercache.SetValue("__DataSchema", p.Name, intercache.CacheValue{
+				UUID:          p.UUID,
+				LastFetchTime: uint64(time.Now().UnixMilli()),
+				Value:         &p,
+			})
+		}
+		return nil
+	})
+}
+
+// 只比较名称, 类型, 读写和单位; 规则变了不影响数据中心的表
+func sameProperties(current []model.MIotProperty, properties []IoTProperty) bool {
+	if len(current) != len(properties) {
+		return false
+	}
+	byName := map[string]model.MIotProperty{}
+	for _, p := range current {
+		byName[p.Name] = p
+	}
+	for _, p := range properties {
+		c, ok := byName[p.Name]
+		if !ok || c.Type != string(p.Type) || c.Rw != p.Rw || c.Unit != p.Unit {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+*
+* 同步云端的事件和服务: 整体替换; 不影响数据中心的表, 已经发布的也能同步
+*
+ */
+func SyncIoTFunctions(schemaUuid string, events []IoTEvent, services []IoTService) error {
+	for i := range events {
+		if err := events[i].HoldValidator(); err != nil {
+			return fmt.Errorf("event '%s': %s", events[i].Name, err)
+		}
+	}
+	for i := range services {
+		if err := services[i].HoldValidator(); err != nil {
+			return fmt.Errorf("service '%s': %s", services[i].Name, err)
+		}
+	}
+	err := interdb.InterDb().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("schema_id=?", schemaUuid).Delete(&model.MIotEvent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("schema_id=?", schemaUuid).Delete(&model.MIotService{}).Error; err != nil {
+			return err
+		}
+		for _, e := range events {
+			outputs, _ := json.Marshal(paramsOrEmpty(e.Outputs))
+			if err := tx.Create(&model.MIotEvent{
+				SchemaId:    schemaUuid,
+				UUID:        utils.MakeUUID("EVENT"),
+				Label:       e.Label,
+				Name:        e.Name,
+				Severity:    e.Severity,
+				Outputs:     string(outputs),
+				Description: e.Description,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		for _, s := range services {
+			inputs, _ := json.Marshal(paramsOrEmpty(s.Inputs))
+			outputs, _ := json.Marshal(paramsOrEmpty(s.Outputs))
+			if err := tx.Create(&model.MIotService{
+				SchemaId:    schemaUuid,
+				UUID:        utils.MakeUUID("SERVICE"),
+				Label:       s.Label,
+				Name:        s.Name,
+				Command:     s.Command,
+				Inputs:      string(inputs),
+				Outputs:     string(outputs),
+				Description: s.Description,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return LoadIoTFunctionCache(schemaUuid)
+}
+
+// nil 序列化出来是 null, 数据库里统一存 []
+func paramsOrEmpty(params []IoTParam) []IoTParam {
+	if params == nil {
+		return []IoTParam{}
+	}
+	return params
+}
@@ -0,0 +1,351 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/eventbus"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// go test -timeout 30s -run ^Test_IoTProperty_Complex_Types github.com/hootrhino/rhilex/component/dataschema -v -count=1
+func Test_IoTProperty_Complex_Types(t *testing.T) {
+	enum := IoTProperty{Name: "mode", Type: IoTPropertyTypeEnum,
+		Rule: IoTPropertyRule{Enums: []IoTEnumItem{{0, "自动"}, {1, "手动"}}}}
+	if err := enum.HoldValidator(); err != nil {
+		t.Fatal(err)
+	}
+	if enum.Rule.validator.Validate(1) != nil || enum.Rule.validator.Validate(2) == nil ||
+		enum.Rule.validator.Validate(1.5) == nil {
+		t.Fatal("unexpected enum validate")
+	}
+	if (&IoTProperty{Name: "e", Type: IoTPropertyTypeEnum}).HoldValidator() == nil {
+		t.Fatal("enum without items should fail")
+	}
+	ts := IoTProperty{Name: "ts", Type: IoTPropertyTypeTimestamp}
+	ts.HoldValidator()
+	if ts.Rule.validator.Validate(float64(time.Now().UnixMilli())) != nil ||
+		ts.Rule.validator.Validate("2025-01-02T03:04:05Z") != nil || ts.Rule.validator.Validate(-1) == nil {
+		t.Fatal("unexpected timestamp validate")
+	}
+	// 结构体: 按字段类型检查, 不认识的字段报错
+	pos := IoTProperty{Name: "pos", Type: IoTPropertyTypeStruct, Rule: IoTPropertyRule{Fields: []IoTParam{
+		{Name: "lat", Type: IoTPropertyTypeFloat, Rule: IoTPropertyRule{Min: -90, Max: 90}},
+		{Name: "lng", Type: IoTPropertyTypeFloat, Rule: IoTPropertyRule{Min: -180, Max: 180}},
+	}}}
+	if err := pos.HoldValidator(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pos.Rule.validator.Validate(map[string]any{"lat": 30.5, "lng": 114.3}); err != nil {
+		t.Fatal(err)
+	}
+	if pos.Rule.validator.Validate(map[string]any{"lat": 100}) == nil ||
+		pos.Rule.validator.Validate(map[string]any{"alt": 1}) == nil {
+		t.Fatal("invalid struct should fail")
+	}
+	// 数组: 元素沿用数组的规则
+	arr := IoTProperty{Name: "arr", Type: IoTPropertyTypeArray,
+		Rule: IoTPropertyRule{ElementType: IoTPropertyTypeInteger, Min: 0, Max: 10, Size: 3}}
+	if err := arr.HoldValidator(); err != nil {
+		t.Fatal(err)
+	}
+	if err := arr.Rule.validator.Validate([]any{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if arr.Rule.validator.Validate([]any{1, 2, 3, 4}) == nil || arr.Rule.validator.Validate([]any{11}) == nil {
+		t.Fatal("invalid array should fail")
+	}
+	if (&IoTProperty{Name: "a", Type: IoTPropertyTypeArray,
+		Rule: IoTPropertyRule{ElementType: IoTPropertyTypeArray}}).HoldValidator() == nil {
+		t.Fatal("array of array should fail")
+	}
+	// 规则序列化以后再读出来还能用
+	bytes, _ := json.Marshal(pos.Rule)
+	decoded := IoTProperty{Name: "pos", Type: IoTPropertyTypeStruct}
+	json.Unmarshal(bytes, &decoded.Rule)
+	if err := decoded.HoldValidator(); err != nil {
+		t.Fatal(err)
+	}
+	if CheckPropertyType("ARRAY") != nil || CheckPropertyType("MAP") == nil {
+		t.Fatal("unexpected property type check")
+	}
+}
+
+type fakeDevice struct {
+	typex.XDevice
+	cmd, args string
+}
+
+func (d *fakeDevice) Status() typex.SourceState {
+	return typex.SOURCE_UP
+}
+
+func (d *fakeDevice) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	d.cmd, d.args = string(cmd), string(args)
+	return []byte(`{"ok":true}`), nil
+}
+
+type fakeRhilex struct {
+	typex.Rhilex
+	device *typex.Device
+}
+
+func (e *fakeRhilex) GetDevice(uuid string) *typex.Device {
+	if e.device.UUID == uuid {
+		return e.device
+	}
+	return nil
+}
+
+// go test -timeout 30s -run ^Test_IoTService_Invoke github.com/hootrhino/rhilex/component/dataschema -v -count=1
+func Test_IoTService_Invoke(t *testing.T) {
+	intercache.InitGlobalValueRegistry(nil)
+	device := &fakeDevice{}
+	rx := &fakeRhilex{device: &typex.Device{UUID: "DEVICE1", Device: device}}
+	SetIoTFunctionCache("SCHEMA1", nil, []IoTService{{
+		Name:    "reboot",
+		Command: "Reboot",
+		Inputs: []IoTParam{
+			{Name: "delay", Type: IoTPropertyTypeInteger, Rule: IoTPropertyRule{Min: 0, Max: 60, DefaultValue: 5}},
+			{Name: "mode", Type: IoTPropertyTypeEnum, Rule: IoTPropertyRule{Enums: []IoTEnumItem{{0, "soft"}, {1, "hard"}}}},
+		},
+		Outputs: []IoTParam{{Name: "ok", Type: IoTPropertyTypeBool}},
+	}})
+	result, err := InvokeService(rx, "SCHEMA1", "DEVICE1", "reboot", map[string]any{"mode": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.cmd != "Reboot" || !strings.Contains(device.args, `"delay":5`) || result["ok"] != true {
+		t.Fatal("unexpected invoke", device.cmd, device.args, result)
+	}
+	if _, err := InvokeService(rx, "SCHEMA1", "DEVICE1", "reboot", map[string]any{"mode": 3}); err == nil {
+		t.Fatal("invalid input should fail")
+	}
+	if _, err := InvokeService(rx, "SCHEMA1", "DEVICE1", "reboot", map[string]any{"mode": 0, "x": 1}); err == nil {
+		t.Fatal("undeclared input should fail")
+	}
+	if _, err := InvokeService(rx, "SCHEMA1", "DEVICE1", "nope", nil); err == nil ||
+		!strings.Contains(err.Error(), ErrIoTServiceNotExists.Error()) {
+		t.Fatal("unexpected error", err)
+	}
+	if _, err := InvokeService(rx, "SCHEMA1", "DEVICE2", "reboot", map[string]any{"mode": 0}); err == nil {
+		t.Fatal("missing device should fail")
+	}
+	if len(ListIoTServiceCache("SCHEMA1")) != 1 {
+		t.Fatal("unexpected service list")
+	}
+	FlushIoTFunctionCache("SCHEMA1")
+	if _, ok := GetIoTServiceCache("SCHEMA1", "reboot"); ok {
+		t.Fatal("service should be flushed")
+	}
+}
+
+// go test -timeout 30s -run ^Test_IoTEvent_Emit github.com/hootrhino/rhilex/component/dataschema -v -count=1
+func Test_IoTEvent_Emit(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	intercache.InitGlobalValueRegistry(nil)
+	eventbus.InitEventBus(nil)
+	defer eventbus.Stop()
+	SetIoTFunctionCache("SCHEMA1", []IoTEvent{{
+		Name:     "overheat",
+		Severity: IoTEventSeverityAlert,
+		Outputs:  []IoTParam{{Name: "temp", Type: IoTPropertyTypeFloat, Rule: IoTPropertyRule{Min: -40, Max: 200}}},
+	}, {
+		Name:     "bad",
+		Severity: "PANIC",
+	}}, nil)
+	if _, ok := GetIoTEventCache("SCHEMA1", "bad"); ok {
+		t.Fatal("invalid event should be skipped")
+	}
+	received := make(chan eventbus.EventMessage, 1)
+	eventbus.Subscribe("schema.event.SCHEMA1", &eventbus.Subscriber{
+		Callback: func(topic string, msg eventbus.EventMessage) {
+			received <- msg
+		},
+	})
+	if _, err := EmitEvent("SCHEMA1", "DEVICE1", "overheat", map[string]any{"temp": 300}); err == nil {
+		t.Fatal("out of range output should fail")
+	}
+	if _, err := EmitEvent("SCHEMA1", "DEVICE1", "overheat", map[string]any{"temp": 95.5}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		message := msg.Payload.(IoTEventMessage)
+		if msg.From != "DEVICE1" || message.Severity != IoTEventSeverityAlert || message.Params["temp"] != 95.5 {
+			t.Fatal("unexpected event", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not received")
+	}
+}
+
+// go test -timeout 30s -run ^Test_IoTBinding_Sync github.com/hootrhino/rhilex/component/dataschema -v -count=1
+func Test_IoTBinding_Sync(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	intercache.InitGlobalValueRegistry(nil)
+	resolvePointTags = func(deviceUuid string) (map[string]string, error) {
+		return map[string]string{"t1": "POINT1", "sw": "POINT2"}, nil
+	}
+	rows := []map[string]any{}
+	saveDataCenterRow = func(schemaUuid string, row map[string]any) error {
+		rows = append(rows, row)
+		return nil
+	}
+	properties := []IoTProperty{
+		{UUID: "P1", Name: "temp", Type: IoTPropertyTypeFloat, Rw: "R",
+			Rule: IoTPropertyRule{Min: -40, Max: 200, Round: 1}},
+		{UUID: "P2", Name: "switch", Type: IoTPropertyTypeBool, Rw: "RW"},
+	}
+	if err := SetIoTBindings("SCHEMA2", true, properties, []IoTBinding{
+		{Property: "nope", DeviceUuid: "DEVICE1", Tag: "t1"},
+	}); err == nil {
+		t.Fatal("unknown property should fail")
+	}
+	if err := SetIoTBindings("SCHEMA2", true, properties, []IoTBinding{
+		{Property: "temp", DeviceUuid: "DEVICE1", Tag: "t1", Expr: "value +"},
+	}); err == nil {
+		t.Fatal("invalid expr should fail")
+	}
+	if err := SetIoTBindings("SCHEMA2", true, properties, []IoTBinding{
+		{Property: "temp", DeviceUuid: "DEVICE1", Tag: "t1", Expr: "value / 10"},
+		{Property: "switch", DeviceUuid: "DEVICE1", Tag: "sw", WriteExpr: "value ? 1 : 0"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetIoTBindings("SCHEMA2", false, nil, nil)
+	intercache.RegisterSlot("DEVICE1")
+	intercache.SetValue("DEVICE1", "POINT1", intercache.CacheValue{
+		UUID: "POINT1", Status: 1, LastFetchTime: 1, Value: "215.33"})
+	intercache.SetValue("DEVICE1", "POINT2", intercache.CacheValue{
+		UUID: "POINT2", Status: 0, LastFetchTime: 1, Value: "1"})
+	SyncIoTBindings()
+	value, ok := GetIoTPropertyValue("SCHEMA2", "temp")
+	if !ok || value.Value != 21.5 || value.Ts != 1 {
+		t.Fatal("unexpected value", value)
+	}
+	if _, ok := GetIoTPropertyValue("SCHEMA2", "switch"); ok {
+		t.Fatal("failed point should be skipped")
+	}
+	if len(rows) != 1 || rows[0]["temp"] != 21.5 {
+		t.Fatal("unexpected rows", rows)
+	}
+	// 采集时间没变不算新值
+	SyncIoTBindings()
+	if len(rows) != 1 {
+		t.Fatal("unchanged point should not be saved", rows)
+	}
+	// 超出范围的值丢掉
+	intercache.SetValue("DEVICE1", "POINT1", intercache.CacheValue{
+		UUID: "POINT1", Status: 1, LastFetchTime: 2, Value: "9999"})
+	SyncIoTBindings()
+	if value, _ := GetIoTPropertyValue("SCHEMA2", "temp"); value.Value != 21.5 || len(rows) != 1 {
+		t.Fatal("out of range value should be dropped", value, rows)
+	}
+	if len(GetIoTPropertyValues("SCHEMA2")) != 1 {
+		t.Fatal("unexpected values")
+	}
+}
+
+// go test -timeout 30s -run ^Test_IoTBinding_Write github.com/hootrhino/rhilex/component/dataschema -v -count=1
+func Test_IoTBinding_Write(t *testing.T) {
+	device := &fakeDevice{}
+	rx := &fakeRhilex{device: &typex.Device{UUID: "DEVICE1", Device: device}}
+	properties := []IoTProperty{
+		{UUID: "P1", Name: "temp", Type: IoTPropertyTypeFloat, Rw: "R",
+			Rule: IoTPropertyRule{Min: -40, Max: 200}},
+		{UUID: "P2", Name: "switch", Type: IoTPropertyTypeBool, Rw: "RW"},
+		{UUID: "P3", Name: "speed", Type: IoTPropertyTypeInteger, Rw: "W",
+			Rule: IoTPropertyRule{Min: 0, Max: 100}},
+	}
+	if err := SetIoTBindings("SCHEMA3", false, properties, []IoTBinding{
+		{Property: "temp", DeviceUuid: "DEVICE1", Tag: "t1"},
+		{Property: "switch", DeviceUuid: "DEVICE1", Tag: "sw", WriteExpr: "value ? 1 : 0"},
+		{Property: "speed", DeviceUuid: "DEVICE1", Tag: "sp", CtrlCommand: "SetSpeed"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetIoTBindings("SCHEMA3", false, nil, nil)
+	if !HasIoTBinding("SCHEMA3", "switch") || HasIoTBinding("SCHEMA3", "nope") {
+		t.Fatal("unexpected binding lookup")
+	}
+	if err := WriteProperty(rx, "SCHEMA3", "temp", 20); err == nil {
+		t.Fatal("read only property should fail")
+	}
+	if err := WriteProperty(rx, "SCHEMA3", "switch", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if device.cmd != "WriteToSheetRegisterWithTag" || device.args != `{"tag":"sw","value":"1"}` {
+		t.Fatal("unexpected write", device.cmd, device.args)
+	}
+	if err := WriteProperty(rx, "SCHEMA3", "speed", 42.0); err != nil {
+		t.Fatal(err)
+	}
+	if device.cmd != "SetSpeed" || device.args != `{"tag":"sp","value":"42"}` {
+		t.Fatal("unexpected write", device.cmd, device.args)
+	}
+	if err := WriteProperty(rx, "SCHEMA3", "speed", 101); err == nil {
+		t.Fatal("out of range value should fail")
+	}
+	if err := WriteProperty(rx, "SCHEMA3", "nope", 1); err == nil {
+		t.Fatal("unbound property should fail")
+	}
+}
+
+// go test -timeout 30s -run ^Test_CastPropertyValue github.com/hootrhino/rhilex/component/dataschema -v -count=1
+func Test_CastPropertyValue(t *testing.T) {
+	cases := []struct {
+		property IoTProperty
+		value    any
+		expect   any
+	}{
+		{IoTProperty{Type: IoTPropertyTypeInteger, Rule: IoTPropertyRule{Max: 100}}, "12.9", int64(12)},
+		{IoTProperty{Type: IoTPropertyTypeFloat, Rule: IoTPropertyRule{Max: 100, Round: 2}}, 3.14159, 3.14},
+		{IoTProperty{Type: IoTPropertyTypeBool}, 0.0, false},
+		{IoTProperty{Type: IoTPropertyTypeString, Rule: IoTPropertyRule{Max: 10}}, 12.5, "12.5"},
+	}
+	for _, c := range cases {
+		result, err := CastPropertyValue(c.property, c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != c.expect {
+			t.Fatal("unexpected cast", c.value, result)
+		}
+	}
+	array := IoTProperty{Type: IoTPropertyTypeArray,
+		Rule: IoTPropertyRule{ElementType: IoTPropertyTypeInteger, Max: 10, Size: 2}}
+	result, err := CastPropertyValue(array, "[1,2]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes, _ := json.Marshal(result)
+	if string(bytes) != "[1,2]" {
+		t.Fatal("unexpected array", string(bytes))
+	}
+	if _, err := CastPropertyValue(array, "[1,2,3]"); err == nil {
+		t.Fatal("oversize array should fail")
+	}
+	if _, err := CastPropertyValue(IoTProperty{Type: IoTPropertyTypeInteger}, "abc"); err == nil {
+		t.Fatal("invalid number should fail")
+	}
+}
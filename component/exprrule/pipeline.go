@@ -0,0 +1,402 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package exprrule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/hootrhino/rhilex/component/interqueue"
+	"github.com/hootrhino/rhilex/component/rulestream"
+	"github.com/hootrhino/rhilex/typex"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+*
+* 声明式规则定义, JSON 或者 YAML:
+*
+*   filter: "temp > 30 && msg.quality == 'GOOD'"
+*   mappings:
+*     - {from: temp, to: temperature, unit: {from: C, to: F}, precision: 1}
+*     - {to: alarm, expr: "temp > 80"}
+*   outputs:
+*     - target: OUTEND_UUID
+*
+ */
+type Definition struct {
+	Filter       string    `json:"filter" yaml:"filter"`             // 过滤表达式, 结果必须是 bool, 为空不过滤
+	Mappings     []Mapping `json:"mappings" yaml:"mappings"`         // 字段映射
+	KeepUnmapped bool      `json:"keepUnmapped" yaml:"keepUnmapped"` // 保留没有映射的字段
+	Outputs      []Output  `json:"outputs" yaml:"outputs"`           // 输出目标
+}
+
+type Mapping struct {
+	From      string   `json:"from" yaml:"from"`           // 源字段, 支持 a.b.c
+	To        string   `json:"to" yaml:"to"`               // 目标字段, 为空和 From 相同
+	Expr      string   `json:"expr" yaml:"expr"`           // 计算表达式, value 是 From 的值
+	Default   any      `json:"default" yaml:"default"`     // 源字段不存在时的值
+	Unit      *Unit    `json:"unit" yaml:"unit"`           // 单位换算
+	Scale     *float64 `json:"scale" yaml:"scale"`         // 线性变换: value*scale+offset
+	Offset    float64  `json:"offset" yaml:"offset"`       //
+	Precision *int     `json:"precision" yaml:"precision"` // 保留几位小数
+}
+
+type Unit struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+type Output struct {
+	Target string `json:"target" yaml:"target"` // 输出资源 UUID
+	Stream string `json:"stream" yaml:"stream"` // 发布到规则流, 和 Target 二选一
+}
+
+// JSON 是 YAML 的子集, 统一用 YAML 解析
+func Parse(text string) (Definition, error) {
+	def := Definition{}
+	if strings.TrimSpace(text) == "" {
+		return def, errors.New("empty rule definition")
+	}
+	if err := yaml.Unmarshal([]byte(text), &def); err != nil {
+		return def, fmt.Errorf("invalid rule definition: %w", err)
+	}
+	if len(def.Outputs) == 0 {
+		return def, errors.New("rule definition must have at least one output")
+	}
+	for _, output := range def.Outputs {
+		if (output.Target == "") == (output.Stream == "") {
+			return def, errors.New("output must have either target or stream")
+		}
+		if output.Stream != "" {
+			if err := rulestream.ValidateName(output.Stream); err != nil {
+				return def, err
+			}
+		}
+	}
+	for _, m := range def.Mappings {
+		if m.From == "" && m.To == "" {
+			return def, errors.New("mapping must have 'from' or 'to'")
+		}
+		if m.From == "" && m.Expr == "" && m.Default == nil {
+			return def, fmt.Errorf("mapping '%s' has no value source", m.To)
+		}
+	}
+	return def, nil
+}
+
+type compiledMapping struct {
+	Mapping
+	program *vm.Program
+	convert func(float64) float64
+}
+
+/*
+*
+* 编译好的处理流程: 过滤 -> 映射 -> 输出
+*
+ */
+type Pipeline struct {
+	e        typex.Rhilex
+	ruleId   string
+	def      Definition
+	filter   *vm.Program
+	mappings []compiledMapping
+}
+
+// 只校验不加载
+func Validate(text string) error {
+	_, err := Compile(nil, "", text)
+	return err
+}
+
+func Compile(e typex.Rhilex, ruleId, text string) (*Pipeline, error) {
+	def, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pipeline{e: e, ruleId: ruleId, def: def}
+	if def.Filter != "" {
+		program, err := expr.Compile(def.Filter, expr.AsBool())
+		if err != nil {
+			return nil, fmt.Errorf("compile filter failed: %w", err)
+		}
+		p.filter = program
+	}
+	for _, m := range def.Mappings {
+		cm := compiledMapping{Mapping: m}
+		if cm.To == "" {
+			cm.To = cm.From
+		}
+		if m.Expr != "" {
+			program, err := expr.Compile(m.Expr)
+			if err != nil {
+				return nil, fmt.Errorf("compile mapping '%s' failed: %w", cm.To, err)
+			}
+			cm.program = program
+		}
+		if m.Unit != nil {
+			convert, err := unitConverter(m.Unit.From, m.Unit.To)
+			if err != nil {
+				return nil, err
+			}
+			cm.convert = convert
+		}
+		p.mappings = append(p.mappings, cm)
+	}
+	return p, nil
+}
+
+/*
+*
+* 表达式的环境: Payload 是对象的话字段直接可用, payload 是整个 Payload,
+* msg 是 {id, ts, quality, tags}, value 是非对象的 Payload
+*
+ */
+func environment(msg *typex.Message) map[string]any {
+	payload := normalize(msg.Data())
+	env := map[string]any{}
+	if fields, ok := payload.(map[string]any); ok {
+		for k, v := range fields {
+			env[k] = v
+		}
+	} else {
+		env["value"] = payload
+	}
+	env["payload"] = payload
+	env["msg"] = map[string]any{
+		"id":      msg.ResourceId,
+		"ts":      msg.Timestamp,
+		"quality": msg.Quality,
+		"tags":    msg.Tags,
+	}
+	return env
+}
+
+// 结构体之类的 Payload 转成 map, 表达式里按 JSON 字段名访问
+func normalize(value any) any {
+	switch value.(type) {
+	case nil, map[string]any, []any, string, float64, bool, int, int64:
+		return value
+	case []byte:
+		return string(value.([]byte))
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized any
+	if err := json.Unmarshal(bytes, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+/*
+*
+* 处理一条消息, 被过滤掉的返回 false
+*
+ */
+func (p *Pipeline) Process(msg *typex.Message) (any, bool, error) {
+	env := environment(msg)
+	if p.filter != nil {
+		output, err := expr.Run(p.filter, env)
+		if err != nil {
+			return nil, false, fmt.Errorf("filter failed: %w", err)
+		}
+		if pass, _ := output.(bool); !pass {
+			return nil, false, nil
+		}
+	}
+	if len(p.mappings) == 0 {
+		return env["payload"], true, nil
+	}
+	record := map[string]any{}
+	if p.def.KeepUnmapped {
+		if fields, ok := env["payload"].(map[string]any); ok {
+			for k, v := range fields {
+				record[k] = v
+			}
+		}
+	}
+	for _, m := range p.mappings {
+		value, err := m.apply(env)
+		if err != nil {
+			return nil, false, err
+		}
+		if p.def.KeepUnmapped && m.From != "" && m.From != m.To && !strings.Contains(m.From, ".") {
+			delete(record, m.From)
+		}
+		setPath(record, m.To, value)
+	}
+	return record, true, nil
+}
+
+func (m compiledMapping) apply(env map[string]any) (any, error) {
+	var value any
+	if m.From != "" {
+		value = lookupPath(env, m.From)
+	}
+	if value == nil {
+		value = m.Default
+	}
+	if m.program != nil {
+		scoped := make(map[string]any, len(env)+1)
+		for k, v := range env {
+			scoped[k] = v
+		}
+		scoped["value"] = value
+		output, err := expr.Run(m.program, scoped)
+		if err != nil {
+			return nil, fmt.Errorf("mapping '%s' failed: %w", m.To, err)
+		}
+		value = output
+	}
+	if m.convert == nil && m.Scale == nil && m.Offset == 0 && m.Precision == nil {
+		return value, nil
+	}
+	number, ok := toFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("mapping '%s' value is not a number: %v", m.To, value)
+	}
+	if m.convert != nil {
+		number = m.convert(number)
+	}
+	if m.Scale != nil {
+		number = number * *m.Scale
+	}
+	number += m.Offset
+	if m.Precision != nil {
+		pow := math.Pow(10, float64(*m.Precision))
+		number = math.Round(number*pow) / pow
+	}
+	return number, nil
+}
+
+// 输出目标的 UUID
+func (p *Pipeline) Targets() []string {
+	targets := []string{}
+	for _, output := range p.def.Outputs {
+		if output.Target != "" {
+			targets = append(targets, output.Target)
+		}
+	}
+	return targets
+}
+
+// 发布到的规则流, 加载的时候用来检查环
+func (p *Pipeline) Streams() []string {
+	streams := []string{}
+	for _, output := range p.def.Outputs {
+		if output.Stream != "" {
+			streams = append(streams, output.Stream)
+		}
+	}
+	return streams
+}
+
+/*
+*
+* 实现 typex.RulePipeline: 处理结果序列化成 JSON 推到每个输出目标
+*
+ */
+func (p *Pipeline) Execute(msg *typex.Message) error {
+	record, pass, err := p.Process(msg)
+	if err != nil || !pass {
+		return err
+	}
+	var data string
+	if s, ok := record.(string); ok {
+		data = s
+	} else {
+		bytes, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		data = string(bytes)
+	}
+	errs := []error{}
+	for _, output := range p.def.Outputs {
+		if output.Stream != "" {
+			next := typex.NewMessage(p.ruleId, record)
+			next.Tags = map[string]string{"stream": output.Stream}
+			next.Hops = msg.Hops + 1
+			if err := rulestream.Emit(output.Stream, next); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		outEnd := p.e.GetOutEnd(output.Target)
+		if outEnd == nil {
+			errs = append(errs, fmt.Errorf("target not found: %s", output.Target))
+			continue
+		}
+		if err := interqueue.PushOutQueue(outEnd, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func lookupPath(env map[string]any, path string) any {
+	var current any = env
+	for _, part := range strings.Split(path, ".") {
+		fields, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = fields[part]
+	}
+	return current
+}
+
+func setPath(record map[string]any, path string, value any) {
+	parts := strings.Split(path, ".")
+	current := record
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
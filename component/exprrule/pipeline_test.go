@@ -0,0 +1,78 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package exprrule
+
+import (
+	"testing"
+
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// go test -timeout 30s -run ^Test_Expr_Pipeline github.com/hootrhino/rhilex/component/exprrule -v -count=1
+func Test_Expr_Pipeline(t *testing.T) {
+	p, err := Compile(nil, "RULE1", `
+filter: "temp > 30 && msg.quality == 'GOOD'"
+keepUnmapped: true
+mappings:
+  - {from: temp, to: temperature, unit: {from: C, to: F}, precision: 1}
+  - {from: sensor.pressure, to: data.pressure, unit: {from: kPa, to: bar}}
+  - {to: alarm, expr: "temp > 80"}
+  - {from: raw, scale: 0.1, offset: -5}
+outputs:
+  - target: OUT1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, pass, err := p.Process(typex.NewStringMessage("IN1",
+		`{"temp":36.55,"raw":100,"site":"A","sensor":{"pressure":250}}`))
+	if err != nil || !pass {
+		t.Fatal("should pass", err)
+	}
+	fields := record.(map[string]any)
+	if fields["temperature"] != 97.8 || fields["alarm"] != false || fields["raw"] != 5.0 ||
+		fields["site"] != "A" || fields["temp"] != nil {
+		t.Fatal("unexpected record", fields)
+	}
+	if fields["data"].(map[string]any)["pressure"] != 2.5 {
+		t.Fatal("unexpected nested field", fields)
+	}
+	if _, pass, _ := p.Process(typex.NewStringMessage("IN1", `{"temp":20}`)); pass {
+		t.Fatal("should be filtered")
+	}
+	// 结构体按 JSON 字段名访问, 非对象的 Payload 用 value 访问
+	type point struct {
+		Level int `json:"level"`
+	}
+	p, _ = Compile(nil, "RULE1", `{"filter": "level >= 2", "outputs": [{"target": "OUT1"}]}`)
+	if _, pass, err := p.Process(typex.NewMessage("DEV1", point{Level: 3})); !pass || err != nil {
+		t.Fatal("struct payload should pass", err)
+	}
+	p, _ = Compile(nil, "RULE1", `{"filter": "value >= 2", "outputs": [{"target": "OUT1"}]}`)
+	if record, pass, _ := p.Process(typex.NewMessage("DEV1", 3.0)); !pass || record != 3.0 {
+		t.Fatal("number payload should pass", record)
+	}
+	for _, bad := range []string{
+		``,
+		`{"filter": "temp >", "outputs": [{"target": "OUT1"}]}`,
+		`{"filter": "temp > 1"}`,
+		`{"mappings": [{"from": "a", "unit": {"from": "C", "to": "kg"}}], "outputs": [{"target": "OUT1"}]}`,
+	} {
+		if err := Validate(bad); err == nil {
+			t.Fatal("invalid definition should fail:", bad)
+		}
+	}
+}
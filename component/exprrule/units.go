@@ -0,0 +1,80 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package exprrule
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+*
+* 单位换算: 同一类单位先换算到基准单位再换算到目标单位, 温度单独处理
+*
+ */
+var __UnitFactors = map[string]map[string]float64{
+	"pressure": {"pa": 1, "kpa": 1e3, "mpa": 1e6, "bar": 1e5, "mbar": 100, "psi": 6894.757293168, "atm": 101325},
+	"length":   {"mm": 1e-3, "cm": 1e-2, "m": 1, "km": 1e3, "in": 0.0254, "ft": 0.3048},
+	"speed":    {"m/s": 1, "km/h": 1 / 3.6, "mph": 0.44704},
+	"mass":     {"mg": 1e-6, "g": 1e-3, "kg": 1, "t": 1e3, "lb": 0.45359237},
+	"volume":   {"ml": 1e-3, "l": 1, "m3": 1e3},
+	"power":    {"w": 1, "kw": 1e3, "mw": 1e6},
+	"energy":   {"wh": 1, "kwh": 1e3, "mwh": 1e6, "j": 1 / 3600.0, "kj": 1 / 3.6},
+	"time":     {"ms": 1e-3, "s": 1, "min": 60, "h": 3600},
+	"current":  {"ma": 1e-3, "a": 1},
+	"voltage":  {"mv": 1e-3, "v": 1, "kv": 1e3},
+}
+
+var __Temperatures = map[string]bool{"c": true, "f": true, "k": true}
+
+// 换算函数, 单位不支持或者不是同一类就报错
+func unitConverter(from, to string) (func(float64) float64, error) {
+	from, to = strings.ToLower(from), strings.ToLower(to)
+	if from == to {
+		return func(v float64) float64 { return v }, nil
+	}
+	if __Temperatures[from] && __Temperatures[to] {
+		return func(v float64) float64 { return fromCelsius(toCelsius(v, from), to) }, nil
+	}
+	for _, factors := range __UnitFactors {
+		f, ok1 := factors[from]
+		t, ok2 := factors[to]
+		if ok1 && ok2 {
+			return func(v float64) float64 { return v * f / t }, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported unit conversion: %s -> %s", from, to)
+}
+
+func toCelsius(v float64, unit string) float64 {
+	switch unit {
+	case "f":
+		return (v - 32) * 5 / 9
+	case "k":
+		return v - 273.15
+	}
+	return v
+}
+
+func fromCelsius(v float64, unit string) float64 {
+	switch unit {
+	case "f":
+		return v*9/5 + 32
+	case "k":
+		return v + 273.15
+	}
+	return v
+}
@@ -69,6 +69,11 @@ func StopInterDb() {
 	runtime.GC()
 }
 
+// 数据库是否已经初始化, 命令行工具里没有初始化
+func Initialized() bool {
+	return __InternalSqlite != nil && __InternalSqlite.db != nil
+}
+
 /*
 *
 * 返回数据库查询句柄
@@ -21,6 +21,10 @@ import (
 
 type Queue interface {
 	PushInQueue(in *typex.InEnd, data string) error
+	PushInQueueToRules(in *typex.InEnd, data string, ruleIds []string) error
 	PushOutQueue(in *typex.OutEnd, data string) error
 	PushDeviceQueue(in *typex.Device, data string) error
+	PushInQueueMessage(in *typex.InEnd, msg *typex.Message) error
+	PushInQueueMessageToRules(in *typex.InEnd, msg *typex.Message, ruleIds []string) error
+	PushDeviceQueueMessage(in *typex.Device, msg *typex.Message) error
 }
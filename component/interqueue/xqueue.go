@@ -17,9 +17,8 @@ package interqueue
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"hash/fnv"
 	"sync"
-	"time"
 
 	"github.com/hootrhino/rhilex/component/intermetric"
 	"github.com/hootrhino/rhilex/component/luaexecutor"
@@ -39,30 +38,25 @@ type XQueue struct {
 	maxQueueSize int
 }
 
-// 初始化队列
-func InitXQueue(rhilex typex.Rhilex, maxQueueSize int) *XQueue {
-	queueCount := 10
-	inQueue := make([]chan QueueData, queueCount)
-	outQueue := make([]chan QueueData, queueCount)
-	deviceQueue := make([]chan QueueData, queueCount)
-
-	// 初始化队列通道
-	initQueues := func(queues []chan QueueData) {
-		for i := 0; i < queueCount; i++ {
-			queues[i] = make(chan QueueData, maxQueueSize)
+// 初始化队列, 每个队列的通道数就是 worker 数, 每个通道一个协程
+func InitXQueue(rhilex typex.Rhilex, config typex.RhilexConfig) *XQueue {
+	newQueues := func(workers int) []chan QueueData {
+		if workers <= 0 {
+			workers = 10
+		}
+		queues := make([]chan QueueData, workers)
+		for i := 0; i < workers; i++ {
+			queues[i] = make(chan QueueData, config.MaxQueueSize)
 		}
+		return queues
 	}
-	initQueues(inQueue)
-	initQueues(outQueue)
-	initQueues(deviceQueue)
-
 	__DefaultXQueue = &XQueue{
-		InQueue:      inQueue,
-		OutQueue:     outQueue,
-		DeviceQueue:  deviceQueue,
+		InQueue:      newQueues(config.InQueueWorkers),
+		OutQueue:     newQueues(config.OutQueueWorkers),
+		DeviceQueue:  newQueues(config.DeviceQueueWorkers),
 		rhilex:       rhilex,
 		locker:       sync.Mutex{},
-		maxQueueSize: maxQueueSize,
+		maxQueueSize: config.MaxQueueSize,
 	}
 	return __DefaultXQueue
 }
@@ -72,40 +66,40 @@ func StartXQueue() {
 	__DefaultXQueue.StartXQueue()
 }
 
-// 通用的推送函数
-func pushData(q *XQueue, data QueueData, queue []chan QueueData) error {
-	// 减少锁的使用范围
-	var available []int
-	for i := 0; i < len(queue); i++ {
-		if len(queue[i])+1 <= q.maxQueueSize {
-			available = append(available, i)
-		}
+/*
+*
+* 通用的推送函数: 按照资源的 UUID 哈希到固定的通道, 同一个资源的数据严格有序,
+* 不同的资源在不同的协程里并行处理
+*
+ */
+func pushData(q *XQueue, key string, data QueueData, queue []chan QueueData) error {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	select {
+	case queue[h.Sum32()%uint32(len(queue))] <- data:
+		return nil
+	default:
+		return fmt.Errorf("Queue Send Failed: queue is full")
 	}
-	if len(available) == 0 {
-		return fmt.Errorf("Queue Send Failed: No available channels")
-	}
-	// 更高效的随机数生成
-	randomIndex := available[rand.Intn(len(available))]
-	queue[randomIndex] <- data
-	return nil
 }
 
-// 通用的启动队列处理函数
+// 通用的启动队列处理函数, 每个通道一个 worker
 func startQueue(ctx context.Context, q *XQueue, queue []chan QueueData, logMsg string, callback func(QueueData)) {
-	glogger.GLogger.Info("Start XQueue: " + logMsg)
-	for {
-		for _, qc := range queue {
-			select {
-			case <-ctx.Done():
-				return
-			case data, ok := <-qc:
-				if ok {
+	glogger.GLogger.Infof("Start XQueue: %s, workers: %d", logMsg, len(queue))
+	for _, qc := range queue {
+		go func(qc chan QueueData) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case data, ok := <-qc:
+					if !ok {
+						return
+					}
 					callback(data)
 				}
-			case <-time.After(4 * time.Millisecond):
-				continue
 			}
-		}
+		}(qc)
 	}
 }
 
@@ -115,7 +109,11 @@ func (q *XQueue) startInQueue(ctx context.Context) {
 		if data.I == nil || data.E == nil {
 			return
 		}
-		luaexecutor.RunSourceCallbacks(data.I, data.Data)
+		if data.Message != nil {
+			luaexecutor.RunSourceMessageCallbacks(data.I, data.Message, data.RuleIds)
+			return
+		}
+		luaexecutor.RunSourceCallbacksToRules(data.I, data.Data, data.RuleIds)
 	})
 }
 
@@ -125,6 +123,10 @@ func (q *XQueue) startDeviceQueue(ctx context.Context) {
 		if data.D == nil || data.E == nil {
 			return
 		}
+		if data.Message != nil {
+			luaexecutor.RunDeviceMessageCallbacks(data.D, data.Message)
+			return
+		}
 		luaexecutor.RunDeviceCallbacks(data.D, data.Data)
 	})
 }
@@ -139,9 +141,9 @@ func (q *XQueue) startOutQueue(ctx context.Context) {
 // 启动所有队列
 func (q *XQueue) StartXQueue() {
 	ctx := context.Background()
-	go q.startInQueue(ctx)
-	go q.startDeviceQueue(ctx)
-	go q.startOutQueue(ctx)
+	q.startInQueue(ctx)
+	q.startDeviceQueue(ctx)
+	q.startOutQueue(ctx)
 }
 
 // 通用的推送包装函数
@@ -156,12 +158,26 @@ func (q *XQueue) PushInQueue(in *typex.InEnd, data string) error {
 		I:    in,
 		Data: data,
 	}
-	return pushData(q, qd, q.InQueue)
+	return pushData(q, in.UUID, qd, q.InQueue)
 }
 func PushInQueue(in *typex.InEnd, data string) error {
 	return pushWrapper(__DefaultXQueue, (*XQueue).PushInQueue, in, data)
 }
 
+// 推送数据到输入队列, 只交给指定的规则处理
+func (q *XQueue) PushInQueueToRules(in *typex.InEnd, data string, ruleIds []string) error {
+	qd := QueueData{
+		E:       q.rhilex,
+		I:       in,
+		Data:    data,
+		RuleIds: ruleIds,
+	}
+	return pushData(q, in.UUID, qd, q.InQueue)
+}
+func PushInQueueToRules(in *typex.InEnd, data string, ruleIds []string) error {
+	return __DefaultXQueue.PushInQueueToRules(in, data, ruleIds)
+}
+
 // 推送数据到设备队列
 func (q *XQueue) PushDeviceQueue(device *typex.Device, data string) error {
 	qd := QueueData{
@@ -169,12 +185,52 @@ func (q *XQueue) PushDeviceQueue(device *typex.Device, data string) error {
 		D:    device,
 		Data: data,
 	}
-	return pushData(q, qd, q.DeviceQueue)
+	return pushData(q, device.UUID, qd, q.DeviceQueue)
 }
 func PushDeviceQueue(device *typex.Device, data string) error {
 	return pushWrapper(__DefaultXQueue, (*XQueue).PushDeviceQueue, device, data)
 }
 
+// 推送结构化的消息到输入队列
+func (q *XQueue) PushInQueueMessage(in *typex.InEnd, msg *typex.Message) error {
+	qd := QueueData{
+		E:       q.rhilex,
+		I:       in,
+		Message: msg,
+	}
+	return pushData(q, in.UUID, qd, q.InQueue)
+}
+func PushInQueueMessage(in *typex.InEnd, msg *typex.Message) error {
+	return __DefaultXQueue.PushInQueueMessage(in, msg)
+}
+
+// 推送结构化的消息到输入队列, 只交给指定的规则处理
+func (q *XQueue) PushInQueueMessageToRules(in *typex.InEnd, msg *typex.Message, ruleIds []string) error {
+	qd := QueueData{
+		E:       q.rhilex,
+		I:       in,
+		Message: msg,
+		RuleIds: ruleIds,
+	}
+	return pushData(q, in.UUID, qd, q.InQueue)
+}
+func PushInQueueMessageToRules(in *typex.InEnd, msg *typex.Message, ruleIds []string) error {
+	return __DefaultXQueue.PushInQueueMessageToRules(in, msg, ruleIds)
+}
+
+// 推送结构化的消息到设备队列
+func (q *XQueue) PushDeviceQueueMessage(device *typex.Device, msg *typex.Message) error {
+	qd := QueueData{
+		E:       q.rhilex,
+		D:       device,
+		Message: msg,
+	}
+	return pushData(q, device.UUID, qd, q.DeviceQueue)
+}
+func PushDeviceQueueMessage(device *typex.Device, msg *typex.Message) error {
+	return __DefaultXQueue.PushDeviceQueueMessage(device, msg)
+}
+
 // 推送数据到输出队列
 func (q *XQueue) PushOutQueue(out *typex.OutEnd, data string) error {
 	qd := QueueData{
@@ -182,7 +238,7 @@ func (q *XQueue) PushOutQueue(out *typex.OutEnd, data string) error {
 		O:    out,
 		Data: data,
 	}
-	return pushData(q, qd, q.OutQueue)
+	return pushData(q, out.UUID, qd, q.OutQueue)
 }
 func PushOutQueue(out *typex.OutEnd, data string) error {
 	return pushWrapper(__DefaultXQueue, (*XQueue).PushOutQueue, out, data)
@@ -195,6 +251,10 @@ type QueueData struct {
 	D     *typex.Device
 	E     typex.Rhilex
 	Data  string
+	// 结构化的消息, 不为空的时候忽略 Data
+	Message *typex.Message
+	// 为空表示交给所有绑定的规则
+	RuleIds []string
 }
 
 func (qd QueueData) String() string {
@@ -0,0 +1,141 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luadebugger
+
+import (
+	"strconv"
+	"strings"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/gopher-lua/ast"
+	"github.com/hootrhino/gopher-lua/parse"
+)
+
+// 插到每条语句前面的钩子函数, 参数是行号和脚本名
+const HOOK_NAME = "__rhilex_debugger"
+
+/*
+*
+* 虚拟机没有行钩子, 编译之前在语法树的每条语句前面插一个钩子调用;
+* 行号和原来的脚本一致, 出错的时候行号也不变
+*
+ */
+func Instrument(source, name string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(instrumentBlock(chunk, name), name)
+}
+
+func instrumentBlock(stmts []ast.Stmt, name string) []ast.Stmt {
+	block := make([]ast.Stmt, 0, len(stmts)*2)
+	last := -1
+	for _, stmt := range stmts {
+		instrumentStmt(stmt, name)
+		// 同一行的多条语句只停一次
+		if stmt.Line() != last {
+			block = append(block, hookStmt(stmt.Line(), name))
+			last = stmt.Line()
+		}
+		block = append(block, stmt)
+	}
+	return block
+}
+
+func hookStmt(line int, name string) ast.Stmt {
+	fn := &ast.IdentExpr{Value: HOOK_NAME}
+	lineArg := &ast.NumberExpr{Value: strconv.Itoa(line)}
+	nameArg := &ast.StringExpr{Value: name}
+	call := &ast.FuncCallExpr{Func: fn, Args: []ast.Expr{lineArg, nameArg}}
+	stmt := &ast.FuncCallStmt{Expr: call}
+	for _, node := range []ast.PositionHolder{fn, lineArg, nameArg, call, stmt} {
+		node.SetLine(line)
+		node.SetLastLine(line)
+	}
+	return stmt
+}
+
+func instrumentStmt(stmt ast.Stmt, name string) {
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		instrumentExprs(s.Lhs, name)
+		instrumentExprs(s.Rhs, name)
+	case *ast.LocalAssignStmt:
+		instrumentExprs(s.Exprs, name)
+	case *ast.FuncCallStmt:
+		instrumentExpr(s.Expr, name)
+	case *ast.DoBlockStmt:
+		s.Stmts = instrumentBlock(s.Stmts, name)
+	case *ast.WhileStmt:
+		instrumentExpr(s.Condition, name)
+		s.Stmts = instrumentBlock(s.Stmts, name)
+	case *ast.RepeatStmt:
+		instrumentExpr(s.Condition, name)
+		s.Stmts = instrumentBlock(s.Stmts, name)
+	case *ast.IfStmt:
+		instrumentExpr(s.Condition, name)
+		s.Then = instrumentBlock(s.Then, name)
+		s.Else = instrumentBlock(s.Else, name)
+	case *ast.NumberForStmt:
+		instrumentExprs([]ast.Expr{s.Init, s.Limit, s.Step}, name)
+		s.Stmts = instrumentBlock(s.Stmts, name)
+	case *ast.GenericForStmt:
+		instrumentExprs(s.Exprs, name)
+		s.Stmts = instrumentBlock(s.Stmts, name)
+	case *ast.FuncDefStmt:
+		instrumentExpr(s.Func, name)
+	case *ast.ReturnStmt:
+		instrumentExprs(s.Exprs, name)
+	}
+}
+
+func instrumentExprs(exprs []ast.Expr, name string) {
+	for _, expr := range exprs {
+		instrumentExpr(expr, name)
+	}
+}
+
+// 表达式里面也会定义函数, 比如 Actions 表里的回调
+func instrumentExpr(expr ast.Expr, name string) {
+	switch e := expr.(type) {
+	case *ast.FunctionExpr:
+		e.Stmts = instrumentBlock(e.Stmts, name)
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			instrumentExprs([]ast.Expr{field.Key, field.Value}, name)
+		}
+	case *ast.FuncCallExpr:
+		instrumentExprs([]ast.Expr{e.Func, e.Receiver}, name)
+		instrumentExprs(e.Args, name)
+	case *ast.AttrGetExpr:
+		instrumentExprs([]ast.Expr{e.Object, e.Key}, name)
+	case *ast.LogicalOpExpr:
+		instrumentExprs([]ast.Expr{e.Lhs, e.Rhs}, name)
+	case *ast.RelationalOpExpr:
+		instrumentExprs([]ast.Expr{e.Lhs, e.Rhs}, name)
+	case *ast.StringConcatOpExpr:
+		instrumentExprs([]ast.Expr{e.Lhs, e.Rhs}, name)
+	case *ast.ArithmeticOpExpr:
+		instrumentExprs([]ast.Expr{e.Lhs, e.Rhs}, name)
+	case *ast.UnaryMinusOpExpr:
+		instrumentExpr(e.Expr, name)
+	case *ast.UnaryNotOpExpr:
+		instrumentExpr(e.Expr, name)
+	case *ast.UnaryLenOpExpr:
+		instrumentExpr(e.Expr, name)
+	}
+}
@@ -0,0 +1,487 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luadebugger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/ruletest"
+	"github.com/hootrhino/rhilex/rhilexlib"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// 规则的三段脚本, 断点的 source 用这几个名字
+const (
+	SOURCE_ACTIONS = "actions"
+	SOURCE_SUCCESS = "success"
+	SOURCE_FAILED  = "failed"
+)
+
+const (
+	MaxSessions    = 4
+	MaxTableFields = 200
+	// 抓取输入的默认超时时间
+	DefaultCaptureTimeout = 60 * time.Second
+)
+
+var __sessions atomic.Int32
+
+type Breakpoint struct {
+	Source string `json:"source"` // 为空是 actions
+	Line   int    `json:"line"`
+}
+
+/*
+*
+* 客户端发过来的命令
+*
+ */
+type Command struct {
+	Cmd         string                `json:"cmd"`
+	RuleId      string                `json:"ruleId"`
+	Input       string                `json:"input"`
+	Capture     bool                  `json:"capture"` // 抓取规则的下一条真实输入
+	Timeout     int                   `json:"timeout"` // 抓取超时, 秒
+	Actions     string                `json:"actions"`
+	Success     string                `json:"success"`
+	Failed      string                `json:"failed"`
+	Mocks       ruletest.FixtureMocks `json:"mocks"`
+	Breakpoints []Breakpoint          `json:"breakpoints"`
+	Level       int                   `json:"level"` // variables: 第几层调用栈, 默认 1
+	Ref         int                   `json:"ref"`   // variables: 展开表
+}
+
+type Frame struct {
+	Level  int    `json:"level"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Line   int    `json:"line"`
+}
+
+type Variable struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope,omitempty"` // local, upvalue
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Ref   int    `json:"ref,omitempty"` // 表的引用, 暂停期间有效
+}
+
+type Result struct {
+	Output any                    `json:"output"`
+	Calls  []ruletest.FixtureCall `json:"calls"`
+	KV     map[string]string      `json:"kv"`
+	Logs   []string               `json:"logs"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+/*
+*
+* 发给客户端的事件
+*
+ */
+type Event struct {
+	Event     string     `json:"event"`
+	Reason    string     `json:"reason,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	Line      int        `json:"line,omitempty"`
+	Stack     []Frame    `json:"stack,omitempty"`
+	Level     int        `json:"level,omitempty"`
+	Ref       int        `json:"ref,omitempty"`
+	Variables []Variable `json:"variables,omitempty"`
+	Input     string     `json:"input,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	Result    *Result    `json:"result,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+/*
+*
+* 一个调试会话: 规则在沙箱里执行, 外部调用全部模拟, 不影响正在运行的规则;
+* 钩子在虚拟机的协程里阻塞, 暂停期间查看变量也在这个协程里做
+*
+ */
+type Session struct {
+	e        typex.Rhilex
+	send     func(Event)
+	ctx      context.Context
+	cancel   context.CancelFunc
+	commands chan Command
+	running  atomic.Bool
+	paused   atomic.Bool
+	pause    atomic.Bool
+	locker   sync.Mutex
+	breaks   map[string]map[int]bool
+	// 下面的只在虚拟机协程里用
+	armed     bool
+	step      string
+	stepDepth int
+	refs      map[int]*lua.LTable
+}
+
+// send 会被多个协程调用, 调用方负责加锁
+func NewSession(e typex.Rhilex, send func(Event)) (*Session, error) {
+	if __sessions.Add(1) > MaxSessions {
+		__sessions.Add(-1)
+		return nil, fmt.Errorf("too many debug sessions, max %d", MaxSessions)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Session{
+		e:        e,
+		send:     send,
+		ctx:      ctx,
+		cancel:   cancel,
+		commands: make(chan Command, 8),
+		breaks:   map[string]map[int]bool{},
+	}, nil
+}
+
+func (s *Session) Close() {
+	s.cancel()
+	__sessions.Add(-1)
+}
+
+/*
+*
+* 处理 start 以外的命令; 继续、单步和查看变量只能在暂停的时候用
+*
+ */
+func (s *Session) Handle(cmd Command) {
+	switch cmd.Cmd {
+	case "setBreakpoints":
+		s.setBreakpoints(cmd.Breakpoints)
+		s.send(Event{Event: "breakpoints"})
+	case "pause":
+		s.pause.Store(true)
+	case "stop":
+		s.cancel()
+	case "continue", "stepInto", "stepOver", "stepOut", "variables":
+		if !s.paused.Load() {
+			s.send(Event{Event: "error", Error: "rule is not paused"})
+			return
+		}
+		select {
+		case s.commands <- cmd:
+		default:
+			s.send(Event{Event: "error", Error: "debugger is busy"})
+		}
+	default:
+		s.send(Event{Event: "error", Error: "unknown command: " + cmd.Cmd})
+	}
+}
+
+func (s *Session) setBreakpoints(breakpoints []Breakpoint) {
+	breaks := map[string]map[int]bool{}
+	for _, b := range breakpoints {
+		if b.Source == "" {
+			b.Source = SOURCE_ACTIONS
+		}
+		if breaks[b.Source] == nil {
+			breaks[b.Source] = map[int]bool{}
+		}
+		breaks[b.Source][b.Line] = true
+	}
+	s.locker.Lock()
+	s.breaks = breaks
+	s.locker.Unlock()
+}
+
+func (s *Session) hasBreakpoint(source string, line int) bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.breaks[source][line]
+}
+
+/*
+*
+* 开始调试一次执行, 一个会话同时只能执行一次
+*
+ */
+func (s *Session) Start(script ruletest.RuleScript, cmd Command) error {
+	if script.Type == typex.RULE_TYPE_EXPR {
+		return errors.New("expr rule can not be debugged")
+	}
+	if !s.running.CompareAndSwap(false, true) {
+		return errors.New("debugger is running")
+	}
+	s.setBreakpoints(cmd.Breakpoints)
+	go func() {
+		defer s.running.Store(false)
+		result, err := s.run(script, cmd)
+		if err != nil {
+			s.send(Event{Event: "error", Error: err.Error()})
+			return
+		}
+		s.send(Event{Event: "terminated", Result: result})
+	}()
+	return nil
+}
+
+func (s *Session) run(script ruletest.RuleScript, cmd Command) (*Result, error) {
+	msg := typex.NewStringMessage(script.UUID, cmd.Input)
+	if cmd.Capture {
+		captured, err := s.capture(script.UUID, cmd.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		msg = captured
+	}
+	sandbox, err := ruletest.NewSandbox(s.e, script, cmd.Mocks)
+	if err != nil {
+		return nil, err
+	}
+	defer sandbox.Close()
+	sandbox.OnLog(func(text string) {
+		s.send(Event{Event: "output", Text: text})
+	})
+	L := sandbox.Rule.LuaVM
+	L.SetGlobal(HOOK_NAME, L.NewFunction(s.hook))
+	for _, chunk := range []struct{ name, code string }{
+		{SOURCE_ACTIONS, script.Actions},
+		{SOURCE_SUCCESS, script.Success},
+		{SOURCE_FAILED, script.Failed},
+	} {
+		proto, err := Instrument(chunk.code, chunk.name)
+		if err != nil {
+			return nil, fmt.Errorf("load rule failed: %w", err)
+		}
+		if err := L.CallByParam(lua.P{
+			Fn:      L.NewFunctionFromProto(proto),
+			NRet:    0,
+			Protect: true,
+		}); err != nil {
+			return nil, fmt.Errorf("load rule failed: %w", err)
+		}
+	}
+	// 停止的时候中断虚拟机
+	L.SetContext(s.ctx)
+	defer L.RemoveContext()
+	s.armed = true
+	s.step = ""
+	output, err := luaexecutor.ExecuteRuleDebug(sandbox.Rule, msg)
+	result := &Result{
+		Output: toGo(output),
+		Calls:  sandbox.Calls(),
+		KV:     sandbox.KV(),
+		Logs:   sandbox.Logs(),
+	}
+	if s.ctx.Err() != nil {
+		result.Error = "debugger stopped"
+	} else if err != nil {
+		result.Error = err.Error()
+	}
+	return result, nil
+}
+
+// 等规则收到下一条真实的输入
+func (s *Session) capture(ruleId string, timeout int) (*typex.Message, error) {
+	wait := DefaultCaptureTimeout
+	if timeout > 0 {
+		wait = time.Duration(timeout) * time.Second
+	}
+	ch, cancel := luaexecutor.CaptureMessage(ruleId)
+	defer cancel()
+	s.send(Event{Event: "capturing"})
+	select {
+	case msg := <-ch:
+		s.send(Event{Event: "captured", Input: msg.String()})
+		return msg, nil
+	case <-time.After(wait):
+		return nil, fmt.Errorf("no input captured in %v", wait)
+	case <-s.ctx.Done():
+		return nil, errors.New("debugger stopped")
+	}
+}
+
+/*
+*
+* 每条语句执行前调用, 参数: 行号, 脚本名
+*
+ */
+func (s *Session) hook(L *lua.LState) int {
+	if !s.armed {
+		return 0
+	}
+	line := L.CheckInt(1)
+	source := L.CheckString(2)
+	depth := stackDepth(L)
+	reason := ""
+	switch {
+	case s.hasBreakpoint(source, line):
+		reason = "breakpoint"
+	case s.pause.Load():
+		reason = "pause"
+	case s.step == "stepInto",
+		s.step == "stepOver" && depth <= s.stepDepth,
+		s.step == "stepOut" && depth < s.stepDepth:
+		reason = "step"
+	}
+	if reason != "" {
+		s.waitCommand(L, reason, source, line, depth)
+	}
+	return 0
+}
+
+func (s *Session) waitCommand(L *lua.LState, reason, source string, line, depth int) {
+	s.pause.Store(false)
+	s.refs = map[int]*lua.LTable{}
+	s.paused.Store(true)
+	defer s.paused.Store(false)
+	s.send(Event{
+		Event:     "paused",
+		Reason:    reason,
+		Source:    source,
+		Line:      line,
+		Stack:     stackFrames(L),
+		Level:     1,
+		Variables: s.frameVariables(L, 1),
+	})
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case cmd := <-s.commands:
+			switch cmd.Cmd {
+			case "continue":
+				s.step = ""
+				return
+			case "stepInto", "stepOver", "stepOut":
+				s.step = cmd.Cmd
+				s.stepDepth = depth
+				return
+			case "variables":
+				if cmd.Ref > 0 {
+					s.send(Event{Event: "variables", Ref: cmd.Ref, Variables: s.tableFields(L, cmd.Ref)})
+					continue
+				}
+				if cmd.Level <= 0 {
+					cmd.Level = 1
+				}
+				s.send(Event{Event: "variables", Level: cmd.Level, Variables: s.frameVariables(L, cmd.Level)})
+			}
+		}
+	}
+}
+
+// 调用栈深度, 第 0 层是钩子自己
+func stackDepth(L *lua.LState) int {
+	depth := 0
+	for {
+		if _, ok := L.GetStack(depth + 1); !ok {
+			return depth
+		}
+		depth++
+	}
+}
+
+// 只列出 Lua 函数, 层数和 GetStack 一致
+func stackFrames(L *lua.LState) []Frame {
+	frames := []Frame{}
+	for level := 1; ; level++ {
+		dbg, ok := L.GetStack(level)
+		if !ok {
+			return frames
+		}
+		if _, err := L.GetInfo("Sln", dbg, lua.LNil); err != nil || dbg.Source == "" {
+			continue
+		}
+		name := dbg.Name
+		if name == "" {
+			name = "?"
+		}
+		frames = append(frames, Frame{Level: level, Name: name, Source: dbg.Source, Line: dbg.CurrentLine})
+	}
+}
+
+func (s *Session) frameVariables(L *lua.LState, level int) []Variable {
+	variables := []Variable{}
+	dbg, ok := L.GetStack(level)
+	if !ok {
+		return variables
+	}
+	for i := 1; ; i++ {
+		name, value := L.GetLocal(dbg, i)
+		if name == "" {
+			break
+		}
+		if name[0] == '(' {
+			continue // (*temporary)
+		}
+		variables = append(variables, s.variable(name, "local", value))
+	}
+	f, _ := L.GetInfo("f", dbg, lua.LNil)
+	if fn, ok := f.(*lua.LFunction); ok && !fn.IsG {
+		for i := 1; i <= len(fn.Upvalues); i++ {
+			name, value := L.GetUpvalue(fn, i)
+			variables = append(variables, s.variable(name, "upvalue", value))
+		}
+	}
+	return variables
+}
+
+func (s *Session) tableFields(L *lua.LState, ref int) []Variable {
+	variables := []Variable{}
+	table, ok := s.refs[ref]
+	if !ok {
+		return variables
+	}
+	table.ForEach(func(k, v lua.LValue) {
+		if len(variables) < MaxTableFields {
+			variables = append(variables, s.variable(k.String(), "", v))
+		}
+	})
+	sort.SliceStable(variables, func(i, j int) bool {
+		return variables[i].Name < variables[j].Name
+	})
+	return variables
+}
+
+func (s *Session) variable(name, scope string, value lua.LValue) Variable {
+	v := Variable{Name: name, Scope: scope, Type: value.Type().String(), Value: value.String()}
+	switch lv := value.(type) {
+	case *lua.LTable:
+		v.Ref = len(s.refs) + 1
+		s.refs[v.Ref] = lv
+		v.Value = fmt.Sprintf("table[%d]", lv.Len())
+	case lua.LString:
+		bytes, _ := json.Marshal(string(lv))
+		v.Value = string(bytes)
+	}
+	return v
+}
+
+func toGo(value lua.LValue) any {
+	if value == nil || value == lua.LNil {
+		return nil
+	}
+	bytes, err := rhilexlib.EncodeValue(value)
+	if err != nil {
+		return value.String()
+	}
+	var result any
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return value.String()
+	}
+	return result
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luadebugger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/ruletest"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/sirupsen/logrus"
+)
+
+var debugRule = ruletest.RuleScript{
+	UUID:    "RULE_DEBUG",
+	Success: `function Success() end`,
+	Failed:  `function Failed(error) end`,
+	Actions: `
+local function double(x)
+	local y = x * 2
+	return y
+end
+Actions = { function(args)
+	local value = json:J2T(args)
+	local t = double(value.temp)
+	Debug("t", t)
+	return true, t
+end }`,
+}
+
+func nextEvent(t *testing.T, events chan Event, name string) Event {
+	for {
+		select {
+		case event := <-events:
+			if event.Event == "error" {
+				t.Fatal(event.Error)
+			}
+			if event.Event == name {
+				return event
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("wait event timeout:", name)
+		}
+	}
+}
+
+func variable(variables []Variable, name string) (Variable, bool) {
+	for _, v := range variables {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variable{}, false
+}
+
+// go test -timeout 30s -run ^Test_Lua_Debugger github.com/hootrhino/rhilex/component/luadebugger -v -count=1
+func Test_Lua_Debugger(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	events := make(chan Event, 32)
+	session, err := NewSession(nil, func(e Event) { events <- e })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.Start(debugRule, Command{
+		Input:       `{"temp": 21, "site": "A"}`,
+		Breakpoints: []Breakpoint{{Line: 8}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	paused := nextEvent(t, events, "paused")
+	if paused.Line != 8 || paused.Reason != "breakpoint" {
+		t.Fatal("unexpected pause", paused)
+	}
+	value, ok := variable(paused.Variables, "value")
+	if !ok || value.Ref == 0 {
+		t.Fatal("local value not found", paused.Variables)
+	}
+	if _, ok := variable(paused.Variables, "double"); !ok {
+		t.Fatal("upvalue double not found", paused.Variables)
+	}
+	session.Handle(Command{Cmd: "variables", Ref: value.Ref})
+	fields := nextEvent(t, events, "variables")
+	if site, ok := variable(fields.Variables, "site"); !ok || site.Value != `"A"` {
+		t.Fatal("unexpected fields", fields.Variables)
+	}
+	// 进到 double 里面
+	session.Handle(Command{Cmd: "stepInto"})
+	paused = nextEvent(t, events, "paused")
+	if paused.Line != 3 || len(paused.Stack) != 2 || paused.Stack[0].Name != "double" {
+		t.Fatal("unexpected step into", paused)
+	}
+	session.Handle(Command{Cmd: "stepOut"})
+	paused = nextEvent(t, events, "paused")
+	if paused.Line != 9 {
+		t.Fatal("unexpected step out", paused)
+	}
+	if v, ok := variable(paused.Variables, "t"); !ok || v.Value != "42" {
+		t.Fatal("unexpected local t", paused.Variables)
+	}
+	session.Handle(Command{Cmd: "continue"})
+	if output := nextEvent(t, events, "output"); output.Text != "t  42" {
+		t.Fatal("unexpected output", output)
+	}
+	result := nextEvent(t, events, "terminated").Result
+	if result.Error != "" || result.Output != float64(42) || len(result.Logs) != 1 {
+		t.Fatal("unexpected result", result)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Lua_Debugger_Capture github.com/hootrhino/rhilex/component/luadebugger -v -count=1
+func Test_Lua_Debugger_Capture(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	luaexecutor.InitRuleSandbox(typex.RhilexConfig{RuleExecuteTimeout: 3000})
+	events := make(chan Event, 32)
+	session, err := NewSession(nil, func(e Event) { events <- e })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.Start(debugRule, Command{Capture: true}); err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, events, "capturing")
+	live := typex.NewRule(nil, debugRule.UUID, "", "", "", "",
+		debugRule.Success, `Actions = { function(args) return true, args end }`, debugRule.Failed)
+	live.LuaVM.DoString(live.Actions)
+	live.LuaVM.DoString(live.Success)
+	luaexecutor.RunStreamMessageCallbacks([]typex.Rule{*live},
+		typex.NewStringMessage("SOURCE", `{"temp": 5}`))
+	if captured := nextEvent(t, events, "captured"); captured.Input != `{"temp": 5}` {
+		t.Fatal("unexpected input", captured)
+	}
+	if result := nextEvent(t, events, "terminated").Result; result.Output != float64(10) {
+		t.Fatal("unexpected result", result)
+	}
+}
@@ -16,24 +16,90 @@
 package luaexecutor
 
 import (
+	"slices"
+
 	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/trafficcapture"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/sirupsen/logrus"
 )
 
-// executeRule 执行单个规则
-func executeRule(rule *typex.Rule, callbackArgs lua.LValue) bool {
-	_, errA := ExecuteActions(rule, callbackArgs)
-	if errA != nil {
-		handleError(rule, errA)
+// executeRule 执行单个规则, 执行受预算限制, 连续超时会被挂起
+// 每次执行从规则的虚拟机池里借一个虚拟机, 同一个规则可以被多个资源并发执行
+func executeRule(rule *typex.Rule, msg *typex.Message) bool {
+	captureMessage(rule.UUID, msg)
+	if rule.Pipeline != nil {
+		return executePipeline(rule, msg)
+	}
+	if rule.VMPool == nil {
+		setMessageHops(rule.LuaVM, msg)
+		return executeRuleWithVM(rule, ruleArgs(rule.LuaVM, msg))
+	}
+	LuaVM := rule.VMPool.Acquire()
+	if LuaVM == nil { // 规则已经被删除了
+		return false
+	}
+	defer rule.VMPool.Release(LuaVM)
+	pooled := *rule
+	pooled.LuaVM = LuaVM
+	setMessageHops(LuaVM, msg)
+	return executeRuleWithVM(&pooled, ruleArgs(LuaVM, msg))
+}
+
+// 当前消息的转发次数, stream:Emit 发出去的消息在这个基础上加一
+const __MessageHopsKey = "_RHILEX_MESSAGE_HOPS"
+
+func setMessageHops(L *lua.LState, msg *typex.Message) {
+	L.G.Registry.RawSetString(__MessageHopsKey, lua.LNumber(msg.Hops))
+}
+
+func MessageHops(L *lua.LState) int {
+	return int(lua.LVAsNumber(L.G.Registry.RawGetString(__MessageHopsKey)))
+}
+
+/*
+*
+* 规则的参数: 默认是字符串, 兼容老规则;
+* 规则里声明了 ArgsType = "table" 就直接拿到消息表, 不用再 json:J2T
+*
+ */
+func ruleArgs(L *lua.LState, msg *typex.Message) lua.LValue {
+	if L.GetGlobal("ArgsType").String() == "table" {
+		return msg.ToLValue(L)
+	}
+	return lua.LString(msg.String())
+}
+
+func executeRuleWithVM(rule *typex.Rule, callbackArgs lua.LValue) bool {
+	budget := GetRuleBudget()
+	timeout, err := executeWithBudget(rule, budget, func() error {
+		if _, errA := ExecuteActions(rule, callbackArgs); errA != nil {
+			handleError(rule, errA)
+			return errA
+		}
+		if _, errS := ExecuteSuccess(rule.LuaVM); errS != nil {
+			return errS
+		}
+		return nil
+	})
+	accountRuleExecution(rule, timeout, budget)
+	if err != nil {
+		glogger.GLogger.WithFields(logrus.Fields{
+			"topic": "rule/log/" + rule.UUID,
+		}).Error(err)
 		return false
 	}
+	return true
+}
 
-	_, errS := ExecuteSuccess(rule.LuaVM)
-	if errS != nil {
-		glogger.GLogger.Error(errS)
-		return false // lua 是规则链，有短路原则，中途出错会中断
+// 声明式规则直接执行编译好的流程, 不经过虚拟机
+func executePipeline(rule *typex.Rule, msg *typex.Message) bool {
+	if err := rule.Pipeline.Execute(msg); err != nil {
+		glogger.GLogger.WithFields(logrus.Fields{
+			"topic": "rule/log/" + rule.UUID,
+		}).Error(err)
+		return false
 	}
 	return true
 }
@@ -71,12 +137,33 @@ func handleError(rule *typex.Rule, err error) {
 *
  */
 func RunSourceCallbacks(in *typex.InEnd, callbackArgs string) {
+	RunSourceMessageCallbacks(in, typex.NewStringMessage(in.UUID, callbackArgs), nil)
+}
+
+/*
+*
+* 只执行指定的规则, ruleIds 为空表示执行所有绑定的规则
+*
+ */
+func RunSourceCallbacksToRules(in *typex.InEnd, callbackArgs string, ruleIds []string) {
+	RunSourceMessageCallbacks(in, typex.NewStringMessage(in.UUID, callbackArgs), ruleIds)
+}
+
+/*
+*
+* 执行资源端的规则脚本, 参数是结构化的消息
+*
+ */
+func RunSourceMessageCallbacks(in *typex.InEnd, msg *typex.Message, ruleIds []string) {
+	trafficcapture.Capture(in.UUID, msg)
 	// 执行来自资源的脚本
 	for _, rule := range in.BindRules {
-		if rule.Status == typex.RULE_RUNNING {
-			if !executeRule(&rule, lua.LString(callbackArgs)) {
-				return
-			}
+		if len(ruleIds) > 0 && !slices.Contains(ruleIds, rule.UUID) {
+			continue
+		}
+		// 一个规则失败不影响其他规则
+		if ruleRunnable(&rule) {
+			executeRule(&rule, msg)
 		}
 	}
 }
@@ -87,9 +174,32 @@ func RunSourceCallbacks(in *typex.InEnd, callbackArgs string) {
 *
  */
 func RunDeviceCallbacks(Device *typex.Device, callbackArgs string) {
+	RunDeviceMessageCallbacks(Device, typex.NewStringMessage(Device.UUID, callbackArgs))
+}
+
+/*
+*
+* 执行设备端的规则脚本, 参数是结构化的消息
+*
+ */
+func RunDeviceMessageCallbacks(Device *typex.Device, msg *typex.Message) {
+	trafficcapture.Capture(Device.UUID, msg)
 	for _, rule := range Device.BindRules {
-		if !executeRule(&rule, lua.LString(callbackArgs)) {
-			return
+		if ruleRunnable(&rule) {
+			executeRule(&rule, msg)
+		}
+	}
+}
+
+/*
+*
+* 执行订阅了规则流的规则
+*
+ */
+func RunStreamMessageCallbacks(rules []typex.Rule, msg *typex.Message) {
+	for _, rule := range rules {
+		if ruleRunnable(&rule) {
+			executeRule(&rule, msg)
 		}
 	}
 }
@@ -0,0 +1,96 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luaexecutor
+
+import (
+	"sync"
+	"sync/atomic"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 规则调试器抓取规则的下一条输入, 抓到以后自动取消
+*
+ */
+var __captures = struct {
+	sync.Mutex
+	waiting map[string][]chan *typex.Message
+}{waiting: map[string][]chan *typex.Message{}}
+
+// 没有人在抓的时候不加锁
+var __capturing atomic.Int32
+
+func CaptureMessage(ruleId string) (<-chan *typex.Message, func()) {
+	ch := make(chan *typex.Message, 1)
+	__captures.Lock()
+	__captures.waiting[ruleId] = append(__captures.waiting[ruleId], ch)
+	__capturing.Add(1)
+	__captures.Unlock()
+	cancel := func() {
+		__captures.Lock()
+		defer __captures.Unlock()
+		waiting := __captures.waiting[ruleId]
+		for i, c := range waiting {
+			if c == ch {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				__capturing.Add(-1)
+				break
+			}
+		}
+		if len(waiting) == 0 {
+			delete(__captures.waiting, ruleId)
+		} else {
+			__captures.waiting[ruleId] = waiting
+		}
+	}
+	return ch, cancel
+}
+
+func captureMessage(ruleId string, msg *typex.Message) {
+	if __capturing.Load() == 0 {
+		return
+	}
+	__captures.Lock()
+	waiting, ok := __captures.waiting[ruleId]
+	if ok {
+		delete(__captures.waiting, ruleId)
+		__capturing.Add(-int32(len(waiting)))
+	}
+	__captures.Unlock()
+	for _, ch := range waiting {
+		ch <- msg
+	}
+}
+
+/*
+*
+* 调试执行: 不受预算限制, 断点暂停的时候不能被超时中断; 调用方自己用 Context 停止
+*
+ */
+func ExecuteRuleDebug(rule *typex.Rule, msg *typex.Message) (lua.LValue, error) {
+	setMessageHops(rule.LuaVM, msg)
+	output, err := ExecuteActions(rule, ruleArgs(rule.LuaVM, msg))
+	if err != nil {
+		return lua.LNil, err
+	}
+	if _, err := ExecuteSuccess(rule.LuaVM); err != nil {
+		return output, err
+	}
+	return output, nil
+}
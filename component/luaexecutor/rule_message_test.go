@@ -0,0 +1,163 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luaexecutor
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+type testRegister struct {
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+// go test -timeout 30s -run ^Test_Rule_Message_Args github.com/hootrhino/rhilex/component/luaexecutor -v -count=1
+func Test_Rule_Message_Args(t *testing.T) {
+	__RuleBudget = RuleBudget{Timeout: time.Second}
+	tableRule := newSandboxTestRule(t, `
+ArgsType = "table"
+Actions = { function(args)
+	assert(args.id == "dev1")
+	assert(args.quality == "GOOD")
+	assert(args.payload[1].tag == "t1")
+	assert(args.payload[1].value == "1")
+	return true, args
+end }`)
+	msg := typex.NewMessage("dev1", []testRegister{{Tag: "t1", Value: "1"}})
+	if !executeRule(tableRule, msg) {
+		t.Fatal("table rule failed")
+	}
+	// 老的字符串数据也能给表规则用
+	if !executeRule(tableRule, typex.NewStringMessage("dev1", `[{"tag":"t1","value":"1"}]`)) {
+		t.Fatal("table rule with string message failed")
+	}
+	stringRule := newSandboxTestRule(t, `
+Actions = { function(args)
+	assert(args == '[{"tag":"t1","value":"1"}]')
+	return true, args
+end }`)
+	if !executeRule(stringRule, msg) {
+		t.Fatal("string rule failed")
+	}
+}
+
+type testPoint struct {
+	Name string `json:"name"`
+	Unit string `json:"unit,omitempty"`
+}
+
+type testMeta struct {
+	Name  string `json:"name"` // 和 testPoint.Name 一样深, 都不要
+	Model string `json:"model"`
+}
+
+type testMeter struct {
+	testPoint
+	*testMeta
+	Id        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Remark    string    `json:"remark,omitempty"`
+	Ignored   string    `json:"-"`
+	NoTag     int
+	unexposed int
+}
+
+// 转出来的 Lua 表和 JSON 序列化的结果一样
+func assertSameAsJson(t *testing.T, value any) {
+	L := lua.NewState()
+	defer L.Close()
+	bytes, _ := json.Marshal(value)
+	expect := map[string]any{}
+	json.Unmarshal(bytes, &expect)
+	table, ok := typex.ToLValue(L, value).(*lua.LTable)
+	if !ok {
+		t.Fatal("should be table", value)
+	}
+	got := map[string]any{}
+	table.ForEach(func(k, v lua.LValue) {
+		switch v.Type() {
+		case lua.LTNumber:
+			got[k.String()] = float64(v.(lua.LNumber))
+		case lua.LTNil:
+			got[k.String()] = nil
+		default:
+			got[k.String()] = v.String()
+		}
+	})
+	if fmt.Sprint(got) != fmt.Sprint(expect) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Rule_Message_Struct github.com/hootrhino/rhilex/component/luaexecutor -v -count=1
+func Test_Rule_Message_Struct(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assertSameAsJson(t, testMeter{Id: "m1", Time: ts, NoTag: 1, Ignored: "x", unexposed: 1,
+		testPoint: testPoint{Name: "t1"}})
+	assertSameAsJson(t, testMeter{Id: "m1", Time: ts, Remark: "r",
+		testPoint: testPoint{Name: "t1", Unit: "V"}, testMeta: &testMeta{Name: "n", Model: "x"}})
+	assertSameAsJson(t, &testMeta{Name: "n", Model: "x"})
+}
+
+/*
+*
+* 字符串参数和表参数的对比: 字符串规则自己解析 JSON,
+* 表规则直接拿到转好的表, 每次都是新消息, 包括序列化的开销
+*
+ */
+func BenchmarkRuleArgs(b *testing.B) {
+	__RuleBudget = RuleBudget{}
+	registers := []testRegister{}
+	for i := 0; i < 16; i++ {
+		registers = append(registers, testRegister{Tag: fmt.Sprintf("t%d", i), Value: fmt.Sprint(i)})
+	}
+	for _, c := range []struct {
+		name    string
+		actions string
+	}{
+		{"string", `Actions = { function(args) local t = decode(args) return t[1].tag == "t0", args end }`},
+		{"table", `ArgsType = "table"
+Actions = { function(args) return args.payload[1].tag == "t0", args end }`},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			rule := typex.NewRule(nil, "test", "test", "", "", "",
+				`function Success() end`, c.actions, `function Failed(error) end`)
+			// 和 rhilexlib 的 json:J2T 一样, 解析以后转成表
+			rule.LuaVM.SetGlobal("decode", rule.LuaVM.NewFunction(func(L *lua.LState) int {
+				var value any
+				json.Unmarshal([]byte(L.ToString(1)), &value)
+				L.Push(typex.ToLValue(L, value))
+				return 1
+			}))
+			if err := rule.LuaVM.DoString(rule.Actions + "\n" + rule.Success); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if !executeRule(rule, typex.NewMessage("dev1", registers)) {
+					b.Fatal("rule failed")
+				}
+			}
+		})
+	}
+}
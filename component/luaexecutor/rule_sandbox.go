@@ -0,0 +1,181 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luaexecutor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrInstructionLimit = errors.New("rule instruction limit exceeded")
+
+/*
+*
+* 规则单次执行的预算
+*
+ */
+type RuleBudget struct {
+	Timeout          time.Duration // 单次执行超时
+	MaxInstructions  int64         // 单次执行最多的指令数, 0 不限制
+	SuspendThreshold int           // 连续超时多少次以后挂起规则, 0 不挂起
+}
+
+var __RuleBudget = RuleBudget{
+	Timeout:          5 * time.Second,
+	SuspendThreshold: 3,
+}
+
+func InitRuleSandbox(config typex.RhilexConfig) {
+	__RuleBudget = RuleBudget{
+		Timeout:          time.Duration(config.RuleExecuteTimeout) * time.Millisecond,
+		MaxInstructions:  int64(config.RuleMaxInstructions),
+		SuspendThreshold: config.RuleSuspendThreshold,
+	}
+}
+
+func GetRuleBudget() RuleBudget {
+	return __RuleBudget
+}
+
+/*
+*
+* 带指令计数的 Context: gopher-lua 每执行一条指令都会检查一次 Done(),
+* 所以在 Done() 里计数就能限制指令数
+*
+ */
+type instructionBudgetContext struct {
+	context.Context
+	limit    int64
+	count    atomic.Int64
+	exceeded atomic.Bool
+}
+
+var __closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+func (c *instructionBudgetContext) Done() <-chan struct{} {
+	if c.limit > 0 && c.count.Add(1) > c.limit {
+		c.exceeded.Store(true)
+		return __closedChan
+	}
+	return c.Context.Done()
+}
+
+func (c *instructionBudgetContext) Err() error {
+	if c.exceeded.Load() {
+		return ErrInstructionLimit
+	}
+	return c.Context.Err()
+}
+
+// 限制指令数的 Context, 小程序的配额也用这个; 返回的函数检查是否超过了限制
+func WithInstructionLimit(ctx context.Context, limit int64) (context.Context, func() bool) {
+	budgetCtx := &instructionBudgetContext{Context: ctx, limit: limit}
+	return budgetCtx, budgetCtx.exceeded.Load
+}
+
+/*
+*
+* 在预算内执行规则: 超时或者超过指令数都会中断 Lua 虚拟机
+* 返回的 bool 表示是否是因为超时被中断
+*
+ */
+func executeWithBudget(rule *typex.Rule, budget RuleBudget,
+	f func() error) (bool, error) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if budget.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, budget.Timeout)
+	}
+	defer cancel()
+	budgetCtx := &instructionBudgetContext{Context: ctx, limit: budget.MaxInstructions}
+	top := rule.LuaVM.GetTop()
+	rule.LuaVM.SetContext(budgetCtx)
+	defer rule.LuaVM.RemoveContext()
+	err := f()
+	// 被中断的时候虚拟机不一定会返回错误, 以 Context 的状态为准, 顺便把栈恢复干净
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		rule.LuaVM.SetTop(top)
+		return true, fmt.Errorf("rule execute timeout(%v)", budget.Timeout)
+	}
+	if budgetCtx.exceeded.Load() {
+		rule.LuaVM.SetTop(top)
+		return false, fmt.Errorf("%w(%d)", ErrInstructionLimit, budget.MaxInstructions)
+	}
+	return false, err
+}
+
+/*
+*
+* 执行一次规则, 返回 Actions 的输出; 不计入超时统计, 给规则测试用
+*
+ */
+func ExecuteRuleOnce(rule *typex.Rule, msg *typex.Message) (lua.LValue, error) {
+	if rule.Pipeline != nil {
+		return lua.LNil, rule.Pipeline.Execute(msg)
+	}
+	var output lua.LValue = lua.LNil
+	_, err := executeWithBudget(rule, GetRuleBudget(), func() error {
+		result, errA := ExecuteActions(rule, ruleArgs(rule.LuaVM, msg))
+		if errA != nil {
+			return errA
+		}
+		output = result
+		_, errS := ExecuteSuccess(rule.LuaVM)
+		return errS
+	})
+	return output, err
+}
+
+/*
+*
+* 超时计数, 连续超时达到阈值以后挂起规则
+*
+ */
+func accountRuleExecution(rule *typex.Rule, timeout bool, budget RuleBudget) {
+	if rule.Runtime == nil {
+		return
+	}
+	if !timeout {
+		rule.Runtime.OnFinished()
+		return
+	}
+	reason := fmt.Sprintf("suspended after %d consecutive timeouts, at %s",
+		budget.SuspendThreshold, time.Now().Format(time.RFC3339))
+	if rule.Runtime.OnTimeout(budget.SuspendThreshold, reason) {
+		glogger.GLogger.WithFields(logrus.Fields{
+			"topic": "rule/log/" + rule.UUID,
+		}).Errorf("Rule [%s, %s] %s", rule.UUID, rule.Name, reason)
+	}
+}
+
+// 规则是否可以执行
+func ruleRunnable(rule *typex.Rule) bool {
+	if rule.Status != typex.RULE_RUNNING {
+		return false
+	}
+	return rule.Runtime == nil || !rule.Runtime.Suspended()
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luaexecutor
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/sirupsen/logrus"
+)
+
+func newSandboxTestRule(t *testing.T, actions string) *typex.Rule {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	rule := typex.NewRule(nil, "test", "test", "", "", "",
+		`function Success() end`, actions, `function Failed(error) end`)
+	if err := rule.LuaVM.DoString(rule.Actions + "\n" + rule.Success); err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+// go test -timeout 30s -run ^Test_Rule_Sandbox_Timeout github.com/hootrhino/rhilex/component/luaexecutor -v -count=1
+func Test_Rule_Sandbox_Timeout(t *testing.T) {
+	__RuleBudget = RuleBudget{Timeout: 50 * time.Millisecond, SuspendThreshold: 2}
+	rule := newSandboxTestRule(t, `Actions = { function(args) while true do end return true, args end }`)
+	for i := 0; i < 2; i++ {
+		if executeRule(rule, typex.NewStringMessage("test", "{}")) {
+			t.Fatal("dead loop should be interrupted")
+		}
+	}
+	if !rule.Runtime.Suspended() {
+		t.Fatal("rule should be suspended")
+	}
+	t.Log(rule.Runtime.SuspendReason())
+	if ruleRunnable(rule) {
+		t.Fatal("suspended rule should not be runnable")
+	}
+	rule.Runtime.Resume()
+	if !ruleRunnable(rule) {
+		t.Fatal("resumed rule should be runnable")
+	}
+}
+
+// go test -timeout 30s -run ^Test_Rule_Sandbox_Instructions github.com/hootrhino/rhilex/component/luaexecutor -v -count=1
+func Test_Rule_Sandbox_Instructions(t *testing.T) {
+	rule := newSandboxTestRule(t, `Actions = { function(args) for i = 1, 100000 do end return true, args end }`)
+	budget := RuleBudget{Timeout: time.Second, MaxInstructions: 1000}
+	timeout, err := executeWithBudget(rule, budget, func() error {
+		_, err := ExecuteActions(rule, lua.LString("{}"))
+		return err
+	})
+	if timeout || !errors.Is(err, ErrInstructionLimit) {
+		t.Fatal("instruction limit should be exceeded", timeout, err)
+	}
+	budget.MaxInstructions = 0
+	if _, err := executeWithBudget(rule, budget, func() error {
+		_, err := ExecuteActions(rule, lua.LString("{}"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Rule_VMPool github.com/hootrhino/rhilex/component/luaexecutor -v -count=1
+func Test_Rule_VMPool(t *testing.T) {
+	__RuleBudget = RuleBudget{Timeout: time.Second}
+	actions := `Actions = { function(args) local s = 0 for i = 1, 1000 do s = s + i end return true, args end }`
+	rule := newSandboxTestRule(t, actions)
+	created := atomic.Int32{}
+	rule.VMPool = typex.NewRuleVMPool(rule.LuaVM, 4)
+	rule.VMPool.SetFactory(func() (*lua.LState, error) {
+		created.Add(1)
+		LuaVM := lua.NewState()
+		return LuaVM, LuaVM.DoString(actions + "\n" + rule.Success)
+	})
+	wg := sync.WaitGroup{}
+	failed := atomic.Int32{}
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !executeRule(rule, typex.NewStringMessage("test", "{}")) {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if failed.Load() > 0 {
+		t.Fatal("failed executions:", failed.Load())
+	}
+	if created.Load() > 3 {
+		t.Fatal("pool should not exceed max size:", created.Load())
+	}
+}
+
+// go test -timeout 30s -run ^Test_Rule_VMPoolClose github.com/hootrhino/rhilex/component/luaexecutor -v -count=1
+func Test_Rule_VMPoolClose(t *testing.T) {
+	__RuleBudget = RuleBudget{Timeout: time.Second}
+	rule := newSandboxTestRule(t, `Actions = { function(args) return true, args end }`)
+	rule.VMPool = typex.NewRuleVMPool(rule.LuaVM, 2)
+	rule.VMPool.SetFactory(func() (*lua.LState, error) { return lua.NewState(), nil })
+	first := rule.VMPool.Acquire()
+	second := rule.VMPool.Acquire()
+	// 池子满了, 排队等待的在关闭以后返回 nil
+	waiting := make(chan *lua.LState)
+	go func() { waiting <- rule.VMPool.Acquire() }()
+	rule.VMPool.Release(first)
+	if vm := <-waiting; vm != first {
+		t.Fatal("released vm should be reused")
+	}
+	go func() { waiting <- rule.VMPool.Acquire() }()
+	time.Sleep(10 * time.Millisecond)
+	rule.VMPool.Close()
+	if vm := <-waiting; vm != nil {
+		t.Fatal("acquire should fail after close")
+	}
+	// 借在外面的虚拟机归还的时候关闭
+	rule.VMPool.Release(first)
+	rule.VMPool.Release(second)
+	if !first.IsClosed() || !second.IsClosed() {
+		t.Fatal("vm should be closed after release")
+	}
+	if executeRule(rule, typex.NewStringMessage("test", "{}")) {
+		t.Fatal("removed rule should not execute")
+	}
+	rule.VMPool.Close()
+}
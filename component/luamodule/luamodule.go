@@ -0,0 +1,249 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luamodule
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/interdb"
+)
+
+// 使用模块的脚本类型, 目前只有规则和应用会执行 Lua 脚本
+const (
+	SCOPE_RULE   string = "RULE"
+	SCOPE_APPLET string = "APPLET"
+)
+
+var moduleNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*$`)
+
+// 使用模块的规则/应用
+type Dependent struct {
+	Type string `json:"type"`
+	UUID string `json:"uuid"`
+}
+
+var __ModuleCache = struct {
+	sync.RWMutex
+	sources map[string]string // name@version -> 源码, 最新版本的键是 name
+}{sources: map[string]string{}}
+
+var __Dependents = struct {
+	sync.RWMutex
+	modules map[string]map[Dependent]struct{}
+}{modules: map[string]map[Dependent]struct{}{}}
+
+func ValidateName(name string) error {
+	if !moduleNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid module name: %s", name)
+	}
+	return nil
+}
+
+// require 的参数: name 或者 name@version
+func parseSpec(spec string) (string, int, error) {
+	name, v, found := strings.Cut(spec, "@")
+	if err := ValidateName(name); err != nil {
+		return "", 0, err
+	}
+	if !found {
+		return name, 0, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version <= 0 {
+		return "", 0, fmt.Errorf("invalid module version: %s", spec)
+	}
+	return name, version, nil
+}
+
+/*
+*
+* 获取模块源码, version 为 0 表示最新版本; 版本不可变, 最新版本在更新的时候失效
+*
+ */
+func GetSource(name string, version int) (string, error) {
+	key := name
+	if version > 0 {
+		key = fmt.Sprintf("%s@%d", name, version)
+	}
+	__ModuleCache.RLock()
+	source, ok := __ModuleCache.sources[key]
+	__ModuleCache.RUnlock()
+	if ok {
+		return source, nil
+	}
+	source, err := loadSource(name, version)
+	if err != nil {
+		return "", err
+	}
+	__ModuleCache.Lock()
+	__ModuleCache.sources[key] = source
+	__ModuleCache.Unlock()
+	return source, nil
+}
+
+func loadSource(name string, version int) (string, error) {
+	if !interdb.Initialized() {
+		return "", fmt.Errorf("module not exists")
+	}
+	if version == 0 {
+		m := model.MLuaModule{}
+		if err := interdb.InterDb().Where("name=?", name).First(&m).Error; err != nil {
+			return "", err
+		}
+		return m.Source, nil
+	}
+	m := model.MLuaModuleVersion{}
+	if err := interdb.InterDb().Where("name=? and version=?", name, version).
+		First(&m).Error; err != nil {
+		return "", err
+	}
+	return m.Source, nil
+}
+
+// 直接放进缓存, 命令行测试的时候没有数据库; spec 是 name 或者 name@version
+func Preload(spec, source string) {
+	__ModuleCache.Lock()
+	__ModuleCache.sources[spec] = source
+	__ModuleCache.Unlock()
+}
+
+// 模块更新或者删除以后, 最新版本的缓存失效
+func Invalidate(name string) {
+	__ModuleCache.Lock()
+	delete(__ModuleCache.sources, name)
+	__ModuleCache.Unlock()
+}
+
+/*
+*
+* 给虚拟机装上模块加载器, 排在 preload 后面, 文件加载器前面;
+* 同一个虚拟机只装一次, 加载成功的模块记录为脚本的依赖
+*
+ */
+func Install(L *lua.LState, scope, uuid string) {
+	registry := L.Get(lua.RegistryIndex)
+	if L.GetField(registry, "_RHILEX_MODULES") != lua.LNil {
+		return
+	}
+	loaders, ok := L.GetField(registry, "_LOADERS").(*lua.LTable)
+	if !ok {
+		return
+	}
+	loaders.Insert(2, L.NewFunction(loader(Dependent{Type: scope, UUID: uuid})))
+	L.SetField(registry, "_RHILEX_MODULES", lua.LTrue)
+}
+
+func loader(dependent Dependent) lua.LGFunction {
+	return func(L *lua.LState) int {
+		spec := L.CheckString(1)
+		name, version, err := parseSpec(spec)
+		if err != nil {
+			L.Push(lua.LString(err.Error()))
+			return 1
+		}
+		source, err := GetSource(name, version)
+		if err != nil {
+			L.Push(lua.LString(fmt.Sprintf("no rhilex module '%s': %s", spec, err)))
+			return 1
+		}
+		fn, err := L.Load(strings.NewReader(source), "module:"+spec)
+		if err != nil {
+			L.Push(lua.LString(fmt.Sprintf("load rhilex module '%s' failed: %s", spec, err)))
+			return 1
+		}
+		addDependent(name, dependent)
+		L.Push(fn)
+		return 1
+	}
+}
+
+/*
+*
+* 语法校验的临时虚拟机没有打开标准库, 装一个假的 require:
+* 返回空表, 只检查模块存不存在, 返回的函数给出缺失的模块
+*
+ */
+func InstallVerifier(L *lua.LState) func() error {
+	missing := []string{}
+	L.SetGlobal("require", L.NewFunction(func(L *lua.LState) int {
+		spec := L.CheckString(1)
+		name, version, err := parseSpec(spec)
+		if err == nil {
+			_, err = GetSource(name, version)
+		}
+		if err != nil {
+			missing = append(missing, spec)
+		}
+		L.Push(L.NewTable())
+		return 1
+	}))
+	return func() error {
+		if len(missing) > 0 {
+			return fmt.Errorf("module not found: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+}
+
+func addDependent(name string, dependent Dependent) {
+	if dependent.UUID == "" {
+		return
+	}
+	__Dependents.Lock()
+	defer __Dependents.Unlock()
+	if __Dependents.modules[name] == nil {
+		__Dependents.modules[name] = map[Dependent]struct{}{}
+	}
+	__Dependents.modules[name][dependent] = struct{}{}
+}
+
+/*
+*
+* 使用了模块的规则/应用, 包括通过其他模块间接使用的
+*
+ */
+func Dependents(name string) []Dependent {
+	__Dependents.RLock()
+	defer __Dependents.RUnlock()
+	dependents := []Dependent{}
+	for dependent := range __Dependents.modules[name] {
+		dependents = append(dependents, dependent)
+	}
+	sort.Slice(dependents, func(i, j int) bool {
+		if dependents[i].Type != dependents[j].Type {
+			return dependents[i].Type < dependents[j].Type
+		}
+		return dependents[i].UUID < dependents[j].UUID
+	})
+	return dependents
+}
+
+// 规则/应用被删除或者重新加载之前, 清掉它的依赖记录
+func Forget(scope, uuid string) {
+	dependent := Dependent{Type: scope, UUID: uuid}
+	__Dependents.Lock()
+	defer __Dependents.Unlock()
+	for _, dependents := range __Dependents.modules {
+		delete(dependents, dependent)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luamodule
+
+import (
+	"testing"
+
+	lua "github.com/hootrhino/gopher-lua"
+)
+
+// go test -timeout 30s -run ^Test_Require_Module github.com/hootrhino/rhilex/component/luamodule -v -count=1
+func Test_Require_Module(t *testing.T) {
+	Preload("mathx", `local M = {} function M.double(x) return x * 2 end return M`)
+	Preload("mathx@1", `local M = {} function M.double(x) return x + x + 1 end return M`)
+	Preload("scale", `local mathx = require("mathx") local M = {} function M.scale(x) return mathx.double(x) * 10 end return M`)
+	L := lua.NewState()
+	defer L.Close()
+	Install(L, SCOPE_RULE, "RULE1")
+	Install(L, SCOPE_RULE, "RULE1") // 重复安装不影响
+	err := L.DoString(`
+local mathx = require("mathx")
+local old = require("mathx@1")
+local scale = require("scale")
+a, b, c = mathx.double(2), old.double(2), scale.scale(2)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if L.GetGlobal("a").String() != "4" || L.GetGlobal("b").String() != "5" ||
+		L.GetGlobal("c").String() != "40" {
+		t.Fatal("unexpected module result", L.GetGlobal("a"), L.GetGlobal("b"), L.GetGlobal("c"))
+	}
+	// 间接使用的模块也要记录
+	for _, name := range []string{"mathx", "scale"} {
+		dependents := Dependents(name)
+		if len(dependents) != 1 || dependents[0].UUID != "RULE1" {
+			t.Fatal("dependents not recorded:", name, dependents)
+		}
+	}
+	if err := L.DoString(`require("not_exists")`); err == nil {
+		t.Fatal("require missing module should fail")
+	}
+	Forget(SCOPE_RULE, "RULE1")
+	if len(Dependents("mathx")) != 0 {
+		t.Fatal("dependents should be forgotten")
+	}
+}
+
+// go test -timeout 30s -run ^Test_Module_Verifier github.com/hootrhino/rhilex/component/luamodule -v -count=1
+func Test_Module_Verifier(t *testing.T) {
+	Preload("exists", `return {}`)
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	check := InstallVerifier(L)
+	if err := L.DoString(`local a = require("exists") local b = require("missing@2")`); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(); err == nil {
+		t.Fatal("missing module should be reported")
+	} else {
+		t.Log(err)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/luamodule"
 	core "github.com/hootrhino/rhilex/config"
 	"github.com/hootrhino/rhilex/rhilexlib"
 	"github.com/hootrhino/rhilex/typex"
@@ -39,6 +40,7 @@ func VerifyLuaSyntax(r *typex.Rule) error {
 		RegistryMaxSize:  0,
 		RegistryGrowStep: 0,
 	})
+	checkModules := luamodule.InstallVerifier(tempVm)
 
 	if err := tempVm.DoString(r.Success); err != nil {
 		return err
@@ -76,10 +78,14 @@ func VerifyLuaSyntax(r *typex.Rule) error {
 	} else {
 		return errors.New("'Actions' must be a functions table")
 	}
+	if err := checkModules(); err != nil {
+		return err
+	}
 	// 释放语法验证阶段的临时虚拟机
 	tempVm.Close()
 	tempVm = nil
-	// 交给规则脚本
+	// 交给规则脚本, 脚本里可能 require 用户模块
+	luamodule.Install(r.LuaVM, luamodule.SCOPE_RULE, r.UUID)
 	r.LuaVM.DoString(r.Success)
 	r.LuaVM.DoString(r.Actions)
 	r.LuaVM.DoString(r.Failed)
@@ -95,9 +101,13 @@ func ValidateLuaSyntax(bytes []byte) error {
 		RegistryMaxSize:  0,
 		RegistryGrowStep: 0,
 	})
+	checkModules := luamodule.InstallVerifier(tempVm)
 	if err := tempVm.DoString(string(bytes)); err != nil {
 		return err
 	}
+	if err := checkModules(); err != nil {
+		return err
+	}
 	// 检查函数入口
 	AppMain := tempVm.GetGlobal("Main")
 	if AppMain == nil {
@@ -151,6 +161,7 @@ func AddRuleLibToGroup(rx typex.Rhilex, LState *lua.LState,
 }
 
 func LoadRuleLibGroup(e typex.Rhilex, scope, uuid string, LState *lua.LState) {
+	luamodule.Install(LState, scope, uuid)
 	{
 		Funcs := map[string]func(l *lua.LState) int{
 			"ToHttp":       rhilexlib.DataToHttp(e, uuid),
@@ -162,6 +173,10 @@ func LoadRuleLibGroup(e typex.Rhilex, scope, uuid string, LState *lua.LState) {
 			"ToSemtechUdp": rhilexlib.DataToSemtechUdp(e, uuid),
 			"ToUart":       rhilexlib.DataToUart(e, uuid),
 			"ToGreptimeDB": rhilexlib.DataToGreptimeDB(e),
+			"ToInfluxDB":   rhilexlib.DataToInfluxDB(e),
+			"ToPrometheus": rhilexlib.DataToPrometheus(e),
+			"ToSqlDB":      rhilexlib.DataToSqlDB(e),
+			"ToSparkplug":  rhilexlib.DataToSparkplug(e),
 		}
 		AddRuleLibToGroup(e, LState, "data", Funcs)
 	}
@@ -342,11 +357,21 @@ func LoadRuleLibGroup(e typex.Rhilex, scope, uuid string, LState *lua.LState) {
 		Funcs := map[string]func(l *lua.LState) int{
 			"Save":       rhilexlib.InsertToDataCenterTable(e, uuid),
 			"List":       rhilexlib.QueryDataCenterList(e, uuid),
+			"Query":      rhilexlib.QueryDataCenter(e, uuid),
 			"Last":       rhilexlib.QueryDataCenterLast(e, uuid),
 			"UpdateLast": rhilexlib.UpdateDataCenterLast(e, uuid),
 		}
 		AddRuleLibToGroup(e, LState, "rds", Funcs)
 	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Invoke": rhilexlib.SchemaInvoke(e, uuid),
+			"Emit":   rhilexlib.SchemaEmit(e, uuid),
+			"Write":  rhilexlib.SchemaWrite(e, uuid),
+			"Read":   rhilexlib.SchemaRead(e, uuid),
+		}
+		AddRuleLibToGroup(e, LState, "schema", Funcs)
+	}
 	{
 		Funcs := map[string]func(l *lua.LState) int{
 			"ParseDOxygen": rhilexlib.ApureParseOxygen(e),
@@ -414,6 +439,36 @@ func LoadRuleLibGroup(e typex.Rhilex, scope, uuid string, LState *lua.LState) {
 		}
 		AddRuleLibToGroup(e, LState, "string", Funcs)
 	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Define": rhilexlib.WindowDefine(e, uuid),
+			"Add":    rhilexlib.WindowAdd(e, uuid),
+			"Push":   rhilexlib.WindowPush(e, uuid),
+			"Tick":   rhilexlib.WindowTick(e, uuid),
+			"Flush":  rhilexlib.WindowFlush(e, uuid),
+			"Peek":   rhilexlib.WindowPeek(e, uuid),
+			"Reset":  rhilexlib.WindowReset(e, uuid),
+			"Stats":  rhilexlib.WindowStats(e, uuid),
+		}
+		AddRuleLibToGroup(e, LState, "window", Funcs)
+	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Emit": rhilexlib.StreamEmit(e, uuid),
+		}
+		AddRuleLibToGroup(e, LState, "stream", Funcs)
+	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Send": rhilexlib.MailboxSend(e, uuid),
+		}
+		// 只有小程序有自己的邮箱, 规则收信会阻塞, 所以规则只能发信;
+		// 小程序给规则发数据用 stream:Emit
+		if scope == "APPLET" {
+			Funcs["Receive"] = rhilexlib.MailboxReceive(e, uuid)
+		}
+		AddRuleLibToGroup(e, LState, "mailbox", Funcs)
+	}
 }
 
 /*
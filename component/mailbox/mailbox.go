@@ -0,0 +1,128 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mailbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 默认邮箱容量
+const DefaultSize = 64
+
+/*
+*
+* 一封信: 发件人是小程序或者规则的 UUID, 内容是 JSON 能表示的值
+*
+ */
+type Mail struct {
+	From string `json:"from"`
+	Ts   int64  `json:"ts"` // 毫秒
+	Data any    `json:"data"`
+}
+
+type Stats struct {
+	Pending  int `json:"pending"`
+	Capacity int `json:"capacity"`
+}
+
+var __Mailboxes = struct {
+	sync.RWMutex
+	boxes map[string]chan Mail
+}{boxes: map[string]chan Mail{}}
+
+/*
+*
+* 创建邮箱, 已经存在并且容量一样的话保留里面的信
+*
+ */
+func Register(owner string, size int) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	__Mailboxes.Lock()
+	defer __Mailboxes.Unlock()
+	if box, ok := __Mailboxes.boxes[owner]; ok && cap(box) == size {
+		return
+	}
+	__Mailboxes.boxes[owner] = make(chan Mail, size)
+}
+
+func Unregister(owner string) {
+	__Mailboxes.Lock()
+	defer __Mailboxes.Unlock()
+	delete(__Mailboxes.boxes, owner)
+}
+
+/*
+*
+* 发信, 不阻塞: 邮箱满了直接返回错误, 不能拖慢发信的规则
+*
+ */
+func Send(to, from string, data any) error {
+	__Mailboxes.RLock()
+	box, ok := __Mailboxes.boxes[to]
+	__Mailboxes.RUnlock()
+	if !ok {
+		return fmt.Errorf("mailbox not exists: %s", to)
+	}
+	select {
+	case box <- Mail{From: from, Ts: time.Now().UnixMilli(), Data: data}:
+		return nil
+	default:
+		return fmt.Errorf("mailbox is full: %s", to)
+	}
+}
+
+/*
+*
+* 收信, timeout 为 0 一直等; 超时返回 false, ctx 结束返回错误
+*
+ */
+func Receive(ctx context.Context, owner string, timeout time.Duration) (Mail, bool, error) {
+	__Mailboxes.RLock()
+	box, ok := __Mailboxes.boxes[owner]
+	__Mailboxes.RUnlock()
+	if !ok {
+		return Mail{}, false, fmt.Errorf("mailbox not exists: %s", owner)
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case mail := <-box:
+		return mail, true, nil
+	case <-expired:
+		return Mail{}, false, nil
+	case <-ctx.Done():
+		return Mail{}, false, ctx.Err()
+	}
+}
+
+func GetStats(owner string) (Stats, bool) {
+	__Mailboxes.RLock()
+	defer __Mailboxes.RUnlock()
+	box, ok := __Mailboxes.boxes[owner]
+	if !ok {
+		return Stats{}, false
+	}
+	return Stats{Pending: len(box), Capacity: cap(box)}, true
+}
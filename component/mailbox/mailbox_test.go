@@ -0,0 +1,65 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mailbox
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_Mailbox_SendReceive(t *testing.T) {
+	Register("APP1", 2)
+	defer Unregister("APP1")
+	if err := Send("APP1", "RULE1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Send("APP1", "RULE1", map[string]any{"temp": 1}); err != nil {
+		t.Fatal(err)
+	}
+	// 满了不阻塞
+	if err := Send("APP1", "RULE1", "c"); err == nil {
+		t.Fatal("expect mailbox full")
+	}
+	if stats, _ := GetStats("APP1"); stats.Pending != 2 || stats.Capacity != 2 {
+		t.Fatal("unexpected stats:", stats)
+	}
+	mail, ok, err := Receive(context.Background(), "APP1", time.Second)
+	if err != nil || !ok || mail.From != "RULE1" || mail.Data != "a" {
+		t.Fatal("unexpected mail:", mail, ok, err)
+	}
+	// 同样的容量重新注册保留里面的信
+	Register("APP1", 2)
+	if stats, _ := GetStats("APP1"); stats.Pending != 1 {
+		t.Fatal("mail lost after register:", stats)
+	}
+}
+
+func Test_Mailbox_ReceiveTimeout(t *testing.T) {
+	Register("APP2", 0)
+	defer Unregister("APP2")
+	if _, ok, err := Receive(context.Background(), "APP2", 10*time.Millisecond); ok || err != nil {
+		t.Fatal("expect timeout:", ok, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := Receive(ctx, "APP2", 0); err == nil {
+		t.Fatal("expect context error")
+	}
+	if err := Send("NOT_EXISTS", "RULE1", 1); err == nil {
+		t.Fatal("expect mailbox not exists")
+	}
+}
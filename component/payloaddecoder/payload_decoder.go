@@ -0,0 +1,199 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package payloaddecoder
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/hootrhino/rhilex/component/sparkplug"
+	"github.com/hootrhino/rhilex/utils"
+	"github.com/itchyny/gojq"
+)
+
+// 解码器类型
+const (
+	DECODER_RAW         string = "RAW"         // 原样转成Base64
+	DECODER_JSON        string = "JSON"        // 解析成JSON
+	DECODER_JSONPATH    string = "JSONPATH"    // 按照JQ表达式提取字段
+	DECODER_SPARKPLUG_B string = "SPARKPLUG_B" // Sparkplug B protobuf
+	DECODER_CBOR        string = "CBOR"        // CBOR
+	DECODER_BINPARSER   string = "BINPARSER"   // 二进制表达式, 见 utils/binparser.md
+)
+
+/*
+*
+* 解码器配置
+*
+ */
+type DecoderConfig struct {
+	Decoder    string            `json:"decoder" validate:"required" title:"解码器"`
+	JsonPaths  map[string]string `json:"jsonPaths" title:"字段提取"`    // JSONPATH: 输出字段 -> JQ表达式, 比如 {"temp": ".data.sensors[0].t"}
+	Expression string            `json:"expression" title:"二进制表达式"` // BINPARSER: ID:32:int:BE; Name:40:string:BE
+	HexPayload bool              `json:"hexPayload" title:"HEX文本"`  // BINPARSER: 报文是HEX字符串
+}
+
+/*
+*
+* 把原始报文解码成一个可以直接转成JSON的值
+*
+ */
+type PayloadDecoder interface {
+	Decode(payload []byte) (any, error)
+}
+
+func NewPayloadDecoder(config DecoderConfig) (PayloadDecoder, error) {
+	switch config.Decoder {
+	case DECODER_RAW, "":
+		return rawDecoder{}, nil
+	case DECODER_JSON:
+		return jsonDecoder{}, nil
+	case DECODER_JSONPATH:
+		if len(config.JsonPaths) == 0 {
+			return nil, fmt.Errorf("jsonPaths can not be empty")
+		}
+		queries := map[string]*gojq.Query{}
+		for field, path := range config.JsonPaths {
+			query, err := gojq.Parse(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid json path %s: %w", path, err)
+			}
+			queries[field] = query
+		}
+		return jsonPathDecoder{queries: queries}, nil
+	case DECODER_SPARKPLUG_B:
+		return sparkplugDecoder{}, nil
+	case DECODER_CBOR:
+		return cborDecoder{}, nil
+	case DECODER_BINPARSER:
+		if config.Expression == "" {
+			return nil, fmt.Errorf("binparser expression can not be empty")
+		}
+		return binParserDecoder{expression: config.Expression, hexPayload: config.HexPayload}, nil
+	}
+	return nil, fmt.Errorf("unsupported decoder: %s", config.Decoder)
+}
+
+type rawDecoder struct{}
+
+func (rawDecoder) Decode(payload []byte) (any, error) {
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+type jsonDecoder struct{}
+
+func (jsonDecoder) Decode(payload []byte) (any, error) {
+	var value any
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+type jsonPathDecoder struct {
+	queries map[string]*gojq.Query
+}
+
+func (d jsonPathDecoder) Decode(payload []byte) (any, error) {
+	var value any
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return nil, err
+	}
+	result := map[string]any{}
+	for field, query := range d.queries {
+		iter := query.Run(value)
+		v, ok := iter.Next()
+		if !ok {
+			result[field] = nil
+			continue
+		}
+		if err, isErr := v.(error); isErr {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+		result[field] = v
+	}
+	return result, nil
+}
+
+type sparkplugDecoder struct{}
+
+func (sparkplugDecoder) Decode(payload []byte) (any, error) {
+	p, err := sparkplug.Unmarshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return p.ToMap(), nil
+}
+
+type cborDecoder struct{}
+
+func (cborDecoder) Decode(payload []byte) (any, error) {
+	var value any
+	if err := cbor.Unmarshal(payload, &value); err != nil {
+		return nil, err
+	}
+	return normalize(value), nil
+}
+
+type binParserDecoder struct {
+	expression string
+	hexPayload bool
+}
+
+func (d binParserDecoder) Decode(payload []byte) (any, error) {
+	data := payload
+	if d.hexPayload {
+		bytes, err := hex.DecodeString(strings.TrimSpace(string(payload)))
+		if err != nil {
+			return nil, err
+		}
+		data = bytes
+	}
+	parsed, err := utils.ParseBinary(d.expression, data)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any(parsed), nil
+}
+
+// CBOR 里面的键不一定是字符串, 统一转成字符串方便序列化成JSON
+func normalize(value any) any {
+	switch T := value.(type) {
+	case map[any]any:
+		m := map[string]any{}
+		for k, v := range T {
+			m[fmt.Sprintf("%v", k)] = normalize(v)
+		}
+		return m
+	case map[string]any:
+		for k, v := range T {
+			T[k] = normalize(v)
+		}
+		return T
+	case []any:
+		for i, v := range T {
+			T[i] = normalize(v)
+		}
+		return T
+	case []byte:
+		return base64.StdEncoding.EncodeToString(T)
+	}
+	return value
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package payloaddecoder
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/hootrhino/rhilex/component/sparkplug"
+)
+
+func decode(t *testing.T, config DecoderConfig, payload []byte) any {
+	decoder, err := NewPayloadDecoder(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := decoder.Decode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 解码结果必须能直接转成JSON
+	if _, err := json.Marshal(value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// go test -timeout 30s -run ^Test_PayloadDecoder github.com/hootrhino/rhilex/component/payloaddecoder -v -count=1
+func Test_PayloadDecoder(t *testing.T) {
+	if v := decode(t, DecoderConfig{Decoder: DECODER_RAW}, []byte{0x01, 0xff}); v != "Af8=" {
+		t.Fatal("unexpected raw", v)
+	}
+	if v := decode(t, DecoderConfig{}, []byte("a")); v != "YQ==" {
+		t.Fatal("empty decoder should be raw", v)
+	}
+	v := decode(t, DecoderConfig{Decoder: DECODER_JSON}, []byte(`{"temp":23.5,"tags":["a"]}`))
+	if !reflect.DeepEqual(v, map[string]any{"temp": 23.5, "tags": []any{"a"}}) {
+		t.Fatal("unexpected json", v)
+	}
+	v = decode(t, DecoderConfig{Decoder: DECODER_JSONPATH, JsonPaths: map[string]string{
+		"temp": ".data.sensors[0].t",
+		"name": ".name",
+		"none": ".nope.nope",
+	}}, []byte(`{"name":"d1","data":{"sensors":[{"t":21},{"t":22}]}}`))
+	if !reflect.DeepEqual(v, map[string]any{"temp": 21.0, "name": "d1", "none": nil}) {
+		t.Fatal("unexpected jsonpath", v)
+	}
+	payload, _ := cbor.Marshal(map[any]any{"temp": 23.5, 1: []byte{0x01}, "list": []any{map[any]any{2: "x"}}})
+	v = decode(t, DecoderConfig{Decoder: DECODER_CBOR}, payload)
+	if !reflect.DeepEqual(v, map[string]any{"temp": 23.5, "1": "AQ==", "list": []any{map[string]any{"2": "x"}}}) {
+		t.Fatal("unexpected cbor", v)
+	}
+	expression := "ID:32:int:BE; Voltage:16:int:LE"
+	v = decode(t, DecoderConfig{Decoder: DECODER_BINPARSER, Expression: expression},
+		[]byte{0x00, 0x00, 0x00, 0x2a, 0xdc, 0x00})
+	hexV := decode(t, DecoderConfig{Decoder: DECODER_BINPARSER, Expression: expression, HexPayload: true},
+		[]byte(" 0000002adc00\n"))
+	if m, ok := v.(map[string]any); !ok || !reflect.DeepEqual(v, hexV) || len(m) != 2 {
+		t.Fatal("unexpected binparser", v, hexV)
+	}
+	sp := sparkplug.Payload{Timestamp: 1700000000000, Metrics: []sparkplug.Metric{
+		{Name: "temp", DataType: sparkplug.Double, Value: 23.5},
+	}}
+	if m, ok := decode(t, DecoderConfig{Decoder: DECODER_SPARKPLUG_B}, sp.Marshal()).(map[string]any); !ok || len(m) == 0 {
+		t.Fatal("unexpected sparkplug", m)
+	}
+	// 配置错误
+	for _, bad := range []DecoderConfig{
+		{Decoder: "XML"},
+		{Decoder: DECODER_JSONPATH},
+		{Decoder: DECODER_JSONPATH, JsonPaths: map[string]string{"a": ".["}},
+		{Decoder: DECODER_BINPARSER},
+	} {
+		if _, err := NewPayloadDecoder(bad); err == nil {
+			t.Fatal("should fail:", bad)
+		}
+	}
+	// 报文错误
+	for _, bad := range []struct {
+		config  DecoderConfig
+		payload []byte
+	}{
+		{DecoderConfig{Decoder: DECODER_JSON}, []byte(`{"a":`)},
+		{DecoderConfig{Decoder: DECODER_JSONPATH, JsonPaths: map[string]string{"a": ".a"}}, []byte(`nope`)},
+		{DecoderConfig{Decoder: DECODER_JSONPATH, JsonPaths: map[string]string{"a": ".a.b"}}, []byte(`{"a":1}`)},
+		{DecoderConfig{Decoder: DECODER_CBOR}, []byte{0xff, 0xff}},
+		{DecoderConfig{Decoder: DECODER_BINPARSER, Expression: expression, HexPayload: true}, []byte("zz")},
+		{DecoderConfig{Decoder: DECODER_SPARKPLUG_B}, []byte{0xff, 0xff, 0xff}},
+	} {
+		decoder, err := NewPayloadDecoder(bad.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decoder.Decode(bad.payload); err == nil {
+			t.Fatal("should fail:", bad.config.Decoder, string(bad.payload))
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rulestream
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// 一条消息最多被转发几次, 防止动态流名字绕过加载时的环检测
+const MaxHops = 16
+
+var streamNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-.]+$`)
+
+// 扫描 Lua 里的 stream:Emit("name", ...) 字面量
+var emitRegexp = regexp.MustCompile(`stream\s*:\s*Emit\s*\(\s*["']([^"']+)["']`)
+
+/*
+*
+* 规则流: 规则把结果发布到命名的流上, 订阅了这个流的规则把它当成输入;
+* 规则和流组成一个有向图, 加载规则的时候检查环
+*
+ */
+var __Streams = struct {
+	sync.RWMutex
+	rules  map[string]*typex.Rule // 规则 ID -> 订阅了流的规则
+	inputs map[string]string      // 规则 ID -> 订阅的流
+	emits  map[string][]string    // 规则 ID -> 发布的流
+}{
+	rules:  map[string]*typex.Rule{},
+	inputs: map[string]string{},
+	emits:  map[string][]string{},
+}
+
+type streamData struct {
+	stream string
+	msg    *typex.Message
+}
+
+var __Queue []chan streamData
+var __Cancel context.CancelFunc
+
+func InitRuleStream(config typex.RhilexConfig) {
+	workers := config.StreamQueueWorkers
+	if workers <= 0 {
+		workers = 4
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	__Cancel = cancel
+	__Queue = make([]chan streamData, workers)
+	for i := range __Queue {
+		__Queue[i] = make(chan streamData, config.MaxQueueSize)
+		go func(ctx context.Context, qc chan streamData) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case data := <-qc:
+					dispatch(data)
+				}
+			}
+		}(ctx, __Queue[i])
+	}
+}
+
+func Stop() {
+	if __Cancel != nil {
+		__Cancel()
+	}
+}
+
+func ValidateName(name string) error {
+	if !streamNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid stream name: %s", name)
+	}
+	return nil
+}
+
+/*
+*
+* 规则会发布到哪些流: 声明式规则看输出定义, Lua 规则扫描 stream:Emit 的字面量,
+* 动态拼出来的流名字扫描不到, 运行时靠 MaxHops 兜底
+*
+ */
+func Emits(r *typex.Rule) []string {
+	if p, ok := r.Pipeline.(interface{ Streams() []string }); ok {
+		return p.Streams()
+	}
+	return ScanLua(r.Actions)
+}
+
+func ScanLua(source string) []string {
+	streams := []string{}
+	seen := map[string]bool{}
+	for _, match := range emitRegexp.FindAllStringSubmatch(source, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			streams = append(streams, match[1])
+		}
+	}
+	return streams
+}
+
+/*
+*
+* 检查规则加入以后会不会成环: 规则订阅 input, 发布 emits,
+* 只要某个 emit 沿着已有的边能走回 input 就成环; 规则自己原来的边不算
+*
+ */
+func Check(ruleId, input string, emits []string) error {
+	if input == "" {
+		return nil
+	}
+	if err := ValidateName(input); err != nil {
+		return err
+	}
+	__Streams.RLock()
+	defer __Streams.RUnlock()
+	edges := map[string][]string{}
+	for id, in := range __Streams.inputs {
+		if id == ruleId {
+			continue
+		}
+		edges[in] = append(edges[in], __Streams.emits[id]...)
+	}
+	for _, emit := range emits {
+		if path, ok := findPath(edges, emit, input, map[string]bool{}); ok {
+			return fmt.Errorf("rule stream cycle detected: %s -> %v", input, path)
+		}
+	}
+	return nil
+}
+
+func findPath(edges map[string][]string, from, to string, visited map[string]bool) ([]string, bool) {
+	if from == to {
+		return []string{from}, true
+	}
+	if visited[from] {
+		return nil, false
+	}
+	visited[from] = true
+	for _, next := range edges[from] {
+		if path, ok := findPath(edges, next, to, visited); ok {
+			return append([]string{from}, path...), true
+		}
+	}
+	return nil, false
+}
+
+// 加载规则: 检查环, 记录规则发布的流, 订阅了流的话加入订阅表
+func Bind(r *typex.Rule) error {
+	emits := Emits(r)
+	if err := Check(r.UUID, r.FromStream, emits); err != nil {
+		return err
+	}
+	__Streams.Lock()
+	defer __Streams.Unlock()
+	delete(__Streams.rules, r.UUID)
+	delete(__Streams.inputs, r.UUID)
+	delete(__Streams.emits, r.UUID)
+	if len(emits) > 0 {
+		__Streams.emits[r.UUID] = emits
+	}
+	if r.FromStream != "" {
+		__Streams.rules[r.UUID] = r
+		__Streams.inputs[r.UUID] = r.FromStream
+	}
+	return nil
+}
+
+func Unbind(ruleId string) {
+	__Streams.Lock()
+	defer __Streams.Unlock()
+	delete(__Streams.rules, ruleId)
+	delete(__Streams.inputs, ruleId)
+	delete(__Streams.emits, ruleId)
+}
+
+/*
+*
+* 发布到流: 按流的名字哈希到固定的通道, 同一个流的消息有序
+*
+ */
+func Emit(stream string, msg *typex.Message) error {
+	if err := ValidateName(stream); err != nil {
+		return err
+	}
+	if msg.Hops > MaxHops {
+		return fmt.Errorf("stream %s dropped message after %d hops, maybe a cycle", stream, msg.Hops)
+	}
+	if len(__Queue) == 0 {
+		return fmt.Errorf("rule stream not initialized")
+	}
+	h := fnv.New32a()
+	h.Write([]byte(stream))
+	select {
+	case __Queue[h.Sum32()%uint32(len(__Queue))] <- streamData{stream: stream, msg: msg}:
+		return nil
+	default:
+		return fmt.Errorf("stream %s queue is full", stream)
+	}
+}
+
+func dispatch(data streamData) {
+	rules := Subscribers(data.stream)
+	if len(rules) == 0 {
+		glogger.GLogger.Debugf("Stream %s has no subscriber", data.stream)
+		return
+	}
+	luaexecutor.RunStreamMessageCallbacks(rules, data.msg)
+}
+
+func Subscribers(stream string) []typex.Rule {
+	__Streams.RLock()
+	defer __Streams.RUnlock()
+	rules := []typex.Rule{}
+	for id, in := range __Streams.inputs {
+		if in == stream {
+			rules = append(rules, *__Streams.rules[id])
+		}
+	}
+	return rules
+}
+
+// 流的拓扑, 给前端画图用
+type StreamTopology struct {
+	Name        string   `json:"name"`
+	Publishers  []string `json:"publishers"`  // 发布到这个流的规则
+	Subscribers []string `json:"subscribers"` // 订阅这个流的规则
+}
+
+func Topology() []StreamTopology {
+	__Streams.RLock()
+	defer __Streams.RUnlock()
+	streams := map[string]*StreamTopology{}
+	get := func(name string) *StreamTopology {
+		if s, ok := streams[name]; ok {
+			return s
+		}
+		s := &StreamTopology{Name: name, Publishers: []string{}, Subscribers: []string{}}
+		streams[name] = s
+		return s
+	}
+	for id, emits := range __Streams.emits {
+		for _, emit := range emits {
+			s := get(emit)
+			s.Publishers = append(s.Publishers, id)
+		}
+	}
+	for id, in := range __Streams.inputs {
+		s := get(in)
+		s.Subscribers = append(s.Subscribers, id)
+	}
+	topology := []StreamTopology{}
+	for _, s := range streams {
+		sort.Strings(s.Publishers)
+		sort.Strings(s.Subscribers)
+		topology = append(topology, *s)
+	}
+	sort.Slice(topology, func(i, j int) bool { return topology[i].Name < topology[j].Name })
+	return topology
+}
@@ -0,0 +1,103 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rulestream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+type fakePipeline struct {
+	streams []string
+	got     chan *typex.Message
+}
+
+func (p *fakePipeline) Execute(msg *typex.Message) error {
+	p.got <- msg
+	return nil
+}
+
+func (p *fakePipeline) Streams() []string {
+	return p.streams
+}
+
+func streamRule(uuid, input string, emits ...string) *typex.Rule {
+	return &typex.Rule{
+		UUID:       uuid,
+		Status:     typex.RULE_RUNNING,
+		FromStream: input,
+		Pipeline:   &fakePipeline{streams: emits, got: make(chan *typex.Message, 1)},
+	}
+}
+
+// go test -timeout 30s -run ^Test_Rule_Stream github.com/hootrhino/rhilex/component/rulestream -v -count=1
+func Test_Rule_Stream(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	InitRuleStream(typex.RhilexConfig{MaxQueueSize: 16, StreamQueueWorkers: 2})
+	defer Stop()
+	emits := ScanLua(`local err = stream:Emit("enriched", t) stream : Emit ('enriched', t) stream:Emit(name, t)`)
+	if len(emits) != 1 || emits[0] != "enriched" {
+		t.Fatal("unexpected emits", emits)
+	}
+	// raw -> enrich -> enriched -> aggregate -> summary
+	enrich := streamRule("ENRICH", "raw", "enriched")
+	aggregate := streamRule("AGGREGATE", "enriched", "summary")
+	if err := Bind(enrich); err != nil {
+		t.Fatal(err)
+	}
+	if err := Bind(aggregate); err != nil {
+		t.Fatal(err)
+	}
+	defer Unbind("ENRICH")
+	defer Unbind("AGGREGATE")
+	if err := Bind(streamRule("LOOP", "summary", "raw")); err == nil {
+		t.Fatal("cycle should be detected")
+	}
+	if err := Bind(streamRule("SELF", "raw", "raw")); err == nil {
+		t.Fatal("self loop should be detected")
+	}
+	// 重新加载自己不算环
+	if err := Bind(streamRule("AGGREGATE", "enriched", "summary")); err != nil {
+		t.Fatal(err)
+	}
+	aggregate = streamRule("AGGREGATE", "enriched", "summary")
+	Bind(aggregate)
+	topology := Topology()
+	if len(topology) != 3 || topology[0].Name != "enriched" ||
+		topology[0].Publishers[0] != "ENRICH" || topology[0].Subscribers[0] != "AGGREGATE" {
+		t.Fatal("unexpected topology", topology)
+	}
+	msg := typex.NewMessage("ENRICH", map[string]any{"temp": 1})
+	msg.Hops = 1
+	if err := Emit("enriched", msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-aggregate.Pipeline.(*fakePipeline).got:
+		if got.ResourceId != "ENRICH" || got.Hops != 1 {
+			t.Fatal("unexpected message", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber not called")
+	}
+	msg.Hops = MaxHops + 1
+	if err := Emit("enriched", msg); err == nil {
+		t.Fatal("too many hops should be dropped")
+	}
+}
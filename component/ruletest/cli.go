@@ -0,0 +1,235 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ruletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+/*
+*
+* 从文件加载测试集: 一个 Suite, Suite 数组, 或者导出接口原样返回的 {"data": [...]}
+*
+ */
+func LoadSuites(path string) ([]Suite, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSuites(bytes)
+}
+
+func parseSuites(bytes []byte) ([]Suite, error) {
+	envelope := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bytes, &envelope); err == nil {
+		if data, ok := envelope["data"]; ok {
+			return parseSuites(data)
+		}
+	}
+	suites := []Suite{}
+	if err := json.Unmarshal(bytes, &suites); err == nil {
+		return suites, nil
+	}
+	suite := Suite{}
+	if err := json.Unmarshal(bytes, &suite); err != nil {
+		return nil, err
+	}
+	return []Suite{suite}, nil
+}
+
+/*
+*
+* 从网关的数据库加载所有规则的测试用例, 没有用例的规则跳过
+*
+ */
+func LoadSuitesFromDb(path string) ([]Suite, error) {
+	db, err := gorm.Open(sqlite.Open(path+"?mode=ro"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sqlDb, err := db.DB(); err == nil {
+		defer sqlDb.Close()
+	}
+	type fixtureRow struct {
+		RuleId  string
+		Fixture string
+	}
+	rows := []fixtureRow{}
+	if err := db.Table("m_rule_fixtures").Select("rule_id, fixture").
+		Order("rule_id, id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	fixtures := map[string][]Fixture{}
+	for _, row := range rows {
+		fixture := Fixture{}
+		if err := json.Unmarshal([]byte(row.Fixture), &fixture); err != nil {
+			return nil, fmt.Errorf("invalid fixture of rule %s: %w", row.RuleId, err)
+		}
+		fixtures[row.RuleId] = append(fixtures[row.RuleId], fixture)
+	}
+	rules := []RuleScript{}
+	columns := "uuid, name, actions, success, failed"
+	if db.Migrator().HasColumn("m_rules", "type") {
+		columns += ", type"
+	}
+	if err := db.Table("m_rules").Select(columns).
+		Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	modules, err := loadModulesFromDb(db)
+	if err != nil {
+		return nil, err
+	}
+	suites := []Suite{}
+	for _, rule := range rules {
+		if len(fixtures[rule.UUID]) > 0 {
+			suites = append(suites, Suite{Rule: rule, Fixtures: fixtures[rule.UUID], Modules: modules})
+		}
+	}
+	return suites, nil
+}
+
+/*
+*
+* 从网关的数据库加载一个规则和所有用户模块, 回放抓包用
+*
+ */
+func LoadRuleFromDb(path, ruleId string) (Suite, error) {
+	db, err := gorm.Open(sqlite.Open(path+"?mode=ro"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return Suite{}, err
+	}
+	if sqlDb, err := db.DB(); err == nil {
+		defer sqlDb.Close()
+	}
+	rules := []RuleScript{}
+	columns := "uuid, name, actions, success, failed"
+	if db.Migrator().HasColumn("m_rules", "type") {
+		columns += ", type"
+	}
+	if err := db.Table("m_rules").Select(columns).
+		Where("uuid = ?", ruleId).Find(&rules).Error; err != nil {
+		return Suite{}, err
+	}
+	if len(rules) == 0 {
+		return Suite{}, fmt.Errorf("rule not exists: %s", ruleId)
+	}
+	modules, err := loadModulesFromDb(db)
+	if err != nil {
+		return Suite{}, err
+	}
+	return Suite{Rule: rules[0], Fixtures: []Fixture{}, Modules: modules}, nil
+}
+
+/*
+*
+* 新版本的规则: .json 文件里的 actions/success/failed 覆盖原来的, 其他文件当成 Actions 脚本
+*
+ */
+func LoadCandidate(base RuleScript, path string) (*RuleScript, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	candidate := base
+	if !strings.HasSuffix(path, ".json") {
+		candidate.Actions = string(bytes)
+		return &candidate, nil
+	}
+	script := RuleScript{}
+	if err := json.Unmarshal(bytes, &script); err != nil {
+		return nil, err
+	}
+	if script.Actions != "" {
+		candidate.Actions = script.Actions
+	}
+	if script.Success != "" {
+		candidate.Success = script.Success
+	}
+	if script.Failed != "" {
+		candidate.Failed = script.Failed
+	}
+	return &candidate, nil
+}
+
+// 用户模块, 老版本的数据库里没有这两张表
+func loadModulesFromDb(db *gorm.DB) (map[string]string, error) {
+	modules := map[string]string{}
+	if !db.Migrator().HasTable("m_lua_modules") {
+		return modules, nil
+	}
+	type moduleRow struct {
+		Name    string
+		Version int
+		Source  string
+	}
+	latest := []moduleRow{}
+	if err := db.Table("m_lua_modules").Select("name, version, source").Find(&latest).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range latest {
+		modules[row.Name] = row.Source
+	}
+	if !db.Migrator().HasTable("m_lua_module_versions") {
+		return modules, nil
+	}
+	versions := []moduleRow{}
+	if err := db.Table("m_lua_module_versions").Select("name, version, source").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range versions {
+		modules[fmt.Sprintf("%s@%d", row.Name, row.Version)] = row.Source
+	}
+	return modules, nil
+}
+
+/*
+*
+* 输出测试报告, 返回失败的用例数
+*
+ */
+func Report(w io.Writer, results []SuiteResult) int {
+	total, failed := 0, 0
+	for _, suite := range results {
+		fmt.Fprintf(w, "RULE %s (%s)\n", suite.RuleName, suite.RuleId)
+		for _, r := range suite.Results {
+			total++
+			if r.Passed {
+				fmt.Fprintf(w, "  PASS %s (%dms)\n", r.Name, r.Cost)
+				continue
+			}
+			failed++
+			fmt.Fprintf(w, "  FAIL %s (%dms)\n", r.Name, r.Cost)
+			for _, e := range r.Errors {
+				fmt.Fprintf(w, "       %s\n", e)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", total-failed, failed)
+	return failed
+}
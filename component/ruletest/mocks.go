@@ -0,0 +1,238 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ruletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/rhilexlib"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 记录规则执行过程中的外部调用, 替换掉会产生副作用的库函数
+*
+ */
+type recorder struct {
+	offline bool // 命令行里执行, 没有规则引擎
+	mocks   FixtureMocks
+	calls   []FixtureCall
+	kv      map[string]string
+	logs    []string
+	onLog   func(string)
+}
+
+// 写数据中心、物模型和设备的库函数, 和 data:* 一样只记录不执行
+var __recordedWrites = map[string][]lua.LValue{
+	"rds:Save":          {lua.LNil},
+	"rds:UpdateLast":    {lua.LNil},
+	"schema:Invoke":     {lua.LNil, lua.LNil},
+	"schema:Emit":       {lua.LNil},
+	"schema:Write":      {lua.LNil},
+	"modbus:WritePoint": {lua.LNil},
+	"modbus_slaver:F5":  {lua.LNil},
+	"modbus_slaver:F6":  {lua.LNil},
+	"tjchmi:WriteToHmi": {lua.LNil},
+}
+
+/*
+*
+* 没有规则引擎的时候不能执行的库: 读数据中心和物模型, 还有板子上的硬件,
+* 只写库名的是整个库; 用 mocks.returns 模拟以后就能用
+*
+ */
+var __offlineUnsupported = []string{
+	"rds:List", "rds:Query", "rds:Last", "schema:Read",
+	"rhilexg1", "en6400", "haas506ld1", "audio",
+}
+
+func newRecorder(mocks FixtureMocks) *recorder {
+	kv := map[string]string{}
+	for k, v := range mocks.KV {
+		kv[k] = v
+	}
+	return &recorder{mocks: mocks, calls: []FixtureCall{}, kv: kv, logs: []string{}}
+}
+
+func (r *recorder) install(L *lua.LState) {
+	// 数据出口全部模拟, 默认返回 nil 表示成功
+	if data, ok := L.GetGlobal("data").(*lua.LTable); ok {
+		names := []string{}
+		data.ForEach(func(k, _ lua.LValue) {
+			names = append(names, k.String())
+		})
+		for _, name := range names {
+			data.RawSetString(name, L.NewFunction(r.mock("data:"+name, lua.LNil)))
+		}
+	}
+	r.setLib(L, "device", "CtrlDevice", r.mock("device:CtrlDevice", lua.LString(""), lua.LNil))
+	r.setLib(L, "stream", "Emit", r.mock("stream:Emit", lua.LNil))
+	r.setLib(L, "mailbox", "Send", r.mock("mailbox:Send", lua.LNil))
+	r.setLib(L, "http", "Get", r.httpMock("http:Get"))
+	r.setLib(L, "http", "Post", r.httpMock("http:Post"))
+	r.setLib(L, "kv", "VSet", func(L *lua.LState) int {
+		r.kv[L.ToString(2)] = L.ToString(3)
+		return 0
+	})
+	r.setLib(L, "kv", "VSetWithDuration", func(L *lua.LState) int {
+		r.kv[L.ToString(2)] = L.ToString(3)
+		return 0
+	})
+	r.setLib(L, "kv", "VGet", func(L *lua.LState) int {
+		if v, ok := r.kv[L.ToString(2)]; ok {
+			L.Push(lua.LString(v))
+		} else {
+			L.Push(lua.LNil)
+		}
+		return 1
+	})
+	r.setLib(L, "kv", "VDel", func(L *lua.LState) int {
+		delete(r.kv, L.ToString(2))
+		return 0
+	})
+	for name, defaults := range __recordedWrites {
+		lib, fn, _ := strings.Cut(name, ":")
+		r.setLib(L, lib, fn, r.mock(name, defaults...))
+	}
+	if r.offline {
+		r.installOffline(L)
+	}
+	L.SetGlobal("Debug", L.NewFunction(func(L *lua.LState) int {
+		top := L.GetTop()
+		content := make([]string, 0, top)
+		for i := 1; i <= top; i++ {
+			content = append(content, L.ToStringMeta(L.Get(i)).String())
+		}
+		r.logs = append(r.logs, strings.Join(content, "  "))
+		if r.onLog != nil {
+			r.onLog(strings.Join(content, "  "))
+		}
+		return 0
+	}))
+	// 用户指定的返回值, 任何函数都可以模拟
+	for name := range r.mocks.Returns {
+		lib, fn, found := strings.Cut(name, ":")
+		if !found {
+			L.SetGlobal(name, L.NewFunction(r.mock(name)))
+			continue
+		}
+		r.setLib(L, lib, fn, r.mock(name))
+	}
+}
+
+func (r *recorder) installOffline(L *lua.LState) {
+	for _, name := range __offlineUnsupported {
+		lib, fn, found := strings.Cut(name, ":")
+		if found {
+			r.setLib(L, lib, fn, unsupportedOffline(name))
+			continue
+		}
+		table, ok := L.GetGlobal(lib).(*lua.LTable)
+		if !ok {
+			continue
+		}
+		names := []string{}
+		table.ForEach(func(k, _ lua.LValue) {
+			names = append(names, k.String())
+		})
+		for _, fn := range names {
+			table.RawSetString(fn, L.NewFunction(unsupportedOffline(lib+":"+fn)))
+		}
+	}
+}
+
+// RaiseError 不会中断执行, 用 panic, 执行规则的 PCall 会转成错误
+func unsupportedOffline(name string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		panic(fmt.Errorf("%s is unsupported in offline mode, mock it with mocks.returns", name))
+	}
+}
+
+func (r *recorder) setLib(L *lua.LState, lib, name string, f lua.LGFunction) {
+	table, ok := L.GetGlobal(lib).(*lua.LTable)
+	if !ok {
+		table = L.NewTable()
+		L.SetGlobal(lib, table)
+	}
+	table.RawSetString(name, L.NewFunction(f))
+}
+
+// 记录调用, 有模拟的返回值就用模拟的, 否则返回默认值
+func (r *recorder) mock(name string, defaults ...lua.LValue) lua.LGFunction {
+	return func(L *lua.LState) int {
+		r.record(L, name)
+		if values, ok := r.mocks.Returns[name]; ok {
+			for _, v := range values {
+				L.Push(typex.ToLValue(L, v))
+			}
+			return len(values)
+		}
+		for _, v := range defaults {
+			L.Push(v)
+		}
+		return len(defaults)
+	}
+}
+
+func (r *recorder) httpMock(name string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		r.record(L, name)
+		if values, ok := r.mocks.Returns[name]; ok && len(values) > 0 {
+			L.Push(typex.ToLValue(L, values[0]))
+			return 1
+		}
+		L.Push(lua.LString(r.mocks.Http[L.ToString(2)]))
+		return 1
+	}
+}
+
+// 库函数都是冒号调用, 第一个参数是库本身, 不记录
+func (r *recorder) record(L *lua.LState, name string) {
+	start := 1
+	if strings.Contains(name, ":") {
+		start = 2
+	}
+	// 末尾的 nil 对 Lua 函数来说和没传一样, 比如 json:T2J 多返回的 err
+	top := L.GetTop()
+	for top >= start && L.Get(top) == lua.LNil {
+		top--
+	}
+	args := []any{}
+	for i := start; i <= top; i++ {
+		args = append(args, luaToGo(L.Get(i)))
+	}
+	r.calls = append(r.calls, FixtureCall{Func: name, Args: args})
+}
+
+// Lua 的值转成和 JSON 一样的 Go 值, 方便和期望比较
+func luaToGo(value lua.LValue) any {
+	if value == nil || value == lua.LNil {
+		return nil
+	}
+	bytes, err := rhilexlib.EncodeValue(value)
+	if err != nil {
+		return value.String()
+	}
+	var result any
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return value.String()
+	}
+	return result
+}
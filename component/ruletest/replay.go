@@ -0,0 +1,225 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ruletest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/exprrule"
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/luamodule"
+	"github.com/hootrhino/rhilex/component/trafficcapture"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// 报告里最多保留多少条结果
+const MaxReplayResults = 500
+
+/*
+*
+* 把抓包按顺序喂给规则; 传了 candidate 就两个版本一起执行, 报告输出不一样的地方。
+* 每个版本从头到尾用同一个沙箱, kv 之类的状态和线上一样会累积; 外部调用全部被模拟
+*
+ */
+func Replay(ctx context.Context, e typex.Rhilex, base RuleScript, candidate *RuleScript,
+	records []trafficcapture.Record, options ReplayOptions) (ReplayReport, error) {
+	start := time.Now()
+	report := ReplayReport{RuleId: base.UUID, Results: []ReplayRecord{}}
+	baseReplayer, err := newReplayer(e, base, options.Mocks)
+	if err != nil {
+		return report, fmt.Errorf("load rule failed: %w", err)
+	}
+	defer baseReplayer.close()
+	var candidateReplayer replayer
+	if candidate != nil {
+		if candidateReplayer, err = newReplayer(e, *candidate, options.Mocks); err != nil {
+			return report, fmt.Errorf("load candidate rule failed: %w", err)
+		}
+		defer candidateReplayer.close()
+	}
+	for i, record := range records {
+		if err := pace(ctx, start, records[0].Ts, record.Ts, options.Speed); err != nil {
+			return report, err
+		}
+		result := ReplayRecord{Index: i, Ts: record.Ts}
+		msg := record.Message()
+		result.Input = msg.String()
+		result.Base = baseReplayer.run(msg)
+		report.Total++
+		if result.Base.Error != "" {
+			report.Errors++
+		}
+		if candidateReplayer != nil {
+			output := candidateReplayer.run(record.Message())
+			result.Candidate = &output
+			result.Diff = diff(result.Base, output)
+			if len(result.Diff) == 0 {
+				continue
+			}
+			report.Differences++
+		}
+		if len(report.Results) < MaxReplayResults {
+			report.Results = append(report.Results, result)
+		} else {
+			report.Truncated = true
+		}
+	}
+	report.Cost = time.Since(start).Milliseconds()
+	return report, nil
+}
+
+// 命令行用: 先加载测试集里的用户模块
+func ReplaySuite(ctx context.Context, suite Suite, candidate *RuleScript,
+	records []trafficcapture.Record, options ReplayOptions) (ReplayReport, error) {
+	for spec, source := range suite.Modules {
+		luamodule.Preload(spec, source)
+	}
+	return Replay(ctx, nil, suite.Rule, candidate, records, options)
+}
+
+// 按抓包的时间间隔等待
+func pace(ctx context.Context, start time.Time, first, ts int64, speed float64) error {
+	if ctx.Err() != nil {
+		return errors.New("replay canceled")
+	}
+	if speed <= 0 || ts <= first {
+		return nil
+	}
+	due := start.Add(time.Duration(float64(ts-first)/speed) * time.Millisecond)
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return errors.New("replay canceled")
+	}
+}
+
+func diff(base, candidate ReplayOutput) []string {
+	diffs := []string{}
+	if base.Error != candidate.Error {
+		diffs = append(diffs, fmt.Sprintf("error: '%s' -> '%s'", base.Error, candidate.Error))
+	}
+	if !equal(base.Output, candidate.Output) {
+		diffs = append(diffs, fmt.Sprintf("output: %s -> %s", toJson(base.Output), toJson(candidate.Output)))
+	}
+	if !equal(base.Calls, candidate.Calls) {
+		diffs = append(diffs, fmt.Sprintf("calls: %s -> %s", toJson(base.Calls), toJson(candidate.Calls)))
+	}
+	return diffs
+}
+
+type replayer interface {
+	run(msg *typex.Message) ReplayOutput
+	close()
+}
+
+func newReplayer(e typex.Rhilex, script RuleScript, mocks FixtureMocks) (replayer, error) {
+	if script.Type == typex.RULE_TYPE_EXPR {
+		pipeline, err := exprrule.Compile(nil, script.UUID, script.Actions)
+		if err != nil {
+			return nil, err
+		}
+		return &exprReplayer{pipeline: pipeline}, nil
+	}
+	sandbox, err := NewSandbox(e, script, mocks)
+	if err != nil {
+		return nil, err
+	}
+	if err := sandbox.Load(script); err != nil {
+		sandbox.Close()
+		return nil, err
+	}
+	return &luaReplayer{sandbox: sandbox}, nil
+}
+
+type luaReplayer struct {
+	sandbox *Sandbox
+}
+
+func (r *luaReplayer) run(msg *typex.Message) ReplayOutput {
+	r.sandbox.Reset()
+	output, err := luaexecutor.ExecuteRuleOnce(r.sandbox.Rule, msg)
+	result := ReplayOutput{
+		Output: luaToGo(output),
+		Calls:  r.sandbox.Calls(),
+		Logs:   r.sandbox.Logs(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (r *luaReplayer) close() {
+	r.sandbox.Close()
+}
+
+// 声明式规则和测试用例一样, 每个输出目标记录成一次调用
+type exprReplayer struct {
+	pipeline *exprrule.Pipeline
+}
+
+func (r *exprReplayer) run(msg *typex.Message) ReplayOutput {
+	result := ReplayOutput{Calls: []FixtureCall{}}
+	record, pass, err := r.pipeline.Process(msg)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if pass {
+		result.Output = record
+		for _, target := range r.pipeline.Targets() {
+			result.Calls = append(result.Calls, FixtureCall{Func: "output", Args: []any{target, record}})
+		}
+		for _, stream := range r.pipeline.Streams() {
+			result.Calls = append(result.Calls, FixtureCall{Func: "stream:Emit", Args: []any{stream, record}})
+		}
+	}
+	return result
+}
+
+func (r *exprReplayer) close() {}
+
+/*
+*
+* 输出回放报告, 返回输出不一样的条数
+*
+ */
+func ReportReplay(w io.Writer, report ReplayReport) int {
+	fmt.Fprintf(w, "RULE %s: %d records replayed, %d errors, %d differences (%dms)\n",
+		report.RuleId, report.Total, report.Errors, report.Differences, report.Cost)
+	for _, r := range report.Results {
+		if len(r.Diff) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  #%d %s\n", r.Index, time.UnixMilli(r.Ts).Format(time.RFC3339Nano))
+		fmt.Fprintf(w, "       input: %s\n", r.Input)
+		for _, d := range r.Diff {
+			fmt.Fprintf(w, "       %s\n", d)
+		}
+	}
+	if report.Truncated {
+		fmt.Fprintf(w, "  ... only the first %d differences are listed\n", MaxReplayResults)
+	}
+	return report.Differences
+}
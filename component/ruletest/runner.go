@@ -0,0 +1,250 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ruletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/exprrule"
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/luamodule"
+	"github.com/hootrhino/rhilex/component/luaruntime"
+	"github.com/hootrhino/rhilex/component/streamwindow"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 执行一个测试用例: 每个用例用独立的虚拟机, 外部调用全部被模拟
+*
+ */
+func RunFixture(e typex.Rhilex, script RuleScript, fixture Fixture) FixtureResult {
+	start := time.Now()
+	result := FixtureResult{Name: fixture.Name, Errors: []string{}}
+	defer func() {
+		result.Cost = time.Since(start).Milliseconds()
+	}()
+	if script.Type == typex.RULE_TYPE_EXPR {
+		runExprFixture(script, fixture, &result)
+		return result
+	}
+	sandbox, err := NewSandbox(e, script, fixture.Mocks)
+	if err != nil {
+		result.Error = err.Error()
+		result.Errors = append(result.Errors, "load ext lib failed: "+err.Error())
+		return result
+	}
+	defer sandbox.Close()
+	if err := sandbox.Load(script); err != nil {
+		result.Error = err.Error()
+		result.Errors = append(result.Errors, "load rule failed: "+err.Error())
+		return result
+	}
+	output, err := luaexecutor.ExecuteRuleOnce(sandbox.Rule, typex.NewStringMessage(script.UUID, fixture.Input))
+	result.Output = luaToGo(output)
+	result.Calls = sandbox.Calls()
+	result.KV = sandbox.KV()
+	result.Logs = sandbox.Logs()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Errors = append(result.Errors, check(fixture.Expect, result)...)
+	result.Passed = len(result.Errors) == 0
+	return result
+}
+
+/*
+*
+* 规则的沙箱: 独立的虚拟机, 库都加载好了, 外部调用全部被模拟;
+* 规则脚本由调用方加载, 规则调试器也用这个; e 为 nil 是命令行的离线模式
+*
+ */
+type Sandbox struct {
+	Rule     *typex.Rule
+	id       string
+	recorder *recorder
+}
+
+func NewSandbox(e typex.Rhilex, script RuleScript, mocks FixtureMocks) (*Sandbox, error) {
+	rule := typex.NewRule(e, script.UUID, script.Name, "", "", "",
+		script.Success, script.Actions, script.Failed)
+	if err := luaruntime.LoadExtLuaLib(e, rule.LuaVM); err != nil {
+		rule.LuaVM.Close()
+		return nil, err
+	}
+	// 窗口等有状态的库用独立的 ID, 不影响正在运行的规则
+	sandbox := &Sandbox{Rule: rule, id: "_fixture_" + script.UUID, recorder: newRecorder(mocks)}
+	sandbox.recorder.offline = e == nil
+	luaruntime.LoadRuleLibGroup(e, "RULE", sandbox.id, rule.LuaVM)
+	sandbox.recorder.install(rule.LuaVM)
+	return sandbox, nil
+}
+
+// 加载规则脚本
+func (s *Sandbox) Load(script RuleScript) error {
+	for _, code := range []string{script.Actions, script.Success, script.Failed} {
+		if err := s.Rule.LuaVM.DoString(code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 清掉记录的调用和输出, kv 保留; 连续执行多条消息的时候用
+func (s *Sandbox) Reset() {
+	s.recorder.calls = []FixtureCall{}
+	s.recorder.logs = []string{}
+}
+
+// 每次 Debug 输出的时候回调
+func (s *Sandbox) OnLog(f func(string)) {
+	s.recorder.onLog = f
+}
+
+func (s *Sandbox) Calls() []FixtureCall {
+	return s.recorder.calls
+}
+
+func (s *Sandbox) KV() map[string]string {
+	return s.recorder.kv
+}
+
+func (s *Sandbox) Logs() []string {
+	return s.recorder.logs
+}
+
+func (s *Sandbox) Close() {
+	s.Rule.LuaVM.Close()
+	streamwindow.Purge(s.id)
+	luamodule.Forget(luamodule.SCOPE_RULE, s.id)
+}
+
+/*
+*
+* 声明式规则: 只执行过滤和映射, 每个输出目标记录成一次 output 调用
+*
+ */
+func runExprFixture(script RuleScript, fixture Fixture, result *FixtureResult) {
+	result.Calls = []FixtureCall{}
+	pipeline, err := exprrule.Compile(nil, script.UUID, script.Actions)
+	if err != nil {
+		result.Error = err.Error()
+		result.Errors = append(result.Errors, "load rule failed: "+err.Error())
+		return
+	}
+	output := (&exprReplayer{pipeline: pipeline}).run(typex.NewStringMessage(script.UUID, fixture.Input))
+	result.Output = output.Output
+	result.Calls = output.Calls
+	result.Error = output.Error
+	result.Errors = append(result.Errors, check(fixture.Expect, *result)...)
+	result.Passed = len(result.Errors) == 0
+}
+
+// 执行一个规则的全部测试用例
+func RunSuite(e typex.Rhilex, suite Suite) SuiteResult {
+	result := SuiteResult{
+		RuleId:   suite.Rule.UUID,
+		RuleName: suite.Rule.Name,
+		Passed:   true,
+		Results:  []FixtureResult{},
+	}
+	for spec, source := range suite.Modules {
+		luamodule.Preload(spec, source)
+	}
+	for _, fixture := range suite.Fixtures {
+		r := RunFixture(e, suite.Rule, fixture)
+		result.Passed = result.Passed && r.Passed
+		result.Results = append(result.Results, r)
+	}
+	return result
+}
+
+// 和期望比较, 返回不符合的地方
+func check(expect FixtureExpect, result FixtureResult) []string {
+	errors := []string{}
+	if expect.Error == "" && result.Error != "" {
+		errors = append(errors, "unexpected error: "+result.Error)
+	}
+	if expect.Error != "" && !strings.Contains(result.Error, expect.Error) {
+		errors = append(errors, fmt.Sprintf("expect error '%s', got '%s'", expect.Error, result.Error))
+	}
+	if expect.Output != nil && !equal(expect.Output, result.Output) {
+		errors = append(errors, fmt.Sprintf("expect output %s, got %s",
+			toJson(expect.Output), toJson(result.Output)))
+	}
+	if expect.Calls != nil {
+		if len(expect.Calls) != len(result.Calls) {
+			errors = append(errors, fmt.Sprintf("expect %d calls, got %d: %s",
+				len(expect.Calls), len(result.Calls), toJson(result.Calls)))
+		} else {
+			for i, call := range expect.Calls {
+				got := result.Calls[i]
+				if call.Func != got.Func {
+					errors = append(errors, fmt.Sprintf("call #%d: expect %s, got %s", i+1, call.Func, got.Func))
+					continue
+				}
+				if call.Args != nil && !equal(call.Args, got.Args) {
+					errors = append(errors, fmt.Sprintf("call #%d %s: expect args %s, got %s",
+						i+1, call.Func, toJson(call.Args), toJson(got.Args)))
+				}
+			}
+		}
+	}
+	for k, v := range expect.KV {
+		if got, ok := result.KV[k]; !ok || got != v {
+			errors = append(errors, fmt.Sprintf("expect kv '%s'='%s', got '%s'", k, v, got))
+		}
+	}
+	for _, log := range expect.Logs {
+		found := false
+		for _, got := range result.Logs {
+			if strings.Contains(got, log) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errors = append(errors, fmt.Sprintf("expect log '%s' not found", log))
+		}
+	}
+	return errors
+}
+
+// 都转成 JSON 的值再比较, 数字类型不一样也能比较
+func equal(a, b any) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(v any) any {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var result any
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return v
+	}
+	return result
+}
+
+func toJson(v any) string {
+	bytes, _ := json.Marshal(v)
+	return string(bytes)
+}
@@ -0,0 +1,179 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ruletest
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/trafficcapture"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/sirupsen/logrus"
+)
+
+var testRule = RuleScript{
+	UUID:    "RULE_TEST",
+	Name:    "test",
+	Success: `function Success() end`,
+	Failed:  `function Failed(error) end`,
+	Actions: `
+Actions = { function(args)
+	local value, err = json:J2T(args)
+	if value.temp > 30 then
+		local resp, err1 = device:CtrlDevice("DEVICE1", "open")
+		kv:VSet("alarm", resp)
+		data:ToMqtt("OUT1", json:T2J({alarm = true}))
+		Debug("alarm", value.temp)
+	end
+	return true, value.temp
+end }`,
+}
+
+// go test -timeout 30s -run ^Test_Run_Fixture github.com/hootrhino/rhilex/component/ruletest -v -count=1
+func Test_Run_Fixture(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	luaexecutor.InitRuleSandbox(typex.RhilexConfig{RuleExecuteTimeout: 3000})
+	result := RunSuite(nil, Suite{Rule: testRule, Fixtures: []Fixture{
+		{
+			Name:  "alarm",
+			Input: `{"temp": 35}`,
+			Mocks: FixtureMocks{Returns: map[string][]any{"device:CtrlDevice": {"ok", nil}}},
+			Expect: FixtureExpect{
+				Output: 35,
+				Calls: []FixtureCall{
+					{Func: "device:CtrlDevice", Args: []any{"DEVICE1", "open"}},
+					{Func: "data:ToMqtt", Args: []any{"OUT1", `{"alarm":true}`}},
+				},
+				KV:   map[string]string{"alarm": "ok"},
+				Logs: []string{"alarm  35"},
+			},
+		},
+		{
+			Name:   "normal",
+			Input:  `{"temp": 20}`,
+			Expect: FixtureExpect{Output: 20, Calls: []FixtureCall{}},
+		},
+	}})
+	Report(os.Stdout, []SuiteResult{result})
+	if !result.Passed {
+		t.Fatal("fixtures should pass")
+	}
+	// 期望不符合的时候要报出来
+	failed := RunFixture(nil, testRule, Fixture{
+		Name:   "wrong",
+		Input:  `{"temp": 35}`,
+		Expect: FixtureExpect{Output: 36, Calls: []FixtureCall{}},
+	})
+	if failed.Passed || len(failed.Errors) != 2 {
+		t.Fatal("fixture should fail", failed.Errors)
+	}
+	t.Log(failed.Errors)
+}
+
+// go test -timeout 30s -run ^Test_Run_Fixture_Timeout github.com/hootrhino/rhilex/component/ruletest -v -count=1
+func Test_Run_Fixture_Timeout(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	luaexecutor.InitRuleSandbox(typex.RhilexConfig{RuleExecuteTimeout: 100})
+	start := time.Now()
+	result := RunFixture(nil, RuleScript{
+		Success: testRule.Success,
+		Failed:  testRule.Failed,
+		Actions: `Actions = { function(args) while true do end return true, args end }`,
+	}, Fixture{Name: "dead loop", Expect: FixtureExpect{Error: "timeout"}})
+	if !result.Passed || time.Since(start) > 2*time.Second {
+		t.Fatal("dead loop should time out", result.Errors)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Run_Fixture_Offline github.com/hootrhino/rhilex/component/ruletest -v -count=1
+func Test_Run_Fixture_Offline(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	luaexecutor.InitRuleSandbox(typex.RhilexConfig{RuleExecuteTimeout: 3000})
+	script := RuleScript{Success: testRule.Success, Failed: testRule.Failed}
+	// 写数据的库只记录, 不碰数据库和设备
+	script.Actions = `Actions = { function(args)
+	local err = rds:Save("SCHEMA1", {temp = 1})
+	local err1 = schema:Write("DEVICE1", "temp", 1)
+	local err2 = modbus:WritePoint("DEVICE1", "{}")
+	return err == nil and err1 == nil and err2 == nil, args
+end }`
+	result := RunFixture(nil, script, Fixture{Name: "writes", Input: "{}", Expect: FixtureExpect{
+		Calls: []FixtureCall{
+			{Func: "rds:Save", Args: []any{"SCHEMA1", map[string]any{"temp": 1}}},
+			{Func: "schema:Write"},
+			{Func: "modbus:WritePoint"},
+		},
+	}})
+	if !result.Passed {
+		t.Fatal(result.Errors)
+	}
+	// 读数据中心和硬件的库没有引擎不能执行, 报错说清楚
+	for _, call := range []string{`rds:Query("SCHEMA1", {})`, `schema:Read("DEVICE1", "temp")`, `rhilexg1:DO1Set(1)`} {
+		script.Actions = `Actions = { function(args) local v = ` + call + ` return true, args end }`
+		result = RunFixture(nil, script, Fixture{Name: call, Input: "{}", Expect: FixtureExpect{Error: "unsupported in offline mode"}})
+		if !result.Passed {
+			t.Fatal(call, result.Errors)
+		}
+	}
+	// 模拟以后可以用
+	script.Actions = `Actions = { function(args) local rows, err = rds:Query("SCHEMA1", {}) return true, rows[1].temp end }`
+	result = RunFixture(nil, script, Fixture{Name: "mocked", Input: "{}",
+		Mocks:  FixtureMocks{Returns: map[string][]any{"rds:Query": {[]any{map[string]any{"temp": 2}}, nil}}},
+		Expect: FixtureExpect{Output: 2}})
+	if !result.Passed {
+		t.Fatal(result.Errors)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Replay github.com/hootrhino/rhilex/component/ruletest -v -count=1
+func Test_Replay(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	luaexecutor.InitRuleSandbox(typex.RhilexConfig{RuleExecuteTimeout: 3000})
+	records := []trafficcapture.Record{}
+	for i, temp := range []string{"20", "31", "29", "35"} {
+		records = append(records, trafficcapture.Record{
+			Ts: int64(1000 + i*10), ResourceId: "INEND1", Data: `{"temp": ` + temp + `}`,
+		})
+	}
+	candidate := testRule
+	candidate.Actions = strings.Replace(testRule.Actions, "value.temp > 30", "value.temp > 30.5", 1)
+	candidate.Actions = strings.Replace(candidate.Actions, "return true, value.temp", "return true, value.temp + 0", 1)
+	report, err := Replay(context.Background(), nil, testRule, &candidate, records, ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 4 || report.Differences != 0 {
+		t.Fatal("unexpected report", report)
+	}
+	candidate.Actions = strings.Replace(testRule.Actions, "value.temp > 30", "value.temp > 32", 1)
+	start := time.Now()
+	report, err = Replay(context.Background(), nil, testRule, &candidate, records, ReplayOptions{Speed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只有 31 度的那条不一样: 新版本不告警
+	if report.Differences != 1 || report.Results[0].Index != 1 || len(report.Results[0].Diff) != 1 {
+		t.Fatal("unexpected report", report)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Fatal("replay should keep the original interval")
+	}
+}
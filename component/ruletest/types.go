@@ -0,0 +1,129 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ruletest
+
+/*
+*
+* 规则的测试用例: 输入 + 外部调用的模拟结果 + 期望的输出和副作用
+*
+ */
+type Fixture struct {
+	Name   string        `json:"name"`
+	Input  string        `json:"input"` // 和资源推给规则的数据一样
+	Mocks  FixtureMocks  `json:"mocks"`
+	Expect FixtureExpect `json:"expect"`
+}
+
+/*
+*
+* 模拟外部调用, data/device/http 默认全部模拟, 不会真的发出去
+*
+ */
+type FixtureMocks struct {
+	Returns map[string][]any  `json:"returns"` // "data:ToMqtt" -> 返回值, 任何库函数都可以模拟, 全局函数不带前缀
+	Http    map[string]string `json:"http"`    // URL -> 响应
+	KV      map[string]string `json:"kv"`      // kv 的初始状态
+}
+
+/*
+*
+* 期望: 字段为空的不检查
+*
+ */
+type FixtureExpect struct {
+	Error  string            `json:"error"`  // 期望的错误(包含即可), 为空表示期望执行成功
+	Output any               `json:"output"` // Actions 的最终输出
+	Calls  []FixtureCall     `json:"calls"`  // 按顺序的外部调用, 空数组表示期望没有外部调用
+	KV     map[string]string `json:"kv"`     // 执行以后 kv 的状态
+	Logs   []string          `json:"logs"`   // Debug 输出, 包含即可
+}
+
+type FixtureCall struct {
+	Func string `json:"func"` // data:ToMqtt
+	Args []any  `json:"args"` // 为空不检查参数
+}
+
+type FixtureResult struct {
+	Name   string            `json:"name"`
+	Passed bool              `json:"passed"`
+	Errors []string          `json:"errors"` // 不符合期望的地方
+	Error  string            `json:"error"`  // 执行错误
+	Output any               `json:"output"`
+	Calls  []FixtureCall     `json:"calls"`
+	KV     map[string]string `json:"kv"`
+	Logs   []string          `json:"logs"`
+	Cost   int64             `json:"cost"` // 毫秒
+}
+
+// 被测试的规则脚本
+type RuleScript struct {
+	UUID    string `json:"uuid"`
+	Name    string `json:"name"`
+	Type    string `json:"type,omitempty"` // 为空是 lua
+	Actions string `json:"actions"`
+	Success string `json:"success"`
+	Failed  string `json:"failed"`
+}
+
+// 一个规则和它的全部测试用例, 也是导出给 CI 用的文件格式
+type Suite struct {
+	Rule     RuleScript        `json:"rule"`
+	Fixtures []Fixture         `json:"fixtures"`
+	Modules  map[string]string `json:"modules,omitempty"` // 规则用到的用户模块, name 或者 name@version -> 源码
+}
+
+type SuiteResult struct {
+	RuleId   string          `json:"ruleId"`
+	RuleName string          `json:"ruleName"`
+	Passed   bool            `json:"passed"`
+	Results  []FixtureResult `json:"results"`
+}
+
+/*
+*
+* 回放抓包: Speed 为 0 不等待, 1 按原来的间隔, 大于 1 加速
+*
+ */
+type ReplayOptions struct {
+	Speed float64      `json:"speed"`
+	Mocks FixtureMocks `json:"mocks"`
+}
+
+type ReplayOutput struct {
+	Output any           `json:"output"`
+	Calls  []FixtureCall `json:"calls"`
+	Logs   []string      `json:"logs,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type ReplayRecord struct {
+	Index     int           `json:"index"`
+	Ts        int64         `json:"ts"`
+	Input     string        `json:"input"`
+	Base      ReplayOutput  `json:"base"`
+	Candidate *ReplayOutput `json:"candidate,omitempty"`
+	Diff      []string      `json:"diff,omitempty"`
+}
+
+type ReplayReport struct {
+	RuleId      string         `json:"ruleId"`
+	Total       int            `json:"total"`       // 回放了多少条
+	Errors      int            `json:"errors"`      // 执行出错的条数
+	Differences int            `json:"differences"` // 两个版本输出不一样的条数
+	Truncated   bool           `json:"truncated"`   // 结果太多, 只保留了前面的
+	Results     []ReplayRecord `json:"results"`     // 对比的时候只有不一样的
+	Cost        int64          `json:"cost"`        // 毫秒
+}
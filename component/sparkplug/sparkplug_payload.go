@@ -0,0 +1,421 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sparkplug
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Sparkplug B 数据类型
+type DataType uint32
+
+const (
+	Unknown  DataType = 0
+	Int8     DataType = 1
+	Int16    DataType = 2
+	Int32    DataType = 3
+	Int64    DataType = 4
+	UInt8    DataType = 5
+	UInt16   DataType = 6
+	UInt32   DataType = 7
+	UInt64   DataType = 8
+	Float    DataType = 9
+	Double   DataType = 10
+	Boolean  DataType = 11
+	String   DataType = 12
+	DateTime DataType = 13
+	Text     DataType = 14
+	UUID     DataType = 15
+	Bytes    DataType = 17
+)
+
+/*
+*
+* Sparkplug B 的 Metric, 只实现了标量类型, DataSet/Template 解码的时候会跳过
+*
+ */
+type Metric struct {
+	Name      string   `json:"name,omitempty"`
+	Alias     uint64   `json:"alias,omitempty"`
+	HasAlias  bool     `json:"-"`
+	Timestamp uint64   `json:"timestamp,omitempty"`
+	DataType  DataType `json:"datatype"`
+	IsNull    bool     `json:"isNull,omitempty"`
+	Value     any      `json:"value"`
+}
+
+/*
+*
+* org.eclipse.tahu.protobuf.Payload
+*
+ */
+type Payload struct {
+	Timestamp uint64   `json:"timestamp"`
+	Metrics   []Metric `json:"metrics"`
+	Seq       uint64   `json:"seq"`
+	HasSeq    bool     `json:"-"`
+	Uuid      string   `json:"uuid,omitempty"`
+	Body      []byte   `json:"body,omitempty"`
+}
+
+// 编码
+func (p *Payload) Marshal() []byte {
+	b := []byte{}
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, p.Timestamp)
+	for _, metric := range p.Metrics {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, metric.marshal())
+	}
+	if p.HasSeq {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, p.Seq)
+	}
+	if p.Uuid != "" {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, p.Uuid)
+	}
+	if len(p.Body) > 0 {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, p.Body)
+	}
+	return b
+}
+
+func (m *Metric) marshal() []byte {
+	b := []byte{}
+	if m.Name != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, m.Name)
+	}
+	if m.HasAlias {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.Alias)
+	}
+	if m.Timestamp > 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.Timestamp)
+	}
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.DataType))
+	if m.IsNull || m.Value == nil {
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+		return b
+	}
+	switch m.DataType {
+	case Int8, Int16, Int32, UInt8, UInt16, UInt32:
+		b = protowire.AppendTag(b, 10, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(uint32(toInt64(m.Value))))
+	case Int64, UInt64, DateTime:
+		b = protowire.AppendTag(b, 11, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(toInt64(m.Value)))
+	case Float:
+		b = protowire.AppendTag(b, 12, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, math.Float32bits(float32(toFloat64(m.Value))))
+	case Double:
+		b = protowire.AppendTag(b, 13, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(toFloat64(m.Value)))
+	case Boolean:
+		b = protowire.AppendTag(b, 14, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(toBool(m.Value)))
+	case Bytes:
+		bytes, _ := m.Value.([]byte)
+		b = protowire.AppendTag(b, 16, protowire.BytesType)
+		b = protowire.AppendBytes(b, bytes)
+	default:
+		b = protowire.AppendTag(b, 15, protowire.BytesType)
+		b = protowire.AppendString(b, fmt.Sprintf("%v", m.Value))
+	}
+	return b
+}
+
+// 解码
+func Unmarshal(b []byte) (*Payload, error) {
+	p := &Payload{Metrics: []Metric{}}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Timestamp = v
+			b = b[n:]
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			metric, err := unmarshalMetric(v)
+			if err != nil {
+				return nil, err
+			}
+			p.Metrics = append(p.Metrics, *metric)
+			b = b[n:]
+		case num == 3 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Seq = v
+			p.HasSeq = true
+			b = b[n:]
+		case num == 4 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Uuid = v
+			b = b[n:]
+		case num == 5 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Body = append([]byte{}, v...)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return p, nil
+}
+
+func unmarshalMetric(b []byte) (*Metric, error) {
+	m := &Metric{}
+	var raw uint64
+	var hasRaw bool
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			m.Name = v
+			b = b[n:]
+		case typ == protowire.VarintType && (num == 2 || num == 3 || num == 4 ||
+			num == 7 || num == 10 || num == 11 || num == 14):
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			switch num {
+			case 2:
+				m.Alias = v
+				m.HasAlias = true
+			case 3:
+				m.Timestamp = v
+			case 4:
+				m.DataType = DataType(v)
+			case 7:
+				m.IsNull = v != 0
+			case 10, 11:
+				raw = v
+				hasRaw = true
+			case 14:
+				m.Value = v != 0
+			}
+			b = b[n:]
+		case num == 12 && typ == protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			m.Value = float64(math.Float32frombits(v))
+			b = b[n:]
+		case num == 13 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			m.Value = math.Float64frombits(v)
+			b = b[n:]
+		case num == 15 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			m.Value = v
+			b = b[n:]
+		case num == 16 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			m.Value = append([]byte{}, v...)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	// 整数要根据类型恢复符号位
+	if hasRaw {
+		switch m.DataType {
+		case Int8:
+			m.Value = int64(int8(raw))
+		case Int16:
+			m.Value = int64(int16(raw))
+		case Int32:
+			m.Value = int64(int32(raw))
+		case UInt8, UInt16, UInt32:
+			m.Value = int64(uint32(raw))
+		case UInt64:
+			m.Value = raw
+		default:
+			m.Value = int64(raw)
+		}
+	}
+	if m.IsNull {
+		m.Value = nil
+	}
+	return m, nil
+}
+
+/*
+*
+* 转成平铺的表: {"timestamp":.., "seq":.., "metrics": {"name": value}}
+* 只有别名没有名字的 metric 用 "alias:<n>" 作为键
+*
+ */
+func (p *Payload) ToMap() map[string]any {
+	metrics := map[string]any{}
+	for _, metric := range p.Metrics {
+		key := metric.Name
+		if key == "" {
+			key = "alias:" + strconv.FormatUint(metric.Alias, 10)
+		}
+		metrics[key] = metric.Value
+	}
+	return map[string]any{
+		"timestamp": p.Timestamp,
+		"seq":       p.Seq,
+		"metrics":   metrics,
+	}
+}
+
+// 根据Go的值推断类型
+func InferDataType(v any) DataType {
+	switch v.(type) {
+	case bool:
+		return Boolean
+	case int, int64:
+		return Int64
+	case int8:
+		return Int8
+	case int16:
+		return Int16
+	case int32:
+		return Int32
+	case uint8:
+		return UInt8
+	case uint16:
+		return UInt16
+	case uint32:
+		return UInt32
+	case uint64:
+		return UInt64
+	case float32:
+		return Float
+	case float64:
+		return Double
+	case []byte:
+		return Bytes
+	}
+	return String
+}
+
+func toInt64(v any) int64 {
+	switch T := v.(type) {
+	case int:
+		return int64(T)
+	case int8:
+		return int64(T)
+	case int16:
+		return int64(T)
+	case int32:
+		return int64(T)
+	case int64:
+		return T
+	case uint8:
+		return int64(T)
+	case uint16:
+		return int64(T)
+	case uint32:
+		return int64(T)
+	case uint64:
+		return int64(T)
+	case float32:
+		return int64(T)
+	case float64:
+		return int64(T)
+	case bool:
+		if T {
+			return 1
+		}
+	case string:
+		i, _ := strconv.ParseInt(T, 10, 64)
+		return i
+	}
+	return 0
+}
+
+func toFloat64(v any) float64 {
+	switch T := v.(type) {
+	case float32:
+		return float64(T)
+	case float64:
+		return T
+	case string:
+		f, _ := strconv.ParseFloat(T, 64)
+		return f
+	}
+	return float64(toInt64(v))
+}
+
+func toBool(v any) bool {
+	switch T := v.(type) {
+	case bool:
+		return T
+	case string:
+		b, _ := strconv.ParseBool(T)
+		return b
+	}
+	return toInt64(v) != 0
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sparkplug
+
+import (
+	"testing"
+)
+
+func Test_Payload_Marshal_Unmarshal(t *testing.T) {
+	p := Payload{
+		Timestamp: 1700000000000,
+		Seq:       3,
+		HasSeq:    true,
+		Metrics: []Metric{
+			{Name: "temp", Alias: 1, HasAlias: true, DataType: Double, Value: 23.5},
+			{Name: "count", DataType: Int16, Value: int64(-2)},
+			{Name: "online", DataType: Boolean, Value: true},
+			{Name: "sn", DataType: String, Value: "rhilex"},
+			{Alias: 5, HasAlias: true, DataType: Int64, IsNull: true},
+		},
+	}
+	p1, err := Unmarshal(p.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1.Timestamp != p.Timestamp || p1.Seq != 3 || len(p1.Metrics) != 5 {
+		t.Fatal("payload mismatch:", p1)
+	}
+	metrics := p1.ToMap()["metrics"].(map[string]any)
+	t.Log(metrics)
+	if metrics["temp"] != 23.5 || metrics["count"] != int64(-2) ||
+		metrics["online"] != true || metrics["sn"] != "rhilex" || metrics["alias:5"] != nil {
+		t.Fatal("metrics mismatch:", metrics)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package streamwindow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/ossupport"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+var windowNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-.]+$`)
+
+/*
+*
+* 所有规则的窗口, 同一个规则的多个虚拟机共享一份状态
+*
+ */
+var __Windows = struct {
+	sync.Mutex
+	owners map[string]map[string]*Window
+}{owners: map[string]map[string]*Window{}}
+
+var __SnapshotDir = ossupport.StreamWindowDir
+
+var __Cancel context.CancelFunc
+
+// 快照文件内容
+type snapshot struct {
+	Spec      Spec               `json:"spec"`
+	Watermark int64              `json:"watermark"`
+	Panes     map[string][]*Pane `json:"panes"`
+}
+
+func InitStreamWindow(config typex.RhilexConfig) {
+	interval := time.Duration(config.WindowSnapshotInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	__Cancel = cancel
+	go func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				saveAll()
+			}
+		}
+	}(ctx)
+}
+
+// 停止的时候保存全部持久化窗口
+func Stop() {
+	if __Cancel != nil {
+		__Cancel()
+	}
+	saveAll()
+}
+
+/*
+*
+* 定义窗口: 已经存在且定义相同就直接返回, 定义变了就重建;
+* 持久化的窗口第一次定义的时候从快照恢复
+*
+ */
+func Define(owner, name string, spec Spec) (*Window, error) {
+	if !windowNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid window name: %s", name)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	__Windows.Lock()
+	defer __Windows.Unlock()
+	windows, ok := __Windows.owners[owner]
+	if !ok {
+		windows = map[string]*Window{}
+		__Windows.owners[owner] = windows
+	}
+	if w, ok := windows[name]; ok {
+		if w.Spec.equal(spec) {
+			return w, nil
+		}
+		glogger.GLogger.Infof("Stream window (%s,%s) redefined, state reset", owner, name)
+	}
+	w := newWindow(owner, name, spec)
+	if spec.Persist {
+		restore(w)
+	}
+	windows[name] = w
+	return w, nil
+}
+
+func Get(owner, name string) *Window {
+	__Windows.Lock()
+	defer __Windows.Unlock()
+	return __Windows.owners[owner][name]
+}
+
+// 某个规则的全部窗口
+func Windows(owner string) []*Window {
+	__Windows.Lock()
+	defer __Windows.Unlock()
+	windows := []*Window{}
+	for _, w := range __Windows.owners[owner] {
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+/*
+*
+* 规则卸载的时候释放内存, 持久化窗口先保存, 重新加载后可以恢复
+*
+ */
+func Release(owner string) {
+	__Windows.Lock()
+	windows := __Windows.owners[owner]
+	delete(__Windows.owners, owner)
+	__Windows.Unlock()
+	for _, w := range windows {
+		if w.Spec.Persist {
+			save(w)
+		}
+	}
+}
+
+// 规则删除的时候连快照一起删掉
+func Purge(owner string) {
+	__Windows.Lock()
+	delete(__Windows.owners, owner)
+	__Windows.Unlock()
+	files, _ := filepath.Glob(filepath.Join(__SnapshotDir, owner+".*.json"))
+	for _, file := range files {
+		os.Remove(file)
+	}
+}
+
+func snapshotPath(w *Window) string {
+	return filepath.Join(__SnapshotDir, w.Owner+"."+w.Name+".json")
+}
+
+func saveAll() {
+	__Windows.Lock()
+	windows := []*Window{}
+	for _, owned := range __Windows.owners {
+		for _, w := range owned {
+			if w.Spec.Persist {
+				windows = append(windows, w)
+			}
+		}
+	}
+	__Windows.Unlock()
+	for _, w := range windows {
+		save(w)
+	}
+}
+
+func save(w *Window) {
+	w.mu.Lock()
+	if !w.dirty {
+		w.mu.Unlock()
+		return
+	}
+	bytes, err := json.Marshal(snapshot{
+		Spec:      w.Spec,
+		Watermark: w.watermark,
+		Panes:     w.panes,
+	})
+	w.dirty = false
+	w.mu.Unlock()
+	if err != nil {
+		glogger.GLogger.Error("Stream window snapshot error:", err)
+		return
+	}
+	if err := os.MkdirAll(__SnapshotDir, 0755); err != nil {
+		glogger.GLogger.Error("Stream window snapshot error:", err)
+		return
+	}
+	// 先写临时文件再改名, 避免断电写坏快照
+	path := snapshotPath(w)
+	if err := os.WriteFile(path+".tmp", bytes, 0644); err != nil {
+		glogger.GLogger.Error("Stream window snapshot error:", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		glogger.GLogger.Error("Stream window snapshot error:", err)
+	}
+}
+
+func restore(w *Window) {
+	bytes, err := os.ReadFile(snapshotPath(w))
+	if err != nil {
+		return
+	}
+	s := snapshot{}
+	if err := json.Unmarshal(bytes, &s); err != nil {
+		glogger.GLogger.Error("Stream window snapshot broken:", err)
+		return
+	}
+	if !s.Spec.equal(w.Spec) {
+		glogger.GLogger.Infof("Stream window (%s,%s) spec changed, snapshot ignored", w.Owner, w.Name)
+		return
+	}
+	if s.Panes != nil {
+		w.panes = s.Panes
+	}
+	w.watermark = s.Watermark
+}
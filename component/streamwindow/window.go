@@ -0,0 +1,453 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package streamwindow
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 窗口类型
+const (
+	TUMBLING string = "tumbling" // 滚动窗口: 固定长度, 不重叠
+	SLIDING  string = "sliding"  // 滑动窗口: 固定长度, 按步长重叠
+	SESSION  string = "session"  // 会话窗口: 数据间隔超过 Gap 就关闭
+)
+
+const (
+	__DefaultMaxKeys   = 1024
+	__DefaultMaxValues = 10000
+	__MaxSlidingPanes  = 1000
+)
+
+var __DefaultAggs = []string{"count", "sum", "avg", "min", "max"}
+
+/*
+*
+* 窗口定义, 时间单位都是毫秒
+*
+ */
+type Spec struct {
+	Type      string   `json:"type"`
+	Size      int64    `json:"size"`      // 窗口长度
+	Slide     int64    `json:"slide"`     // 滑动步长, 仅滑动窗口
+	Gap       int64    `json:"gap"`       // 会话超时, 仅会话窗口
+	Key       string   `json:"key"`       // 分组字段
+	Field     string   `json:"field"`     // 统计字段
+	Time      string   `json:"time"`      // 时间字段, 为空用到达时间
+	Aggs      []string `json:"aggs"`      // 统计项
+	MaxKeys   int      `json:"maxKeys"`   // 最多多少个分组
+	MaxValues int      `json:"maxValues"` // 分位数最多缓存多少个值
+	Persist   bool     `json:"persist"`   // 是否持久化
+}
+
+func (s *Spec) Validate() error {
+	switch s.Type {
+	case TUMBLING:
+		if s.Size <= 0 {
+			return fmt.Errorf("tumbling window size must be positive")
+		}
+	case SLIDING:
+		if s.Size <= 0 || s.Slide <= 0 {
+			return fmt.Errorf("sliding window size and slide must be positive")
+		}
+		if s.Slide > s.Size {
+			return fmt.Errorf("sliding window slide must not be greater than size")
+		}
+		if s.Size/s.Slide > __MaxSlidingPanes {
+			return fmt.Errorf("sliding window size/slide must not exceed %d", __MaxSlidingPanes)
+		}
+	case SESSION:
+		if s.Gap <= 0 {
+			return fmt.Errorf("session window gap must be positive")
+		}
+	default:
+		return fmt.Errorf("unsupported window type: %s", s.Type)
+	}
+	if len(s.Aggs) == 0 {
+		s.Aggs = append([]string{}, __DefaultAggs...)
+	}
+	for _, agg := range s.Aggs {
+		if !validAgg(agg) {
+			return fmt.Errorf("unsupported aggregation: %s", agg)
+		}
+	}
+	if s.MaxKeys <= 0 {
+		s.MaxKeys = __DefaultMaxKeys
+	}
+	if s.MaxValues <= 0 {
+		s.MaxValues = __DefaultMaxValues
+	}
+	return nil
+}
+
+func (s Spec) equal(o Spec) bool {
+	return s.Type == o.Type && s.Size == o.Size && s.Slide == o.Slide &&
+		s.Gap == o.Gap && s.Key == o.Key && s.Field == o.Field &&
+		s.Time == o.Time && s.MaxKeys == o.MaxKeys &&
+		s.MaxValues == o.MaxValues && s.Persist == o.Persist &&
+		strings.Join(s.Aggs, ",") == strings.Join(o.Aggs, ",")
+}
+
+// 是否需要缓存原始值算分位数
+func (s Spec) keepValues() bool {
+	for _, agg := range s.Aggs {
+		if _, ok := percentile(agg); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validAgg(agg string) bool {
+	switch agg {
+	case "count", "sum", "avg", "min", "max", "stddev", "first", "last":
+		return true
+	}
+	_, ok := percentile(agg)
+	return ok
+}
+
+// p50, p95, p99.9 ...
+func percentile(agg string) (float64, bool) {
+	if !strings.HasPrefix(agg, "p") {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(agg[1:], 64)
+	if err != nil || p <= 0 || p >= 100 {
+		return 0, false
+	}
+	return p, true
+}
+
+/*
+*
+* 一个分组的一个窗口, 增量统计, 方差用 Welford 算法
+*
+ */
+type Pane struct {
+	Start  int64     `json:"start"`
+	End    int64     `json:"end"`
+	Last   int64     `json:"last"`
+	Count  int64     `json:"count"`
+	Sum    float64   `json:"sum"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	First  float64   `json:"first"`
+	Latest float64   `json:"latest"`
+	Mean   float64   `json:"mean"`
+	M2     float64   `json:"m2"`
+	Values []float64 `json:"values,omitempty"`
+}
+
+func (p *Pane) add(value float64, ts int64, maxValues int, keep bool) {
+	if p.Count == 0 {
+		p.Min, p.Max, p.First = value, value, value
+	}
+	p.Count++
+	p.Sum += value
+	p.Min = math.Min(p.Min, value)
+	p.Max = math.Max(p.Max, value)
+	p.Latest = value
+	if ts > p.Last {
+		p.Last = ts
+	}
+	delta := value - p.Mean
+	p.Mean += delta / float64(p.Count)
+	p.M2 += delta * (value - p.Mean)
+	if keep && len(p.Values) < maxValues {
+		p.Values = append(p.Values, value)
+	}
+}
+
+func (p *Pane) aggregate(aggs []string) map[string]float64 {
+	values := map[string]float64{}
+	var sorted []float64
+	for _, agg := range aggs {
+		switch agg {
+		case "count":
+			values[agg] = float64(p.Count)
+		case "sum":
+			values[agg] = p.Sum
+		case "avg":
+			values[agg] = p.Mean
+		case "min":
+			values[agg] = p.Min
+		case "max":
+			values[agg] = p.Max
+		case "first":
+			values[agg] = p.First
+		case "last":
+			values[agg] = p.Latest
+		case "stddev":
+			if p.Count > 0 {
+				values[agg] = math.Sqrt(p.M2 / float64(p.Count))
+			}
+		default:
+			q, _ := percentile(agg)
+			if sorted == nil {
+				sorted = append([]float64{}, p.Values...)
+				sort.Float64s(sorted)
+			}
+			values[agg] = rank(sorted, q)
+		}
+	}
+	return values
+}
+
+// 线性插值求分位数
+func rank(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := q / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+/*
+*
+* 窗口关闭以后的统计结果
+*
+ */
+type Result struct {
+	Window string             `json:"window"`
+	Key    string             `json:"key"`
+	Start  int64              `json:"start"`
+	End    int64              `json:"end"`
+	Values map[string]float64 `json:"values"`
+}
+
+/*
+*
+* 一个命名窗口, 按 Key 分组; 水位线是见过的最大时间, 窗口结束时间不超过水位线就关闭
+*
+ */
+type Window struct {
+	mu        sync.Mutex
+	Owner     string
+	Name      string
+	Spec      Spec
+	panes     map[string][]*Pane
+	watermark int64
+	late      int64
+	dropped   int64
+	dirty     bool
+}
+
+func newWindow(owner, name string, spec Spec) *Window {
+	return &Window{
+		Owner: owner,
+		Name:  name,
+		Spec:  spec,
+		panes: map[string][]*Pane{},
+	}
+}
+
+/*
+*
+* 写入一个值, 返回因为水位线推进而关闭的窗口
+*
+ */
+func (w *Window) Add(key string, value float64, ts int64) []Result {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ts > w.watermark {
+		w.watermark = ts
+	}
+	results := w.closeExpired()
+	if _, ok := w.panes[key]; !ok && len(w.panes) >= w.Spec.MaxKeys {
+		w.dropped++
+		return results
+	}
+	if !w.insert(key, value, ts) {
+		w.late++
+	}
+	w.dirty = true
+	return results
+}
+
+func (w *Window) insert(key string, value float64, ts int64) bool {
+	keep := w.Spec.keepValues()
+	switch w.Spec.Type {
+	case TUMBLING:
+		start := floor(ts, w.Spec.Size)
+		if start+w.Spec.Size <= w.watermark {
+			return false
+		}
+		w.pane(key, start, start+w.Spec.Size).add(value, ts, w.Spec.MaxValues, keep)
+	case SLIDING:
+		inserted := false
+		for start := floor(ts, w.Spec.Slide); start > ts-w.Spec.Size; start -= w.Spec.Slide {
+			if start+w.Spec.Size <= w.watermark {
+				break
+			}
+			w.pane(key, start, start+w.Spec.Size).add(value, ts, w.Spec.MaxValues, keep)
+			inserted = true
+		}
+		return inserted
+	case SESSION:
+		if ts+w.Spec.Gap <= w.watermark {
+			return false
+		}
+		panes := w.panes[key]
+		if len(panes) == 0 {
+			panes = []*Pane{{Start: ts, Last: ts}}
+			w.panes[key] = panes
+		}
+		p := panes[0]
+		if ts < p.Start {
+			p.Start = ts
+		}
+		p.add(value, ts, w.Spec.MaxValues, keep)
+		p.End = p.Last + w.Spec.Gap
+	}
+	return true
+}
+
+func (w *Window) pane(key string, start, end int64) *Pane {
+	panes := w.panes[key]
+	for _, p := range panes {
+		if p.Start == start {
+			return p
+		}
+	}
+	p := &Pane{Start: start, End: end, Last: start}
+	panes = append(panes, p)
+	sort.Slice(panes, func(i, j int) bool { return panes[i].Start < panes[j].Start })
+	w.panes[key] = panes
+	return p
+}
+
+func (w *Window) closeExpired() []Result {
+	return w.close(func(p *Pane) bool { return p.End <= w.watermark })
+}
+
+func (w *Window) close(expired func(*Pane) bool) []Result {
+	results := []Result{}
+	for key, panes := range w.panes {
+		open := panes[:0]
+		for _, p := range panes {
+			if expired(p) {
+				results = append(results, w.result(key, p))
+			} else {
+				open = append(open, p)
+			}
+		}
+		if len(open) == 0 {
+			delete(w.panes, key)
+		} else {
+			w.panes[key] = open
+		}
+	}
+	if len(results) > 0 {
+		w.dirty = true
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].End != results[j].End {
+			return results[i].End < results[j].End
+		}
+		return results[i].Key < results[j].Key
+	})
+	return results
+}
+
+func (w *Window) result(key string, p *Pane) Result {
+	return Result{
+		Window: w.Name,
+		Key:    key,
+		Start:  p.Start,
+		End:    p.End,
+		Values: p.aggregate(w.Spec.Aggs),
+	}
+}
+
+/*
+*
+* 按当前时间推进水位线, 用于没有新数据时也能关闭窗口
+*
+ */
+func (w *Window) Tick(now int64) []Result {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if now > w.watermark {
+		w.watermark = now
+	}
+	return w.closeExpired()
+}
+
+// 强制关闭全部窗口
+func (w *Window) Flush() []Result {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.close(func(*Pane) bool { return true })
+}
+
+// 查看某个分组还没关闭的窗口
+func (w *Window) Peek(key string) []Result {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	results := []Result{}
+	for _, p := range w.panes[key] {
+		results = append(results, w.result(key, p))
+	}
+	return results
+}
+
+// 清空窗口状态
+func (w *Window) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.panes = map[string][]*Pane{}
+	w.watermark = 0
+	w.late = 0
+	w.dropped = 0
+	w.dirty = true
+}
+
+type Stats struct {
+	Keys      int   `json:"keys"`
+	Watermark int64 `json:"watermark"`
+	Late      int64 `json:"late"`    // 迟到丢弃的数据
+	Dropped   int64 `json:"dropped"` // 超过分组上限丢弃的数据
+}
+
+func (w *Window) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Stats{
+		Keys:      len(w.panes),
+		Watermark: w.watermark,
+		Late:      w.late,
+		Dropped:   w.dropped,
+	}
+}
+
+func floor(ts, size int64) int64 {
+	start := ts - ts%size
+	if ts < 0 && ts%size != 0 {
+		start -= size
+	}
+	return start
+}
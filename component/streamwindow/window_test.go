@@ -0,0 +1,117 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package streamwindow
+
+import (
+	"testing"
+)
+
+// go test -timeout 30s -run ^Test_Tumbling_Window github.com/hootrhino/rhilex/component/streamwindow -v -count=1
+func Test_Tumbling_Window(t *testing.T) {
+	w, err := Define("RULE1", "avg", Spec{Type: TUMBLING, Size: 1000, Aggs: []string{"count", "avg", "stddev", "p50"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Purge("RULE1")
+	for i, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		if results := w.Add("a", v, int64(100+i)); len(results) != 0 {
+			t.Fatal("window closed too early", results)
+		}
+	}
+	w.Add("b", 1, 500)
+	results := w.Add("a", 100, 1200)
+	if len(results) != 2 || results[0].Key != "a" || results[1].Key != "b" {
+		t.Fatal("unexpected results", results)
+	}
+	a := results[0]
+	if a.Start != 0 || a.End != 1000 || a.Values["count"] != 8 || a.Values["avg"] != 5 ||
+		a.Values["stddev"] != 2 || a.Values["p50"] != 4.5 {
+		t.Fatal("unexpected aggregation", a)
+	}
+	// 迟到数据丢弃
+	w.Add("a", 1, 900)
+	if w.Stats().Late != 1 {
+		t.Fatal("late data should be counted")
+	}
+	// 定义相同不重置
+	same, _ := Define("RULE1", "avg", Spec{Type: TUMBLING, Size: 1000, Aggs: []string{"count", "avg", "stddev", "p50"}})
+	if same != w {
+		t.Fatal("same spec should reuse window")
+	}
+	if flushed := w.Flush(); len(flushed) != 1 || flushed[0].Values["count"] != 1 {
+		t.Fatal("unexpected flush", flushed)
+	}
+}
+
+// go test -timeout 30s -run ^Test_Sliding_Session_Window github.com/hootrhino/rhilex/component/streamwindow -v -count=1
+func Test_Sliding_Session_Window(t *testing.T) {
+	defer Purge("RULE2")
+	sliding, err := Define("RULE2", "sliding", Spec{Type: SLIDING, Size: 1000, Slide: 500, Aggs: []string{"sum"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sliding.Add("", 1, 100)
+	sliding.Add("", 2, 600)
+	results := sliding.Add("", 4, 1100)
+	// [0,1000) 关闭, [500,1500) 和 [1000,2000) 还开着
+	if len(results) != 1 || results[0].Values["sum"] != 3 {
+		t.Fatal("unexpected sliding results", results)
+	}
+	if peek := sliding.Peek(""); len(peek) != 2 || peek[0].Values["sum"] != 6 || peek[1].Values["sum"] != 4 {
+		t.Fatal("unexpected sliding peek", peek)
+	}
+	session, err := Define("RULE2", "session", Spec{Type: SESSION, Gap: 300, Aggs: []string{"count", "first", "last"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Add("k", 1, 0)
+	session.Add("k", 2, 200)
+	session.Add("k", 3, 400)
+	results = session.Tick(800)
+	if len(results) != 1 || results[0].Start != 0 || results[0].End != 700 ||
+		results[0].Values["count"] != 3 || results[0].Values["first"] != 1 || results[0].Values["last"] != 3 {
+		t.Fatal("unexpected session results", results)
+	}
+	if _, err := Define("RULE2", "bad", Spec{Type: SLIDING, Size: 100, Slide: 200}); err == nil {
+		t.Fatal("slide greater than size should fail")
+	}
+	if _, err := Define("RULE2", "bad", Spec{Type: TUMBLING, Size: 100, Aggs: []string{"median"}}); err == nil {
+		t.Fatal("unknown aggregation should fail")
+	}
+}
+
+// go test -timeout 30s -run ^Test_Window_Persist github.com/hootrhino/rhilex/component/streamwindow -v -count=1
+func Test_Window_Persist(t *testing.T) {
+	__SnapshotDir = t.TempDir()
+	spec := Spec{Type: TUMBLING, Size: 60000, Aggs: []string{"max"}, Persist: true}
+	w, _ := Define("RULE3", "max", spec)
+	w.Add("a", 3, 1000)
+	w.Add("a", 8, 2000)
+	Release("RULE3")
+	w, _ = Define("RULE3", "max", spec)
+	results := w.Flush()
+	if len(results) != 1 || results[0].Values["max"] != 8 {
+		t.Fatal("window not restored", results)
+	}
+	Purge("RULE3")
+	w, _ = Define("RULE3", "max", spec)
+	if len(w.Flush()) != 0 {
+		t.Fatal("purged window should not be restored")
+	}
+	if rank([]float64{1, 2}, 50) != 1.5 {
+		t.Fatal("unexpected percentile")
+	}
+}
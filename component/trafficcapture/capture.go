@@ -0,0 +1,390 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package trafficcapture
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/ossupport"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 抓包记录: 资源推给规则的每一条消息, 一行一个 JSON
+*
+ */
+type Record struct {
+	Ts         int64             `json:"ts"` // 毫秒
+	ResourceId string            `json:"id"`
+	Quality    string            `json:"quality,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Data       string            `json:"data,omitempty"`  // 和字符串规则拿到的一样
+	Bytes      []byte            `json:"bytes,omitempty"` // 二进制消息
+}
+
+// 还原成消息, 时间戳保持抓包时候的值
+func (r Record) Message() *typex.Message {
+	var msg *typex.Message
+	if r.Bytes != nil {
+		msg = typex.NewBytesMessage(r.ResourceId, r.Bytes)
+	} else {
+		msg = typex.NewStringMessage(r.ResourceId, r.Data)
+	}
+	msg.Timestamp = r.Ts
+	if r.Quality != "" {
+		msg.Quality = r.Quality
+	}
+	msg.Tags = r.Tags
+	return msg
+}
+
+type CaptureInfo struct {
+	ResourceId string `json:"resourceId"`
+	Running    bool   `json:"running"`
+	StartAt    int64  `json:"startAt,omitempty"`
+	StopAt     int64  `json:"stopAt,omitempty"` // 0 表示一直抓到手动停止
+	Records    int64  `json:"records"`
+	Size       int64  `json:"size"` // 所有文件的大小
+}
+
+type capture struct {
+	resourceId string
+	startAt    time.Time
+	stopAt     time.Time
+	records    atomic.Int64
+	locker     sync.Mutex
+	file       *os.File
+	size       int64
+	timer      *time.Timer
+}
+
+var (
+	__CaptureDir  = ossupport.TrafficCaptureDir
+	__captures    sync.Map // resourceId -> *capture
+	__capturing   atomic.Int32
+	__maxFileSize int64 = 4 * 1024 * 1024
+	__maxFiles          = 3
+	__validId           = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+	__fileName          = regexp.MustCompile(`^([A-Za-z0-9_\-]+)\.jsonl(\.\d+)?$`)
+)
+
+func InitTrafficCapture(config typex.RhilexConfig) {
+	if config.CaptureMaxFileSize > 0 {
+		__maxFileSize = int64(config.CaptureMaxFileSize) * 1024 * 1024
+	}
+	if config.CaptureMaxFiles > 0 {
+		__maxFiles = config.CaptureMaxFiles
+	}
+}
+
+/*
+*
+* 开始抓包, duration 为 0 表示一直抓到手动停止; 重新开始会接着写原来的文件
+*
+ */
+func Start(resourceId string, duration time.Duration) error {
+	if !__validId.MatchString(resourceId) {
+		return fmt.Errorf("invalid resource id: %s", resourceId)
+	}
+	if err := os.MkdirAll(__CaptureDir, 0755); err != nil {
+		return err
+	}
+	path := filePath(resourceId, 0)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, _ := file.Stat()
+	c := &capture{resourceId: resourceId, startAt: time.Now(), file: file, size: info.Size()}
+	if _, loaded := __captures.LoadOrStore(resourceId, c); loaded {
+		file.Close()
+		return fmt.Errorf("capture already running: %s", resourceId)
+	}
+	__capturing.Add(1)
+	if duration > 0 {
+		c.stopAt = c.startAt.Add(duration)
+		c.timer = time.AfterFunc(duration, func() {
+			Stop(resourceId)
+		})
+	}
+	glogger.GLogger.Info("Traffic capture started:", resourceId)
+	return nil
+}
+
+func Stop(resourceId string) error {
+	v, ok := __captures.LoadAndDelete(resourceId)
+	if !ok {
+		return fmt.Errorf("capture not running: %s", resourceId)
+	}
+	__capturing.Add(-1)
+	c := v.(*capture)
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	glogger.GLogger.Info("Traffic capture stopped:", resourceId, ", records:", c.records.Load())
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+func StopAll() {
+	__captures.Range(func(key, _ any) bool {
+		Stop(key.(string))
+		return true
+	})
+}
+
+/*
+*
+* 资源的消息进规则之前调用; 没有在抓包的时候直接返回
+*
+ */
+func Capture(resourceId string, msg *typex.Message) {
+	if __capturing.Load() == 0 {
+		return
+	}
+	v, ok := __captures.Load(resourceId)
+	if !ok {
+		return
+	}
+	record := Record{
+		Ts:         msg.Timestamp,
+		ResourceId: resourceId,
+		Quality:    msg.Quality,
+		Tags:       msg.Tags,
+	}
+	if msg.Bytes != nil {
+		record.Bytes = msg.Bytes
+	} else {
+		record.Data = msg.String()
+	}
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if err := v.(*capture).write(append(bytes, '\n')); err != nil {
+		glogger.GLogger.Error("Traffic capture write failed:", resourceId, ", error:", err)
+	}
+}
+
+func (c *capture) write(line []byte) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	if c.size > 0 && c.size+int64(len(line)) > __maxFileSize {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := c.file.Write(line)
+	c.size += int64(n)
+	c.records.Add(1)
+	return err
+}
+
+// 当前文件改名成 .1, 原来的 .1 改成 .2, 超过数量的删掉
+func (c *capture) rotate() error {
+	c.file.Close()
+	os.Remove(filePath(c.resourceId, __maxFiles-1))
+	for i := __maxFiles - 2; i >= 0; i-- {
+		os.Rename(filePath(c.resourceId, i), filePath(c.resourceId, i+1))
+	}
+	file, err := os.OpenFile(filePath(c.resourceId, 0), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		c.file = nil
+		return err
+	}
+	c.file = file
+	c.size = 0
+	return nil
+}
+
+func filePath(resourceId string, index int) string {
+	path := filepath.Join(__CaptureDir, resourceId+".jsonl")
+	if index > 0 {
+		path = fmt.Sprintf("%s.%d", path, index)
+	}
+	return path
+}
+
+// 抓包文件, 旧的在前面
+func files(resourceId string) []string {
+	paths := []string{}
+	for i := __maxFiles - 1; i >= 0; i-- {
+		if _, err := os.Stat(filePath(resourceId, i)); err == nil {
+			paths = append(paths, filePath(resourceId, i))
+		}
+	}
+	return paths
+}
+
+func Exists(resourceId string) bool {
+	return __validId.MatchString(resourceId) && len(files(resourceId)) > 0
+}
+
+/*
+*
+* 所有的抓包: 正在抓的和磁盘上已经有文件的
+*
+ */
+func List() []CaptureInfo {
+	infos := map[string]*CaptureInfo{}
+	entries, _ := os.ReadDir(__CaptureDir)
+	for _, entry := range entries {
+		matches := __fileName.FindStringSubmatch(entry.Name())
+		info, err := entry.Info()
+		if matches == nil || err != nil {
+			continue
+		}
+		resourceId := matches[1]
+		if infos[resourceId] == nil {
+			infos[resourceId] = &CaptureInfo{ResourceId: resourceId}
+		}
+		infos[resourceId].Size += info.Size()
+	}
+	__captures.Range(func(key, value any) bool {
+		c := value.(*capture)
+		if infos[c.resourceId] == nil {
+			infos[c.resourceId] = &CaptureInfo{ResourceId: c.resourceId}
+		}
+		info := infos[c.resourceId]
+		info.Running = true
+		info.StartAt = c.startAt.UnixMilli()
+		if !c.stopAt.IsZero() {
+			info.StopAt = c.stopAt.UnixMilli()
+		}
+		info.Records = c.records.Load()
+		return true
+	})
+	list := []CaptureInfo{}
+	for _, info := range infos {
+		list = append(list, *info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ResourceId < list[j].ResourceId
+	})
+	return list
+}
+
+/*
+*
+* 按时间顺序输出全部抓包文件, 下载用
+*
+ */
+func Export(w io.Writer, resourceId string) error {
+	paths := files(resourceId)
+	if !Exists(resourceId) {
+		return fmt.Errorf("capture not exists: %s", resourceId)
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 删除抓包文件, 正在抓的先停掉
+func Remove(resourceId string) error {
+	if !__validId.MatchString(resourceId) {
+		return fmt.Errorf("invalid resource id: %s", resourceId)
+	}
+	Stop(resourceId)
+	for i := 0; i < __maxFiles; i++ {
+		os.Remove(filePath(resourceId, i))
+	}
+	return nil
+}
+
+// 读取一个资源的全部抓包记录
+func Load(resourceId string) ([]Record, error) {
+	paths := files(resourceId)
+	if !Exists(resourceId) {
+		return nil, fmt.Errorf("capture not exists: %s", resourceId)
+	}
+	records := []Record{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		part, err := Parse(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, part...)
+	}
+	return records, nil
+}
+
+/*
+*
+* 解析下载下来的抓包文件, 最后一行可能没写完, 跳过
+*
+ */
+func Parse(r io.Reader) ([]Record, error) {
+	records := []Record{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	var broken error
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if broken != nil {
+			return nil, broken
+		}
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			broken = fmt.Errorf("invalid capture record at line %d: %w", line, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 && broken != nil {
+		return nil, errors.New("invalid capture file")
+	}
+	return records, nil
+}
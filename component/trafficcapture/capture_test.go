@@ -0,0 +1,81 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package trafficcapture
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/sirupsen/logrus"
+)
+
+// go test -timeout 30s -run ^Test_Traffic_Capture github.com/hootrhino/rhilex/component/trafficcapture -v -count=1
+func Test_Traffic_Capture(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	__CaptureDir = t.TempDir() + "/"
+	__maxFileSize = 100
+	__maxFiles = 2
+	if err := Start("../etc", 0); err == nil {
+		t.Fatal("invalid id should be rejected")
+	}
+	if err := Start("INEND1", 0); err != nil {
+		t.Fatal(err)
+	}
+	Capture("OTHER", typex.NewStringMessage("OTHER", "x"))
+	for i := 0; i < 10; i++ {
+		msg := typex.NewStringMessage("INEND1", `{"temp": 30}`)
+		msg.Timestamp = int64(1000 + i)
+		Capture("INEND1", msg)
+	}
+	Capture("INEND1", typex.NewBytesMessage("INEND1", []byte{0x01, 0x02}))
+	list := List()
+	if len(list) != 1 || !list[0].Running || list[0].Records != 11 {
+		t.Fatal("unexpected list", list)
+	}
+	if err := Stop("INEND1"); err != nil {
+		t.Fatal(err)
+	}
+	// 只保留最新的两个文件, 老的记录被轮转掉了
+	records, err := Load("INEND1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || len(records) >= 11 {
+		t.Fatal("unexpected records", len(records))
+	}
+	last := records[len(records)-1].Message()
+	if string(last.Bytes) != "\x01\x02" || last.ResourceId != "INEND1" {
+		t.Fatal("unexpected last record", last)
+	}
+	first := records[0].Message()
+	if first.String() != `{"temp": 30}` || first.Timestamp < 1000 {
+		t.Fatal("unexpected first record", first)
+	}
+	buffer := bytes.Buffer{}
+	if err := Export(&buffer, "INEND1"); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := Parse(&buffer)
+	if err != nil || len(exported) != len(records) {
+		t.Fatal("unexpected export", err, len(exported))
+	}
+	Remove("INEND1")
+	if Exists("INEND1") {
+		t.Fatal("capture should be removed")
+	}
+}
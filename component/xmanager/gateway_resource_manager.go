@@ -44,6 +44,11 @@ func NewGatewayResourceManager(rhilex typex.Rhilex) *GatewayResourceManager {
 	}
 }
 
+// Rhilex 资源里要访问设备的时候用
+func (m *GatewayResourceManager) Rhilex() typex.Rhilex {
+	return m.rhilex
+}
+
 // RegisterType 注册资源类型和其对应的 worker 实现
 func (m *GatewayResourceManager) RegisterType(resourceType string,
 	factory func(m *GatewayResourceManager) (GatewayResource, error)) {
@@ -62,7 +67,7 @@ func (m *GatewayResourceManager) LoadResource(uuid string, name string, resource
 		return fmt.Errorf("unsupported resource type: %s", resourceType)
 	}
 	for _, resource := range m.resources.Values() {
-		if resource.Name == name {
+		if resource.Name == name && resource.UUID != uuid {
 			return fmt.Errorf("resource name already exists: %s", name)
 		}
 	}
@@ -94,8 +99,8 @@ func (m *GatewayResourceManager) LoadResource(uuid string, name string, resource
 // ReloadResource 重启资源
 func (m *GatewayResourceManager) ReloadResource(uuid string) error {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
 	worker, exists := m.resources.Get(uuid)
+	m.mu.RUnlock()
 	if !exists {
 		return fmt.Errorf("resource not found: %s", uuid)
 	}
@@ -37,22 +37,37 @@ func InitGlobalConfig(path string) typex.RhilexConfig {
 		os.Exit(1)
 	}
 	GlobalConfig = typex.RhilexConfig{
-		AppId:                 "rhilex",
-		IniPath:               path,
-		MaxQueueSize:          10240,
-		SourceRestartInterval: 5000,
-		GomaxProcs:            0,
-		EnablePProf:           false,
-		EnableConsole:         false,
-		DebugMode:             false,
-		LogLevel:              "info",
-		LogMaxSize:            5,    // MB
-		LogMaxBackups:         5,    // Per
-		LogMaxAge:             7,    // days
-		LogCompress:           true, // Compress
-		MaxKvStoreSize:        1024, // 20MB
-		ExtLibs:               []string{},
-		DataSchemaSecret:      []string{"rhilex-secret"},
+		AppId:                  "rhilex",
+		IniPath:                path,
+		MaxQueueSize:           10240,
+		SourceRestartInterval:  5000,
+		GomaxProcs:             0,
+		EnablePProf:            false,
+		EnableConsole:          false,
+		DebugMode:              false,
+		LogLevel:               "info",
+		LogMaxSize:             5,    // MB
+		LogMaxBackups:          5,    // Per
+		LogMaxAge:              7,    // days
+		LogCompress:            true, // Compress
+		MaxKvStoreSize:         1024, // 20MB
+		ExtLibs:                []string{},
+		DataSchemaSecret:       []string{"rhilex-secret"},
+		RuleExecuteTimeout:     5000,
+		RuleMaxInstructions:    0,
+		RuleMaxRegistrySize:    1024 * 1024,
+		RuleCallStackSize:      256,
+		RuleSuspendThreshold:   3,
+		RuleVMPoolSize:         1,
+		WindowSnapshotInterval: 5000,
+		CaptureMaxFileSize:     4, // MB
+		CaptureMaxFiles:        3,
+		AppletTrustedKeys:      []string{},
+		AppletRequireSignature: false,
+		InQueueWorkers:         10,
+		DeviceQueueWorkers:     10,
+		StreamQueueWorkers:     4,
+		OutQueueWorkers:        10,
 	}
 	if err := cfg.Section("main").MapTo(&GlobalConfig); err != nil {
 		log.Fatalf("[RHILEX INIT] Fail to map config file: %v", err)
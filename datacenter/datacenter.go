@@ -16,6 +16,8 @@
 package datacenter
 
 import (
+	"time"
+
 	core "github.com/hootrhino/rhilex/config"
 	"github.com/hootrhino/rhilex/typex"
 )
@@ -41,8 +43,11 @@ func InitDataCenter(rhilex typex.Rhilex) {
 		secrets[v] = true
 	}
 	InitDataCenterDb(rhilex)
+	DataCenterDbRegisterModel(&MDataCenterRetention{})
+	DataCenterDbRegisterModel(&MDataCenterSync{})
 	loadSecrets(secrets)
 	go StartClearDataCenterCron()
+	StartDataCenterSync(rhilex)
 }
 func loadSecrets(secrets map[string]bool) {
 	__DefaultDataCenter.secrets = secrets
@@ -51,3 +56,19 @@ func CheckSecrets(secret string) bool {
 	_, ok := __DefaultDataCenter.secrets[secret]
 	return ok
 }
+
+/*
+*
+* 往数据模型的表里写一行, key 是列名; create_at 统一存成不带时区的本地时间字符串(TimeLayout),
+* 没有填的用当前时间. 汇总, 分组和同步都按这个格式算, 带时区的话会被 strftime 换成 UTC
+*
+ */
+func InsertSchemaRow(schemaUuid string, row map[string]any) error {
+	switch T := row["create_at"].(type) {
+	case nil:
+		row["create_at"] = time.Now().Format(TimeLayout)
+	case time.Time:
+		row["create_at"] = T.In(time.Local).Format(TimeLayout)
+	}
+	return DataCenterDb().Table("data_center_" + schemaUuid).Create(row).Error
+}
@@ -17,57 +17,64 @@ package datacenter
 
 import (
 	"context"
-	"fmt"
+	"sync"
 	"time"
 
-	core "github.com/hootrhino/rhilex/config"
 	"github.com/hootrhino/rhilex/glogger"
 )
 
-// period
-// - `-n day`：表示当前日期减去n天
-// - `-n month`：表示当前日期减去n个月
-// - `-n year`：表示当前日期减去n年
-// - `-n hour`：表示当前日期减去n小时
-// - `-n minute`：表示当前日期减去n分钟
-// - `-n second`：表示当前日期减去n秒
+var (
+	__CronLocker sync.Mutex
+	__CronCancel context.CancelFunc
+)
+
+/*
+*
+* 每分钟汇总一次, 再按保留策略清理; 重复启动会先停掉上一个
+*
+ */
 func StartClearDataCenterCron() {
+	ctx, cancel := context.WithCancel(context.Background())
+	__CronLocker.Lock()
+	if __CronCancel != nil {
+		__CronCancel()
+	}
+	__CronCancel = cancel
+	__CronLocker.Unlock()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
 	for {
+		execDataCenterCron(time.Now())
 		select {
-		case <-context.Background().Done():
+		case <-ctx.Done():
 			return
-		default:
-		}
-		if core.GlobalConfig.DebugMode {
-			execDataCenterCron(`-1 day`)
-			time.Sleep(60 * time.Second) // For test
-		} else {
-			execDataCenterCron(`-1 day`)
-			time.Sleep(24 * time.Hour)
+		case <-ticker.C:
 		}
 	}
 }
-func execDataCenterCron(period string) {
-	sql := `SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'data_center_%';`
-	tables := []string{}
-	err := DataCenterDb().Raw(sql).Scan(&tables).Error
+
+func StopClearDataCenterCron() {
+	__CronLocker.Lock()
+	defer __CronLocker.Unlock()
+	if __CronCancel != nil {
+		__CronCancel()
+		__CronCancel = nil
+	}
+}
+
+func execDataCenterCron(now time.Time) {
+	uuids, err := schemaTables()
 	if err != nil {
 		glogger.GLogger.Error(err)
 		return
 	}
-	glogger.GLogger.Debug("ExecDataCenterCron:", sql)
-
-	//
-	// 删除period之前的数据
-	// DELETE FROM %s WHERE create_at < date('now', '$s');
-	//
-	for _, table := range tables {
-		deleteSql := fmt.Sprintf("DELETE FROM %s WHERE create_at < date('now', '%s');", table, period)
-		ExecError := DataCenterDb().Exec(deleteSql).Error
-		if ExecError != nil {
-			glogger.GLogger.Error(ExecError)
+	for _, uuid := range uuids {
+		// 先汇总再清理, 清掉的原始数据已经进了汇总表
+		if err := RollupSchema(uuid, now); err != nil {
+			glogger.GLogger.Error("ExecDataCenterCron rollup:", uuid, err)
+		}
+		if err := ApplyRetention(uuid, now); err != nil {
+			glogger.GLogger.Error("ExecDataCenterCron retention:", uuid, err)
 		}
-		glogger.GLogger.Debug("ExecDataCenterCron:", deleteSql)
 	}
-	// DB().Exec("VACUUM;")
 }
@@ -18,6 +18,7 @@ package datacenter
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/hootrhino/rhilex/glogger"
@@ -83,6 +84,27 @@ func GenerateSQLiteCreateTableDDL(schemaDDL SchemaDDL) (string, error) {
 			} else {
 				columnDefine += " NOT NULL DEFAULT 0"
 			}
+		// 枚举值和时间戳都是整数, 默认值不是整数的直接报错, 不拼进建表语句
+		case "ENUM", "TIMESTAMP":
+			DefaultValue := int64(0)
+			if column.DefaultValue != "" {
+				v, err := strconv.ParseInt(strings.TrimSpace(column.DefaultValue), 10, 64)
+				if err != nil {
+					return "", fmt.Errorf("column %s default value must be integer: %s", column.Name, column.DefaultValue)
+				}
+				DefaultValue = v
+			}
+			columnDefine += fmt.Sprintf(" NOT NULL DEFAULT %d", DefaultValue)
+		// 结构体和数组按 JSON 文本存
+		case "STRUCT", "ARRAY":
+			DefaultValue := column.DefaultValue
+			if DefaultValue == "" || DefaultValue == "0" {
+				DefaultValue = "{}"
+				if column.Type == "ARRAY" {
+					DefaultValue = "[]"
+				}
+			}
+			columnDefine += " NOT NULL DEFAULT " + fmt.Sprintf("'%s'", strings.ReplaceAll(DefaultValue, "'", "''"))
 		case "DATETIME":
 			columnDefine += " NOT NULL DEFAULT CURRENT_TIMESTAMP"
 		}
@@ -110,7 +132,7 @@ func SqliteTypeMappingSchemaType(goType string) string {
 	switch goType {
 	case "STRING":
 		return "TEXT"
-	case "INTEGER":
+	case "INTEGER", "ENUM", "TIMESTAMP":
 		return "INTEGER"
 	case "FLOAT":
 		return "REAL"
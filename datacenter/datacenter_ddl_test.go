@@ -0,0 +1,71 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"testing"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// go test -timeout 30s -run ^Test_DataCenter_DDLDefault github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_DataCenter_DDLDefault(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, err := GenerateSQLiteCreateTableDDL(SchemaDDL{
+		SchemaUUID: "t1",
+		DDLColumns: []DDLColumn{
+			{Name: "id", Type: "INTEGER"},
+			{Name: "mode", Type: "ENUM", DefaultValue: "2"},
+			{Name: "at", Type: "TIMESTAMP"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(sql).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`INSERT INTO t1 (id) VALUES (1)`).Error; err != nil {
+		t.Fatal(err)
+	}
+	row := struct {
+		Mode int64
+		At   int64
+	}{}
+	db.Raw(`SELECT mode, at FROM t1`).Scan(&row)
+	if row.Mode != 2 || row.At != 0 {
+		t.Fatal("unexpected defaults", row)
+	}
+	// 默认值不是整数的不能拼进建表语句
+	for _, bad := range []DDLColumn{
+		{Name: "mode", Type: "ENUM", DefaultValue: "0'); DROP TABLE t1; --"},
+		{Name: "at", Type: "TIMESTAMP", DefaultValue: "2025-01-01"},
+		{Name: "mode", Type: "ENUM", DefaultValue: "1.5"},
+	} {
+		if _, err := GenerateSQLiteCreateTableDDL(SchemaDDL{SchemaUUID: "t2", DDLColumns: []DDLColumn{bad}}); err == nil {
+			t.Fatal("should fail:", bad)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+/*
+*
+* 导出查询结果: csv, xlsx, parquet
+*
+ */
+func ExportResult(w io.Writer, format string, result QueryResult) error {
+	switch format {
+	case "", "xlsx":
+		return WriteXLSX(w, result)
+	case "csv":
+		return WriteCSV(w, result)
+	case "parquet":
+		return WriteParquet(w, result)
+	}
+	return fmt.Errorf("unsupported export format: %s", format)
+}
+
+func WriteCSV(w io.Writer, result QueryResult) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(result.Columns); err != nil {
+		return err
+	}
+	line := make([]string, len(result.Columns))
+	for _, row := range result.Rows {
+		for i, column := range result.Columns {
+			line[i] = csvValue(row[column])
+		}
+		if err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvValue(value any) string {
+	switch T := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(T, 'f', -1, 64)
+	case bool:
+		if T {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func WriteXLSX(w io.Writer, result QueryResult) error {
+	xlsx := excelize.NewFile()
+	defer xlsx.Close()
+	cell, _ := excelize.CoordinatesToCellName(1, 1)
+	if err := xlsx.SetSheetRow("Sheet1", cell, &result.Columns); err != nil {
+		return err
+	}
+	for idx, row := range result.Rows {
+		SheetRow := make([]any, len(result.Columns))
+		for i, column := range result.Columns {
+			SheetRow[i] = row[column]
+		}
+		cell, _ = excelize.CoordinatesToCellName(1, idx+2)
+		if err := xlsx.SetSheetRow("Sheet1", cell, &SheetRow); err != nil {
+			return err
+		}
+	}
+	return xlsx.Write(w)
+}
+
+/*
+*
+* Parquet 的列类型按值推断: 全是整数是 INT64, 有小数是 DOUBLE, 布尔是 BOOLEAN, 其余都是字符串
+*
+ */
+type parquetKind int
+
+const (
+	parquetNull parquetKind = iota
+	parquetInt
+	parquetDouble
+	parquetBool
+	parquetString
+)
+
+func parquetKindOf(value any) parquetKind {
+	switch value.(type) {
+	case nil:
+		return parquetNull
+	case int, int32, int64:
+		return parquetInt
+	case float32, float64:
+		return parquetDouble
+	case bool:
+		return parquetBool
+	}
+	return parquetString
+}
+
+func parquetColumnKind(rows []map[string]any, column string) parquetKind {
+	kind := parquetNull
+	for _, row := range rows {
+		k := parquetKindOf(row[column])
+		switch {
+		case k == parquetNull || k == kind:
+		case kind == parquetNull:
+			kind = k
+		case (kind == parquetInt && k == parquetDouble) || (kind == parquetDouble && k == parquetInt):
+			kind = parquetDouble
+		default:
+			return parquetString
+		}
+	}
+	return kind
+}
+
+func parquetValue(kind parquetKind, value any) any {
+	if value == nil {
+		return nil
+	}
+	switch kind {
+	case parquetInt:
+		switch T := value.(type) {
+		case int:
+			return int64(T)
+		case int32:
+			return int64(T)
+		}
+		return value
+	case parquetDouble:
+		switch T := value.(type) {
+		case int:
+			return float64(T)
+		case int32:
+			return float64(T)
+		case int64:
+			return float64(T)
+		case float32:
+			return float64(T)
+		}
+		return value
+	case parquetBool:
+		return value
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return csvValue(value)
+}
+
+func WriteParquet(w io.Writer, result QueryResult) error {
+	group := parquet.Group{}
+	kinds := make([]parquetKind, len(result.Columns))
+	for i, column := range result.Columns {
+		kinds[i] = parquetColumnKind(result.Rows, column)
+		switch kinds[i] {
+		case parquetInt:
+			group[column] = parquet.Optional(parquet.Int(64))
+		case parquetDouble:
+			group[column] = parquet.Optional(parquet.Leaf(parquet.DoubleType))
+		case parquetBool:
+			group[column] = parquet.Optional(parquet.Leaf(parquet.BooleanType))
+		default:
+			kinds[i] = parquetString
+			group[column] = parquet.Optional(parquet.String())
+		}
+	}
+	writer := parquet.NewWriter(w, parquet.NewSchema("data_center", group))
+	for _, row := range result.Rows {
+		record := make(map[string]any, len(result.Columns))
+		for i, column := range result.Columns {
+			record[column] = parquetValue(kinds[i], row[column])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
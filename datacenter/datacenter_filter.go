@@ -0,0 +1,370 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+*
+* 过滤表达式: SQL WHERE 的一个安全子集, 例如
+*   temp > 20.5 AND (on = true OR label LIKE 'a%') AND id NOT IN (1, 2)
+* 支持: = == != <> < <= > >=, AND OR NOT, IN, BETWEEN, LIKE, IS [NOT] NULL, 括号;
+* 列名只能是表里的列, 值全部走参数绑定, 不会拼进 SQL
+*
+ */
+const (
+	maxFilterLength = 2048
+	maxFilterDepth  = 32
+)
+
+type filterTokenKind int
+
+const (
+	tokenEOF filterTokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type filterToken struct {
+	kind  filterTokenKind
+	text  string
+	value any
+	pos   int
+}
+
+func tokenizeFilter(s string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, filterToken{kind: tokenComma, text: ",", pos: i})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			op := string(r)
+			if i+1 < len(runes) && strings.ContainsRune("=>", runes[i+1]) {
+				op += string(runes[i+1])
+			}
+			switch op {
+			case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("invalid operator '%s' at %d", op, i)
+			}
+			tokens = append(tokens, filterToken{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		case r == '\'':
+			// 字符串, '' 转义单引号
+			sb := strings.Builder{}
+			j := i + 1
+			for ; j < len(runes); j++ {
+				if runes[j] == '\'' {
+					if j+1 < len(runes) && runes[j+1] == '\'' {
+						sb.WriteRune('\'')
+						j++
+						continue
+					}
+					break
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: sb.String(), value: sb.String(), pos: i})
+			i = j + 1
+		case r == '`' || r == '"':
+			// 带引号的列名
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated identifier at %d", i)
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: string(runes[i+1 : j]), value: true, pos: i})
+			i = j + 1
+		case unicode.IsDigit(r) || ((r == '-' || r == '.') && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || strings.ContainsRune(".eE", runes[j]) ||
+				((runes[j] == '-' || runes[j] == '+') && (runes[j-1] == 'e' || runes[j-1] == 'E'))) {
+				j++
+			}
+			text := string(runes[i:j])
+			var value any
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				value = n
+			} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+				value = f
+			} else {
+				return nil, fmt.Errorf("invalid number '%s' at %d", text, i)
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: text, value: value, pos: i})
+			i = j
+		case r == '_' || unicode.IsLetter(r):
+			j := i + 1
+			for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: string(runes[i:j]), pos: i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected '%c' at %d", r, i)
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+type filterParser struct {
+	tokens  []filterToken
+	pos     int
+	depth   int
+	columns []string
+	args    []any
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// 关键字不区分大小写, 带引号的是列名不是关键字
+func (p *filterParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenIdent && t.value == nil && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("filter: %s at %d", fmt.Sprintf(format, a...), p.peek().pos)
+}
+
+func (p *filterParser) parseOr() (string, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxFilterDepth {
+		return "", p.errorf("too deep")
+	}
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = left + " OR " + right
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (string, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return "", err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		left = left + " AND " + right
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (string, error) {
+	if p.keyword("NOT") {
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxFilterDepth {
+			return "", p.errorf("too deep")
+		}
+		inner, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (string, error) {
+	if p.peek().kind == tokenLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if p.next().kind != tokenRParen {
+			return "", p.errorf("expect ')'")
+		}
+		return "(" + inner + ")", nil
+	}
+	t := p.next()
+	if t.kind != tokenIdent {
+		return "", p.errorf("expect column")
+	}
+	if !slices.Contains(p.columns, t.text) {
+		return "", fmt.Errorf("filter: unknown column '%s'", t.text)
+	}
+	column := fmt.Sprintf("`%s`", t.text)
+	if op := p.peek(); op.kind == tokenOperator {
+		p.next()
+		if err := p.parseValue(); err != nil {
+			return "", err
+		}
+		if op.text == "==" {
+			op.text = "="
+		}
+		return fmt.Sprintf("%s %s ?", column, op.text), nil
+	}
+	if p.keyword("IS") {
+		if p.keyword("NOT") {
+			if !p.keyword("NULL") {
+				return "", p.errorf("expect NULL")
+			}
+			return column + " IS NOT NULL", nil
+		}
+		if !p.keyword("NULL") {
+			return "", p.errorf("expect NULL")
+		}
+		return column + " IS NULL", nil
+	}
+	not := ""
+	if p.keyword("NOT") {
+		not = "NOT "
+	}
+	switch {
+	case p.keyword("IN"):
+		if p.next().kind != tokenLParen {
+			return "", p.errorf("expect '('")
+		}
+		holders := []string{}
+		for {
+			if err := p.parseValue(); err != nil {
+				return "", err
+			}
+			holders = append(holders, "?")
+			if p.peek().kind == tokenComma {
+				p.next()
+				continue
+			}
+			break
+		}
+		if p.next().kind != tokenRParen {
+			return "", p.errorf("expect ')'")
+		}
+		return fmt.Sprintf("%s %sIN (%s)", column, not, strings.Join(holders, ", ")), nil
+	case p.keyword("BETWEEN"):
+		if err := p.parseValue(); err != nil {
+			return "", err
+		}
+		if !p.keyword("AND") {
+			return "", p.errorf("expect AND")
+		}
+		if err := p.parseValue(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %sBETWEEN ? AND ?", column, not), nil
+	case p.keyword("LIKE"):
+		t := p.next()
+		if t.kind != tokenString {
+			return "", p.errorf("LIKE expect string")
+		}
+		p.args = append(p.args, t.value)
+		return fmt.Sprintf("%s %sLIKE ?", column, not), nil
+	}
+	return "", p.errorf("expect operator")
+}
+
+func (p *filterParser) parseValue() error {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber, tokenString:
+		p.args = append(p.args, t.value)
+		return nil
+	case tokenIdent:
+		if t.value == nil {
+			switch strings.ToUpper(t.text) {
+			case "TRUE":
+				p.args = append(p.args, 1)
+				return nil
+			case "FALSE":
+				p.args = append(p.args, 0)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("filter: expect value at %d", t.pos)
+}
+
+/*
+*
+* 编译过滤表达式, 返回带 ? 的 SQL 片段和参数; 空表达式返回空串
+*
+ */
+func CompileFilter(filter string, columns []string) (string, []any, error) {
+	if strings.TrimSpace(filter) == "" {
+		return "", nil, nil
+	}
+	if len(filter) > maxFilterLength {
+		return "", nil, fmt.Errorf("filter too long, must less than %d", maxFilterLength)
+	}
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return "", nil, fmt.Errorf("filter: %s", err)
+	}
+	p := &filterParser{tokens: tokens, columns: columns, args: []any{}}
+	sql, err := p.parseOr()
+	if err != nil {
+		return "", nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return "", nil, p.errorf("unexpected '%s'", p.peek().text)
+	}
+	return sql, p.args, nil
+}
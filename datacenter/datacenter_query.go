@@ -0,0 +1,175 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 一次查询最多返回多少个时间段
+const MaxAggregateBuckets = 10000
+
+/*
+*
+* 聚合查询: [Start, End) 按 Interval 分桶, 每个桶对 Fields 求 Func(min|max|avg|count)
+*
+ */
+type AggregateQuery struct {
+	Fields   []string
+	Start    time.Time // 本地时间
+	End      time.Time
+	Interval time.Duration
+	Func     string
+}
+
+// 时间段间隔, 除了 Go 的 time.Duration 格式还支持 d 表示天, 比如 7d
+func ParseInterval(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval: %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval: %s", s)
+	}
+	return d, nil
+}
+
+func ParseTime(s string) (time.Time, error) {
+	return time.ParseInLocation(TimeLayout, strings.TrimSpace(s), time.Local)
+}
+
+func (q AggregateQuery) validate() error {
+	switch q.Func {
+	case "min", "max", "avg", "count":
+	default:
+		return fmt.Errorf("unsupported aggregate function: %s", q.Func)
+	}
+	if q.Interval < time.Second || q.Interval%time.Second != 0 {
+		return fmt.Errorf("interval must be whole seconds")
+	}
+	if !q.Start.Before(q.End) {
+		return fmt.Errorf("start must be before end")
+	}
+	if q.End.Sub(q.Start)/q.Interval > MaxAggregateBuckets {
+		return fmt.Errorf("too many buckets, must less than %d", MaxAggregateBuckets)
+	}
+	return nil
+}
+
+/*
+*
+* 选数据源: 用能整除 interval 的最粗的汇总层, 它还没算到的部分依次用更细的层和原始数据补上
+*
+ */
+func aggregateSources(schemaUuid string, start, end time.Time, interval time.Duration) []rollupSource {
+	tier := -1
+	for i, t := range RollupTiers {
+		if interval >= t.Interval && interval%t.Interval == 0 {
+			tier = i
+		}
+	}
+	sources := []rollupSource{}
+	lo := start
+	for i := tier; i >= 0; i-- {
+		hi := rollupCoverage(schemaUuid, RollupTiers[i])
+		if hi.After(end) {
+			hi = end
+		}
+		if hi.After(lo) {
+			sources = append(sources, rollupSource{tier: i, start: lo, end: hi})
+			lo = hi
+		}
+	}
+	if lo.Before(end) {
+		sources = append(sources, rollupSource{tier: -1, start: lo, end: end})
+	}
+	return sources
+}
+
+/*
+*
+* 返回 [{"bucket":"2006-01-02 15:04:05", "<field>": value}], 没数据的时间段不返回
+*
+ */
+func QueryAggregate(schemaUuid string, q AggregateQuery) ([]map[string]any, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	columns, err := NumericColumns(schemaUuid)
+	if err != nil {
+		return nil, err
+	}
+	fields := q.Fields
+	if len(fields) == 0 {
+		fields = columns
+	}
+	for _, field := range fields {
+		if !slices.Contains(columns, field) {
+			return nil, fmt.Errorf("field not numeric or not exists: %s", field)
+		}
+	}
+	results := []map[string]any{}
+	if len(fields) == 0 {
+		return results, nil
+	}
+	if err := ensureRollupTables(schemaUuid); err != nil {
+		return nil, err
+	}
+	start, end := wallClock(q.Start), wallClock(q.End)
+	sql, args := aggregateSql(schemaUuid, fields,
+		aggregateSources(schemaUuid, start, end, q.Interval), q.Interval)
+	type aggregateRow struct {
+		Bucket string
+		Name   string
+		Min    float64
+		Max    float64
+		Avg    float64
+		Count  int64
+	}
+	rows := []aggregateRow{}
+	if err := DataCenterDb().Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	byBucket := map[string]map[string]any{}
+	for _, row := range rows {
+		result, ok := byBucket[row.Bucket]
+		if !ok {
+			result = map[string]any{"bucket": row.Bucket}
+			byBucket[row.Bucket] = result
+			results = append(results, result)
+		}
+		switch q.Func {
+		case "min":
+			result[row.Name] = row.Min
+		case "max":
+			result[row.Name] = row.Max
+		case "avg":
+			result[row.Name] = row.Avg
+		case "count":
+			result[row.Name] = row.Count
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"gorm.io/gorm"
+)
+
+/*
+*
+* 保留策略: 每个数据模型单独配置, 单位是天, 0 表示不删除;
+* MaxRows 是原始数据最多保留多少行, 防止采集太快撑死数据库, 0 表示不限制
+*
+ */
+type RetentionPolicy struct {
+	Raw     int `json:"raw"`     // 原始数据
+	Minute  int `json:"minute"`  // 1分钟汇总
+	Hour    int `json:"hour"`    // 1小时汇总
+	Day     int `json:"day"`     // 1天汇总
+	MaxRows int `json:"maxRows"` // 原始数据最大行数
+}
+
+// 没配置过的模型用默认策略
+var DefaultRetentionPolicy = RetentionPolicy{
+	Raw:     7,
+	Minute:  30,
+	Hour:    365,
+	Day:     0,
+	MaxRows: 10000,
+}
+
+func (p RetentionPolicy) Validate() error {
+	if p.Raw < 0 || p.Minute < 0 || p.Hour < 0 || p.Day < 0 || p.MaxRows < 0 {
+		return fmt.Errorf("retention must not be negative")
+	}
+	return nil
+}
+
+// 数据中心库里的策略表
+type MDataCenterRetention struct {
+	ID       uint   `gorm:"primarykey"`
+	SchemaId string `gorm:"not null;uniqueIndex"`
+	Raw      int    `gorm:"not null"`
+	Minute   int    `gorm:"not null"`
+	Hour     int    `gorm:"not null"`
+	Day      int    `gorm:"not null"`
+	MaxRows  int    `gorm:"not null"`
+}
+
+func GetRetentionPolicy(schemaUuid string) RetentionPolicy {
+	MRetention := MDataCenterRetention{}
+	if err := DataCenterDb().Where("schema_id=?", schemaUuid).First(&MRetention).Error; err != nil {
+		return DefaultRetentionPolicy
+	}
+	return RetentionPolicy{
+		Raw:     MRetention.Raw,
+		Minute:  MRetention.Minute,
+		Hour:    MRetention.Hour,
+		Day:     MRetention.Day,
+		MaxRows: MRetention.MaxRows,
+	}
+}
+
+func SetRetentionPolicy(schemaUuid string, policy RetentionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	MRetention := MDataCenterRetention{
+		SchemaId: schemaUuid,
+		Raw:      policy.Raw,
+		Minute:   policy.Minute,
+		Hour:     policy.Hour,
+		Day:      policy.Day,
+		MaxRows:  policy.MaxRows,
+	}
+	return DataCenterDb().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("schema_id=?", schemaUuid).Delete(&MDataCenterRetention{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&MRetention).Error
+	})
+}
+
+/*
+*
+* 按策略清理一个模型的原始数据和汇总数据
+*
+ */
+func ApplyRetention(schemaUuid string, now time.Time) error {
+	policy := GetRetentionPolicy(schemaUuid)
+	table := RawTable(schemaUuid)
+	// 以前用触发器限制行数, 现在由策略里的 MaxRows 管
+	if err := DataCenterDb().Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS "%s";`, table)).Error; err != nil {
+		return err
+	}
+	if policy.Raw > 0 {
+		sql := fmt.Sprintf(`DELETE FROM "%s" WHERE create_at < ?`, table)
+		args := []any{wallClock(now).AddDate(0, 0, -policy.Raw).Format(TimeLayout)}
+		// 过期但还没同步出去的数据先留着, 等同步任务推走; MaxRows 仍然是硬上限
+		if mark, ok := syncedMark(schemaUuid); ok {
+			sql += " AND id <= ?"
+			args = append(args, mark)
+		}
+		if err := DataCenterDb().Exec(sql+";", args...).Error; err != nil {
+			return err
+		}
+	}
+	if policy.MaxRows > 0 {
+		sql := `DELETE FROM "%s" WHERE id <= (SELECT id FROM "%s" ORDER BY id DESC LIMIT 1 OFFSET ?);`
+		if err := DataCenterDb().Exec(fmt.Sprintf(sql, table, table), policy.MaxRows).Error; err != nil {
+			return err
+		}
+	}
+	for _, tier := range RollupTiers {
+		days := tier.retention(policy)
+		if days <= 0 {
+			continue
+		}
+		sql := fmt.Sprintf(`DELETE FROM "%s" WHERE bucket < ?;`, tier.Table(schemaUuid))
+		if err := DataCenterDb().Exec(sql, wallClock(now).AddDate(0, 0, -days).Format(TimeLayout)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+*
+* 删除模型的全部数据: 原始表, 汇总表, 策略
+*
+ */
+func DropSchemaTables(schemaUuid string) error {
+	tables := []string{RawTable(schemaUuid)}
+	for _, tier := range RollupTiers {
+		tables = append(tables, tier.Table(schemaUuid))
+	}
+	for _, table := range tables {
+		if err := DataCenterDb().Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s";`, table)).Error; err != nil {
+			return err
+		}
+	}
+	if err := DataCenterDb().Where("schema_id=?", schemaUuid).Delete(&MDataCenterRetention{}).Error; err != nil {
+		glogger.GLogger.Error("delete retention failed:", schemaUuid, err)
+	}
+	if err := DeleteSchemaSyncJobs(schemaUuid); err != nil {
+		glogger.GLogger.Error("delete sync jobs failed:", schemaUuid, err)
+	}
+	return nil
+}
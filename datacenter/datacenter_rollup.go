@@ -0,0 +1,273 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// create_at 的格式, 存的是本地时间
+const TimeLayout = "2006-01-02 15:04:05"
+
+/*
+*
+* 汇总层级: 1m 由原始数据算, 1h 由 1m 算, 1d 由 1h 算;
+* 汇总表是竖表, 每个时间段每个数值列一行: bucket, name, min, max, avg, count
+*
+ */
+type RollupTier struct {
+	Name     string
+	Interval time.Duration
+}
+
+var RollupTiers = []RollupTier{
+	{Name: "1m", Interval: time.Minute},
+	{Name: "1h", Interval: time.Hour},
+	{Name: "1d", Interval: 24 * time.Hour},
+}
+
+func RawTable(schemaUuid string) string {
+	return "data_center_" + schemaUuid
+}
+
+func (t RollupTier) Table(schemaUuid string) string {
+	return fmt.Sprintf("data_center_rollup_%s_%s", t.Name, schemaUuid)
+}
+
+func (t RollupTier) retention(p RetentionPolicy) int {
+	switch t.Name {
+	case "1m":
+		return p.Minute
+	case "1h":
+		return p.Hour
+	}
+	return p.Day
+}
+
+// 汇总表名也是 data_center_ 开头, 找模型表的时候要排除
+func isRollupTable(table string) bool {
+	return strings.HasPrefix(table, "data_center_rollup_")
+}
+
+/*
+*
+* 本地时间当成 UTC 处理: create_at 是本地时间字符串, SQLite 的 strftime('%s') 按 UTC 解析,
+* 两边一致的话按天分桶正好是本地的零点
+*
+ */
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+func parseWallClock(s string) (time.Time, error) {
+	if len(s) > len(TimeLayout) {
+		s = s[:len(TimeLayout)]
+	}
+	return time.ParseInLocation(TimeLayout, s, time.UTC)
+}
+
+func ensureRollupTables(schemaUuid string) error {
+	for _, tier := range RollupTiers {
+		sql := `CREATE TABLE IF NOT EXISTS "%s" (
+bucket DATETIME NOT NULL,
+name TEXT NOT NULL,
+min REAL,
+max REAL,
+avg REAL,
+count INTEGER NOT NULL DEFAULT 0,
+PRIMARY KEY (bucket, name)
+);`
+		if err := DataCenterDb().Exec(fmt.Sprintf(sql, tier.Table(schemaUuid))).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 原始表里能汇总的列: 整数, 浮点, 布尔
+func NumericColumns(schemaUuid string) ([]string, error) {
+	rows, err := DataCenterDb().Raw(fmt.Sprintf(`PRAGMA table_info("%s");`, RawTable(schemaUuid))).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := []string{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, dataType string
+		var defaultValue any
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		if name == "id" {
+			continue
+		}
+		switch strings.ToUpper(dataType) {
+		case "INTEGER", "REAL", "BOOLEAN":
+			columns = append(columns, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 && !tableExists(RawTable(schemaUuid)) {
+		return nil, fmt.Errorf("data center table not exists: %s", schemaUuid)
+	}
+	return columns, nil
+}
+
+func tableExists(table string) bool {
+	var count int64
+	DataCenterDb().Raw(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?;`, table).Scan(&count)
+	return count > 0
+}
+
+// 汇总层已经算到哪里了(不含), 没有数据返回零值
+func rollupCoverage(schemaUuid string, tier RollupTier) time.Time {
+	var last string
+	DataCenterDb().Raw(fmt.Sprintf(`SELECT COALESCE(MAX(bucket), '') FROM "%s";`,
+		tier.Table(schemaUuid))).Scan(&last)
+	t, err := parseWallClock(last)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.Add(tier.Interval)
+}
+
+/*
+*
+* 一段数据源, 统一成竖表: ts, name, min, max, avg, count
+*
+ */
+type rollupSource struct {
+	tier       int // -1 是原始表
+	start, end time.Time
+}
+
+func (s rollupSource) sql(schemaUuid string, columns []string) (string, []any) {
+	start, end := s.start.Format(TimeLayout), s.end.Format(TimeLayout)
+	if s.tier >= 0 {
+		names := make([]string, len(columns))
+		args := []any{}
+		for i, column := range columns {
+			names[i] = "?"
+			args = append(args, column)
+		}
+		args = append(args, start, end)
+		return fmt.Sprintf(`SELECT bucket AS ts, name, min, max, avg, count FROM "%s" `+
+			`WHERE name IN (%s) AND bucket >= ? AND bucket < ?`,
+			RollupTiers[s.tier].Table(schemaUuid), strings.Join(names, ",")), args
+	}
+	parts := []string{}
+	args := []any{}
+	for _, column := range columns {
+		parts = append(parts, fmt.Sprintf(`SELECT create_at AS ts, ? AS name, "%s" AS min, "%s" AS max, `+
+			`"%s" AS avg, 1 AS count FROM "%s" WHERE create_at >= ? AND create_at < ?`,
+			column, column, column, RawTable(schemaUuid)))
+		args = append(args, column, start, end)
+	}
+	return strings.Join(parts, " UNION ALL "), args
+}
+
+// 按 interval 分桶聚合若干段数据源
+func aggregateSql(schemaUuid string, columns []string, sources []rollupSource,
+	interval time.Duration) (string, []any) {
+	parts := []string{}
+	args := []any{}
+	for _, source := range sources {
+		sql, sourceArgs := source.sql(schemaUuid, columns)
+		parts = append(parts, sql)
+		args = append(args, sourceArgs...)
+	}
+	seconds := int64(interval / time.Second)
+	sql := fmt.Sprintf(`SELECT datetime((CAST(strftime('%%s', ts) AS INTEGER) / %d) * %d, 'unixepoch') AS bucket, `+
+		`name, MIN(min) AS min, MAX(max) AS max, SUM(avg * count) / SUM(count) AS avg, SUM(count) AS count `+
+		`FROM (%s) GROUP BY bucket, name ORDER BY bucket ASC`, seconds, seconds, strings.Join(parts, " UNION ALL "))
+	return sql, args
+}
+
+/*
+*
+* 汇总一个模型: 每层从上次算到的地方开始, 算到当前时间段之前(只算完整的时间段)
+*
+ */
+func RollupSchema(schemaUuid string, now time.Time) error {
+	if err := ensureRollupTables(schemaUuid); err != nil {
+		return err
+	}
+	columns, err := NumericColumns(schemaUuid)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	wall := wallClock(now)
+	for i, tier := range RollupTiers {
+		start := rollupCoverage(schemaUuid, tier)
+		if start.IsZero() {
+			// 第一次汇总从数据源最早的数据开始
+			var first string
+			if i == 0 {
+				DataCenterDb().Raw(fmt.Sprintf(`SELECT COALESCE(MIN(create_at), '') FROM "%s";`,
+					RawTable(schemaUuid))).Scan(&first)
+			} else {
+				DataCenterDb().Raw(fmt.Sprintf(`SELECT COALESCE(MIN(bucket), '') FROM "%s";`,
+					RollupTiers[i-1].Table(schemaUuid))).Scan(&first)
+			}
+			if start, err = parseWallClock(first); err != nil {
+				continue
+			}
+			start = start.Truncate(tier.Interval)
+		}
+		// 上一层刚刚算到了它自己的当前时间段, 这一层的完整时间段都在里面
+		end := wall.Truncate(tier.Interval)
+		if !start.Before(end) {
+			continue
+		}
+		sql, args := aggregateSql(schemaUuid, columns, []rollupSource{{tier: i - 1, start: start, end: end}},
+			tier.Interval)
+		insert := fmt.Sprintf(`INSERT OR REPLACE INTO "%s" (bucket, name, min, max, avg, count) %s`,
+			tier.Table(schemaUuid), sql)
+		if err := DataCenterDb().Exec(insert, args...).Error; err != nil {
+			return fmt.Errorf("rollup %s: %s", tier.Name, err)
+		}
+	}
+	return nil
+}
+
+/*
+*
+* 所有模型表
+*
+ */
+func schemaTables() ([]string, error) {
+	tables := []string{}
+	sql := `SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'data_center_%';`
+	if err := DataCenterDb().Raw(sql).Scan(&tables).Error; err != nil {
+		return nil, err
+	}
+	uuids := []string{}
+	for _, table := range tables {
+		if isRollupTable(table) {
+			continue
+		}
+		uuids = append(uuids, strings.TrimPrefix(table, "data_center_"))
+	}
+	return uuids, nil
+}
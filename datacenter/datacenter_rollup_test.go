@@ -0,0 +1,205 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func initTestDataCenter(t *testing.T, schemaUuid string) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	__DataCenterSqlite = &SqliteDAO{name: "Sqlite3", db: db}
+	DataCenterDbRegisterModel(&MDataCenterRetention{})
+	DataCenterDbRegisterModel(&MDataCenterSync{})
+	sql, _ := GenerateSQLiteCreateTableDDL(SchemaDDL{
+		SchemaUUID: RawTable(schemaUuid),
+		DDLColumns: []DDLColumn{
+			{Name: "id", Type: "INTEGER"},
+			{Name: "create_at", Type: "DATETIME"},
+			{Name: "temp", Type: "FLOAT"},
+			{Name: "on", Type: "BOOL"},
+			{Name: "label", Type: "STRING"},
+		},
+	})
+	if err := db.Exec(sql).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 每 20 秒一行, temp 是第几分钟, on 是偶数分钟
+func insertTestRows(t *testing.T, schemaUuid string, from time.Time, n int) {
+	for i := 0; i < n; i++ {
+		ts := from.Add(time.Duration(i) * 20 * time.Second)
+		minute := int(ts.Sub(from) / time.Minute)
+		if err := InsertSchemaRow(schemaUuid, map[string]any{
+			"create_at": ts.Format(TimeLayout),
+			"temp":      float64(minute),
+			"on":        minute%2 == 0,
+			"label":     "x",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func countRows(table string) int64 {
+	var count int64
+	DataCenterDb().Table(table).Count(&count)
+	return count
+}
+
+// go test -timeout 30s -run ^Test_DataCenter_Rollup github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_DataCenter_Rollup(t *testing.T) {
+	initTestDataCenter(t, "SCHEMA1")
+	from := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	insertTestRows(t, "SCHEMA1", from, 3*180+2) // 10:00:00 - 13:00:20
+	columns, err := NumericColumns("SCHEMA1")
+	if err != nil || len(columns) != 2 {
+		t.Fatal("unexpected columns", columns, err)
+	}
+	now := from.Add(3*time.Hour + 30*time.Second)
+	for i := 0; i < 2; i++ {
+		if err := RollupSchema("SCHEMA1", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countRows(RollupTiers[0].Table("SCHEMA1")); n != 180*2 {
+		t.Fatal("unexpected 1m rows", n)
+	}
+	if n := countRows(RollupTiers[1].Table("SCHEMA1")); n != 3*2 {
+		t.Fatal("unexpected 1h rows", n)
+	}
+	if n := countRows(RollupTiers[2].Table("SCHEMA1")); n != 0 {
+		t.Fatal("incomplete day should not be rolled up", n)
+	}
+	// 1h 走汇总表, 13 点还没汇总的走原始数据
+	for _, c := range []struct {
+		fn     string
+		expect []any
+	}{
+		{"avg", []any{29.5, 89.5, 149.5, 180.0}},
+		{"max", []any{59.0, 119.0, 179.0, 180.0}},
+		{"count", []any{int64(180), int64(180), int64(180), int64(2)}},
+	} {
+		records, err := QueryAggregate("SCHEMA1", AggregateQuery{
+			Fields: []string{"temp"}, Start: from, End: now, Interval: time.Hour, Func: c.fn,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 4 || records[0]["bucket"] != "2025-01-01 10:00:00" {
+			t.Fatal("unexpected records", records)
+		}
+		for i, record := range records {
+			if record["temp"] != c.expect[i] {
+				t.Fatal("unexpected", c.fn, i, record)
+			}
+		}
+	}
+	// 30 秒的间隔只能用原始数据
+	records, err := QueryAggregate("SCHEMA1", AggregateQuery{
+		Start: from, End: from.Add(time.Minute), Interval: 30 * time.Second, Func: "count",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0]["temp"] != int64(2) || records[1]["on"] != int64(1) {
+		t.Fatal("unexpected records", records)
+	}
+	if _, err := QueryAggregate("SCHEMA1", AggregateQuery{
+		Fields: []string{"label"}, Start: from, End: now, Interval: time.Hour, Func: "avg",
+	}); err == nil {
+		t.Fatal("string field should fail")
+	}
+	if _, err := QueryAggregate("SCHEMA1", AggregateQuery{
+		Start: from, End: now, Interval: time.Hour, Func: "median",
+	}); err == nil {
+		t.Fatal("unknown function should fail")
+	}
+	if _, err := QueryAggregate("SCHEMA1", AggregateQuery{
+		Start: from, End: from.Add(time.Hour), Interval: time.Second, Func: "avg",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := QueryAggregate("SCHEMA1", AggregateQuery{
+		Start: from, End: from.Add(24 * time.Hour), Interval: time.Second, Func: "avg",
+	}); err == nil {
+		t.Fatal("too many buckets should fail")
+	}
+}
+
+// go test -timeout 30s -run ^Test_DataCenter_Retention github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_DataCenter_Retention(t *testing.T) {
+	initTestDataCenter(t, "SCHEMA2")
+	from := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	insertTestRows(t, "SCHEMA2", from, 180)
+	now := from.Add(time.Hour)
+	if err := RollupSchema("SCHEMA2", now); err != nil {
+		t.Fatal(err)
+	}
+	if GetRetentionPolicy("SCHEMA2") != DefaultRetentionPolicy {
+		t.Fatal("unexpected default policy")
+	}
+	if err := SetRetentionPolicy("SCHEMA2", RetentionPolicy{MaxRows: -1}); err == nil {
+		t.Fatal("negative policy should fail")
+	}
+	if err := SetRetentionPolicy("SCHEMA2", RetentionPolicy{Minute: 1, MaxRows: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyRetention("SCHEMA2", now); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(RawTable("SCHEMA2")); n != 100 {
+		t.Fatal("unexpected raw rows", n)
+	}
+	if n := countRows(RollupTiers[0].Table("SCHEMA2")); n != 60*2 {
+		t.Fatal("rollup should be kept", n)
+	}
+	if err := ApplyRetention("SCHEMA2", now.AddDate(0, 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(RollupTiers[0].Table("SCHEMA2")); n != 0 {
+		t.Fatal("expired rollup should be deleted", n)
+	}
+	if n := countRows(RollupTiers[1].Table("SCHEMA2")); n != 2 {
+		t.Fatal("hour rollup should be kept forever", n)
+	}
+	uuids, _ := schemaTables()
+	if len(uuids) != 1 || uuids[0] != "SCHEMA2" {
+		t.Fatal("unexpected schema tables", uuids)
+	}
+	if err := DropSchemaTables("SCHEMA2"); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(RawTable("SCHEMA2")) || tableExists(RollupTiers[0].Table("SCHEMA2")) {
+		t.Fatal("tables should be dropped")
+	}
+	if GetRetentionPolicy("SCHEMA2") != DefaultRetentionPolicy {
+		t.Fatal("policy should be deleted")
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 一次查询(导出)最多返回多少行
+const MaxQueryRows = 100000
+
+/*
+*
+* 数据查询: 选列, 时间范围, 过滤表达式, 按时间分组, 排序和分页
+*
+ */
+type DataQuery struct {
+	Select    []string      // 列, 空的时候全部列(分组时全部数值列)
+	Where     string        // 过滤表达式, 见 CompileFilter
+	Start     time.Time     // create_at >= Start, 零值不限制
+	End       time.Time     // create_at < End, 零值不限制
+	GroupBy   time.Duration // 大于 0 时按时间段分组, 每组对 Select 求 Func
+	Func      string        // min|max|avg|count, 默认 avg
+	Order     string        // ASC|DESC, 默认 DESC
+	OrderById bool          // 只按 id 排序, 不按 create_at; 同步按 id 分页用
+	Limit     int           // 0 是 MaxQueryRows
+	Offset    int
+}
+
+/*
+*
+* 接口和 Lua 传进来的都是字符串, 统一在这里转换
+*
+ */
+type DataQueryOptions struct {
+	Select  []string `json:"select"`
+	Where   string   `json:"where"`
+	Start   string   `json:"start"`
+	End     string   `json:"end"`
+	GroupBy string   `json:"groupBy"`
+	Func    string   `json:"func"`
+	Order   string   `json:"order"`
+	Limit   int      `json:"limit"`
+	Offset  int      `json:"offset"`
+}
+
+func (O DataQueryOptions) Parse() (DataQuery, error) {
+	q := DataQuery{
+		Select: O.Select,
+		Where:  O.Where,
+		Func:   O.Func,
+		Order:  strings.ToUpper(O.Order),
+		Limit:  O.Limit,
+		Offset: O.Offset,
+	}
+	var err error
+	if O.Start != "" {
+		if q.Start, err = ParseTime(O.Start); err != nil {
+			return q, err
+		}
+	}
+	if O.End != "" {
+		if q.End, err = ParseTime(O.End); err != nil {
+			return q, err
+		}
+	}
+	if O.GroupBy != "" {
+		if q.GroupBy, err = ParseInterval(O.GroupBy); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// 查询结果, Columns 是列的顺序, 导出的时候用
+type QueryResult struct {
+	Columns []string         `json:"columns"`
+	Rows    []map[string]any `json:"rows"`
+}
+
+// 表里所有列
+func TableColumns(schemaUuid string) ([]string, error) {
+	rows, err := DataCenterDb().Raw(fmt.Sprintf(`PRAGMA table_info("%s");`, RawTable(schemaUuid))).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := []string{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, dataType string
+		var defaultValue any
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("data center table not exists: %s", schemaUuid)
+	}
+	return columns, nil
+}
+
+// 生成查询语句, 不带分页
+func (q DataQuery) build(schemaUuid string) (string, []any, []string, error) {
+	columns, err := TableColumns(schemaUuid)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	conditions := []string{}
+	args := []any{}
+	if !q.Start.IsZero() {
+		conditions = append(conditions, "create_at >= ?")
+		args = append(args, wallClock(q.Start).Format(TimeLayout))
+	}
+	if !q.End.IsZero() {
+		conditions = append(conditions, "create_at < ?")
+		args = append(args, wallClock(q.End).Format(TimeLayout))
+	}
+	filter, filterArgs, err := CompileFilter(q.Where, columns)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if filter != "" {
+		conditions = append(conditions, "("+filter+")")
+		args = append(args, filterArgs...)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	order := "DESC"
+	switch q.Order {
+	case "", "DESC":
+	case "ASC":
+		order = "ASC"
+	default:
+		return "", nil, nil, fmt.Errorf("invalid order: %s", q.Order)
+	}
+	if q.GroupBy <= 0 {
+		selected := q.Select
+		if len(selected) == 0 {
+			selected = columns
+		}
+		quoted := []string{}
+		for _, column := range selected {
+			if !slices.Contains(columns, column) {
+				return "", nil, nil, fmt.Errorf("unknown column: %s", column)
+			}
+			quoted = append(quoted, fmt.Sprintf("`%s`", column))
+		}
+		orderBy := fmt.Sprintf("create_at %s, id %s", order, order)
+		if q.OrderById {
+			orderBy = "id " + order
+		}
+		sql := fmt.Sprintf(`SELECT %s FROM "%s"%s ORDER BY %s`,
+			strings.Join(quoted, ", "), RawTable(schemaUuid), where, orderBy)
+		return sql, args, selected, nil
+	}
+	// 按时间分组
+	if q.GroupBy < time.Second || q.GroupBy%time.Second != 0 {
+		return "", nil, nil, fmt.Errorf("groupBy must be whole seconds")
+	}
+	fn := q.Func
+	if fn == "" {
+		fn = "avg"
+	}
+	switch fn {
+	case "min", "max", "avg", "count":
+	default:
+		return "", nil, nil, fmt.Errorf("unsupported aggregate function: %s", fn)
+	}
+	numeric, err := NumericColumns(schemaUuid)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	selected := q.Select
+	if len(selected) == 0 {
+		selected = numeric
+	}
+	seconds := int64(q.GroupBy / time.Second)
+	parts := []string{fmt.Sprintf(`datetime((CAST(strftime('%%s', create_at) AS INTEGER) / %d) * %d, 'unixepoch') AS bucket`,
+		seconds, seconds)}
+	for _, column := range selected {
+		if !slices.Contains(numeric, column) {
+			return "", nil, nil, fmt.Errorf("column not numeric or not exists: %s", column)
+		}
+		parts = append(parts, fmt.Sprintf("%s(`%s`) AS `%s`", strings.ToUpper(fn), column, column))
+	}
+	sql := fmt.Sprintf(`SELECT %s FROM "%s"%s GROUP BY bucket ORDER BY bucket %s`,
+		strings.Join(parts, ", "), RawTable(schemaUuid), where, order)
+	return sql, args, append([]string{"bucket"}, selected...), nil
+}
+
+/*
+*
+* 查询; DATETIME 列转成和 create_at 一样的本地时间字符串
+*
+ */
+func QueryData(schemaUuid string, q DataQuery) (QueryResult, error) {
+	sql, args, columns, err := q.build(schemaUuid)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	limit := q.Limit
+	if limit <= 0 || limit > MaxQueryRows {
+		limit = MaxQueryRows
+	}
+	offset := q.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	rows, err := DataCenterDb().Raw(fmt.Sprintf("%s LIMIT %d OFFSET %d", sql, limit, offset), args...).Rows()
+	if err != nil {
+		return QueryResult{}, err
+	}
+	defer rows.Close()
+	records := []map[string]any{}
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return QueryResult{}, err
+		}
+		record := make(map[string]any, len(columns))
+		for i, column := range columns {
+			switch T := values[i].(type) {
+			case time.Time: // 存的是什么时间就显示什么, 不换时区
+				record[column] = T.Format(TimeLayout)
+			case []byte:
+				record[column] = string(T)
+			default:
+				record[column] = T
+			}
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return QueryResult{}, err
+	}
+	return QueryResult{Columns: columns, Rows: records}, nil
+}
+
+// 满足条件的总行数(分组时是组数), 分页用
+func CountData(schemaUuid string, q DataQuery) (int64, error) {
+	sql, args, _, err := q.build(schemaUuid)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	if err := DataCenterDb().Raw(fmt.Sprintf("SELECT count(*) FROM (%s)", sql), args...).
+		Scan(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+// go test -timeout 30s -run ^Test_CompileFilter github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_CompileFilter(t *testing.T) {
+	columns := []string{"id", "temp", "on", "label"}
+	sql, args, err := CompileFilter(
+		"temp >= -1.5 and (on == TRUE or label like 'it''s%') AND id NOT IN (1, 2) AND NOT `temp` BETWEEN 10 AND 20", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "`temp` >= ? AND (`on` = ? OR `label` LIKE ?) AND `id` NOT IN (?, ?) AND NOT `temp` BETWEEN ? AND ?"
+	if sql != expect {
+		t.Fatal("unexpected sql", sql)
+	}
+	if len(args) != 7 || args[0] != -1.5 || args[1] != 1 || args[2] != "it's%" || args[3] != int64(1) {
+		t.Fatal("unexpected args", args)
+	}
+	if sql, _, _ := CompileFilter("label IS NOT NULL", columns); sql != "`label` IS NOT NULL" {
+		t.Fatal("unexpected sql", sql)
+	}
+	for _, bad := range []string{
+		"temp > 1; DROP TABLE x",
+		"nope = 1",
+		"temp = label",
+		"temp >",
+		"(temp > 1",
+		"temp > 1 OR",
+		"label LIKE 1",
+		"label = 'abc",
+		"temp => 1",
+		strings.Repeat("(", 40) + "temp > 1" + strings.Repeat(")", 40),
+	} {
+		if _, _, err := CompileFilter(bad, columns); err == nil {
+			t.Fatal("should fail:", bad)
+		}
+	}
+}
+
+// go test -timeout 30s -run ^Test_DataCenter_Query github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_DataCenter_Query(t *testing.T) {
+	initTestDataCenter(t, "SCHEMA3")
+	from := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	insertTestRows(t, "SCHEMA3", from, 30) // 10:00:00 - 10:09:40
+	q, err := DataQueryOptions{
+		Select: []string{"create_at", "temp"},
+		Where:  "temp >= 2 AND on = true",
+		Start:  "2025-01-01 10:00:00",
+		End:    "2025-01-01 10:05:00",
+		Order:  "asc",
+	}.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := QueryData("SCHEMA3", q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 6 || result.Rows[0]["create_at"] != "2025-01-01 10:02:00" ||
+		result.Rows[0]["temp"] != 2.0 || len(result.Rows[0]) != 2 {
+		t.Fatal("unexpected rows", result.Rows)
+	}
+	if count, err := CountData("SCHEMA3", q); err != nil || count != 6 {
+		t.Fatal("unexpected count", count, err)
+	}
+	q.Limit, q.Offset = 2, 4
+	if result, _ := QueryData("SCHEMA3", q); len(result.Rows) != 2 || result.Rows[0]["temp"] != 4.0 {
+		t.Fatal("unexpected page", result.Rows)
+	}
+	// 按 5 分钟分组
+	q, _ = DataQueryOptions{Select: []string{"temp"}, GroupBy: "5m", Func: "max", Order: "ASC"}.Parse()
+	result, err = QueryData("SCHEMA3", q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Columns) != 2 || len(result.Rows) != 2 ||
+		result.Rows[0]["bucket"] != "2025-01-01 10:00:00" || result.Rows[1]["temp"] != 9.0 {
+		t.Fatal("unexpected groups", result)
+	}
+	for _, bad := range []DataQueryOptions{
+		{Select: []string{"nope"}},
+		{Select: []string{"label"}, GroupBy: "1m"},
+		{GroupBy: "1m", Func: "median"},
+		{Order: "RANDOM()"},
+		{Where: "1 = 1"},
+	} {
+		q, _ := bad.Parse()
+		if _, err := QueryData("SCHEMA3", q); err == nil {
+			t.Fatal("should fail:", bad)
+		}
+	}
+	if _, err := (DataQueryOptions{Start: "yesterday"}).Parse(); err == nil {
+		t.Fatal("invalid time should fail")
+	}
+	// 以前带时区存的行, 显示的还是写进去的时间
+	DataCenterDb().Exec(`INSERT INTO "data_center_SCHEMA3" (create_at, temp) VALUES ('2025-01-02 10:00:00+08:00', 100)`)
+	q, _ = DataQueryOptions{Select: []string{"create_at"}, Where: "temp = 100"}.Parse()
+	if result, _ := QueryData("SCHEMA3", q); len(result.Rows) != 1 || result.Rows[0]["create_at"] != "2025-01-02 10:00:00" {
+		t.Fatal("unexpected create_at", result.Rows)
+	}
+}
+
+// go test -timeout 30s -run ^Test_DataCenter_Export github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_DataCenter_Export(t *testing.T) {
+	initTestDataCenter(t, "SCHEMA4")
+	insertTestRows(t, "SCHEMA4", time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local), 3)
+	q, _ := DataQueryOptions{Select: []string{"id", "create_at", "temp", "on", "label"}, Order: "ASC"}.Parse()
+	result, err := QueryData("SCHEMA4", q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv := &bytes.Buffer{}
+	if err := ExportResult(csv, "csv", result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(csv.String(), "id,create_at,temp,on,label\n1,2025-01-01 10:00:00,0,1,x\n") {
+		t.Fatal("unexpected csv", csv.String())
+	}
+	xlsx := &bytes.Buffer{}
+	if err := ExportResult(xlsx, "xlsx", result); err != nil {
+		t.Fatal(err)
+	}
+	file, err := excelize.OpenReader(xlsx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := file.GetRows("Sheet1"); len(rows) != 4 || rows[0][2] != "temp" {
+		t.Fatal("unexpected xlsx", rows)
+	}
+	pq := &bytes.Buffer{}
+	if err := ExportResult(pq, "parquet", result); err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(bytes.NewReader(pq.Bytes()), int64(pq.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.NumRows() != 3 {
+		t.Fatal("unexpected parquet rows", pf.NumRows())
+	}
+	reader := parquet.NewReader(pf)
+	row := map[string]any{}
+	if err := reader.Read(&row); err != nil {
+		t.Fatal(err)
+	}
+	if row["id"] != int64(1) || row["on"] != true || row["label"] != "x" || row["create_at"] != "2025-01-01 10:00:00" {
+		t.Fatal("unexpected parquet row", row)
+	}
+	if err := ExportResult(pq, "json", result); err == nil {
+		t.Fatal("unsupported format should fail")
+	}
+}
@@ -0,0 +1,411 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+/*
+*
+* 同步任务: 把一个模型表的新数据分批推到一个输出资源(OutEnd);
+* LastId 是高水位, 只有输出资源确认发送成功才往前推, 每批都落库, 断网或者重启以后接着推
+*
+ */
+type MDataCenterSync struct {
+	ID          uint   `gorm:"primarykey"`
+	UUID        string `gorm:"not null;uniqueIndex"`
+	SchemaId    string `gorm:"not null;index"`
+	OutEndId    string `gorm:"not null"`
+	BatchSize   int    `gorm:"not null"`
+	Interval    int    `gorm:"not null"` // 秒
+	Enable      *bool  `gorm:"not null"`
+	LastId      int64  `gorm:"not null;default:0"` // 已经推送的最大 id
+	Synced      int64  `gorm:"not null;default:0"` // 累计推送行数
+	LastSyncAt  int64  `gorm:"not null;default:0"` // 最后一次成功的时间, 毫秒
+	LastError   string
+	Description string
+}
+
+const (
+	DefaultSyncBatchSize = 100
+	MaxSyncBatchSize     = 1000
+	DefaultSyncInterval  = 5
+	// 一轮最多推多少批, 积压太多的时候分几轮推, 不占着不放
+	maxSyncBatchesPerRound = 50
+	// 失败以后退避, 最长间隔
+	maxSyncBackoff = 5 * time.Minute
+	// 等输出资源确认一批的时间
+	syncAckTimeout = 10 * time.Second
+)
+
+func (job *MDataCenterSync) check() error {
+	if job.SchemaId == "" || job.OutEndId == "" {
+		return fmt.Errorf("schemaId and outEndId are required")
+	}
+	if job.BatchSize == 0 {
+		job.BatchSize = DefaultSyncBatchSize
+	}
+	if job.BatchSize < 1 || job.BatchSize > MaxSyncBatchSize {
+		return fmt.Errorf("batchSize must between 1 and %d", MaxSyncBatchSize)
+	}
+	if job.Interval == 0 {
+		job.Interval = DefaultSyncInterval
+	}
+	if job.Interval < 1 {
+		return fmt.Errorf("interval must greater than 0")
+	}
+	if job.Enable == nil {
+		job.Enable = new(bool)
+		*job.Enable = true
+	}
+	return nil
+}
+
+func AllSyncJobs(schemaUuid string) ([]MDataCenterSync, error) {
+	jobs := []MDataCenterSync{}
+	tx := DataCenterDb().Model(MDataCenterSync{})
+	if schemaUuid != "" {
+		tx = tx.Where("schema_id=?", schemaUuid)
+	}
+	return jobs, tx.Order("id").Find(&jobs).Error
+}
+
+func GetSyncJob(uuid string) (MDataCenterSync, error) {
+	job := MDataCenterSync{}
+	return job, DataCenterDb().Where("uuid=?", uuid).First(&job).Error
+}
+
+// 新建任务从表里现有的最大 id 开始推, fromStart 为真时从头推
+func CreateSyncJob(job MDataCenterSync, fromStart bool) (MDataCenterSync, error) {
+	if err := job.check(); err != nil {
+		return job, err
+	}
+	var count int64
+	DataCenterDb().Model(MDataCenterSync{}).
+		Where("schema_id=? AND out_end_id=?", job.SchemaId, job.OutEndId).Count(&count)
+	if count > 0 {
+		return job, fmt.Errorf("sync job already exists: %s -> %s", job.SchemaId, job.OutEndId)
+	}
+	job.ID = 0
+	job.UUID = utils.MakeUUID("SYNC")
+	job.LastId, job.Synced, job.LastSyncAt, job.LastError = 0, 0, 0, ""
+	if !fromStart {
+		job.LastId = maxRowId(job.SchemaId)
+	}
+	return job, DataCenterDb().Create(&job).Error
+}
+
+// 只改配置, 高水位不动
+func UpdateSyncJob(job MDataCenterSync) error {
+	if err := job.check(); err != nil {
+		return err
+	}
+	return DataCenterDb().Model(MDataCenterSync{}).Where("uuid=?", job.UUID).
+		Select("out_end_id", "batch_size", "interval", "enable", "description").
+		Updates(&job).Error
+}
+
+func DeleteSyncJob(uuid string) error {
+	__DefaultSyncRunner.forget(uuid)
+	return DataCenterDb().Where("uuid=?", uuid).Delete(&MDataCenterSync{}).Error
+}
+
+func DeleteSchemaSyncJobs(schemaUuid string) error {
+	return DataCenterDb().Where("schema_id=?", schemaUuid).Delete(&MDataCenterSync{}).Error
+}
+
+// 重设高水位, 比如要从某一行开始重推
+func ResetSyncJob(uuid string, lastId int64) error {
+	if lastId < 0 {
+		return fmt.Errorf("lastId must not be negative")
+	}
+	__DefaultSyncRunner.forget(uuid)
+	return DataCenterDb().Model(MDataCenterSync{}).Where("uuid=?", uuid).
+		Updates(map[string]any{"last_id": lastId, "last_error": ""}).Error
+}
+
+func maxRowId(schemaUuid string) int64 {
+	var id int64
+	DataCenterDb().Raw(fmt.Sprintf(`SELECT COALESCE(MAX(id), 0) FROM "%s";`, RawTable(schemaUuid))).Scan(&id)
+	return id
+}
+
+/*
+*
+* 同步状态: Pending 是还没推的行数, Lag 是最早一条没推的数据到现在多少秒
+*
+ */
+type SyncStatus struct {
+	Pending int64   `json:"pending"`
+	Lag     float64 `json:"lag"`
+}
+
+func GetSyncStatus(job MDataCenterSync) SyncStatus {
+	status := SyncStatus{}
+	table := RawTable(job.SchemaId)
+	DataCenterDb().Raw(fmt.Sprintf(`SELECT count(*) FROM "%s" WHERE id > ?;`, table), job.LastId).
+		Scan(&status.Pending)
+	if status.Pending == 0 {
+		return status
+	}
+	var oldest string
+	DataCenterDb().Raw(fmt.Sprintf(`SELECT COALESCE(MIN(create_at), '') FROM "%s" WHERE id > ?;`, table),
+		job.LastId).Scan(&oldest)
+	if t, err := parseWallClock(oldest); err == nil {
+		if lag := wallClock(time.Now()).Sub(t).Seconds(); lag > 0 {
+			status.Lag = lag
+		}
+	}
+	return status
+}
+
+// 已经被所有启用的任务推走的最大 id, 没有任务返回 false; 按时间清理原始数据的时候不删没推走的
+func syncedMark(schemaUuid string) (int64, bool) {
+	var result struct {
+		Mark  int64
+		Count int64
+	}
+	DataCenterDb().Model(MDataCenterSync{}).
+		Select("COALESCE(MIN(last_id), 0) AS mark, count(*) AS count").
+		Where("schema_id=? AND enable=?", schemaUuid, true).Scan(&result)
+	return result.Mark, result.Count > 0
+}
+
+/*
+*
+* 推一轮: 每批 BatchSize 行, 从小到大按 id 推, 直到追上或者失败; 只按 id 排序分页,
+* create_at 不一定跟着 id 涨(校时, 手工指定), 按时间排会漏行;
+* 发出去的是 JSON 数组, 每行是表里的列再加一个毫秒时间戳 ts
+*
+ */
+func SyncOnce(rx typex.Rhilex, job *MDataCenterSync) (int, error) {
+	// 表被清空或者重建过, id 从头开始了
+	if max := maxRowId(job.SchemaId); max < job.LastId {
+		glogger.GLogger.Warn("data center table reset, sync from start:", job.SchemaId)
+		job.LastId = 0
+		if err := saveSyncProgress(job); err != nil {
+			return 0, err
+		}
+	}
+	total := 0
+	for i := 0; i < maxSyncBatchesPerRound; i++ {
+		result, err := QueryData(job.SchemaId, DataQuery{
+			Where:     fmt.Sprintf("id > %d", job.LastId),
+			Order:     "ASC",
+			OrderById: true,
+			Limit:     job.BatchSize,
+		})
+		if err != nil {
+			return total, err
+		}
+		if len(result.Rows) == 0 {
+			return total, nil
+		}
+		lastId := job.LastId
+		for _, row := range result.Rows {
+			id, ok := row["id"].(int64)
+			if !ok {
+				return total, fmt.Errorf("unexpected id type: %T", row["id"])
+			}
+			lastId = max(lastId, id)
+		}
+		if err := pushSyncRows(rx, job.OutEndId, result.Rows); err != nil {
+			job.LastError = err.Error()
+			saveSyncProgress(job)
+			return total, err
+		}
+		job.LastId = lastId
+		job.Synced += int64(len(result.Rows))
+		job.LastSyncAt = time.Now().UnixMilli()
+		job.LastError = ""
+		if err := saveSyncProgress(job); err != nil {
+			return total, err
+		}
+		total += len(result.Rows)
+		if len(result.Rows) < job.BatchSize {
+			return total, nil
+		}
+	}
+	return total, nil
+}
+
+/*
+*
+* 同步只推给能确认送达的输出资源(typex.XAckTarget); 别的资源 To 返回成功的时候
+* 数据可能还在批量缓冲区或者离线缓存里, 断点先动了, 重启会丢, 缓存重放会重复
+*
+ */
+func CheckSyncOutEnd(rx typex.Rhilex, outEndId string) error {
+	outEnd := rx.GetOutEnd(outEndId)
+	if outEnd == nil || outEnd.Target == nil {
+		return fmt.Errorf("outend not exists: %s", outEndId)
+	}
+	if _, ok := outEnd.Target.(typex.XAckTarget); !ok {
+		return fmt.Errorf("outend does not support acknowledged delivery: %s", outEndId)
+	}
+	return nil
+}
+
+func pushSyncRows(rx typex.Rhilex, outEndId string, rows []map[string]any) error {
+	if err := CheckSyncOutEnd(rx, outEndId); err != nil {
+		return err
+	}
+	outEnd := rx.GetOutEnd(outEndId)
+	if outEnd.Target.Status() != typex.SOURCE_UP {
+		return fmt.Errorf("outend is not running: %s", outEndId)
+	}
+	for _, row := range rows {
+		// create_at 存的是不带时区的本地时间(见 InsertSchemaRow), 按本地时间换成 Unix 毫秒
+		if s, ok := row["create_at"].(string); ok {
+			if t, err := time.ParseInLocation(TimeLayout, s, time.Local); err == nil {
+				row["ts"] = t.UnixMilli()
+			}
+		}
+	}
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	// 等对端确认, 不然 To 返回成功也可能没发出去, 断点就跳过了这一批
+	return outEnd.Target.(typex.XAckTarget).ToAcked(string(payload), syncAckTimeout)
+}
+
+func saveSyncProgress(job *MDataCenterSync) error {
+	return DataCenterDb().Model(MDataCenterSync{}).Where("uuid=?", job.UUID).
+		Updates(map[string]any{
+			"last_id":      job.LastId,
+			"synced":       job.Synced,
+			"last_sync_at": job.LastSyncAt,
+			"last_error":   job.LastError,
+		}).Error
+}
+
+/*
+*
+* 调度: 每秒看一遍任务, 到时间的推一轮; 失败的按间隔翻倍退避, 最长 5 分钟
+*
+ */
+type syncState struct {
+	nextRun time.Time
+	fails   int
+}
+
+type syncRunner struct {
+	locker sync.Mutex
+	states map[string]*syncState
+	cancel context.CancelFunc
+}
+
+var __DefaultSyncRunner = &syncRunner{states: map[string]*syncState{}}
+
+func (r *syncRunner) forget(uuid string) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	delete(r.states, uuid)
+}
+
+func (r *syncRunner) due(job MDataCenterSync, now time.Time) bool {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	state, ok := r.states[job.UUID]
+	return !ok || !now.Before(state.nextRun)
+}
+
+func (r *syncRunner) done(job MDataCenterSync, now time.Time, err error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	state, ok := r.states[job.UUID]
+	if !ok {
+		state = &syncState{}
+		r.states[job.UUID] = state
+	}
+	interval := time.Duration(job.Interval) * time.Second
+	if err == nil {
+		state.fails = 0
+		state.nextRun = now.Add(interval)
+		return
+	}
+	state.fails++
+	backoff := interval
+	for i := 1; i < state.fails && backoff < maxSyncBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxSyncBackoff {
+		backoff = maxSyncBackoff
+	}
+	state.nextRun = now.Add(backoff)
+}
+
+func (r *syncRunner) round(rx typex.Rhilex, now time.Time) {
+	jobs, err := AllSyncJobs("")
+	if err != nil {
+		glogger.GLogger.Error("load sync jobs failed:", err)
+		return
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Enable == nil || !*job.Enable || !r.due(*job, now) {
+			continue
+		}
+		n, err := SyncOnce(rx, job)
+		if err != nil {
+			glogger.GLogger.Error("data center sync failed:", job.SchemaId, job.OutEndId, err)
+		} else if n > 0 {
+			glogger.GLogger.Debug("data center synced:", job.SchemaId, job.OutEndId, n)
+		}
+		r.done(*job, now, err)
+	}
+}
+
+func StartDataCenterSync(rx typex.Rhilex) {
+	ctx, cancel := context.WithCancel(context.Background())
+	__DefaultSyncRunner.locker.Lock()
+	if __DefaultSyncRunner.cancel != nil {
+		__DefaultSyncRunner.cancel()
+	}
+	__DefaultSyncRunner.cancel = cancel
+	__DefaultSyncRunner.locker.Unlock()
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			__DefaultSyncRunner.round(rx, time.Now())
+		}
+	}()
+}
+
+func StopDataCenterSync() {
+	__DefaultSyncRunner.locker.Lock()
+	defer __DefaultSyncRunner.locker.Unlock()
+	if __DefaultSyncRunner.cancel != nil {
+		__DefaultSyncRunner.cancel()
+		__DefaultSyncRunner.cancel = nil
+	}
+}
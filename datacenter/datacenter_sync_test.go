@@ -0,0 +1,267 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package datacenter
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/typex"
+)
+
+type fakeSyncRhilex struct {
+	typex.Rhilex
+	outEnds map[string]*typex.OutEnd
+}
+
+func (r *fakeSyncRhilex) GetOutEnd(uuid string) *typex.OutEnd {
+	return r.outEnds[uuid]
+}
+
+// 能确认送达的目标, 同步只走 ToAcked; 记下收到的每一批, down 的时候模拟对端没回确认
+type fakeSyncTarget struct {
+	typex.XTarget
+	down    bool
+	batches [][]map[string]any
+}
+
+func (t *fakeSyncTarget) Status() typex.SourceState {
+	return typex.SOURCE_UP
+}
+
+func (t *fakeSyncTarget) To(data any) (any, error) {
+	return nil, fmt.Errorf("sync should use ToAcked")
+}
+
+func (t *fakeSyncTarget) ToAcked(data any, timeout time.Duration) error {
+	if t.down {
+		return fmt.Errorf("not acknowledged in %v", timeout)
+	}
+	rows := []map[string]any{}
+	if err := json.Unmarshal([]byte(data.(string)), &rows); err != nil {
+		return err
+	}
+	t.batches = append(t.batches, rows)
+	return nil
+}
+
+func (t *fakeSyncTarget) rows() int {
+	n := 0
+	for _, batch := range t.batches {
+		n += len(batch)
+	}
+	return n
+}
+
+// 只有 To 的目标, 返回成功的时候数据可能还在缓冲区里
+type fakeBufferedTarget struct {
+	typex.XTarget
+	sent int
+}
+
+func (t *fakeBufferedTarget) Status() typex.SourceState {
+	return typex.SOURCE_UP
+}
+
+func (t *fakeBufferedTarget) To(data any) (any, error) {
+	t.sent++
+	return nil, nil
+}
+
+// go test -timeout 30s -run ^Test_DataCenter_Sync$ github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_DataCenter_Sync(t *testing.T) {
+	// 本地时间不是 UTC 的时候 ts 也要对
+	local := time.Local
+	time.Local = time.FixedZone("UTC+8", 8*3600)
+	t.Cleanup(func() { time.Local = local })
+	initTestDataCenter(t, "SCHEMA5")
+	target := &fakeSyncTarget{}
+	rx := &fakeSyncRhilex{outEnds: map[string]*typex.OutEnd{"OUT1": {UUID: "OUT1", Target: target}}}
+	from := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	insertTestRows(t, "SCHEMA5", from, 5)
+	// 默认从当前位置开始, 已有数据不推
+	job, err := CreateSyncJob(MDataCenterSync{SchemaId: "SCHEMA5", OutEndId: "OUT1", BatchSize: 10}, false)
+	if err != nil || job.LastId != 5 || job.Interval != DefaultSyncInterval || !*job.Enable {
+		t.Fatal("unexpected job", job, err)
+	}
+	if _, err := CreateSyncJob(MDataCenterSync{SchemaId: "SCHEMA5", OutEndId: "OUT1"}, false); err == nil {
+		t.Fatal("duplicated job should fail")
+	}
+	if _, err := CreateSyncJob(MDataCenterSync{SchemaId: "SCHEMA5", OutEndId: "OUT2", BatchSize: 5000}, false); err == nil {
+		t.Fatal("too large batch should fail")
+	}
+	insertTestRows(t, "SCHEMA5", from.Add(time.Hour), 25)
+	if status := GetSyncStatus(job); status.Pending != 25 || status.Lag <= 0 {
+		t.Fatal("unexpected status", status)
+	}
+	// 目标不通, 进度不动
+	target.down = true
+	if n, err := SyncOnce(rx, &job); err == nil || n != 0 {
+		t.Fatal("sync should fail", n, err)
+	}
+	job, _ = GetSyncJob(job.UUID)
+	if job.LastId != 5 || job.LastError == "" {
+		t.Fatal("unexpected job after failure", job)
+	}
+	// 恢复以后接着推, 分三批
+	target.down = false
+	n, err := SyncOnce(rx, &job)
+	if err != nil || n != 25 || len(target.batches) != 3 || len(target.batches[2]) != 5 {
+		t.Fatal("unexpected sync", n, err, len(target.batches))
+	}
+	first := target.batches[0][0]
+	if first["id"] != 6.0 || first["create_at"] != "2025-01-01 11:00:00" ||
+		first["ts"] != float64(from.Add(time.Hour).UnixMilli()) {
+		t.Fatal("unexpected row", first)
+	}
+	job, _ = GetSyncJob(job.UUID)
+	if job.LastId != 30 || job.Synced != 25 || job.LastError != "" || job.LastSyncAt == 0 {
+		t.Fatal("unexpected job after sync", job)
+	}
+	if status := GetSyncStatus(job); status.Pending != 0 || status.Lag != 0 {
+		t.Fatal("unexpected status", status)
+	}
+	// 重设进度从头推
+	if err := ResetSyncJob(job.UUID, 0); err != nil {
+		t.Fatal(err)
+	}
+	job, _ = GetSyncJob(job.UUID)
+	if n, _ := SyncOnce(rx, &job); n != 30 {
+		t.Fatal("unexpected resync", n)
+	}
+	// 表被清空以后从头开始
+	DataCenterDb().Exec(fmt.Sprintf(`DELETE FROM "%s";`, RawTable("SCHEMA5")))
+	DataCenterDb().Exec(`DELETE FROM sqlite_sequence WHERE name = ?;`, RawTable("SCHEMA5"))
+	insertTestRows(t, "SCHEMA5", from.Add(2*time.Hour), 2)
+	if n, _ := SyncOnce(rx, &job); n != 2 || job.LastId != 2 {
+		t.Fatal("unexpected sync after clear", n, job.LastId)
+	}
+	// 找不到输出资源
+	delete(rx.outEnds, "OUT1")
+	insertTestRows(t, "SCHEMA5", from.Add(3*time.Hour), 1)
+	if _, err := SyncOnce(rx, &job); err == nil {
+		t.Fatal("missing outend should fail")
+	}
+}
+
+// go test -timeout 30s -run ^Test_DataCenter_SyncAcked github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_DataCenter_SyncAcked(t *testing.T) {
+	initTestDataCenter(t, "SCHEMA7")
+	buffered := &fakeBufferedTarget{}
+	target := &fakeSyncTarget{}
+	rx := &fakeSyncRhilex{outEnds: map[string]*typex.OutEnd{
+		"OUT1": {UUID: "OUT1", Target: buffered},
+		"OUT2": {UUID: "OUT2", Target: target},
+	}}
+	insertTestRows(t, "SCHEMA7", time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local), 3)
+	// 不能确认送达的输出资源建不了任务, 已有的任务也不推
+	if err := CheckSyncOutEnd(rx, "OUT1"); err == nil {
+		t.Fatal("outend without ack should be rejected")
+	}
+	if err := CheckSyncOutEnd(rx, "OUT3"); err == nil {
+		t.Fatal("missing outend should be rejected")
+	}
+	job, err := CreateSyncJob(MDataCenterSync{SchemaId: "SCHEMA7", OutEndId: "OUT1"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := SyncOnce(rx, &job); err == nil || n != 0 || job.LastId != 0 || buffered.sent != 0 {
+		t.Fatal("outend without ack should fail", n, err, job.LastId)
+	}
+	job.OutEndId = "OUT2"
+	if err := CheckSyncOutEnd(rx, job.OutEndId); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := SyncOnce(rx, &job); err != nil || n != 3 || job.LastId != 3 || target.rows() != 3 {
+		t.Fatal("unexpected sync", n, err, job.LastId)
+	}
+}
+
+// go test -timeout 30s -run ^Test_DataCenter_SyncOrder github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_DataCenter_SyncOrder(t *testing.T) {
+	initTestDataCenter(t, "SCHEMA8")
+	target := &fakeSyncTarget{}
+	rx := &fakeSyncRhilex{outEnds: map[string]*typex.OutEnd{"OUT1": {UUID: "OUT1", Target: target}}}
+	// 时钟回拨过: id 1/2/3 的 create_at 是 10s/5s/7s
+	from := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	for _, second := range []int{10, 5, 7} {
+		if err := InsertSchemaRow("SCHEMA8", map[string]any{
+			"create_at": from.Add(time.Duration(second) * time.Second), "temp": float64(second),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := CreateSyncJob(MDataCenterSync{SchemaId: "SCHEMA8", OutEndId: "OUT1", BatchSize: 2}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按 id 分页, 一行都不漏
+	if n, err := SyncOnce(rx, &job); err != nil || n != 3 || job.LastId != 3 || len(target.batches) != 2 {
+		t.Fatal("unexpected sync", n, err, job.LastId, target.batches)
+	}
+	ids := []any{}
+	for _, batch := range target.batches {
+		for _, row := range batch {
+			ids = append(ids, row["id"])
+		}
+	}
+	if fmt.Sprint(ids) != "[1 2 3]" {
+		t.Fatal("unexpected ids", ids)
+	}
+	if status := GetSyncStatus(job); status.Pending != 0 {
+		t.Fatal("unexpected status", status)
+	}
+}
+
+// go test -timeout 30s -run ^Test_DataCenter_SyncRetention github.com/hootrhino/rhilex/datacenter -v -count=1
+func Test_DataCenter_SyncRetention(t *testing.T) {
+	initTestDataCenter(t, "SCHEMA6")
+	from := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	insertTestRows(t, "SCHEMA6", from, 10)
+	if err := SetRetentionPolicy("SCHEMA6", RetentionPolicy{Raw: 1}); err != nil {
+		t.Fatal(err)
+	}
+	job, err := CreateSyncJob(MDataCenterSync{SchemaId: "SCHEMA6", OutEndId: "OUT1"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ResetSyncJob(job.UUID, 4)
+	// 过期了, 但是 5 - 10 还没推走
+	if err := ApplyRetention("SCHEMA6", from.AddDate(0, 0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(RawTable("SCHEMA6")); n != 6 {
+		t.Fatal("unexpected rows", n)
+	}
+	// 停用的任务不挡清理
+	disabled := false
+	job.Enable = &disabled
+	if err := UpdateSyncJob(job); err != nil {
+		t.Fatal(err)
+	}
+	ApplyRetention("SCHEMA6", from.AddDate(0, 0, 3))
+	if n := countRows(RawTable("SCHEMA6")); n != 0 {
+		t.Fatal("unexpected rows", n)
+	}
+	if err := DropSchemaTables("SCHEMA6"); err != nil {
+		t.Fatal(err)
+	}
+	if jobs, _ := AllSyncJobs("SCHEMA6"); len(jobs) != 0 {
+		t.Fatal("sync jobs should be deleted", jobs)
+	}
+}
@@ -22,4 +22,6 @@ func InitAll(e typex.Rhilex) {
 }
 
 func StopAll() {
+	StopClearDataCenterCron()
+	StopDataCenterSync()
 }
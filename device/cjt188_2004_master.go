@@ -17,7 +17,6 @@ package device
 
 import (
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -293,16 +292,12 @@ func (gw *CJT188_2004_MasterGateway) work(handler *cjt1882004.CJT188ClientHandle
 			NewValue.ErrMsg = ""
 			intercache.SetValue(gw.PointId, DataPoint.UUID, NewValue)
 			if !*gw.mainConfig.CommonConfig.BatchRequest {
-				if bytes, err := json.Marshal(CJT1882004ReadData{
-					MeterId: DataPoint.MeterId,
-					Tag:     DataPoint.Tag,
-					Value:   Value,
-				}); err != nil {
-					glogger.GLogger.Error(err)
-				} else {
-					glogger.GLogger.Debug(string(bytes))
-					gw.RuleEngine.WorkDevice(gw.Details(), string(bytes))
-				}
+				gw.RuleEngine.WorkDeviceMessage(gw.Details(),
+					typex.NewMessage(gw.PointId, CJT1882004ReadData{
+						MeterId: DataPoint.MeterId,
+						Tag:     DataPoint.Tag,
+						Value:   Value,
+					}))
 			} else {
 				cjt1882004ReadDataList = append(cjt1882004ReadDataList, CJT1882004ReadData{
 					MeterId: DataPoint.MeterId,
@@ -325,12 +320,8 @@ func (gw *CJT188_2004_MasterGateway) work(handler *cjt1882004.CJT188ClientHandle
 		}
 		if *gw.mainConfig.CommonConfig.BatchRequest {
 			if len(cjt1882004ReadDataList) > 0 {
-				if bytes, err := json.Marshal(cjt1882004ReadDataList); err != nil {
-					glogger.GLogger.Error(err)
-				} else {
-					glogger.GLogger.Debug(string(bytes))
-					gw.RuleEngine.WorkDevice(gw.Details(), string(bytes))
-				}
+				gw.RuleEngine.WorkDeviceMessage(gw.Details(),
+					typex.NewMessage(gw.PointId, cjt1882004ReadDataList))
 			}
 		}
 	}
@@ -16,7 +16,6 @@
 package device
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -290,16 +289,12 @@ func (gw *DLT645_2007_MasterGateway) work(handler *dlt6452007.DLT645ClientHandle
 			NewValue.ErrMsg = ""
 			intercache.SetValue(gw.PointId, DataPoint.UUID, NewValue)
 			if !*gw.mainConfig.CommonConfig.BatchRequest {
-				if bytes, err := json.Marshal(DLT6452007ReadData{
-					Tag:     DataPoint.Tag,
-					MeterId: DataPoint.MeterId,
-					Value:   Value,
-				}); err != nil {
-					glogger.GLogger.Error(err)
-				} else {
-					glogger.GLogger.Debug(string(bytes))
-					gw.RuleEngine.WorkDevice(gw.Details(), string(bytes))
-				}
+				gw.RuleEngine.WorkDeviceMessage(gw.Details(),
+					typex.NewMessage(gw.PointId, DLT6452007ReadData{
+						Tag:     DataPoint.Tag,
+						MeterId: DataPoint.MeterId,
+						Value:   Value,
+					}))
 			} else {
 				DLT6452007ReadDataList = append(DLT6452007ReadDataList, DLT6452007ReadData{
 					MeterId: DataPoint.MeterId,
@@ -322,12 +317,8 @@ func (gw *DLT645_2007_MasterGateway) work(handler *dlt6452007.DLT645ClientHandle
 		}
 		if *gw.mainConfig.CommonConfig.BatchRequest {
 			if len(DLT6452007ReadDataList) > 0 {
-				if bytes, err := json.Marshal(DLT6452007ReadDataList); err != nil {
-					glogger.GLogger.Error(err)
-				} else {
-					glogger.GLogger.Debug(string(bytes))
-					gw.RuleEngine.WorkDevice(gw.Details(), string(bytes))
-				}
+				gw.RuleEngine.WorkDeviceMessage(gw.Details(),
+					typex.NewMessage(gw.PointId, DLT6452007ReadDataList))
 			}
 		}
 		// 是否预警
@@ -2,7 +2,6 @@ package device
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -267,12 +266,8 @@ func (dev *GenericBacnetIpDevice) Start(cctx typex.CCTX) error {
 			}
 			if *dev.mainConfig.CommonConfig.BatchRequest {
 				if len(ReadBacnetValues) > 0 {
-					if bytes, err := json.Marshal(ReadBacnetValues); err != nil {
-						glogger.GLogger.Error(err)
-					} else {
-						glogger.GLogger.Debug(string(bytes))
-						dev.RuleEngine.WorkDevice(dev.Details(), string(bytes))
-					}
+					dev.RuleEngine.WorkDeviceMessage(dev.Details(),
+						typex.NewMessage(dev.PointId, ReadBacnetValues))
 				}
 			}
 			// 是否预警
@@ -356,11 +351,8 @@ func (dev *GenericBacnetIpDevice) ReadProperty() []ReadBacnetValue {
 			})
 			if !*dev.mainConfig.CommonConfig.BatchRequest {
 				if len(ReadBacnetValues) > 0 {
-					if bytes, errMarshal := json.Marshal(ReadBacnetValue); errMarshal != nil {
-						glogger.GLogger.Error(errMarshal)
-					} else {
-						dev.RuleEngine.WorkDevice(dev.Details(), string(bytes))
-					}
+					dev.RuleEngine.WorkDeviceMessage(dev.Details(),
+						typex.NewMessage(dev.PointId, ReadBacnetValue))
 				}
 			}
 		}
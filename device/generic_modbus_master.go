@@ -419,12 +419,8 @@ func (mdev *GenericModbusMaster) Start(cctx typex.CCTX) error {
 				}
 				if *mdev.mainConfig.CommonConfig.BatchRequest {
 					if len(ReadRegisterValues) > 0 {
-						if bytes, errMarshal := json.Marshal(ReadRegisterValues); errMarshal != nil {
-							mdev.retryTimes++
-							glogger.GLogger.Error(errMarshal)
-						} else {
-							mdev.RuleEngine.WorkDevice(mdev.Details(), string(bytes))
-						}
+						mdev.RuleEngine.WorkDeviceMessage(mdev.Details(),
+							typex.NewMessage(mdev.PointId, ReadRegisterValues))
 					}
 				}
 				// 是否预警
@@ -746,11 +742,8 @@ func (mdev *GenericModbusMaster) modbusSingleRead() []ReadRegisterValue {
 				// }
 			}
 			if !*mdev.mainConfig.CommonConfig.BatchRequest {
-				if bytes, errMarshal := json.Marshal(Reg); errMarshal != nil {
-					glogger.GLogger.Error(errMarshal)
-				} else {
-					mdev.RuleEngine.WorkDevice(mdev.Details(), string(bytes))
-				}
+				mdev.RuleEngine.WorkDeviceMessage(mdev.Details(),
+					typex.NewMessage(mdev.PointId, Reg))
 			}
 		}
 		// 2 字节
@@ -813,11 +806,8 @@ func (mdev *GenericModbusMaster) modbusSingleRead() []ReadRegisterValue {
 				// }
 			}
 			if !*mdev.mainConfig.CommonConfig.BatchRequest {
-				if bytes, errMarshal := json.Marshal(Reg); errMarshal != nil {
-					glogger.GLogger.Error(errMarshal)
-				} else {
-					mdev.RuleEngine.WorkDevice(mdev.Details(), string(bytes))
-				}
+				mdev.RuleEngine.WorkDeviceMessage(mdev.Details(),
+					typex.NewMessage(mdev.PointId, Reg))
 			}
 		}
 		// 2 字节
@@ -881,11 +871,8 @@ func (mdev *GenericModbusMaster) modbusSingleRead() []ReadRegisterValue {
 				// }
 			}
 			if !*mdev.mainConfig.CommonConfig.BatchRequest {
-				if bytes, errMarshal := json.Marshal(Reg); errMarshal != nil {
-					glogger.GLogger.Error(errMarshal)
-				} else {
-					mdev.RuleEngine.WorkDevice(mdev.Details(), string(bytes))
-				}
+				mdev.RuleEngine.WorkDeviceMessage(mdev.Details(),
+					typex.NewMessage(mdev.PointId, Reg))
 			}
 		}
 		// 2 字节
@@ -948,11 +935,8 @@ func (mdev *GenericModbusMaster) modbusSingleRead() []ReadRegisterValue {
 				// }
 			}
 			if !*mdev.mainConfig.CommonConfig.BatchRequest {
-				if bytes, errMarshal := json.Marshal(Reg); errMarshal != nil {
-					glogger.GLogger.Error(errMarshal)
-				} else {
-					mdev.RuleEngine.WorkDevice(mdev.Details(), string(bytes))
-				}
+				mdev.RuleEngine.WorkDeviceMessage(mdev.Details(),
+					typex.NewMessage(mdev.PointId, Reg))
 			}
 		}
 		time.Sleep(time.Duration(r.Frequency) * time.Millisecond)
@@ -1,7 +1,6 @@
 package device
 
 import (
-	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -220,12 +219,8 @@ func (sd *genericSnmpDevice) Start(cctx typex.CCTX) error {
 			}
 			if *sd.mainConfig.CommonConfig.BatchRequest {
 				if len(snmpOids) > 0 {
-					if bytes, err := json.Marshal(snmpOids); err != nil {
-						glogger.GLogger.Error(err)
-					} else {
-						glogger.GLogger.Debug(string(bytes))
-						sd.RuleEngine.WorkDevice(sd.Details(), string(bytes))
-					}
+					sd.RuleEngine.WorkDeviceMessage(sd.Details(),
+						typex.NewMessage(sd.PointId, snmpOids))
 				}
 			}
 			// 是否预警
@@ -382,11 +377,8 @@ func (sd *genericSnmpDevice) readData() ([]ReadSnmpOidValue, error) {
 			}
 			intercache.SetValue(sd.PointId, snmpOid.UUID, NewValue)
 			if !*sd.mainConfig.CommonConfig.BatchRequest {
-				if bytes, errMarshal := json.Marshal(snmpOid); errMarshal != nil {
-					glogger.GLogger.Error(errMarshal)
-				} else {
-					sd.RuleEngine.WorkDevice(sd.Details(), string(bytes))
-				}
+				sd.RuleEngine.WorkDeviceMessage(sd.Details(),
+					typex.NewMessage(sd.PointId, snmpOid))
 			}
 		}(oid)
 	}
@@ -17,7 +17,6 @@ package device
 
 import (
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -249,16 +248,12 @@ func (gw *GenericUserProtocolDevice) work(handler *userproto.UserProtocolClientH
 			NewValue.ErrMsg = ""
 			intercache.SetValue(gw.PointId, DataPoint.UUID, NewValue)
 			if !*gw.mainConfig.CommonConfig.BatchRequest {
-				if bytes, err := json.Marshal(UserProtocolReadData{
-					Tag:     DataPoint.Tag,
-					Command: DataPoint.Command,
-					Value:   Value,
-				}); err != nil {
-					glogger.GLogger.Error(err)
-				} else {
-					glogger.GLogger.Debug(string(bytes))
-					gw.RuleEngine.WorkDevice(gw.Details(), string(bytes))
-				}
+				gw.RuleEngine.WorkDeviceMessage(gw.Details(),
+					typex.NewMessage(gw.PointId, UserProtocolReadData{
+						Tag:     DataPoint.Tag,
+						Command: DataPoint.Command,
+						Value:   Value,
+					}))
 			} else {
 				UserProtocolReadDataList = append(UserProtocolReadDataList, UserProtocolReadData{
 					Tag:     DataPoint.Tag,
@@ -270,12 +265,8 @@ func (gw *GenericUserProtocolDevice) work(handler *userproto.UserProtocolClientH
 		}
 		if *gw.mainConfig.CommonConfig.BatchRequest {
 			if len(UserProtocolReadDataList) > 0 {
-				if bytes, err := json.Marshal(UserProtocolReadDataList); err != nil {
-					glogger.GLogger.Error(err)
-				} else {
-					glogger.GLogger.Debug(string(bytes))
-					gw.RuleEngine.WorkDevice(gw.Details(), string(bytes))
-				}
+				gw.RuleEngine.WorkDeviceMessage(gw.Details(),
+					typex.NewMessage(gw.PointId, UserProtocolReadDataList))
 			}
 		}
 	}
@@ -0,0 +1,438 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ithings
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/hootrhino/rhilex/glogger"
+)
+
+const (
+	METHOD_REPORT           = "report"
+	METHOD_CONTROL          = "control"
+	METHOD_CONTROL_REPLY    = "controlReply"
+	METHOD_GET_REPORT       = "getReport"
+	METHOD_GET_REPORT_REPLY = "getReportReply"
+	METHOD_EVENT_POST       = "eventPost"
+	METHOD_ACTION           = "action"
+	METHOD_ACTION_REPLY     = "actionReply"
+	METHOD_GET_SCHEMA       = "getSchema"
+	METHOD_BIND             = "bind"
+	METHOD_ONLINE           = "online"
+	METHOD_OFFLINE          = "offline"
+)
+
+// 子设备
+type SubDevice struct {
+	ProductId    string `json:"productID"`
+	DeviceName   string `json:"deviceName"`
+	DeviceSecret string `json:"-"` // 有密钥的时候绑定带签名
+}
+
+/*
+*
+* 物模型协议的消息, 上下行共用一个结构
+*
+ */
+type Message struct {
+	Method    string          `json:"method"`
+	MsgToken  string          `json:"msgToken"`
+	Timestamp int64           `json:"timestamp,omitempty"`
+	Params    map[string]any  `json:"params,omitempty"`
+	EventId   string          `json:"eventID,omitempty"`
+	ActionId  string          `json:"actionID,omitempty"`
+	Type      string          `json:"type,omitempty"`
+	Code      int             `json:"code,omitempty"`
+	Msg       string          `json:"msg,omitempty"`
+	Data      any             `json:"data,omitempty"`
+	Response  map[string]any  `json:"response,omitempty"`
+	Payload   *GatewayPayload `json:"payload,omitempty"`
+}
+
+// 网关拓扑消息的内容
+type GatewayPayload struct {
+	Devices []GatewayDevice `json:"devices"`
+}
+
+type GatewayDevice struct {
+	ProductId  string `json:"productID"`
+	DeviceName string `json:"deviceName"`
+	Signature  string `json:"signature,omitempty"`
+	SignType   string `json:"signType,omitempty"`
+	Random     int64  `json:"random,omitempty"`
+	Timestamp  int64  `json:"timestamp,omitempty"`
+	Code       int    `json:"code,omitempty"`
+}
+
+type ClientConfig struct {
+	Host         string
+	Port         int
+	ProductId    string
+	DeviceName   string
+	DeviceSecret string
+	HmacType     string        // hmacsha256|hmacsha1
+	Timeout      time.Duration // 等待云端回复的超时
+}
+
+/*
+*
+* 云端下发的请求; 子设备和网关自己都走这里, 用 productID/deviceName 区分
+*
+ */
+type Handler struct {
+	OnControl   func(device SubDevice, params map[string]any) error
+	OnGetReport func(device SubDevice) map[string]any
+	OnAction    func(device SubDevice, actionId string, params map[string]any) (map[string]any, error)
+}
+
+/*
+*
+* iThings 网关连接: 以网关设备的身份登录, 代理子设备收发物模型消息
+*
+ */
+type Client struct {
+	config  ClientConfig
+	handler Handler
+	client  mqtt.Client
+	locker  sync.Mutex
+	pending map[string]chan Message // msgToken -> 回复
+	devices map[string]SubDevice    // 已经订阅的子设备
+}
+
+func NewClient(config ClientConfig, handler Handler) *Client {
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	return &Client{
+		config:  config,
+		handler: handler,
+		pending: map[string]chan Message{},
+		devices: map[string]SubDevice{},
+	}
+}
+
+func ThingTopic(direction, kind, productId, deviceName string) string {
+	return fmt.Sprintf("$thing/%s/%s/%s/%s", direction, kind, productId, deviceName)
+}
+
+func GatewayTopic(direction, productId, deviceName string) string {
+	return fmt.Sprintf("$gateway/%s/operation/%s/%s", direction, productId, deviceName)
+}
+
+// 登录: 用设备密钥算用户名密码, 订阅网关自己的下行
+func (c *Client) Connect() error {
+	clientId, username, password := GenSecretDeviceInfo(c.config.HmacType,
+		c.config.ProductId, c.config.DeviceName, c.config.DeviceSecret)
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("tcp://%s:%v", c.config.Host, c.config.Port))
+	opts.SetClientID(clientId)
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false) // 不需要自动重连, 交给RHILEX管理
+	opts.SetConnectTimeout(c.config.Timeout)
+	opts.SetPingTimeout(c.config.Timeout)
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		glogger.GLogger.Warn("iThings connect lost:", err)
+	})
+	c.client = mqtt.NewClient(opts)
+	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	if err := c.subscribe(GatewayTopic("down", c.config.ProductId, c.config.DeviceName)); err != nil {
+		return err
+	}
+	return c.subscribeDevice(c.self())
+}
+
+func (c *Client) IsConnected() bool {
+	return c.client != nil && c.client.IsConnectionOpen()
+}
+
+func (c *Client) Disconnect() {
+	if c.client != nil {
+		c.client.Disconnect(100)
+	}
+}
+
+func (c *Client) self() SubDevice {
+	return SubDevice{ProductId: c.config.ProductId, DeviceName: c.config.DeviceName}
+}
+
+func (c *Client) subscribe(topic string) error {
+	token := c.client.Subscribe(topic, 1, c.onMessage)
+	if !token.WaitTimeout(c.config.Timeout) {
+		return fmt.Errorf("subscribe timeout: %s", topic)
+	}
+	return token.Error()
+}
+
+func (c *Client) subscribeDevice(device SubDevice) error {
+	key := device.ProductId + "/" + device.DeviceName
+	c.locker.Lock()
+	_, ok := c.devices[key]
+	c.locker.Unlock()
+	if ok {
+		return nil
+	}
+	if err := c.subscribe(fmt.Sprintf("$thing/down/+/%s/%s", device.ProductId, device.DeviceName)); err != nil {
+		return err
+	}
+	c.locker.Lock()
+	c.devices[key] = device
+	c.locker.Unlock()
+	return nil
+}
+
+/*
+*
+* 拓扑: 先绑定再上线; 绑定成功以后才能代理子设备的物模型消息
+*
+ */
+func (c *Client) AddTopology(devices []SubDevice) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	payload := &GatewayPayload{}
+	for _, device := range devices {
+		payload.Devices = append(payload.Devices, c.bindDevice(device))
+	}
+	if err := c.gatewayRequest(METHOD_BIND, payload); err != nil {
+		return err
+	}
+	for _, device := range devices {
+		if err := c.subscribeDevice(device); err != nil {
+			return err
+		}
+	}
+	return c.SetOnline(devices, true)
+}
+
+func (c *Client) bindDevice(device SubDevice) GatewayDevice {
+	bind := GatewayDevice{ProductId: device.ProductId, DeviceName: device.DeviceName}
+	if device.DeviceSecret == "" {
+		return bind
+	}
+	bind.Random = rand.Int63n(1 << 31)
+	bind.Timestamp = time.Now().Unix()
+	bind.SignType = T_HmacSha256
+	content := fmt.Sprintf("%s;%s;%d;%d", device.ProductId, device.DeviceName, bind.Random, bind.Timestamp)
+	secret, _ := base64.StdEncoding.DecodeString(device.DeviceSecret)
+	bind.Signature = HmacSha256(content, secret)
+	return bind
+}
+
+func (c *Client) SetOnline(devices []SubDevice, online bool) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	method := METHOD_ONLINE
+	if !online {
+		method = METHOD_OFFLINE
+	}
+	payload := &GatewayPayload{}
+	for _, device := range devices {
+		payload.Devices = append(payload.Devices, GatewayDevice{
+			ProductId:  device.ProductId,
+			DeviceName: device.DeviceName,
+		})
+	}
+	return c.gatewayRequest(method, payload)
+}
+
+func (c *Client) gatewayRequest(method string, payload *GatewayPayload) error {
+	reply, err := c.request(GatewayTopic("up", c.config.ProductId, c.config.DeviceName), Message{
+		Method:    method,
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+	if reply.Payload != nil {
+		for _, device := range reply.Payload.Devices {
+			if device.Code != 0 && device.Code != 200 {
+				return fmt.Errorf("%s %s/%s failed, code: %d", method,
+					device.ProductId, device.DeviceName, device.Code)
+			}
+		}
+	}
+	return nil
+}
+
+// 上报属性
+func (c *Client) ReportProperty(device SubDevice, params map[string]any) error {
+	_, err := c.request(ThingTopic("up", "property", device.ProductId, device.DeviceName), Message{
+		Method:    METHOD_REPORT,
+		Timestamp: time.Now().UnixMilli(),
+		Params:    params,
+	})
+	return err
+}
+
+// 上报事件
+func (c *Client) PostEvent(device SubDevice, eventId, eventType string, params map[string]any) error {
+	if eventType == "" {
+		eventType = "info"
+	}
+	_, err := c.request(ThingTopic("up", "event", device.ProductId, device.DeviceName), Message{
+		Method:    METHOD_EVENT_POST,
+		Timestamp: time.Now().UnixMilli(),
+		EventId:   eventId,
+		Type:      eventType,
+		Params:    params,
+	})
+	return err
+}
+
+// 从云端拉物模型
+func (c *Client) GetSchema(device SubDevice) (ThingModel, error) {
+	reply, err := c.request(ThingTopic("up", "schema", device.ProductId, device.DeviceName), Message{
+		Method:    METHOD_GET_SCHEMA,
+		Timestamp: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return ThingModel{}, err
+	}
+	if data, ok := reply.Data.(map[string]any); ok {
+		if schema, ok := data["schema"]; ok {
+			return ParseThingModel(schema)
+		}
+	}
+	return ParseThingModel(reply.Data)
+}
+
+/*
+*
+* 发请求等回复, 回复的 msgToken 和请求一样; code 不是 200 的算失败
+*
+ */
+func (c *Client) request(topic string, msg Message) (Message, error) {
+	if !c.IsConnected() {
+		return Message{}, fmt.Errorf("ithings not connected")
+	}
+	msg.MsgToken = Random(16, 3)
+	reply := make(chan Message, 1)
+	c.locker.Lock()
+	c.pending[msg.MsgToken] = reply
+	c.locker.Unlock()
+	defer func() {
+		c.locker.Lock()
+		delete(c.pending, msg.MsgToken)
+		c.locker.Unlock()
+	}()
+	if err := c.publish(topic, msg); err != nil {
+		return Message{}, err
+	}
+	select {
+	case r := <-reply:
+		if r.Code != 0 && r.Code != 200 {
+			return r, fmt.Errorf("%s failed, code: %d, msg: %s", msg.Method, r.Code, r.Msg)
+		}
+		return r, nil
+	case <-time.After(c.config.Timeout):
+		return Message{}, fmt.Errorf("%s timeout", msg.Method)
+	}
+}
+
+func (c *Client) publish(topic string, msg Message) error {
+	bytes, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	token := c.client.Publish(topic, 1, false, bytes)
+	if !token.WaitTimeout(c.config.Timeout) {
+		return fmt.Errorf("publish timeout: %s", topic)
+	}
+	return token.Error()
+}
+
+/*
+*
+* 下行: $thing/down/{property|event|action|schema}/{productID}/{deviceName}
+* 和 $gateway/down/operation/...; 回复交给等待的请求, 云端的请求交给 Handler
+*
+ */
+func (c *Client) onMessage(client mqtt.Client, m mqtt.Message) {
+	msg := Message{}
+	if err := json.Unmarshal(m.Payload(), &msg); err != nil {
+		glogger.GLogger.Error("iThings invalid message:", m.Topic(), err)
+		return
+	}
+	c.locker.Lock()
+	reply, ok := c.pending[msg.MsgToken]
+	c.locker.Unlock()
+	// 网关拓扑的回复 method 不带 Reply, 只要 msgToken 对上就是回复
+	if ok && (strings.HasSuffix(msg.Method, "Reply") || strings.HasPrefix(m.Topic(), "$gateway/")) {
+		reply <- msg
+		return
+	}
+	parts := strings.Split(m.Topic(), "/")
+	if len(parts) != 5 || parts[0] != "$thing" {
+		return
+	}
+	device := SubDevice{ProductId: parts[3], DeviceName: parts[4]}
+	// 下行的处理会调用设备, 不能阻塞 MQTT 的收包
+	go c.handle(parts[2], device, msg)
+}
+
+func (c *Client) handle(kind string, device SubDevice, msg Message) {
+	topic := ThingTopic("up", kind, device.ProductId, device.DeviceName)
+	switch {
+	case kind == "property" && msg.Method == METHOD_CONTROL:
+		reply := Message{Method: METHOD_CONTROL_REPLY, MsgToken: msg.MsgToken, Code: 200, Msg: "success"}
+		if c.handler.OnControl == nil {
+			reply.Code, reply.Msg = 400, "control not supported"
+		} else if err := c.handler.OnControl(device, msg.Params); err != nil {
+			reply.Code, reply.Msg = 400, err.Error()
+		}
+		c.reply(topic, reply)
+	case kind == "property" && msg.Method == METHOD_GET_REPORT:
+		reply := Message{Method: METHOD_GET_REPORT_REPLY, MsgToken: msg.MsgToken, Code: 200, Msg: "success"}
+		params := map[string]any{}
+		if c.handler.OnGetReport != nil {
+			params = c.handler.OnGetReport(device)
+		}
+		reply.Data = map[string]any{"params": params}
+		c.reply(topic, reply)
+	case kind == "action" && msg.Method == METHOD_ACTION:
+		reply := Message{Method: METHOD_ACTION_REPLY, MsgToken: msg.MsgToken,
+			ActionId: msg.ActionId, Code: 200, Msg: "success"}
+		if c.handler.OnAction == nil {
+			reply.Code, reply.Msg = 400, "action not supported"
+		} else if response, err := c.handler.OnAction(device, msg.ActionId, msg.Params); err != nil {
+			reply.Code, reply.Msg = 400, err.Error()
+		} else {
+			reply.Response = response
+		}
+		c.reply(topic, reply)
+	}
+}
+
+func (c *Client) reply(topic string, msg Message) {
+	msg.Timestamp = time.Now().UnixMilli()
+	if err := c.publish(topic, msg); err != nil {
+		glogger.GLogger.Error("iThings reply failed:", err)
+	}
+}
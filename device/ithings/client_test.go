@@ -0,0 +1,193 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ithings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+/*
+*
+* 本地 MQTT Broker 模拟 iThings 云端: 回复拓扑, 上报和物模型请求
+*
+ */
+type fakeCloud struct {
+	server  *mqttserver.Server
+	port    int
+	reports chan Message
+	replies chan Message
+	bound   []GatewayDevice
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func startFakeCloud(t *testing.T) *fakeCloud {
+	cloud := &fakeCloud{port: freePort(t), reports: make(chan Message, 8), replies: make(chan Message, 8)}
+	cloud.server = mqttserver.New(&mqttserver.Options{InlineClient: true,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	cloud.server.AddHook(new(auth.AllowHook), nil)
+	if err := cloud.server.AddListener(listeners.NewTCP(listeners.Config{
+		ID: "ithings", Address: fmt.Sprintf("127.0.0.1:%d", cloud.port)})); err != nil {
+		t.Fatal(err)
+	}
+	if err := cloud.server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	cloud.server.Subscribe("$gateway/up/operation/+/+", 1, func(cl *mqttserver.Client,
+		sub packets.Subscription, pk packets.Packet) {
+		msg := Message{}
+		json.Unmarshal(pk.Payload, &msg)
+		if msg.Method == METHOD_BIND {
+			cloud.bound = append(cloud.bound, msg.Payload.Devices...)
+		}
+		cloud.publish(strings.Replace(pk.TopicName, "/up/", "/down/", 1),
+			Message{Method: msg.Method, MsgToken: msg.MsgToken, Code: 200, Payload: msg.Payload})
+	})
+	cloud.server.Subscribe("$thing/up/+/+/+", 2, func(cl *mqttserver.Client,
+		sub packets.Subscription, pk packets.Packet) {
+		msg := Message{}
+		json.Unmarshal(pk.Payload, &msg)
+		down := strings.Replace(pk.TopicName, "/up/", "/down/", 1)
+		switch msg.Method {
+		case METHOD_REPORT, METHOD_EVENT_POST:
+			cloud.reports <- msg
+			cloud.publish(down, Message{Method: msg.Method + "Reply", MsgToken: msg.MsgToken, Code: 200})
+		case METHOD_GET_SCHEMA:
+			schema := `{"properties":[{"identifier":"temp","name":"温度","mode":"r",
+				"define":{"type":"float","unit":"℃","min":"-40","max":"120"}},
+				{"identifier":"switch","name":"开关","mode":"rw",
+				"define":{"type":"bool","mapping":{"0":"关","1":"开"}}}]}`
+			cloud.publish(down, Message{Method: "getSchemaReply", MsgToken: msg.MsgToken, Code: 200,
+				Data: map[string]any{"schema": schema}})
+		default:
+			cloud.replies <- msg
+		}
+	})
+	return cloud
+}
+
+func (cloud *fakeCloud) publish(topic string, msg Message) {
+	bytes, _ := json.Marshal(msg)
+	cloud.server.Publish(topic, bytes, false, 1)
+}
+
+func waitMessage(t *testing.T, ch chan Message) Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait message timeout")
+	}
+	return Message{}
+}
+
+// go test -timeout 30s -run ^Test_Ithings_Client github.com/hootrhino/rhilex/device/ithings -v -count=1
+func Test_Ithings_Client(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	cloud := startFakeCloud(t)
+	defer cloud.server.Close()
+	controlled := make(chan map[string]any, 1)
+	client := NewClient(ClientConfig{
+		Host: "127.0.0.1", Port: cloud.port, ProductId: "GW01", DeviceName: "gateway",
+		DeviceSecret: "c2VjcmV0", Timeout: 2 * time.Second,
+	}, Handler{
+		OnControl: func(device SubDevice, params map[string]any) error {
+			controlled <- params
+			return nil
+		},
+		OnAction: func(device SubDevice, actionId string, params map[string]any) (map[string]any, error) {
+			if actionId != "reboot" {
+				return nil, fmt.Errorf("unknown action: %s", actionId)
+			}
+			return map[string]any{"ok": true}, nil
+		},
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+	sensor := SubDevice{ProductId: "P01", DeviceName: "sensor1", DeviceSecret: "c2VjcmV0"}
+	if err := client.AddTopology([]SubDevice{sensor}); err != nil {
+		t.Fatal(err)
+	}
+	if len(cloud.bound) != 1 || cloud.bound[0].Signature == "" {
+		t.Fatal("sub device should be bound with signature", cloud.bound)
+	}
+	if err := client.ReportProperty(sensor, map[string]any{"temp": 21.5}); err != nil {
+		t.Fatal(err)
+	}
+	if report := waitMessage(t, cloud.reports); report.Params["temp"] != 21.5 {
+		t.Fatal("unexpected report", report)
+	}
+	if err := client.PostEvent(sensor, "overheat", "alert", map[string]any{"temp": 99}); err != nil {
+		t.Fatal(err)
+	}
+	if event := waitMessage(t, cloud.reports); event.EventId != "overheat" || event.Type != "alert" {
+		t.Fatal("unexpected event", event)
+	}
+	thingModel, err := client.GetSchema(sensor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := thingModel.Property("temp")
+	if !ok || p.Define.MinValue() != -40 || p.Define.MaxValue() != 120 {
+		t.Fatal("unexpected thing model", thingModel)
+	}
+	if v := ConvertValue(&p.Define, "21.5"); v != 21.5 {
+		t.Fatal("unexpected value", v)
+	}
+	// 云端下发属性
+	cloud.publish(ThingTopic("down", "property", "P01", "sensor1"),
+		Message{Method: METHOD_CONTROL, MsgToken: "c1", Params: map[string]any{"switch": true}})
+	if params := <-controlled; params["switch"] != true {
+		t.Fatal("unexpected control", params)
+	}
+	if reply := waitMessage(t, cloud.replies); reply.Method != METHOD_CONTROL_REPLY || reply.Code != 200 {
+		t.Fatal("unexpected control reply", reply)
+	}
+	// 云端调用行为
+	cloud.publish(ThingTopic("down", "action", "P01", "sensor1"),
+		Message{Method: METHOD_ACTION, MsgToken: "a1", ActionId: "reboot"})
+	reply := waitMessage(t, cloud.replies)
+	if reply.Method != METHOD_ACTION_REPLY || reply.Code != 200 || reply.Response["ok"] != true {
+		t.Fatal("unexpected action reply", reply)
+	}
+	cloud.publish(ThingTopic("down", "action", "P01", "sensor1"),
+		Message{Method: METHOD_ACTION, MsgToken: "a2", ActionId: "format"})
+	if reply := waitMessage(t, cloud.replies); reply.Code != 400 {
+		t.Fatal("unknown action should fail", reply)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ithings
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+*
+* 云端的物模型, 一个产品一份
+*
+ */
+type ThingModel struct {
+	Properties []ThingProperty `json:"properties"`
+	Events     []ThingEvent    `json:"events"`
+	Actions    []ThingAction   `json:"actions"`
+}
+
+type ThingProperty struct {
+	Identifier string      `json:"identifier"`
+	Name       string      `json:"name"`
+	Desc       string      `json:"desc"`
+	Mode       string      `json:"mode"` // r|rw
+	Define     ThingDefine `json:"define"`
+}
+
+type ThingEvent struct {
+	Identifier string       `json:"identifier"`
+	Name       string       `json:"name"`
+	Desc       string       `json:"desc"`
+	Type       string       `json:"type"` // info|alert|fault
+	Params     []ThingParam `json:"params"`
+}
+
+type ThingAction struct {
+	Identifier string       `json:"identifier"`
+	Name       string       `json:"name"`
+	Desc       string       `json:"desc"`
+	Input      []ThingParam `json:"input"`
+	Output     []ThingParam `json:"output"`
+}
+
+type ThingParam struct {
+	Identifier string      `json:"identifier"`
+	Name       string      `json:"name"`
+	Define     ThingDefine `json:"define"`
+}
+
+/*
+*
+* 数据定义; 云端的 min/max/step 有时候是字符串, 有时候是数字
+*
+ */
+type ThingDefine struct {
+	Type      string            `json:"type"` // bool|int|float|string|enum|timestamp|struct|array
+	Unit      string            `json:"unit"`
+	Min       any               `json:"min"`
+	Max       any               `json:"max"` // 数组的时候是最大元素个数
+	Step      any               `json:"step"`
+	Mapping   map[string]string `json:"mapping"`
+	Specs     []ThingSpec       `json:"specs,omitempty"`     // struct 的字段
+	ArrayInfo *ThingDefine      `json:"arrayInfo,omitempty"` // array 的元素
+}
+
+// 结构体字段
+type ThingSpec struct {
+	Identifier string      `json:"identifier"`
+	Name       string      `json:"name"`
+	DataType   ThingDefine `json:"dataType"`
+}
+
+func (d ThingDefine) MinValue() float64 {
+	return toFloat(d.Min)
+}
+
+func (d ThingDefine) MaxValue() float64 {
+	return toFloat(d.Max)
+}
+
+func toFloat(v any) float64 {
+	switch T := v.(type) {
+	case float64:
+		return T
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(T), 64)
+		return f
+	}
+	return 0
+}
+
+// 云端回复里物模型可能是 JSON 字符串, 也可能是对象
+func ParseThingModel(v any) (ThingModel, error) {
+	model := ThingModel{}
+	var bytes []byte
+	switch T := v.(type) {
+	case string:
+		bytes = []byte(T)
+	case nil:
+		return model, fmt.Errorf("empty thing model")
+	default:
+		bytes, _ = json.Marshal(T)
+	}
+	if err := json.Unmarshal(bytes, &model); err != nil {
+		return model, fmt.Errorf("invalid thing model: %s", err)
+	}
+	return model, nil
+}
+
+func (m ThingModel) Property(identifier string) (ThingProperty, bool) {
+	for _, p := range m.Properties {
+		if p.Identifier == identifier {
+			return p, true
+		}
+	}
+	return ThingProperty{}, false
+}
+
+/*
+*
+* 点位表里的值大多是字符串, 按物模型的类型转换以后再上报;
+* 没有物模型的时候能转成数字的就转成数字
+*
+ */
+func ConvertValue(define *ThingDefine, value any) any {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	s = strings.TrimSpace(s)
+	if define == nil {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+		return s
+	}
+	switch define.Type {
+	case "bool":
+		return s == "1" || strings.EqualFold(s, "true")
+	case "int", "enum", "timestamp":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return int64(f)
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
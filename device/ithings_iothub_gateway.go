@@ -0,0 +1,240 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hootrhino/rhilex/device/ithings"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+type __IthingsSubDevice struct {
+	ProductId    string `json:"productId" validate:"required"`
+	DeviceName   string `json:"deviceName" validate:"required"`
+	DeviceSecret string `json:"deviceSecret"`
+}
+
+type __IthingsGatewayConfig struct {
+	Host         string               `json:"host" validate:"required"`
+	Port         int                  `json:"port" validate:"required"`
+	ProductId    string               `json:"productId" validate:"required"`
+	DeviceName   string               `json:"deviceName" validate:"required"`
+	DeviceSecret string               `json:"deviceSecret" validate:"required"`
+	HmacType     string               `json:"hmacType"` // hmacsha256|hmacsha1
+	SubDevices   []__IthingsSubDevice `json:"subDevices"`
+}
+
+type __IthingsGatewayMainConfig struct {
+	IthingsConfig __IthingsGatewayConfig `json:"ithingsConfig" validate:"required"`
+}
+
+/*
+*
+* iThings 网关设备: 云端下发的属性设置和行为调用作为设备数据进规则,
+* 规则里通过 device:Ctrl 上报属性和事件
+*
+ */
+type IthingsIothubGateway struct {
+	typex.XStatus
+	status     typex.SourceState
+	mainConfig __IthingsGatewayMainConfig
+	client     *ithings.Client
+	locker     sync.Mutex
+	reported   map[string]map[string]any // 设备 -> 最近一次上报的属性, 云端获取的时候返回
+}
+
+func NewIthingsIothubGateway(e typex.Rhilex) typex.XDevice {
+	gw := new(IthingsIothubGateway)
+	gw.RuleEngine = e
+	gw.reported = map[string]map[string]any{}
+	return gw
+}
+
+func (gw *IthingsIothubGateway) Init(devId string, configMap map[string]any) error {
+	gw.PointId = devId
+	if err := utils.BindSourceConfig(configMap, &gw.mainConfig); err != nil {
+		glogger.GLogger.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (gw *IthingsIothubGateway) Start(cctx typex.CCTX) error {
+	gw.Ctx = cctx.Ctx
+	gw.CancelCTX = cctx.CancelCTX
+	config := gw.mainConfig.IthingsConfig
+	gw.client = ithings.NewClient(ithings.ClientConfig{
+		Host:         config.Host,
+		Port:         config.Port,
+		ProductId:    config.ProductId,
+		DeviceName:   config.DeviceName,
+		DeviceSecret: config.DeviceSecret,
+		HmacType:     config.HmacType,
+	}, ithings.Handler{
+		OnControl:   gw.onControl,
+		OnGetReport: gw.onGetReport,
+		OnAction:    gw.onAction,
+	})
+	if err := gw.client.Connect(); err != nil {
+		return err
+	}
+	if devices := gw.subDevices(); len(devices) > 0 {
+		if err := gw.client.AddTopology(devices); err != nil {
+			gw.client.Disconnect()
+			return err
+		}
+	}
+	gw.status = typex.SOURCE_UP
+	return nil
+}
+
+func (gw *IthingsIothubGateway) subDevices() []ithings.SubDevice {
+	devices := []ithings.SubDevice{}
+	for _, d := range gw.mainConfig.IthingsConfig.SubDevices {
+		devices = append(devices, ithings.SubDevice{
+			ProductId:    d.ProductId,
+			DeviceName:   d.DeviceName,
+			DeviceSecret: d.DeviceSecret,
+		})
+	}
+	return devices
+}
+
+// 下行的数据格式, 规则里按 method 区分
+type ithingsDownlink struct {
+	ProductId  string         `json:"productId"`
+	DeviceName string         `json:"deviceName"`
+	Method     string         `json:"method"`
+	ActionId   string         `json:"actionId,omitempty"`
+	Params     map[string]any `json:"params"`
+}
+
+func (gw *IthingsIothubGateway) work(downlink ithingsDownlink) error {
+	bytes, _ := json.Marshal(downlink)
+	_, err := gw.RuleEngine.WorkDevice(gw.Details(), string(bytes))
+	return err
+}
+
+func (gw *IthingsIothubGateway) onControl(device ithings.SubDevice, params map[string]any) error {
+	return gw.work(ithingsDownlink{ProductId: device.ProductId, DeviceName: device.DeviceName,
+		Method: ithings.METHOD_CONTROL, Params: params})
+}
+
+func (gw *IthingsIothubGateway) onGetReport(device ithings.SubDevice) map[string]any {
+	gw.locker.Lock()
+	defer gw.locker.Unlock()
+	params := map[string]any{}
+	for k, v := range gw.reported[device.ProductId+"/"+device.DeviceName] {
+		params[k] = v
+	}
+	return params
+}
+
+// 规则是异步执行的, 行为调用只回复已经收到
+func (gw *IthingsIothubGateway) onAction(device ithings.SubDevice, actionId string,
+	params map[string]any) (map[string]any, error) {
+	return map[string]any{}, gw.work(ithingsDownlink{ProductId: device.ProductId,
+		DeviceName: device.DeviceName, Method: ithings.METHOD_ACTION, ActionId: actionId, Params: params})
+}
+
+func (gw *IthingsIothubGateway) Status() typex.SourceState {
+	if gw.client != nil && !gw.client.IsConnected() {
+		return typex.SOURCE_DOWN
+	}
+	return gw.status
+}
+
+func (gw *IthingsIothubGateway) Stop() {
+	gw.status = typex.SOURCE_DOWN
+	if gw.CancelCTX != nil {
+		gw.CancelCTX()
+	}
+	if gw.client != nil {
+		gw.client.Disconnect()
+	}
+}
+
+func (gw *IthingsIothubGateway) Details() *typex.Device {
+	return gw.RuleEngine.GetDevice(gw.PointId)
+}
+
+func (gw *IthingsIothubGateway) SetState(status typex.SourceState) {
+	gw.status = status
+}
+
+func (gw *IthingsIothubGateway) OnDCACall(UUID string, Command string, Args any) typex.DCAResult {
+	return typex.DCAResult{}
+}
+
+// 上行的参数; 不填 productId 的时候就是网关自己
+type ithingsUplink struct {
+	ProductId  string         `json:"productId"`
+	DeviceName string         `json:"deviceName"`
+	EventId    string         `json:"eventId"`
+	Type       string         `json:"type"`
+	Params     map[string]any `json:"params"`
+}
+
+/*
+*
+* PropertyReport: {"productId","deviceName","params"}
+* EventPost: {"productId","deviceName","eventId","type","params"}
+*
+ */
+func (gw *IthingsIothubGateway) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	if gw.client == nil || !gw.client.IsConnected() {
+		return nil, fmt.Errorf("iThings is not connected")
+	}
+	uplink := ithingsUplink{}
+	if err := json.Unmarshal(args, &uplink); err != nil {
+		return nil, err
+	}
+	device := ithings.SubDevice{ProductId: uplink.ProductId, DeviceName: uplink.DeviceName}
+	if device.ProductId == "" {
+		device.ProductId = gw.mainConfig.IthingsConfig.ProductId
+		device.DeviceName = gw.mainConfig.IthingsConfig.DeviceName
+	}
+	switch string(cmd) {
+	case "PropertyReport":
+		if err := gw.client.ReportProperty(device, uplink.Params); err != nil {
+			return nil, err
+		}
+		gw.locker.Lock()
+		key := device.ProductId + "/" + device.DeviceName
+		if gw.reported[key] == nil {
+			gw.reported[key] = map[string]any{}
+		}
+		for k, v := range uplink.Params {
+			gw.reported[key][k] = v
+		}
+		gw.locker.Unlock()
+		return []byte("ok"), nil
+	case "EventPost":
+		if uplink.Type == "" {
+			uplink.Type = "info"
+		}
+		if err := gw.client.PostEvent(device, uplink.EventId, uplink.Type, uplink.Params); err != nil {
+			return nil, err
+		}
+		return []byte("ok"), nil
+	}
+	return nil, fmt.Errorf("unsupported command: %s", cmd)
+}
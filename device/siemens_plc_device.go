@@ -3,7 +3,6 @@ package device
 import (
 	"context"
 
-	"encoding/json"
 	"errors"
 
 	"sync"
@@ -193,11 +192,8 @@ func (s1200 *SIEMENS_PLC) Start(cctx typex.CCTX) error {
 			}
 			if !s1200.mainConfig.CommonConfig.BatchRequest {
 				if len(ReadPLCRegisterValues) > 0 {
-					if bytes, err := json.Marshal(ReadPLCRegisterValues); err != nil {
-						glogger.GLogger.Error(err)
-					} else {
-						s1200.RuleEngine.WorkDevice(s1200.Details(), string(bytes))
-					}
+					s1200.RuleEngine.WorkDeviceMessage(s1200.Details(),
+						typex.NewMessage(s1200.PointId, ReadPLCRegisterValues))
 				}
 			}
 		}
@@ -299,11 +295,8 @@ func (s1200 *SIEMENS_PLC) Read() []ReadPLCRegisterValue {
 				ErrMsg:        "",
 			})
 			if !s1200.mainConfig.CommonConfig.BatchRequest {
-				if bytes, errMarshal := json.Marshal(PlcReadReg); errMarshal != nil {
-					glogger.GLogger.Error(errMarshal)
-				} else {
-					s1200.RuleEngine.WorkDevice(s1200.Details(), string(bytes))
-				}
+				s1200.RuleEngine.WorkDeviceMessage(s1200.Details(),
+					typex.NewMessage(s1200.PointId, PlcReadReg))
 			}
 		}
 		if db.Frequency < 10 {
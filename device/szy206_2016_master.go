@@ -16,7 +16,6 @@
 package device
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -278,15 +277,11 @@ func (gw *SZY206_2016_MasterGateway) work(handler *szy2062016.SZY206ClientHandle
 			NewValue.ErrMsg = ""
 			intercache.SetValue(gw.PointId, DataPoint.UUID, NewValue)
 			if !*gw.mainConfig.CommonConfig.BatchRequest {
-				if bytes, err := json.Marshal(SZY2062016ReadData{
-					MeterId: DataPoint.MeterId,
-					Value:   Value,
-				}); err != nil {
-					glogger.GLogger.Error(err)
-				} else {
-					glogger.GLogger.Debug(string(bytes))
-					gw.RuleEngine.WorkDevice(gw.Details(), string(bytes))
-				}
+				gw.RuleEngine.WorkDeviceMessage(gw.Details(),
+					typex.NewMessage(gw.PointId, SZY2062016ReadData{
+						MeterId: DataPoint.MeterId,
+						Value:   Value,
+					}))
 			} else {
 				SZY2062016ReadDataList = append(SZY2062016ReadDataList, SZY2062016ReadData{
 					MeterId: DataPoint.MeterId,
@@ -298,12 +293,8 @@ func (gw *SZY206_2016_MasterGateway) work(handler *szy2062016.SZY206ClientHandle
 		}
 		if *gw.mainConfig.CommonConfig.BatchRequest {
 			if len(SZY2062016ReadDataList) > 0 {
-				if bytes, err := json.Marshal(SZY2062016ReadDataList); err != nil {
-					glogger.GLogger.Error(err)
-				} else {
-					glogger.GLogger.Debug(string(bytes))
-					gw.RuleEngine.WorkDevice(gw.Details(), string(bytes))
-				}
+				gw.RuleEngine.WorkDeviceMessage(gw.Details(),
+					typex.NewMessage(gw.PointId, SZY2062016ReadDataList))
 			}
 		}
 	}
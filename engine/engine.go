@@ -20,8 +20,6 @@ import (
 	"fmt"
 	"runtime"
 
-	lua "github.com/hootrhino/gopher-lua"
-
 	intercache "github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/intermetric"
 	"github.com/hootrhino/rhilex/component/interqueue"
@@ -33,7 +31,6 @@ import (
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/hootrhino/rhilex/utils"
 	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/sirupsen/logrus"
 )
 
 // 规则引擎
@@ -123,75 +120,30 @@ func (e *RuleEngine) WorkDevice(Device *typex.Device, data string) (bool, error)
 	return true, nil
 }
 
-// RunSourceCallbacks 执行针对资源端的规则脚本
-func (e *RuleEngine) RunSourceCallbacks(in *typex.InEnd, callbackArgs string) {
-	// 执行来自资源的脚本
-	for _, rule := range in.BindRules {
-		if rule.Status == typex.RULE_RUNNING {
-			if err := e.executeRule(&rule, callbackArgs); err != nil {
-				return
-			}
-		}
+// 核心功能: Work, 推送结构化的消息
+func (e *RuleEngine) WorkInEndMessage(in *typex.InEnd, msg *typex.Message) (bool, error) {
+	if err := interqueue.PushInQueueMessage(in, msg); err != nil {
+		return false, err
 	}
+	return true, nil
 }
 
-// RunDeviceCallbacks 执行针对设备端的规则脚本
-func (e *RuleEngine) RunDeviceCallbacks(Device *typex.Device, callbackArgs string) {
-	for _, rule := range Device.BindRules {
-		if err := e.executeRule(&rule, callbackArgs); err != nil {
-			return
-		}
+// 核心功能: Work, 推送结构化的消息
+func (e *RuleEngine) WorkDeviceMessage(Device *typex.Device, msg *typex.Message) (bool, error) {
+	if err := interqueue.PushDeviceQueueMessage(Device, msg); err != nil {
+		return false, err
 	}
+	return true, nil
 }
 
-// executeRule 执行规则脚本的公共逻辑
-func (e *RuleEngine) executeRule(rule *typex.Rule, callbackArgs string) error {
-	// 执行规则动作
-	_, errA := luaexecutor.ExecuteActions(rule, lua.LString(callbackArgs))
-	if errA != nil {
-		e.handleError(rule, errA)
-		return errA
-	}
-
-	// 执行成功处理
-	_, errS := luaexecutor.ExecuteSuccess(rule.LuaVM)
-	if errS != nil {
-		glogger.GLogger.Error(errS)
-		return errS
-	}
-
-	return nil
+// RunSourceCallbacks 执行针对资源端的规则脚本
+func (e *RuleEngine) RunSourceCallbacks(in *typex.InEnd, callbackArgs string) {
+	luaexecutor.RunSourceCallbacks(in, callbackArgs)
 }
 
-// handleError 处理规则执行错误
-func (e *RuleEngine) handleError(rule *typex.Rule, err error) {
-	Debugger, Ok := rule.LuaVM.GetStack(1)
-	if !Ok {
-		return
-	}
-
-	LValue, _ := rule.LuaVM.GetInfo("f", Debugger, lua.LNil)
-	rule.LuaVM.GetInfo("l", Debugger, lua.LNil)
-	rule.LuaVM.GetInfo("S", Debugger, lua.LNil)
-	rule.LuaVM.GetInfo("u", Debugger, lua.LNil)
-	rule.LuaVM.GetInfo("n", Debugger, lua.LNil)
-
-	LFunction := LValue.(*lua.LFunction)
-	LastCall := lua.DbgCall{
-		Name: "_main",
-	}
-	if len(LFunction.Proto.DbgCalls) > 0 {
-		LastCall = LFunction.Proto.DbgCalls[0]
-	}
-
-	glogger.GLogger.WithFields(logrus.Fields{
-		"topic": "rule/log/" + rule.UUID,
-	}).Warnf("Function Name: [%s],"+
-		"What: [%s], Source Line: [%d],"+
-		" Last Call: [%s], Error message: %s",
-		Debugger.Name, Debugger.What, Debugger.CurrentLine,
-		LastCall.Name, err.Error(),
-	)
+// RunDeviceCallbacks 执行针对设备端的规则脚本
+func (e *RuleEngine) RunDeviceCallbacks(Device *typex.Device, callbackArgs string) {
+	luaexecutor.RunDeviceCallbacks(Device, callbackArgs)
 }
 
 func (e *RuleEngine) GetInEnd(uuid string) *typex.InEnd {
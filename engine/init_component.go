@@ -20,6 +20,7 @@ import (
 	"github.com/hootrhino/rhilex/applet"
 	"github.com/hootrhino/rhilex/cecolla"
 	"github.com/hootrhino/rhilex/component/aibase"
+	"github.com/hootrhino/rhilex/component/appletbundle"
 	"github.com/hootrhino/rhilex/component/crontask"
 	"github.com/hootrhino/rhilex/component/eventbus"
 	intercache "github.com/hootrhino/rhilex/component/intercache"
@@ -29,8 +30,12 @@ import (
 	"github.com/hootrhino/rhilex/component/internotify"
 	"github.com/hootrhino/rhilex/component/interqueue"
 	"github.com/hootrhino/rhilex/component/lostcache"
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/rulestream"
 	"github.com/hootrhino/rhilex/component/security"
+	"github.com/hootrhino/rhilex/component/streamwindow"
 	supervisor "github.com/hootrhino/rhilex/component/supervisor"
+	"github.com/hootrhino/rhilex/component/trafficcapture"
 	core "github.com/hootrhino/rhilex/config"
 	datacenter "github.com/hootrhino/rhilex/datacenter"
 	"github.com/hootrhino/rhilex/glogger"
@@ -72,8 +77,18 @@ func InitAllComponent(__DefaultRuleEngine typex.Rhilex) {
 	applet.InitAppletRuntime(__DefaultRuleEngine)
 	// current only support Internal ai
 	aibase.InitAlgorithmRuntime(__DefaultRuleEngine)
+	// Rule execute budget
+	luaexecutor.InitRuleSandbox(core.GlobalConfig)
+	// Stream window
+	streamwindow.InitStreamWindow(core.GlobalConfig)
+	// Rule stream
+	rulestream.InitRuleStream(core.GlobalConfig)
+	// Traffic capture
+	trafficcapture.InitTrafficCapture(core.GlobalConfig)
+	// Applet bundle signature
+	appletbundle.InitAppletBundle(core.GlobalConfig)
 	// Internal Queue
-	interqueue.InitXQueue(__DefaultRuleEngine, core.GlobalConfig.MaxQueueSize)
+	interqueue.InitXQueue(__DefaultRuleEngine, core.GlobalConfig)
 	// Init Transceiver Communicator Manager
 	transceiver.InitTransceiverManager(__DefaultRuleEngine)
 	// Init Device Registry
@@ -97,6 +112,9 @@ func StopAllComponent() {
 	crontask.StopCronRebootExecutor()
 	supervisor.StopSupervisorAdmin()
 	applet.Stop()
+	streamwindow.Stop()
+	rulestream.Stop()
+	trafficcapture.StopAll()
 	intercache.Flush()
 	aibase.Stop()
 	transceiver.Stop()
@@ -155,7 +155,8 @@ func startDevice(xDevice typex.XDevice, e *RuleEngine,
 		// bind 最新的规则 要从数据库拿刚更新的
 		for _, rule := range device.BindRules {
 			glogger.GLogger.Debugf("Load rule:(%s,%s)", rule.UUID, rule.Name)
-			RuleInstance := typex.NewLuaRule(e,
+			RuleInstance := typex.NewTypedRule(e,
+				rule.Type,
 				rule.UUID,
 				rule.Name,
 				rule.Description,
@@ -16,7 +16,12 @@
 package engine
 
 import (
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/exprrule"
+	"github.com/hootrhino/rhilex/component/luamodule"
 	"github.com/hootrhino/rhilex/component/luaruntime"
+	"github.com/hootrhino/rhilex/component/rulestream"
+	"github.com/hootrhino/rhilex/component/streamwindow"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
 )
@@ -24,6 +29,9 @@ import (
 // LoadRule: 每个规则都绑定了资源(FromSource)或者设备(FromDevice)
 // 使用MAP来记录RULE的绑定关系, KEY是UUID, Value是规则
 func (e *RuleEngine) LoadRule(r *typex.Rule) error {
+	if r.Type == typex.RULE_TYPE_EXPR {
+		return e.loadExprRule(r)
+	}
 	// 前置语法验证
 	if err := luaruntime.VerifyLuaSyntax(r); err != nil {
 		return err
@@ -32,24 +40,63 @@ func (e *RuleEngine) LoadRule(r *typex.Rule) error {
 	if err := luaruntime.LoadExtLuaLib(e, r.LuaVM); err != nil {
 		return err
 	}
+	// 规则流不能成环
+	if err := rulestream.Bind(r); err != nil {
+		return err
+	}
 	e.SaveRule(r)
 	//--------------------------------------------------------------
 	// Load LoadBuildInLuaLib
 	//--------------------------------------------------------------
 	luaruntime.LoadRuleLibGroup(e, "RULE", r.UUID, r.LuaVM)
+	// 池子里新建的虚拟机和 LuaVM 加载同样的脚本和库
+	r.VMPool.SetFactory(func() (*lua.LState, error) {
+		LuaVM := typex.NewRuleLState(e)
+		luamodule.Install(LuaVM, luamodule.SCOPE_RULE, r.UUID)
+		if err := luaruntime.LoadExtLuaLib(e, LuaVM); err != nil {
+			return nil, err
+		}
+		for _, script := range []string{r.Success, r.Actions, r.Failed} {
+			if err := LuaVM.DoString(script); err != nil {
+				return nil, err
+			}
+		}
+		luaruntime.LoadRuleLibGroup(e, "RULE", r.UUID, LuaVM)
+		return LuaVM, nil
+	})
 	glogger.GLogger.Infof("Rule [%s, %s] load successfully", r.UUID, r.Name)
+	e.bindRule(r)
+	return nil
+
+}
+
+// 声明式规则: 编译成处理流程, 不需要虚拟机
+func (e *RuleEngine) loadExprRule(r *typex.Rule) error {
+	pipeline, err := exprrule.Compile(e, r.UUID, r.Actions)
+	if err != nil {
+		return err
+	}
+	r.Pipeline = pipeline
+	if err := rulestream.Bind(r); err != nil {
+		return err
+	}
+	e.SaveRule(r)
+	glogger.GLogger.Infof("Expr rule [%s, %s] load successfully", r.UUID, r.Name)
+	e.bindRule(r)
+	return nil
+}
+
+func (e *RuleEngine) bindRule(r *typex.Rule) {
 	// 查找输入定义的资源是否存在
 	if in := e.GetInEnd(r.FromSource); in != nil {
 		(in.BindRules)[r.UUID] = *r
-		return nil
+		return
 	}
 	// 查找输入定义的资源是否存在
 	if Device := e.GetDevice(r.FromDevice); Device != nil {
 		// 绑定资源和规则，建立关联关系
 		(Device.BindRules)[r.UUID] = *r
 	}
-	return nil
-
 }
 
 // GetRule a rule
@@ -85,6 +132,12 @@ func (e *RuleEngine) RemoveRule(ruleId string) {
 			}
 		}
 		e.Rules.Delete(ruleId)
+		if rule.VMPool != nil {
+			rule.VMPool.Close()
+		}
+		luamodule.Forget(luamodule.SCOPE_RULE, ruleId)
+		streamwindow.Release(ruleId)
+		rulestream.Unbind(ruleId)
 		glogger.GLogger.Infof("Rule [%s, %s] has been deleted", ruleId, rule.Name)
 	}
 }
@@ -103,7 +103,8 @@ func (e *RuleEngine) startSource(source typex.XSource,
 	Source := source.Details()
 	if Source != nil {
 		for _, rule := range Source.BindRules {
-			RuleInstance := typex.NewLuaRule(e,
+			RuleInstance := typex.NewTypedRule(e,
+				rule.Type,
 				rule.UUID,
 				rule.Name,
 				rule.Description,
@@ -17,12 +17,17 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
 	"time"
 
+	"github.com/hootrhino/rhilex/component/appletbundle"
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/ruletest"
+	"github.com/hootrhino/rhilex/component/trafficcapture"
 	"github.com/hootrhino/rhilex/engine"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/ossupport"
@@ -342,6 +347,192 @@ func main() {
 					return nil
 				},
 			},
+			// 规则测试, 给 CI 用: 有失败的用例就返回非 0
+			{
+				Name:  "test-rules",
+				Usage: "Run rule fixtures",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "db",
+						Usage: "run fixtures stored in rhilex database",
+						Value: "",
+					},
+					&cli.StringFlag{
+						Name:  "suite",
+						Usage: "run fixtures exported by /rules/fixtures/export",
+						Value: "",
+					},
+					&cli.IntFlag{
+						Name:  "timeout",
+						Usage: "rule execute timeout(ms)",
+						Value: 5000,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					glogger.StartGLogger(glogger.LogConfig{
+						AppID:         "rhilex",
+						LogLevel:      "fatal",
+						EnableConsole: true,
+					})
+					luaexecutor.InitRuleSandbox(typex.RhilexConfig{
+						RuleExecuteTimeout: c.Int("timeout"),
+					})
+					var suites []ruletest.Suite
+					var err error
+					switch {
+					case c.String("suite") != "":
+						suites, err = ruletest.LoadSuites(c.String("suite"))
+					case c.String("db") != "":
+						suites, err = ruletest.LoadSuitesFromDb(c.String("db"))
+					default:
+						return cli.Exit("[RHILEX TEST] --suite or --db is required", 2)
+					}
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX TEST] Load fixtures failed: %s", err), 2)
+					}
+					results := []ruletest.SuiteResult{}
+					for _, suite := range suites {
+						results = append(results, ruletest.RunSuite(nil, suite))
+					}
+					if failed := ruletest.Report(os.Stdout, results); failed > 0 {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			// 回放抓包: 对比两个版本的规则, 输出不一样就返回 1
+			{
+				Name:  "replay-rules",
+				Usage: "Replay captured traffic through a rule",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "capture",
+						Usage:    "capture file downloaded from /capture/download",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "db",
+						Usage:    "rhilex database to load the rule from",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "rule",
+						Usage:    "rule uuid",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "candidate",
+						Usage: "new version of the rule: a lua file of Actions, or a json file with actions/success/failed",
+						Value: "",
+					},
+					&cli.Float64Flag{
+						Name:  "speed",
+						Usage: "0: as fast as possible, 1: original speed, >1: accelerated",
+						Value: 0,
+					},
+					&cli.IntFlag{
+						Name:  "timeout",
+						Usage: "rule execute timeout(ms)",
+						Value: 5000,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					glogger.StartGLogger(glogger.LogConfig{
+						AppID:         "rhilex",
+						LogLevel:      "fatal",
+						EnableConsole: true,
+					})
+					luaexecutor.InitRuleSandbox(typex.RhilexConfig{
+						RuleExecuteTimeout: c.Int("timeout"),
+					})
+					file, err := os.Open(c.String("capture"))
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX REPLAY] Open capture failed: %s", err), 2)
+					}
+					records, err := trafficcapture.Parse(file)
+					file.Close()
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX REPLAY] Load capture failed: %s", err), 2)
+					}
+					suite, err := ruletest.LoadRuleFromDb(c.String("db"), c.String("rule"))
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX REPLAY] Load rule failed: %s", err), 2)
+					}
+					var candidate *ruletest.RuleScript
+					if c.String("candidate") != "" {
+						candidate, err = ruletest.LoadCandidate(suite.Rule, c.String("candidate"))
+						if err != nil {
+							return cli.Exit(fmt.Sprintf("[RHILEX REPLAY] Load candidate failed: %s", err), 2)
+						}
+					}
+					report, err := ruletest.ReplaySuite(context.Background(), suite, candidate, records,
+						ruletest.ReplayOptions{Speed: c.Float64("speed")})
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX REPLAY] Replay failed: %s", err), 2)
+					}
+					if ruletest.ReportReplay(os.Stdout, report) > 0 {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			// 供应商给应用包签名; 没有 key 的时候生成一对新的密钥
+			{
+				Name:  "sign-applet",
+				Usage: "Sign an applet bundle, or generate a signing key pair",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "bundle",
+						Usage: "applet bundle zip to sign",
+						Value: "",
+					},
+					&cli.StringFlag{
+						Name:  "key",
+						Usage: "file of the base64 ed25519 private key",
+						Value: "",
+					},
+					&cli.StringFlag{
+						Name:  "out",
+						Usage: "signed bundle, default overwrite the input",
+						Value: "",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.String("key") == "" {
+						publicKey, privateKey, err := appletbundle.GenerateKey()
+						if err != nil {
+							return cli.Exit(fmt.Sprintf("[RHILEX SIGN] Generate key failed: %s", err), 2)
+						}
+						fmt.Printf("public key (applet_trusted_keys): %s\nprivate key: %s\n", publicKey, privateKey)
+						return nil
+					}
+					keyBytes, err := os.ReadFile(c.String("key"))
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX SIGN] Read key failed: %s", err), 2)
+					}
+					key, err := appletbundle.ParsePrivateKey(string(keyBytes))
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX SIGN] %s", err), 2)
+					}
+					data, err := os.ReadFile(c.String("bundle"))
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX SIGN] Read bundle failed: %s", err), 2)
+					}
+					signed, err := appletbundle.Sign(data, key)
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX SIGN] Sign bundle failed: %s", err), 2)
+					}
+					out := c.String("out")
+					if out == "" {
+						out = c.String("bundle")
+					}
+					if err := os.WriteFile(out, signed, 0644); err != nil {
+						return cli.Exit(fmt.Sprintf("[RHILEX SIGN] Write bundle failed: %s", err), 2)
+					}
+					fmt.Printf("signed by key %s: %s\n", appletbundle.KeyId(key.Public().(ed25519.PublicKey)), out)
+					return nil
+				},
+			},
 			// version
 			{
 				Name:        "version",
@@ -41,6 +41,12 @@ const (
 	DataCenterPath = MainWorkDir + "rhilex_datacenter.db"
 	// 离线缓存的数据
 	LostCacheDataPath = MainWorkDir + "rhilex_lostcache.db"
+	// 流式窗口快照
+	StreamWindowDir = MainWorkDir + "rhilex_window/"
+	// 流量抓包
+	TrafficCaptureDir = MainWorkDir + "rhilex_capture/"
+	// 应用包里的文件
+	AppletBundleDir = MainWorkDir + "rhilex_applet/"
 	// 固件保存路径
 	FirmwarePath = MainWorkDir + "zupgrade/firmware.zip"
 	// 升级日志
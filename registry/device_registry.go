@@ -157,6 +157,12 @@ func LoadAllDeviceType(e typex.Rhilex) {
 			NewDevice: device.NewMBusEn13433MasterGateway,
 		},
 	)
+	DefaultDeviceRegistry.Register(typex.ITHINGS_IOTHUB_GATEWAY,
+		&typex.XConfig{
+			Engine:    e,
+			NewDevice: device.NewIthingsIothubGateway,
+		},
+	)
 }
 func (rm *DeviceRegistry) Register(name typex.DeviceType, f *typex.XConfig) {
 	f.Type = string(name)
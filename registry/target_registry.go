@@ -98,6 +98,30 @@ func LoadAllTargetType(e typex.Rhilex) {
 			NewTarget: target.NewGrepTimeDbTarget,
 		},
 	)
+	DefaultTargetRegistry.Register(typex.INFLUXDB_TARGET,
+		&typex.XConfig{
+			Engine:    e,
+			NewTarget: target.NewInfluxDBTarget,
+		},
+	)
+	DefaultTargetRegistry.Register(typex.PROMETHEUS_RW_TARGET,
+		&typex.XConfig{
+			Engine:    e,
+			NewTarget: target.NewPrometheusRemoteWriteTarget,
+		},
+	)
+	DefaultTargetRegistry.Register(typex.SQL_DATABASE,
+		&typex.XConfig{
+			Engine:    e,
+			NewTarget: target.NewSqlDatabaseTarget,
+		},
+	)
+	DefaultTargetRegistry.Register(typex.SPARKPLUG_EDGE_NODE,
+		&typex.XConfig{
+			Engine:    e,
+			NewTarget: target.NewSparkplugEdgeNodeTarget,
+		},
+	)
 }
 
 func (rm *TargetRegistry) Register(name typex.TargetType, f *typex.XConfig) {
@@ -15,7 +15,22 @@
 
 package resconfig
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/hootrhino/rhilex/component/payloaddecoder"
+)
+
+/*
+*
+* 按Topic解码和路由: 匹配上的消息按解码器解码以后只交给指定的规则
+*
+ */
+type MqttTopicMapping struct {
+	Topic string   `json:"topic" validate:"required" title:"Topic"` // 支持 + 和 # 通配符
+	Rules []string `json:"rules" title:"规则"`                        // 规则UUID, 为空表示所有绑定的规则
+	payloaddecoder.DecoderConfig
+}
 
 type GenericMqttConfig struct {
 	Host      string   `json:"host" validate:"required" title:"服务地址"`
@@ -25,6 +40,8 @@ type GenericMqttConfig struct {
 	Password  string   `json:"password" validate:"required" title:"连接密码"`
 	Qos       int      `json:"qos" validate:"required" title:"数据质量"`
 	SubTopics []string `json:"subTopics" title:"订阅topic组"`
+	// 按顺序匹配, 第一个匹配上的生效; 没有匹配上的消息保持原来的格式交给所有规则
+	TopicMappings []MqttTopicMapping `json:"topicMappings" title:"Topic映射"`
 }
 
 func (cfg *GenericMqttConfig) Validate() error {
@@ -48,5 +65,13 @@ func (cfg *GenericMqttConfig) Validate() error {
 			return errors.New("mqtt config error: subscription topics cannot be empty")
 		}
 	}
+	for _, mapping := range cfg.TopicMappings {
+		if mapping.Topic == "" {
+			return errors.New("mqtt config error: mapping topic cannot be empty")
+		}
+		if _, err := payloaddecoder.NewPayloadDecoder(mapping.DecoderConfig); err != nil {
+			return errors.New("mqtt config error: " + err.Error())
+		}
+	}
 	return nil
 }
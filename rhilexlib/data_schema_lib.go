@@ -0,0 +1,158 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"encoding/json"
+	"fmt"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/dataschema"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// Lua 表转参数, 不传或者 nil 是空参数
+func luaParams(l *lua.LState, n int) (map[string]any, error) {
+	params := map[string]any{}
+	value := l.Get(n)
+	if value == lua.LNil {
+		return params, nil
+	}
+	if value.Type() != lua.LTTable {
+		return nil, fmt.Errorf("params must be table")
+	}
+	bytes, err := EncodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	// 空表编码出来是 []
+	if string(bytes) == "[]" {
+		return params, nil
+	}
+	if err := json.Unmarshal(bytes, &params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+/*
+*
+* 调用物模型服务: local result, err = schema:Invoke(schemaId, deviceUuid, service, {k=v})
+*
+ */
+func SchemaInvoke(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		params, err := luaParams(l, 5)
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		result, err := dataschema.InvokeService(rx, l.ToString(2), l.ToString(3), l.ToString(4), params)
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		l.Push(typex.ToLValue(l, result))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
+/*
+*
+* 触发物模型事件: local err = schema:Emit(schemaId, deviceUuid, event, {k=v})
+*
+ */
+func SchemaEmit(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		params, err := luaParams(l, 5)
+		if err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		if _, err := dataschema.EmitEvent(l.ToString(2), l.ToString(3), l.ToString(4), params); err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
+
+// Lua 值转 Go 值, 表按 JSON 转换
+func luaValue(value lua.LValue) (any, error) {
+	switch v := value.(type) {
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		bytes, err := EncodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		var result any
+		if err := json.Unmarshal(bytes, &result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unsupported value type: %s", value.Type())
+}
+
+/*
+*
+* 写物模型属性到绑定的点位: local err = schema:Write(schemaId, name, value)
+*
+ */
+func SchemaWrite(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		value, err := luaValue(l.Get(4))
+		if err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		if err := dataschema.WriteProperty(rx, l.ToString(2), l.ToString(3), value); err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
+
+/*
+*
+* 读物模型属性的当前值: local value, err = schema:Read(schemaId, name)
+*
+ */
+func SchemaRead(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		value, ok := dataschema.GetIoTPropertyValue(l.ToString(2), l.ToString(3))
+		if !ok {
+			l.Push(lua.LNil)
+			l.Push(lua.LString("property value not exists: " + l.ToString(3)))
+			return 2
+		}
+		l.Push(typex.ToLValue(l, value.Value))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
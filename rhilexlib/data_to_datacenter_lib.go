@@ -15,10 +15,9 @@
 package rhilexlib
 
 import (
+	"encoding/json"
 	"fmt"
-	"reflect"
 	"strings"
-	"time"
 
 	"github.com/hootrhino/rhilex/component/dataschema"
 	"github.com/hootrhino/rhilex/datacenter"
@@ -28,57 +27,6 @@ import (
 	lua "github.com/hootrhino/gopher-lua"
 )
 
-// GenInsertSql 生成 INSERT SQL 语句
-func GenInsertSql(tableName string, rowList []kvp) (string, error) {
-	if len(rowList) == 0 {
-		return "", fmt.Errorf("no rows to insert")
-	}
-	columnStr := strings.Join(buildColumns(rowList), ", ")
-	values := make([]string, len(rowList))
-	for i, Row := range rowList {
-		value := Row.V
-		valueStr, err := formatValue(value)
-		if err != nil {
-			return "", err
-		}
-		values[i] = valueStr
-	}
-	insertSql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableName, columnStr, strings.Join(values, ", "))
-	return insertSql, nil
-}
-
-// buildColumns 构建一个包含列名的字符串列表
-func buildColumns(rowList []kvp) []string {
-	var columnList []string
-	for _, Row := range rowList {
-		column := Row.K
-		columnList = append(columnList, fmt.Sprintf("`%s`", column))
-	}
-	return columnList
-}
-
-// formatValue 根据值的类型格式化 SQL 值
-func formatValue(val any) (string, error) {
-	switch v := val.(type) {
-	case string:
-		return fmt.Sprintf("'%s'", v), nil
-	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-		return fmt.Sprintf("%d", v), nil
-	case float32, float64:
-		return fmt.Sprintf("%f", v), nil
-	case bool:
-		if v {
-			return "1", nil
-		} else {
-			return "0", nil
-		}
-	case time.Time:
-		return fmt.Sprintf("'%s'", v.Format("2006-01-02 15:04:05")), nil
-	default:
-		return "", fmt.Errorf("unsupported type: %s", reflect.TypeOf(val))
-	}
-}
-
 /*
 *
 * 数据写入Sqlite，需要验证规则,验证规则的方式:
@@ -96,16 +44,12 @@ func InsertToDataCenterTable(rx typex.Rhilex, uuid string) func(*lua.LState) int
 			return 1
 		}
 		RowList := []kvp{}
-		// create_at
-		RowList = append(RowList, kvp{
-			"create_at", time.Now(),
-		})
 		kvs.ForEach(func(k, v lua.LValue) {
 			Row := kvp{}
 			// K 只能String
 			if k.Type() == lua.LTString {
 				// create_at id : 不允许用户填写
-				if Row.K != "create_at" && Row.K != "id" {
+				if K := lua.LVAsString(k); K != "create_at" && K != "id" {
 					switch v.Type() {
 					case lua.LTString:
 						Row.K = lua.LVAsString(k)
@@ -133,8 +77,7 @@ func InsertToDataCenterTable(rx typex.Rhilex, uuid string) func(*lua.LState) int
 			l.Push(lua.LString(errCheckRule.Error()))
 			return 1
 		}
-		TableName := fmt.Sprintf("data_center_%s", schema_uuid)
-		if errSave := saveToDataCenter(TableName, RowList); errSave != nil {
+		if errSave := saveToDataCenter(schema_uuid, RowList); errSave != nil {
 			l.Push(lua.LString(errSave.Error()))
 		} else {
 			l.Push(lua.LNil)
@@ -175,18 +118,16 @@ func CheckSchemaConsist(RowList []kvp) error {
 	return nil
 }
 
-// Save to local DataCenter
+// Save to local DataCenter, create_at 由数据中心统一填
 func saveToDataCenter(schema_uuid string, RowList []kvp) error {
-	Sql, err0 := GenInsertSql(schema_uuid, RowList)
-	glogger.GLogger.Debug(Sql)
-	if err0 != nil {
-		return err0
+	if len(RowList) == 0 {
+		return fmt.Errorf("no rows to insert")
 	}
-	err1 := datacenter.DataCenterDb().Exec(Sql).Error
-	if err1 != nil {
-		return err1
+	row := make(map[string]any, len(RowList))
+	for _, Row := range RowList {
+		row[Row.K] = Row.V
 	}
-	return nil
+	return datacenter.InsertSchemaRow(schema_uuid, row)
 }
 
 // Query List
@@ -212,6 +153,57 @@ func queryDataCenterList(schema_uuid string, page, size int, fields string) erro
 	return nil
 }
 
+/*
+*
+* 条件查询: local rows, err = rds:Query(schemaId, {
+*     select = {'temp'}, where = "temp > 20 AND on = true",
+*     start = '2025-01-01 00:00:00', ['end'] = '2025-01-02 00:00:00',
+*     groupBy = '1h', func = 'avg', order = 'ASC', limit = 100, offset = 0
+* }); 最多返回 1000 行
+*
+ */
+const maxLuaQueryRows = 1000
+
+func QueryDataCenter(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		options := datacenter.DataQueryOptions{}
+		if value := l.Get(3); value != lua.LNil {
+			if value.Type() != lua.LTTable {
+				l.Push(lua.LNil)
+				l.Push(lua.LString("options must be table"))
+				return 2
+			}
+			bytes, err := EncodeValue(value)
+			if err == nil && string(bytes) != "[]" {
+				err = json.Unmarshal(bytes, &options)
+			}
+			if err != nil {
+				l.Push(lua.LNil)
+				l.Push(lua.LString(err.Error()))
+				return 2
+			}
+		}
+		query, err := options.Parse()
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		if query.Limit <= 0 || query.Limit > maxLuaQueryRows {
+			query.Limit = maxLuaQueryRows
+		}
+		result, err := datacenter.QueryData(l.ToString(2), query)
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		l.Push(typex.ToLValue(l, result.Rows))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
 /*
 *
 * last data
@@ -252,7 +244,7 @@ func UpdateDataCenterLast(rx typex.Rhilex, uuid string) func(*lua.LState) int {
 			// K 只能String
 			if k.Type() == lua.LTString {
 				// create_at 不允许用户填写
-				if Row.K != "create_at" && Row.K != "id" {
+				if K := lua.LVAsString(k); K != "create_at" && K != "id" {
 					switch v.Type() {
 					case lua.LTString:
 						Row.K = lua.LVAsString(k)
@@ -283,7 +275,7 @@ func UpdateDataCenterLast(rx typex.Rhilex, uuid string) func(*lua.LState) int {
 			return 1
 		}
 		if id < 0 {
-			if err := saveToDataCenter(fmt.Sprintf("data_center_%s", schema_uuid), RowList); err != nil {
+			if err := saveToDataCenter(schema_uuid, RowList); err != nil {
 				l.Push(lua.LString(err.Error()))
 			} else {
 				l.Push(lua.LNil)
@@ -0,0 +1,103 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/datacenter"
+	"github.com/hootrhino/rhilex/glogger"
+)
+
+// 数据中心的库文件是相对路径, 换到临时目录里建
+func initTestDataCenter(t *testing.T, schemaUuid string) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	intercache.InitGlobalValueRegistry(nil)
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		datacenter.DataCenterDb().Exec("DROP TABLE IF EXISTS " + datacenter.RawTable(schemaUuid))
+		os.Chdir(wd)
+	})
+	datacenter.InitDataCenterDb(nil)
+	sql, _ := datacenter.GenerateSQLiteCreateTableDDL(datacenter.SchemaDDL{
+		SchemaUUID: datacenter.RawTable(schemaUuid),
+		DDLColumns: []datacenter.DDLColumn{
+			{Name: "id", Type: "INTEGER"},
+			{Name: "create_at", Type: "DATETIME"},
+			{Name: "temp", Type: "FLOAT"},
+		},
+	})
+	if err := datacenter.DataCenterDb().Exec(sql).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// go test -timeout 30s -run ^Test_DataCenter_Save github.com/hootrhino/rhilex/rhilexlib -v -count=1
+func Test_DataCenter_Save(t *testing.T) {
+	initTestDataCenter(t, "SCHEMA1")
+	L := lua.NewState()
+	defer L.Close()
+	rds := L.NewTable()
+	L.SetField(rds, "Save", L.NewFunction(InsertToDataCenterTable(nil, "")))
+	L.SetGlobal("rds", rds)
+	if err := L.DoString(`err = rds:Save("SCHEMA1", {temp = 21.5, create_at = "1970-01-01 00:00:00"})`); err != nil {
+		t.Fatal(err)
+	}
+	if err := L.GetGlobal("err"); err != lua.LNil {
+		t.Fatal(err)
+	}
+	// 存的是不带时区的本地时间, 用户填的 create_at 不算
+	createAt := ""
+	datacenter.DataCenterDb().Raw(`SELECT CAST(create_at AS TEXT) FROM "data_center_SCHEMA1"`).Scan(&createAt)
+	ts, err := time.ParseInLocation(datacenter.TimeLayout, createAt, time.Local)
+	if err != nil {
+		t.Fatal("unexpected create_at:", createAt, err)
+	}
+	if d := time.Since(ts); d < -time.Second || d > 5*time.Second {
+		t.Fatal("create_at should be local now:", createAt)
+	}
+	// 按分钟分组, 桶是本地时间的整分钟, 不会被换成 UTC
+	q, _ := datacenter.DataQueryOptions{Select: []string{"temp"}, GroupBy: "1m", Func: "max"}.Parse()
+	result, err := datacenter.QueryData("SCHEMA1", q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 1 || result.Rows[0]["bucket"] != createAt[:16]+":00" || result.Rows[0]["temp"] != 21.5 {
+		t.Fatal("unexpected groups", result.Rows)
+	}
+	// 按本地时间的范围查得到
+	q, _ = datacenter.DataQueryOptions{Select: []string{"create_at"},
+		Start: ts.Format(datacenter.TimeLayout), End: ts.Add(time.Second).Format(datacenter.TimeLayout)}.Parse()
+	if result, _ := datacenter.QueryData("SCHEMA1", q); len(result.Rows) != 1 || result.Rows[0]["create_at"] != createAt {
+		t.Fatal("unexpected rows", result.Rows)
+	}
+	// 带时区的时间也换成本地时间字符串再存
+	at := time.Date(2025, 1, 1, 10, 0, 0, 0, time.FixedZone("UTC+14", 14*3600))
+	if err := datacenter.InsertSchemaRow("SCHEMA1", map[string]any{"create_at": at, "temp": 1.0}); err != nil {
+		t.Fatal(err)
+	}
+	datacenter.DataCenterDb().Raw(`SELECT CAST(create_at AS TEXT) FROM "data_center_SCHEMA1" WHERE temp = 1`).Scan(&createAt)
+	if createAt != at.In(time.Local).Format(datacenter.TimeLayout) {
+		t.Fatal("unexpected create_at:", createAt)
+	}
+}
@@ -16,6 +16,7 @@
 package rhilexlib
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"strings"
@@ -34,7 +35,7 @@ import (
 func HttpGet(rx typex.Rhilex, uuid string) func(*lua.LState) int {
 	return func(l *lua.LState) int {
 		url := l.ToString(2)
-		l.Push(lua.LString(__HttpGet(url)))
+		l.Push(lua.LString(__HttpGet(luaContext(l), url)))
 		return 1
 	}
 }
@@ -42,21 +43,33 @@ func HttpPost(rx typex.Rhilex, uuid string) func(*lua.LState) int {
 	return func(l *lua.LState) int {
 		url := l.ToString(2)
 		body := l.ToString(3)
-		l.Push(lua.LString(__HttpPost(url, body)))
+		l.Push(lua.LString(__HttpPost(luaContext(l), url, body)))
 		return 1
 	}
 }
 
+// 规则执行超时以后 Context 会被取消, 阻塞的请求也一起结束
+func luaContext(l *lua.LState) context.Context {
+	if ctx := l.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
 /*
 *
 * GET
 *
  */
-func __HttpGet(url string) string {
+func __HttpGet(ctx context.Context, url string) string {
 	client := http.Client{
 		Timeout: 5 * time.Second,
 	}
-	resp, err := client.Get(url)
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := client.Do(request)
 	if err != nil {
 		return ""
 	}
@@ -72,8 +85,14 @@ func __HttpGet(url string) string {
 *
 * POST
  */
-func __HttpPost(url string, body string) string {
-	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+func __HttpPost(ctx context.Context, url string, body string) string {
+	request, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(body))
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return ""
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(request)
 	if err != nil {
 		glogger.GLogger.Error(err)
 		return ""
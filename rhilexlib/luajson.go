@@ -69,6 +69,12 @@ func apiBase64JsonToTable(L *lua.LState) int {
 }
 
 func apiDecode(L *lua.LState) int {
+	// 表规则拿到的参数已经是表了, 原样返回, 老脚本不用改
+	if table, ok := L.Get(2).(*lua.LTable); ok {
+		L.Push(table)
+		L.Push(lua.LNil)
+		return 2
+	}
 	str := L.CheckString(2)
 
 	value, err := _Decode(L, []byte(str))
@@ -109,6 +115,11 @@ func (i invalidTypeError) Error() string {
 }
 
 // _Encode returns the JSON encoding of value.
+// Lua 值转成 JSON, 给 Go 代码用
+func EncodeValue(value lua.LValue) ([]byte, error) {
+	return _Encode(value)
+}
+
 func _Encode(value lua.LValue) ([]byte, error) {
 	return json.Marshal(jsonValue{
 		LValue:  value,
@@ -0,0 +1,93 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/mailbox"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 给小程序发信: mailbox:Send(appId, value), 规则和小程序都能用;
+* 邮箱满了返回错误, 不会阻塞
+*
+ */
+func MailboxSend(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		to := l.ToString(2)
+		var data any
+		switch value := l.Get(3).(type) {
+		case lua.LString:
+			data = string(value)
+		default:
+			bytes, err := EncodeValue(value)
+			if err != nil {
+				l.Push(lua.LString(err.Error()))
+				return 1
+			}
+			if err := json.Unmarshal(bytes, &data); err != nil {
+				l.Push(lua.LString(err.Error()))
+				return 1
+			}
+		}
+		if err := mailbox.Send(to, uuid, data); err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
+
+/*
+*
+* 小程序收信: local mail, err = mailbox:Receive(timeoutMs),
+* mail 是 {from=, ts=, data=}; 超时返回 nil, nil; 不传超时一直等到有信或者小程序停止
+*
+ */
+func MailboxReceive(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		timeout := time.Duration(l.OptInt(2, 0)) * time.Millisecond
+		ctx := l.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		mail, ok, err := mailbox.Receive(ctx, uuid, timeout)
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		if !ok {
+			l.Push(lua.LNil)
+			l.Push(lua.LNil)
+			return 2
+		}
+		table := l.CreateTable(0, 3)
+		table.RawSetString("from", lua.LString(mail.From))
+		table.RawSetString("ts", lua.LNumber(mail.Ts))
+		table.RawSetString("data", typex.ToLValue(l, mail.Data))
+		l.Push(table)
+		l.Push(lua.LNil)
+		return 2
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"encoding/json"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/rulestream"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 发布到规则流: stream:Emit(name, value), value 是表或者字符串,
+* 订阅了这个流的规则会收到消息, 消息的 id 是发布的规则
+*
+ */
+func StreamEmit(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		name := l.ToString(2)
+		var msg *typex.Message
+		switch value := l.Get(3).(type) {
+		case lua.LString:
+			msg = typex.NewStringMessage(uuid, string(value))
+		case *lua.LTable:
+			bytes, err := EncodeValue(value)
+			if err != nil {
+				l.Push(lua.LString(err.Error()))
+				return 1
+			}
+			var payload any
+			if err := json.Unmarshal(bytes, &payload); err != nil {
+				l.Push(lua.LString(err.Error()))
+				return 1
+			}
+			msg = typex.NewMessage(uuid, payload)
+		default:
+			l.Push(lua.LString("stream value must be a table or string"))
+			return 1
+		}
+		msg.Tags = map[string]string{"stream": name}
+		msg.Hops = luaexecutor.MessageHops(l) + 1
+		if err := rulestream.Emit(name, msg); err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
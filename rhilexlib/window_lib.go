@@ -0,0 +1,321 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/streamwindow"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// 每个虚拟机自己的窗口回调, 窗口状态是共享的, 回调在触发关闭的虚拟机里执行
+const __WindowCallbacks = "_RHILEX_WINDOW_CALLBACKS"
+
+/*
+*
+* 定义窗口, 可以在每次执行的时候重复调用, 定义不变不会重置状态:
+*   window:Define("avg1m", {type="tumbling", size=60000, key="tag", field="value",
+*       aggs={"avg","max","p95"}, persist=true}, function(r) ... end)
+*
+ */
+func WindowDefine(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		name := l.ToString(2)
+		opts := l.ToTable(3)
+		if opts == nil {
+			l.Push(lua.LString("window options must be a table"))
+			return 1
+		}
+		spec := streamwindow.Spec{
+			Type:      lua.LVAsString(opts.RawGetString("type")),
+			Size:      int64(lua.LVAsNumber(opts.RawGetString("size"))),
+			Slide:     int64(lua.LVAsNumber(opts.RawGetString("slide"))),
+			Gap:       int64(lua.LVAsNumber(opts.RawGetString("gap"))),
+			Key:       lua.LVAsString(opts.RawGetString("key")),
+			Field:     lua.LVAsString(opts.RawGetString("field")),
+			Time:      lua.LVAsString(opts.RawGetString("time")),
+			MaxKeys:   int(lua.LVAsNumber(opts.RawGetString("maxKeys"))),
+			MaxValues: int(lua.LVAsNumber(opts.RawGetString("maxValues"))),
+			Persist:   lua.LVAsBool(opts.RawGetString("persist")),
+		}
+		if aggs, ok := opts.RawGetString("aggs").(*lua.LTable); ok {
+			aggs.ForEach(func(_, v lua.LValue) {
+				spec.Aggs = append(spec.Aggs, v.String())
+			})
+		}
+		if _, err := streamwindow.Define(uuid, name, spec); err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		if callback, ok := l.Get(4).(*lua.LFunction); ok {
+			windowCallbacks(l).RawSetString(name, callback)
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
+
+/*
+*
+* 写入一个值: window:Add(name, key, value[, ts]), 返回关闭的窗口和错误
+*
+ */
+func WindowAdd(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		w, ok := getWindow(l, uuid, l.ToString(2))
+		if !ok {
+			return 2
+		}
+		ts := l.OptInt64(5, time.Now().UnixMilli())
+		results := w.Add(l.ToString(3), float64(l.ToNumber(4)), ts)
+		l.Push(emitWindowResults(l, w.Name, results))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
+/*
+*
+* 写入一条记录: window:Push(name, record[, ts]), 记录可以是表或者 JSON 字符串,
+* 按定义里的 key/field/time 取值, 字段支持 a.b.c 形式的路径
+*
+ */
+func WindowPush(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		w, ok := getWindow(l, uuid, l.ToString(2))
+		if !ok {
+			return 2
+		}
+		record, err := windowRecord(l.Get(3))
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		value, ok := toFloat(lookupPath(record, w.Spec.Field))
+		if !ok {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(fmt.Sprintf("field '%s' is not a number", w.Spec.Field)))
+			return 2
+		}
+		key := ""
+		if w.Spec.Key != "" {
+			if v := lookupPath(record, w.Spec.Key); v != nil {
+				key = fmt.Sprintf("%v", v)
+			}
+		}
+		ts := time.Now().UnixMilli()
+		if w.Spec.Time != "" {
+			if v, ok := toFloat(lookupPath(record, w.Spec.Time)); ok {
+				ts = int64(v)
+			}
+		}
+		ts = l.OptInt64(4, ts)
+		results := w.Add(key, value, ts)
+		l.Push(emitWindowResults(l, w.Name, results))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
+/*
+*
+* 按当前时间关闭到期的窗口, 没有新数据的时候由定时应用或者其他规则调用
+*
+ */
+func WindowTick(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		w, ok := getWindow(l, uuid, l.ToString(2))
+		if !ok {
+			return 2
+		}
+		results := w.Tick(time.Now().UnixMilli())
+		l.Push(emitWindowResults(l, w.Name, results))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
+// 强制关闭全部窗口
+func WindowFlush(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		w, ok := getWindow(l, uuid, l.ToString(2))
+		if !ok {
+			return 2
+		}
+		results := w.Flush()
+		l.Push(emitWindowResults(l, w.Name, results))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
+// 查看某个分组当前还没关闭的统计值
+func WindowPeek(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		w, ok := getWindow(l, uuid, l.ToString(2))
+		if !ok {
+			return 2
+		}
+		l.Push(windowResultsTable(l, w.Peek(l.ToString(3))))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
+func WindowReset(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		w, ok := getWindow(l, uuid, l.ToString(2))
+		if !ok {
+			return 2
+		}
+		w.Reset()
+		l.Push(lua.LTrue)
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
+func WindowStats(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		w, ok := getWindow(l, uuid, l.ToString(2))
+		if !ok {
+			return 2
+		}
+		stats := w.Stats()
+		t := l.NewTable()
+		t.RawSetString("keys", lua.LNumber(stats.Keys))
+		t.RawSetString("watermark", lua.LNumber(stats.Watermark))
+		t.RawSetString("late", lua.LNumber(stats.Late))
+		t.RawSetString("dropped", lua.LNumber(stats.Dropped))
+		l.Push(t)
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
+func getWindow(l *lua.LState, uuid, name string) (*streamwindow.Window, bool) {
+	w := streamwindow.Get(uuid, name)
+	if w == nil {
+		l.Push(lua.LNil)
+		l.Push(lua.LString("window not defined: " + name))
+		return nil, false
+	}
+	return w, true
+}
+
+func windowCallbacks(l *lua.LState) *lua.LTable {
+	if t, ok := l.G.Registry.RawGetString(__WindowCallbacks).(*lua.LTable); ok {
+		return t
+	}
+	t := l.NewTable()
+	l.G.Registry.RawSetString(__WindowCallbacks, t)
+	return t
+}
+
+// 调用回调并返回结果列表
+func emitWindowResults(l *lua.LState, name string, results []streamwindow.Result) *lua.LTable {
+	list := windowResultsTable(l, results)
+	callback, ok := windowCallbacks(l).RawGetString(name).(*lua.LFunction)
+	if !ok {
+		return list
+	}
+	list.ForEach(func(_, result lua.LValue) {
+		if err := l.CallByParam(lua.P{
+			Fn:      callback,
+			NRet:    0,
+			Protect: true,
+		}, result); err != nil {
+			glogger.GLogger.Error("Stream window callback error:", err)
+		}
+	})
+	return list
+}
+
+func windowResultsTable(l *lua.LState, results []streamwindow.Result) *lua.LTable {
+	list := l.NewTable()
+	for _, r := range results {
+		t := l.NewTable()
+		t.RawSetString("window", lua.LString(r.Window))
+		t.RawSetString("key", lua.LString(r.Key))
+		t.RawSetString("start", lua.LNumber(r.Start))
+		t.RawSetString("end", lua.LNumber(r.End))
+		for agg, value := range r.Values {
+			t.RawSetString(agg, lua.LNumber(value))
+		}
+		list.Append(t)
+	}
+	return list
+}
+
+func windowRecord(value lua.LValue) (any, error) {
+	var bytes []byte
+	switch v := value.(type) {
+	case lua.LString:
+		bytes = []byte(v)
+	case *lua.LTable:
+		encoded, err := EncodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		bytes = encoded
+	default:
+		return nil, fmt.Errorf("record must be a table or json string")
+	}
+	var record any
+	if err := json.Unmarshal(bytes, &record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func lookupPath(record any, path string) any {
+	if path == "" {
+		return record
+	}
+	current := record
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -3,9 +3,12 @@ package source
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/hootrhino/rhilex/component/interqueue"
+	"github.com/hootrhino/rhilex/component/payloaddecoder"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/resconfig"
 	"github.com/hootrhino/rhilex/typex"
@@ -17,10 +20,17 @@ type MqttMessage struct {
 	Payload []byte `json:"payload"`
 }
 
+// 已经初始化好解码器的Topic映射
+type mqttTopicRoute struct {
+	mapping resconfig.MqttTopicMapping
+	decoder payloaddecoder.PayloadDecoder
+}
+
 type genericMqttSource struct {
 	typex.XStatus
 	client     mqtt.Client
 	mainConfig resconfig.GenericMqttConfig
+	routes     []mqttTopicRoute
 	status     typex.SourceState
 }
 
@@ -40,6 +50,14 @@ func (tc *genericMqttSource) Init(inEndId string, configMap map[string]any) erro
 	if err := utils.BindSourceConfig(configMap, &tc.mainConfig); err != nil {
 		return err
 	}
+	tc.routes = []mqttTopicRoute{}
+	for _, mapping := range tc.mainConfig.TopicMappings {
+		decoder, err := payloaddecoder.NewPayloadDecoder(mapping.DecoderConfig)
+		if err != nil {
+			return fmt.Errorf("topic mapping %s: %w", mapping.Topic, err)
+		}
+		tc.routes = append(tc.routes, mqttTopicRoute{mapping: mapping, decoder: decoder})
+	}
 	return nil
 }
 
@@ -90,7 +108,7 @@ func (tc *genericMqttSource) connectToMqtt() error {
 
 func (tc *genericMqttSource) subscribe() error {
 	filters := make(map[string]byte)
-	for _, topic := range tc.mainConfig.SubTopics {
+	for _, topic := range subscribeFilters(tc.mainConfig.SubTopics, tc.mainConfig.TopicMappings) {
 		filters[topic] = byte(tc.mainConfig.Qos)
 	}
 	multiple := tc.client.SubscribeMultiple(filters, tc.onMessage)
@@ -104,6 +122,12 @@ func (tc *genericMqttSource) subscribe() error {
 }
 
 func (tc *genericMqttSource) onMessage(client mqtt.Client, message mqtt.Message) {
+	for _, route := range tc.routes {
+		if MatchMqttTopic(route.mapping.Topic, message.Topic()) {
+			tc.routeMessage(route, message)
+			return
+		}
+	}
 	mqttMessage := MqttMessage{
 		Topic:   message.Topic(),
 		Payload: message.Payload(),
@@ -141,3 +165,95 @@ func (tc *genericMqttSource) Stop() {
 	}
 	tc.status = typex.SOURCE_DOWN
 }
+
+/*
+*
+* 按映射解码, 然后只交给映射里面指定的规则; 解码结果直接作为消息的 payload,
+* 表规则拿到的就是 Lua 表, Topic 放在 tags.topic 里
+*
+ */
+func (tc *genericMqttSource) routeMessage(route mqttTopicRoute, message mqtt.Message) {
+	payload, err := route.decoder.Decode(message.Payload())
+	if err != nil {
+		glogger.GLogger.Errorf("decode message failed, topic=%s decoder=%s error=%v",
+			message.Topic(), route.mapping.Decoder, err)
+		return
+	}
+	msg := typex.NewMessage(tc.PointId, payload)
+	msg.Tags = map[string]string{"topic": message.Topic()}
+	if err := interqueue.PushInQueueMessageToRules(tc.RuleEngine.GetInEnd(tc.PointId),
+		msg, route.mapping.Rules); err != nil {
+		glogger.GLogger.Error(err)
+	}
+}
+
+/*
+*
+* 要订阅的 Topic: SubTopics 加上映射里的 Topic; 已经被 SubTopics 覆盖的映射不再单独订阅,
+* 不然 Broker 会按每个订阅各发一次, 同一条消息重复处理
+*
+ */
+func subscribeFilters(subTopics []string, mappings []resconfig.MqttTopicMapping) []string {
+	filters := []string{}
+	filters = append(filters, subTopics...)
+	for _, mapping := range mappings {
+		covered := false
+		for _, filter := range filters {
+			if mqttFilterCovers(filter, mapping.Topic) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			filters = append(filters, mapping.Topic)
+		}
+	}
+	return filters
+}
+
+// filter 能匹配到的 Topic 是否包含 other 能匹配到的所有 Topic
+func mqttFilterCovers(filter, other string) bool {
+	filterLevels := strings.Split(filter, "/")
+	otherLevels := strings.Split(other, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(otherLevels) || otherLevels[i] == "#" {
+			return false
+		}
+		if level == "+" {
+			continue
+		}
+		if level != otherLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(otherLevels)
+}
+
+/*
+*
+* MQTT Topic 通配符匹配: + 匹配一层, # 匹配剩下的所有层(包括父级本身);
+* $ 开头的系统 Topic 不会被第一层的通配符匹配
+*
+ */
+func MatchMqttTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
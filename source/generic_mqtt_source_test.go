@@ -0,0 +1,147 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package source
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/interqueue"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/resconfig"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// go test -timeout 30s -run ^Test_MatchMqttTopic github.com/hootrhino/rhilex/source -v -count=1
+func Test_MatchMqttTopic(t *testing.T) {
+	for _, c := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/b", "a/b/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a//c", true},
+		{"+/+", "/a", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"a/#", "b/a", false},
+		{"#", "a/b", true},
+		{"+", "a/b", false},
+		{"#", "$SYS/broker", false},
+		{"+/broker", "$SYS/broker", false},
+		{"$SYS/#", "$SYS/broker", true},
+	} {
+		if MatchMqttTopic(c.filter, c.topic) != c.match {
+			t.Fatal("unexpected match:", c.filter, c.topic, c.match)
+		}
+	}
+}
+
+// go test -timeout 30s -run ^Test_SubscribeFilters github.com/hootrhino/rhilex/source -v -count=1
+func Test_SubscribeFilters(t *testing.T) {
+	mappings := []resconfig.MqttTopicMapping{
+		{Topic: "sensor/+/json"}, // sensor/# 覆盖了
+		{Topic: "meter/+/raw"},   // 没有覆盖, 自动订阅
+		{Topic: "meter/1/raw"},   // meter/+/raw 覆盖了
+		{Topic: "spBv1.0/+/DDATA/#"},
+		{Topic: "spBv1.0/g1/#"},
+	}
+	filters := subscribeFilters([]string{"sensor/#", "spBv1.0/+/DDATA/+"}, mappings)
+	expect := []string{"sensor/#", "spBv1.0/+/DDATA/+", "meter/+/raw", "spBv1.0/+/DDATA/#", "spBv1.0/g1/#"}
+	if !reflect.DeepEqual(filters, expect) {
+		t.Fatal("unexpected filters", filters)
+	}
+	if filters := subscribeFilters(nil, nil); len(filters) != 0 {
+		t.Fatal("unexpected filters", filters)
+	}
+}
+
+type fakeMqttMessage struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m fakeMqttMessage) Topic() string   { return m.topic }
+func (m fakeMqttMessage) Payload() []byte { return m.payload }
+
+type fakeMqttRhilex struct {
+	typex.Rhilex
+	in *typex.InEnd
+}
+
+func (e *fakeMqttRhilex) GetInEnd(uuid string) *typex.InEnd {
+	return e.in
+}
+
+// 规则把收到的参数类型, tags.topic 和 payload.temp 写进 seen
+func newMqttTestRule(t *testing.T, uuid string, seen chan string) typex.Rule {
+	rule := typex.NewRule(nil, uuid, uuid, "", "", "", `function Success() end`, `
+ArgsType = "table"
+Actions = { function(args)
+	seen(type(args) .. ":" .. args.tags.topic .. ":" .. tostring(args.payload.temp))
+	return true, args
+end }`, `function Failed(error) end`)
+	rule.LuaVM.SetGlobal("seen", rule.LuaVM.NewFunction(func(L *lua.LState) int {
+		seen <- uuid + ":" + L.ToString(1)
+		return 0
+	}))
+	if err := rule.LuaVM.DoString(rule.Actions + "\n" + rule.Success); err != nil {
+		t.Fatal(err)
+	}
+	return *rule
+}
+
+// go test -timeout 30s -run ^Test_GenericMqtt_RouteMessage github.com/hootrhino/rhilex/source -v -count=1
+func Test_GenericMqtt_RouteMessage(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	seen := make(chan string, 4)
+	in := typex.NewInEnd(typex.GENERIC_MQTT_SERVER, "mqtt", "", nil)
+	in.BindRules["R1"] = newMqttTestRule(t, "R1", seen)
+	in.BindRules["R2"] = newMqttTestRule(t, "R2", seen)
+	rhilex := &fakeMqttRhilex{in: in}
+	interqueue.InitXQueue(rhilex, typex.RhilexConfig{MaxQueueSize: 4, InQueueWorkers: 1})
+	interqueue.StartXQueue()
+	source := NewGenericMqttSource(rhilex).(*genericMqttSource)
+	if err := source.Init(in.UUID, map[string]any{
+		"host": "127.0.0.1", "port": 1883, "clientId": "test", "username": "u", "password": "p", "qos": 1,
+		"topicMappings": []map[string]any{{"topic": "sensor/+/json", "decoder": "JSON", "rules": []string{"R1"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	source.onMessage(nil, fakeMqttMessage{topic: "sensor/d1/json", payload: []byte(`{"temp":23.5}`)})
+	// 只有映射里的 R1 收到, 拿到的是解码好的表, 不用 json:J2T
+	select {
+	case got := <-seen:
+		if got != "R1:table:sensor/d1/json:23.5" {
+			t.Fatal("unexpected args", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("rule not executed")
+	}
+	select {
+	case got := <-seen:
+		t.Fatal("unexpected rule executed", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -139,10 +139,24 @@ func (ht *HTTPTarget) To(data any) (any, error) {
 			}
 			return nil, err
 		}
+		return nil, nil
 	}
 	return nil, fmt.Errorf("data type must string!")
 }
 
+// 返回 200 才算送达, 超时算失败; 不进离线缓存
+func (ht *HTTPTarget) ToAcked(data any, timeout time.Duration) error {
+	T, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data type must string!")
+	}
+	client := ht.client
+	client.Timeout = timeout
+	_, err := utils.Post(client, T,
+		ht.mainConfig.HTTPTargetConfig.Url, ht.mainConfig.HTTPTargetConfig.Headers)
+	return err
+}
+
 func (ht *HTTPTarget) Stop() {
 	ht.status = typex.SOURCE_DOWN
 	if ht.CancelCTX != nil {
@@ -0,0 +1,73 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// go test -timeout 30s -run ^Test_HTTPTarget_To github.com/hootrhino/rhilex/target -v -count=1
+func Test_HTTPTarget_To(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	bodies := []string{}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	ht := NewHTTPTarget(nil).(*HTTPTarget)
+	if err := ht.Init("HTTP1", map[string]any{
+		"commonConfig": map[string]any{"url": server.URL, "headers": map[string]string{}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ht.Start(typex.CCTX{Ctx: ctx, CancelCTX: cancel}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ht.To("hello"); err != nil {
+		t.Fatal("successful post should not fail:", err)
+	}
+	if len(bodies) != 1 || bodies[0] != `"hello"` {
+		t.Fatal("unexpected bodies", bodies)
+	}
+	status = http.StatusInternalServerError
+	if _, err := ht.To("hello"); err == nil {
+		t.Fatal("non-200 should fail")
+	}
+	if _, err := ht.To(1); err == nil {
+		t.Fatal("non string should fail")
+	}
+	// 确认送达: 200 才算成功
+	if err := ht.ToAcked("acked", time.Second); err == nil {
+		t.Fatal("non-200 should fail")
+	}
+	status = http.StatusOK
+	if err := ht.ToAcked("acked", time.Second); err != nil || bodies[len(bodies)-1] != `"acked"` {
+		t.Fatal("unexpected acked post", err, bodies)
+	}
+}
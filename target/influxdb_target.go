@@ -0,0 +1,276 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/lostcache"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+/*
+*
+* InfluxDB 配置, Version=1 的时候用 Database/Username/Password, Version=2 的时候用 Org/Bucket/Token
+*
+ */
+type InfluxDBConfig struct {
+	Version          int    `json:"version" validate:"required" title:"版本"` // 1 | 2
+	Url              string `json:"url" validate:"required" title:"URL"`    // http://127.0.0.1:8086
+	Database         string `json:"database" title:"数据库名"`                  // v1
+	Username         string `json:"username" title:"用户"`                    // v1
+	Password         string `json:"password" title:"密码"`                    // v1
+	Org              string `json:"org" title:"组织"`                         // v2
+	Bucket           string `json:"bucket" title:"Bucket"`                  // v2
+	Token            string `json:"token" title:"Token"`                    // v2
+	BatchSize        int    `json:"batchSize" title:"批量大小"`
+	FlushInterval    int    `json:"flushInterval" title:"刷新间隔(毫秒)"`
+	Timeout          int    `json:"timeout" title:"超时时间(毫秒)"`
+	CacheOfflineData *bool  `json:"cacheOfflineData" title:"离线缓存"`
+}
+
+type InfluxDBMainConfig struct {
+	InfluxDBConfig InfluxDBConfig   `json:"commonConfig" validate:"required"`
+	PointMapping   TsdbPointMapping `json:"pointMapping" validate:"required"`
+}
+
+type influxDBTarget struct {
+	typex.XStatus
+	client     http.Client
+	mainConfig InfluxDBMainConfig
+	batcher    *targetBatcher[TsdbPoint]
+	status     typex.SourceState
+}
+
+func NewInfluxDBTarget(e typex.Rhilex) typex.XTarget {
+	influx := new(influxDBTarget)
+	influx.RuleEngine = e
+	influx.mainConfig = InfluxDBMainConfig{
+		InfluxDBConfig: InfluxDBConfig{
+			Version:          2,
+			Url:              "http://127.0.0.1:8086",
+			Database:         "rhilex",
+			Org:              "rhilex",
+			Bucket:           "rhilex",
+			BatchSize:        100,
+			FlushInterval:    1000,
+			Timeout:          3000,
+			CacheOfflineData: new(bool),
+		},
+		PointMapping: TsdbPointMapping{
+			Measurement: "rhilex",
+			Tags:        map[string]string{},
+			TagKeys:     []string{},
+			FieldKeys:   []string{},
+			Precision:   "ms",
+		},
+	}
+	influx.status = typex.SOURCE_DOWN
+	return influx
+}
+
+func (influx *influxDBTarget) Init(outEndId string, configMap map[string]any) error {
+	influx.PointId = outEndId
+	if err := utils.BindSourceConfig(configMap, &influx.mainConfig); err != nil {
+		return err
+	}
+	config := influx.mainConfig.InfluxDBConfig
+	if config.Version != 1 && config.Version != 2 {
+		return fmt.Errorf("invalid influxdb version: %d", config.Version)
+	}
+	if config.Version == 1 && config.Database == "" {
+		return fmt.Errorf("influxdb v1 database is required")
+	}
+	if config.Version == 2 && (config.Org == "" || config.Bucket == "") {
+		return fmt.Errorf("influxdb v2 org and bucket are required")
+	}
+	if _, err := url.Parse(config.Url); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (influx *influxDBTarget) Start(cctx typex.CCTX) error {
+	influx.Ctx = cctx.Ctx
+	influx.CancelCTX = cctx.CancelCTX
+	influx.client = http.Client{
+		Timeout: time.Duration(influx.mainConfig.InfluxDBConfig.Timeout) * time.Millisecond,
+	}
+	influx.batcher = newTargetBatcher[TsdbPoint](influx.mainConfig.InfluxDBConfig.BatchSize,
+		influx.write, influx.cacheOfflineData)
+	go influx.batcher.Run(influx.Ctx.Done(),
+		time.Duration(influx.mainConfig.InfluxDBConfig.FlushInterval)*time.Millisecond)
+	influx.status = typex.SOURCE_UP
+	// 补发数据
+	if *influx.mainConfig.InfluxDBConfig.CacheOfflineData {
+		if CacheData, err1 := lostcache.GetLostCacheData(influx.PointId); err1 != nil {
+			glogger.GLogger.Error(err1)
+		} else {
+			for _, data := range CacheData {
+				influx.To(data.Data)
+				{
+					lostcache.DeleteLostCacheData(influx.PointId, data.ID)
+				}
+			}
+		}
+	}
+	glogger.GLogger.Info("InfluxDB Target started")
+	return nil
+}
+
+func (influx *influxDBTarget) Status() typex.SourceState {
+	if influx.status == typex.SOURCE_DOWN {
+		return typex.SOURCE_DOWN
+	}
+	if err := influx.ping(); err != nil {
+		glogger.GLogger.Error(err)
+		return typex.SOURCE_DOWN
+	}
+	return typex.SOURCE_UP
+}
+
+/*
+*
+* 数据到达后先转成时序点放进缓冲区, 满了或者到时间了统一写入
+*
+ */
+func (influx *influxDBTarget) To(data any) (any, error) {
+	switch T := data.(type) {
+	case string:
+		points, err := influx.mainConfig.PointMapping.ParsePoints(T)
+		if err != nil {
+			glogger.GLogger.Error(err)
+			return 0, err
+		}
+		return len(points), influx.batcher.Add(T, points)
+	}
+	return 0, fmt.Errorf("data type must string!")
+}
+
+func (influx *influxDBTarget) Stop() {
+	influx.status = typex.SOURCE_DOWN
+	if influx.CancelCTX != nil {
+		influx.CancelCTX()
+	}
+}
+
+func (influx *influxDBTarget) Details() *typex.OutEnd {
+	return influx.RuleEngine.GetOutEnd(influx.PointId)
+}
+
+func (influx *influxDBTarget) cacheOfflineData(raws []string) {
+	if !*influx.mainConfig.InfluxDBConfig.CacheOfflineData {
+		return
+	}
+	for _, raw := range raws {
+		lostcache.SaveLostCacheData(influx.PointId, lostcache.CacheDataDto{
+			TargetId: influx.PointId,
+			Data:     raw,
+		})
+	}
+}
+
+// v1: /write?db=&precision=  v2: /api/v2/write?org=&bucket=&precision=
+func (influx *influxDBTarget) writeUrl() string {
+	config := influx.mainConfig.InfluxDBConfig
+	precision := influx.mainConfig.PointMapping.Precision
+	if precision == "" {
+		precision = "ms"
+	}
+	query := url.Values{}
+	if config.Version == 1 {
+		query.Set("db", config.Database)
+		// v1 的精度写法和 v2 不一样
+		switch precision {
+		case "ns":
+			query.Set("precision", "n")
+		case "us":
+			query.Set("precision", "u")
+		default:
+			query.Set("precision", precision)
+		}
+		return strings.TrimSuffix(config.Url, "/") + "/write?" + query.Encode()
+	}
+	query.Set("org", config.Org)
+	query.Set("bucket", config.Bucket)
+	query.Set("precision", precision)
+	return strings.TrimSuffix(config.Url, "/") + "/api/v2/write?" + query.Encode()
+}
+
+func (influx *influxDBTarget) authorize(request *http.Request) {
+	config := influx.mainConfig.InfluxDBConfig
+	if config.Version == 1 {
+		if config.Username != "" {
+			request.SetBasicAuth(config.Username, config.Password)
+		}
+		return
+	}
+	if config.Token != "" {
+		request.Header.Set("Authorization", "Token "+config.Token)
+	}
+}
+
+func (influx *influxDBTarget) write(points []TsdbPoint) error {
+	body := EncodeLineProtocol(points, influx.mainConfig.PointMapping.Precision)
+	if body == "" { // 没有能写的字段
+		return nil
+	}
+	glogger.GLogger.Debug("InfluxDB write:", body)
+	request, err := http.NewRequest("POST", influx.writeUrl(), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	influx.authorize(request)
+	response, err := influx.client.Do(request)
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
+		bytes, _ := io.ReadAll(response.Body)
+		err := fmt.Errorf("influxdb write error: %d, %s", response.StatusCode, string(bytes))
+		glogger.GLogger.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (influx *influxDBTarget) ping() error {
+	request, err := http.NewRequest("GET",
+		strings.TrimSuffix(influx.mainConfig.InfluxDBConfig.Url, "/")+"/ping", nil)
+	if err != nil {
+		return err
+	}
+	influx.authorize(request)
+	response, err := influx.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("influxdb ping error: %d", response.StatusCode)
+	}
+	return nil
+}
@@ -168,3 +168,19 @@ func (mq *mqttOutEndTarget) To(data any) (any, error) {
 	}
 	return nil, errors.New("mqtt client is nil")
 }
+
+// QoS 1 发出去, 等到 Broker 回 PUBACK 才算成功; 不进离线缓存
+func (mq *mqttOutEndTarget) ToAcked(data any, timeout time.Duration) error {
+	if mq.client == nil {
+		return errors.New("mqtt client is nil")
+	}
+	T, ok := data.(string)
+	if !ok {
+		return errors.New("Invalid mqtt data type")
+	}
+	token := mq.client.Publish(mq.mainConfig.PubTopic, 1, false, T)
+	if !token.WaitTimeout(timeout) {
+		return fmt.Errorf("mqtt publish not acknowledged in %v", timeout)
+	}
+	return token.Error()
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// acked 关掉才算 Broker 回了 PUBACK
+type pendingMqttToken struct {
+	acked chan struct{}
+}
+
+func (t pendingMqttToken) Wait() bool {
+	<-t.acked
+	return true
+}
+
+func (t pendingMqttToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-t.acked:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (t pendingMqttToken) Done() <-chan struct{} { return t.acked }
+func (t pendingMqttToken) Error() error          { return nil }
+
+type ackMqttClient struct {
+	mqtt.Client
+	token    pendingMqttToken
+	payloads []any
+}
+
+func (c *ackMqttClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	c.payloads = append(c.payloads, payload)
+	return c.token
+}
+
+// go test -timeout 30s -run ^Test_MqttTarget_ToAcked github.com/hootrhino/rhilex/target -v -count=1
+func Test_MqttTarget_ToAcked(t *testing.T) {
+	mq := NewMqttTarget(nil).(*mqttOutEndTarget)
+	cache := true // 同步自己重试, 开了离线缓存也不进
+	mq.mainConfig.CacheOfflineData = &cache
+	client := &ackMqttClient{token: pendingMqttToken{acked: make(chan struct{})}}
+	mq.client = client
+	if err := mq.ToAcked("[]", 20*time.Millisecond); err == nil {
+		t.Fatal("unacknowledged publish should fail")
+	}
+	close(client.token.acked)
+	if err := mq.ToAcked("[]", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.payloads) != 2 {
+		t.Fatal("unexpected payloads", client.payloads)
+	}
+	if err := mq.ToAcked(1, time.Second); err == nil {
+		t.Fatal("non string should fail")
+	}
+}
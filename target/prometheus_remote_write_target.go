@@ -0,0 +1,296 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/hootrhino/rhilex/component/lostcache"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+/*
+*
+* Prometheus Remote Write, 兼容 Prometheus/VictoriaMetrics/Mimir 等
+* 每个数值字段生成一条时间序列: __name__ = <measurement>_<field>, 标签来自 TagKeys 和 Tags
+*
+ */
+type PrometheusRemoteWriteConfig struct {
+	Url              string            `json:"url" validate:"required" title:"URL"` // http://127.0.0.1:9090/api/v1/write
+	Username         string            `json:"username" title:"用户"`
+	Password         string            `json:"password" title:"密码"`
+	BearerToken      string            `json:"bearerToken" title:"Token"`
+	Headers          map[string]string `json:"headers" title:"HTTP Headers"` // 比如 Mimir 的 X-Scope-OrgID
+	BatchSize        int               `json:"batchSize" title:"批量大小"`
+	FlushInterval    int               `json:"flushInterval" title:"刷新间隔(毫秒)"`
+	Timeout          int               `json:"timeout" title:"超时时间(毫秒)"`
+	CacheOfflineData *bool             `json:"cacheOfflineData" title:"离线缓存"`
+}
+
+type PrometheusRemoteWriteMainConfig struct {
+	PrometheusRemoteWriteConfig PrometheusRemoteWriteConfig `json:"commonConfig" validate:"required"`
+	PointMapping                TsdbPointMapping            `json:"pointMapping" validate:"required"`
+}
+
+type prometheusRemoteWriteTarget struct {
+	typex.XStatus
+	client     http.Client
+	mainConfig PrometheusRemoteWriteMainConfig
+	batcher    *targetBatcher[TsdbPoint]
+	status     typex.SourceState
+}
+
+func NewPrometheusRemoteWriteTarget(e typex.Rhilex) typex.XTarget {
+	prom := new(prometheusRemoteWriteTarget)
+	prom.RuleEngine = e
+	prom.mainConfig = PrometheusRemoteWriteMainConfig{
+		PrometheusRemoteWriteConfig: PrometheusRemoteWriteConfig{
+			Url:              "http://127.0.0.1:9090/api/v1/write",
+			Headers:          map[string]string{},
+			BatchSize:        100,
+			FlushInterval:    1000,
+			Timeout:          3000,
+			CacheOfflineData: new(bool),
+		},
+		PointMapping: TsdbPointMapping{
+			Measurement: "rhilex",
+			Tags:        map[string]string{},
+			TagKeys:     []string{},
+			FieldKeys:   []string{},
+			Precision:   "ms",
+		},
+	}
+	prom.status = typex.SOURCE_DOWN
+	return prom
+}
+
+func (prom *prometheusRemoteWriteTarget) Init(outEndId string, configMap map[string]any) error {
+	prom.PointId = outEndId
+	if err := utils.BindSourceConfig(configMap, &prom.mainConfig); err != nil {
+		return err
+	}
+	Url, err := url.ParseRequestURI(prom.mainConfig.PrometheusRemoteWriteConfig.Url)
+	if err != nil {
+		return err
+	}
+	if (Url.Scheme != "http" && Url.Scheme != "https") || Url.Host == "" {
+		return fmt.Errorf("invalid remote write url: %s", prom.mainConfig.PrometheusRemoteWriteConfig.Url)
+	}
+	return nil
+}
+
+func (prom *prometheusRemoteWriteTarget) Start(cctx typex.CCTX) error {
+	prom.Ctx = cctx.Ctx
+	prom.CancelCTX = cctx.CancelCTX
+	prom.client = http.Client{
+		Timeout: time.Duration(prom.mainConfig.PrometheusRemoteWriteConfig.Timeout) * time.Millisecond,
+	}
+	prom.batcher = newTargetBatcher[TsdbPoint](prom.mainConfig.PrometheusRemoteWriteConfig.BatchSize,
+		prom.write, prom.cacheOfflineData)
+	go prom.batcher.Run(prom.Ctx.Done(),
+		time.Duration(prom.mainConfig.PrometheusRemoteWriteConfig.FlushInterval)*time.Millisecond)
+	prom.status = typex.SOURCE_UP
+	// 补发数据
+	if *prom.mainConfig.PrometheusRemoteWriteConfig.CacheOfflineData {
+		if CacheData, err1 := lostcache.GetLostCacheData(prom.PointId); err1 != nil {
+			glogger.GLogger.Error(err1)
+		} else {
+			for _, data := range CacheData {
+				prom.To(data.Data)
+				{
+					lostcache.DeleteLostCacheData(prom.PointId, data.ID)
+				}
+			}
+		}
+	}
+	glogger.GLogger.Info("Prometheus Remote Write Target started")
+	return nil
+}
+
+func (prom *prometheusRemoteWriteTarget) Status() typex.SourceState {
+	return prom.status
+}
+
+func (prom *prometheusRemoteWriteTarget) To(data any) (any, error) {
+	switch T := data.(type) {
+	case string:
+		points, err := prom.mainConfig.PointMapping.ParsePoints(T)
+		if err != nil {
+			glogger.GLogger.Error(err)
+			return 0, err
+		}
+		return len(points), prom.batcher.Add(T, points)
+	}
+	return 0, fmt.Errorf("data type must string!")
+}
+
+func (prom *prometheusRemoteWriteTarget) Stop() {
+	prom.status = typex.SOURCE_DOWN
+	if prom.CancelCTX != nil {
+		prom.CancelCTX()
+	}
+}
+
+func (prom *prometheusRemoteWriteTarget) Details() *typex.OutEnd {
+	return prom.RuleEngine.GetOutEnd(prom.PointId)
+}
+
+func (prom *prometheusRemoteWriteTarget) cacheOfflineData(raws []string) {
+	if !*prom.mainConfig.PrometheusRemoteWriteConfig.CacheOfflineData {
+		return
+	}
+	for _, raw := range raws {
+		lostcache.SaveLostCacheData(prom.PointId, lostcache.CacheDataDto{
+			TargetId: prom.PointId,
+			Data:     raw,
+		})
+	}
+}
+
+func (prom *prometheusRemoteWriteTarget) write(points []TsdbPoint) error {
+	config := prom.mainConfig.PrometheusRemoteWriteConfig
+	payload := EncodeRemoteWriteRequest(points)
+	request, err := http.NewRequest("POST", config.Url,
+		bytes.NewReader(snappy.Encode(nil, payload)))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range config.Headers {
+		request.Header.Set(k, v)
+	}
+	if config.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+config.BearerToken)
+	} else if config.Username != "" {
+		request.SetBasicAuth(config.Username, config.Password)
+	}
+	response, err := prom.client.Do(request)
+	if err != nil {
+		glogger.GLogger.Error(err)
+		prom.status = typex.SOURCE_DOWN
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		bytes, _ := io.ReadAll(response.Body)
+		err := fmt.Errorf("remote write error: %d, %s", response.StatusCode, string(bytes))
+		glogger.GLogger.Error(err)
+		prom.status = typex.SOURCE_DOWN
+		return err
+	}
+	prom.status = typex.SOURCE_UP
+	return nil
+}
+
+/*
+*
+* 手工编码 prometheus.WriteRequest, 省得引入整个 prompb:
+*
+*	message WriteRequest { repeated TimeSeries timeseries = 1; }
+*	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+*	message Label        { string name = 1; string value = 2; }
+*	message Sample       { double value = 1; int64 timestamp = 2; }
+*
+ */
+func EncodeRemoteWriteRequest(points []TsdbPoint) []byte {
+	request := []byte{}
+	for _, point := range points {
+		for _, field := range sortedKeys(point.Fields) {
+			value, ok := remoteWriteValue(point.Fields[field])
+			if !ok {
+				continue
+			}
+			labels := map[string]string{}
+			for k, v := range point.Tags {
+				if v != "" {
+					labels[sanitizeMetricName(k)] = v
+				}
+			}
+			labels["__name__"] = sanitizeMetricName(point.Measurement + "_" + field)
+			series := []byte{}
+			names := make([]string, 0, len(labels))
+			for k := range labels {
+				names = append(names, k)
+			}
+			sort.Strings(names) // 远程写要求标签按名字排序
+			for _, name := range names {
+				label := protowire.AppendTag(nil, 1, protowire.BytesType)
+				label = protowire.AppendString(label, name)
+				label = protowire.AppendTag(label, 2, protowire.BytesType)
+				label = protowire.AppendString(label, labels[name])
+				series = protowire.AppendTag(series, 1, protowire.BytesType)
+				series = protowire.AppendBytes(series, label)
+			}
+			sample := protowire.AppendTag(nil, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(point.Timestamp.UnixMilli()))
+			series = protowire.AppendTag(series, 2, protowire.BytesType)
+			series = protowire.AppendBytes(series, sample)
+			request = protowire.AppendTag(request, 1, protowire.BytesType)
+			request = protowire.AppendBytes(request, series)
+		}
+	}
+	return request
+}
+
+// 只有数值和布尔可以作为样本
+func remoteWriteValue(v any) (float64, bool) {
+	switch T := v.(type) {
+	case float64:
+		return T, true
+	case float32:
+		return float64(T), true
+	case int:
+		return float64(T), true
+	case int64:
+		return float64(T), true
+	case bool:
+		if T {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// 指标名只能是 [a-zA-Z_:][a-zA-Z0-9_:]*
+func sanitizeMetricName(name string) string {
+	sb := strings.Builder{}
+	for i, c := range name {
+		if c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9') {
+			sb.WriteRune(c)
+			continue
+		}
+		sb.WriteByte('_')
+	}
+	return sb.String()
+}
@@ -0,0 +1,712 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/component/lostcache"
+	"github.com/hootrhino/rhilex/component/sparkplug"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+const (
+	sparkplugNamespace   = "spBv1.0"
+	sparkplugBdSeqMetric = "bdSeq"
+	sparkplugRebirth     = "Node Control/Rebirth"
+)
+
+/*
+*
+* Sparkplug B 子设备: 一个 RHILEX 设备对应一个 Sparkplug Device
+*
+ */
+type SparkplugDeviceConfig struct {
+	DeviceId    string `json:"deviceId" validate:"required" title:"Sparkplug设备ID"`
+	DeviceUuid  string `json:"deviceUuid" validate:"required" title:"设备UUID"`
+	SchemaId    string `json:"schemaId" title:"数据模型"`   // 额外的属性, 来自物模型
+	CtrlCommand string `json:"ctrlCommand" title:"写指令"` // DCMD 调用设备 OnCtrl 时的指令, 通用 Modbus 默认 WriteToSheetRegisterWithTag, 其他设备必填
+}
+
+type SparkplugEdgeNodeConfig struct {
+	Host             string `json:"host" validate:"required" title:"服务地址"`
+	Port             int    `json:"port" validate:"required" title:"服务端口"`
+	ClientId         string `json:"clientId" validate:"required" title:"客户端ID"`
+	Username         string `json:"username" title:"连接账户"`
+	Password         string `json:"password" title:"连接密码"`
+	GroupId          string `json:"groupId" validate:"required" title:"Group ID"`
+	EdgeNodeId       string `json:"edgeNodeId" validate:"required" title:"Edge Node ID"`
+	SchemaId         string `json:"schemaId" title:"节点数据模型"`            // 节点级别的 metric, 来自物模型
+	PublishInterval  int    `json:"publishInterval" title:"点位上报间隔(毫秒)"` // 定时把点位表里变化的值用 DDATA 上报, 0 表示不上报
+	CacheOfflineData *bool  `json:"cacheOfflineData" title:"离线缓存"`
+}
+
+type SparkplugEdgeNodeMainConfig struct {
+	SparkplugEdgeNodeConfig SparkplugEdgeNodeConfig `json:"commonConfig" validate:"required"`
+	Devices                 []SparkplugDeviceConfig `json:"devices"`
+}
+
+/*
+*
+* 规则里推送的数据: deviceId 为空表示节点数据(NDATA), 否则是设备数据(DDATA)
+*
+ */
+type SparkplugData struct {
+	DeviceId string         `json:"deviceId"`
+	Metrics  map[string]any `json:"metrics"`
+}
+
+// 一个 metric 的定义, 出生证明里面发过一次以后就只用别名
+type sparkplugMetricDef struct {
+	Name     string
+	Alias    uint64
+	DataType sparkplug.DataType
+	PointId  string // 来自点位表的 metric
+}
+
+type sparkplugMetricSet struct {
+	byName  map[string]*sparkplugMetricDef
+	byAlias map[uint64]*sparkplugMetricDef
+	names   []string // 保证出生证明里的顺序稳定
+}
+
+func newSparkplugMetricSet() *sparkplugMetricSet {
+	return &sparkplugMetricSet{
+		byName:  map[string]*sparkplugMetricDef{},
+		byAlias: map[uint64]*sparkplugMetricDef{},
+		names:   []string{},
+	}
+}
+
+type sparkplugDevice struct {
+	config  SparkplugDeviceConfig
+	metrics *sparkplugMetricSet
+	last    map[uint64]any // 上一次上报的点位值, 只上报变化的
+}
+
+type sparkplugEdgeNodeTarget struct {
+	typex.XStatus
+	client      mqtt.Client
+	mainConfig  SparkplugEdgeNodeMainConfig
+	locker      sync.Mutex
+	seq         uint64
+	bdSeq       uint64
+	nextAlias   uint64
+	nodeMetrics *sparkplugMetricSet
+	devices     map[string]*sparkplugDevice // Sparkplug DeviceId -> 设备
+	status      typex.SourceState
+}
+
+func NewSparkplugEdgeNodeTarget(e typex.Rhilex) typex.XTarget {
+	sp := new(sparkplugEdgeNodeTarget)
+	sp.RuleEngine = e
+	sp.mainConfig = SparkplugEdgeNodeMainConfig{
+		SparkplugEdgeNodeConfig: SparkplugEdgeNodeConfig{
+			Host:             "127.0.0.1",
+			Port:             1883,
+			ClientId:         "rhilex-edge-node",
+			GroupId:          "rhilex",
+			EdgeNodeId:       "rhilex",
+			PublishInterval:  1000,
+			CacheOfflineData: new(bool),
+		},
+		Devices: []SparkplugDeviceConfig{},
+	}
+	sp.status = typex.SOURCE_DOWN
+	return sp
+}
+
+func (sp *sparkplugEdgeNodeTarget) Init(outEndId string, configMap map[string]any) error {
+	sp.PointId = outEndId
+	if err := utils.BindSourceConfig(configMap, &sp.mainConfig); err != nil {
+		return err
+	}
+	config := sp.mainConfig.SparkplugEdgeNodeConfig
+	for _, id := range []string{config.GroupId, config.EdgeNodeId} {
+		if strings.ContainsAny(id, "/+#") {
+			return fmt.Errorf("invalid sparkplug id: %s", id)
+		}
+	}
+	deviceIds := map[string]bool{}
+	for _, device := range sp.mainConfig.Devices {
+		if device.DeviceId == "" || strings.ContainsAny(device.DeviceId, "/+#") {
+			return fmt.Errorf("invalid sparkplug device id: %s", device.DeviceId)
+		}
+		if deviceIds[device.DeviceId] {
+			return fmt.Errorf("duplicate sparkplug device id: %s", device.DeviceId)
+		}
+		deviceIds[device.DeviceId] = true
+	}
+	return nil
+}
+
+func (sp *sparkplugEdgeNodeTarget) Start(cctx typex.CCTX) error {
+	sp.Ctx = cctx.Ctx
+	sp.CancelCTX = cctx.CancelCTX
+	config := sp.mainConfig.SparkplugEdgeNodeConfig
+	if err := sp.loadMetrics(); err != nil {
+		return err
+	}
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("tcp://%s:%v", config.Host, config.Port))
+	opts.SetClientID(config.ClientId)
+	opts.SetUsername(config.Username)
+	opts.SetPassword(config.Password)
+	// 遗嘱就是 NDEATH, 和下一个 NBIRTH 使用同一个 bdSeq
+	opts.SetBinaryWill(sp.nodeTopic("NDEATH"), sp.deathPayload(), 1, false)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		glogger.GLogger.Infof("Sparkplug Edge Node Connected Success")
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		glogger.GLogger.Warn("Sparkplug Edge Node Connect lost:", err)
+	})
+	opts.SetCleanSession(true)
+	opts.SetOrderMatters(false)     // 回调里面还要发消息, 不能阻塞
+	opts.SetAutoReconnect(false)    // 不需要自动重连, 交给RHILEX管理
+	opts.SetMaxReconnectInterval(0) // 不需要自动重连, 交给RHILEX管理
+	opts.SetConnectTimeout(5 * time.Second)
+	opts.SetPingTimeout(5 * time.Second)
+	sp.client = mqtt.NewClient(opts)
+	token := sp.client.Connect()
+	if token.Wait() && token.Error() != nil {
+		sp.client = nil
+		return token.Error()
+	}
+	if err := sp.subscribeAndBirth(); err != nil {
+		// 已经连上了, 失败的时候要断开, 不然连接就泄漏了
+		sp.client.Disconnect(10)
+		sp.client = nil
+		return err
+	}
+	sp.status = typex.SOURCE_UP
+	if config.PublishInterval > 0 {
+		go sp.publishPoints(time.Duration(config.PublishInterval) * time.Millisecond)
+	}
+	// 补发数据
+	if *config.CacheOfflineData {
+		if CacheData, err1 := lostcache.GetLostCacheData(sp.PointId); err1 != nil {
+			glogger.GLogger.Error(err1)
+		} else {
+			for _, data := range CacheData {
+				sp.To(data.Data)
+				{
+					lostcache.DeleteLostCacheData(sp.PointId, data.ID)
+				}
+			}
+		}
+	}
+	glogger.GLogger.Info("Sparkplug Edge Node Target started")
+	return nil
+}
+
+func (sp *sparkplugEdgeNodeTarget) subscribeAndBirth() error {
+	if token := sp.client.Subscribe(sp.nodeTopic("NCMD"), 1, sp.onNodeCommand); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	if token := sp.client.Subscribe(sp.nodeTopic("DCMD")+"/+", 1, sp.onDeviceCommand); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return sp.rebirth()
+}
+
+func (sp *sparkplugEdgeNodeTarget) Status() typex.SourceState {
+	if sp.client != nil {
+		if sp.client.IsConnected() && sp.client.IsConnectionOpen() {
+			return typex.SOURCE_UP
+		}
+		return typex.SOURCE_DOWN
+	}
+	return sp.status
+}
+
+func (sp *sparkplugEdgeNodeTarget) Details() *typex.OutEnd {
+	return sp.RuleEngine.GetOutEnd(sp.PointId)
+}
+
+/*
+*
+* 正常下线的时候遗嘱不会触发, 所以要自己发 DDEATH 和 NDEATH
+*
+ */
+func (sp *sparkplugEdgeNodeTarget) Stop() {
+	sp.status = typex.SOURCE_DOWN
+	if sp.CancelCTX != nil {
+		sp.CancelCTX()
+	}
+	if sp.client != nil {
+		if sp.client.IsConnected() {
+			sp.locker.Lock()
+			for _, device := range sp.devices {
+				sp.publish(sp.deviceTopic("DDEATH", device.config.DeviceId), &sparkplug.Payload{})
+			}
+			sp.client.Publish(sp.nodeTopic("NDEATH"), 1, false, sp.deathPayload()).Wait()
+			sp.locker.Unlock()
+		}
+		sp.client.Disconnect(10)
+	}
+	sp.bdSeq = (sp.bdSeq + 1) % 256
+}
+
+/*
+*
+* 规则推过来的数据: {"deviceId": "d1", "metrics": {"temp": 23.5}}
+* 出生证明里没有的 metric 会先注册再重新发出生证明
+*
+ */
+func (sp *sparkplugEdgeNodeTarget) To(data any) (any, error) {
+	if sp.client == nil {
+		return nil, errors.New("sparkplug client is nil")
+	}
+	switch T := data.(type) {
+	case string:
+		spData := SparkplugData{}
+		if err := json.Unmarshal([]byte(T), &spData); err != nil {
+			return nil, err
+		}
+		if len(spData.Metrics) == 0 {
+			return nil, errors.New("sparkplug metrics can not be empty")
+		}
+		err := sp.publishData(spData)
+		if err != nil && *sp.mainConfig.SparkplugEdgeNodeConfig.CacheOfflineData {
+			lostcache.SaveLostCacheData(sp.PointId, lostcache.CacheDataDto{
+				TargetId: sp.PointId,
+				Data:     T,
+			})
+		}
+		return len(spData.Metrics), err
+	}
+	return nil, errors.New("Invalid sparkplug data type")
+}
+
+func (sp *sparkplugEdgeNodeTarget) publishData(spData SparkplugData) error {
+	sp.locker.Lock()
+	defer sp.locker.Unlock()
+	metrics := sp.nodeMetrics
+	topic := sp.nodeTopic("NDATA")
+	if spData.DeviceId != "" {
+		device, ok := sp.devices[spData.DeviceId]
+		if !ok {
+			return fmt.Errorf("sparkplug device not exists: %s", spData.DeviceId)
+		}
+		metrics = device.metrics
+		topic = sp.deviceTopic("DDATA", spData.DeviceId)
+	}
+	newMetric := false
+	for _, name := range sortedKeys(spData.Metrics) {
+		if _, ok := metrics.byName[name]; !ok {
+			sp.addMetric(metrics, name, sparkplug.InferDataType(spData.Metrics[name]), "")
+			newMetric = true
+		}
+	}
+	if newMetric {
+		// 节点的出生证明变了要整体重新出生, 设备的只需要重发 DBIRTH
+		if spData.DeviceId == "" {
+			if err := sp.birth(); err != nil {
+				return err
+			}
+		} else if err := sp.deviceBirth(sp.devices[spData.DeviceId]); err != nil {
+			return err
+		}
+	}
+	payload := &sparkplug.Payload{}
+	for _, name := range sortedKeys(spData.Metrics) {
+		def := metrics.byName[name]
+		payload.Metrics = append(payload.Metrics, sparkplug.Metric{
+			Alias:    def.Alias,
+			HasAlias: true,
+			DataType: def.DataType,
+			Value:    spData.Metrics[name],
+		})
+	}
+	return sp.publish(topic, payload)
+}
+
+/*
+*
+* 出生证明的 metric: 节点来自物模型, 设备来自点位表和物模型
+*
+ */
+func (sp *sparkplugEdgeNodeTarget) loadMetrics() error {
+	sp.locker.Lock()
+	defer sp.locker.Unlock()
+	sp.nextAlias = 0
+	sp.nodeMetrics = newSparkplugMetricSet()
+	sp.addMetric(sp.nodeMetrics, sparkplugBdSeqMetric, sparkplug.Int64, "")
+	sp.addMetric(sp.nodeMetrics, sparkplugRebirth, sparkplug.Boolean, "")
+	if err := sp.loadSchemaMetrics(sp.nodeMetrics, sp.mainConfig.SparkplugEdgeNodeConfig.SchemaId); err != nil {
+		return err
+	}
+	sp.devices = map[string]*sparkplugDevice{}
+	for _, config := range sp.mainConfig.Devices {
+		device := &sparkplugDevice{
+			config:  config,
+			metrics: newSparkplugMetricSet(),
+			last:    map[uint64]any{},
+		}
+		points, err := loadDevicePointTags(config.DeviceUuid)
+		if err != nil {
+			return err
+		}
+		for _, point := range points {
+			if _, ok := device.metrics.byName[point.Tag]; ok || point.Tag == "" {
+				continue
+			}
+			value := intercache.GetValue(config.DeviceUuid, point.UUID).Value
+			sp.addMetric(device.metrics, point.Tag, sparkplug.InferDataType(value), point.UUID)
+		}
+		if err := sp.loadSchemaMetrics(device.metrics, config.SchemaId); err != nil {
+			return err
+		}
+		sp.devices[config.DeviceId] = device
+	}
+	return nil
+}
+
+func (sp *sparkplugEdgeNodeTarget) loadSchemaMetrics(metrics *sparkplugMetricSet, schemaId string) error {
+	if schemaId == "" {
+		return nil
+	}
+	MIotProperties := []model.MIotProperty{}
+	if err := interdb.InterDb().Model(model.MIotProperty{}).
+		Where("schema_id=?", schemaId).
+		Find(&MIotProperties).Error; err != nil {
+		return err
+	}
+	for _, MIotProperty := range MIotProperties {
+		if _, ok := metrics.byName[MIotProperty.Name]; ok {
+			continue
+		}
+		dataType := sparkplug.String
+		switch MIotProperty.Type {
+		case "INTEGER":
+			dataType = sparkplug.Int64
+		case "FLOAT":
+			dataType = sparkplug.Double
+		case "BOOL":
+			dataType = sparkplug.Boolean
+		}
+		sp.addMetric(metrics, MIotProperty.Name, dataType, "")
+	}
+	return nil
+}
+
+// 别名在整个节点里唯一
+func (sp *sparkplugEdgeNodeTarget) addMetric(metrics *sparkplugMetricSet,
+	name string, dataType sparkplug.DataType, pointId string) {
+	def := &sparkplugMetricDef{Name: name, Alias: sp.nextAlias, DataType: dataType, PointId: pointId}
+	sp.nextAlias++
+	metrics.byName[name] = def
+	metrics.byAlias[def.Alias] = def
+	metrics.names = append(metrics.names, name)
+}
+
+type sparkplugPointTag struct {
+	UUID string
+	Tag  string
+}
+
+// 所有带点位表的设备
+var sparkplugPointSheets = []any{
+	model.MModbusDataPoint{},
+	model.MSiemensDataPoint{},
+	model.MSnmpOid{},
+	model.MBacnetDataPoint{},
+	model.MBacnetRouterDataPoint{},
+	model.MDlt6452007DataPoint{},
+	model.MCjt1882004DataPoint{},
+	model.MSzy2062016DataPoint{},
+	model.MUserProtocolDataPoint{},
+	model.MMBusDataPoint{},
+}
+
+func loadDevicePointTags(deviceUuid string) ([]sparkplugPointTag, error) {
+	points := []sparkplugPointTag{}
+	for _, sheet := range sparkplugPointSheets {
+		rows := []sparkplugPointTag{}
+		if err := interdb.InterDb().Model(sheet).Select("uuid, tag").
+			Where("device_uuid=?", deviceUuid).Order("id").Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		points = append(points, rows...)
+	}
+	return points, nil
+}
+
+/*
+*
+* 重新出生: NBIRTH 的 seq 归零, 然后所有设备发 DBIRTH
+*
+ */
+func (sp *sparkplugEdgeNodeTarget) rebirth() error {
+	sp.locker.Lock()
+	defer sp.locker.Unlock()
+	return sp.birth()
+}
+
+func (sp *sparkplugEdgeNodeTarget) birth() error {
+	sp.seq = 0
+	payload := &sparkplug.Payload{}
+	for _, name := range sp.nodeMetrics.names {
+		def := sp.nodeMetrics.byName[name]
+		metric := sparkplug.Metric{
+			Name:     def.Name,
+			Alias:    def.Alias,
+			HasAlias: true,
+			DataType: def.DataType,
+		}
+		switch name {
+		case sparkplugBdSeqMetric:
+			metric.Value = int64(sp.bdSeq)
+		case sparkplugRebirth:
+			metric.Value = false
+		}
+		payload.Metrics = append(payload.Metrics, metric)
+	}
+	if err := sp.publish(sp.nodeTopic("NBIRTH"), payload); err != nil {
+		return err
+	}
+	for _, device := range sp.devices {
+		if err := sp.deviceBirth(device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sp *sparkplugEdgeNodeTarget) deviceBirth(device *sparkplugDevice) error {
+	payload := &sparkplug.Payload{}
+	for _, name := range device.metrics.names {
+		def := device.metrics.byName[name]
+		metric := sparkplug.Metric{
+			Name:     def.Name,
+			Alias:    def.Alias,
+			HasAlias: true,
+			DataType: def.DataType,
+		}
+		if def.PointId != "" {
+			value := intercache.GetValue(device.config.DeviceUuid, def.PointId)
+			if value.Status == 1 {
+				metric.Value = value.Value
+				device.last[def.Alias] = value.Value
+			}
+		}
+		payload.Metrics = append(payload.Metrics, metric)
+	}
+	return sp.publish(sp.deviceTopic("DBIRTH", device.config.DeviceId), payload)
+}
+
+/*
+*
+* 定时把点位表里变化的值上报, 采集失败的点位上报为空值
+*
+ */
+func (sp *sparkplugEdgeNodeTarget) publishPoints(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sp.Ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sp.locker.Lock()
+		for _, device := range sp.devices {
+			payload := &sparkplug.Payload{}
+			for _, name := range device.metrics.names {
+				def := device.metrics.byName[name]
+				if def.PointId == "" {
+					continue
+				}
+				cache := intercache.GetValue(device.config.DeviceUuid, def.PointId)
+				var value any
+				if cache.Status == 1 {
+					value = cache.Value
+				}
+				if last, ok := device.last[def.Alias]; ok && reflect.DeepEqual(last, value) {
+					continue
+				}
+				device.last[def.Alias] = value
+				payload.Metrics = append(payload.Metrics, sparkplug.Metric{
+					Alias:     def.Alias,
+					HasAlias:  true,
+					Timestamp: cache.LastFetchTime,
+					DataType:  def.DataType,
+					IsNull:    value == nil,
+					Value:     value,
+				})
+			}
+			if len(payload.Metrics) == 0 {
+				continue
+			}
+			if err := sp.publish(sp.deviceTopic("DDATA", device.config.DeviceId), payload); err != nil {
+				glogger.GLogger.Error(err)
+			}
+		}
+		sp.locker.Unlock()
+	}
+}
+
+/*
+*
+* NCMD: 只处理 Node Control/Rebirth
+*
+ */
+func (sp *sparkplugEdgeNodeTarget) onNodeCommand(client mqtt.Client, msg mqtt.Message) {
+	payload, err := sparkplug.Unmarshal(msg.Payload())
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return
+	}
+	for _, metric := range payload.Metrics {
+		name := metric.Name
+		if name == "" && metric.HasAlias {
+			sp.locker.Lock()
+			if def, ok := sp.nodeMetrics.byAlias[metric.Alias]; ok {
+				name = def.Name
+			}
+			sp.locker.Unlock()
+		}
+		if name == sparkplugRebirth && metric.Value == true {
+			glogger.GLogger.Info("Sparkplug Edge Node rebirth")
+			if err := sp.rebirth(); err != nil {
+				glogger.GLogger.Error(err)
+			}
+			return
+		}
+	}
+}
+
+/*
+*
+* DCMD: 每个 metric 转成一次设备的 OnCtrl 调用, 参数是 {"tag": name, "value": "..."}
+*
+ */
+func (sp *sparkplugEdgeNodeTarget) onDeviceCommand(client mqtt.Client, msg mqtt.Message) {
+	deviceId := msg.Topic()[strings.LastIndex(msg.Topic(), "/")+1:]
+	sp.locker.Lock()
+	device, ok := sp.devices[deviceId]
+	sp.locker.Unlock()
+	if !ok {
+		glogger.GLogger.Errorf("sparkplug device not exists: %s", deviceId)
+		return
+	}
+	payload, err := sparkplug.Unmarshal(msg.Payload())
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return
+	}
+	rhilexDevice := sp.RuleEngine.GetDevice(device.config.DeviceUuid)
+	if rhilexDevice == nil || rhilexDevice.Device == nil {
+		glogger.GLogger.Errorf("device not exists: %s", device.config.DeviceUuid)
+		return
+	}
+	if rhilexDevice.Device.Status() != typex.SOURCE_UP {
+		glogger.GLogger.Errorf("device is not running: %s", device.config.DeviceUuid)
+		return
+	}
+	cmd, err := sparkplugCtrlCommand(device.config, rhilexDevice)
+	if err != nil {
+		glogger.GLogger.Errorf("sparkplug DCMD %s error: %s", deviceId, err)
+		return
+	}
+	for _, metric := range payload.Metrics {
+		name := metric.Name
+		if name == "" && metric.HasAlias {
+			sp.locker.Lock()
+			if def, ok := device.metrics.byAlias[metric.Alias]; ok {
+				name = def.Name
+			}
+			sp.locker.Unlock()
+		}
+		if name == "" {
+			continue
+		}
+		value := fmt.Sprintf("%v", metric.Value)
+		if b, ok := metric.Value.(bool); ok {
+			value = "0"
+			if b {
+				value = "1"
+			}
+		}
+		args, _ := json.Marshal(map[string]string{"tag": name, "value": value})
+		if _, err := rhilexDevice.Device.OnCtrl([]byte(cmd), args); err != nil {
+			glogger.GLogger.Errorf("sparkplug DCMD %s/%s error: %s", deviceId, name, err)
+		}
+	}
+}
+
+/*
+*
+* DCMD 调用的设备指令: 没有配置 ctrlCommand 的时候默认 WriteToSheetRegisterWithTag,
+* 这个指令只有通用 Modbus 设备支持, 其他设备必须配置
+*
+ */
+func sparkplugCtrlCommand(config SparkplugDeviceConfig, device *typex.Device) (string, error) {
+	if config.CtrlCommand != "" {
+		return config.CtrlCommand, nil
+	}
+	if device.Type != typex.GENERIC_MODBUS_MASTER {
+		return "", fmt.Errorf("device %s type %s has no default ctrl command, ctrlCommand is required",
+			device.UUID, device.Type)
+	}
+	return "WriteToSheetRegisterWithTag", nil
+}
+
+// 调用方必须持有锁; 除了 NDEATH 以外每条消息都要带 0~255 循环的 seq
+func (sp *sparkplugEdgeNodeTarget) publish(topic string, payload *sparkplug.Payload) error {
+	payload.Timestamp = uint64(time.Now().UnixMilli())
+	payload.Seq = sp.seq
+	payload.HasSeq = true
+	sp.seq = (sp.seq + 1) % 256
+	token := sp.client.Publish(topic, 0, false, payload.Marshal())
+	token.Wait()
+	return token.Error()
+}
+
+func (sp *sparkplugEdgeNodeTarget) deathPayload() []byte {
+	payload := &sparkplug.Payload{
+		Timestamp: uint64(time.Now().UnixMilli()),
+		Metrics: []sparkplug.Metric{{
+			Name:     sparkplugBdSeqMetric,
+			DataType: sparkplug.Int64,
+			Value:    int64(sp.bdSeq),
+		}},
+	}
+	return payload.Marshal()
+}
+
+// spBv1.0/<group>/<type>/<node>
+func (sp *sparkplugEdgeNodeTarget) nodeTopic(messageType string) string {
+	config := sp.mainConfig.SparkplugEdgeNodeConfig
+	return strings.Join([]string{sparkplugNamespace, config.GroupId, messageType, config.EdgeNodeId}, "/")
+}
+
+// spBv1.0/<group>/<type>/<node>/<device>
+func (sp *sparkplugEdgeNodeTarget) deviceTopic(messageType, deviceId string) string {
+	return sp.nodeTopic(messageType) + "/" + deviceId
+}
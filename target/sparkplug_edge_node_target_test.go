@@ -0,0 +1,287 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/hootrhino/rhilex/component/sparkplug"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+type fakeMqttToken struct{ err error }
+
+func (t fakeMqttToken) Wait() bool                     { return true }
+func (t fakeMqttToken) WaitTimeout(time.Duration) bool { return true }
+func (t fakeMqttToken) Error() error                   { return t.err }
+func (t fakeMqttToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+type fakeMqttMessage struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m fakeMqttMessage) Topic() string   { return m.topic }
+func (m fakeMqttMessage) Payload() []byte { return m.payload }
+
+type sparkplugMessage struct {
+	topic   string
+	payload *sparkplug.Payload
+}
+
+// 记下发出去的每条消息
+type fakeMqttClient struct {
+	mqtt.Client
+	messages []sparkplugMessage
+}
+
+func (c *fakeMqttClient) IsConnected() bool { return true }
+func (c *fakeMqttClient) Disconnect(uint)   {}
+func (c *fakeMqttClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	p, err := sparkplug.Unmarshal(payload.([]byte))
+	if err != nil {
+		return fakeMqttToken{err: err}
+	}
+	c.messages = append(c.messages, sparkplugMessage{topic: topic, payload: p})
+	return fakeMqttToken{}
+}
+
+func (c *fakeMqttClient) take() []sparkplugMessage {
+	messages := c.messages
+	c.messages = nil
+	return messages
+}
+
+type fakeCtrlDevice struct {
+	typex.XDevice
+	calls []string
+}
+
+func (d *fakeCtrlDevice) Status() typex.SourceState { return typex.SOURCE_UP }
+func (d *fakeCtrlDevice) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	d.calls = append(d.calls, string(cmd)+" "+string(args))
+	return nil, nil
+}
+
+type fakeSparkplugRhilex struct {
+	typex.Rhilex
+	devices map[string]*typex.Device
+}
+
+func (r *fakeSparkplugRhilex) GetDevice(uuid string) *typex.Device {
+	return r.devices[uuid]
+}
+
+// 不连 Broker 也不读数据库, 手工建好 metric
+func newTestEdgeNode(t *testing.T, rx typex.Rhilex) (*sparkplugEdgeNodeTarget, *fakeMqttClient) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	sp := NewSparkplugEdgeNodeTarget(rx).(*sparkplugEdgeNodeTarget)
+	if err := sp.Init("SP1", map[string]any{
+		"commonConfig": map[string]any{"groupId": "g1", "edgeNodeId": "n1"},
+		"devices": []map[string]any{
+			{"deviceId": "d1", "deviceUuid": "DEVICE1"},
+			{"deviceId": "d2", "deviceUuid": "DEVICE2", "ctrlCommand": "Write"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeMqttClient{}
+	sp.client = client
+	sp.nodeMetrics = newSparkplugMetricSet()
+	sp.addMetric(sp.nodeMetrics, sparkplugBdSeqMetric, sparkplug.Int64, "")
+	sp.addMetric(sp.nodeMetrics, sparkplugRebirth, sparkplug.Boolean, "")
+	sp.devices = map[string]*sparkplugDevice{}
+	for _, config := range sp.mainConfig.Devices {
+		device := &sparkplugDevice{config: config, metrics: newSparkplugMetricSet(), last: map[uint64]any{}}
+		sp.addMetric(device.metrics, "temp", sparkplug.Double, "")
+		sp.devices[config.DeviceId] = device
+	}
+	return sp, client
+}
+
+func metricNames(p *sparkplug.Payload) map[string]uint64 {
+	names := map[string]uint64{}
+	for _, metric := range p.Metrics {
+		names[metric.Name] = metric.Alias
+	}
+	return names
+}
+
+// go test -timeout 30s -run ^Test_SparkplugEdgeNode_Birth github.com/hootrhino/rhilex/target -v -count=1
+func Test_SparkplugEdgeNode_Birth(t *testing.T) {
+	sp, client := newTestEdgeNode(t, nil)
+	if err := sp.rebirth(); err != nil {
+		t.Fatal(err)
+	}
+	messages := client.take()
+	if len(messages) != 3 || messages[0].topic != "spBv1.0/g1/NBIRTH/n1" || messages[0].payload.Seq != 0 {
+		t.Fatal("unexpected birth", messages)
+	}
+	// 别名在整个节点里唯一, 出生证明里名字和别名都有
+	aliases := map[uint64]bool{}
+	for i, message := range messages {
+		if i > 0 && !strings.HasPrefix(message.topic, "spBv1.0/g1/DBIRTH/n1/d") {
+			t.Fatal("unexpected topic", message.topic)
+		}
+		if message.payload.Seq != uint64(i) {
+			t.Fatal("unexpected seq", i, message.payload.Seq)
+		}
+		for name, alias := range metricNames(message.payload) {
+			if name == "" || aliases[alias] {
+				t.Fatal("metric must have name and unique alias", name, alias)
+			}
+			aliases[alias] = true
+		}
+	}
+	if v := messages[0].payload.Metrics[0]; v.Name != sparkplugBdSeqMetric || v.Value != int64(0) {
+		t.Fatal("unexpected bdSeq", v)
+	}
+	// 新的 metric: 先只重发这个设备的 DBIRTH, 然后 DDATA 只带别名
+	if _, err := sp.To(`{"deviceId":"d1","metrics":{"temp":21.5,"hum":60}}`); err != nil {
+		t.Fatal(err)
+	}
+	messages = client.take()
+	if len(messages) != 2 || messages[0].topic != "spBv1.0/g1/DBIRTH/n1/d1" || messages[1].topic != "spBv1.0/g1/DDATA/n1/d1" {
+		t.Fatal("unexpected messages", messages)
+	}
+	birth := metricNames(messages[0].payload)
+	if len(birth) != 2 || aliases[birth["hum"]] {
+		t.Fatal("new metric should get a new alias", birth)
+	}
+	for _, metric := range messages[1].payload.Metrics {
+		if metric.Name != "" || !metric.HasAlias {
+			t.Fatal("DDATA should only use alias", metric)
+		}
+	}
+	if messages[1].payload.Metrics[0].Alias != birth["hum"] || messages[1].payload.Metrics[0].Value != 60.0 {
+		t.Fatal("unexpected DDATA", messages[1].payload.Metrics)
+	}
+	// 节点的新 metric 要整体重新出生
+	if _, err := sp.To(`{"metrics":{"uptime":1}}`); err != nil {
+		t.Fatal(err)
+	}
+	messages = client.take()
+	if len(messages) != 4 || messages[0].topic != "spBv1.0/g1/NBIRTH/n1" || messages[0].payload.Seq != 0 ||
+		messages[3].topic != "spBv1.0/g1/NDATA/n1" || messages[3].payload.Seq != 3 {
+		t.Fatal("unexpected node rebirth", messages)
+	}
+	if _, err := sp.To(`{"deviceId":"nope","metrics":{"a":1}}`); err == nil {
+		t.Fatal("unknown device should fail")
+	}
+	if _, err := sp.To(`{"deviceId":"d1","metrics":{}}`); err == nil {
+		t.Fatal("empty metrics should fail")
+	}
+}
+
+// go test -timeout 30s -run ^Test_SparkplugEdgeNode_SeqWrap github.com/hootrhino/rhilex/target -v -count=1
+func Test_SparkplugEdgeNode_SeqWrap(t *testing.T) {
+	sp, client := newTestEdgeNode(t, nil)
+	sp.seq = 254
+	for i := 0; i < 3; i++ {
+		if _, err := sp.To(`{"deviceId":"d1","metrics":{"temp":1.5}}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := client.take()
+	if len(messages) != 3 || messages[0].payload.Seq != 254 || messages[1].payload.Seq != 255 || messages[2].payload.Seq != 0 {
+		t.Fatal("seq should wrap at 256", messages)
+	}
+	// 停止以后 bdSeq 加一, 也是 0~255 循环
+	sp.bdSeq = 255
+	sp.Stop()
+	messages = client.take()
+	if last := messages[len(messages)-1]; last.topic != "spBv1.0/g1/NDEATH/n1" || last.payload.Metrics[0].Value != int64(255) {
+		t.Fatal("unexpected death", last)
+	}
+	if sp.bdSeq != 0 {
+		t.Fatal("bdSeq should wrap", sp.bdSeq)
+	}
+}
+
+// go test -timeout 30s -run ^Test_SparkplugEdgeNode_Commands github.com/hootrhino/rhilex/target -v -count=1
+func Test_SparkplugEdgeNode_Commands(t *testing.T) {
+	modbus := &fakeCtrlDevice{}
+	other := &fakeCtrlDevice{}
+	rx := &fakeSparkplugRhilex{devices: map[string]*typex.Device{
+		"DEVICE1": {UUID: "DEVICE1", Type: typex.SIEMENS_PLC, Device: other},
+		"DEVICE2": {UUID: "DEVICE2", Type: typex.SIEMENS_PLC, Device: modbus},
+	}}
+	sp, client := newTestEdgeNode(t, rx)
+	sp.rebirth()
+	client.take()
+	// NCMD Rebirth, 按名字和按别名都行
+	rebirthAlias := sp.nodeMetrics.byName[sparkplugRebirth].Alias
+	for _, metric := range []sparkplug.Metric{
+		{Name: sparkplugRebirth, DataType: sparkplug.Boolean, Value: true},
+		{Alias: rebirthAlias, HasAlias: true, DataType: sparkplug.Boolean, Value: true},
+	} {
+		sp.seq = 10
+		payload := &sparkplug.Payload{Metrics: []sparkplug.Metric{metric}}
+		sp.onNodeCommand(client, fakeMqttMessage{topic: "spBv1.0/g1/NCMD/n1", payload: payload.Marshal()})
+		messages := client.take()
+		if len(messages) != 3 || messages[0].topic != "spBv1.0/g1/NBIRTH/n1" || messages[0].payload.Seq != 0 {
+			t.Fatal("rebirth expected", messages)
+		}
+	}
+	payload := &sparkplug.Payload{Metrics: []sparkplug.Metric{{Name: sparkplugRebirth, DataType: sparkplug.Boolean, Value: false}}}
+	sp.onNodeCommand(client, fakeMqttMessage{topic: "spBv1.0/g1/NCMD/n1", payload: payload.Marshal()})
+	if messages := client.take(); len(messages) != 0 {
+		t.Fatal("rebirth=false should be ignored", messages)
+	}
+	// DCMD 按设备路由, 别名换成名字
+	tempAlias := sp.devices["d2"].metrics.byName["temp"].Alias
+	payload = &sparkplug.Payload{Metrics: []sparkplug.Metric{
+		{Alias: tempAlias, HasAlias: true, DataType: sparkplug.Double, Value: 25.5},
+		{Name: "on", DataType: sparkplug.Boolean, Value: true},
+		{Alias: 999, HasAlias: true, DataType: sparkplug.Double, Value: 1.0}, // 不认识的别名跳过
+	}}
+	sp.onDeviceCommand(client, fakeMqttMessage{topic: "spBv1.0/g1/DCMD/n1/d2", payload: payload.Marshal()})
+	if len(modbus.calls) != 2 || modbus.calls[0] != `Write {"tag":"temp","value":"25.5"}` ||
+		modbus.calls[1] != `Write {"tag":"on","value":"1"}` {
+		t.Fatal("unexpected calls", modbus.calls)
+	}
+	// 没有配置 ctrlCommand 的非 Modbus 设备拒绝执行
+	sp.onDeviceCommand(client, fakeMqttMessage{topic: "spBv1.0/g1/DCMD/n1/d1", payload: payload.Marshal()})
+	if len(other.calls) != 0 {
+		t.Fatal("default command should only apply to modbus", other.calls)
+	}
+	rx.devices["DEVICE1"].Type = typex.GENERIC_MODBUS_MASTER
+	sp.onDeviceCommand(client, fakeMqttMessage{topic: "spBv1.0/g1/DCMD/n1/d1", payload: payload.Marshal()})
+	// d2 的别名在 d1 上不认识, 只剩按名字的那个
+	if len(other.calls) != 1 {
+		t.Fatal("unexpected calls", other.calls)
+	}
+	var args map[string]string
+	json.Unmarshal([]byte(other.calls[0][len("WriteToSheetRegisterWithTag "):]), &args)
+	if args["tag"] != "on" || args["value"] != "1" {
+		t.Fatal("unexpected args", other.calls[0])
+	}
+	// 不存在的设备
+	sp.onDeviceCommand(client, fakeMqttMessage{topic: "spBv1.0/g1/DCMD/n1/d9", payload: payload.Marshal()})
+	if len(modbus.calls) != 2 || len(other.calls) != 1 {
+		t.Fatal("unknown device should be ignored")
+	}
+}
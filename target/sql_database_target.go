@@ -0,0 +1,644 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/lostcache"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/*
+*
+* 列映射: 把规则传过来的字段写进表的某一列, 类型和数据中心的数据模型保持一致:
+* STRING | INTEGER | FLOAT | BOOL | DATETIME
+*
+ */
+type SqlColumnMapping struct {
+	Name         string `json:"name" validate:"required" title:"列名"`
+	Field        string `json:"field" title:"字段"` // 为空表示和列名一样
+	Type         string `json:"type" validate:"required" title:"类型"`
+	DefaultValue string `json:"defaultValue" title:"默认值"`
+}
+
+type SqlDatabaseConfig struct {
+	Driver           string             `json:"driver" validate:"required" title:"驱动"` // sqlite3 | mysql | postgres
+	Dsn              string             `json:"dsn" validate:"required" title:"连接串"`
+	Table            string             `json:"table" validate:"required" title:"数据表"`
+	AutoCreateTable  *bool              `json:"autoCreateTable" title:"自动建表"`
+	Columns          []SqlColumnMapping `json:"columns" validate:"required,dive" title:"列映射"`
+	UpsertKeys       []string           `json:"upsertKeys" title:"主键"` // 不为空则按主键更新
+	BatchSize        int                `json:"batchSize" title:"批量大小"`
+	FlushInterval    int                `json:"flushInterval" title:"刷新间隔(毫秒)"`
+	MaxOpenConns     int                `json:"maxOpenConns" title:"最大连接数"`
+	MaxIdleConns     int                `json:"maxIdleConns" title:"最大空闲连接数"`
+	ConnMaxLifetime  int                `json:"connMaxLifetime" title:"连接存活时间(秒)"`
+	CacheOfflineData *bool              `json:"cacheOfflineData" title:"离线缓存"`
+}
+
+type SqlDatabaseMainConfig struct {
+	SqlDatabaseConfig SqlDatabaseConfig `json:"commonConfig" validate:"required"`
+}
+
+/*
+*
+* 关系型数据库输出, 支持 PostgreSQL/MySQL/SQLite, 每个批次一个事务
+*
+ */
+type sqlDatabaseTarget struct {
+	typex.XStatus
+	db         *sql.DB
+	mainConfig SqlDatabaseMainConfig
+	batcher    *targetBatcher[[]any]
+	insertSql  string
+	status     typex.SourceState
+}
+
+var sqlIdentifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func NewSqlDatabaseTarget(e typex.Rhilex) typex.XTarget {
+	sqlt := new(sqlDatabaseTarget)
+	sqlt.RuleEngine = e
+	sqlt.mainConfig = SqlDatabaseMainConfig{
+		SqlDatabaseConfig: SqlDatabaseConfig{
+			Driver:           "sqlite3",
+			Dsn:              "./rhilex_sql_target.db",
+			Table:            "rhilex",
+			AutoCreateTable:  new(bool),
+			Columns:          []SqlColumnMapping{},
+			UpsertKeys:       []string{},
+			BatchSize:        100,
+			FlushInterval:    1000,
+			MaxOpenConns:     4,
+			MaxIdleConns:     2,
+			ConnMaxLifetime:  300,
+			CacheOfflineData: new(bool),
+		},
+	}
+	sqlt.status = typex.SOURCE_DOWN
+	return sqlt
+}
+
+func (sqlt *sqlDatabaseTarget) Init(outEndId string, configMap map[string]any) error {
+	sqlt.PointId = outEndId
+	if err := utils.BindSourceConfig(configMap, &sqlt.mainConfig); err != nil {
+		return err
+	}
+	config := sqlt.mainConfig.SqlDatabaseConfig
+	if !utils.SContains([]string{"sqlite3", "mysql", "postgres"}, config.Driver) {
+		return fmt.Errorf("unsupported driver: %s", config.Driver)
+	}
+	// 表名和列名会被拼进SQL, 必须是合法标识符
+	if !sqlIdentifierRegex.MatchString(config.Table) {
+		return fmt.Errorf("invalid table name: %s", config.Table)
+	}
+	if len(config.Columns) == 0 {
+		return fmt.Errorf("columns can not be empty")
+	}
+	columns := map[string]bool{}
+	for _, column := range config.Columns {
+		if !sqlIdentifierRegex.MatchString(column.Name) {
+			return fmt.Errorf("invalid column name: %s", column.Name)
+		}
+		if !utils.SContains([]string{"STRING", "INTEGER", "FLOAT", "BOOL", "DATETIME"}, column.Type) {
+			return fmt.Errorf("invalid column type: %s", column.Type)
+		}
+		if _, err := sqlDefaultLiteral(config.Driver, column); err != nil {
+			return fmt.Errorf("column %s default value: %w", column.Name, err)
+		}
+		columns[column.Name] = true
+	}
+	for _, key := range config.UpsertKeys {
+		if !columns[key] {
+			return fmt.Errorf("upsert key not in columns: %s", key)
+		}
+	}
+	sqlt.insertSql = sqlt.generateInsertSql()
+	return nil
+}
+
+func (sqlt *sqlDatabaseTarget) Start(cctx typex.CCTX) error {
+	sqlt.Ctx = cctx.Ctx
+	sqlt.CancelCTX = cctx.CancelCTX
+	config := sqlt.mainConfig.SqlDatabaseConfig
+	db, err := sql.Open(config.Driver, config.Dsn)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(config.ConnMaxLifetime) * time.Second)
+	if err := db.PingContext(sqlt.Ctx); err != nil {
+		db.Close()
+		return err
+	}
+	sqlt.db = db
+	if *config.AutoCreateTable {
+		ddl := sqlt.generateCreateTableDDL()
+		glogger.GLogger.Debug(ddl)
+		if _, err := db.ExecContext(sqlt.Ctx, ddl); err != nil {
+			db.Close()
+			return err
+		}
+	}
+	if err := sqlt.validateSchema(); err != nil {
+		db.Close()
+		return err
+	}
+	sqlt.batcher = newTargetBatcher[[]any](config.BatchSize, sqlt.write, sqlt.cacheOfflineData)
+	go sqlt.batcher.Run(sqlt.Ctx.Done(), time.Duration(config.FlushInterval)*time.Millisecond)
+	sqlt.status = typex.SOURCE_UP
+	// 补发数据
+	if *config.CacheOfflineData {
+		if CacheData, err1 := lostcache.GetLostCacheData(sqlt.PointId); err1 != nil {
+			glogger.GLogger.Error(err1)
+		} else {
+			for _, data := range CacheData {
+				sqlt.To(data.Data)
+				{
+					lostcache.DeleteLostCacheData(sqlt.PointId, data.ID)
+				}
+			}
+		}
+	}
+	glogger.GLogger.Info("SQL Database Target started")
+	return nil
+}
+
+func (sqlt *sqlDatabaseTarget) Status() typex.SourceState {
+	if sqlt.db == nil {
+		return typex.SOURCE_DOWN
+	}
+	if err := sqlt.db.Ping(); err != nil {
+		glogger.GLogger.Error(err)
+		return typex.SOURCE_DOWN
+	}
+	return sqlt.status
+}
+
+/*
+*
+* 数据可以是一个JSON对象, 也可以是对象数组, 每个对象是一行
+*
+ */
+func (sqlt *sqlDatabaseTarget) To(data any) (any, error) {
+	switch T := data.(type) {
+	case string:
+		rows, err := sqlt.parseRows(T)
+		if err != nil {
+			glogger.GLogger.Error(err)
+			return 0, err
+		}
+		return len(rows), sqlt.batcher.Add(T, rows)
+	}
+	return 0, fmt.Errorf("data type must string!")
+}
+
+func (sqlt *sqlDatabaseTarget) Stop() {
+	sqlt.status = typex.SOURCE_DOWN
+	if sqlt.CancelCTX != nil {
+		sqlt.CancelCTX()
+	}
+	// 等缓冲区最后一次刷完再关连接, 不然剩下的数据就丢了
+	if sqlt.batcher != nil {
+		sqlt.batcher.Wait()
+		sqlt.batcher = nil
+	}
+	if sqlt.db != nil {
+		sqlt.db.Close()
+		sqlt.db = nil
+	}
+}
+
+func (sqlt *sqlDatabaseTarget) Details() *typex.OutEnd {
+	return sqlt.RuleEngine.GetOutEnd(sqlt.PointId)
+}
+
+func (sqlt *sqlDatabaseTarget) cacheOfflineData(raws []string) {
+	if !*sqlt.mainConfig.SqlDatabaseConfig.CacheOfflineData {
+		return
+	}
+	for _, raw := range raws {
+		lostcache.SaveLostCacheData(sqlt.PointId, lostcache.CacheDataDto{
+			TargetId: sqlt.PointId,
+			Data:     raw,
+		})
+	}
+}
+
+/*
+*
+* 一个批次一个事务, 任何一行失败整个批次回滚; 回滚以后连接还通的话是某几行数据不对(约束, 类型),
+* 改成一行一行写, 写不进去的记日志丢掉, 不然坏数据进了离线缓存每次启动都会重放;
+* 连接断了才返回错误, 整批交给离线缓存
+*
+ */
+func (sqlt *sqlDatabaseTarget) write(rows [][]any) error {
+	err := sqlt.writeBatch(rows)
+	if err == nil || !sqlt.connected() {
+		return err
+	}
+	glogger.GLogger.Warn("SQL batch failed, write rows one by one:", err)
+	for _, row := range rows {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := sqlt.db.ExecContext(ctx, sqlt.insertSql, row...)
+		cancel()
+		if err == nil {
+			continue
+		}
+		if !sqlt.connected() {
+			return err
+		}
+		glogger.GLogger.Error("SQL row dropped:", err, row)
+	}
+	return nil
+}
+
+func (sqlt *sqlDatabaseTarget) writeBatch(rows [][]any) error {
+	db := sqlt.db
+	if db == nil {
+		return fmt.Errorf("database not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, sqlt.insertSql)
+	if err != nil {
+		tx.Rollback()
+		glogger.GLogger.Error(err)
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			tx.Rollback()
+			glogger.GLogger.Error(err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (sqlt *sqlDatabaseTarget) connected() bool {
+	if sqlt.db == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return sqlt.db.PingContext(ctx) == nil
+}
+
+func (sqlt *sqlDatabaseTarget) parseRows(data string) ([][]any, error) {
+	objects := []map[string]any{}
+	trimmed := strings.TrimSpace(data)
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &objects); err != nil {
+			return nil, err
+		}
+	} else {
+		object := map[string]any{}
+		if err := json.Unmarshal([]byte(trimmed), &object); err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	rows := [][]any{}
+	for _, object := range objects {
+		row := []any{}
+		for _, column := range sqlt.mainConfig.SqlDatabaseConfig.Columns {
+			field := column.Field
+			if field == "" {
+				field = column.Name
+			}
+			value, err := sqlColumnValue(column, object[field])
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			row = append(row, value)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 把JSON值转换成列类型, 缺失的字段用默认值
+func sqlColumnValue(column SqlColumnMapping, v any) (any, error) {
+	if v == nil {
+		if column.Type == "DATETIME" && column.DefaultValue == "" {
+			return time.Now(), nil
+		}
+		if column.DefaultValue == "" {
+			return nil, nil
+		}
+		v = column.DefaultValue
+	}
+	switch column.Type {
+	case "STRING":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		bytes, err := json.Marshal(v)
+		return string(bytes), err
+	case "INTEGER":
+		switch T := v.(type) {
+		case float64:
+			if T != math.Trunc(T) || math.IsInf(T, 0) {
+				return nil, fmt.Errorf("column %s expect integer, got %v", column.Name, T)
+			}
+			return int64(T), nil
+		case bool:
+			if T {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			return strconv.ParseInt(T, 10, 64)
+		}
+	case "FLOAT":
+		switch T := v.(type) {
+		case float64:
+			return T, nil
+		case string:
+			return strconv.ParseFloat(T, 64)
+		}
+	case "BOOL":
+		switch T := v.(type) {
+		case bool:
+			return T, nil
+		case float64:
+			return T != 0, nil
+		case string:
+			return strconv.ParseBool(T)
+		}
+	case "DATETIME":
+		switch T := v.(type) {
+		case float64:
+			return time.UnixMilli(int64(T)), nil
+		case string:
+			return time.Parse(time.RFC3339Nano, T)
+		}
+	}
+	return nil, fmt.Errorf("can not convert %v to %s", v, column.Type)
+}
+
+func (sqlt *sqlDatabaseTarget) quote(name string) string {
+	if sqlt.mainConfig.SqlDatabaseConfig.Driver == "mysql" {
+		return "`" + name + "`"
+	}
+	return `"` + name + `"`
+}
+
+func (sqlt *sqlDatabaseTarget) placeholder(i int) string {
+	if sqlt.mainConfig.SqlDatabaseConfig.Driver == "postgres" {
+		return "$" + strconv.Itoa(i+1)
+	}
+	return "?"
+}
+
+/*
+*
+* INSERT INTO t (a,b) VALUES (?,?)
+* 有主键的时候:
+*   sqlite/postgres: ON CONFLICT (k) DO UPDATE SET b=excluded.b
+*   mysql:           ON DUPLICATE KEY UPDATE b=VALUES(b)
+*
+ */
+func (sqlt *sqlDatabaseTarget) generateInsertSql() string {
+	config := sqlt.mainConfig.SqlDatabaseConfig
+	names := []string{}
+	values := []string{}
+	updates := []string{}
+	for i, column := range config.Columns {
+		names = append(names, sqlt.quote(column.Name))
+		values = append(values, sqlt.placeholder(i))
+		if utils.SContains(config.UpsertKeys, column.Name) {
+			continue
+		}
+		if config.Driver == "mysql" {
+			updates = append(updates, fmt.Sprintf("%s=VALUES(%s)",
+				sqlt.quote(column.Name), sqlt.quote(column.Name)))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s=excluded.%s",
+				sqlt.quote(column.Name), sqlt.quote(column.Name)))
+		}
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", sqlt.quote(config.Table),
+		strings.Join(names, ","), strings.Join(values, ","))
+	if len(config.UpsertKeys) == 0 {
+		return insert
+	}
+	if config.Driver == "mysql" {
+		if len(updates) == 0 {
+			return "INSERT IGNORE" + strings.TrimPrefix(insert, "INSERT")
+		}
+		return insert + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ",")
+	}
+	keys := []string{}
+	for _, key := range config.UpsertKeys {
+		keys = append(keys, sqlt.quote(key))
+	}
+	if len(updates) == 0 {
+		return insert + fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(keys, ","))
+	}
+	return insert + fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s",
+		strings.Join(keys, ","), strings.Join(updates, ","))
+}
+
+/*
+*
+* 生成建表语句, 思路和数据中心的 GenerateSQLiteCreateTableDDL 一样, 只是按驱动映射类型
+*
+ */
+func (sqlt *sqlDatabaseTarget) generateCreateTableDDL() string {
+	config := sqlt.mainConfig.SqlDatabaseConfig
+	columns := []string{}
+	for _, column := range config.Columns {
+		columnDefine := fmt.Sprintf("%s %s", sqlt.quote(column.Name),
+			sqlTypeMappingSchemaType(config.Driver, column.Type))
+		if literal, _ := sqlDefaultLiteral(config.Driver, column); literal != "" {
+			columnDefine += " DEFAULT " + literal
+		}
+		columns = append(columns, columnDefine)
+	}
+	if len(config.UpsertKeys) > 0 {
+		keys := []string{}
+		for _, key := range config.UpsertKeys {
+			keys = append(keys, sqlt.quote(key))
+		}
+		columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(keys, ",")))
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n)",
+		sqlt.quote(config.Table), strings.Join(columns, ",\n"))
+}
+
+/*
+*
+* 默认值按列类型生成: 数值不加引号, 布尔按驱动写, 时间没有默认值时用当前时间;
+* MySQL 的 DATETIME(3) 默认值精度必须一致, 是 CURRENT_TIMESTAMP(3)
+*
+ */
+func sqlDefaultLiteral(driver string, column SqlColumnMapping) (string, error) {
+	if column.DefaultValue == "" {
+		if column.Type != "DATETIME" {
+			return "", nil
+		}
+		if driver == "mysql" {
+			return "CURRENT_TIMESTAMP(3)", nil
+		}
+		return "CURRENT_TIMESTAMP", nil
+	}
+	switch column.Type {
+	case "INTEGER":
+		v, err := strconv.ParseInt(column.DefaultValue, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(v, 10), nil
+	case "FLOAT":
+		v, err := strconv.ParseFloat(column.DefaultValue, 64)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case "BOOL":
+		v, err := strconv.ParseBool(column.DefaultValue)
+		if err != nil {
+			return "", err
+		}
+		if driver == "sqlite3" {
+			if v {
+				return "1", nil
+			}
+			return "0", nil
+		}
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case "DATETIME":
+		if _, err := time.Parse(time.RFC3339Nano, column.DefaultValue); err != nil {
+			return "", err
+		}
+	}
+	return "'" + strings.ReplaceAll(column.DefaultValue, "'", "''") + "'", nil
+}
+
+func sqlTypeMappingSchemaType(driver, schemaType string) string {
+	switch driver {
+	case "mysql":
+		switch schemaType {
+		case "STRING":
+			return "VARCHAR(255)" // MySQL 的主键不能是 TEXT
+		case "INTEGER":
+			return "BIGINT"
+		case "FLOAT":
+			return "DOUBLE"
+		case "BOOL":
+			return "BOOLEAN"
+		case "DATETIME":
+			return "DATETIME(3)"
+		}
+	case "postgres":
+		switch schemaType {
+		case "STRING":
+			return "TEXT"
+		case "INTEGER":
+			return "BIGINT"
+		case "FLOAT":
+			return "DOUBLE PRECISION"
+		case "BOOL":
+			return "BOOLEAN"
+		case "DATETIME":
+			return "TIMESTAMPTZ"
+		}
+	default:
+		switch schemaType {
+		case "STRING":
+			return "TEXT"
+		case "INTEGER":
+			return "INTEGER"
+		case "FLOAT":
+			return "REAL"
+		case "BOOL":
+			return "BOOLEAN"
+		case "DATETIME":
+			return "DATETIME"
+		}
+	}
+	return "TEXT"
+}
+
+// 校验表里面是否包含了所有映射的列
+func (sqlt *sqlDatabaseTarget) validateSchema() error {
+	config := sqlt.mainConfig.SqlDatabaseConfig
+	var query string
+	args := []any{}
+	switch config.Driver {
+	case "mysql":
+		query = "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?"
+		args = append(args, config.Table)
+	case "postgres":
+		query = "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1"
+		args = append(args, config.Table)
+	default:
+		query = "SELECT name FROM pragma_table_info(?)"
+		args = append(args, config.Table)
+	}
+	rows, err := sqlt.db.QueryContext(sqlt.Ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	existed := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		existed[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(existed) == 0 {
+		return fmt.Errorf("table not exists: %s", config.Table)
+	}
+	for _, column := range config.Columns {
+		if !existed[strings.ToLower(column.Name)] {
+			return fmt.Errorf("column not exists: %s.%s", config.Table, column.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+func newTestSqlTarget(t *testing.T, dsn string, config map[string]any) *sqlDatabaseTarget {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	common := map[string]any{
+		"driver":          "sqlite3",
+		"dsn":             dsn,
+		"table":           "metrics",
+		"autoCreateTable": true,
+		"batchSize":       100,
+		"flushInterval":   60000,
+		"columns": []map[string]any{
+			{"name": "device", "type": "STRING"},
+			{"name": "temp", "field": "t", "type": "FLOAT"},
+			{"name": "count", "type": "INTEGER", "defaultValue": "7"},
+			{"name": "on", "type": "BOOL", "defaultValue": "true"},
+			{"name": "ts", "type": "DATETIME"},
+		},
+	}
+	for k, v := range config {
+		common[k] = v
+	}
+	sqlt := NewSqlDatabaseTarget(nil).(*sqlDatabaseTarget)
+	if err := sqlt.Init("SQL1", map[string]any{"commonConfig": common}); err != nil {
+		t.Fatal(err)
+	}
+	return sqlt
+}
+
+func startTestSqlTarget(t *testing.T, sqlt *sqlDatabaseTarget) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := sqlt.Start(typex.CCTX{Ctx: ctx, CancelCTX: cancel}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func queryTestSqlRows(t *testing.T, dsn string) []string {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT device, temp, count, "on" FROM metrics ORDER BY device`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	result := []string{}
+	for rows.Next() {
+		var device string
+		var temp float64
+		var count int64
+		var on bool
+		if err := rows.Scan(&device, &temp, &count, &on); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, fmt.Sprintf("%s|%g|%d|%s", device, temp, count,
+			map[bool]string{true: "on", false: "off"}[on]))
+	}
+	return result
+}
+
+// go test -timeout 30s -run ^Test_SqlDatabase_DDL github.com/hootrhino/rhilex/target -v -count=1
+func Test_SqlDatabase_DDL(t *testing.T) {
+	sqlt := newTestSqlTarget(t, "unused.db", map[string]any{"upsertKeys": []string{"device"}})
+	expect := "CREATE TABLE IF NOT EXISTS \"metrics\" (\n" +
+		"\"device\" TEXT,\n\"temp\" REAL,\n\"count\" INTEGER DEFAULT 7,\n\"on\" BOOLEAN DEFAULT 1,\n" +
+		"\"ts\" DATETIME DEFAULT CURRENT_TIMESTAMP,\nPRIMARY KEY (\"device\")\n)"
+	if ddl := sqlt.generateCreateTableDDL(); ddl != expect {
+		t.Fatal("unexpected sqlite ddl", ddl)
+	}
+	if insert := sqlt.generateInsertSql(); insert != `INSERT INTO "metrics" ("device","temp","count","on","ts") VALUES (?,?,?,?,?)`+
+		` ON CONFLICT ("device") DO UPDATE SET "temp"=excluded."temp","count"=excluded."count","on"=excluded."on","ts"=excluded."ts"` {
+		t.Fatal("unexpected insert", insert)
+	}
+	sqlt.mainConfig.SqlDatabaseConfig.Driver = "mysql"
+	ddl := sqlt.generateCreateTableDDL()
+	if !strings.Contains(ddl, "`ts` DATETIME(3) DEFAULT CURRENT_TIMESTAMP(3)") ||
+		!strings.Contains(ddl, "`on` BOOLEAN DEFAULT TRUE") || !strings.Contains(ddl, "`count` BIGINT DEFAULT 7") {
+		t.Fatal("unexpected mysql ddl", ddl)
+	}
+	if insert := sqlt.generateInsertSql(); !strings.HasSuffix(insert, "ON DUPLICATE KEY UPDATE `temp`=VALUES(`temp`),"+
+		"`count`=VALUES(`count`),`on`=VALUES(`on`),`ts`=VALUES(`ts`)") {
+		t.Fatal("unexpected mysql insert", insert)
+	}
+	sqlt.mainConfig.SqlDatabaseConfig.Driver = "postgres"
+	if insert := sqlt.generateInsertSql(); !strings.Contains(insert, "VALUES ($1,$2,$3,$4,$5)") {
+		t.Fatal("unexpected postgres insert", insert)
+	}
+	for _, bad := range []map[string]any{
+		{"table": "metrics; DROP TABLE x"},
+		{"driver": "oracle"},
+		{"upsertKeys": []string{"nope"}},
+		{"columns": []map[string]any{{"name": "count", "type": "INTEGER", "defaultValue": "abc"}}},
+		{"columns": []map[string]any{{"name": "on", "type": "BOOL", "defaultValue": "maybe"}}},
+		{"columns": []map[string]any{{"name": "x y", "type": "STRING"}}},
+	} {
+		config := map[string]any{
+			"driver": "sqlite3", "dsn": "unused.db", "table": "metrics",
+			"columns": []map[string]any{{"name": "device", "type": "STRING"}},
+		}
+		for k, v := range bad {
+			config[k] = v
+		}
+		if err := NewSqlDatabaseTarget(nil).Init("SQL1", map[string]any{"commonConfig": config}); err == nil {
+			t.Fatal("should fail:", bad)
+		}
+	}
+}
+
+// go test -timeout 30s -run ^Test_SqlDatabase_Write github.com/hootrhino/rhilex/target -v -count=1
+func Test_SqlDatabase_Write(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "sql_target.db")
+	sqlt := newTestSqlTarget(t, dsn, map[string]any{"upsertKeys": []string{"device"}})
+	startTestSqlTarget(t, sqlt)
+	rows, err := sqlt.parseRows(`[{"device":"d1","t":1.5,"count":2,"on":false},{"device":"d2","t":"2.5"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlt.write(rows); err != nil {
+		t.Fatal(err)
+	}
+	// 按主键更新
+	rows, _ = sqlt.parseRows(`{"device":"d1","t":3,"count":4,"on":true}`)
+	if err := sqlt.write(rows); err != nil {
+		t.Fatal(err)
+	}
+	if result := queryTestSqlRows(t, dsn); strings.Join(result, ",") != "d1|3|4|on,d2|2.5|7|on" {
+		t.Fatal("unexpected rows", result)
+	}
+	// 批次里有一行写不进去, 连接还通: 好的行照写, 坏的行丢掉, 不进离线缓存
+	if err := sqlt.write([][]any{{"d3", 1.0, int64(1), true, time.Now()}, {"d4", "x", "y", "z"}}); err != nil {
+		t.Fatal("bad row should be dropped:", err)
+	}
+	if result := queryTestSqlRows(t, dsn); len(result) != 3 || result[2] != "d3|1|1|on" {
+		t.Fatal("good rows should be written", result)
+	}
+	if _, err := sqlt.parseRows(`{"device":"d1","t":"hot"}`); err == nil {
+		t.Fatal("invalid float should fail")
+	}
+	// 整数列不能截断小数
+	if _, err := sqlt.parseRows(`{"device":"d1","count":23.7}`); err == nil {
+		t.Fatal("fractional integer should fail")
+	}
+	// 停止时缓冲区里的数据要刷进去
+	if _, err := sqlt.To(`{"device":"d5","t":5}`); err != nil {
+		t.Fatal(err)
+	}
+	sqlt.Stop()
+	if result := queryTestSqlRows(t, dsn); len(result) != 4 || result[3] != "d5|5|7|on" {
+		t.Fatal("buffered rows should be flushed on stop", result)
+	}
+	// 连接断了才返回错误, 整批交给离线缓存
+	sqlt.db, _ = sql.Open("sqlite3", dsn)
+	sqlt.db.Close()
+	if err := sqlt.write(rows); err == nil {
+		t.Fatal("closed database should fail")
+	}
+	sqlt.db = nil
+}
+
+// go test -timeout 30s -run ^Test_SqlDatabase_ValidateSchema github.com/hootrhino/rhilex/target -v -count=1
+func Test_SqlDatabase_ValidateSchema(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "sql_target.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE metrics (device TEXT, temp REAL)`); err != nil {
+		t.Fatal(err)
+	}
+	// 不自动建表, 表里少了列
+	sqlt := newTestSqlTarget(t, dsn, map[string]any{"autoCreateTable": false})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := sqlt.Start(typex.CCTX{Ctx: ctx, CancelCTX: cancel}); err == nil ||
+		!strings.Contains(err.Error(), "column not exists") {
+		t.Fatal("missing column should fail", err)
+	}
+	sqlt = newTestSqlTarget(t, dsn, map[string]any{"autoCreateTable": false, "table": "nope"})
+	if err := sqlt.Start(typex.CCTX{Ctx: ctx, CancelCTX: cancel}); err == nil ||
+		!strings.Contains(err.Error(), "table not exists") {
+		t.Fatal("missing table should fail", err)
+	}
+	sqlt = newTestSqlTarget(t, dsn, map[string]any{"autoCreateTable": false,
+		"columns": []map[string]any{{"name": "DEVICE", "type": "STRING"}, {"name": "temp", "type": "FLOAT"}}})
+	startTestSqlTarget(t, sqlt)
+	sqlt.Stop()
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+*
+* 批量写入缓冲区, 满了或者到时间了就刷出去, 刷新失败的时候原始数据交给离线缓存
+*
+ */
+type targetBatcher[T any] struct {
+	locker    sync.Mutex
+	batchSize int
+	raws      []string
+	items     []T
+	flush     func([]T) error
+	onFailed  func(raws []string)
+	stopped   chan struct{}
+}
+
+func newTargetBatcher[T any](batchSize int, flush func([]T) error,
+	onFailed func(raws []string)) *targetBatcher[T] {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &targetBatcher[T]{
+		batchSize: batchSize,
+		raws:      []string{},
+		items:     []T{},
+		flush:     flush,
+		onFailed:  onFailed,
+		stopped:   make(chan struct{}),
+	}
+}
+
+// 加入缓冲区, 满了就立即刷新
+func (b *targetBatcher[T]) Add(raw string, items []T) error {
+	b.locker.Lock()
+	b.raws = append(b.raws, raw)
+	b.items = append(b.items, items...)
+	full := len(b.items) >= b.batchSize
+	b.locker.Unlock()
+	if full {
+		return b.Flush()
+	}
+	return nil
+}
+
+// 刷新缓冲区
+func (b *targetBatcher[T]) Flush() error {
+	b.locker.Lock()
+	if len(b.items) == 0 {
+		b.locker.Unlock()
+		return nil
+	}
+	raws := b.raws
+	items := b.items
+	b.raws = []string{}
+	b.items = []T{}
+	b.locker.Unlock()
+	if err := b.flush(items); err != nil {
+		if b.onFailed != nil {
+			b.onFailed(raws)
+		}
+		return err
+	}
+	return nil
+}
+
+// 定时刷新, 直到 done 关闭; 关闭时会把剩下的刷出去
+func (b *targetBatcher[T]) Run(done <-chan struct{}, interval time.Duration) {
+	defer close(b.stopped)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			b.Flush()
+			return
+		case <-ticker.C:
+			b.Flush()
+		}
+	}
+}
+
+// 等 Run 退出, 也就是最后一次刷新完成; 只能在 Run 启动以后调用
+func (b *targetBatcher[T]) Wait() {
+	<-b.stopped
+}
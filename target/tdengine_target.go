@@ -16,13 +16,21 @@
 package target
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/interdb"
 	"github.com/hootrhino/rhilex/component/lostcache"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
@@ -40,11 +48,40 @@ type TDEngineConfig struct {
 	Username         string `json:"username" validate:"required" title:"用户"` // 用户
 	Password         string `json:"password" validate:"required" title:"密码"` // 密码
 	DbName           string `json:"dbName" validate:"required" title:"数据库名"` // 数据库名
+	Mode             string `json:"mode" title:"写入模式"`                       // SQL | SCHEMALESS_LINE | SCHEMALESS_JSON | SUPERTABLE
+	BatchSize        int    `json:"batchSize" title:"批量大小"`                  // 除了SQL模式都支持批量
+	FlushInterval    int    `json:"flushInterval" title:"刷新间隔(毫秒)"`
 	CacheOfflineData *bool  `json:"cacheOfflineData" title:"离线缓存"`
 }
 
+/*
+*
+* 超级表模式: 按照数据模型的属性自动建超级表, 每个设备一个子表
+*
+ */
+type TDEngineSuperTableConfig struct {
+	Name          string `json:"name" title:"超级表名"`
+	SchemaId      string `json:"schemaId" title:"数据模型"`        // 数据列来自数据模型的属性
+	DeviceIdField string `json:"deviceIdField" title:"设备ID字段"` // 子表名: <超级表名>_<设备ID>
+}
+
 type TDEngineMainConfig struct {
-	TDEngineConfig TDEngineConfig `json:"commonConfig" validate:"required"`
+	TDEngineConfig TDEngineConfig           `json:"commonConfig" validate:"required"`
+	PointMapping   TsdbPointMapping         `json:"pointMapping"`
+	SuperTable     TDEngineSuperTableConfig `json:"superTable"`
+}
+
+const (
+	TDENGINE_MODE_SQL             = "SQL"             // 规则直接写SQL
+	TDENGINE_MODE_SCHEMALESS_LINE = "SCHEMALESS_LINE" // InfluxDB 行协议
+	TDENGINE_MODE_SCHEMALESS_JSON = "SCHEMALESS_JSON" // OpenTSDB JSON
+	TDENGINE_MODE_SUPERTABLE      = "SUPERTABLE"      // 超级表自动建表
+)
+
+// 超级表的数据列
+type tdSuperTableColumn struct {
+	Name string
+	Type string
 }
 
 /*
@@ -57,6 +94,8 @@ type tdEngineTarget struct {
 	typex.XStatus
 	client     http.Client
 	mainConfig TDEngineMainConfig
+	batcher    *targetBatcher[TsdbPoint]
+	columns    []tdSuperTableColumn
 	status     typex.SourceState
 }
 type tdHttpResult struct {
@@ -75,8 +114,21 @@ func NewTdEngineTarget(e typex.Rhilex) typex.XTarget {
 				Username:         "taos",
 				Password:         "root",
 				DbName:           "rhilex",
+				Mode:             TDENGINE_MODE_SQL,
+				BatchSize:        100,
+				FlushInterval:    1000,
 				CacheOfflineData: new(bool),
 			},
+			PointMapping: TsdbPointMapping{
+				Measurement: "rhilex",
+				Tags:        map[string]string{},
+				TagKeys:     []string{},
+				FieldKeys:   []string{},
+				Precision:   "ms",
+			},
+			SuperTable: TDEngineSuperTableConfig{
+				DeviceIdField: "deviceId",
+			},
 		},
 	}
 	td.RuleEngine = e
@@ -111,6 +163,38 @@ func (td *tdEngineTarget) Init(outEndId string, configMap map[string]any) error
 	if err := utils.BindSourceConfig(configMap, &td.mainConfig); err != nil {
 		return err
 	}
+	switch td.mode() {
+	case TDENGINE_MODE_SQL, TDENGINE_MODE_SCHEMALESS_LINE, TDENGINE_MODE_SCHEMALESS_JSON:
+	case TDENGINE_MODE_SUPERTABLE:
+		superTable := td.mainConfig.SuperTable
+		if !tdIdentifierRegex.MatchString(superTable.Name) {
+			return fmt.Errorf("invalid super table name: %s", superTable.Name)
+		}
+		if superTable.SchemaId == "" || superTable.DeviceIdField == "" {
+			return fmt.Errorf("super table schemaId and deviceIdField are required")
+		}
+		for _, k := range append(td.mainConfig.PointMapping.TagKeys,
+			sortedKeys(td.mainConfig.PointMapping.Tags)...) {
+			if !tdIdentifierRegex.MatchString(k) {
+				return fmt.Errorf("invalid tag name: %s", k)
+			}
+		}
+		// TDengine 的标签名不区分大小写, device_id 已经被设备ID占了
+		tagNames := map[string]bool{"device_id": true}
+		for _, k := range td.tagNames() {
+			if tagNames[strings.ToLower(k)] {
+				return fmt.Errorf("duplicated tag name: %s", k)
+			}
+			tagNames[strings.ToLower(k)] = true
+		}
+		// 设备ID作为标签带进来, 用来生成子表名
+		if !utils.SContains(td.mainConfig.PointMapping.TagKeys, superTable.DeviceIdField) {
+			td.mainConfig.PointMapping.TagKeys = append(td.mainConfig.PointMapping.TagKeys,
+				superTable.DeviceIdField)
+		}
+	default:
+		return fmt.Errorf("unsupported tdengine mode: %s", td.mainConfig.TDEngineConfig.Mode)
+	}
 	if td.testStatus() {
 		return nil
 	}
@@ -122,6 +206,18 @@ func (td *tdEngineTarget) Start(cctx typex.CCTX) error {
 	td.Ctx = cctx.Ctx
 	td.CancelCTX = cctx.CancelCTX
 	//
+	if td.mode() == TDENGINE_MODE_SUPERTABLE {
+		if err := td.createSuperTable(); err != nil {
+			glogger.GLogger.Error(err)
+			return err
+		}
+	}
+	if td.mode() != TDENGINE_MODE_SQL {
+		td.batcher = newTargetBatcher[TsdbPoint](td.mainConfig.TDEngineConfig.BatchSize,
+			td.write, td.cacheOfflineData)
+		go td.batcher.Run(td.Ctx.Done(),
+			time.Duration(td.mainConfig.TDEngineConfig.FlushInterval)*time.Millisecond)
+	}
 	td.status = typex.SOURCE_UP
 	// 补发数据
 	if *td.mainConfig.TDEngineConfig.CacheOfflineData {
@@ -160,6 +256,11 @@ func (td *tdEngineTarget) Stop() {
 	if td.CancelCTX != nil {
 		td.CancelCTX()
 	}
+	// 等缓冲区最后一次刷完, 重启的时候不会和新的缓冲区抢着写
+	if td.batcher != nil {
+		td.batcher.Wait()
+		td.batcher = nil
+	}
 }
 
 func post(client http.Client,
@@ -217,11 +318,29 @@ func execQuery(client http.Client, username string, password string, sql string,
 * SQL: INSERT INTO meter VALUES (NOW, %v, %v);
 * 数据到达后写入Tdengine, 这里对数据有严格约束，必须是以,分割的字符串
 * 比如: 10.22,220.12,123,......
+* 其他模式下数据是JSON表, 按照 pointMapping 转换以后批量写入
 *
  */
 func (td *tdEngineTarget) To(data any) (any, error) {
 	switch T := data.(type) {
 	case string:
+		if td.mode() != TDENGINE_MODE_SQL {
+			points, err := td.mainConfig.PointMapping.ParsePoints(T)
+			if err != nil {
+				glogger.GLogger.Error(err)
+				return 0, err
+			}
+			// 超级表的点先检查, 坏数据不进缓冲区, 免得整批失败以后进离线缓存反复重放
+			if td.mode() == TDENGINE_MODE_SUPERTABLE {
+				for _, point := range points {
+					if _, err := td.superTableValues(point); err != nil {
+						glogger.GLogger.Error(err)
+						return 0, err
+					}
+				}
+			}
+			return len(points), td.batcher.Add(T, points)
+		}
 		{
 			errQuery := execQuery(td.client, td.mainConfig.TDEngineConfig.Username,
 				td.mainConfig.TDEngineConfig.Password, T, td.url())
@@ -239,3 +358,316 @@ func (td *tdEngineTarget) To(data any) (any, error) {
 	}
 	return 0, nil
 }
+
+func (td *tdEngineTarget) mode() string {
+	if td.mainConfig.TDEngineConfig.Mode == "" {
+		return TDENGINE_MODE_SQL
+	}
+	return td.mainConfig.TDEngineConfig.Mode
+}
+
+func (td *tdEngineTarget) cacheOfflineData(raws []string) {
+	if !*td.mainConfig.TDEngineConfig.CacheOfflineData {
+		return
+	}
+	for _, raw := range raws {
+		lostcache.SaveLostCacheData(td.PointId, lostcache.CacheDataDto{
+			TargetId: td.PointId,
+			Data:     raw,
+		})
+	}
+}
+
+func (td *tdEngineTarget) write(points []TsdbPoint) error {
+	switch td.mode() {
+	case TDENGINE_MODE_SCHEMALESS_LINE:
+		precision := td.mainConfig.PointMapping.Precision
+		if precision == "" {
+			precision = "ms"
+		}
+		body := EncodeLineProtocol(points, precision)
+		if body == "" { // 没有能写的字段
+			return nil
+		}
+		query := url.Values{}
+		query.Set("db", td.mainConfig.TDEngineConfig.DbName)
+		query.Set("precision", precision)
+		return td.schemaless(fmt.Sprintf("http://%s:%v/influxdb/v1/write?%s",
+			td.mainConfig.TDEngineConfig.Fqdn, td.mainConfig.TDEngineConfig.Port,
+			query.Encode()), body)
+	case TDENGINE_MODE_SCHEMALESS_JSON:
+		body, err := json.Marshal(encodeOpenTsdbJson(points))
+		if err != nil {
+			return err
+		}
+		return td.schemaless(fmt.Sprintf("http://%s:%v/opentsdb/v1/put/json/%s",
+			td.mainConfig.TDEngineConfig.Fqdn, td.mainConfig.TDEngineConfig.Port,
+			td.mainConfig.TDEngineConfig.DbName), string(body))
+	case TDENGINE_MODE_SUPERTABLE:
+		sql, err := td.superTableInsertSql(points)
+		if err != nil {
+			return err
+		}
+		return execQuery(td.client, td.mainConfig.TDEngineConfig.Username,
+			td.mainConfig.TDEngineConfig.Password, sql, td.url())
+	}
+	return fmt.Errorf("unsupported tdengine mode: %s", td.mode())
+}
+
+/*
+*
+* taosAdapter 的无模式写入接口, 成功返回 204
+*
+ */
+func (td *tdEngineTarget) schemaless(url string, body string) error {
+	glogger.GLogger.Debug("TDengine schemaless:", body)
+	request, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth(td.mainConfig.TDEngineConfig.Username, td.mainConfig.TDEngineConfig.Password)
+	response, err := td.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		bytes, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("tdengine schemaless error: %d, %s", response.StatusCode, string(bytes))
+	}
+	return nil
+}
+
+type openTsdbJsonPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     any               `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// 每个字段一个 metric: <measurement>_<field>
+func encodeOpenTsdbJson(points []TsdbPoint) []openTsdbJsonPoint {
+	jsonPoints := []openTsdbJsonPoint{}
+	for _, point := range points {
+		for _, field := range sortedKeys(point.Fields) {
+			jsonPoints = append(jsonPoints, openTsdbJsonPoint{
+				Metric:    point.Measurement + "_" + field,
+				Timestamp: point.Timestamp.UnixMilli(),
+				Value:     point.Fields[field],
+				Tags:      point.Tags,
+			})
+		}
+	}
+	return jsonPoints
+}
+
+var tdIdentifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+/*
+*
+* CREATE STABLE IF NOT EXISTS meter (ts TIMESTAMP, voltage DOUBLE, ...) TAGS (device_id NCHAR(64), ...)
+*
+ */
+func (td *tdEngineTarget) createSuperTable() error {
+	MIotProperties := []model.MIotProperty{}
+	if err := interdb.InterDb().Model(model.MIotProperty{}).
+		Where("schema_id=?", td.mainConfig.SuperTable.SchemaId).
+		Find(&MIotProperties).Error; err != nil {
+		return err
+	}
+	sql, err := td.superTableDDL(MIotProperties)
+	if err != nil {
+		return err
+	}
+	return execQuery(td.client, td.mainConfig.TDEngineConfig.Username,
+		td.mainConfig.TDEngineConfig.Password, sql, td.url())
+}
+
+// 按数据模型的属性生成建表语句, 顺便记下数据列
+func (td *tdEngineTarget) superTableDDL(MIotProperties []model.MIotProperty) (string, error) {
+	td.columns = []tdSuperTableColumn{}
+	columns := []string{"ts TIMESTAMP"}
+	for _, MIotProperty := range MIotProperties {
+		if MIotProperty.Name == "create_at" || MIotProperty.Name == "id" ||
+			!tdIdentifierRegex.MatchString(MIotProperty.Name) {
+			continue
+		}
+		column := tdSuperTableColumn{Name: MIotProperty.Name}
+		switch MIotProperty.Type {
+		case "INTEGER":
+			column.Type = "BIGINT"
+		case "FLOAT":
+			column.Type = "DOUBLE"
+		case "BOOL":
+			column.Type = "BOOL"
+		default:
+			column.Type = "NCHAR(255)"
+		}
+		td.columns = append(td.columns, column)
+		columns = append(columns, column.Name+" "+column.Type)
+	}
+	if len(td.columns) == 0 {
+		return "", fmt.Errorf("data schema has no property: %s", td.mainConfig.SuperTable.SchemaId)
+	}
+	tags := []string{"device_id NCHAR(64)"}
+	for _, k := range td.tagNames() {
+		tags = append(tags, k+" NCHAR(64)")
+	}
+	return fmt.Sprintf("CREATE STABLE IF NOT EXISTS %s (%s) TAGS (%s);",
+		td.mainConfig.SuperTable.Name, strings.Join(columns, ", "), strings.Join(tags, ", ")), nil
+}
+
+// 除了设备ID以外的标签, 顺序固定
+func (td *tdEngineTarget) tagNames() []string {
+	names := []string{}
+	for _, k := range td.mainConfig.PointMapping.TagKeys {
+		if k != td.mainConfig.SuperTable.DeviceIdField && !utils.SContains(names, k) {
+			names = append(names, k)
+		}
+	}
+	for _, k := range sortedKeys(td.mainConfig.PointMapping.Tags) {
+		if !utils.SContains(names, k) {
+			names = append(names, k)
+		}
+	}
+	return names
+}
+
+/*
+*
+* 一条语句写多个子表, 子表不存在的时候 TDengine 会按 USING 自动创建:
+* INSERT INTO meter_d1 USING meter TAGS ('d1') VALUES (1700000000000, 220.1) meter_d2 USING ...
+*
+ */
+func (td *tdEngineTarget) superTableInsertSql(points []TsdbPoint) (string, error) {
+	sb := strings.Builder{}
+	sb.WriteString("INSERT INTO")
+	for _, point := range points {
+		values, err := td.superTableValues(point)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(" ")
+		sb.WriteString(values)
+	}
+	sb.WriteString(";")
+	return sb.String(), nil
+}
+
+// 一个点写成 "子表 USING 超级表 TAGS (...) VALUES (...)"; 没有设备ID或者值和列类型对不上的报错
+func (td *tdEngineTarget) superTableValues(point TsdbPoint) (string, error) {
+	superTable := td.mainConfig.SuperTable
+	deviceId := point.Tags[superTable.DeviceIdField]
+	if deviceId == "" {
+		return "", fmt.Errorf("point missing device id field: %s", superTable.DeviceIdField)
+	}
+	tags := []string{tdSqlValue(deviceId)}
+	for _, k := range td.tagNames() {
+		tags = append(tags, tdSqlValue(point.Tags[k]))
+	}
+	values := []string{strconv.FormatInt(point.Timestamp.UnixMilli(), 10)}
+	for _, column := range td.columns {
+		value, err := tdColumnValue(column, point.Fields[column.Name])
+		if err != nil {
+			return "", err
+		}
+		values = append(values, value)
+	}
+	return fmt.Sprintf("%s USING %s TAGS (%s) VALUES (%s)",
+		tdSubTableName(superTable.Name, deviceId), superTable.Name,
+		strings.Join(tags, ", "), strings.Join(values, ", ")), nil
+}
+
+// 按列类型转换, 转不了的报错, 不然 TDengine 整条语句都会失败
+func tdColumnValue(column tdSuperTableColumn, v any) (string, error) {
+	if v == nil {
+		return "NULL", nil
+	}
+	switch column.Type {
+	case "BIGINT":
+		switch T := v.(type) {
+		case float64:
+			if T == math.Trunc(T) && !math.IsInf(T, 0) {
+				return strconv.FormatInt(int64(T), 10), nil
+			}
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(T), 10, 64); err == nil {
+				return strconv.FormatInt(i, 10), nil
+			}
+		}
+	case "DOUBLE":
+		switch T := v.(type) {
+		case float64:
+			if !math.IsNaN(T) && !math.IsInf(T, 0) {
+				return strconv.FormatFloat(T, 'f', -1, 64), nil
+			}
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(T), 64); err == nil &&
+				!math.IsNaN(f) && !math.IsInf(f, 0) {
+				return strconv.FormatFloat(f, 'f', -1, 64), nil
+			}
+		}
+	case "BOOL":
+		switch T := v.(type) {
+		case bool:
+			return strconv.FormatBool(T), nil
+		case float64:
+			return strconv.FormatBool(T != 0), nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(T)); err == nil {
+				return strconv.FormatBool(b), nil
+			}
+		}
+	default:
+		return tdSqlValue(v), nil
+	}
+	return "", fmt.Errorf("column %s expect %s, got %v", column.Name, column.Type, v)
+}
+
+/*
+*
+* 子表名只能包含字母数字下划线, 而且不区分大小写; 设备ID要改写的时候(有大写或者特殊字符)
+* 后面加上原始设备ID的哈希, 免得 Dev-1 和 dev_1 写进同一个子表
+*
+ */
+func tdSubTableName(superTable, deviceId string) string {
+	sb := strings.Builder{}
+	sb.WriteString(strings.ToLower(superTable))
+	sb.WriteByte('_')
+	rewritten := false
+	for _, c := range deviceId {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9'):
+			sb.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			sb.WriteRune(c - 'A' + 'a')
+			rewritten = true
+		default:
+			sb.WriteByte('_')
+			rewritten = true
+		}
+	}
+	if rewritten {
+		hash := fnv.New32a()
+		hash.Write([]byte(deviceId))
+		sb.WriteString(fmt.Sprintf("_%08x", hash.Sum32()))
+	}
+	return sb.String()
+}
+
+func tdSqlValue(v any) string {
+	switch T := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		return strconv.FormatBool(T)
+	case float64:
+		return strconv.FormatFloat(T, 'f', -1, 64)
+	case string:
+		// TDengine 的字符串支持反斜杠转义, 先转义反斜杠再转义引号
+		return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(T) + "'"
+	default:
+		bytes, _ := json.Marshal(T)
+		return tdSqlValue(string(bytes))
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/glogger"
+)
+
+// 假的 TDengine REST 接口, 记下收到的 SQL
+type fakeTdEngine struct {
+	*httptest.Server
+	locker sync.Mutex
+	sqls   []string
+	reply  string
+}
+
+func newFakeTdEngine(t *testing.T) *fakeTdEngine {
+	fake := &fakeTdEngine{reply: `{"status":"succ"}`}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fake.locker.Lock()
+		defer fake.locker.Unlock()
+		fake.sqls = append(fake.sqls, string(body))
+		w.Write([]byte(fake.reply))
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func newTestSuperTableTarget(t *testing.T, fake *fakeTdEngine, pointMapping map[string]any) (*tdEngineTarget, error) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	u, _ := url.Parse(fake.URL)
+	port, _ := strconv.Atoi(u.Port())
+	td := NewTdEngineTarget(nil).(*tdEngineTarget)
+	return td, td.Init("TD1", map[string]any{
+		"commonConfig": map[string]any{
+			"fqdn": u.Hostname(), "port": port, "username": "root", "password": "taosdata",
+			"dbName": "rhilex", "mode": TDENGINE_MODE_SUPERTABLE,
+		},
+		"pointMapping": pointMapping,
+		"superTable":   map[string]any{"name": "meter", "schemaId": "SCHEMA1", "deviceIdField": "deviceId"},
+	})
+}
+
+// go test -timeout 30s -run ^Test_TdEngine_SuperTable github.com/hootrhino/rhilex/target -v -count=1
+func Test_TdEngine_SuperTable(t *testing.T) {
+	fake := newFakeTdEngine(t)
+	td, err := newTestSuperTableTarget(t, fake, map[string]any{
+		"measurement": "meter", "timeField": "ts", "precision": "ms",
+		"tagKeys": []string{"location"}, "tags": map[string]string{"site": "f1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// createSuperTable: 数据列来自模型属性, id 和非法列名跳过
+	ddl, err := td.superTableDDL([]model.MIotProperty{
+		{Name: "id", Type: "INTEGER"},
+		{Name: "voltage", Type: "FLOAT"},
+		{Name: "count", Type: "INTEGER"},
+		{Name: "on", Type: "BOOL"},
+		{Name: "label", Type: "STRING"},
+		{Name: "bad-name", Type: "FLOAT"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "CREATE STABLE IF NOT EXISTS meter (ts TIMESTAMP, voltage DOUBLE, count BIGINT, on BOOL, label NCHAR(255)) " +
+		"TAGS (device_id NCHAR(64), location NCHAR(64), site NCHAR(64));"
+	if ddl != expect {
+		t.Fatalf("expect %s, got %s", expect, ddl)
+	}
+	if err := execQuery(td.client, "root", "taosdata", ddl, td.url()); err != nil {
+		t.Fatal(err)
+	}
+	if last := fake.sqls[len(fake.sqls)-1]; last != expect {
+		t.Fatal("unexpected sql sent", last)
+	}
+	fake.reply = `{"status":"error","code":534,"desc":"Syntax error in SQL"}`
+	if err := execQuery(td.client, "root", "taosdata", ddl, td.url()); err == nil {
+		t.Fatal("tdengine error should fail")
+	}
+	if _, err := td.superTableDDL([]model.MIotProperty{{Name: "create_at", Type: "STRING"}}); err == nil {
+		t.Fatal("schema without property should fail")
+	}
+	// superTableInsertSql: 反斜杠和单引号都要转义, 不能提前结束字符串
+	td.superTableDDL([]model.MIotProperty{{Name: "voltage", Type: "FLOAT"}, {Name: "label", Type: "STRING"}})
+	points, err := td.mainConfig.PointMapping.ParsePoints(`[` +
+		`{"deviceId":"d1","location":"a\\","ts":1700000000000,"voltage":220.1,"label":"x\\'); DROP TABLE meter; --"},` +
+		`{"deviceId":"Dev-1","location":"b","ts":1700000001000,"voltage":221}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert, err := td.superTableInsertSql(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = `INSERT INTO` +
+		` meter_d1 USING meter TAGS ('d1', 'a\\', 'f1') VALUES (1700000000000, 220.1, 'x\\\'); DROP TABLE meter; --')` +
+		` ` + tdSubTableName("meter", "Dev-1") + ` USING meter TAGS ('Dev-1', 'b', 'f1') VALUES (1700000001000, 221, NULL);`
+	if insert != expect {
+		t.Fatalf("expect %s, got %s", expect, insert)
+	}
+	// 没有设备ID的点不能写进 meter_ 子表; BIGINT 列不能写小数
+	td.superTableDDL([]model.MIotProperty{{Name: "count", Type: "INTEGER"}, {Name: "on", Type: "BOOL"}})
+	for _, bad := range []string{
+		`{"location":"a","ts":1700000000000,"count":1}`,
+		`{"deviceId":"","location":"a","ts":1700000000000,"count":1}`,
+		`{"deviceId":"d1","location":"a","ts":1700000000000,"count":23.7}`,
+		`{"deviceId":"d1","location":"a","ts":1700000000000,"count":"x"}`,
+		`{"deviceId":"d1","location":"a","ts":1700000000000,"on":"maybe"}`,
+	} {
+		points, err := td.mainConfig.PointMapping.ParsePoints(bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := td.superTableInsertSql(points); err == nil {
+			t.Fatal("should fail:", bad)
+		}
+	}
+	points, _ = td.mainConfig.PointMapping.ParsePoints(
+		`{"deviceId":"d1","location":"a","ts":1700000000000,"count":"24","on":1}`)
+	if insert, err := td.superTableInsertSql(points); err != nil ||
+		insert != `INSERT INTO meter_d1 USING meter TAGS ('d1', 'a', 'f1') VALUES (1700000000000, 24, true);` {
+		t.Fatal("unexpected insert", insert, err)
+	}
+}
+
+// go test -timeout 30s -run ^Test_TdEngine_StopFlush github.com/hootrhino/rhilex/target -v -count=1
+func Test_TdEngine_StopFlush(t *testing.T) {
+	fake := newFakeTdEngine(t)
+	td, err := newTestSuperTableTarget(t, fake, map[string]any{"measurement": "meter", "timeField": "ts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.superTableDDL([]model.MIotProperty{{Name: "voltage", Type: "FLOAT"}})
+	sent := len(fake.sqls)
+	td.Ctx, td.CancelCTX = context.WithCancel(context.Background())
+	td.batcher = newTargetBatcher[TsdbPoint](100, td.write, nil)
+	go td.batcher.Run(td.Ctx.Done(), time.Hour)
+	points, _ := td.mainConfig.PointMapping.ParsePoints(`{"deviceId":"d1","ts":1700000000000,"voltage":1}`)
+	td.batcher.Add("", points)
+	// Stop 返回的时候缓冲区已经刷完
+	td.Stop()
+	fake.locker.Lock()
+	defer fake.locker.Unlock()
+	if len(fake.sqls) != sent+1 || td.batcher != nil {
+		t.Fatal("batch should be flushed before stop returns", fake.sqls)
+	}
+}
+
+// go test -timeout 30s -run ^Test_TdEngine_SubTableName github.com/hootrhino/rhilex/target -v -count=1
+func Test_TdEngine_SubTableName(t *testing.T) {
+	if name := tdSubTableName("meter", "d_1"); name != "meter_d_1" {
+		t.Fatal("unexpected name", name)
+	}
+	names := map[string]bool{}
+	for _, deviceId := range []string{"dev_1", "Dev_1", "DEV_1", "dev-1", "dev.1"} {
+		name := tdSubTableName("Meter", deviceId)
+		if !tdIdentifierRegex.MatchString(name) || name != tdSubTableName("Meter", deviceId) {
+			t.Fatal("invalid name", deviceId, name)
+		}
+		if names[name] {
+			t.Fatal("duplicated sub table name", deviceId, name)
+		}
+		names[name] = true
+	}
+}
+
+// go test -timeout 30s -run ^Test_TdEngine_TagNames github.com/hootrhino/rhilex/target -v -count=1
+func Test_TdEngine_TagNames(t *testing.T) {
+	fake := newFakeTdEngine(t)
+	for _, bad := range []map[string]any{
+		{"measurement": "meter", "tags": map[string]string{"device_id": "x"}},
+		{"measurement": "meter", "tagKeys": []string{"DEVICE_ID"}},
+		{"measurement": "meter", "tagKeys": []string{"Site"}, "tags": map[string]string{"site": "f1"}},
+		{"measurement": "meter", "tagKeys": []string{"bad-tag"}},
+	} {
+		if _, err := newTestSuperTableTarget(t, fake, bad); err == nil {
+			t.Fatal("should fail:", bad)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+*
+* 时序数据库通用的字段映射配置, 规则传过来的数据可以是一个JSON对象, 也可以是对象数组:
+* {"device":"d1","temp":23.5} 或者 [{"device":"d1","temp":23.5},{"device":"d2","temp":24}]
+*
+ */
+type TsdbPointMapping struct {
+	Measurement      string            `json:"measurement" validate:"required" title:"测量名"`
+	MeasurementField string            `json:"measurementField" title:"测量名字段"` // 如果存在, 优先用该字段的值作为测量名
+	TimeField        string            `json:"timeField" title:"时间字段"`         // 时间字段, 单位和Precision一致; 为空则用当前时间
+	Tags             map[string]string `json:"tags" title:"静态标签"`
+	TagKeys          []string          `json:"tagKeys" title:"标签字段"`
+	FieldKeys        []string          `json:"fieldKeys" title:"值字段"`  // 为空则剩下的字段全部作为值
+	Precision        string            `json:"precision" title:"时间精度"` // ns|us|ms|s
+}
+
+/*
+*
+* 一个时序点
+*
+ */
+type TsdbPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]any
+	Timestamp   time.Time
+}
+
+// 解析规则传过来的JSON表
+func (m TsdbPointMapping) ParsePoints(data string) ([]TsdbPoint, error) {
+	rows := []map[string]any{}
+	trimmed := strings.TrimSpace(data)
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &rows); err != nil {
+			return nil, err
+		}
+	} else {
+		row := map[string]any{}
+		if err := json.Unmarshal([]byte(trimmed), &row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	points := []TsdbPoint{}
+	for _, row := range rows {
+		point, err := m.toPoint(row)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+func (m TsdbPointMapping) toPoint(row map[string]any) (TsdbPoint, error) {
+	point := TsdbPoint{
+		Measurement: m.Measurement,
+		Tags:        map[string]string{},
+		Fields:      map[string]any{},
+		Timestamp:   time.Now(),
+	}
+	if m.MeasurementField != "" {
+		if v, ok := row[m.MeasurementField]; ok {
+			point.Measurement = fmt.Sprintf("%v", v)
+		}
+	}
+	if point.Measurement == "" {
+		return point, fmt.Errorf("measurement is empty")
+	}
+	if m.TimeField != "" {
+		if v, ok := row[m.TimeField]; ok {
+			ts, err := toTimestamp(v, m.Precision)
+			if err != nil {
+				return point, err
+			}
+			point.Timestamp = ts
+		}
+	}
+	for k, v := range m.Tags {
+		point.Tags[k] = v
+	}
+	isTag := map[string]bool{}
+	for _, k := range m.TagKeys {
+		isTag[k] = true
+		if v, ok := row[k]; ok && v != nil {
+			point.Tags[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	if len(m.FieldKeys) > 0 {
+		for _, k := range m.FieldKeys {
+			if v, ok := row[k]; ok && v != nil {
+				point.Fields[k] = v
+			}
+		}
+	} else {
+		for k, v := range row {
+			if isTag[k] || v == nil || k == m.TimeField || k == m.MeasurementField {
+				continue
+			}
+			point.Fields[k] = v
+		}
+	}
+	if len(point.Fields) == 0 {
+		return point, fmt.Errorf("point has no field")
+	}
+	return point, nil
+}
+
+func toTimestamp(v any, precision string) (time.Time, error) {
+	var n int64
+	switch T := v.(type) {
+	case float64:
+		n = int64(T)
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, T); err == nil {
+			return t, nil
+		}
+		i, err := strconv.ParseInt(T, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp: %v", T)
+		}
+		n = i
+	default:
+		return time.Time{}, fmt.Errorf("invalid timestamp: %v", T)
+	}
+	switch precision {
+	case "ns":
+		return time.Unix(0, n), nil
+	case "us":
+		return time.UnixMicro(n), nil
+	case "s":
+		return time.Unix(n, 0), nil
+	default:
+		return time.UnixMilli(n), nil
+	}
+}
+
+// 按照精度截断时间戳
+func timestampOf(t time.Time, precision string) int64 {
+	switch precision {
+	case "ns":
+		return t.UnixNano()
+	case "us":
+		return t.UnixMicro()
+	case "s":
+		return t.Unix()
+	default:
+		return t.UnixMilli()
+	}
+}
+
+/*
+*
+* InfluxDB 行协议:
+* measurement,tag1=v1,tag2=v2 field1=1.0,field2="str",field3=true 1700000000000
+*
+ */
+func EncodeLineProtocol(points []TsdbPoint, precision string) string {
+	sb := strings.Builder{}
+	for _, point := range points {
+		fields := []string{}
+		for _, k := range sortedKeys(point.Fields) {
+			if value, ok := lineProtocolFieldValue(point.Fields[k]); ok {
+				fields = append(fields, escapeLineProtocol(k, ",= ")+"="+value)
+			}
+		}
+		// 一个字段都没有的行不合法, 整行丢掉
+		if len(fields) == 0 {
+			continue
+		}
+		sb.WriteString(escapeLineProtocol(point.Measurement, ", "))
+		for _, k := range sortedKeys(point.Tags) {
+			if point.Tags[k] == "" {
+				continue
+			}
+			sb.WriteByte(',')
+			sb.WriteString(escapeLineProtocol(k, ",= "))
+			sb.WriteByte('=')
+			sb.WriteString(escapeLineProtocol(point.Tags[k], ",= "))
+		}
+		sb.WriteByte(' ')
+		sb.WriteString(strings.Join(fields, ","))
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.FormatInt(timestampOf(point.Timestamp, precision), 10))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func lineProtocolFieldValue(v any) (string, bool) {
+	switch T := v.(type) {
+	case bool:
+		return strconv.FormatBool(T), true
+	case int:
+		return strconv.Itoa(T) + "i", true
+	case int64:
+		return strconv.FormatInt(T, 10) + "i", true
+	case float64:
+		if math.IsNaN(T) || math.IsInf(T, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(T, 'f', -1, 64), true
+	case float32:
+		if math.IsNaN(float64(T)) || math.IsInf(float64(T), 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(float64(T), 'f', -1, 32), true
+	case string:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(T) + `"`, true
+	default:
+		bytes, err := json.Marshal(T)
+		if err != nil {
+			return "", false
+		}
+		return lineProtocolFieldValue(string(bytes))
+	}
+}
+
+func escapeLineProtocol(s string, chars string) string {
+	sb := strings.Builder{}
+	for _, c := range s {
+		if c == '\n' {
+			sb.WriteString(`\n`)
+			continue
+		}
+		if strings.ContainsRune(chars, c) || c == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,211 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func Test_EncodeLineProtocol(t *testing.T) {
+	mapping := TsdbPointMapping{
+		Measurement: "modbus",
+		TimeField:   "ts",
+		Tags:        map[string]string{"gateway": "rhilex 01"},
+		TagKeys:     []string{"device"},
+		Precision:   "ms",
+	}
+	points, err := mapping.ParsePoints(`[{"device":"d1","ts":1700000000000,"temp":23.5,"online":true,"name":"a\"b"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := EncodeLineProtocol(points, mapping.Precision)
+	expect := `modbus,device=d1,gateway=rhilex\ 01 name="a\"b",online=true,temp=23.5 1700000000000` + "\n"
+	if line != expect {
+		t.Fatalf("expect %s, got %s", expect, line)
+	}
+	// 字段全被过滤掉的点整行跳过, float32 的 NaN/Inf 也过滤
+	line = EncodeLineProtocol([]TsdbPoint{
+		{Measurement: "m", Fields: map[string]any{"a": math.NaN(), "b": float32(math.Inf(1))}, Timestamp: time.UnixMilli(1)},
+		{Measurement: "m", Fields: map[string]any{"a": float32(math.NaN()), "b": float32(1.5)}, Timestamp: time.UnixMilli(2)},
+	}, "ms")
+	if line != "m b=1.5 2\n" {
+		t.Fatal("unexpected line", line)
+	}
+	series := decodeRemoteWriteRequest(t, EncodeRemoteWriteRequest(points))
+	// name 是字符串, 不是样本
+	expectSeries := []remoteWriteSeries{
+		{
+			Labels:    map[string]string{"__name__": "modbus_online", "device": "d1", "gateway": "rhilex 01"},
+			Value:     1,
+			Timestamp: 1700000000000,
+		},
+		{
+			Labels:    map[string]string{"__name__": "modbus_temp", "device": "d1", "gateway": "rhilex 01"},
+			Value:     23.5,
+			Timestamp: 1700000000000,
+		},
+	}
+	if !reflect.DeepEqual(series, expectSeries) {
+		t.Fatalf("expect %v, got %v", expectSeries, series)
+	}
+}
+
+type remoteWriteSeries struct {
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64
+}
+
+// 按 prometheus.WriteRequest 解码, 每条序列只有一个样本; 顺带检查标签是否按名字排序
+func decodeRemoteWriteRequest(t *testing.T, b []byte) []remoteWriteSeries {
+	consume := func(b []byte) (protowire.Number, protowire.Type, []byte, uint64, []byte) {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			return num, typ, v, 0, b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			return num, typ, nil, v, b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			return num, typ, nil, v, b[n:]
+		}
+		t.Fatal("unexpected wire type", typ)
+		return 0, 0, nil, 0, nil
+	}
+	result := []remoteWriteSeries{}
+	for len(b) > 0 {
+		num, _, seriesBytes, _, rest := consume(b)
+		b = rest
+		if num != 1 {
+			t.Fatal("unexpected WriteRequest field", num)
+		}
+		series := remoteWriteSeries{Labels: map[string]string{}}
+		last := ""
+		for len(seriesBytes) > 0 {
+			num, _, v, _, rest := consume(seriesBytes)
+			seriesBytes = rest
+			switch num {
+			case 1:
+				name, value := "", ""
+				for len(v) > 0 {
+					num, _, s, _, rest := consume(v)
+					v = rest
+					if num == 1 {
+						name = string(s)
+					} else {
+						value = string(s)
+					}
+				}
+				if name <= last {
+					t.Fatal("labels not sorted", last, name)
+				}
+				last = name
+				series.Labels[name] = value
+			case 2:
+				for len(v) > 0 {
+					num, _, _, x, rest := consume(v)
+					v = rest
+					if num == 1 {
+						series.Value = math.Float64frombits(x)
+					} else {
+						series.Timestamp = int64(x)
+					}
+				}
+			}
+		}
+		result = append(result, series)
+	}
+	return result
+}
+
+// go test -timeout 30s -run ^Test_PrometheusRemoteWrite github.com/hootrhino/rhilex/target -v -count=1
+func Test_PrometheusRemoteWrite(t *testing.T) {
+	glogger.StartGLogger(glogger.LogConfig{AppID: "rhilex", LogLevel: "fatal", EnableConsole: true})
+	bodies := [][]byte{}
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Error("unexpected headers", r.Header)
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	prom := NewPrometheusRemoteWriteTarget(nil).(*prometheusRemoteWriteTarget)
+	for _, bad := range []string{"", "127.0.0.1:9090/api/v1/write", "ftp://127.0.0.1/write"} {
+		if err := prom.Init("PROM1", map[string]any{"commonConfig": map[string]any{"url": bad}}); err == nil {
+			t.Fatal("invalid url should fail:", bad)
+		}
+	}
+	if err := prom.Init("PROM1", map[string]any{
+		"commonConfig": map[string]any{"url": server.URL, "bearerToken": "token", "batchSize": 10},
+		"pointMapping": map[string]any{"measurement": "m", "timeField": "ts", "precision": "ms"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prom.Start(typex.CCTX{Ctx: ctx, CancelCTX: cancel})
+	points, _ := prom.mainConfig.PointMapping.ParsePoints(`{"ts":1700000000000,"v":2}`)
+	if err := prom.write(points); err != nil {
+		t.Fatal(err)
+	}
+	series := decodeRemoteWriteRequest(t, bodies[0])
+	if len(series) != 1 || series[0].Labels["__name__"] != "m_v" || series[0].Value != 2 ||
+		series[0].Timestamp != 1700000000000 {
+		t.Fatal("unexpected series", series)
+	}
+	status = http.StatusBadRequest
+	if err := prom.write(points); err == nil || prom.Status() != typex.SOURCE_DOWN {
+		t.Fatal("non-2xx should fail and set target down", err)
+	}
+	status = http.StatusOK
+	if err := prom.write(points); err != nil || prom.Status() != typex.SOURCE_UP {
+		t.Fatal("should recover", err)
+	}
+}
@@ -32,6 +32,11 @@ type Rhilex interface {
 	WorkInEnd(*InEnd, string) (bool, error)
 	WorkDevice(*Device, string) (bool, error)
 	//
+	// 执行任务, 数据是结构化的消息, 不用先序列化成字符串
+	//
+	WorkInEndMessage(*InEnd, *Message) (bool, error)
+	WorkDeviceMessage(*Device, *Message) (bool, error)
+	//
 	// 获取配置
 	//
 	GetConfig() *RhilexConfig
@@ -23,20 +23,35 @@ type Secret struct {
 	Value []string `ini:"value,,allowshadow"`
 }
 type RhilexConfig struct {
-	IniPath               string   `json:"-"`
-	AppId                 string   `ini:"app_id" json:"appId"`
-	MaxQueueSize          int      `ini:"max_queue_size" json:"maxQueueSize"`
-	SourceRestartInterval int      `ini:"resource_restart_interval" json:"sourceRestartInterval"`
-	GomaxProcs            int      `ini:"gomax_procs" json:"gomaxProcs"`
-	EnablePProf           bool     `ini:"enable_pprof" json:"enablePProf"`
-	EnableConsole         bool     `ini:"enable_console" json:"enableConsole"`
-	DebugMode             bool     `ini:"debug_mode" json:"appDebugMode"`
-	LogLevel              string   `ini:"log_level" json:"logLevel"`
-	LogMaxSize            int      `ini:"log_max_size" json:"logMaxSize"`
-	LogMaxBackups         int      `ini:"log_max_backups" json:"logMaxBackups"`
-	LogMaxAge             int      `ini:"log_max_age" json:"logMaxAge"`
-	LogCompress           bool     `ini:"log_compress" json:"logCompress"`
-	MaxKvStoreSize        int      `ini:"max_kv_store_size" json:"maxKvStoreSize"`
-	ExtLibs               []string `ini:"ext_libs,,allowshadow" json:"extLibs"`
-	DataSchemaSecret      []string `ini:"dataschema_secrets,,allowshadow" json:"dataSchemaSecret"`
+	IniPath                string   `json:"-"`
+	AppId                  string   `ini:"app_id" json:"appId"`
+	MaxQueueSize           int      `ini:"max_queue_size" json:"maxQueueSize"`
+	SourceRestartInterval  int      `ini:"resource_restart_interval" json:"sourceRestartInterval"`
+	GomaxProcs             int      `ini:"gomax_procs" json:"gomaxProcs"`
+	EnablePProf            bool     `ini:"enable_pprof" json:"enablePProf"`
+	EnableConsole          bool     `ini:"enable_console" json:"enableConsole"`
+	DebugMode              bool     `ini:"debug_mode" json:"appDebugMode"`
+	LogLevel               string   `ini:"log_level" json:"logLevel"`
+	LogMaxSize             int      `ini:"log_max_size" json:"logMaxSize"`
+	LogMaxBackups          int      `ini:"log_max_backups" json:"logMaxBackups"`
+	LogMaxAge              int      `ini:"log_max_age" json:"logMaxAge"`
+	LogCompress            bool     `ini:"log_compress" json:"logCompress"`
+	MaxKvStoreSize         int      `ini:"max_kv_store_size" json:"maxKvStoreSize"`
+	ExtLibs                []string `ini:"ext_libs,,allowshadow" json:"extLibs"`
+	DataSchemaSecret       []string `ini:"dataschema_secrets,,allowshadow" json:"dataSchemaSecret"`
+	RuleExecuteTimeout     int      `ini:"rule_execute_timeout" json:"ruleExecuteTimeout"`            // 单次执行超时(毫秒)
+	RuleMaxInstructions    int      `ini:"rule_max_instructions" json:"ruleMaxInstructions"`          // 单次执行最多的指令数, 0 不限制
+	RuleMaxRegistrySize    int      `ini:"rule_max_registry_size" json:"ruleMaxRegistrySize"`         // Lua 栈的最大槽位数, 不限制表和字符串占用的内存
+	RuleCallStackSize      int      `ini:"rule_call_stack_size" json:"ruleCallStackSize"`             // 最大调用深度
+	RuleSuspendThreshold   int      `ini:"rule_suspend_threshold" json:"ruleSuspendThreshold"`        // 连续超时多少次以后挂起规则, 0 不挂起
+	RuleVMPoolSize         int      `ini:"rule_vm_pool_size" json:"ruleVmPoolSize"`                   // 每个规则最多几个虚拟机并发执行
+	WindowSnapshotInterval int      `ini:"window_snapshot_interval" json:"windowSnapshotInterval"`    // 流式窗口快照间隔(毫秒)
+	CaptureMaxFileSize     int      `ini:"capture_max_file_size" json:"captureMaxFileSize"`           // 流量抓包单个文件大小(MB)
+	CaptureMaxFiles        int      `ini:"capture_max_files" json:"captureMaxFiles"`                  // 流量抓包每个资源最多保留几个文件
+	AppletTrustedKeys      []string `ini:"applet_trusted_keys,,allowshadow" json:"appletTrustedKeys"` // 验证应用包签名的公钥, base64
+	AppletRequireSignature bool     `ini:"applet_require_signature" json:"appletRequireSignature"`    // 只接受签过名的应用包
+	InQueueWorkers         int      `ini:"in_queue_workers" json:"inQueueWorkers"`                    // 输入队列并发数
+	DeviceQueueWorkers     int      `ini:"device_queue_workers" json:"deviceQueueWorkers"`            // 设备队列并发数
+	StreamQueueWorkers     int      `ini:"stream_queue_workers" json:"streamQueueWorkers"`            // 规则流队列并发数
+	OutQueueWorkers        int      `ini:"out_queue_workers" json:"outQueueWorkers"`                  // 输出队列并发数
 }
@@ -0,0 +1,423 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package typex
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+)
+
+// 数据质量
+const (
+	QUALITY_GOOD      string = "GOOD"
+	QUALITY_BAD       string = "BAD"
+	QUALITY_UNCERTAIN string = "UNCERTAIN"
+)
+
+/*
+*
+* 资源和规则之间传递的消息, 不用先序列化成 JSON 再在规则里解析,
+* Payload 直接转成 Lua 表; 二进制数据放在 Bytes 里
+*
+ */
+type Message struct {
+	ResourceId string            `json:"id"`
+	Timestamp  int64             `json:"ts"` // 毫秒
+	Quality    string            `json:"quality"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Payload    any               `json:"payload,omitempty"`
+	Bytes      []byte            `json:"bytes,omitempty"`
+	Hops       int               `json:"-"` // 经过了几次规则之间的流转发, 防止死循环
+	once       sync.Once
+	str        string
+	lazy       bool // Payload 需要从 str 解析
+	decode     sync.Once
+}
+
+func NewMessage(resourceId string, payload any) *Message {
+	return &Message{
+		ResourceId: resourceId,
+		Timestamp:  time.Now().UnixMilli(),
+		Quality:    QUALITY_GOOD,
+		Payload:    payload,
+	}
+}
+
+/*
+*
+* 老的字符串数据: 字符串规则原样拿到, 表规则拿到解析后的 JSON, 不是 JSON 就是字符串本身
+* 解析推迟到第一次转 Lua 表的时候, 全是字符串规则就不用解析
+*
+ */
+func NewStringMessage(resourceId string, data string) *Message {
+	m := NewMessage(resourceId, nil)
+	m.once.Do(func() { m.str = data })
+	m.lazy = true
+	return m
+}
+
+func NewBytesMessage(resourceId string, bytes []byte) *Message {
+	return &Message{
+		ResourceId: resourceId,
+		Timestamp:  time.Now().UnixMilli(),
+		Quality:    QUALITY_GOOD,
+		Bytes:      bytes,
+	}
+}
+
+/*
+*
+* 兼容老的字符串规则: Payload 序列化成 JSON, Bytes 原样转成字符串
+* 一条消息可能被多个规则执行, 只序列化一次
+*
+ */
+func (m *Message) String() string {
+	m.once.Do(func() {
+		if m.Bytes != nil {
+			m.str = string(m.Bytes)
+			return
+		}
+		if s, ok := m.Payload.(string); ok {
+			m.str = s
+			return
+		}
+		bytes, err := json.Marshal(m.Payload)
+		if err != nil {
+			m.str = fmt.Sprintf("%v", m.Payload)
+			return
+		}
+		m.str = string(bytes)
+	})
+	return m.str
+}
+
+/*
+*
+* 转成 Lua 表: {id=, ts=, quality=, tags={}, payload=}
+* Bytes 不为空的时候 payload 是二进制字符串
+*
+ */
+func (m *Message) ToLValue(L *lua.LState) lua.LValue {
+	table := L.CreateTable(0, 5)
+	table.RawSetString("id", lua.LString(m.ResourceId))
+	table.RawSetString("ts", lua.LNumber(m.Timestamp))
+	table.RawSetString("quality", lua.LString(m.Quality))
+	tags := L.CreateTable(0, len(m.Tags))
+	for k, v := range m.Tags {
+		tags.RawSetString(k, lua.LString(v))
+	}
+	table.RawSetString("tags", tags)
+	if m.Bytes != nil {
+		table.RawSetString("payload", lua.LString(m.Bytes))
+	} else {
+		table.RawSetString("payload", ToLValue(L, m.payload()))
+	}
+	return table
+}
+
+// 解析后的 Payload, 二进制消息返回原始字节
+func (m *Message) Data() any {
+	if m.Bytes != nil {
+		return m.Bytes
+	}
+	return m.payload()
+}
+
+func (m *Message) payload() any {
+	if !m.lazy {
+		return m.Payload
+	}
+	m.decode.Do(func() {
+		var payload any
+		if err := json.Unmarshal([]byte(m.str), &payload); err != nil {
+			payload = m.str
+		}
+		m.Payload = payload
+	})
+	return m.Payload
+}
+
+/*
+*
+* Go 的值直接转成 Lua 值, 常见的类型走快速路径, 结构体之类的走反射
+*
+ */
+func ToLValue(L *lua.LState, value any) lua.LValue {
+	switch T := value.(type) {
+	case nil:
+		return lua.LNil
+	case lua.LValue:
+		return T
+	case bool:
+		return lua.LBool(T)
+	case string:
+		return lua.LString(T)
+	case []byte:
+		return lua.LString(T)
+	case int:
+		return lua.LNumber(T)
+	case int8:
+		return lua.LNumber(T)
+	case int16:
+		return lua.LNumber(T)
+	case int32:
+		return lua.LNumber(T)
+	case int64:
+		return lua.LNumber(T)
+	case uint:
+		return lua.LNumber(T)
+	case uint8:
+		return lua.LNumber(T)
+	case uint16:
+		return lua.LNumber(T)
+	case uint32:
+		return lua.LNumber(T)
+	case uint64:
+		return lua.LNumber(T)
+	case float32:
+		return lua.LNumber(T)
+	case float64:
+		return lua.LNumber(T)
+	case json.Number:
+		return lua.LString(T)
+	case map[string]any:
+		table := L.CreateTable(0, len(T))
+		for k, v := range T {
+			table.RawSetString(k, ToLValue(L, v))
+		}
+		return table
+	case map[string]string:
+		table := L.CreateTable(0, len(T))
+		for k, v := range T {
+			table.RawSetString(k, lua.LString(v))
+		}
+		return table
+	case []any:
+		table := L.CreateTable(len(T), 0)
+		for _, v := range T {
+			table.Append(ToLValue(L, v))
+		}
+		return table
+	case []map[string]any:
+		table := L.CreateTable(len(T), 0)
+		for _, v := range T {
+			table.Append(ToLValue(L, v))
+		}
+		return table
+	}
+	return reflectToLValue(L, reflect.ValueOf(value))
+}
+
+var __jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+
+func reflectToLValue(L *lua.LState, value reflect.Value) lua.LValue {
+	// 自己实现了 MarshalJSON 的类型(比如 time.Time)按 JSON 的结果转
+	if value.IsValid() && value.Kind() != reflect.Interface &&
+		value.Type().Implements(__jsonMarshalerType) && value.CanInterface() {
+		if value.Kind() == reflect.Pointer && value.IsNil() {
+			return lua.LNil
+		}
+		return marshalerToLValue(L, value.Interface().(json.Marshaler))
+	}
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			return lua.LNil
+		}
+		return reflectToLValue(L, value.Elem())
+	case reflect.Slice, reflect.Array:
+		table := L.CreateTable(value.Len(), 0)
+		for i := 0; i < value.Len(); i++ {
+			table.Append(ToLValue(L, value.Index(i).Interface()))
+		}
+		return table
+	case reflect.Map:
+		table := L.CreateTable(0, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			table.RawSetString(fmt.Sprintf("%v", iter.Key().Interface()),
+				ToLValue(L, iter.Value().Interface()))
+		}
+		return table
+	case reflect.Struct:
+		// 和 JSON 的字段保持一致, 老规则里的字段名不用改
+		fields := structFields(value.Type())
+		table := L.CreateTable(0, len(fields))
+		for _, field := range fields {
+			fieldValue, err := value.FieldByIndexErr(field.index)
+			if err != nil || !fieldValue.CanInterface() { // 嵌入的指针是 nil
+				continue
+			}
+			if field.omitEmpty && isEmptyValue(fieldValue) {
+				continue
+			}
+			table.RawSetString(field.name, ToLValue(L, fieldValue.Interface()))
+		}
+		return table
+	case reflect.Bool:
+		return lua.LBool(value.Bool())
+	case reflect.String:
+		return lua.LString(value.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return lua.LNumber(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return lua.LNumber(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return lua.LNumber(value.Float())
+	}
+	return lua.LNil
+}
+
+func marshalerToLValue(L *lua.LState, marshaler json.Marshaler) lua.LValue {
+	bytes, err := marshaler.MarshalJSON()
+	if err != nil {
+		return lua.LNil
+	}
+	var value any
+	if err := json.Unmarshal(bytes, &value); err != nil {
+		return lua.LNil
+	}
+	return ToLValue(L, value)
+}
+
+/*
+*
+* 结构体转 Lua 表用到的字段, 规则和 encoding/json 一样:
+* 字段名用 json 标签, 没有标签的嵌入结构体把字段提升上来,
+* 同名字段浅的优先, 一样深的时候有标签的优先, 还分不出来就都不要
+*
+ */
+type luaStructField struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+}
+
+var __structFieldsCache sync.Map // reflect.Type -> []luaStructField
+
+func structFields(t reflect.Type) []luaStructField {
+	if cached, ok := __structFieldsCache.Load(t); ok {
+		return cached.([]luaStructField)
+	}
+	candidates := []luaStructField{}
+	collectStructFields(t, nil, map[reflect.Type]bool{}, &candidates)
+	byName := map[string][]luaStructField{}
+	names := []string{}
+	for _, field := range candidates {
+		if _, ok := byName[field.name]; !ok {
+			names = append(names, field.name)
+		}
+		byName[field.name] = append(byName[field.name], field)
+	}
+	fields := []luaStructField{}
+	for _, name := range names {
+		if field, ok := dominantField(byName[name]); ok {
+			fields = append(fields, field)
+		}
+	}
+	__structFieldsCache.Store(t, fields)
+	return fields
+}
+
+func collectStructFields(t reflect.Type, index []int, visited map[reflect.Type]bool,
+	fields *[]luaStructField) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	defer delete(visited, t)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous {
+			if !field.IsExported() && fieldType.Kind() != reflect.Struct {
+				continue
+			}
+		} else if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int{}, index...), i)
+		if name == "" && field.Anonymous && fieldType.Kind() == reflect.Struct {
+			collectStructFields(fieldType, fieldIndex, visited, fields)
+			continue
+		}
+		tagged := name != ""
+		if !tagged {
+			name = field.Name
+		}
+		*fields = append(*fields, luaStructField{
+			name:      name,
+			index:     fieldIndex,
+			tagged:    tagged,
+			omitEmpty: strings.Contains(","+options+",", ",omitempty,"),
+		})
+	}
+}
+
+func dominantField(fields []luaStructField) (luaStructField, bool) {
+	depth := len(fields[0].index)
+	for _, field := range fields {
+		depth = min(depth, len(field.index))
+	}
+	shallowest := []luaStructField{}
+	for _, field := range fields {
+		if len(field.index) == depth {
+			shallowest = append(shallowest, field)
+		}
+	}
+	if len(shallowest) == 1 {
+		return shallowest[0], true
+	}
+	tagged := []luaStructField{}
+	for _, field := range shallowest {
+		if field.tagged {
+			tagged = append(tagged, field)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return luaStructField{}, false
+}
+
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return value.IsZero()
+	}
+	return false
+}
@@ -16,6 +16,8 @@
 package typex
 
 import (
+	"sync"
+
 	lua "github.com/hootrhino/gopher-lua"
 )
 
@@ -31,20 +33,94 @@ const _VM_Registry_GrowStep int = 32         // 默认CPU消耗
 const RULE_STOP RuleStatus = 0
 const RULE_RUNNING RuleStatus = 1
 
+// 规则类型: Lua 脚本, 或者声明式的表达式规则
+const (
+	RULE_TYPE_LUA  string = "lua"
+	RULE_TYPE_EXPR string = "expr"
+)
+
+/*
+*
+* 声明式规则编译出来的处理流程, 不需要 Lua 虚拟机
+*
+ */
+type RulePipeline interface {
+	Execute(msg *Message) error
+}
+
 // 规则描述
 type Rule struct {
-	Id          string      `json:"id"`
-	UUID        string      `json:"uuid"`
-	Type        string      `json:"type"` // 脚本类型，目前支持"lua"
-	Status      RuleStatus  `json:"status"`
-	Name        string      `json:"name"`
-	FromSource  string      `json:"fromSource"` // 来自数据源
-	FromDevice  string      `json:"fromDevice"` // 来自设备
-	Actions     string      `json:"actions"`
-	Success     string      `json:"success"`
-	Failed      string      `json:"failed"`
-	Description string      `json:"description"`
-	LuaVM       *lua.LState `json:"-"` // Lua VM
+	Id          string       `json:"id"`
+	UUID        string       `json:"uuid"`
+	Type        string       `json:"type"` // 规则类型: lua, expr
+	Status      RuleStatus   `json:"status"`
+	Name        string       `json:"name"`
+	FromSource  string       `json:"fromSource"` // 来自数据源
+	FromDevice  string       `json:"fromDevice"` // 来自设备
+	FromStream  string       `json:"fromStream"` // 订阅其他规则发布的流
+	Actions     string       `json:"actions"`
+	Success     string       `json:"success"`
+	Failed      string       `json:"failed"`
+	Description string       `json:"description"`
+	LuaVM       *lua.LState  `json:"-"` // Lua VM
+	Runtime     *RuleRuntime `json:"-"` // 运行时状态
+	VMPool      *RuleVMPool  `json:"-"` // 并发执行用的虚拟机池, LuaVM 是其中的第一个
+	Pipeline    RulePipeline `json:"-"` // 声明式规则的处理流程, 加载的时候编译
+}
+
+/*
+*
+* 规则运行时状态: 规则绑定到资源的时候是值拷贝, 所以用指针在拷贝之间共享
+*
+ */
+type RuleRuntime struct {
+	locker              sync.Mutex
+	consecutiveTimeouts int
+	suspended           bool
+	suspendReason       string
+}
+
+// 规则是否被挂起
+func (r *RuleRuntime) Suspended() bool {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.suspended
+}
+
+// 挂起原因
+func (r *RuleRuntime) SuspendReason() string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.suspendReason
+}
+
+// 执行超时, 连续超时 threshold 次以后挂起规则, 刚被挂起的时候返回 true
+func (r *RuleRuntime) OnTimeout(threshold int, reason string) bool {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.consecutiveTimeouts++
+	if threshold > 0 && r.consecutiveTimeouts >= threshold && !r.suspended {
+		r.suspended = true
+		r.suspendReason = reason
+		return true
+	}
+	return false
+}
+
+// 执行完成(不管成功失败, 只要不是超时)就清零
+func (r *RuleRuntime) OnFinished() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.consecutiveTimeouts = 0
+}
+
+// 恢复执行
+func (r *RuleRuntime) Resume() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.consecutiveTimeouts = 0
+	r.suspended = false
+	r.suspendReason = ""
 }
 
 func NewLuaRule(e Rhilex,
@@ -68,6 +144,48 @@ func NewLuaRule(e Rhilex,
 	return rule
 }
 
+/*
+*
+* 声明式规则: Actions 里是 JSON/YAML 定义, 不创建虚拟机
+*
+ */
+func NewExprRule(e Rhilex,
+	uuid string,
+	name string,
+	description string,
+	fromSource string,
+	fromDevice string,
+	definition string) *Rule {
+	return &Rule{
+		UUID:        uuid,
+		Name:        name,
+		Type:        RULE_TYPE_EXPR,
+		Description: description,
+		FromSource:  fromSource,
+		FromDevice:  fromDevice,
+		Status:      RULE_RUNNING,
+		Actions:     definition,
+		Runtime:     &RuleRuntime{},
+	}
+}
+
+// 按类型新建规则, 类型为空的老规则都是 Lua
+func NewTypedRule(e Rhilex,
+	ruleType string,
+	uuid string,
+	name string,
+	description string,
+	fromSource string,
+	fromDevice string,
+	success string,
+	actions string,
+	failed string) *Rule {
+	if ruleType == RULE_TYPE_EXPR {
+		return NewExprRule(e, uuid, name, description, fromSource, fromDevice, actions)
+	}
+	return NewLuaRule(e, uuid, name, description, fromSource, fromDevice, success, actions, failed)
+}
+
 // New
 func NewRule(e Rhilex,
 	uuid string,
@@ -78,10 +196,17 @@ func NewRule(e Rhilex,
 	success string,
 	actions string,
 	failed string) *Rule {
+	LuaVM := NewRuleLState(e)
+	poolSize := 1
+	if e != nil {
+		if config := e.GetConfig(); config != nil && config.RuleVMPoolSize > 0 {
+			poolSize = config.RuleVMPoolSize
+		}
+	}
 	return &Rule{
 		UUID:        uuid,
 		Name:        name,
-		Type:        "lua", // 默认执行lua脚本
+		Type:        RULE_TYPE_LUA, // 默认执行lua脚本
 		Description: description,
 		FromSource:  fromSource,
 		FromDevice:  fromDevice,
@@ -89,12 +214,134 @@ func NewRule(e Rhilex,
 		Actions:     actions,
 		Success:     success,
 		Failed:      failed,
-		LuaVM: lua.NewState(lua.Options{
-			// IncludeGoStackTrace: true,
-			RegistrySize:     _VM_Registry_Size,
-			RegistryMaxSize:  _VM_Registry_MaxSize,
-			RegistryGrowStep: _VM_Registry_GrowStep,
-		}),
+		LuaVM:       LuaVM,
+		Runtime:     &RuleRuntime{},
+		VMPool:      NewRuleVMPool(LuaVM, poolSize),
+	}
+}
+
+// 按照全局配置的限制创建规则虚拟机
+func NewRuleLState(e Rhilex) *lua.LState {
+	registryMaxSize := _VM_Registry_MaxSize
+	callStackSize := lua.CallStackSize
+	if e != nil {
+		if config := e.GetConfig(); config != nil {
+			if config.RuleMaxRegistrySize > 0 {
+				registryMaxSize = config.RuleMaxRegistrySize
+			}
+			if config.RuleCallStackSize > 0 {
+				callStackSize = config.RuleCallStackSize
+			}
+		}
+	}
+	return lua.NewState(lua.Options{
+		// IncludeGoStackTrace: true,
+		CallStackSize:    callStackSize,
+		RegistrySize:     min(_VM_Registry_Size, registryMaxSize),
+		RegistryMaxSize:  registryMaxSize,
+		RegistryGrowStep: _VM_Registry_GrowStep,
+	})
+}
+
+/*
+*
+* 规则虚拟机池: LState 不是线程安全的, 同一个规则要被多个资源并发执行的时候
+* 从池子里借一个虚拟机, 不够了就用 factory 新建, 到上限以后排队等待
+* 删除规则的时候 Close 掉, 还借在外面的虚拟机归还的时候关闭
+*
+ */
+type RuleVMPool struct {
+	locker  sync.Mutex
+	idle    chan *lua.LState
+	done    chan struct{}
+	closed  bool
+	size    int
+	max     int
+	factory func() (*lua.LState, error)
+}
+
+func NewRuleVMPool(first *lua.LState, max int) *RuleVMPool {
+	if max < 1 {
+		max = 1
+	}
+	pool := &RuleVMPool{
+		idle: make(chan *lua.LState, max),
+		done: make(chan struct{}),
+		size: 1,
+		max:  max,
+	}
+	pool.idle <- first
+	return pool
+}
+
+// 设置新建虚拟机的方法, 没有设置的时候池子里只有一个虚拟机
+func (p *RuleVMPool) SetFactory(factory func() (*lua.LState, error)) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.factory = factory
+}
+
+// 池子关闭以后返回 nil
+func (p *RuleVMPool) Acquire() *lua.LState {
+	select {
+	case vm := <-p.idle:
+		return vm
+	default:
+	}
+	p.locker.Lock()
+	if p.closed {
+		p.locker.Unlock()
+		return nil
+	}
+	if p.factory != nil && p.size < p.max {
+		p.size++
+		factory := p.factory
+		p.locker.Unlock()
+		vm, err := factory()
+		if err == nil {
+			return vm
+		}
+		p.locker.Lock()
+		p.size--
+	}
+	p.locker.Unlock()
+	select {
+	case vm := <-p.idle:
+		return vm
+	case <-p.done:
+		return nil
+	}
+}
+
+func (p *RuleVMPool) Release(vm *lua.LState) {
+	if vm == nil {
+		return
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.closed {
+		vm.Close()
+		return
+	}
+	p.idle <- vm
+}
+
+// 关闭池子里所有的虚拟机, 包括规则的 LuaVM
+func (p *RuleVMPool) Close() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+	for {
+		select {
+		case vm := <-p.idle:
+			vm.Close()
+		default:
+			return
+		}
 	}
 }
 
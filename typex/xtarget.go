@@ -15,6 +15,8 @@
 
 package typex
 
+import "time"
+
 // TargetType
 type TargetType string
 
@@ -28,19 +30,33 @@ func (i TargetType) String() string {
 *
  */
 const (
-	MONGO_SINGLE          TargetType = "MONGO_SINGLE"          // To MongoDB
-	MQTT_TARGET           TargetType = "MQTT"                  // To Mqtt Server
-	HTTP_TARGET           TargetType = "HTTP"                  // To Http Target
-	TDENGINE_TARGET       TargetType = "TDENGINE"              // To TDENGINE
-	GRPC_CODEC_TARGET     TargetType = "GRPC_CODEC_TARGET"     // To GRPC Target
-	RHILEX_GRPC_TARGET    TargetType = "RHILEX_GRPC_TARGET"    // To GRPC Target
-	UDP_TARGET            TargetType = "UDP_TARGET"            // To UDP Server
-	GENERIC_UART_TARGET   TargetType = "GENERIC_UART_TARGET"   // To GENERIC_UART_TARGET DTU
-	TCP_TRANSPORT         TargetType = "TCP_TRANSPORT"         // To TCP Transport
-	SEMTECH_UDP_FORWARDER TargetType = "SEMTECH_UDP_FORWARDER" // To Chirp stack UDP
-	GREPTIME_DATABASE     TargetType = "GREPTIME_DATABASE"     // To GREPTIME DATABASE
+	MONGO_SINGLE          TargetType = "MONGO_SINGLE"            // To MongoDB
+	MQTT_TARGET           TargetType = "MQTT"                    // To Mqtt Server
+	HTTP_TARGET           TargetType = "HTTP"                    // To Http Target
+	TDENGINE_TARGET       TargetType = "TDENGINE"                // To TDENGINE
+	GRPC_CODEC_TARGET     TargetType = "GRPC_CODEC_TARGET"       // To GRPC Target
+	RHILEX_GRPC_TARGET    TargetType = "RHILEX_GRPC_TARGET"      // To GRPC Target
+	UDP_TARGET            TargetType = "UDP_TARGET"              // To UDP Server
+	GENERIC_UART_TARGET   TargetType = "GENERIC_UART_TARGET"     // To GENERIC_UART_TARGET DTU
+	TCP_TRANSPORT         TargetType = "TCP_TRANSPORT"           // To TCP Transport
+	SEMTECH_UDP_FORWARDER TargetType = "SEMTECH_UDP_FORWARDER"   // To Chirp stack UDP
+	GREPTIME_DATABASE     TargetType = "GREPTIME_DATABASE"       // To GREPTIME DATABASE
+	INFLUXDB_TARGET       TargetType = "INFLUXDB"                // To InfluxDB v1/v2
+	PROMETHEUS_RW_TARGET  TargetType = "PROMETHEUS_REMOTE_WRITE" // To Prometheus Remote Write
+	SQL_DATABASE          TargetType = "SQL_DATABASE"            // To PostgreSQL/MySQL/SQLite
+	SPARKPLUG_EDGE_NODE   TargetType = "SPARKPLUG_EDGE_NODE"     // Sparkplug B Edge Node
 )
 
+/*
+*
+* 可选的确认送达出口: 等对端确认了才返回, 超时算失败; 失败不进离线缓存.
+* 给数据中心同步这种自己记断点, 自己重试的调用方用
+*
+ */
+type XAckTarget interface {
+	ToAcked(data any, timeout time.Duration) error
+}
+
 // Stream from source and to target
 type XTarget interface {
 	//
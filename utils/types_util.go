@@ -15,7 +15,12 @@
 
 package utils
 
-import "reflect"
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
 
 // IsArrayAndGetTypeList 检查给定的 any 是否为数组，并返回其元素类型列表。
 func IsArrayAndGetValueList(i any) ([]any, bool) {
@@ -33,3 +38,20 @@ func IsArrayAndGetValueList(i any) ([]any, bool) {
 	}
 	return values, true
 }
+
+// ParsePointValue 点位表里的值大多是字符串, 能转成数字和布尔的先转;
+// NaN 和 Inf 转成 JSON 会失败, 还按字符串
+func ParsePointValue(value any) any {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	s = strings.TrimSpace(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		return b
+	}
+	return s
+}
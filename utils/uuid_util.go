@@ -60,6 +60,9 @@ func RuleUuid() string {
 }
 
 // MakeUUID
+func RuleFixtureUuid() string {
+	return MakeUUID("FIXTURE")
+}
 func UserLuaUuid() string {
 	return MakeUUID("USERLUA")
 }